@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	geo "github.com/nci/geometry"
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/metrics"
+	"github.com/nci/gsky/tokens"
+	"github.com/nci/gsky/urlsign"
+	"github.com/nci/gsky/utils"
+)
+
+// authoriseOWS identifies the user of an OWS request by its API token
+// or with the access control of the root config and returns the request
+// carrying its access.
+func authoriseOWS(w http.ResponseWriter, r *http.Request, confMap map[string]*utils.Config, namespace string) (*http.Request, bool) {
+	var ac *utils.AccessControl
+	if rootConfig := confMap["."]; rootConfig != nil {
+		ac = rootConfig.ServiceConfig.AccessControl
+	}
+
+	if urlSigner != nil && urlsign.IsSigned(r.URL) {
+		access, ok := authoriseSigned(w, r, ac, namespace)
+		if !ok || access == nil {
+			return r, ok
+		}
+		return r.WithContext(utils.NewAccessContext(r.Context(), access)), true
+	}
+
+	if key := tokens.FromRequest(r); tokenStore != nil && tokens.IsToken(key) {
+		access, ok := authoriseToken(w, key, ac, namespace)
+		if !ok {
+			return r, false
+		}
+		return r.WithContext(utils.NewAccessContext(r.Context(), access)), true
+	}
+
+	if ac == nil {
+		return r, true
+	}
+	access, err := ac.Authorise(r, namespace)
+	if err != nil {
+		writeAccessError(w, err)
+		return r, false
+	}
+	return r.WithContext(utils.NewAccessContext(r.Context(), access)), true
+}
+
+// authoriseToken validates an API token and applies its rate limit.
+func authoriseToken(w http.ResponseWriter, key string, ac *utils.AccessControl, namespace string) (*utils.Access, bool) {
+	token, err := tokenStore.Validate(key)
+	if err != nil {
+		writeAccessError(w, utils.NewAccessError(true, "%v", err))
+		return nil, false
+	}
+	return tokenAccess(w, token, ac, namespace)
+}
+
+// tokenAccess applies the rate limit of a validated token and returns
+// its access.
+func tokenAccess(w http.ResponseWriter, token *tokens.Token, ac *utils.AccessControl, namespace string) (*utils.Access, bool) {
+	if ok, wait := tokenStore.Allow(token); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, fmt.Sprintf("rate limit of token %s exceeded", token.ID), http.StatusTooManyRequests)
+		return nil, false
+	}
+	access, err := utils.TokenAccess(ac, token, namespace)
+	if err != nil {
+		writeAccessError(w, err)
+		return nil, false
+	}
+	return access, true
+}
+
+func writeAccessError(w http.ResponseWriter, err error) {
+	status := http.StatusForbidden
+	if ae, ok := err.(*utils.AccessError); ok {
+		status = ae.StatusCode()
+	}
+	if status == http.StatusUnauthorized {
+		w.Header().Add("WWW-Authenticate", `ApiKey realm="gsky", header="`+utils.APIKeyHeader+`", param="`+utils.APIKeyParam+`"`)
+		if tokenStore != nil {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="gsky"`)
+		}
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// checkAccess writes the error response if the operation on the layer
+// or process is denied to the user of the request.
+func checkAccess(ctx context.Context, w http.ResponseWriter, layer, operation string, scope *utils.AccessScope, metricsCollector *metrics.MetricsCollector) bool {
+	err := utils.AccessFromContext(ctx).Check(layer, operation, scope)
+	if err == nil {
+		return true
+	}
+	logging.FromContext(ctx).Warnf("%v", err)
+	metricsCollector.Info.HTTPStatus = err.(*utils.AccessError).StatusCode()
+	writeAccessError(w, err)
+	return false
+}
+
+// accessScope returns the extent of a request in the given CRS. The
+// bbox is unknown if it cannot be transformed.
+func accessScope(crs *string, bbox []float64, start, end *time.Time) *utils.AccessScope {
+	scope := &utils.AccessScope{Start: start, End: end}
+	if crs != nil && len(bbox) == 4 {
+		if canonical, err := utils.GetCanonicalBbox(*crs, bbox); err == nil {
+			scope.BBox = canonical
+		}
+	}
+	return scope
+}
+
+// wmsBBox returns the bbox of a WMS request in the x, y order.
+func wmsBBox(params utils.WMSParams) []float64 {
+	bbox := params.BBox
+	if len(bbox) == 4 && params.CRS != nil && params.Version != nil &&
+		strings.ToUpper(*params.CRS) == "EPSG:4326" && *params.Version == "1.3.0" {
+		bbox = []float64{bbox[1], bbox[0], bbox[3], bbox[2]}
+	}
+	return bbox
+}
+
+// wpsAccessScope returns the extent of the geometry and the time range
+// of a WPS Execute request.
+func wpsAccessScope(params utils.WPSParams, geom geo.Geometry) *utils.AccessScope {
+	parseTime := func(value *string) *time.Time {
+		if value == nil {
+			return nil
+		}
+		t, err := time.Parse(utils.ISOFormat, *value)
+		if err != nil {
+			return nil
+		}
+		return &t
+	}
+
+	var points []geo.Point
+	switch geom := geom.(type) {
+	case *geo.Point:
+		points = append(points, *geom)
+	case *geo.Polygon:
+		for _, ring := range *geom {
+			points = append(points, ring...)
+		}
+	case *geo.MultiPolygon:
+		for _, poly := range *geom {
+			for _, ring := range poly {
+				points = append(points, ring...)
+			}
+		}
+	}
+
+	var bbox []float64
+	if len(points) > 0 {
+		bbox = []float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		for _, p := range points {
+			bbox[0] = math.Min(bbox[0], p.X)
+			bbox[1] = math.Min(bbox[1], p.Y)
+			bbox[2] = math.Max(bbox[2], p.X)
+			bbox[3] = math.Max(bbox[3], p.Y)
+		}
+	}
+	crs := "EPSG:4326"
+	return accessScope(&crs, bbox, parseTime(params.StartDateTime), parseTime(params.EndDateTime))
+}
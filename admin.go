@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/tokens"
+	"github.com/nci/gsky/utils"
+)
+
+// adminAuthorised checks the bearer token of an admin request. Browsers
+// may instead send the token as the password of HTTP basic auth, which
+// any user name is accepted with. The admin endpoints are disabled if no
+// admin token is configured.
+func adminAuthorised(w http.ResponseWriter, r *http.Request) bool {
+	if len(*adminToken) == 0 {
+		http.Error(w, "admin endpoints are disabled", http.StatusNotFound)
+		return false
+	}
+
+	var token string
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if strings.HasPrefix(auth, prefix) {
+		token = strings.TrimPrefix(auth, prefix)
+	} else if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	if len(token) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) != 1 {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="gsky admin"`)
+		w.Header().Add("WWW-Authenticate", `Basic realm="gsky admin"`)
+		http.Error(w, "unauthorised", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// adminAuthor returns the author of an admin operation given by the
+// author query parameter or the X-Gsky-Author header.
+func adminAuthor(r *http.Request) string {
+	if author := r.FormValue("author"); len(author) > 0 {
+		return author
+	}
+	if author := r.Header.Get("X-Gsky-Author"); len(author) > 0 {
+		return author
+	}
+	return "admin"
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// configHistoryHandler lists the configurations applied by the server.
+func configHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, utils.ConfigVersions.List())
+}
+
+// configRollbackHandler makes a previous version of the configuration
+// active again, e.g. POST /admin/config/rollback?version=3
+func configRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid version: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	author := adminAuthor(r)
+	v, err := utils.RollbackConfig(configMap, version, author)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	Info.Printf("Config rolled back to version %d by %s", version, author)
+	writeAdminJSON(w, http.StatusOK, v)
+}
+
+// effectiveConfigHandler returns the fully resolved configuration in
+// effect, optionally restricted to a namespace, e.g.
+// GET /admin/config/effective?namespace=sentinel2
+func effectiveConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := utils.DumpEffectiveConfig(getConfigMap(), r.FormValue("namespace"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// configStageHandler manages the candidate config staged side by side
+// with the live one:
+// POST /admin/config/stage?dir=/etc/gsky/green&check_mas=true loads
+// and validates a candidate, GET returns its status and DELETE
+// discards it.
+func configStageHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		staged, found := utils.StagedConfigs.Status()
+		if !found {
+			http.Error(w, "no staged config", http.StatusNotFound)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, staged)
+
+	case http.MethodPost:
+		dir := r.FormValue("dir")
+		if len(dir) == 0 {
+			dir = *stagingConfigDir
+		}
+		if len(dir) == 0 {
+			http.Error(w, "dir parameter required", http.StatusBadRequest)
+			return
+		}
+		checkMAS, _ := strconv.ParseBool(r.FormValue("check_mas"))
+
+		author := adminAuthor(r)
+		staged, err := utils.StagedConfigs.Stage(dir, author, checkMAS, *verbose)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		Info.Printf("Config staged from %s by %s with %d issues", staged.Dir, author, len(staged.Issues))
+		writeAdminJSON(w, http.StatusOK, staged)
+
+	case http.MethodDelete:
+		if !utils.StagedConfigs.Discard() {
+			http.Error(w, "no staged config", http.StatusNotFound)
+			return
+		}
+		Info.Printf("Staged config discarded by %s", adminAuthor(r))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// configPromoteHandler switches the live config to the staged one,
+// e.g. POST /admin/config/promote. A candidate with validation issues
+// requires force=true.
+func configPromoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	force, _ := strconv.ParseBool(r.FormValue("force"))
+	author := adminAuthor(r)
+	v, err := utils.StagedConfigs.Promote(configMap, author, force)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	Info.Printf("Staged config promoted to version %d by %s", v.Version, author)
+	writeAdminJSON(w, http.StatusOK, v)
+}
+
+// statusHandler serves the status page of the server showing the health
+// of the layers, MAS and the workers, the cache statistics and the
+// recent errors, e.g. GET /admin/status?check_mas=true&format=json
+// The MAS checks of the layers are slow for large configs and are only
+// run if check_mas is set.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	checkMAS, _ := strconv.ParseBool(r.FormValue("check_mas"))
+	report := utils.NewStatusReport(getConfigMap(), checkMAS, utils.DefaultStatusTimeout)
+	if cache := getTileCache(); cache != nil {
+		stats := cache.Stats()
+		report.DiskCache = &stats
+	}
+	if masFailover != nil {
+		report.MASFailover = masFailover.Status()
+	}
+	if r.FormValue("format") == "json" {
+		writeAdminJSON(w, http.StatusOK, report)
+		return
+	}
+
+	tplFile, err := fileResolver.Lookup("templates/admin_status.tpl")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tpl, err := template.ParseFiles(tplFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err = tpl.Execute(w, report); err != nil {
+		Error.Printf("admin status page: %v", err)
+	}
+}
+
+// tokenRequest is the body of a token issuance: the scope of the token
+// and its expiry given as a time or a duration from now, e.g.
+// {"name": "portal", "layers": ["chirps_*"], "operations": ["view"], "ttl": "720h"}
+type tokenRequest struct {
+	tokens.Token
+	TTL string `json:"ttl"`
+}
+
+// tokenResponse returns the secret of a token issued, which is only
+// returned once.
+type tokenResponse struct {
+	Secret string `json:"token"`
+	*tokens.Token
+}
+
+// tokensHandler manages the scoped API tokens: POST /admin/tokens
+// issues a token, GET lists the tokens and DELETE /admin/tokens?id=...
+// revokes one.
+func tokensHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+	if tokenStore == nil {
+		http.Error(w, "tokens are disabled, see -token_file", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := tokenStore.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, list)
+
+	case http.MethodPost:
+		var req tokenRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid token request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(req.TTL) > 0 {
+			ttl, err := time.ParseDuration(req.TTL)
+			if err != nil || ttl <= 0 {
+				http.Error(w, fmt.Sprintf("invalid ttl: %v", req.TTL), http.StatusBadRequest)
+				return
+			}
+			expires := time.Now().UTC().Add(ttl)
+			req.Expires = &expires
+		}
+		if len(req.User) > 0 {
+			var ac *utils.AccessControl
+			if rootConfig := getConfigMap()["."]; rootConfig != nil {
+				ac = rootConfig.ServiceConfig.AccessControl
+			}
+			if !ac.HasUser(req.User) {
+				http.Error(w, fmt.Sprintf("access control user not found: %v", req.User), http.StatusBadRequest)
+				return
+			}
+		}
+
+		author := adminAuthor(r)
+		secret, token, err := tokenStore.Issue(req.Token, author)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		Info.Printf("Token %s (%s) issued by %s", token.ID, token.Name, author)
+		w.Header().Set("Cache-Control", "no-store")
+		writeAdminJSON(w, http.StatusCreated, tokenResponse{Secret: secret, Token: token})
+
+	case http.MethodDelete:
+		author := adminAuthor(r)
+		token, err := tokenStore.Revoke(r.FormValue("id"), author)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		Info.Printf("Token %s (%s) revoked by %s", token.ID, token.Name, author)
+		writeAdminJSON(w, http.StatusOK, token)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
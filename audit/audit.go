@@ -0,0 +1,156 @@
+// Package audit records the audit trail of the data requests served by
+// OWS, i.e. who downloaded or processed which layer with which
+// parameters, how many bytes were returned and the outcome. The records
+// are appended to a JSON lines file or inserted into a PostgreSQL table.
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// Record is an audited request.
+type Record struct {
+	Time       time.Time         `json:"time"`
+	RequestID  string            `json:"request_id,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Token      string            `json:"token,omitempty"`
+	RemoteAddr string            `json:"remote_addr"`
+	PeerAddr   string            `json:"peer_addr,omitempty"`
+	NameSpace  string            `json:"namespace"`
+	Service    string            `json:"service"`
+	Operation  string            `json:"operation"`
+	Layer      string            `json:"layer,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	Bytes      int64             `json:"bytes"`
+	Status     int               `json:"status"`
+	DurationMS int64             `json:"duration_ms"`
+}
+
+// secretParams are the query parameters never recorded, the API key
+// and the signature of the signed URLs.
+var secretParams = map[string]bool{"api_key": true, "signature": true}
+
+// Params returns the query parameters of a request to record.
+func Params(query map[string][]string) map[string]string {
+	params := make(map[string]string, len(query))
+	for key, values := range query {
+		if secretParams[strings.ToLower(key)] {
+			continue
+		}
+		params[key] = strings.Join(values, ",")
+	}
+	return params
+}
+
+// Log is an audit trail destination.
+type Log interface {
+	Write(rec *Record) error
+	Close() error
+}
+
+// Open opens the audit trail given as a postgres:// or postgresql://
+// URL, or as a file path otherwise.
+func Open(dest string) (Log, error) {
+	if strings.HasPrefix(dest, "postgres://") || strings.HasPrefix(dest, "postgresql://") {
+		return openDB(dest)
+	}
+	return openFile(dest)
+}
+
+// fileLog appends the records as JSON lines to a file only ever opened
+// for appending.
+type fileLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openFile(path string) (*fileLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLog{file: file}, nil
+}
+
+func (l *fileLog) Write(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(data)
+	return err
+}
+
+func (l *fileLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+const createTable = `create table if not exists gsky_audit (
+	id bigserial primary key,
+	time timestamptz not null,
+	request_id text,
+	username text,
+	token text,
+	remote_addr text,
+	peer_addr text,
+	namespace text,
+	service text,
+	operation text,
+	layer text,
+	params jsonb,
+	bytes bigint,
+	status integer,
+	duration_ms bigint
+)`
+
+// addPeerAddr adds the peer_addr column to the tables created before it.
+const addPeerAddr = `alter table gsky_audit add column if not exists peer_addr text`
+
+// dbLog inserts the records into the gsky_audit table, which is created
+// if it does not exist.
+type dbLog struct {
+	db *sql.DB
+}
+
+func openDB(url string) (*dbLog, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{createTable, addPeerAddr} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("audit table: %v", err)
+		}
+	}
+	return &dbLog{db: db}, nil
+}
+
+func (l *dbLog) Write(rec *Record) error {
+	params, err := json.Marshal(rec.Params)
+	if err != nil {
+		return err
+	}
+	_, err = l.db.Exec(`insert into gsky_audit (time, request_id, username, token, remote_addr, peer_addr, namespace, service, operation, layer, params, bytes, status, duration_ms)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		rec.Time, rec.RequestID, rec.User, rec.Token, rec.RemoteAddr, rec.PeerAddr, rec.NameSpace, rec.Service, rec.Operation, rec.Layer, string(params), rec.Bytes, rec.Status, rec.DurationMS)
+	return err
+}
+
+func (l *dbLog) Close() error {
+	return l.db.Close()
+}
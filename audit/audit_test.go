@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	write := func(layer string) {
+		l, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		rec := &Record{
+			Time:       time.Now(),
+			User:       "kenya",
+			RemoteAddr: "196.201.4.7",
+			PeerAddr:   "10.0.3.18:51922",
+			NameSpace:  "licensed",
+			Service:    "WCS",
+			Operation:  "GetCoverage",
+			Layer:      layer,
+			Params:     Params(map[string][]string{"coverage": {layer}, "api_key": {"secret"}, "bbox": {"33", "-5", "42", "5"}}),
+			Bytes:      1024,
+			Status:     200,
+		}
+		if err := l.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("rainfall_daily")
+	write("rainfall_monthly")
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 2 || records[0].Layer != "rainfall_daily" || records[1].Layer != "rainfall_monthly" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if _, found := records[0].Params["api_key"]; found {
+		t.Errorf("API key recorded")
+	}
+	if records[0].Params["bbox"] != "33,-5,42,5" || records[0].Bytes != 1024 ||
+		records[0].RemoteAddr != "196.201.4.7" || records[0].PeerAddr != "10.0.3.18:51922" {
+		t.Errorf("unexpected record: %+v", records[0])
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nci/gsky/seeding"
+	"github.com/nci/gsky/utils"
+)
+
+// benchWorkloads are the synthetic workloads replayed by `gsky bench`.
+var benchWorkloads = []string{"tiles", "wcs", "drill"}
+
+// benchResult is the performance of a workload, as written to the
+// report files compared across releases.
+type benchResult struct {
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	Bytes      int64   `json:"bytes"`
+	Throughput float64 `json:"throughput"`
+	P50MS      float64 `json:"p50_ms"`
+	P90MS      float64 `json:"p90_ms"`
+	P99MS      float64 `json:"p99_ms"`
+	MaxMS      float64 `json:"max_ms"`
+
+	latencies []time.Duration
+}
+
+// benchReport is the outcome of a benchmark run.
+type benchReport struct {
+	URL         string                  `json:"url"`
+	Layer       string                  `json:"layer"`
+	Version     string                  `json:"version"`
+	Started     time.Time               `json:"started"`
+	Duration    float64                 `json:"duration_seconds"`
+	Concurrency int                     `json:"concurrency"`
+	Workloads   map[string]*benchResult `json:"workloads"`
+}
+
+// bench replays a mix of synthetic requests against a running OWS.
+type bench struct {
+	url         string
+	layer       string
+	time        string
+	process     string
+	apiKey      string
+	bbox        []float64
+	minZoom     int
+	maxZoom     int
+	wcsSize     int
+	client      *http.Client
+	weights     map[string]int
+	totalWeight int
+
+	mu     sync.Mutex
+	rnd    *rand.Rand
+	report benchReport
+}
+
+// runBench implements the `gsky bench` subcommand. The workload mix is
+// replayed by concurrent clients for a duration or a number of requests
+// and the latency percentiles and throughput of each workload are
+// printed. The exit status is 1 if a workload regressed against the
+// baseline report.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	baseURL := fs.String("url", "http://localhost:8080/ows", "OWS endpoint of the instance benchmarked, including the namespace if any.")
+	layer := fs.String("layer", "", "Name of the layer requested.")
+	timestamp := fs.String("time", "", "Time of the requests. Defaults to the current time of the layer.")
+	process := fs.String("process", "geometryDrill", "Identifier of the WPS process of the drills.")
+	apiKey := fs.String("api_key", "", "API key or token of the requests if access control is enabled.")
+	workload := fs.String("workload", "tiles:8,wcs:1,drill:1", "Comma separated workloads with their relative weights: tiles, wcs and drill.")
+	bboxParam := fs.String("bbox", "-180,-90,180,90", "EPSG:4326 extent of the requests: minx,miny,maxx,maxy.")
+	zoom := fs.String("zoom", "3-8", "Range of the zoom levels of the tile storm.")
+	wcsSize := fs.Int("wcs_size", 512, "Width and height of the WCS extracts.")
+	concurrency := fs.Int("concurrency", 8, "Number of concurrent clients.")
+	duration := fs.Duration("duration", time.Minute, "Duration of the run.")
+	requests := fs.Int("requests", 0, "Number of requests of the run. Overrides -duration if positive.")
+	timeout := fs.Duration("timeout", 60*time.Second, "Timeout of each request.")
+	seed := fs.Int64("seed", 1, "Seed of the random requests, so that runs are comparable.")
+	reportFile := fs.String("report", "", "JSON file receiving the report of the run.")
+	baselineFile := fs.String("baseline", "", "JSON report of a previous run compared to this one.")
+	maxRegression := fs.Float64("max_regression", 20, "Maximum increase in percent of the p90 latency of a workload over the baseline.")
+	fs.Parse(args)
+
+	b := &bench{
+		url:     *baseURL,
+		layer:   *layer,
+		time:    *timestamp,
+		process: *process,
+		apiKey:  *apiKey,
+		wcsSize: *wcsSize,
+		client:  &http.Client{Timeout: *timeout},
+		rnd:     rand.New(rand.NewSource(*seed)),
+	}
+	if err := b.parseFlags(*workload, *bboxParam, *zoom); err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+		os.Exit(2)
+	}
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+
+	b.report = benchReport{URL: b.url, Layer: b.layer, Version: utils.GSKYVersion, Started: time.Now(), Concurrency: *concurrency, Workloads: make(map[string]*benchResult)}
+	for name := range b.weights {
+		b.report.Workloads[name] = &benchResult{}
+	}
+	b.run(*concurrency, *duration, *requests)
+	b.print(os.Stdout)
+
+	if len(*reportFile) > 0 {
+		if err := writeBenchReport(*reportFile, &b.report); err != nil {
+			fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if len(*baselineFile) > 0 {
+		baseline, err := readBenchReport(*baselineFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+			os.Exit(2)
+		}
+		if !compareBench(os.Stdout, baseline, &b.report, *maxRegression) {
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
+func (b *bench) parseFlags(workload, bbox, zoom string) error {
+	if len(b.layer) == 0 {
+		return fmt.Errorf("-layer is required")
+	}
+
+	b.weights = make(map[string]int)
+	for _, w := range strings.Split(workload, ",") {
+		parts := strings.SplitN(strings.TrimSpace(w), ":", 2)
+		weight := 1
+		if len(parts) == 2 {
+			var err error
+			if weight, err = strconv.Atoi(parts[1]); err != nil || weight < 0 {
+				return fmt.Errorf("invalid weight of workload %s", parts[0])
+			}
+		}
+		if !benchWorkload(parts[0]) {
+			return fmt.Errorf("unknown workload %q, must be one of %s", parts[0], strings.Join(benchWorkloads, ", "))
+		}
+		if weight > 0 {
+			b.weights[parts[0]] = weight
+			b.totalWeight += weight
+		}
+	}
+	if b.totalWeight == 0 {
+		return fmt.Errorf("empty workload")
+	}
+
+	for _, v := range strings.Split(bbox, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return fmt.Errorf("invalid bbox: %v", err)
+		}
+		b.bbox = append(b.bbox, f)
+	}
+	if len(b.bbox) != 4 || b.bbox[0] >= b.bbox[2] || b.bbox[1] >= b.bbox[3] {
+		return fmt.Errorf("invalid bbox %s", bbox)
+	}
+
+	zooms := strings.SplitN(zoom, "-", 2)
+	var err error
+	if b.minZoom, err = strconv.Atoi(zooms[0]); err != nil {
+		return fmt.Errorf("invalid zoom %s", zoom)
+	}
+	b.maxZoom = b.minZoom
+	if len(zooms) == 2 {
+		if b.maxZoom, err = strconv.Atoi(zooms[1]); err != nil {
+			return fmt.Errorf("invalid zoom %s", zoom)
+		}
+	}
+	if b.minZoom < 0 || b.maxZoom > 22 || b.minZoom > b.maxZoom {
+		return fmt.Errorf("invalid zoom %s", zoom)
+	}
+	return nil
+}
+
+func benchWorkload(name string) bool {
+	for _, w := range benchWorkloads {
+		if w == name {
+			return true
+		}
+	}
+	return false
+}
+
+// run starts the clients and waits for the end of the run.
+func (b *bench) run(concurrency int, duration time.Duration, requests int) {
+	deadline := time.Now().Add(duration)
+	var issued int64
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				b.mu.Lock()
+				if (requests > 0 && issued >= int64(requests)) || (requests <= 0 && time.Now().After(deadline)) {
+					b.mu.Unlock()
+					return
+				}
+				issued++
+				name := b.pick()
+				req, err := b.newRequest(name)
+				b.mu.Unlock()
+
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+					return
+				}
+				b.do(name, req)
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(b.report.Started)
+	b.report.Duration = elapsed.Seconds()
+	for _, res := range b.report.Workloads {
+		res.summarise(elapsed)
+	}
+}
+
+// pick draws a workload by weight. b.mu must be held.
+func (b *bench) pick() string {
+	n := b.rnd.Intn(b.totalWeight)
+	for _, name := range benchWorkloads {
+		if w := b.weights[name]; w > 0 {
+			if n -= w; n < 0 {
+				return name
+			}
+		}
+	}
+	return benchWorkloads[0]
+}
+
+// newRequest draws a random request of the workload. b.mu must be held.
+func (b *bench) newRequest(name string) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	switch name {
+	case "tiles":
+		req, err = http.NewRequest("GET", b.url+"?"+b.tileParams().Encode(), nil)
+	case "wcs":
+		req, err = http.NewRequest("GET", b.url+"?"+b.wcsParams().Encode(), nil)
+	case "drill":
+		req, err = http.NewRequest("POST", b.url+"?service=WPS&request=Execute", strings.NewReader(b.drillPayload()))
+		if err == nil {
+			req.Header.Set("Content-Type", "text/xml")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b.apiKey) > 0 {
+		req.Header.Set(utils.APIKeyHeader, b.apiKey)
+	}
+	return req, nil
+}
+
+// tileParams returns the GetMap of a random tile of the bbox.
+func (b *bench) tileParams() url.Values {
+	z := b.minZoom + b.rnd.Intn(b.maxZoom-b.minZoom+1)
+	x0, y1 := seeding.LonLatToTile(b.bbox[0], b.bbox[1], z)
+	x1, y0 := seeding.LonLatToTile(b.bbox[2], b.bbox[3], z)
+	x := x0 + b.rnd.Intn(x1-x0+1)
+	y := y0 + b.rnd.Intn(y1-y0+1)
+
+	bbox := seeding.TileBBox(z, x, y)
+	params := url.Values{
+		"service": {"WMS"},
+		"request": {"GetMap"},
+		"version": {"1.3.0"},
+		"layers":  {b.layer},
+		"styles":  {""},
+		"crs":     {"EPSG:3857"},
+		"bbox":    {fmt.Sprintf("%f,%f,%f,%f", bbox[0], bbox[1], bbox[2], bbox[3])},
+		"width":   {"256"},
+		"height":  {"256"},
+		"format":  {"image/png"},
+	}
+	if len(b.time) > 0 {
+		params.Set("time", b.time)
+	}
+	return params
+}
+
+// randomBox returns a random box of the bbox whose size is between the
+// fractions min and max of the size of the bbox.
+func (b *bench) randomBox(min, max float64) []float64 {
+	f := min + b.rnd.Float64()*(max-min)
+	w, h := (b.bbox[2]-b.bbox[0])*f, (b.bbox[3]-b.bbox[1])*f
+	x := b.bbox[0] + b.rnd.Float64()*(b.bbox[2]-b.bbox[0]-w)
+	y := b.bbox[1] + b.rnd.Float64()*(b.bbox[3]-b.bbox[1]-h)
+	return []float64{x, y, x + w, y + h}
+}
+
+func (b *bench) wcsParams() url.Values {
+	box := b.randomBox(0.05, 0.25)
+	params := url.Values{
+		"service":  {"WCS"},
+		"request":  {"GetCoverage"},
+		"version":  {"1.0.0"},
+		"coverage": {b.layer},
+		"crs":      {"EPSG:4326"},
+		"bbox":     {fmt.Sprintf("%f,%f,%f,%f", box[0], box[1], box[2], box[3])},
+		"width":    {strconv.Itoa(b.wcsSize)},
+		"height":   {strconv.Itoa(b.wcsSize)},
+		"format":   {"GeoTIFF"},
+	}
+	if len(b.time) > 0 {
+		params.Set("time", b.time)
+	}
+	return params
+}
+
+// drillPayload returns the WPS Execute of a drill over a random polygon
+// of the bbox.
+func (b *bench) drillPayload() string {
+	box := b.randomBox(0.01, 0.05)
+	geometry := fmt.Sprintf(`{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[%[1]f,%[2]f],[%[3]f,%[2]f],[%[3]f,%[4]f],[%[1]f,%[4]f],[%[1]f,%[2]f]]]}}]}`, box[0], box[1], box[2], box[3])
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<wps:Execute version="1.0.0" service="WPS" xmlns="http://www.opengis.net/wps/1.0.0" xmlns:wps="http://www.opengis.net/wps/1.0.0" xmlns:ows="http://www.opengis.net/ows/1.1">
+  <ows:Identifier>%s</ows:Identifier>
+  <wps:DataInputs>
+    <wps:Input>
+      <ows:Identifier>geometry</ows:Identifier>
+      <wps:Data><wps:ComplexData>%s</wps:ComplexData></wps:Data>
+    </wps:Input>
+  </wps:DataInputs>
+</wps:Execute>`, b.process, geometry)
+}
+
+// do sends a request and records its outcome.
+func (b *bench) do(name string, req *http.Request) {
+	t0 := time.Now()
+	resp, err := b.client.Do(req)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s", resp.Status)
+		}
+	}
+	latency := time.Since(t0)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := b.report.Workloads[name]
+	res.Requests++
+	res.Bytes += n
+	if err != nil {
+		res.Errors++
+		return
+	}
+	res.latencies = append(res.latencies, latency)
+}
+
+// summarise computes the percentiles of the successful requests.
+func (r *benchResult) summarise(elapsed time.Duration) {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	if elapsed > 0 {
+		r.Throughput = float64(r.Requests-r.Errors) / elapsed.Seconds()
+	}
+	if len(r.latencies) == 0 {
+		return
+	}
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(r.latencies)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return ms(r.latencies[i])
+	}
+	r.P50MS = percentile(0.5)
+	r.P90MS = percentile(0.9)
+	r.P99MS = percentile(0.99)
+	r.MaxMS = ms(r.latencies[len(r.latencies)-1])
+}
+
+func (r *benchResult) errorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+func (b *bench) print(w io.Writer) {
+	fmt.Fprintf(w, "%s layer %s, %d clients, %.1fs\n", b.report.URL, b.report.Layer, b.report.Concurrency, b.report.Duration)
+	fmt.Fprintf(w, "%-8s %8s %7s %10s %9s %9s %9s %9s %12s\n", "workload", "requests", "errors", "req/s", "p50 ms", "p90 ms", "p99 ms", "max ms", "MB")
+	for _, name := range benchWorkloads {
+		if r, found := b.report.Workloads[name]; found {
+			fmt.Fprintf(w, "%-8s %8d %7d %10.2f %9.1f %9.1f %9.1f %9.1f %12.1f\n", name, r.Requests, r.Errors, r.Throughput, r.P50MS, r.P90MS, r.P99MS, r.MaxMS, float64(r.Bytes)/(1<<20))
+		}
+	}
+}
+
+func writeBenchReport(path string, report *benchReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func readBenchReport(path string) (*benchReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report benchReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid report %s: %v", path, err)
+	}
+	return &report, nil
+}
+
+// compareBench prints the change of the p90 latency and throughput of
+// the workloads of both reports. It returns false if the p90 latency of
+// a workload increased by more than maxRegression percent or if its
+// error rate increased.
+func compareBench(w io.Writer, baseline, report *benchReport, maxRegression float64) bool {
+	passed := true
+	fmt.Fprintf(w, "\ncompared to %s (%s):\n", baseline.Started.Format(time.RFC3339), baseline.Version)
+	for _, name := range benchWorkloads {
+		base, found := baseline.Workloads[name]
+		r, found2 := report.Workloads[name]
+		if !found || !found2 || base.P90MS == 0 {
+			continue
+		}
+		p90 := (r.P90MS - base.P90MS) / base.P90MS * 100
+		throughput := 0.0
+		if base.Throughput > 0 {
+			throughput = (r.Throughput - base.Throughput) / base.Throughput * 100
+		}
+		status := "ok"
+		if p90 > maxRegression || r.errorRate() > base.errorRate() {
+			status = "REGRESSION"
+			passed = false
+		}
+		fmt.Fprintf(w, "%-8s p90 %+7.1f%%  req/s %+7.1f%%  errors %.1f%% -> %.1f%%  %s\n", name, p90, throughput, base.errorRate()*100, r.errorRate()*100, status)
+	}
+	return passed
+}
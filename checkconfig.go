@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nci/gsky/utils"
+)
+
+// runCheckConfig implements the `gsky checkconfig` subcommand. All the
+// config files are parsed and checked, and the issues found are printed
+// to stderr. The exit status is 1 if any config has an issue.
+func runCheckConfig(args []string) {
+	fs := flag.NewFlagSet("checkconfig", flag.ExitOnError)
+	noMAS := fs.Bool("no_mas", false, "Skip looking up data sources and bands in MAS.")
+	fs.Parse(args)
+
+	confMap, err := utils.LoadAllConfigFilesForCheck(utils.EtcDir, *verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error in loading config files: %v\n", err)
+		os.Exit(1)
+	}
+
+	checker := utils.NewConfigChecker(!*noMAS)
+	issues := checker.CheckConfig(confMap)
+	for _, issue := range issues {
+		fmt.Fprintln(os.Stderr, issue)
+	}
+
+	nLayers := 0
+	for _, conf := range confMap {
+		if conf != nil {
+			nLayers += len(conf.Layers)
+		}
+	}
+
+	if len(issues) > 0 {
+		fmt.Fprintf(os.Stderr, "%d issues found in %d namespaces, %d layers\n", len(issues), len(confMap), nLayers)
+		os.Exit(1)
+	}
+	fmt.Printf("OK: %d namespaces, %d layers\n", len(confMap), nLayers)
+	os.Exit(0)
+}
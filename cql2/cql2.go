@@ -0,0 +1,430 @@
+// Package cql2 parses the filters of OGC CQL2 text expressions, e.g.
+// sensor = 'MODIS' AND cloud_cover < 20, and translates them into the
+// SQL/JSON path predicates of Postgres, evaluated against the JSON
+// metadata of the files with jsonb_path_exists. The path is passed to
+// Postgres as a parameter, never as SQL.
+//
+// The comparisons, LIKE, IN, BETWEEN, IS NULL, AND, OR and NOT are
+// supported, of a property and literals: strings, numbers, booleans and
+// TIMESTAMP or DATE literals, compared as their RFC 3339 strings. The
+// properties are paths of the JSON documents, their keys separated by
+// dots, e.g. geo_metadata.namespace.
+package cql2
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxDepth bounds the nesting of the expressions.
+const maxDepth = 32
+
+// Error is an invalid expression.
+type Error struct {
+	Offset  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Message, e.Offset)
+}
+
+// JSONPath returns the SQL/JSON path of the documents matching the CQL2
+// text expression of filter.
+func JSONPath(filter string) (string, error) {
+	p := &parser{lexer: lexer{src: filter}}
+	p.next()
+	var b strings.Builder
+	b.WriteString("$ ? (")
+	if err := p.expr(&b, 0); err != nil {
+		return "", err
+	}
+	if p.err != nil || p.tok.kind != eof {
+		return "", p.errorf("unexpected %s", p.tok)
+	}
+	b.WriteByte(')')
+	return b.String(), nil
+}
+
+type tokenKind int
+
+const (
+	eof tokenKind = iota
+	ident
+	keyword
+	str
+	number
+	operator
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case eof:
+		return "end of expression"
+	case str:
+		return fmt.Sprintf("string '%s'", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "LIKE": true, "IN": true, "BETWEEN": true,
+	"IS": true, "NULL": true, "TRUE": true, "FALSE": true, "TIMESTAMP": true, "DATE": true,
+}
+
+// comparisons are the SQL/JSON path operators of the comparisons.
+var comparisons = map[string]string{"=": "==", "<>": "!=", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) scan() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.src) {
+		return token{kind: eof, offset: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case c == '\'':
+		var b strings.Builder
+		for l.pos++; ; l.pos++ {
+			if l.pos == len(l.src) {
+				return token{}, &Error{start, "unterminated string"}
+			}
+			if l.src[l.pos] == '\'' {
+				// Quotes are doubled in the strings.
+				if l.pos+1 < len(l.src) && l.src[l.pos+1] == '\'' {
+					b.WriteByte('\'')
+					l.pos++
+					continue
+				}
+				l.pos++
+				return token{kind: str, text: b.String(), offset: start}, nil
+			}
+			b.WriteByte(l.src[l.pos])
+		}
+	case c == '"':
+		end := strings.IndexByte(l.src[l.pos+1:], '"')
+		if end < 0 {
+			return token{}, &Error{start, "unterminated property name"}
+		}
+		l.pos += end + 2
+		return token{kind: ident, text: l.src[start+1 : l.pos-1], offset: start}, nil
+	case c >= '0' && c <= '9' || c == '-' || c == '.':
+		for l.pos++; l.pos < len(l.src) && strings.IndexByte("0123456789.eE+-", l.src[l.pos]) >= 0; l.pos++ {
+			if (l.src[l.pos] == '+' || l.src[l.pos] == '-') && l.src[l.pos-1] != 'e' && l.src[l.pos-1] != 'E' {
+				break
+			}
+		}
+		text := l.src[start:l.pos]
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return token{}, &Error{start, fmt.Sprintf("invalid number %s", text)}
+		}
+		return token{kind: number, text: text, offset: start}, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos++; l.pos < len(l.src); l.pos++ {
+			c := rune(l.src[l.pos])
+			if c != '_' && c != '.' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				break
+			}
+		}
+		text := l.src[start:l.pos]
+		if keywords[strings.ToUpper(text)] {
+			return token{kind: keyword, text: strings.ToUpper(text), offset: start}, nil
+		}
+		return token{kind: ident, text: text, offset: start}, nil
+	}
+
+	for _, op := range []string{"<>", "<=", ">=", "=", "<", ">", "(", ")", ","} {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: operator, text: op, offset: start}, nil
+		}
+	}
+	return token{}, &Error{start, fmt.Sprintf("unexpected character %q", c)}
+}
+
+type parser struct {
+	lexer
+	tok token
+	err error
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.scan()
+	if p.err != nil {
+		p.tok = token{kind: eof, offset: p.pos}
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return &Error{p.tok.offset, fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) is(kind tokenKind, text string) bool {
+	return p.tok.kind == kind && p.tok.text == text
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if !p.is(kind, text) {
+		return p.errorf("expected %s, got %s", text, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+// expr parses a disjunction of conjunctions.
+func (p *parser) expr(b *strings.Builder, depth int) error {
+	if depth > maxDepth {
+		return p.errorf("expression nested too deeply")
+	}
+	return p.binary(b, "OR", "||", func(b *strings.Builder) error {
+		return p.binary(b, "AND", "&&", func(b *strings.Builder) error {
+			return p.unary(b, depth)
+		})
+	})
+}
+
+func (p *parser) binary(b *strings.Builder, op, pathOp string, operand func(*strings.Builder) error) error {
+	var first strings.Builder
+	if err := operand(&first); err != nil {
+		return err
+	}
+	if !p.is(keyword, op) {
+		b.WriteString(first.String())
+		return nil
+	}
+	b.WriteByte('(')
+	b.WriteString(first.String())
+	for p.is(keyword, op) {
+		p.next()
+		b.WriteString(" " + pathOp + " ")
+		if err := operand(b); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(')')
+	return nil
+}
+
+func (p *parser) unary(b *strings.Builder, depth int) error {
+	switch {
+	case p.is(keyword, "NOT"):
+		p.next()
+		b.WriteString("!(")
+		if err := p.unary(b, depth+1); err != nil {
+			return err
+		}
+		b.WriteByte(')')
+		return nil
+	case p.is(operator, "("):
+		p.next()
+		b.WriteByte('(')
+		if err := p.expr(b, depth+1); err != nil {
+			return err
+		}
+		b.WriteByte(')')
+		return p.expect(operator, ")")
+	}
+	return p.predicate(b)
+}
+
+// predicate parses a predicate of a property.
+func (p *parser) predicate(b *strings.Builder) error {
+	if p.tok.kind != ident {
+		return p.errorf("expected a property, got %s", p.tok)
+	}
+	path, err := propertyPath(p.tok)
+	if err != nil {
+		return err
+	}
+	p.next()
+
+	not := false
+	if p.is(keyword, "NOT") {
+		not = true
+		p.next()
+	}
+	var pred strings.Builder
+	switch {
+	case p.tok.kind == operator && !not && len(comparisons[p.tok.text]) > 0:
+		op := comparisons[p.tok.text]
+		p.next()
+		value, err := p.literal()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s %s %s", path, op, value)
+		return nil
+
+	case p.is(keyword, "LIKE"):
+		p.next()
+		if p.tok.kind != str {
+			return p.errorf("expected a pattern, got %s", p.tok)
+		}
+		fmt.Fprintf(&pred, "%s like_regex %s", path, quote(likeRegex(p.tok.text)))
+		p.next()
+
+	case p.is(keyword, "IN"):
+		p.next()
+		if err := p.expect(operator, "("); err != nil {
+			return err
+		}
+		pred.WriteByte('(')
+		for i := 0; ; i++ {
+			value, err := p.literal()
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				pred.WriteString(" || ")
+			}
+			fmt.Fprintf(&pred, "%s == %s", path, value)
+			if !p.is(operator, ",") {
+				break
+			}
+			p.next()
+		}
+		pred.WriteByte(')')
+		if err := p.expect(operator, ")"); err != nil {
+			return err
+		}
+
+	case p.is(keyword, "BETWEEN"):
+		p.next()
+		low, err := p.literal()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(keyword, "AND"); err != nil {
+			return err
+		}
+		high, err := p.literal()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&pred, "(%s >= %s && %s <= %s)", path, low, path, high)
+
+	case p.is(keyword, "IS") && !not:
+		p.next()
+		if p.is(keyword, "NOT") {
+			not = true
+			p.next()
+		}
+		if err := p.expect(keyword, "NULL"); err != nil {
+			return err
+		}
+		// The missing properties are null, as JSON nulls.
+		exists := fmt.Sprintf("exists(%s ? (@ != null))", path)
+		if not {
+			b.WriteString(exists)
+		} else {
+			b.WriteString("!(" + exists + ")")
+		}
+		return nil
+
+	default:
+		return p.errorf("expected a comparison, LIKE, IN, BETWEEN or IS NULL, got %s", p.tok)
+	}
+
+	if not {
+		b.WriteString("!(" + pred.String() + ")")
+	} else {
+		b.WriteString(pred.String())
+	}
+	return nil
+}
+
+// literal parses a literal, returned as a SQL/JSON path literal.
+func (p *parser) literal() (string, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == str:
+		p.next()
+		return quote(tok.text), nil
+	case tok.kind == number:
+		p.next()
+		return tok.text, nil
+	case p.is(keyword, "TRUE"), p.is(keyword, "FALSE"):
+		p.next()
+		return strings.ToLower(tok.text), nil
+	case p.is(keyword, "TIMESTAMP"), p.is(keyword, "DATE"):
+		p.next()
+		if err := p.expect(operator, "("); err != nil {
+			return "", err
+		}
+		if p.tok.kind != str {
+			return "", p.errorf("expected a %s string, got %s", strings.ToLower(tok.text), p.tok)
+		}
+		value := p.tok.text
+		p.next()
+		if err := p.expect(operator, ")"); err != nil {
+			return "", err
+		}
+		return quote(value), nil
+	}
+	return "", p.errorf("expected a literal, got %s", p.tok)
+}
+
+// propertyPath returns the SQL/JSON path of a property from the current
+// item, each key quoted.
+func propertyPath(tok token) (string, error) {
+	var b strings.Builder
+	b.WriteByte('@')
+	for _, key := range strings.Split(tok.text, ".") {
+		if len(key) == 0 {
+			return "", &Error{tok.offset, fmt.Sprintf("invalid property %s", tok.text)}
+		}
+		b.WriteByte('.')
+		b.WriteString(quote(key))
+	}
+	return b.String(), nil
+}
+
+// quote returns the SQL/JSON path string of s, whose escapes are those
+// of JSON.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// likeRegex returns the regular expression of a LIKE pattern, % matching
+// any characters and _ any character.
+func likeRegex(pattern string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
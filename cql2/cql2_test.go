@@ -0,0 +1,56 @@
+package cql2
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	for filter, path := range map[string]string{
+		"sensor = 'MODIS'":                             `$ ? (@."sensor" == "MODIS")`,
+		"cloud_cover < 20 AND level >= 2":              `$ ? ((@."cloud_cover" < 20 && @."level" >= 2))`,
+		"a = 1 or b <> 'x' and c = true":               `$ ? ((@."a" == 1 || (@."b" != "x" && @."c" == true)))`,
+		"NOT (a = 1 OR a = 2)":                         `$ ? (!(((@."a" == 1 || @."a" == 2))))`,
+		"geo_metadata.namespace IN ('precip', 'tmax')": `$ ? ((@."geo_metadata"."namespace" == "precip" || @."geo_metadata"."namespace" == "tmax"))`,
+		"name NOT LIKE 'chirps_%.tif'":                 `$ ? (!(@."name" like_regex "^chirps..*\\.tif$"))`,
+		"\"processing level\" BETWEEN 1 AND 3":         `$ ? ((@."processing level" >= 1 && @."processing level" <= 3))`,
+		"product IS NULL":                              `$ ? (!(exists(@."product" ? (@ != null))))`,
+		"product IS NOT NULL":                          `$ ? (exists(@."product" ? (@ != null)))`,
+		"created > TIMESTAMP('2020-01-01T00:00:00Z')":  `$ ? (@."created" > "2020-01-01T00:00:00Z")`,
+		"title = 'it''s \"quoted\"'":                   `$ ? (@."title" == "it's \"quoted\"")`,
+		"x = -1.5e3":                                   `$ ? (@."x" == -1.5e3)`,
+	} {
+		got, err := JSONPath(filter)
+		if err != nil {
+			t.Errorf("%s: %v", filter, err)
+			continue
+		}
+		if got != path {
+			t.Errorf("%s: expected %s, got %s", filter, path, got)
+		}
+	}
+}
+
+func TestJSONPathErrors(t *testing.T) {
+	for filter, message := range map[string]string{
+		"":                    "expected a property, got end of expression at offset 0",
+		"a = ":                "expected a literal, got end of expression at offset 4",
+		"a = 'x":              "unterminated string at offset 4",
+		"a = 1 b = 2":         `unexpected "b" at offset 6`,
+		"a ~ 1":               `unexpected character '~' at offset 2`,
+		"1 = a":               `expected a property, got "1" at offset 0`,
+		"a LIKE 1":            `expected a pattern, got "1" at offset 7`,
+		"a IN (1, 2":          "expected ), got end of expression at offset 10",
+		"(a = 1":              "expected ), got end of expression at offset 6",
+		"a..b = 1":            "invalid property a..b at offset 0",
+		"a = 1; drop table x": `unexpected character ';' at offset 5`,
+		strings.Repeat("(", 40) + "a = 1" + strings.Repeat(")", 40): "expression nested too deeply at offset 33",
+	} {
+		_, err := JSONPath(filter)
+		if err == nil {
+			t.Errorf("%s: expected an error", filter)
+		} else if err.Error() != message {
+			t.Errorf("%s: expected %q, got %q", filter, message, err)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// checkpointEvery is the number of files between the checkpoints.
+	checkpointEvery = 100
+	// maxErrorPaths bounds the paths of the latest failures kept in the
+	// checkpoints.
+	maxErrorPaths = 100
+)
+
+// checkpoint is the state of a crawl of a file list, the files being
+// processed in order.
+type checkpoint struct {
+	// Processed is the number of files processed, from the first file
+	// of the list, LastPath being the last one.
+	Processed  int       `json:"processed"`
+	LastPath   string    `json:"last_path"`
+	Records    int       `json:"records"`
+	Errors     int       `json:"errors"`
+	ErrorPaths []string  `json:"error_paths,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// crawlStatus is the progress report of a crawl.
+type crawlStatus struct {
+	Processed   int       `json:"processed"`
+	Total       int       `json:"total"`
+	Records     int       `json:"records"`
+	Errors      int       `json:"errors"`
+	FilesPerSec float64   `json:"files_per_sec"`
+	ETASeconds  int       `json:"eta_seconds"`
+	Updated     time.Time `json:"updated"`
+	// CurrentPath is the next file of the crawl in order, that being
+	// extracted, and Started the start of the crawl.
+	CurrentPath string    `json:"current_path,omitempty"`
+	Started     time.Time `json:"started"`
+}
+
+// loadCheckpoint returns the checkpoint of a file.
+func loadCheckpoint(file string) (*checkpoint, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %v", file, err)
+	}
+	return cp, nil
+}
+
+// resumeIndex returns the index of the first file of pathList after the
+// checkpoint, the last path of the checkpoint being looked up if the
+// file list changed before it.
+func resumeIndex(pathList []string, cp *checkpoint) (int, error) {
+	if cp.Processed == 0 {
+		return 0, nil
+	}
+	if cp.Processed <= len(pathList) && pathList[cp.Processed-1] == cp.LastPath {
+		return cp.Processed, nil
+	}
+	for i, path := range pathList {
+		if path == cp.LastPath {
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("last path %s of the checkpoint not in the file list", cp.LastPath)
+}
+
+// writeJSON writes the JSON of v to a file atomically, for the readers
+// and the resumed crawls not to read partial files.
+func writeJSON(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// crawlProgress writes the records of the files of a crawl in order,
+// checkpointing the crawl to checkpointFile and reporting its progress
+// to stderr and statusFile, if not empty.
+type crawlProgress struct {
+	checkpointFile string
+	statusFile     string
+	total          int
+	// out receives the records, os.Stdout.
+	out io.Writer
+	// quarantine is the report of the files whose extraction failed, if
+	// any, their quarantine records being written in place of theirs.
+	quarantine *quarantineReport
+
+	mu      sync.Mutex
+	cp      checkpoint
+	resumed int
+	started time.Time
+	current string
+}
+
+// newCrawlProgress returns the progress of a crawl of total files,
+// resumed from cp if not nil.
+func newCrawlProgress(total int, cp *checkpoint, checkpointFile, statusFile string) *crawlProgress {
+	p := &crawlProgress{checkpointFile: checkpointFile, statusFile: statusFile, total: total, out: os.Stdout, started: time.Now()}
+	if cp != nil {
+		p.cp = *cp
+		p.resumed = cp.Processed
+	}
+	return p
+}
+
+// write writes the record of a file, or its error, in the order of the
+// files.
+func (p *crawlProgress) write(path, rec string, err error) {
+	if err == nil {
+		fmt.Fprint(p.out, rec)
+	} else {
+		os.Stderr.Write([]byte(err.Error()))
+		if p.quarantine != nil {
+			fmt.Fprint(p.out, p.quarantine.add(path, err))
+		}
+	}
+
+	p.done(path, err)
+}
+
+// done counts a file processed, checkpointing the crawl every
+// checkpointEvery files.
+func (p *crawlProgress) done(path string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cp.Processed++
+	p.cp.LastPath = path
+	if p.current == path {
+		p.current = ""
+	}
+	if err == nil {
+		p.cp.Records++
+	} else {
+		p.cp.Errors++
+		p.cp.ErrorPaths = append(p.cp.ErrorPaths, path)
+		if len(p.cp.ErrorPaths) > maxErrorPaths {
+			p.cp.ErrorPaths = p.cp.ErrorPaths[len(p.cp.ErrorPaths)-maxErrorPaths:]
+		}
+	}
+	if (p.cp.Processed-p.resumed)%checkpointEvery == 0 {
+		p.saveCheckpoint()
+	}
+}
+
+// setCurrent sets the file being extracted, the next of the crawl.
+func (p *crawlProgress) setCurrent(path string) {
+	p.mu.Lock()
+	p.current = path
+	p.mu.Unlock()
+}
+
+// add adds files to the crawl, e.g. those arrived of -watch.
+func (p *crawlProgress) add(n int) {
+	p.mu.Lock()
+	p.total += n
+	p.mu.Unlock()
+}
+
+func (p *crawlProgress) saveCheckpoint() {
+	if len(p.checkpointFile) == 0 {
+		return
+	}
+	p.cp.Updated = time.Now().UTC()
+	if err := writeJSON(p.checkpointFile, &p.cp); err != nil {
+		log.Printf("crawl checkpoint %s failed: %v", p.checkpointFile, err)
+	}
+}
+
+// status returns the progress of the crawl, the rate being of the files
+// processed since started.
+func (p *crawlProgress) status() *crawlStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &crawlStatus{Processed: p.cp.Processed, Total: p.total, Records: p.cp.Records, Errors: p.cp.Errors, Updated: time.Now().UTC(), CurrentPath: p.current, Started: p.started.UTC()}
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		s.FilesPerSec = float64(p.cp.Processed-p.resumed) / elapsed
+	}
+	if s.FilesPerSec > 0 {
+		s.ETASeconds = int(float64(p.total-p.cp.Processed) / s.FilesPerSec)
+	}
+	return s
+}
+
+func (p *crawlProgress) report() {
+	s := p.status()
+	log.Printf("crawl progress: %d of %d files, %d errors, %.1f files/s, ETA %v", s.Processed, s.Total, s.Errors, s.FilesPerSec, time.Duration(s.ETASeconds)*time.Second)
+	if len(p.statusFile) > 0 {
+		if err := writeJSON(p.statusFile, s); err != nil {
+			log.Printf("crawl status %s failed: %v", p.statusFile, err)
+		}
+	}
+}
+
+// start reports the progress of the crawl every interval until ctx is
+// done.
+func (p *crawlProgress) start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.report()
+			}
+		}
+	}()
+}
+
+// finish checkpoints the crawl once all its files are processed, and
+// reports its progress.
+func (p *crawlProgress) finish(report bool) {
+	p.mu.Lock()
+	p.saveCheckpoint()
+	p.mu.Unlock()
+	if report {
+		p.report()
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeIndex(t *testing.T) {
+	pathList := []string{"/g/data/a.nc", "/g/data/b.nc", "/g/data/c.nc", "/g/data/d.nc"}
+	tests := []struct {
+		name  string
+		paths []string
+		cp    checkpoint
+		index int
+		isErr bool
+	}{
+		{name: "new crawl", paths: pathList, cp: checkpoint{}, index: 0},
+		{name: "same list", paths: pathList, cp: checkpoint{Processed: 2, LastPath: "/g/data/b.nc"}, index: 2},
+		{name: "all processed", paths: pathList, cp: checkpoint{Processed: 4, LastPath: "/g/data/d.nc"}, index: 4},
+		{name: "files added before", paths: append([]string{"/g/data/0.nc"}, pathList...), cp: checkpoint{Processed: 2, LastPath: "/g/data/b.nc"}, index: 3},
+		{name: "files removed before", paths: pathList[1:], cp: checkpoint{Processed: 2, LastPath: "/g/data/b.nc"}, index: 1},
+		{name: "shorter list", paths: pathList[:3], cp: checkpoint{Processed: 4, LastPath: "/g/data/c.nc"}, index: 3},
+		{name: "last path removed", paths: pathList, cp: checkpoint{Processed: 2, LastPath: "/g/data/x.nc"}, isErr: true},
+	}
+	for _, test := range tests {
+		index, err := resumeIndex(test.paths, &test.cp)
+		if test.isErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %d", test.name, index)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if index != test.index {
+			t.Errorf("%s: expected to resume at %d, got %d", test.name, test.index, index)
+		}
+	}
+}
+
+func TestCrawlProgressCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "crawl.checkpoint.json")
+
+	var pathList []string
+	for i := 0; i < checkpointEvery+50; i++ {
+		pathList = append(pathList, fmt.Sprintf("/g/data/%03d.nc", i))
+	}
+
+	// interrupted after the first checkpoint
+	var out bytes.Buffer
+	p := newCrawlProgress(len(pathList), nil, file, "")
+	p.out = &out
+	for i, path := range pathList[:checkpointEvery+10] {
+		var err error
+		if i == 5 {
+			err = errors.New("failed\n")
+		}
+		p.write(path, path+"\n", err)
+	}
+	cp, err := loadCheckpoint(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Processed != checkpointEvery || cp.LastPath != pathList[checkpointEvery-1] || cp.Records != checkpointEvery-1 || cp.Errors != 1 {
+		t.Errorf("unexpected checkpoint: %+v", cp)
+	}
+	if len(cp.ErrorPaths) != 1 || cp.ErrorPaths[0] != pathList[5] {
+		t.Errorf("expected the error path %s, got %v", pathList[5], cp.ErrorPaths)
+	}
+
+	start, err := resumeIndex(pathList, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != checkpointEvery {
+		t.Fatalf("expected to resume at %d, got %d", checkpointEvery, start)
+	}
+	out.Reset()
+	p = newCrawlProgress(len(pathList), cp, file, "")
+	p.out = &out
+	for _, path := range pathList[start:] {
+		p.write(path, path+"\n", nil)
+	}
+	p.finish(false)
+
+	if !bytes.HasPrefix(out.Bytes(), []byte(pathList[checkpointEvery]+"\n")) {
+		t.Errorf("expected the records to resume with %s", pathList[checkpointEvery])
+	}
+	if n := bytes.Count(out.Bytes(), []byte("\n")); n != len(pathList)-checkpointEvery {
+		t.Errorf("expected %d records written on resume, got %d", len(pathList)-checkpointEvery, n)
+	}
+	cp, err = loadCheckpoint(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Processed != len(pathList) || cp.LastPath != pathList[len(pathList)-1] || cp.Records != len(pathList)-1 || cp.Errors != 1 {
+		t.Errorf("unexpected final checkpoint: %+v", cp)
+	}
+	if s := p.status(); s.Processed != len(pathList) || s.Total != len(pathList) {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestCheckpointErrorPaths(t *testing.T) {
+	p := newCrawlProgress(0, nil, "", "")
+	for i := 0; i < maxErrorPaths+10; i++ {
+		p.done(fmt.Sprint(i), errors.New("failed"))
+	}
+	if len(p.cp.ErrorPaths) != maxErrorPaths || p.cp.ErrorPaths[0] != "10" {
+		t.Errorf("expected the latest %d error paths, got %d from %s", maxErrorPaths, len(p.cp.ErrorPaths), p.cp.ErrorPaths[0])
+	}
+}
+
+func TestLoadCheckpointInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "crawl.checkpoint.json")
+	ioutil.WriteFile(file, []byte("{"), 0644)
+	if _, err := loadCheckpoint(file); err == nil {
+		t.Errorf("expected an error of an invalid checkpoint")
+	}
+	if _, err := loadCheckpoint(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing checkpoint, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// checksumAlgorithms are the hashes of the checksums of -checksum.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha256": sha256.New,
+}
+
+// fileChecksum returns the checksum of the content of a file, the name
+// of the algorithm followed by the hex digest, e.g. sha256:<hex>.
+func fileChecksum(path, algorithm string) (string, error) {
+	newHash, found := checksumAlgorithms[algorithm]
+	if !found {
+		return "", fmt.Errorf("unknown checksum algorithm %s", algorithm)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return algorithm + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumPath reports whether the checksum of a path is computed, the
+// objects, the remote datasets and the Zarr stores, directories, being
+// left without.
+func checksumPath(path string) bool {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return false
+	}
+	if strings.HasPrefix(path, "/vsi") {
+		return false
+	}
+	stat, err := os.Stat(path)
+	return err == nil && stat.Mode().IsRegular()
+}
+
+// verifyChecksums returns the files of MAS under root, recursively,
+// whose content no longer matches their checksum, sorted by path, with
+// concurrency files hashed in parallel. The files crawled without
+// checksum are skipped, the files which cannot be read being reported
+// as corrupt.
+func verifyChecksums(c *masClient, root string, concurrency int) ([]string, error) {
+	files, err := c.crawled(strings.TrimSuffix(root, "/"), true)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(chan *crawledFile)
+	var mu sync.Mutex
+	var corrupt []string
+	verified := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range paths {
+				algorithm := strings.SplitN(f.Checksum, ":", 2)[0]
+				sum, err := fileChecksum(f.FilePath, algorithm)
+				if err != nil {
+					log.Printf("checksum of %s failed: %v", f.FilePath, err)
+				}
+				mu.Lock()
+				verified++
+				if sum != f.Checksum {
+					corrupt = append(corrupt, f.FilePath)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, f := range files {
+		if len(f.Checksum) > 0 {
+			paths <- f
+		}
+	}
+	close(paths)
+	wg.Wait()
+
+	sort.Strings(corrupt)
+	log.Printf("%d of %d files of MAS under %s with checksums corrupt, %d without checksums", len(corrupt), verified, root, len(files)-verified)
+	return corrupt, nil
+}
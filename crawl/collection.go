@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	extr "github.com/nci/gsky/crawl/extractor"
+	"github.com/nci/gsky/schedule"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// collection is a crawl declared by a YAML or JSON file rather than by
+// the command line, e.g. of a product whose files need their own rule
+// sets, the file being crawled with -collection.
+type collection struct {
+	// Name is the name of the job of the collection scheduled by
+	// -schedule, the name of the file without extension by default.
+	Name string `json:"name"`
+	// Paths are the directories, object prefixes and files crawled, the
+	// directories recursively.
+	Paths []string `json:"paths"`
+	// Include and Exclude are the shell patterns of the names of the
+	// files crawled and of those skipped, the directories whose names
+	// match Exclude being skipped too, e.g. *.nc and .snapshot.
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// RuleSets are the rule sets of the files, those of -conf.
+	RuleSets []extr.RuleSet `json:"rule_sets"`
+	// Args are the flags of the crawls, overridden by those of the
+	// command line, e.g. ["-incremental", "-fmt", "tsv"].
+	Args []string `json:"args"`
+
+	// Schedule, IngestCmd, Output and Timeout are those of the job of
+	// the collection run by -schedule.
+	Schedule  string `json:"schedule"`
+	IngestCmd string `json:"ingest_cmd"`
+	Output    string `json:"output"`
+	Timeout   int    `json:"timeout"`
+}
+
+// jsonValue returns the value of a YAML document as decoded from JSON,
+// its maps keyed by strings, for the json tags to apply to both.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+	}
+	return v
+}
+
+// loadCollection reads and validates the collection of a YAML or JSON
+// file.
+func loadCollection(file string) (*collection, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// YAML being a superset of JSON, both are read as YAML.
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("invalid collection %s: %v", file, err)
+	}
+	if b, err = json.Marshal(jsonValue(doc)); err != nil {
+		return nil, fmt.Errorf("invalid collection %s: %v", file, err)
+	}
+	// The unknown keys are rejected, e.g. of misspelt rules.
+	coll := &collection{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(coll); err != nil {
+		return nil, fmt.Errorf("invalid collection %s: %v", file, err)
+	}
+	if len(coll.Name) == 0 {
+		coll.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	if err := coll.validate(); err != nil {
+		return nil, fmt.Errorf("invalid collection %s: %v", file, err)
+	}
+	return coll, nil
+}
+
+func (coll *collection) validate() error {
+	if len(coll.Paths) == 0 {
+		return fmt.Errorf("no paths")
+	}
+	for _, patterns := range [][]string{coll.Include, coll.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	config := &extr.Config{RuleSets: coll.RuleSets}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	for _, arg := range coll.Args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		switch strings.TrimLeft(strings.SplitN(arg, "=", 2)[0], "-") {
+		case "collection", "schedule", "crawl_worker":
+			return fmt.Errorf("%s in args", arg)
+		}
+	}
+	if len(coll.Args) > 0 && !strings.HasPrefix(coll.Args[0], "-") {
+		return fmt.Errorf("args expected to be flags, the files crawled being those of paths")
+	}
+	if len(coll.Schedule) > 0 {
+		if _, err := schedule.Parse(coll.Schedule); err != nil {
+			return err
+		}
+	}
+	if len(coll.IngestCmd) > 0 && len(coll.Output) > 0 {
+		return fmt.Errorf("either ingest_cmd or output expected")
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// included reports whether a file of a collection is crawled, given its
+// path relative to the path of the collection: its name must match the
+// include patterns, if any, and neither its name nor those of its
+// directories the exclude patterns.
+func (coll *collection) included(rel string) bool {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	name := parts[len(parts)-1]
+	if len(coll.Include) > 0 && !matchAny(coll.Include, name) {
+		return false
+	}
+	for _, part := range parts {
+		if matchAny(coll.Exclude, part) {
+			return false
+		}
+	}
+	return true
+}
+
+// roots returns the paths of the collection, the object URIs being
+// replaced by their GDAL paths.
+func (coll *collection) roots() []string {
+	roots := make([]string, len(coll.Paths))
+	for i, root := range coll.Paths {
+		roots[i] = extr.VSIPath(root)
+	}
+	return roots
+}
+
+// files returns the files of the collection in the order of its paths,
+// the files of a directory in lexical order, the Zarr stores named
+// *.zarr being single files.
+func (coll *collection) files() ([]string, error) {
+	var files []string
+	for _, root := range coll.roots() {
+		switch {
+		case strings.HasPrefix(root, "http://") || strings.HasPrefix(root, "https://"):
+			files = append(files, root)
+
+		case extr.IsObjectPath(root):
+			objects, err := extr.ListVSI(root, "")
+			if err != nil {
+				return nil, err
+			}
+			prefix := strings.TrimSuffix(root, "/") + "/"
+			for _, object := range objects {
+				if coll.included(strings.TrimPrefix(object, prefix)) {
+					files = append(files, object)
+				}
+			}
+
+		default:
+			stat, err := os.Stat(root)
+			if err != nil {
+				return nil, err
+			}
+			if !stat.IsDir() {
+				if coll.included(filepath.Base(root)) {
+					files = append(files, root)
+				}
+				continue
+			}
+			err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if path == root {
+					return nil
+				}
+				rel, _ := filepath.Rel(root, path)
+				if info.IsDir() {
+					if matchAny(coll.Exclude, info.Name()) {
+						return filepath.SkipDir
+					}
+					if !strings.HasSuffix(info.Name(), ".zarr") {
+						return nil
+					}
+					if coll.included(rel) {
+						files = append(files, path)
+					}
+					return filepath.SkipDir
+				}
+				if info.Mode().IsRegular() && coll.included(rel) {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectionIncluded(t *testing.T) {
+	coll := &collection{Include: []string{"*.nc", "*.tif"}, Exclude: []string{".snapshot", "*_tmp.*"}}
+	tests := []struct {
+		rel      string
+		included bool
+	}{
+		{"chirps-2020.nc", true},
+		{"monthly/chirps-2020.tif", true},
+		{"chirps-2020.nc.aux.xml", false},
+		{"README", false},
+		{"chirps_tmp.nc", false},
+		{".snapshot/chirps-2020.nc", false},
+		{"monthly/.snapshot/2020/chirps-2020.nc", false},
+		{"snapshot/chirps-2020.nc", true},
+	}
+	for _, test := range tests {
+		if included := coll.included(test.rel); included != test.included {
+			t.Errorf("%s: expected included %v, got %v", test.rel, test.included, included)
+		}
+	}
+
+	// without include patterns, all the files not excluded are crawled
+	coll = &collection{Exclude: []string{"*.xml"}}
+	if !coll.included("monthly/README") || coll.included("chirps.nc.aux.xml") {
+		t.Errorf("expected the files but those excluded")
+	}
+}
+
+func TestCollectionFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"b.nc", "a.nc", "a.nc.aux.xml", "monthly/2020.nc", ".snapshot/a.nc", "store.zarr/.zarray", "store.zarr/0.0"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("gsky"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	coll := &collection{
+		Paths:   []string{dir, filepath.Join(dir, "b.nc"), filepath.Join(dir, "a.nc.aux.xml"), "https://thredds.example.org/thredds/dodsC/chirps.nc"},
+		Include: []string{"*.nc", "*.zarr"},
+		Exclude: []string{".snapshot"},
+	}
+	files, err := coll.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "a.nc"),
+		filepath.Join(dir, "b.nc"),
+		filepath.Join(dir, "monthly/2020.nc"),
+		filepath.Join(dir, "store.zarr"),
+		filepath.Join(dir, "b.nc"),
+		"https://thredds.example.org/thredds/dodsC/chirps.nc",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected the files %v, got %v", expected, files)
+	}
+
+	coll.Paths = []string{filepath.Join(dir, "missing")}
+	if _, err := coll.files(); err == nil {
+		t.Errorf("expected an error of a missing path")
+	}
+}
+
+func TestLoadCollection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name  string
+		doc   string
+		isErr string
+	}{
+		{name: "chirps.yaml", doc: "paths: [/g/data/chirps]\ninclude: ['*.tif']\nargs: [-incremental]\nschedule: '@daily'\n"},
+		{name: "tamsat.json", doc: `{"name": "tamsat-v3", "paths": ["s3://tamsat/v3.1/"], "rule_sets": [{"pattern": ".+", "time_source": "path"}]}`},
+		{name: "misspelt.yaml", doc: "paths: [/g/data/chirps]\ninclud: ['*.tif']\n", isErr: "unknown field"},
+		{name: "empty.yaml", doc: "include: ['*.tif']\n", isErr: "no paths"},
+		{name: "pattern.yaml", doc: "paths: [/g/data/chirps]\nexclude: ['[']\n", isErr: "invalid pattern"},
+		{name: "args.yaml", doc: "paths: [/g/data/chirps]\nargs: [-schedule, jobs.yaml]\n", isErr: "-schedule in args"},
+		{name: "paths.yaml", doc: "paths: [/g/data/chirps]\nargs: [/g/data/tamsat]\n", isErr: "expected to be flags"},
+		{name: "cron.yaml", doc: "paths: [/g/data/chirps]\nschedule: '61 * * * *'\n", isErr: "invalid minute"},
+		{name: "output.yaml", doc: "paths: [/g/data/chirps]\ningest_cmd: cat\noutput: chirps.tsv\n", isErr: "either ingest_cmd or output"},
+	}
+	for _, test := range tests {
+		file := filepath.Join(dir, test.name)
+		if err := ioutil.WriteFile(file, []byte(test.doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+		coll, err := loadCollection(file)
+		if len(test.isErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.isErr) {
+				t.Errorf("%s: expected an error of %q, got %v", test.name, test.isErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		switch test.name {
+		case "chirps.yaml":
+			if coll.Name != "chirps" || coll.Include[0] != "*.tif" || coll.Args[0] != "-incremental" {
+				t.Errorf("%s: unexpected collection %+v", test.name, coll)
+			}
+		case "tamsat.json":
+			if coll.Name != "tamsat-v3" || len(coll.RuleSets) != 1 || coll.RuleSets[0].TimeSource != "path" {
+				t.Errorf("%s: unexpected collection %+v", test.name, coll)
+			}
+			if roots := coll.roots(); roots[0] != "/vsis3/tamsat/v3.1/" {
+				t.Errorf("%s: expected the GDAL path of the bucket, got %s", test.name, roots[0])
+			}
+		}
+	}
+}
@@ -2,15 +2,21 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	extr "github.com/nci/gsky/crawl/extractor"
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/thredds"
 	"github.com/nci/gsky/utils"
 )
 
@@ -22,13 +28,21 @@ func ensure(err error) {
 
 const DefaultContentCrawlConcLimit = 2
 const DefaultPosixCrawlConcLimit = 4
+const DefaultProgressInterval = 60
+const DefaultWatchPoll = 60
+const DefaultWatchBatch = 5
 
 func main() {
+	if err := logging.Init("crawler", os.Getenv("GSKY_LOG_LEVEL"), os.Getenv("GSKY_LOG_FORMAT")); err != nil {
+		log.Fatal(err)
+	}
+
 	if len(os.Args) < 2 {
 		log.Fatal("Please provide a path to a file or '-' for reading from stdin")
 	}
 
 	path := os.Args[1]
+	var coll *collection
 
 	var concLimit int
 	approx := true
@@ -41,6 +55,37 @@ func main() {
 	var filePattern string
 
 	followSymlink := false
+	incremental := false
+	dryRun := false
+	samples := 3
+	deleted := false
+	var checksum string
+	var dedup string
+	verify := false
+	list := false
+	threddsCatalog := false
+	zarrStores := false
+	concurrency := 1
+	crawlWorker := false
+	var checkpointFile string
+	resume := false
+	var progressInterval int
+	var statusFile string
+	var statusPort int
+	var namePattern string
+	watch := false
+	var watchPoll int
+	watchBatch := DefaultWatchBatch
+	var ingestCmd string
+	collectionMode := false
+	scheduled := false
+	var schedulePort int
+	var stacDir string
+	var stacCollection string
+	var quarantineFile string
+	manifest := false
+	var manifestColumns string
+	masAddress := os.Getenv("GSKY_MAS_ADDRESS")
 
 	if len(os.Args) > 2 {
 		flagSet := flag.NewFlagSet("Usage", flag.ExitOnError)
@@ -55,10 +100,52 @@ func main() {
 		flagSet.BoolVar(&posix, "posix", false, "Extract POSIX metadata from input directory")
 		flagSet.StringVar(&filePattern, "pattern", "", "pattern expression for POSIX crawl")
 		flagSet.BoolVar(&followSymlink, "followSymlink", false, "Extract POSIX metadata from input directory")
+		flagSet.BoolVar(&incremental, "incremental", false, "Only extract the files new or changed since crawled in the MAS of -mas")
+		flagSet.BoolVar(&dryRun, "dry_run", false, "Write the diff of the files with those crawled in the MAS of -mas as JSON lines, without crawling them")
+		flagSet.IntVar(&samples, "samples", samples, "Number of files new or changed whose records are extracted in the diff of -dry_run")
+		flagSet.BoolVar(&deleted, "deleted", false, "List the files of the MAS of -mas under the directories or prefixes no longer present, for shard_gc.sh")
+		flagSet.StringVar(&checksum, "checksum", "", "Checksum of the content of the files recorded with their metadata, md5 or sha256")
+		flagSet.StringVar(&dedup, "dedup", "", "Ingest of the files of the same content as a file already in MAS, of the checksums of -checksum: link to record them as duplicates of the file without indexing them, or flag to index them flagged as duplicates")
+		flagSet.BoolVar(&verify, "verify", false, "List the files of the MAS of -mas under the directories whose content no longer matches their checksum")
+		flagSet.StringVar(&masAddress, "mas", masAddress, "MAS address of -incremental, -dry_run, -deleted and -verify, e.g. http://localhost:8080")
+		flagSet.BoolVar(&list, "list", false, "List the objects under the s3://, gs:// or az:// prefix, as the file list of a crawl")
+		flagSet.BoolVar(&threddsCatalog, "thredds", false, "List the OPeNDAP URLs of the datasets of the THREDDS catalog URL and of its catalogRefs, as the file list of a crawl")
+		flagSet.BoolVar(&zarrStores, "zarr", false, "Extract the metadata of the Zarr stores of the paths, the stores named *.zarr being detected without it")
+		flagSet.IntVar(&concurrency, "concurrency", concurrency, "Number of crawl worker processes extracting the files in parallel, the records being written in the order of the files, or of the files hashed in parallel by -verify")
+		flagSet.BoolVar(&crawlWorker, "crawl_worker", false, "Run as a crawl worker process of -concurrency")
+		flagSet.StringVar(&checkpointFile, "checkpoint", "", "Checkpoint file of the crawl, saved every 100 files, for -resume")
+		flagSet.BoolVar(&resume, "resume", false, "Resume the crawl of the file list after the last file of the checkpoint of -checkpoint")
+		flagSet.IntVar(&progressInterval, "progress", 0, "Seconds between the progress reports to stderr, 0 for none")
+		flagSet.StringVar(&statusFile, "status", "", "File of the progress reports, written as JSON every -progress seconds, 60 by default")
+		flagSet.IntVar(&statusPort, "status_port", 0, "Port serving the progress of the crawl, or of the files arrived of -watch, as JSON at /status and as Prometheus metrics at /metrics, 0 for none")
+		flagSet.StringVar(&namePattern, "name", "", "Shell pattern of the names of the objects listed by -list, of the datasets listed by -thredds or of the files crawled by -watch, e.g. *.nc")
+		flagSet.BoolVar(&watch, "watch", false, "Crawl the files arriving in the directories continuously, with inotify")
+		flagSet.IntVar(&watchPoll, "watch_poll", 0, "Seconds between the walks of the directories of -watch instead of inotify, e.g. on network file systems, 0 for inotify")
+		flagSet.IntVar(&watchBatch, "watch_batch", watchBatch, "Seconds between the crawls of the files arrived of -watch")
+		flagSet.StringVar(&ingestCmd, "ingest_cmd", "", "Shell command reading the records of each crawl of -watch from stdin, e.g. mas/db/shard_append.sh <shard>, the records being written to stdout if empty")
+		flagSet.BoolVar(&scheduled, "schedule", false, "Run the crawl jobs of the JSON file of the path on their cron schedules, the runs of a job still running when due being skipped")
+		flagSet.IntVar(&schedulePort, "schedule_port", 0, "Port serving the status of the jobs of -schedule at /status, 0 for none")
+		flagSet.BoolVar(&collectionMode, "collection", false, "Crawl the collection of the YAML or JSON file of the path: the files of its paths matching its include and exclude patterns, with its rule sets and its flags")
+		flagSet.StringVar(&stacDir, "stac", "", "Directory of the STAC 1.0 Items of the files crawled, written to items/<id>.json alongside their records")
+		flagSet.StringVar(&stacCollection, "stac_collection", "", "Id of the STAC Collection of the Items of -stac, its collection.json extended to them, that of -collection by default")
+		flagSet.StringVar(&quarantineFile, "quarantine", "", "Report file of the files whose extraction failed, appended as JSON lines, the files being skipped and written as quarantine records of -fmt tsv, in crawl worker processes for a crash of GDAL to only quarantine its file")
+		flagSet.BoolVar(&manifest, "manifest", false, "Crawl the files listed by the CSV or JSON manifest of the path, e.g. an S3 inventory report, optionally gzipped, without walking directories")
+		flagSet.StringVar(&manifestColumns, "manifest_columns", "", "Columns of the CSV manifest of -manifest without header, e.g. the fileSchema of an S3 inventory, \"Bucket, Key, Size, LastModifiedDate\"")
 		flagSet.Parse(os.Args[2:])
+		if collectionMode {
+			var err error
+			coll, err = loadCollection(path)
+			ensure(err)
+			// The flags of the command line override those of the
+			// collection.
+			flagSet.Parse(append(append([]string{}, coll.Args...), os.Args[2:]...))
+		}
 
 		approx = !exact
 	}
+	if coll != nil && len(stacCollection) == 0 {
+		stacCollection = coll.Name
+	}
 
 	outputFormat = strings.ToLower(strings.TrimSpace(outputFormat))
 	if len(outputFormat) == 0 {
@@ -69,9 +156,86 @@ func main() {
 		log.Fatal("Valid output formats are raw and tsv")
 	}
 
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	if _, found := checksumAlgorithms[checksum]; len(checksum) > 0 && !found {
+		log.Fatal("Valid checksums are md5 and sha256")
+	}
+	dedup = strings.ToLower(strings.TrimSpace(dedup))
+	if dedup != "" && dedup != "link" && dedup != "flag" {
+		log.Fatal("Valid dedup values are link and flag")
+	}
+	if len(dedup) > 0 && len(checksum) == 0 {
+		log.Fatal("-dedup requires -checksum")
+	}
+
+	contentConcLimit := concLimit
+	if contentConcLimit < 1 {
+		contentConcLimit = DefaultContentCrawlConcLimit
+	}
 	var err error
+	var cfg []byte
+	if len(configFile) > 0 {
+		cfg, err = ioutil.ReadFile(configFile)
+		ensure(err)
+		config := &extr.Config{}
+		ensure(utils.Unmarshal(cfg, config))
+		ensure(config.Validate())
+	}
+	if coll != nil && len(coll.RuleSets) > 0 {
+		if len(configFile) > 0 {
+			log.Fatal("-conf and the rule_sets of -collection are exclusive")
+		}
+		cfg, err = json.Marshal(&extr.Config{RuleSets: coll.RuleSets})
+		ensure(err)
+	}
+	c := &contentCrawl{
+		concLimit:      contentConcLimit,
+		approx:         approx,
+		sentinel2Yaml:  sentinel2Yaml,
+		landsatYaml:    landsatYaml,
+		zarrStores:     zarrStores,
+		ncMetadata:     ncMetadata,
+		incremental:    incremental,
+		checksum:       checksum,
+		dedup:          dedup,
+		cfg:            cfg,
+		outputFormat:   outputFormat,
+		stacDir:        stacDir,
+		stacCollection: stacCollection,
+	}
+
+	if crawlWorker {
+		ensure(c.serve(os.Stdin, os.NewFile(3, "results")))
+		return
+	}
+
+	if scheduled {
+		ensure(runSchedule(path, schedulePort))
+		return
+	}
+
 	var pathList []string
-	if path == "-" {
+	if coll != nil {
+		if watch || list || threddsCatalog || posix || manifest {
+			log.Fatal("-collection crawls the files of its paths, without -watch, -list, -thredds, -posix or -manifest")
+		}
+		// -deleted and -verify check the paths of the collection, the
+		// other crawls take its files.
+		if deleted || verify {
+			pathList = coll.roots()
+		} else {
+			pathList, err = coll.files()
+			ensure(err)
+			log.Printf("collection %s: %d files", coll.Name, len(pathList))
+		}
+	} else if manifest {
+		if watch || list || threddsCatalog || posix {
+			log.Fatal("-manifest crawls the files listed, without -watch, -list, -thredds or -posix")
+		}
+		pathList, err = readManifest(path, manifestColumns)
+		ensure(err)
+		log.Printf("manifest %s: %d files", path, len(pathList))
+	} else if path == "-" {
 		scanner := bufio.NewScanner(os.Stdin)
 		for scanner.Scan() {
 			file := scanner.Text()
@@ -87,69 +251,325 @@ func main() {
 		pathList = append(pathList, path)
 	}
 
+	if len(pathList) == 0 && coll != nil {
+		ensure(fmt.Errorf("No files in the paths of collection %s", coll.Name))
+	}
+	if len(pathList) == 0 && manifest {
+		ensure(fmt.Errorf("No files in the manifest %s", path))
+	}
 	if len(pathList) == 0 {
 		ensure(fmt.Errorf("No files from STDIN"))
 	}
 
+	// The objects are crawled and stored in MAS by their GDAL paths,
+	// e.g. /vsis3/bucket/key, which the OWS workers open as is.
+	for i := range pathList {
+		pathList[i] = extr.VSIPath(pathList[i])
+	}
+
+	if threddsCatalog {
+		walker := &thredds.Walker{Client: &http.Client{Timeout: time.Minute}}
+		for _, path = range pathList {
+			err := walker.Walk(path, func(ds thredds.Dataset) error {
+				if len(namePattern) > 0 {
+					if matched, err := filepath.Match(namePattern, ds.Name); err != nil || !matched {
+						return err
+					}
+				}
+				fmt.Println(ds.URL)
+				return nil
+			})
+			ensure(err)
+		}
+		return
+	}
+
+	if list {
+		for _, path = range pathList {
+			if !extr.IsObjectPath(path) {
+				log.Fatalf("-list expects an s3://, gs:// or az:// prefix: %s", path)
+			}
+			paths, err := extr.ListVSI(path, namePattern)
+			ensure(err)
+			for _, p := range paths {
+				fmt.Println(p)
+			}
+		}
+		return
+	}
+
+	if watch {
+		for _, path = range pathList {
+			if extr.IsObjectPath(path) {
+				log.Fatalf("-watch expects directories: %s", path)
+			}
+		}
+		if len(ingestCmd) > 0 && outputFormat != "tsv" {
+			log.Fatal("-ingest_cmd expects the records of -fmt tsv")
+		}
+		if watchBatch < 1 {
+			watchBatch = DefaultWatchBatch
+		}
+		if len(quarantineFile) > 0 {
+			c.quarantine, err = openQuarantine(quarantineFile, outputFormat == "tsv")
+			ensure(err)
+		}
+		progress := newCrawlProgress(0, nil, "", "")
+		if statusPort > 0 {
+			serveStatus(progress, statusPort)
+		}
+		c.watch(pathList, &watchOptions{
+			namePattern: namePattern,
+			poll:        time.Duration(watchPoll) * time.Second,
+			batch:       time.Duration(watchBatch) * time.Second,
+			ingestCmd:   ingestCmd,
+			progress:    progress,
+		})
+		return
+	}
+
+	if deleted {
+		if len(masAddress) == 0 {
+			log.Fatal("-deleted requires the MAS address of -mas or $GSKY_MAS_ADDRESS")
+		}
+		mas := &masClient{address: masAddress, apiKey: os.Getenv("GSKY_MAS_API_KEY"), client: &http.Client{Timeout: time.Minute}}
+		for _, path = range pathList {
+			paths, err := deletedPaths(mas, path)
+			ensure(err)
+			for _, p := range paths {
+				fmt.Println(p)
+			}
+		}
+		return
+	}
+
+	if verify {
+		if len(masAddress) == 0 {
+			log.Fatal("-verify requires the MAS address of -mas or $GSKY_MAS_ADDRESS")
+		}
+		mas := &masClient{address: masAddress, apiKey: os.Getenv("GSKY_MAS_API_KEY"), client: &http.Client{Timeout: time.Minute}}
+		corrupt := 0
+		for _, path = range pathList {
+			paths, err := verifyChecksums(mas, path, concurrency)
+			ensure(err)
+			for _, p := range paths {
+				fmt.Println(p)
+			}
+			corrupt += len(paths)
+		}
+		if corrupt > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if posix {
 		if concLimit < 1 {
 			concLimit = DefaultPosixCrawlConcLimit
 		}
 		for _, path = range pathList {
+			if extr.IsObjectPath(path) {
+				log.Fatalf("-posix expects a directory, the objects being listed with -list: %s", path)
+			}
 			err := extr.ExtractPosix(path, concLimit, filePattern, followSymlink, outputFormat)
 			ensure(err)
 		}
 		return
 	}
 
-	if concLimit < 1 {
-		concLimit = DefaultContentCrawlConcLimit
+	if dryRun {
+		if len(masAddress) == 0 {
+			log.Fatal("-dry_run requires the MAS address of -mas or $GSKY_MAS_ADDRESS")
+		}
+		mas := &masClient{address: masAddress, apiKey: os.Getenv("GSKY_MAS_API_KEY"), client: &http.Client{Timeout: time.Minute}}
+		_, err = crawlDiff(mas, c, pathList, samples, os.Stdout)
+		ensure(err)
+		return
 	}
 
-	var cfg []byte
-	for _, path = range pathList {
-		var geoFile *extr.GeoFile
-		if sentinel2Yaml {
-			geoFile, err = extr.ExtractYaml(path, "sentinel2")
-		} else if landsatYaml {
-			geoFile, err = extr.ExtractYaml(path, "landsat")
+	if len(quarantineFile) > 0 {
+		c.quarantine, err = openQuarantine(quarantineFile, outputFormat == "tsv")
+		ensure(err)
+	}
+
+	if incremental {
+		if len(masAddress) == 0 {
+			log.Fatal("-incremental requires the MAS address of -mas or $GSKY_MAS_ADDRESS")
+		}
+		mas := &masClient{address: masAddress, apiKey: os.Getenv("GSKY_MAS_API_KEY"), client: &http.Client{Timeout: time.Minute}}
+		changedPaths, err := incrementalPaths(mas, pathList)
+		ensure(err)
+		log.Printf("%d of %d files new or changed since crawled", len(changedPaths), len(pathList))
+		pathList = changedPaths
+	}
+
+	// The Collection of -stac is extended to the Items of all the files of
+	// the crawl, including those crawled before a -resume.
+	stacPaths := pathList
+
+	var cp *checkpoint
+	total := len(pathList)
+	if resume {
+		if len(checkpointFile) == 0 {
+			log.Fatal("-resume requires the checkpoint file of -checkpoint")
+		}
+		cp, err = loadCheckpoint(checkpointFile)
+		if os.IsNotExist(err) {
+			log.Printf("no checkpoint %s, crawling all the files", checkpointFile)
+			cp, err = nil, nil
+		}
+		ensure(err)
+		if cp != nil {
+			start, err := resumeIndex(pathList, cp)
+			ensure(err)
+			log.Printf("resuming the crawl after %s, %d of %d files processed", cp.LastPath, start, total)
+			cp.Processed = start
+			pathList = pathList[start:]
+		}
+	}
+
+	progress := newCrawlProgress(total, cp, checkpointFile, statusFile)
+	progress.quarantine = c.quarantine
+	if progressInterval <= 0 && len(statusFile) > 0 {
+		progressInterval = DefaultProgressInterval
+	}
+	if progressInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		progress.start(ctx, time.Duration(progressInterval)*time.Second)
+	}
+	if statusPort > 0 {
+		serveStatus(progress, statusPort)
+	}
+
+	// The files quarantined may crash GDAL, which a worker process
+	// isolates.
+	if concurrency > 1 || c.quarantine != nil {
+		// The workers are crawlers of the same flags, reading their paths
+		// from stdin.
+		first := "-"
+		if coll != nil {
+			first = os.Args[1]
+		}
+		args := append([]string{first}, os.Args[2:]...)
+		args = append(args, "-crawl_worker")
+		crawlConcurrently(pathList, concurrency, args, progress)
+	} else {
+		for _, path = range pathList {
+			progress.setCurrent(path)
+			rec, err := c.record(path)
+			progress.write(path, rec, err)
+		}
+	}
+	progress.finish(progressInterval > 0)
+	ensure(c.updateSTACCollection(stacPaths))
+	if c.quarantine != nil {
+		n, err := c.quarantine.close()
+		ensure(err)
+		if n > 0 {
+			log.Printf("%d files quarantined in %s", n, quarantineFile)
+		}
+	}
+}
+
+// contentCrawl extracts the records of the content crawls.
+type contentCrawl struct {
+	concLimit     int
+	approx        bool
+	sentinel2Yaml bool
+	landsatYaml   bool
+	zarrStores    bool
+	ncMetadata    bool
+	incremental   bool
+	checksum      string
+	dedup         string
+	cfg           []byte
+	outputFormat  string
+	// stacDir and stacCollection are the directory of the STAC Items
+	// of the files and the id of their Collection, if any.
+	stacDir        string
+	stacCollection string
+	// quarantine is the report of -quarantine, nil for the crawl workers.
+	quarantine *quarantineReport
+}
+
+// record returns the output record of the metadata of a path.
+func (c *contentCrawl) record(path string) (string, error) {
+	var geoFile *extr.GeoFile
+	var err error
+	if c.sentinel2Yaml {
+		geoFile, err = extr.ExtractYaml(path, "sentinel2")
+	} else if c.landsatYaml {
+		geoFile, err = extr.ExtractYaml(path, "landsat")
+	} else if c.zarrStores || extr.IsZarrPath(path) {
+		geoFile, err = extr.ExtractZarr(path)
+	} else {
+		config := &extr.Config{}
+		if len(c.cfg) > 0 {
+			if err := utils.Unmarshal(c.cfg, config); err != nil {
+				return "", err
+			}
 		} else {
-			config := &extr.Config{}
-			if len(configFile) > 0 {
-				if len(cfg) == 0 {
-					cfg, err = ioutil.ReadFile(configFile)
-					ensure(err)
-					err = utils.Unmarshal([]byte(cfg), config)
-					ensure(err)
-				}
-			} else {
-				if ncMetadata {
-					ruleSet := extr.RuleSet{
-						NcMetadata:    ncMetadata,
-						NameSpace:     extr.NSDataset,
-						SRSText:       extr.SRSDetect,
-						Proj4Text:     extr.Proj4Detect,
-						Pattern:       `.+`,
-						MatchFullPath: true,
-						TimeAxis:      &extr.DatasetAxis{},
-					}
-					config.RuleSets = append(config.RuleSets, ruleSet)
+			if c.ncMetadata {
+				ruleSet := extr.RuleSet{
+					NcMetadata:    c.ncMetadata,
+					NameSpace:     extr.NSDataset,
+					SRSText:       extr.SRSDetect,
+					Proj4Text:     extr.Proj4Detect,
+					Pattern:       `.+`,
+					MatchFullPath: true,
+					TimeAxis:      &extr.DatasetAxis{},
 				}
+				config.RuleSets = append(config.RuleSets, ruleSet)
 			}
-			geoFile, err = extr.ExtractGDALInfo(path, concLimit, approx, config)
 		}
-		if err == nil {
-			out, err := json.Marshal(&geoFile)
-			ensure(err)
+		geoFile, err = extr.ExtractGDALInfo(path, c.concLimit, c.approx, config)
+	}
+	if err != nil {
+		return "", err
+	}
 
-			rec := string(out)
-			if outputFormat == "tsv" {
-				rec = fmt.Sprintf("%s\tgdal\t%s\n", path, string(out))
-			}
+	// The size and mtime of the file tell the next incremental crawls
+	// whether it changed.
+	if c.incremental && geoFile.PosixInfo == nil {
+		if info, err := posixInfo(path); err == nil {
+			geoFile.PosixInfo = info
+		}
+	}
+	if len(c.checksum) > 0 && checksumPath(path) {
+		if geoFile.Checksum, err = fileChecksum(path, c.checksum); err != nil {
+			return "", err
+		}
+		geoFile.Dedup = c.dedup
+	}
+	out, err := json.Marshal(&geoFile)
+	if err != nil {
+		return "", err
+	}
 
-			fmt.Print(rec)
-		} else {
-			os.Stderr.Write([]byte(err.Error()))
+	if len(c.stacDir) > 0 {
+		if err := c.writeSTACItem(path, geoFile); err != nil {
+			return "", err
+		}
+	}
+
+	rec := string(out)
+	if c.outputFormat == "tsv" {
+		recPath, err := recordPath(path)
+		if err != nil {
+			return "", err
 		}
+		rec = fmt.Sprintf("%s\tgdal\t%s\n", recPath, string(out))
+	}
+	return rec, nil
+}
+
+// recordPath returns the path of the record of a file in MAS. The
+// datasets of OPeNDAP URLs are recorded under /thredds, their ds_name
+// keeping the URL.
+func recordPath(path string) (string, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return thredds.RecordPath(path)
 	}
+	return path, nil
 }
@@ -17,7 +17,16 @@ fi
 
 mkdir -p $data_dir
 
-if [ -z "$CRAWL_FILE_LIST" ]
+if [ -z "$CRAWL_FILE_LIST" ] && [ ! -z "${CRAWL_THREDDS_CATALOG:-}" ]
+then
+	set -u
+	job_id=$(echo "$CRAWL_THREDDS_CATALOG" | sed 's#^[a-z]*://##; s#[/:]#_#g')
+	file_list=$data_dir/${job_id}.filelist.gz
+
+	set -ex
+	$gsky_crawler "$CRAWL_THREDDS_CATALOG" -thredds -name "${CRAWL_PATTERN:-*.nc}" | gzip > ${file_list}
+	set +x
+elif [ -z "$CRAWL_FILE_LIST" ]
 then
 	if [ -z "$CRAWL_DIR" ]
 	then
@@ -35,7 +44,14 @@ then
 	file_list=$data_dir/${job_id}.filelist.gz
 
 	set -ex
-	find $find_dir -name "$file_pattern" $find_params | gzip > ${file_list}
+	case "$find_dir" in
+	s3://*|gs://*|az://*|azure://*)
+		$gsky_crawler "$find_dir" -list -name "$file_pattern" | gzip > ${file_list}
+		;;
+	*)
+		find $find_dir -name "$file_pattern" $find_params | gzip > ${file_list}
+		;;
+	esac
 	set +x
 else
 	set -eu
@@ -57,5 +73,29 @@ echo "INFO: crawl batch size: $batch_size"
 export GDAL_PAM_ENABLED=NO
 export GDAL_NETCDF_VERIFY_DIMS=NO
 CRAWL_EXTRA_ARGS=${CRAWL_EXTRA_ARGS:-''}
+if [ ! -z "${CRAWL_INCREMENTAL_MAS:-}" ]
+then
+	CRAWL_EXTRA_ARGS="$CRAWL_EXTRA_ARGS -incremental -mas $CRAWL_INCREMENTAL_MAS"
+	echo "INFO: incremental crawl against MAS: $CRAWL_INCREMENTAL_MAS"
+fi
 
-zcat $file_list | concurrent -i -l $conc_limit -b $batch_size $gsky_crawler - -fmt tsv $CRAWL_EXTRA_ARGS | gzip > $crawl_file
+if [ ! -z "${CRAWL_CONCURRENCY:-}" ]
+then
+	echo "INFO: crawl worker processes: $CRAWL_CONCURRENCY"
+	checkpoint_file="$data_dir/${job_id}.checkpoint.json"
+	status_file="$data_dir/${job_id}.status.json"
+	echo "INFO: crawl checkpoint file: $checkpoint_file"
+	echo "INFO: crawl status file: $status_file"
+	CRAWL_EXTRA_ARGS="$CRAWL_EXTRA_ARGS -checkpoint $checkpoint_file -status $status_file"
+	if [ ! -z "${CRAWL_RESUME:-}" ] && [ -f "$checkpoint_file" ]
+	then
+		# The gzip members of the resumed crawl are appended to the
+		# records of the crawl checkpointed.
+		echo "INFO: resuming crawl from checkpoint"
+		zcat $file_list | $gsky_crawler - -fmt tsv -concurrency $CRAWL_CONCURRENCY -resume $CRAWL_EXTRA_ARGS | gzip >> $crawl_file
+	else
+		zcat $file_list | $gsky_crawler - -fmt tsv -concurrency $CRAWL_CONCURRENCY $CRAWL_EXTRA_ARGS | gzip > $crawl_file
+	fi
+else
+	zcat $file_list | concurrent -i -l $conc_limit -b $batch_size $gsky_crawler - -fmt tsv $CRAWL_EXTRA_ARGS | gzip > $crawl_file
+fi
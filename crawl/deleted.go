@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	extr "github.com/nci/gsky/crawl/extractor"
+)
+
+// deletedPaths returns the files of MAS under root, recursively, no
+// longer present on disk or in the object store, sorted by path. A root
+// which is itself missing fails the check rather than reporting all its
+// files, e.g. of a file system not mounted. The files which cannot be
+// stat for other reasons are kept, their errors being logged.
+func deletedPaths(c *masClient, root string) ([]string, error) {
+	root = strings.TrimSuffix(root, "/")
+	if strings.HasPrefix(root, "/thredds/") {
+		return nil, fmt.Errorf("the OPeNDAP datasets under /thredds cannot be checked: %s", root)
+	}
+
+	var exists func(path string) (bool, error)
+	if extr.IsObjectPath(root) {
+		// The objects are listed at once rather than stat one by one,
+		// a failed stat not telling a missing object from a failed
+		// request. The prefixes of the objects listed are the Zarr
+		// stores.
+		objects, err := extr.ListVSI(root, "")
+		if err != nil {
+			return nil, err
+		}
+		if len(objects) == 0 {
+			return nil, fmt.Errorf("no objects under %s", root)
+		}
+		present := make(map[string]bool)
+		for _, o := range objects {
+			for p := o; len(p) > len(root); p = p[:strings.LastIndex(p, "/")] {
+				present[p] = true
+			}
+		}
+		exists = func(path string) (bool, error) {
+			return present[path], nil
+		}
+	} else {
+		if _, err := os.Stat(root); err != nil {
+			return nil, err
+		}
+		exists = func(path string) (bool, error) {
+			_, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return err == nil, err
+		}
+	}
+
+	files, err := c.crawled(root, true)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for path := range files {
+		found, err := exists(path)
+		if err != nil {
+			log.Printf("deleted check of %s failed, kept: %v", path, err)
+			continue
+		}
+		if !found {
+			deleted = append(deleted, path)
+		}
+	}
+	sort.Strings(deleted)
+	log.Printf("%d of %d files of MAS under %s deleted", len(deleted), len(files), root)
+	return deleted, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeletedPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_deleted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "monthly"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"2020.tif", "monthly/2020.tif"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("gsky"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mas := &fakeMAS{pageSize: 2, files: []*crawledFile{
+		{FilePath: filepath.Join(dir, "2020.tif")},
+		{FilePath: filepath.Join(dir, "2021.tif")},
+		{FilePath: filepath.Join(dir, "monthly/2020.tif")},
+		{FilePath: filepath.Join(dir, "monthly/2021.tif")},
+		{FilePath: filepath.Join(dir, "daily/2020.tif")},
+	}}
+	srv := httptest.NewServer(mas)
+	defer srv.Close()
+	c := &masClient{address: srv.URL, client: srv.Client()}
+
+	deleted, err := deletedPaths(c, dir+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "2021.tif"), filepath.Join(dir, "daily/2020.tif"), filepath.Join(dir, "monthly/2021.tif")}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected the deleted files %v, got %v", expected, deleted)
+	}
+
+	deleted, err = deletedPaths(c, filepath.Join(dir, "monthly"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{filepath.Join(dir, "monthly/2021.tif")}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected the deleted files %v, got %v", expected, deleted)
+	}
+
+	// a missing root is not reported as all its files deleted
+	mas.queries = nil
+	if _, err := deletedPaths(c, filepath.Join(dir, "daily")); err == nil {
+		t.Errorf("expected an error of a missing root")
+	}
+	if len(mas.queries) != 0 {
+		t.Errorf("expected MAS not to be queried for a missing root, got %v", mas.queries)
+	}
+
+	if _, err := deletedPaths(c, "/thredds/example.org/thredds/dodsC/chirps"); err == nil {
+		t.Errorf("expected an error for the OPeNDAP datasets")
+	}
+}
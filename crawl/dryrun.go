@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	extr "github.com/nci/gsky/crawl/extractor"
+	"github.com/nci/gsky/thredds"
+)
+
+// The changes of the files of a dry run.
+const (
+	changeNew       = "new"
+	changeUpdated   = "updated"
+	changeUnchanged = "unchanged"
+	changeDeleted   = "deleted"
+)
+
+// fileChange is a line of the diff of a dry run: a file of the crawl new
+// or changed since crawled, or a file of MAS in the directories of the
+// crawl no longer present. The first changed files carry the record the
+// crawl would extract.
+type fileChange struct {
+	Change    string           `json:"change"`
+	FilePath  string           `json:"file_path"`
+	PosixInfo *extr.PosixInfo  `json:"posix_info,omitempty"`
+	Crawled   *crawledFile     `json:"crawled,omitempty"`
+	Record    *json.RawMessage `json:"record,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// crawlDiff compares the files of pathList with those crawled in MAS,
+// writing the diff to w as JSON lines without extracting the files, but
+// the first samples changed, and returning the number of files per change.
+func crawlDiff(c *masClient, crawl *contentCrawl, pathList []string, samples int, w io.Writer) (map[string]int, error) {
+	enc := json.NewEncoder(w)
+	dirs := newCrawledDirs(c)
+	counts := make(map[string]int)
+	listed := make(map[string]bool)
+	for _, path := range pathList {
+		change := &fileChange{FilePath: path}
+		// The OPeNDAP datasets are recorded under /thredds, without
+		// posix_info.
+		recPath := path
+		if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+			var err error
+			if recPath, err = thredds.RecordPath(path); err != nil {
+				return nil, err
+			}
+		}
+		listed[recPath] = true
+		f, err := dirs.lookup(recPath)
+		if err != nil {
+			return nil, err
+		}
+		change.Crawled = f
+
+		info, err := posixInfo(path)
+		if err == nil {
+			change.PosixInfo = info
+		}
+		switch {
+		case f == nil:
+			change.Change = changeNew
+		case err != nil || changed(f, info):
+			change.Change = changeUpdated
+		default:
+			change.Change = changeUnchanged
+		}
+		counts[change.Change]++
+		if change.Change == changeUnchanged {
+			continue
+		}
+
+		if samples > 0 {
+			samples--
+			sample := *crawl
+			sample.outputFormat = "raw"
+			sample.stacDir = ""
+			rec, err := sample.record(path)
+			if err != nil {
+				change.Error = err.Error()
+			} else {
+				raw := json.RawMessage(rec)
+				change.Record = &raw
+			}
+		}
+		if err := enc.Encode(change); err != nil {
+			return nil, err
+		}
+	}
+
+	// The files of MAS in the directories of the crawl neither listed
+	// nor present are deleted, e.g. for shard_gc.sh.
+	var deleted []*crawledFile
+	for _, files := range dirs.dirs {
+		for path, f := range files {
+			if listed[path] || strings.HasPrefix(path, "/thredds/") {
+				continue
+			}
+			if _, err := posixInfo(path); err != nil {
+				deleted = append(deleted, f)
+			}
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].FilePath < deleted[j].FilePath })
+	for _, f := range deleted {
+		if err := enc.Encode(&fileChange{Change: changeDeleted, FilePath: f.FilePath, Crawled: f}); err != nil {
+			return nil, err
+		}
+	}
+	counts[changeDeleted] = len(deleted)
+
+	log.Printf("dry run: %d new, %d updated, %d deleted and %d unchanged files", counts[changeNew], counts[changeUpdated], counts[changeDeleted], counts[changeUnchanged])
+	return counts, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCrawlDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var pathList []string
+	for _, name := range []string{"new.tif", "same.tif", "resized.tif"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("gsky"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pathList = append(pathList, path)
+	}
+	stat, err := os.Stat(pathList[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, otherSize := stat.Size(), stat.Size()+1
+	mtime := stat.ModTime().UTC().Format(time.RFC3339Nano)
+	missing := filepath.Join(dir, "missing.tif")
+
+	mas := &fakeMAS{files: []*crawledFile{
+		{FilePath: filepath.Join(dir, "gone.tif"), Size: &size, MTime: mtime},
+		{FilePath: pathList[1], Size: &size, MTime: mtime},
+		{FilePath: pathList[2], Size: &otherSize, MTime: mtime},
+		{FilePath: missing, Size: &size, MTime: mtime},
+	}}
+	srv := httptest.NewServer(mas)
+	defer srv.Close()
+	c := &masClient{address: srv.URL, client: srv.Client()}
+
+	// the listed files missing are updated, not deleted
+	var out bytes.Buffer
+	counts, err := crawlDiff(c, &contentCrawl{}, append(pathList, missing), 0, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{changeNew: 1, changeUpdated: 2, changeUnchanged: 1, changeDeleted: 1}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected the summary %v, got %v", expected, counts)
+	}
+
+	var changes []string
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var change fileChange
+		if err := dec.Decode(&change); err != nil {
+			t.Fatal(err)
+		}
+		if change.Record != nil {
+			t.Errorf("%s: expected no record without samples", change.FilePath)
+		}
+		if change.Change == changeNew && change.Crawled != nil || change.Change != changeNew && change.Crawled == nil {
+			t.Errorf("%s: unexpected crawled file of a %s file: %+v", change.FilePath, change.Change, change.Crawled)
+		}
+		changes = append(changes, change.Change+" "+filepath.Base(change.FilePath))
+	}
+	if expected := []string{"new new.tif", "updated resized.tif", "updated missing.tif", "deleted gone.tif"}; !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected the diff %v, got %v", expected, changes)
+	}
+	if len(mas.queries) != 1 {
+		t.Errorf("expected the directory to be queried once, got %v", mas.queries)
+	}
+}
@@ -44,21 +44,18 @@ GDALDatasetH open_gdal_dataset(const char *srcFilePath, int queryMetadata) {
 import "C"
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"log"
 	"math"
 	"os"
-	"path/filepath"
-	"regexp"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 	"unsafe"
 
+	"github.com/nci/gsky/logging"
 	"github.com/nci/gsky/utils"
 )
 
@@ -66,7 +63,7 @@ var LogErr *log.Logger
 
 func init() {
 	utils.InitGdal()
-	LogErr = log.New(os.Stderr, "Crawler: ", log.Ldate|log.Ltime|log.Lshortfile)
+	LogErr = logging.New("crawler").StdLogger(logging.LevelError)
 }
 
 var dateFormats []string = []string{"2006-01-02 15:04:05.0", "2006-1-2 15:4:5"}
@@ -99,7 +96,17 @@ func ExtractGDALInfo(path string, concLimit int, approx bool, config *Config) (*
 	nsubds := C.CSLCount(metadata) / C.int(2)
 
 	var datasets = []*GeoMetaData{}
-	if nsubds == C.int(0) {
+	if shortName == "GRIB" {
+		// The messages of the GRIB files are grouped into datasets
+		// rather than opened as subdatasets.
+		gribDatasets, err := getGRIBInfo(path, hDataset)
+		if err != nil {
+			LogErr.Printf("%v", err)
+			return &GeoFile{}, err
+		}
+		datasets = gribDatasets
+
+	} else if nsubds == C.int(0) {
 		// There are no subdatasets
 		dsInfo, err := getDataSetInfo(path, cPath, shortName, approx, config)
 		if err != nil {
@@ -196,7 +203,6 @@ func getDataSetInfo(filename string, dsName *C.char, driverName string, approx b
 
 	var ncTimes []string
 	var err error
-	var times []time.Time
 	if ruleSet.NcMetadata || driverName == "netCDF" || driverName == "JP2OpenJPEG" {
 		ncTimes, err = getNCTime(datasetName, hSubdataset, ruleSet)
 		if err != nil && timeStamp.IsZero() && len(ruleSet.TimesText) == 0 {
@@ -204,27 +210,7 @@ func getDataSetInfo(filename string, dsName *C.char, driverName string, approx b
 		}
 	}
 
-	if err == nil && ncTimes != nil {
-		for _, timestr := range ncTimes {
-			t, err := time.ParseInLocation("2006-01-02T15:04:05Z", timestr, time.UTC)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			times = append(times, t)
-		}
-	} else if len(ruleSet.TimesText) > 0 {
-		for _, ts := range ruleSet.TimesText {
-			t, err := time.ParseInLocation("2006-01-02T15:04:05Z", ts, time.UTC)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			times = append(times, t)
-		}
-	} else {
-		times = append(times, timeStamp)
-	}
+	times := datasetTimes(ruleSet, timeStamp, ncTimes, err)
 
 	var ncAxes []*DatasetAxis
 	if ruleSet.NcMetadata || driverName == "netCDF" || driverName == "JP2OpenJPEG" {
@@ -283,29 +269,22 @@ func getDataSetInfo(filename string, dsName *C.char, driverName string, approx b
 	ovrs := make([]*Overview, int(nOvr))
 	for i := C.int(0); i < nOvr; i++ {
 		hOvr := C.GDALGetOverview(hBand, i)
-		ovrs[int(i)] = &Overview{XSize: int32(C.GDALGetRasterBandXSize(hOvr)), YSize: int32(C.GDALGetRasterBandYSize(hOvr))}
+		var blockXSize, blockYSize C.int
+		C.GDALGetBlockSize(hOvr, &blockXSize, &blockYSize)
+		ovrs[int(i)] = &Overview{XSize: int32(C.GDALGetRasterBandXSize(hOvr)), YSize: int32(C.GDALGetRasterBandYSize(hOvr)), BlockXSize: int32(blockXSize), BlockYSize: int32(blockYSize)}
 	}
+	structure := getImageStructure(hSubdataset, hBand)
 
 	projWkt := C.GoString(C.GDALGetProjectionRef(hSubdataset))
 
 	if projWkt == "" || ruleSet.SRSText != SRSDetect {
 		projWkt = ruleSet.SRSText
 	}
-	cProjWKT := C.CString(projWkt)
-
-	cProj4 := C.getProj4(cProjWKT)
-	C.free(unsafe.Pointer(cProjWKT))
-	proj4 := C.GoString(cProj4)
-	C.free(unsafe.Pointer(cProj4))
-
+	proj4 := getProj4Text(projWkt)
 	if proj4 == "" || ruleSet.Proj4Text != Proj4Detect {
 		proj4 = ruleSet.Proj4Text
 	}
 
-	var nameSpace string
-
-	nsPath := nameFields["namespace"]
-
 	// GDAL dataset string is dependent on the driver, example:
 	// NETCDF:"/g/data2/fk4/datacube/002/HLTC/HLTC_2_0/netcdf/COMPOSITE_HIGH_100_146.84_-40.8_20000101_20170101_PER_20.nc":blue
 	nsDataset := func() (ns string) {
@@ -315,18 +294,13 @@ func getDataSetInfo(filename string, dsName *C.char, driverName string, approx b
 		}
 		return
 	}()
+	if varPath := ncVarPath(datasetName); len(varPath) > 0 {
+		nsDataset = ncNameSpace(varPath)
+	}
 
-	switch ruleSet.NameSpace {
-
-	case NSCombine:
-		nameSpace = fmt.Sprintf("%s:%s", nsPath, nsDataset)
-
-	case NSPath:
-		nameSpace = nsPath
-
-	case NSDataset:
-		nameSpace = nsDataset
-
+	nameSpace, pathFields, err := ruleSetNameSpace(ruleSet, nameFields, nsDataset)
+	if err != nil {
+		return nil, err
 	}
 
 	dArr := [6]C.double{}
@@ -387,6 +361,22 @@ func getDataSetInfo(filename string, dsName *C.char, driverName string, approx b
 	if numBands < 0 {
 		numBands = int(C.GDALGetRasterCount(hSubdataset))
 	}
+
+	var standardName string
+	cStandardNameKey := C.CString("standard_name")
+	if cStandardName := C.GDALGetMetadataItem(C.GDALMajorObjectH(hBand), cStandardNameKey, nil); cStandardName != nil {
+		standardName = strings.TrimSpace(C.GoString(cStandardName))
+	}
+	C.free(unsafe.Pointer(cStandardNameKey))
+
+	// The bands of the variables of netCDF files, opened with their first
+	// band, share its attributes.
+	bandValues := getBandValues(hBand)
+	var bands []*BandValues
+	if driverName != "netCDF" {
+		bands = getBandsValues(hSubdataset, bandValues)
+	}
+
 	return &GeoMetaData{
 		DataSetName:  datasetName,
 		NameSpace:    nameSpace,
@@ -400,6 +390,7 @@ func getDataSetInfo(filename string, dsName *C.char, driverName string, approx b
 		Proj4:        proj4,
 		GeoTransform: geot,
 		Overviews:    ovrs,
+		Structure:    structure,
 		Mins:         mins,
 		Maxs:         maxs,
 		Means:        means,
@@ -408,104 +399,136 @@ func getDataSetInfo(filename string, dsName *C.char, driverName string, approx b
 		NoData:       float64(noData),
 		Axes:         ncAxes,
 		GeoLocation:  geoLocation,
+		StandardName: standardName,
+		PathFields:   pathFields,
+		BandValues:   *bandValues,
+		Bands:        bands,
 	}, nil
 }
 
-func getGeometryWKT(geot []float64, xSize, ySize int, ruleSet *RuleSet) string {
-	var ulX, ulY, lrX, lrY C.double
+// getImageStructure returns the block size of the first band of a dataset
+// and the layout, the compression and the interleaving of its image
+// structure metadata, e.g. LAYOUT=COG of the cloud optimized GeoTIFFs of
+// GDAL 3.1 or later.
+func getImageStructure(hDataset C.GDALDatasetH, hBand C.GDALRasterBandH) *ImageStructure {
+	cDomain := C.CString("IMAGE_STRUCTURE")
+	defer C.free(unsafe.Pointer(cDomain))
+	item := func(key string) string {
+		cKey := C.CString(key)
+		defer C.free(unsafe.Pointer(cKey))
+		if value := C.GDALGetMetadataItem(C.GDALMajorObjectH(hDataset), cKey, cDomain); value != nil {
+			return strings.TrimSpace(C.GoString(value))
+		}
+		return ""
+	}
 
-	if len(ruleSet.BBox) != 4 {
-		C.GDALApplyGeoTransform((*C.double)(unsafe.Pointer(&geot[0])), 0, 0, &ulX, &ulY)
-		C.GDALApplyGeoTransform((*C.double)(unsafe.Pointer(&geot[0])), C.double(xSize), C.double(ySize), &lrX, &lrY)
-	} else {
-		ulX = C.double(ruleSet.BBox[0])
-		ulY = C.double(ruleSet.BBox[1])
-		lrX = C.double(ruleSet.BBox[2])
-		lrY = C.double(ruleSet.BBox[3])
-		geot[0] = ruleSet.BBox[0]
-		geot[3] = ruleSet.BBox[1]
+	var blockXSize, blockYSize C.int
+	C.GDALGetBlockSize(hBand, &blockXSize, &blockYSize)
+	return &ImageStructure{
+		Layout:      item("LAYOUT"),
+		Compression: item("COMPRESSION"),
+		Interleave:  item("INTERLEAVE"),
+		BlockXSize:  int32(blockXSize),
+		BlockYSize:  int32(blockYSize),
 	}
-	return fmt.Sprintf("POLYGON ((%f %f,%f %f,%f %f,%f %f,%f %f))", ulX, ulY, ulX, lrY, lrX, lrY, lrX, ulY, ulX, ulY)
 }
 
-func copyRuleSet(dst interface{}, src interface{}) error {
-	if dst == nil {
-		return fmt.Errorf("dst cannot be nil")
+// getBandValues returns the units, the scale factor and the offset and the
+// valid range of a band, of the CF attributes of its metadata if not of
+// GDAL.
+func getBandValues(hBand C.GDALRasterBandH) *BandValues {
+	bv := &BandValues{}
+	if units := C.GDALGetRasterUnitType(hBand); units != nil {
+		bv.Units = strings.TrimSpace(C.GoString(units))
 	}
-	if src == nil {
-		return fmt.Errorf("src cannot be nil")
+	if len(bv.Units) == 0 {
+		bv.Units = bandMetadataItem(hBand, "units")
 	}
-	bytes, err := json.Marshal(src)
-	if err != nil {
-		return fmt.Errorf("Unable to marshal src: %s", err)
-	}
-	err = json.Unmarshal(bytes, dst)
-	if err != nil {
-		return fmt.Errorf("Unable to unmarshal into dst: %s", err)
-	}
-	return nil
-}
-
-func parseName(path string, config *Config) (*RuleSet, map[string]string, time.Time) {
-	_, basename := filepath.Split(path)
 
-	if len(config.RuleSets) > 0 {
-		CollectionRuleSets = config.RuleSets
+	var success C.int
+	if scale := float64(C.GDALGetRasterScale(hBand, &success)); success != 0 && scale != 0 && scale != 1 {
+		bv.ScaleFactor = &scale
+	}
+	if offset := float64(C.GDALGetRasterOffset(hBand, &success)); success != 0 && offset != 0 {
+		bv.AddOffset = &offset
 	}
 
-	for _, ruleSet := range CollectionRuleSets {
-		re := regexp.MustCompile(ruleSet.Pattern)
+	bv.ValidRange = parseValidRange(bandMetadataItem(hBand, "valid_range"), bandMetadataItem(hBand, "valid_min"), bandMetadataItem(hBand, "valid_max"))
+	return bv
+}
 
-		fname := basename
-		if ruleSet.MatchFullPath {
-			fname = path
-		}
-		if re.MatchString(fname) {
-			match := re.FindStringSubmatch(fname)
+// getBandsValues returns the values of each band of a dataset, nil if they
+// are those of its first band.
+func getBandsValues(hDataset C.GDALDatasetH, first *BandValues) []*BandValues {
+	nBands := int(C.GDALGetRasterCount(hDataset))
+	bands := []*BandValues{first}
+	differ := false
+	for ib := 2; ib <= nBands; ib++ {
+		bv := getBandValues(C.GDALGetRasterBand(hDataset, C.int(ib)))
+		differ = differ || !reflect.DeepEqual(bv, first)
+		bands = append(bands, bv)
+	}
+	if !differ {
+		return nil
+	}
+	return bands
+}
 
-			result := make(map[string]string)
-			for i, name := range re.SubexpNames() {
-				if i != 0 {
-					result[name] = match[i]
-				}
-			}
-			newRuleSet := RuleSet{}
-			copyRuleSet(&newRuleSet, &ruleSet)
-			return &newRuleSet, result, parseTime(result)
+// parseValidRange parses the valid range of the valid_range attribute, or
+// of the valid_min and valid_max attributes, of the forms of the metadata
+// of GDAL, e.g. {0,100}.
+func parseValidRange(validRange, validMin, validMax string) []float64 {
+	var fields []string
+	if len(validRange) > 0 {
+		fields = strings.FieldsFunc(validRange, func(r rune) bool {
+			return r == '{' || r == '}' || r == '[' || r == ']' || r == ',' || r == ' '
+		})
+	} else if len(validMin) > 0 && len(validMax) > 0 {
+		fields = []string{validMin, validMax}
+	}
+	if len(fields) != 2 {
+		return nil
+	}
+
+	vr := make([]float64, 2)
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
 		}
+		vr[i] = v
+	}
+	if vr[0] > vr[1] {
+		return nil
 	}
-	return nil, nil, time.Time{}
+	return vr
 }
 
-func getRegexMatches(source string, pattern string) map[string]string {
-	result := make(map[string]string)
-	re := regexp.MustCompile(pattern)
-	if re.MatchString(source) {
-		match := re.FindStringSubmatch(source)
-		for i, name := range re.SubexpNames() {
-			if i != 0 {
-				result[name] = match[i]
-			}
-		}
-	}
+// getProj4Text returns the PROJ.4 string of a WKT.
+func getProj4Text(projWkt string) string {
+	cProjWKT := C.CString(projWkt)
+	defer C.free(unsafe.Pointer(cProjWKT))
 
-	return result
+	cProj4 := C.getProj4(cProjWKT)
+	defer C.free(unsafe.Pointer(cProj4))
+	return C.GoString(cProj4)
 }
 
-func instantiateTemplate(tplText string, data interface{}) (string, error) {
-	tpl, err := template.New("template").Parse(tplText)
-	if err != nil {
-		return "", fmt.Errorf("Error trying to parse template document: %v", err)
-	}
+func getGeometryWKT(geot []float64, xSize, ySize int, ruleSet *RuleSet) string {
+	var ulX, ulY, lrX, lrY C.double
 
-	buf := new(bytes.Buffer)
-	err = tpl.Execute(buf, data)
-	if err != nil {
-		return "", fmt.Errorf("Error executing template: %v\n", err)
+	if len(ruleSet.BBox) != 4 {
+		C.GDALApplyGeoTransform((*C.double)(unsafe.Pointer(&geot[0])), 0, 0, &ulX, &ulY)
+		C.GDALApplyGeoTransform((*C.double)(unsafe.Pointer(&geot[0])), C.double(xSize), C.double(ySize), &lrX, &lrY)
+	} else {
+		ulX = C.double(ruleSet.BBox[0])
+		ulY = C.double(ruleSet.BBox[1])
+		lrX = C.double(ruleSet.BBox[2])
+		lrY = C.double(ruleSet.BBox[3])
+		geot[0] = ruleSet.BBox[0]
+		geot[3] = ruleSet.BBox[1]
 	}
-
-	return buf.String(), nil
-
+	return fmt.Sprintf("POLYGON ((%f %f,%f %f,%f %f,%f %f,%f %f))", ulX, ulY, ulX, lrY, lrX, lrY, lrX, ulY, ulX, ulY)
 }
 
 func getGeoLocation(geoLoc *GeoLocRule, path string) (*GeoLocInfo, error) {
@@ -550,43 +573,6 @@ func getGeoLocation(geoLoc *GeoLocRule, path string) (*GeoLocInfo, error) {
 	return locInfo, nil
 }
 
-func parseTime(nameFields map[string]string) time.Time {
-	if _, ok := nameFields["year"]; ok {
-		year, _ := strconv.Atoi(nameFields["year"])
-		t := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
-
-		if _, ok := nameFields["julian_day"]; ok {
-			julianDay, _ := strconv.Atoi(nameFields["julian_day"])
-			t = t.Add(time.Hour * 24 * time.Duration(julianDay-1))
-		}
-
-		if _, ok := nameFields["month"]; ok {
-			if _, ok := nameFields["day"]; ok {
-				month, _ := strconv.Atoi(nameFields["month"])
-				day, _ := strconv.Atoi(nameFields["day"])
-				t = time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
-			}
-		}
-
-		if _, ok := nameFields["hour"]; ok {
-			hour, _ := strconv.Atoi(nameFields["hour"])
-			t = t.Add(time.Hour * time.Duration(hour))
-		}
-
-		if _, ok := nameFields["minute"]; ok {
-			minute, _ := strconv.Atoi(nameFields["minute"])
-			t = t.Add(time.Minute * time.Duration(minute))
-		}
-
-		if _, ok := nameFields["second"]; ok {
-			second, _ := strconv.Atoi(nameFields["second"])
-			t = t.Add(time.Second * time.Duration(second))
-		}
-		return t
-	}
-	return time.Time{}
-}
-
 func getDate(inDate string) (time.Time, error) {
 	for _, dateFormat := range dateFormats {
 		if t, err := time.Parse(dateFormat, inDate); err == nil {
@@ -608,18 +594,14 @@ func getNCTime(sdsName string, hSubdataset C.GDALDatasetH, ruleSet *RuleSet) ([]
 
 	timeUnits := ruleSet.TimeUnits
 	if len(timeUnits) == 0 {
-		CtimeUnits := C.CString(fmt.Sprintf("%s#units", timeDim))
-		defer C.free(unsafe.Pointer(CtimeUnits))
-
-		idx := C.CSLFindName(metadata, CtimeUnits)
-		if idx == -1 {
+		units, found := ncCoordAttr(metadata, ncVarPath(sdsName), timeDim, "units")
+		if !found {
 			return nil, fmt.Errorf("Does not contain timeUnits string")
 		}
-
-		timeUnits = C.GoString(C.CSLFetchNameValue(metadata, CtimeUnits))
+		timeUnits = units
 	}
 	timeUnitsWords := strings.Split(timeUnits, " ")
-	if timeUnitsWords[1] != "since" {
+	if len(timeUnitsWords) < 3 || timeUnitsWords[1] != "since" {
 		return nil, fmt.Errorf("Cannot parse Units string")
 	}
 	if len(timeUnitsWords) == 3 {
@@ -663,6 +645,80 @@ func getNCTime(sdsName string, hSubdataset C.GDALDatasetH, ruleSet *RuleSet) ([]
 	return times, fmt.Errorf("Dataset %s doesn't contain times", sdsName)
 }
 
+// ncVarPath returns the full name of the variable of a netCDF subdataset,
+// e.g. /grp/sub/var of NETCDF:"file.nc":/grp/sub/var, the variables of
+// the root group having no leading /, or empty if not a subdataset.
+func ncVarPath(sdsName string) string {
+	if !strings.HasPrefix(sdsName, "NETCDF:") {
+		return ""
+	}
+	if i := strings.LastIndex(sdsName, `":`); i >= 0 {
+		return sdsName[i+2:]
+	}
+	parts := strings.Split(sdsName, ":")
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+// ncNameSpace returns the namespace of a netCDF variable, its name for
+// the variables of the root group, and its full name without the leading
+// / and with _ for the other characters than letters, digits and _ for
+// those of the groups, e.g. grp_sub_var of /grp/sub/var, for the
+// variables of the same names in different groups to be distinct layers.
+func ncNameSpace(varPath string) string {
+	if !strings.HasPrefix(varPath, "/") {
+		return varPath
+	}
+	return nonNameSpaceChars.ReplaceAllString(strings.TrimPrefix(varPath, "/"), "_")
+}
+
+// ncGroups returns the groups whose dimensions a netCDF variable sees,
+// its group then its ancestors to the root group, empty, e.g. /grp/sub,
+// /grp and the root of /grp/sub/var.
+func ncGroups(varPath string) []string {
+	var groups []string
+	for i := strings.LastIndex(varPath, "/"); i > 0; i = strings.LastIndex(varPath[:i], "/") {
+		groups = append(groups, varPath[:i])
+	}
+	return append(groups, "")
+}
+
+// ncCoordAttr returns the attribute of the coordinate variable of a
+// dimension seen by a netCDF variable, e.g. the units of time. GDAL keys
+// the attributes of the variables of the groups by their full names, e.g.
+// /grp/time#units, so the coordinate variable is looked up in the groups
+// of the variable, then in any group if it is unique, e.g. of a file
+// whose single variable is opened without its subdataset name.
+func ncCoordAttr(metadata **C.char, varPath, dim, attr string) (string, bool) {
+	for _, group := range ncGroups(varPath) {
+		name := dim
+		if len(group) > 0 {
+			name = group + "/" + dim
+		}
+		cKey := C.CString(name + "#" + attr)
+		value := C.CSLFetchNameValue(metadata, cKey)
+		C.free(unsafe.Pointer(cKey))
+		if value != nil {
+			return C.GoString(value), true
+		}
+	}
+
+	suffix := "/" + dim + "#" + attr + "="
+	var found []string
+	for i := C.int(0); i < C.CSLCount(metadata); i++ {
+		item := C.GoString(C.CSLGetField(metadata, i))
+		if j := strings.Index(item, suffix); j >= 0 && !strings.Contains(item[:j], "=") {
+			found = append(found, item[j+len(suffix):])
+		}
+	}
+	if len(found) != 1 {
+		return "", false
+	}
+	return found[0], true
+}
+
 func getNCAxes(sdsName string, hSubdataset C.GDALDatasetH, ruleSet *RuleSet) ([]*DatasetAxis, error) {
 	var axes []*DatasetAxis
 	mObj := C.GDALMajorObjectH(hSubdataset)
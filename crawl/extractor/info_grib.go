@@ -0,0 +1,217 @@
+package extractor
+
+/*
+#include <stdlib.h>
+#include "gdal.h"
+#cgo pkg-config: gdal
+*/
+import "C"
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// gribMessage is the metadata of a message of a GRIB file, a band of its
+// GDAL dataset.
+type gribMessage struct {
+	band      int
+	element   string
+	level     string
+	comment   string
+	unit      string
+	refTime   time.Time
+	validTime time.Time
+	forecast  int64
+}
+
+// gribGroup is the messages of a namespace, in the order of their valid
+// times.
+type gribGroup struct {
+	nameSpace string
+	messages  []*gribMessage
+}
+
+var nonNameSpaceChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+func bandMetadataItem(hBand C.GDALRasterBandH, key string) string {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+	if value := C.GDALGetMetadataItem(C.GDALMajorObjectH(hBand), cKey, nil); value != nil {
+		return strings.TrimSpace(C.GoString(value))
+	}
+	return ""
+}
+
+// gribSeconds parses the seconds of the GRIB metadata of GDAL, e.g.
+// 1577836800 or "1577836800 sec UTC" of the older versions.
+func gribSeconds(value string) (int64, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("missing seconds")
+	}
+	return strconv.ParseInt(fields[0], 10, 64)
+}
+
+func getGRIBMessage(hBand C.GDALRasterBandH, band int) (*gribMessage, error) {
+	msg := &gribMessage{
+		band:    band,
+		element: bandMetadataItem(hBand, "GRIB_ELEMENT"),
+		level:   bandMetadataItem(hBand, "GRIB_SHORT_NAME"),
+		comment: bandMetadataItem(hBand, "GRIB_COMMENT"),
+		unit:    bandMetadataItem(hBand, "GRIB_UNIT"),
+	}
+	if len(msg.element) == 0 {
+		return nil, fmt.Errorf("band %d: no GRIB_ELEMENT", band)
+	}
+	refTime, err := gribSeconds(bandMetadataItem(hBand, "GRIB_REF_TIME"))
+	if err != nil {
+		return nil, fmt.Errorf("band %d: invalid GRIB_REF_TIME: %v", band, err)
+	}
+	msg.refTime = time.Unix(refTime, 0).UTC()
+	if msg.forecast, err = gribSeconds(bandMetadataItem(hBand, "GRIB_FORECAST_SECONDS")); err != nil {
+		msg.forecast = 0
+	}
+	if validTime, err := gribSeconds(bandMetadataItem(hBand, "GRIB_VALID_TIME")); err == nil {
+		msg.validTime = time.Unix(validTime, 0).UTC()
+	} else {
+		msg.validTime = msg.refTime.Add(time.Duration(msg.forecast) * time.Second)
+	}
+	return msg, nil
+}
+
+// gribNameSpaces groups the messages by parameter and level, e.g. TMP at
+// 2-HTGL into the namespace TMP_2_HTGL, in the order of the parameters
+// in the file. The messages of the same parameter, level and valid time,
+// e.g. of the members of an ensemble, go to the namespaces suffixed with
+// their order, TMP_2_HTGL_2 for the second.
+func gribNameSpaces(messages []*gribMessage) []*gribGroup {
+	var groups []*gribGroup
+	byName := make(map[string]*gribGroup)
+	occurrences := make(map[string]int)
+	for _, msg := range messages {
+		nameSpace := msg.element
+		if len(msg.level) > 0 {
+			nameSpace += "_" + msg.level
+		}
+		nameSpace = nonNameSpaceChars.ReplaceAllString(nameSpace, "_")
+
+		key := fmt.Sprintf("%s@%d", nameSpace, msg.validTime.Unix())
+		occurrences[key]++
+		if n := occurrences[key]; n > 1 {
+			nameSpace = fmt.Sprintf("%s_%d", nameSpace, n)
+		}
+
+		g, found := byName[nameSpace]
+		if !found {
+			g = &gribGroup{nameSpace: nameSpace}
+			byName[nameSpace] = g
+			groups = append(groups, g)
+		}
+		g.messages = append(g.messages, msg)
+	}
+	for _, g := range groups {
+		sort.SliceStable(g.messages, func(i, j int) bool {
+			return g.messages[i].validTime.Before(g.messages[j].validTime)
+		})
+	}
+	return groups
+}
+
+// gribDataSetName returns the GDAL dataset of the bands of a namespace,
+// a vrt:// connection of GDAL 3.1 or later selecting the bands of the
+// messages unless they are all the bands of the file in order.
+func gribDataSetName(path string, messages []*gribMessage, nBands int) string {
+	bands := make([]string, len(messages))
+	contiguous := len(messages) == nBands
+	for i, msg := range messages {
+		bands[i] = strconv.Itoa(msg.band)
+		if msg.band != i+1 {
+			contiguous = false
+		}
+	}
+	if contiguous {
+		return path
+	}
+	return fmt.Sprintf("vrt://%s?bands=%s", path, strings.Join(bands, ","))
+}
+
+// getGRIBInfo returns the metadata of the messages of a GRIB file, one
+// dataset per parameter and level whose timestamps are the valid times
+// of its messages, with their reference times and forecast steps.
+func getGRIBInfo(path string, hDataset C.GDALDatasetH) ([]*GeoMetaData, error) {
+	nBands := int(C.GDALGetRasterCount(hDataset))
+	if nBands == 0 {
+		return nil, fmt.Errorf("no GRIB messages found: %v", path)
+	}
+	var messages []*gribMessage
+	for i := 1; i <= nBands; i++ {
+		msg, err := getGRIBMessage(C.GDALGetRasterBand(hDataset, C.int(i)), i)
+		if err != nil {
+			LogErr.Printf("error: %v, %v", path, err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	projWkt := C.GoString(C.GDALGetProjectionRef(hDataset))
+	proj4 := getProj4Text(projWkt)
+	dArr := [6]C.double{}
+	C.GDALGetGeoTransform(hDataset, &dArr[0])
+	geot := make([]float64, 6)
+	for i := range geot {
+		geot[i] = float64(dArr[i])
+	}
+	xSize := int(C.GDALGetRasterXSize(hDataset))
+	ySize := int(C.GDALGetRasterYSize(hDataset))
+	polyWkt := getGeometryWKT(geot, xSize, ySize, &RuleSet{})
+
+	var datasets []*GeoMetaData
+	for _, g := range gribNameSpaces(messages) {
+		hBand := C.GDALGetRasterBand(hDataset, C.int(g.messages[0].band))
+		var noData float64
+		var hasNoData C.int
+		if v := C.GDALGetRasterNoDataValue(hBand, &hasNoData); hasNoData != 0 {
+			noData = float64(v)
+		}
+
+		info := &GRIBInfo{Element: g.messages[0].element, Level: g.messages[0].level, Comment: g.messages[0].comment, Unit: g.messages[0].unit}
+		// The GRIB units are bracketed, e.g. [K]
+		bandValues := getBandValues(hBand)
+		if len(bandValues.Units) == 0 {
+			bandValues.Units = strings.TrimSpace(strings.Trim(info.Unit, "[]"))
+		}
+		var times []time.Time
+		for _, msg := range g.messages {
+			times = append(times, msg.validTime)
+			info.ReferenceTimes = append(info.ReferenceTimes, msg.refTime)
+			info.ForecastSeconds = append(info.ForecastSeconds, msg.forecast)
+		}
+
+		datasets = append(datasets, &GeoMetaData{
+			DataSetName:  gribDataSetName(path, g.messages, nBands),
+			NameSpace:    g.nameSpace,
+			Type:         C.GoString(C.GDALGetDataTypeName(C.GDALGetRasterDataType(hBand))),
+			RasterCount:  int32(len(g.messages)),
+			TimeStamps:   times,
+			XSize:        int32(xSize),
+			YSize:        int32(ySize),
+			Polygon:      polyWkt,
+			ProjWKT:      projWkt,
+			Proj4:        proj4,
+			GeoTransform: geot,
+			NoData:       noData,
+			GRIB:         info,
+			BandValues:   *bandValues,
+		})
+	}
+	if len(datasets) == 0 {
+		return nil, fmt.Errorf("no GRIB messages found: %v", path)
+	}
+	return datasets, nil
+}
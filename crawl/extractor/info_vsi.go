@@ -0,0 +1,143 @@
+package extractor
+
+/*
+#include <stdlib.h>
+#include "cpl_vsi.h"
+#include "cpl_string.h"
+#include "cpl_error.h"
+#cgo pkg-config: gdal
+
+int vsi_stat(const char *path, long long *size, long long *mtime, int *isDir) {
+	VSIStatBufL st;
+	if (VSIStatExL(path, &st, VSI_STAT_EXISTS_FLAG | VSI_STAT_NATURE_FLAG | VSI_STAT_SIZE_FLAG) != 0) {
+		return -1;
+	}
+	*size = st.st_size;
+	*mtime = st.st_mtime;
+	*isDir = VSI_ISDIR(st.st_mode);
+	return 0;
+}
+
+int vsi_read(const char *path, GByte **data, vsi_l_offset *size) {
+	return VSIIngestFile(NULL, path, data, size, -1);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// objectSchemes are the GDAL virtual file systems of the URIs of the
+// object stores, read with the credentials of their GDAL configuration
+// options, e.g. AWS_ACCESS_KEY_ID and AWS_S3_ENDPOINT for s3://.
+var objectSchemes = map[string]string{
+	"s3://":    "/vsis3/",
+	"gs://":    "/vsigs/",
+	"az://":    "/vsiaz/",
+	"azure://": "/vsiaz/",
+}
+
+// IsObjectPath reports whether a path is the URI of an object store or
+// the path of its GDAL virtual file system.
+func IsObjectPath(p string) bool {
+	for scheme, vsi := range objectSchemes {
+		if strings.HasPrefix(p, scheme) || strings.HasPrefix(p, vsi) {
+			return true
+		}
+	}
+	return false
+}
+
+// VSIPath returns the GDAL path of the URI of an object store, e.g.
+// /vsis3/bucket/key of s3://bucket/key, stored in MAS for the OWS
+// workers to read the objects directly. The other paths are returned as
+// is.
+func VSIPath(uri string) string {
+	for scheme, vsi := range objectSchemes {
+		if strings.HasPrefix(uri, scheme) {
+			return vsi + strings.TrimPrefix(uri, scheme)
+		}
+	}
+	return uri
+}
+
+// StatVSI returns the size and modification time of an object, to the
+// second, and whether it is a prefix of objects.
+func StatVSI(p string) (int64, time.Time, bool, error) {
+	cPath := C.CString(p)
+	defer C.free(unsafe.Pointer(cPath))
+
+	var size, mtime C.longlong
+	var isDir C.int
+	if C.vsi_stat(cPath, &size, &mtime, &isDir) != 0 {
+		return 0, time.Time{}, false, fmt.Errorf("stat %s failed: %s", p, C.GoString(C.CPLGetLastErrorMsg()))
+	}
+	return int64(size), time.Unix(int64(mtime), 0).UTC(), isDir != 0, nil
+}
+
+// ListVSI returns the GDAL paths of the objects under the prefix of an
+// object store, recursively, whose names match the shell pattern, as
+// find -name, if not empty.
+func ListVSI(prefix string, pattern string) ([]string, error) {
+	prefix = strings.TrimSuffix(VSIPath(prefix), "/")
+	cPrefix := C.CString(prefix)
+	defer C.free(unsafe.Pointer(cPrefix))
+
+	C.CPLErrorReset()
+	list := C.VSIReadDirRecursive(cPrefix)
+	if list == nil {
+		if msg := C.GoString(C.CPLGetLastErrorMsg()); len(msg) > 0 {
+			return nil, fmt.Errorf("list %s failed: %s", prefix, msg)
+		}
+		return nil, nil
+	}
+	defer C.CSLDestroy(list)
+
+	var paths []string
+	n := int(C.CSLCount(list))
+	for i := 0; i < n; i++ {
+		name := C.GoString(C.CSLGetField(list, C.int(i)))
+		// The prefixes are listed with a trailing slash.
+		if len(name) == 0 || strings.HasSuffix(name, "/") {
+			continue
+		}
+		if len(pattern) > 0 {
+			matched, err := filepath.Match(pattern, path.Base(name))
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		paths = append(paths, prefix+"/"+name)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// ReadVSI returns the content of an object, the error wrapping
+// os.ErrNotExist if the object is missing.
+func ReadVSI(p string) ([]byte, error) {
+	if _, _, _, err := StatVSI(p); err != nil {
+		return nil, fmt.Errorf("%w: %v", os.ErrNotExist, err)
+	}
+	cPath := C.CString(p)
+	defer C.free(unsafe.Pointer(cPath))
+
+	var data *C.GByte
+	var size C.vsi_l_offset
+	if C.vsi_read(cPath, &data, &size) == 0 {
+		return nil, fmt.Errorf("read %s failed: %s", p, C.GoString(C.CPLGetLastErrorMsg()))
+	}
+	defer C.VSIFree(unsafe.Pointer(data))
+	return C.GoBytes(unsafe.Pointer(data), C.int(size)), nil
+}
@@ -0,0 +1,71 @@
+package extractor
+
+/*
+#include <stdlib.h>
+#include "ogr_api.h"
+#include "ogr_srs_api.h"
+#cgo pkg-config: gdal
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// wgs84Segments is the number of the segments of each side of a polygon
+// densified before its transformation, for the bounding box to follow
+// the curves of the sides in longitudes and latitudes.
+const wgs84Segments = 32
+
+// WGS84BBox returns the bounding box in longitudes and latitudes, west,
+// south, east and north, of the polygon of a dataset in its projection,
+// e.g. for the STAC Items of the files.
+func WGS84BBox(polygonWKT, projWKT string) ([]float64, error) {
+	cWKT := C.CString(polygonWKT)
+	defer C.free(unsafe.Pointer(cWKT))
+
+	// OGR_G_CreateFromWkt advances the pointer to the WKT it is given.
+	wkt := cWKT
+	var geom C.OGRGeometryH
+	if C.OGR_G_CreateFromWkt(&wkt, nil, &geom) != C.OGRERR_NONE {
+		return nil, fmt.Errorf("invalid polygon %s", polygonWKT)
+	}
+	defer C.OGR_G_DestroyGeometry(geom)
+
+	if len(projWKT) > 0 {
+		cProjWKT := C.CString(projWKT)
+		defer C.free(unsafe.Pointer(cProjWKT))
+		srcSRS := C.OSRNewSpatialReference(cProjWKT)
+		if srcSRS == nil {
+			return nil, fmt.Errorf("invalid projection %s", projWKT)
+		}
+		defer C.OSRDestroySpatialReference(srcSRS)
+		dstSRS := C.OSRNewSpatialReference(nil)
+		defer C.OSRDestroySpatialReference(dstSRS)
+		cWGS84 := C.CString("WGS84")
+		defer C.free(unsafe.Pointer(cWGS84))
+		C.OSRSetWellKnownGeogCS(dstSRS, cWGS84)
+		C.OSRSetAxisMappingStrategy(srcSRS, C.OAMS_TRADITIONAL_GIS_ORDER)
+		C.OSRSetAxisMappingStrategy(dstSRS, C.OAMS_TRADITIONAL_GIS_ORDER)
+
+		if C.OSRIsSame(srcSRS, dstSRS) == 0 {
+			var env C.OGREnvelope
+			C.OGR_G_GetEnvelope(geom, &env)
+			C.OGR_G_Segmentize(geom, (env.MaxX-env.MinX+env.MaxY-env.MinY)/(2*wgs84Segments))
+
+			trans := C.OCTNewCoordinateTransformation(srcSRS, dstSRS)
+			if trans == nil {
+				return nil, fmt.Errorf("no transformation of %s to WGS84", projWKT)
+			}
+			defer C.OCTDestroyCoordinateTransformation(trans)
+			if C.OGR_G_Transform(geom, trans) != C.OGRERR_NONE {
+				return nil, fmt.Errorf("failed to transform %s to WGS84", polygonWKT)
+			}
+		}
+	}
+
+	var env C.OGREnvelope
+	C.OGR_G_GetEnvelope(geom, &env)
+	return []float64{float64(env.MinX), float64(env.MinY), float64(env.MaxX), float64(env.MaxY)}, nil
+}
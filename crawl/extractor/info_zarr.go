@@ -0,0 +1,215 @@
+package extractor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/zarr"
+)
+
+// zarrTypes are the GDAL types of the data types of the Zarr arrays.
+var zarrTypes = map[string]string{
+	"bool":    "Byte",
+	"int8":    "Byte",
+	"uint8":   "Byte",
+	"int16":   "Int16",
+	"uint16":  "UInt16",
+	"int32":   "Int32",
+	"uint32":  "UInt32",
+	"int64":   "Int64",
+	"uint64":  "UInt64",
+	"float32": "Float32",
+	"float64": "Float64",
+}
+
+// vsiStore is the Zarr store of a prefix of objects.
+type vsiStore struct {
+	prefix string
+}
+
+func (s *vsiStore) Get(key string) ([]byte, error) {
+	data, err := ReadVSI(s.prefix + "/" + key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v", zarr.ErrNotFound, err)
+	}
+	return data, err
+}
+
+func (s *vsiStore) Keys() ([]string, error) {
+	paths, err := ListVSI(s.prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(paths))
+	for i, p := range paths {
+		keys[i] = strings.TrimPrefix(p, s.prefix+"/")
+	}
+	return keys, nil
+}
+
+// IsZarrPath reports whether a path is of a Zarr store, named *.zarr.
+func IsZarrPath(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), ".zarr")
+}
+
+// ExtractZarr returns the metadata of the variables of the Zarr store of
+// a directory or of a prefix of objects, read from its metadata and
+// coordinate arrays without the Zarr driver of GDAL. The datasets are
+// named ZARR:"path":/array, opened by the Zarr driver of GDAL 3.4 or
+// later of the OWS workers.
+func ExtractZarr(path string) (*GeoFile, error) {
+	path = strings.TrimSuffix(path, "/")
+	var store zarr.Store = zarr.Dir(path)
+	if IsObjectPath(path) {
+		store = &vsiStore{prefix: path}
+	}
+	h, err := zarr.Open(store)
+	if err != nil {
+		return &GeoFile{}, fmt.Errorf("open Zarr store error: %v, %v", path, err)
+	}
+	vars, err := h.Variables()
+	if err != nil {
+		return &GeoFile{}, fmt.Errorf("Zarr store error: %v, %v", path, err)
+	}
+
+	datasets := []*GeoMetaData{}
+	for _, v := range vars {
+		dsInfo, err := getZarrInfo(path, v)
+		if err != nil {
+			LogErr.Printf("error: %v, %v, %v", path, v.Path, err)
+			continue
+		}
+		datasets = append(datasets, dsInfo)
+	}
+	if len(datasets) == 0 {
+		return &GeoFile{}, fmt.Errorf("no gridded variables found in Zarr store: %v", path)
+	}
+
+	geoFile := &GeoFile{FileName: path, Driver: "Zarr", DataSets: datasets}
+	if !IsObjectPath(path) {
+		fStat, fErr := os.Lstat(path)
+		if fErr != nil {
+			geoFile.PosixInfo = &PosixInfo{}
+		} else {
+			geoFile.PosixInfo = GetPosixInfo(path, fStat)
+			geoFile.PosixInfo.FilePath = ""
+		}
+	}
+	return geoFile, nil
+}
+
+func getZarrInfo(path string, v *zarr.Variable) (*GeoMetaData, error) {
+	dataType, found := zarrTypes[v.DataType]
+	if !found {
+		return nil, fmt.Errorf("data type %s not supported", v.DataType)
+	}
+	geot, err := zarr.GeoTransform(v.X.Values, v.Y.Values)
+	if err != nil {
+		return nil, err
+	}
+
+	projWkt := v.CRS
+	if len(projWkt) == 0 {
+		if !v.Geographic {
+			return nil, fmt.Errorf("no grid mapping of the CRS of the projected coordinates")
+		}
+		projWkt = SRSWGS84
+	}
+
+	var times []time.Time
+	if v.Time != nil {
+		units, _ := v.Time.Attrs["units"].(string)
+		calendar, _ := v.Time.Attrs["calendar"].(string)
+		if times, err = zarr.Times(v.Time.Values, units, calendar); err != nil {
+			return nil, fmt.Errorf("Error parsing dates: %v", err)
+		}
+	} else {
+		times = append(times, time.Time{})
+	}
+
+	// The axes are the dimensions before y and x, the bands of GDAL, the
+	// last one varying the fastest.
+	var axes []*DatasetAxis
+	numBands := 1
+	for _, c := range v.Axes {
+		axis := &DatasetAxis{Name: c.Name, Params: c.Values, Shape: []int{len(c.Values)}, Grid: "enum"}
+		if c == v.Time {
+			axis = &DatasetAxis{Name: "time", Shape: []int{len(c.Values)}, Grid: "default"}
+		}
+		axes = append(axes, axis)
+		numBands *= len(c.Values)
+	}
+	accumStrides := 1
+	for i := len(axes) - 1; i >= 0; i-- {
+		axes[i].Strides = []int{accumStrides}
+		accumStrides *= axes[i].Shape[0]
+	}
+
+	xSize, ySize := len(v.X.Values), len(v.Y.Values)
+	// The NaN fill values, not of JSON, are left to the NaN checks of
+	// the workers.
+	var noData float64
+	if fill, ok := v.Attrs["_FillValue"].(float64); ok {
+		noData = fill
+	} else if v.FillValue != nil && !math.IsNaN(*v.FillValue) && !math.IsInf(*v.FillValue, 0) {
+		noData = *v.FillValue
+	}
+	standardName, _ := v.Attrs["standard_name"].(string)
+
+	return &GeoMetaData{
+		DataSetName:  fmt.Sprintf(`ZARR:"%s":%s`, path, v.Path),
+		NameSpace:    strings.TrimPrefix(v.Path, "/"),
+		Type:         dataType,
+		RasterCount:  int32(numBands),
+		TimeStamps:   times,
+		XSize:        int32(xSize),
+		YSize:        int32(ySize),
+		Polygon:      getGeometryWKT(geot, xSize, ySize, &RuleSet{}),
+		ProjWKT:      projWkt,
+		Proj4:        getProj4Text(projWkt),
+		GeoTransform: geot,
+		NoData:       noData,
+		Axes:         axes,
+		StandardName: strings.TrimSpace(standardName),
+		Chunks:       v.Chunks,
+		BandValues:   zarrBandValues(v.Attrs),
+	}, nil
+}
+
+// zarrBandValues returns the band values of the CF attributes of a Zarr
+// array, its values read packed.
+func zarrBandValues(attrs map[string]interface{}) BandValues {
+	var bv BandValues
+	if units, ok := attrs["units"].(string); ok {
+		bv.Units = strings.TrimSpace(units)
+	}
+	if scale, ok := attrs["scale_factor"].(float64); ok && scale != 0 && scale != 1 {
+		bv.ScaleFactor = &scale
+	}
+	if offset, ok := attrs["add_offset"].(float64); ok && offset != 0 {
+		bv.AddOffset = &offset
+	}
+
+	attrString := func(name string) string {
+		switch v := attrs[name].(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		case []interface{}:
+			var values []string
+			for _, e := range v {
+				if f, ok := e.(float64); ok {
+					values = append(values, strconv.FormatFloat(f, 'g', -1, 64))
+				}
+			}
+			return strings.Join(values, ",")
+		}
+		return ""
+	}
+	bv.ValidRange = parseValidRange(attrString("valid_range"), attrString("valid_min"), attrString("valid_max"))
+	return bv
+}
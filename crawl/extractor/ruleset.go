@@ -1,11 +1,27 @@
 package extractor
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+)
+
 const (
 	NSPath    string = "ns_path"
 	NSDataset string = "ns_dataset"
 	NSCombine string = "ns_combine"
 )
 
+// TimeSourcePath is the time_source of the rule sets whose timestamps are
+// those of the path, rather than the embedded ones.
+const TimeSourcePath string = "path"
+
 type GeoLocRule struct {
 	XDatasetPattern  string `json:"x_dataset_pattern"`
 	XDatasetTemplate string `json:"x_dataset_template"`
@@ -34,6 +50,21 @@ type RuleSet struct {
 	AxesText      []*DatasetAxis `json:"axes_text,omitempty"`
 	NcMetadata    bool           `json:"nc_metadata"`
 	MatchFullPath bool           `json:"match_full_path"`
+
+	// TimeLayout is the Go layout of the time field of the pattern, e.g.
+	// 20060102 for (?P<time>\d{8}), instead of its year, month, day...
+	// fields. TimeSource, if path, replaces the embedded timestamps with
+	// the time of the path, which otherwise only stands for the missing
+	// ones.
+	TimeLayout string `json:"time_layout"`
+	TimeSource string `json:"time_source"`
+	// NameSpaceTemplate is the template of the namespace, replacing that
+	// of NameSpace, of the fields of the pattern and of the dataset name,
+	// e.g. {{.dataset}}_{{.member}}.
+	NameSpaceTemplate string `json:"namespace_template"`
+	// RecordFields records the fields of the pattern in the path_fields
+	// of the datasets, e.g. the ensemble member or the region.
+	RecordFields bool `json:"record_fields"`
 }
 
 /***** An example config file for the eReefs dataset
@@ -57,6 +88,233 @@ type Config struct {
 	RuleSets []RuleSet `json:"rule_sets"`
 }
 
+// Validate checks the patterns and the templates of the rule sets, for
+// the crawls to fail before extracting the first file.
+func (c *Config) Validate() error {
+	for i, ruleSet := range c.RuleSets {
+		if _, err := regexp.Compile(ruleSet.Pattern); err != nil {
+			return fmt.Errorf("rule set %d: invalid pattern: %v", i, err)
+		}
+		if len(ruleSet.TimeSource) > 0 && ruleSet.TimeSource != TimeSourcePath {
+			return fmt.Errorf("rule set %d: invalid time_source %s, expected path", i, ruleSet.TimeSource)
+		}
+		if len(ruleSet.NameSpaceTemplate) > 0 {
+			if _, err := template.New("namespace").Parse(ruleSet.NameSpaceTemplate); err != nil {
+				return fmt.Errorf("rule set %d: invalid namespace_template: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func copyRuleSet(dst interface{}, src interface{}) error {
+	if dst == nil {
+		return fmt.Errorf("dst cannot be nil")
+	}
+	if src == nil {
+		return fmt.Errorf("src cannot be nil")
+	}
+	bytes, err := json.Marshal(src)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal src: %s", err)
+	}
+	err = json.Unmarshal(bytes, dst)
+	if err != nil {
+		return fmt.Errorf("Unable to unmarshal into dst: %s", err)
+	}
+	return nil
+}
+
+// parseName returns the first rule set whose pattern matches a path, of
+// the config or else of CollectionRuleSets, with the fields of the
+// pattern and the time of the path. The paths matching none of the rule
+// sets of a config get the default rule set.
+func parseName(path string, config *Config) (*RuleSet, map[string]string, time.Time) {
+	_, basename := filepath.Split(path)
+
+	ruleSets := CollectionRuleSets
+	if len(config.RuleSets) > 0 {
+		ruleSets = config.RuleSets
+	}
+
+	for _, ruleSet := range ruleSets {
+		re, err := regexp.Compile(ruleSet.Pattern)
+		if err != nil {
+			LogErr.Printf("invalid pattern %s: %v", ruleSet.Pattern, err)
+			continue
+		}
+
+		fname := basename
+		if ruleSet.MatchFullPath {
+			fname = path
+		}
+		if re.MatchString(fname) {
+			match := re.FindStringSubmatch(fname)
+
+			result := make(map[string]string)
+			for i, name := range re.SubexpNames() {
+				if i != 0 {
+					result[name] = match[i]
+				}
+			}
+			newRuleSet := RuleSet{}
+			copyRuleSet(&newRuleSet, &ruleSet)
+
+			timeStamp := parseTime(result)
+			if len(ruleSet.TimeLayout) > 0 && len(result["time"]) > 0 {
+				if timeStamp, err = time.ParseInLocation(ruleSet.TimeLayout, result["time"], time.UTC); err != nil {
+					LogErr.Printf("error: %v, %v", path, err)
+				}
+			}
+			return &newRuleSet, result, timeStamp
+		}
+	}
+	return &RuleSet{NameSpace: NSDataset, Pattern: `.+`, TimeAxis: &DatasetAxis{}}, map[string]string{}, time.Time{}
+}
+
+func getRegexMatches(source string, pattern string) map[string]string {
+	result := make(map[string]string)
+	re := regexp.MustCompile(pattern)
+	if re.MatchString(source) {
+		match := re.FindStringSubmatch(source)
+		for i, name := range re.SubexpNames() {
+			if i != 0 {
+				result[name] = match[i]
+			}
+		}
+	}
+
+	return result
+}
+
+func instantiateTemplate(tplText string, data interface{}) (string, error) {
+	tpl, err := template.New("template").Parse(tplText)
+	if err != nil {
+		return "", fmt.Errorf("Error trying to parse template document: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = tpl.Execute(buf, data)
+	if err != nil {
+		return "", fmt.Errorf("Error executing template: %v\n", err)
+	}
+
+	return buf.String(), nil
+
+}
+
+func parseTime(nameFields map[string]string) time.Time {
+	if _, ok := nameFields["year"]; ok {
+		year, _ := strconv.Atoi(nameFields["year"])
+		t := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		if _, ok := nameFields["julian_day"]; ok {
+			julianDay, _ := strconv.Atoi(nameFields["julian_day"])
+			t = t.Add(time.Hour * 24 * time.Duration(julianDay-1))
+		}
+
+		if _, ok := nameFields["month"]; ok {
+			if _, ok := nameFields["day"]; ok {
+				month, _ := strconv.Atoi(nameFields["month"])
+				day, _ := strconv.Atoi(nameFields["day"])
+				t = time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+			}
+		}
+
+		if _, ok := nameFields["hour"]; ok {
+			hour, _ := strconv.Atoi(nameFields["hour"])
+			t = t.Add(time.Hour * time.Duration(hour))
+		}
+
+		if _, ok := nameFields["minute"]; ok {
+			minute, _ := strconv.Atoi(nameFields["minute"])
+			t = t.Add(time.Minute * time.Duration(minute))
+		}
+
+		if _, ok := nameFields["second"]; ok {
+			second, _ := strconv.Atoi(nameFields["second"])
+			t = t.Add(time.Second * time.Duration(second))
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// ruleSetNameSpace returns the namespace of a dataset of a path, by the
+// namespace, the namespace_template and the fields of the pattern of the
+// rule set, with the fields recorded if record_fields.
+func ruleSetNameSpace(ruleSet *RuleSet, nameFields map[string]string, nsDataset string) (string, map[string]string, error) {
+	var nameSpace string
+	nsPath := nameFields["namespace"]
+
+	switch ruleSet.NameSpace {
+
+	case NSCombine:
+		nameSpace = fmt.Sprintf("%s:%s", nsPath, nsDataset)
+
+	case NSPath:
+		nameSpace = nsPath
+
+	case NSDataset:
+		nameSpace = nsDataset
+
+	}
+
+	fields := map[string]string{}
+	for name, value := range nameFields {
+		if len(name) > 0 {
+			fields[name] = value
+		}
+	}
+	if len(ruleSet.NameSpaceTemplate) > 0 {
+		data := map[string]string{"dataset": nsDataset}
+		for name, value := range fields {
+			data[name] = value
+		}
+		var err error
+		if nameSpace, err = instantiateTemplate(ruleSet.NameSpaceTemplate, data); err != nil {
+			return "", nil, err
+		}
+	}
+	var pathFields map[string]string
+	if ruleSet.RecordFields {
+		pathFields = fields
+	}
+	return nameSpace, pathFields, nil
+}
+
+// datasetTimes returns the timestamps of a dataset, those embedded in
+// the file, ncTimes, unless their extraction failed, else the times_text
+// of the rule set, else the time of the path. The time of the path
+// replaces the embedded timestamps with the time_source path, and stands
+// for the missing ones.
+func datasetTimes(ruleSet *RuleSet, pathTime time.Time, ncTimes []string, ncErr error) []time.Time {
+	var times []time.Time
+	pathTimes := !pathTime.IsZero() && (ruleSet.TimeSource == TimeSourcePath || (ncTimes != nil && len(ncTimes) == 0))
+	if ncErr == nil && ncTimes != nil && !pathTimes {
+		for _, timestr := range ncTimes {
+			t, err := time.ParseInLocation("2006-01-02T15:04:05Z", timestr, time.UTC)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			times = append(times, t)
+		}
+	} else if len(ruleSet.TimesText) > 0 && !pathTimes {
+		for _, ts := range ruleSet.TimesText {
+			t, err := time.ParseInLocation("2006-01-02T15:04:05Z", ts, time.UTC)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			times = append(times, t)
+		}
+	} else {
+		times = append(times, pathTime)
+	}
+	return times
+}
+
 const (
 	SRSDetect string = ""
 	SRSWGS84  string = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],TOWGS84[0,0,0,0,0,0,0],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9108"]],AUTHORITY["EPSG","4326"]]`
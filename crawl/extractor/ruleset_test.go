@@ -0,0 +1,167 @@
+package extractor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseName(t *testing.T) {
+	config := &Config{RuleSets: []RuleSet{
+		{
+			Pattern:           `^pr_(?P<member>r\d+i\d+p\d+)_(?P<region>[A-Z]+)_(?P<time>\d{8})\.nc$`,
+			NameSpace:         NSDataset,
+			TimeLayout:        "20060102",
+			NameSpaceTemplate: "{{.dataset}}_{{.member}}",
+		},
+		{
+			Pattern:       `/g/data/(?P<namespace>[a-z]+)/(?P<year>\d{4})/(?P<julian_day>\d{3})/`,
+			NameSpace:     NSPath,
+			MatchFullPath: true,
+		},
+		{
+			Pattern:   `_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})T(?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})\.tif$`,
+			NameSpace: NSDataset,
+		},
+	}}
+
+	tests := []struct {
+		path    string
+		pattern string
+		fields  map[string]string
+		time    time.Time
+	}{
+		{
+			path:    "/g/data/cordex/pr_r1i1p1_EA_20200131.nc",
+			pattern: config.RuleSets[0].Pattern,
+			fields:  map[string]string{"member": "r1i1p1", "region": "EA", "time": "20200131"},
+			time:    time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			path:    "/g/data/modis/2020/032/tile.hdf",
+			pattern: config.RuleSets[1].Pattern,
+			fields:  map[string]string{"namespace": "modis", "year": "2020", "julian_day": "032"},
+			time:    time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			path:    "/g/data/s2/T37_20200131T073021.tif",
+			pattern: config.RuleSets[2].Pattern,
+			fields:  map[string]string{"year": "2020", "month": "01", "day": "31", "hour": "07", "minute": "30", "second": "21"},
+			time:    time.Date(2020, 1, 31, 7, 30, 21, 0, time.UTC),
+		},
+		{
+			path:    "/g/data/other/file.nc",
+			pattern: `.+`,
+			fields:  map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		ruleSet, fields, timeStamp := parseName(test.path, config)
+		if ruleSet.Pattern != test.pattern {
+			t.Errorf("%s: expected the rule set of %s, got %s", test.path, test.pattern, ruleSet.Pattern)
+		}
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("%s: expected the fields %v, got %v", test.path, test.fields, fields)
+		}
+		if !timeStamp.Equal(test.time) {
+			t.Errorf("%s: expected the time %v, got %v", test.path, test.time, timeStamp)
+		}
+	}
+
+	// the rule set returned is a copy
+	ruleSet, _, _ := parseName(tests[0].path, config)
+	ruleSet.NameSpace = NSPath
+	if config.RuleSets[0].NameSpace != NSDataset {
+		t.Errorf("expected the rule set of the config to be left as is")
+	}
+
+	// without rule sets, those of the collections apply
+	ruleSet, fields, timeStamp := parseName("/g/data/chirps/chirps-v2.0.2020.dekads.nc", &Config{})
+	if ruleSet.Collection != "chirps2.0" || fields["namespace"] != "chirps" || timeStamp.Year() != 2020 {
+		t.Errorf("expected the chirps2.0 rule set, got %s %v %v", ruleSet.Collection, fields, timeStamp)
+	}
+}
+
+func TestRuleSetNameSpace(t *testing.T) {
+	fields := map[string]string{"": "", "namespace": "precip", "member": "r1i1p1"}
+	tests := []struct {
+		ruleSet    RuleSet
+		nameSpace  string
+		pathFields map[string]string
+	}{
+		{ruleSet: RuleSet{NameSpace: NSDataset}, nameSpace: "pr"},
+		{ruleSet: RuleSet{NameSpace: NSPath}, nameSpace: "precip"},
+		{ruleSet: RuleSet{NameSpace: NSCombine}, nameSpace: "precip:pr"},
+		{
+			ruleSet:    RuleSet{NameSpace: NSDataset, NameSpaceTemplate: "{{.dataset}}_{{.member}}", RecordFields: true},
+			nameSpace:  "pr_r1i1p1",
+			pathFields: map[string]string{"namespace": "precip", "member": "r1i1p1"},
+		},
+	}
+	for _, test := range tests {
+		nameSpace, pathFields, err := ruleSetNameSpace(&test.ruleSet, fields, "pr")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nameSpace != test.nameSpace {
+			t.Errorf("%s %s: expected the namespace %s, got %s", test.ruleSet.NameSpace, test.ruleSet.NameSpaceTemplate, test.nameSpace, nameSpace)
+		}
+		if !reflect.DeepEqual(pathFields, test.pathFields) {
+			t.Errorf("%s %s: expected the path fields %v, got %v", test.ruleSet.NameSpace, test.ruleSet.NameSpaceTemplate, test.pathFields, pathFields)
+		}
+	}
+
+	if _, _, err := ruleSetNameSpace(&RuleSet{NameSpaceTemplate: "{{.dataset"}, fields, "pr"); err == nil {
+		t.Errorf("expected an error of an invalid template")
+	}
+}
+
+func TestDatasetTimes(t *testing.T) {
+	pathTime := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	ncTime := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	ncTimes := []string{"2019-06-01T12:00:00Z"}
+	textTime := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		ruleSet  RuleSet
+		pathTime time.Time
+		ncTimes  []string
+		ncErr    error
+		times    []time.Time
+	}{
+		{name: "embedded", pathTime: pathTime, ncTimes: ncTimes, times: []time.Time{ncTime}},
+		{name: "time_source path", ruleSet: RuleSet{TimeSource: TimeSourcePath}, pathTime: pathTime, ncTimes: ncTimes, times: []time.Time{pathTime}},
+		{name: "missing embedded", pathTime: pathTime, ncTimes: []string{}, times: []time.Time{pathTime}},
+		{name: "no file times", pathTime: pathTime, times: []time.Time{pathTime}},
+		{name: "times_text", ruleSet: RuleSet{TimesText: []string{"2018-01-01T00:00:00Z"}}, pathTime: pathTime, times: []time.Time{textTime}},
+		{name: "failed extraction", ruleSet: RuleSet{TimesText: []string{"2018-01-01T00:00:00Z"}}, ncTimes: ncTimes, ncErr: errors.New("no time"), times: []time.Time{textTime}},
+		{name: "no time", times: []time.Time{{}}},
+	}
+	for _, test := range tests {
+		times := datasetTimes(&test.ruleSet, test.pathTime, test.ncTimes, test.ncErr)
+		if !reflect.DeepEqual(times, test.times) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.times, times)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		ruleSet RuleSet
+		valid   bool
+	}{
+		{ruleSet: RuleSet{Pattern: `^(?P<time>\d{8})\.nc$`, TimeSource: TimeSourcePath, NameSpaceTemplate: "{{.dataset}}"}, valid: true},
+		{ruleSet: RuleSet{Pattern: `^(?P<time>\d{8}\.nc$`}},
+		{ruleSet: RuleSet{Pattern: `.+`, TimeSource: "file"}},
+		{ruleSet: RuleSet{Pattern: `.+`, NameSpaceTemplate: "{{.dataset"}},
+	}
+	for _, test := range tests {
+		config := &Config{RuleSets: []RuleSet{test.ruleSet}}
+		if err := config.Validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid %v, got %v", test.ruleSet, test.valid, err)
+		}
+	}
+}
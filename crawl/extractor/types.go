@@ -3,31 +3,75 @@ package extractor
 import "time"
 
 type Overview struct {
-	XSize int32 `json:"x_size"`
-	YSize int32 `json:"y_size"`
+	XSize      int32 `json:"x_size"`
+	YSize      int32 `json:"y_size"`
+	BlockXSize int32 `json:"block_x_size,omitempty"`
+	BlockYSize int32 `json:"block_y_size,omitempty"`
+}
+
+// ImageStructure is the layout of the pixels of a dataset in its file, of
+// the IMAGE_STRUCTURE metadata of GDAL, e.g. the DEFLATE compressed tiles
+// of 512x512 of a cloud optimized GeoTIFF, whose layout is COG.
+type ImageStructure struct {
+	Layout      string `json:"layout,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	Interleave  string `json:"interleave,omitempty"`
+	BlockXSize  int32  `json:"block_x_size"`
+	BlockYSize  int32  `json:"block_y_size"`
 }
 
 type GeoMetaData struct {
-	DataSetName  string         `json:"ds_name"`
-	NameSpace    string         `json:"namespace,omitempty"`
-	Type         string         `json:"array_type"`
-	RasterCount  int32          `json:"raster_count"`
-	TimeStamps   []time.Time    `json:"timestamps"`
-	Overviews    []*Overview    `json:"overviews,omitempty"`
-	XSize        int32          `json:"x_size"`
-	YSize        int32          `json:"y_size"`
-	GeoTransform []float64      `json:"geotransform"`
-	Polygon      string         `json:"polygon"`
-	ProjWKT      string         `json:"proj_wkt"`
-	Proj4        string         `json:"proj4"`
-	Mins         []float64      `json:"mins,omitempty"`
-	Maxs         []float64      `json:"maxs,omitempty"`
-	Means        []float64      `json:"means,omitempty"`
-	StdDevs      []float64      `json:"stddevs,omitempty"`
-	SampleCounts []int          `json:"sample_counts,omitempty"`
-	NoData       float64        `json:"nodata,omitempty"`
-	Axes         []*DatasetAxis `json:"axes,omitempty"`
-	GeoLocation  *GeoLocInfo    `json:"geo_loc,omitempty"`
+	DataSetName  string            `json:"ds_name"`
+	NameSpace    string            `json:"namespace,omitempty"`
+	Type         string            `json:"array_type"`
+	RasterCount  int32             `json:"raster_count"`
+	TimeStamps   []time.Time       `json:"timestamps"`
+	Overviews    []*Overview       `json:"overviews,omitempty"`
+	Structure    *ImageStructure   `json:"image_structure,omitempty"`
+	XSize        int32             `json:"x_size"`
+	YSize        int32             `json:"y_size"`
+	GeoTransform []float64         `json:"geotransform"`
+	Polygon      string            `json:"polygon"`
+	ProjWKT      string            `json:"proj_wkt"`
+	Proj4        string            `json:"proj4"`
+	Mins         []float64         `json:"mins,omitempty"`
+	Maxs         []float64         `json:"maxs,omitempty"`
+	Means        []float64         `json:"means,omitempty"`
+	StdDevs      []float64         `json:"stddevs,omitempty"`
+	SampleCounts []int             `json:"sample_counts,omitempty"`
+	NoData       float64           `json:"nodata,omitempty"`
+	Axes         []*DatasetAxis    `json:"axes,omitempty"`
+	GeoLocation  *GeoLocInfo       `json:"geo_loc,omitempty"`
+	StandardName string            `json:"standard_name,omitempty"`
+	Chunks       []int             `json:"chunks,omitempty"`
+	PathFields   map[string]string `json:"path_fields,omitempty"`
+	GRIB         *GRIBInfo         `json:"grib,omitempty"`
+	// BandValues are those of the first band, Bands those of each band
+	// if they differ across the bands.
+	BandValues
+	Bands []*BandValues `json:"bands,omitempty"`
+}
+
+// BandValues are the units of the values of a band, the scale factor and
+// the offset decoding its packed values, nil if not packed, and the valid
+// range of its packed values.
+type BandValues struct {
+	Units       string    `json:"units,omitempty"`
+	ScaleFactor *float64  `json:"scale_factor,omitempty"`
+	AddOffset   *float64  `json:"add_offset,omitempty"`
+	ValidRange  []float64 `json:"valid_range,omitempty"`
+}
+
+// GRIBInfo is the parameter and the level of the GRIB messages of a
+// dataset, with their reference times and forecast steps in the order
+// of its timestamps, their valid times.
+type GRIBInfo struct {
+	Element         string      `json:"element"`
+	Level           string      `json:"level"`
+	Comment         string      `json:"comment,omitempty"`
+	Unit            string      `json:"unit,omitempty"`
+	ReferenceTimes  []time.Time `json:"reference_times"`
+	ForecastSeconds []int64     `json:"forecast_seconds"`
 }
 
 type GeoLocInfo struct {
@@ -54,6 +98,12 @@ type GeoFile struct {
 	Driver    string         `json:"file_type"`
 	DataSets  []*GeoMetaData `json:"geo_metadata"`
 	PosixInfo *PosixInfo     `json:"posix_info,omitempty"`
+	// Checksum is the digest of the content of the file, e.g.
+	// sha256:<hex>, if computed by the crawler.
+	Checksum string `json:"checksum,omitempty"`
+	// Dedup is how MAS ingests the file if of the same checksum as a
+	// file already recorded, link or flag, if any.
+	Dedup string `json:"dedup,omitempty"`
 }
 
 type PosixInfo struct {
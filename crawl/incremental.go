@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	extr "github.com/nci/gsky/crawl/extractor"
+	"github.com/nci/gsky/tokens"
+)
+
+// crawledPageSize is the number of files of each ?crawled query.
+const crawledPageSize = 1000
+
+// crawledFile is a file of the ?crawled responses of MAS, with the size
+// and mtime of its posix_info and its checksum when crawled, if any.
+type crawledFile struct {
+	FilePath string `json:"file_path"`
+	Size     *int64 `json:"size"`
+	MTime    string `json:"mtime"`
+	Checksum string `json:"checksum"`
+	Ingested string `json:"ingested"`
+}
+
+// masClient queries the files crawled before from MAS.
+type masClient struct {
+	address string
+	apiKey  string
+	client  *http.Client
+}
+
+// crawled returns the files of MAS directly under dir by path, or
+// under its subdirectories too if recursive.
+func (c *masClient) crawled(dir string, recursive bool) (map[string]*crawledFile, error) {
+	files := make(map[string]*crawledFile)
+	token := ""
+	for {
+		query := url.Values{"limit": {fmt.Sprint(crawledPageSize)}}
+		if len(token) > 0 {
+			query.Set("next_token", token)
+		}
+		if recursive {
+			query.Set("recursive", "")
+		}
+		u := strings.TrimSuffix(c.address, "/") + (&url.URL{Path: dir}).EscapedPath() + "?crawled&" + query.Encode()
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.apiKey) > 0 {
+			req.Header.Set(tokens.APIKeyHeader, c.apiKey)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("MAS query of %s failed: %s: %s", dir, resp.Status, strings.TrimSpace(string(body)))
+		}
+
+		var page struct {
+			Files     []*crawledFile `json:"files"`
+			NextToken string         `json:"next_token"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("MAS query of %s failed: %v", dir, err)
+		}
+		for _, f := range page.Files {
+			files[f.FilePath] = f
+		}
+		if len(page.NextToken) == 0 {
+			return files, nil
+		}
+		token = page.NextToken
+	}
+}
+
+// posixInfo returns the posix_info of a file or of an object, the size
+// and mtime of the objects only being known.
+func posixInfo(path string) (*extr.PosixInfo, error) {
+	if extr.IsObjectPath(path) {
+		size, mtime, _, err := extr.StatVSI(path)
+		if err != nil {
+			return nil, err
+		}
+		return &extr.PosixInfo{FilePath: path, Size: size, MTime: mtime}, nil
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return extr.GetPosixInfo(path, stat), nil
+}
+
+// changed reports whether a file is new or changed since crawled. The
+// files crawled with their posix_info are changed if their size or mtime
+// differ, the others if modified after their ingestion.
+func changed(f *crawledFile, info *extr.PosixInfo) bool {
+	if f == nil {
+		return true
+	}
+	if f.Size != nil && len(f.MTime) > 0 {
+		mtime, err := time.Parse(time.RFC3339Nano, f.MTime)
+		return err != nil || *f.Size != info.Size || !mtime.Equal(info.MTime)
+	}
+	ingested, err := time.Parse(time.RFC3339, f.Ingested)
+	return err != nil || !info.MTime.Before(ingested)
+}
+
+// crawledDirs caches the files crawled of the directories of MAS, each
+// directory or prefix of objects being queried once.
+type crawledDirs struct {
+	c    *masClient
+	dirs map[string]map[string]*crawledFile
+}
+
+func newCrawledDirs(c *masClient) *crawledDirs {
+	return &crawledDirs{c: c, dirs: make(map[string]map[string]*crawledFile)}
+}
+
+// lookup returns the file of MAS of a path, nil if not crawled.
+func (d *crawledDirs) lookup(path string) (*crawledFile, error) {
+	dir := pathpkg.Dir(path)
+	files, found := d.dirs[dir]
+	if !found {
+		var err error
+		if files, err = d.c.crawled(dir, false); err != nil {
+			return nil, err
+		}
+		d.dirs[dir] = files
+	}
+	return files[path], nil
+}
+
+// incrementalPaths returns the paths of pathList new or changed since
+// crawled, querying MAS once per directory or prefix of objects. The
+// paths which cannot be stat are kept, for their extraction to report
+// the error.
+func incrementalPaths(c *masClient, pathList []string) ([]string, error) {
+	dirs := newCrawledDirs(c)
+	var paths []string
+	for _, path := range pathList {
+		info, err := posixInfo(path)
+		if err != nil {
+			paths = append(paths, path)
+			continue
+		}
+		f, err := dirs.lookup(path)
+		if err != nil {
+			return nil, err
+		}
+		if changed(f, info) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	extr "github.com/nci/gsky/crawl/extractor"
+)
+
+func TestChanged(t *testing.T) {
+	mtime := time.Date(2020, 1, 31, 6, 0, 0, 500, time.UTC)
+	info := &extr.PosixInfo{Size: 100, MTime: mtime}
+	size := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name    string
+		f       *crawledFile
+		changed bool
+	}{
+		{"new", nil, true},
+		{"same", &crawledFile{Size: size(100), MTime: mtime.Format(time.RFC3339Nano)}, false},
+		{"same in another zone", &crawledFile{Size: size(100), MTime: mtime.In(time.FixedZone("EAT", 3*3600)).Format(time.RFC3339Nano)}, false},
+		{"size", &crawledFile{Size: size(101), MTime: mtime.Format(time.RFC3339Nano)}, true},
+		{"mtime", &crawledFile{Size: size(100), MTime: mtime.Add(time.Second).Format(time.RFC3339Nano)}, true},
+		{"invalid mtime", &crawledFile{Size: size(100), MTime: "yesterday"}, true},
+		{"ingested after", &crawledFile{Ingested: mtime.Add(time.Hour).Format(time.RFC3339)}, false},
+		{"ingested before", &crawledFile{Ingested: mtime.Add(-time.Hour).Format(time.RFC3339)}, true},
+		{"size without mtime", &crawledFile{Size: size(100), Ingested: mtime.Add(time.Hour).Format(time.RFC3339)}, false},
+		{"never ingested", &crawledFile{}, true},
+	}
+	for _, test := range tests {
+		if got := changed(test.f, info); got != test.changed {
+			t.Errorf("%s: expected changed %v, got %v", test.name, test.changed, got)
+		}
+	}
+}
+
+// fakeMAS answers the ?crawled queries of its files by directory, in
+// pages of pageSize files.
+type fakeMAS struct {
+	files    []*crawledFile
+	pageSize int
+	queries  []string
+}
+
+func (m *fakeMAS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.queries = append(m.queries, r.URL.Path)
+	_, recursive := r.URL.Query()["recursive"]
+	var files []*crawledFile
+	for _, f := range m.files {
+		if filepath.Dir(f.FilePath) == r.URL.Path || recursive && strings.HasPrefix(f.FilePath, r.URL.Path+"/") {
+			files = append(files, f)
+		}
+	}
+	start := 0
+	if token := r.FormValue("next_token"); len(token) > 0 {
+		for i, f := range files {
+			if f.FilePath == token {
+				start = i + 1
+			}
+		}
+	}
+	page := struct {
+		Files     []*crawledFile `json:"files"`
+		NextToken string         `json:"next_token,omitempty"`
+	}{Files: files[start:]}
+	if m.pageSize > 0 && len(page.Files) > m.pageSize {
+		page.Files = page.Files[:m.pageSize]
+		page.NextToken = page.Files[m.pageSize-1].FilePath
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func TestMASClientCrawled(t *testing.T) {
+	mas := &fakeMAS{pageSize: 2, files: []*crawledFile{
+		{FilePath: "/g/data/chirps/2020.tif"},
+		{FilePath: "/g/data/chirps/2021.tif"},
+		{FilePath: "/g/data/chirps/2022.tif"},
+		{FilePath: "/g/data/chirps/monthly/2020.tif"},
+		{FilePath: "/g/data/tamsat/2020.nc"},
+	}}
+	srv := httptest.NewServer(mas)
+	defer srv.Close()
+	c := &masClient{address: srv.URL + "/", client: srv.Client()}
+
+	files, err := c.crawled("/g/data/chirps", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if expected := []string{"/g/data/chirps/2020.tif", "/g/data/chirps/2021.tif", "/g/data/chirps/2022.tif"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v over the pages, got %v", expected, paths)
+	}
+	if len(mas.queries) != 2 {
+		t.Errorf("expected 2 pages queried, got %d", len(mas.queries))
+	}
+
+	files, err = c.crawled("/g/data/chirps", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Errorf("expected the 4 files under the directory, got %d", len(files))
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	c = &masClient{address: failing.URL, client: failing.Client()}
+	if _, err := c.crawled("/g/data/chirps", false); err == nil {
+		t.Errorf("expected an error of a failed query")
+	}
+}
+
+func TestIncrementalPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_incremental")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var pathList []string
+	for _, name := range []string{"new.tif", "same.tif", "resized.tif"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("gsky"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pathList = append(pathList, path)
+	}
+	stat, err := os.Stat(pathList[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, otherSize := stat.Size(), stat.Size()+1
+	mtime := stat.ModTime().UTC().Format(time.RFC3339Nano)
+	missing := filepath.Join(dir, "missing.tif")
+	pathList = append(pathList, missing)
+
+	mas := &fakeMAS{files: []*crawledFile{
+		{FilePath: pathList[1], Size: &size, MTime: mtime},
+		{FilePath: pathList[2], Size: &otherSize, MTime: mtime},
+		{FilePath: missing, Size: &size, MTime: mtime},
+	}}
+	srv := httptest.NewServer(mas)
+	defer srv.Close()
+
+	paths, err := incrementalPaths(&masClient{address: srv.URL, client: srv.Client()}, pathList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{pathList[0], pathList[2], missing}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected the new, changed and missing files %v, got %v", expected, paths)
+	}
+	if len(mas.queries) != 1 {
+		t.Errorf("expected the directory to be queried once, got %v", mas.queries)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// manifestPathColumns are the names of the columns of the CSV manifests,
+// and of the fields of the JSON manifests, of the paths of the files,
+// matched regardless of case.
+var manifestPathColumns = []string{"path", "file_path", "uri", "url"}
+
+// manifestList is the file list of a manifest, in its order without the
+// duplicates, e.g. of the versions of an object.
+type manifestList struct {
+	paths []string
+	seen  map[string]bool
+}
+
+func (l *manifestList) add(path string) {
+	path = strings.TrimSpace(path)
+	if len(path) == 0 || l.seen[path] {
+		return
+	}
+	l.seen[path] = true
+	l.paths = append(l.paths, path)
+}
+
+// readManifest returns the file list of a CSV or JSON manifest, e.g. an
+// S3 inventory report, "-" reading it from stdin. The manifests may be
+// gzipped. The columns are those of the CSV manifests without header, e.g.
+// the fileSchema of an S3 inventory, "Bucket, Key, Size, LastModifiedDate".
+func readManifest(file string, columns string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %v", file, err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	list := &manifestList{seen: make(map[string]bool)}
+	var err error
+	if first := firstByte(br); (first == '[' || first == '{') && len(columns) == 0 {
+		err = readJSONManifest(br, list)
+	} else {
+		err = readCSVManifest(br, columns, list)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", file, err)
+	}
+	return list.paths, nil
+}
+
+// firstByte returns the first byte other than a space of a reader, left
+// to be read.
+func firstByte(br *bufio.Reader) byte {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			br.UnreadByte()
+			return b
+		}
+	}
+}
+
+// readJSONManifest reads a JSON array, or JSON lines, of paths or of
+// objects of a path field or of bucket and key fields.
+func readJSONManifest(r io.Reader, list *manifestList) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		entries, isArray := v.([]interface{})
+		if !isArray {
+			entries = []interface{}{v}
+		}
+		for _, entry := range entries {
+			path, err := jsonManifestPath(entry)
+			if err != nil {
+				return err
+			}
+			list.add(path)
+		}
+	}
+}
+
+func jsonManifestPath(entry interface{}) (string, error) {
+	switch entry := entry.(type) {
+	case string:
+		return entry, nil
+	case map[string]interface{}:
+		if _, found := entry["fileSchema"]; found {
+			return "", fmt.Errorf("an S3 inventory manifest.json lists the data files of the inventory, crawl its CSV data files with -manifest_columns %q", entry["fileSchema"])
+		}
+		fields := make(map[string]string, len(entry))
+		for k, v := range entry {
+			if s, ok := v.(string); ok {
+				fields[strings.ToLower(k)] = s
+			}
+		}
+		for _, name := range manifestPathColumns {
+			if path, found := fields[name]; found {
+				return path, nil
+			}
+		}
+		if bucket, key := fields["bucket"], fields["key"]; len(bucket) > 0 && len(key) > 0 {
+			return "s3://" + bucket + "/" + key, nil
+		}
+	}
+	return "", fmt.Errorf("no path in the entry %v", entry)
+}
+
+// readCSVManifest reads the paths of a CSV manifest, of the path column
+// of its header, or of its bucket and key columns, their keys URL-encoded
+// as those of the S3 inventories, or of its first column without header.
+// The delete markers and the versions not latest of an S3 inventory are
+// skipped.
+func readCSVManifest(r io.Reader, columns string, list *manifestList) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.LazyQuotes = true
+
+	var header []string
+	if len(columns) > 0 {
+		header = strings.Split(columns, ",")
+	}
+	pathCol, bucketCol, keyCol := -1, -1, -1
+	deleteCol, latestCol := -1, -1
+	setHeader := func(header []string) bool {
+		for i, col := range header {
+			switch col = strings.ToLower(strings.TrimSpace(col)); col {
+			case "bucket":
+				bucketCol = i
+			case "key":
+				keyCol = i
+			case "isdeletemarker":
+				deleteCol = i
+			case "islatest":
+				latestCol = i
+			default:
+				for _, name := range manifestPathColumns {
+					if col == name && pathCol < 0 {
+						pathCol = i
+					}
+				}
+			}
+		}
+		return pathCol >= 0 || (bucketCol >= 0 && keyCol >= 0)
+	}
+	if header != nil && !setHeader(header) {
+		return fmt.Errorf("no path, or bucket and key, in the columns %q", columns)
+	}
+
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			header = record
+			if setHeader(header) {
+				continue
+			}
+			// A list of paths without header
+			pathCol = 0
+		}
+
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		if strings.EqualFold(field(deleteCol), "true") || strings.EqualFold(field(latestCol), "false") {
+			continue
+		}
+		if pathCol >= 0 {
+			list.add(field(pathCol))
+			continue
+		}
+		bucket, key := field(bucketCol), field(keyCol)
+		if len(bucket) == 0 || len(key) == 0 {
+			return fmt.Errorf("line %d: no bucket or key", line)
+		}
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		list.add("s3://" + bucket + "/" + key)
+	}
+}
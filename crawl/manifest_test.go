@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inventory := `"chirps","v2/chirps-2020.tif","100","2020-02-01T00:00:00.000Z","true","false"
+"chirps","v2/chirps-2021.tif","100","2021-02-01T00:00:00.000Z","false","false"
+"chirps","v2/chirps%202022.tif","100","2022-02-01T00:00:00.000Z","true","false"
+"chirps","v2/chirps-2023.tif","0","2023-02-01T00:00:00.000Z","true","true"
+"chirps","v2/chirps-2020.tif","100","2020-02-01T00:00:00.000Z","true","false"
+`
+	tests := []struct {
+		name    string
+		doc     string
+		columns string
+		paths   []string
+		isErr   string
+	}{
+		{
+			name:  "list.txt",
+			doc:   "/g/data/chirps/2020.tif\n/g/data/chirps/2021.tif\n\n/g/data/chirps/2020.tif\n",
+			paths: []string{"/g/data/chirps/2020.tif", "/g/data/chirps/2021.tif"},
+		},
+		{
+			name:  "header.csv",
+			doc:   "Size, File_Path\n100, /g/data/chirps/2020.tif\n100, /g/data/chirps/2021.tif\n",
+			paths: []string{"/g/data/chirps/2020.tif", "/g/data/chirps/2021.tif"},
+		},
+		{
+			name:  "bucket.csv",
+			doc:   "bucket,key\nchirps,v2/2020.tif\n",
+			paths: []string{"s3://chirps/v2/2020.tif"},
+		},
+		{
+			name:    "inventory.csv",
+			doc:     inventory,
+			columns: "Bucket, Key, Size, LastModifiedDate, IsLatest, IsDeleteMarker",
+			paths:   []string{"s3://chirps/v2/chirps-2020.tif", "s3://chirps/v2/chirps 2022.tif"},
+		},
+		{
+			name:    "inventory.csv.gz",
+			doc:     inventory,
+			columns: "Bucket, Key, Size, LastModifiedDate, IsLatest, IsDeleteMarker",
+			paths:   []string{"s3://chirps/v2/chirps-2020.tif", "s3://chirps/v2/chirps 2022.tif"},
+		},
+		{
+			name:    "columns.csv",
+			doc:     inventory,
+			columns: "Size, LastModifiedDate",
+			isErr:   "no path, or bucket and key",
+		},
+		{
+			name:  "keys.csv",
+			doc:   "bucket,key\nchirps,\n",
+			isErr: "line 2: no bucket or key",
+		},
+		{
+			name:  "array.json",
+			doc:   `["/g/data/chirps/2020.tif", {"URI": "s3://chirps/v2/2021.tif"}, {"bucket": "chirps", "key": "v2/2022.tif"}]`,
+			paths: []string{"/g/data/chirps/2020.tif", "s3://chirps/v2/2021.tif", "s3://chirps/v2/2022.tif"},
+		},
+		{
+			name:  "lines.jsonl",
+			doc:   "  {\"path\": \"/g/data/chirps/2020.tif\", \"size\": 100}\n{\"path\": \"/g/data/chirps/2021.tif\"}\n",
+			paths: []string{"/g/data/chirps/2020.tif", "/g/data/chirps/2021.tif"},
+		},
+		{
+			name:  "manifest.json",
+			doc:   `{"sourceBucket": "chirps", "fileFormat": "CSV", "fileSchema": "Bucket, Key, Size", "files": []}`,
+			isErr: `-manifest_columns "Bucket, Key, Size"`,
+		},
+		{
+			name:  "entry.json",
+			doc:   `[{"size": 100}]`,
+			isErr: "no path in the entry",
+		},
+	}
+	for _, test := range tests {
+		file := filepath.Join(dir, test.name)
+		b := []byte(test.doc)
+		if strings.HasSuffix(test.name, ".gz") {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write(b)
+			gz.Close()
+			b = buf.Bytes()
+		}
+		if err := ioutil.WriteFile(file, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		paths, err := readManifest(file, test.columns)
+		if len(test.isErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.isErr) {
+				t.Errorf("%s: expected an error of %q, got %v", test.name, test.isErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(paths, test.paths) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.paths, paths)
+		}
+	}
+
+	if _, err := readManifest(filepath.Join(dir, "missing.csv"), ""); !os.IsNotExist(err) {
+		t.Errorf("expected a missing manifest, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quarantineEntry is a file whose extraction failed, skipped by the crawl
+// and reported with the error of GDAL or of the crawl worker.
+type quarantineEntry struct {
+	FilePath string    `json:"file_path"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+// quarantineReport appends the files quarantined by a crawl to the report
+// file of -quarantine, as JSON lines, and returns their quarantine records
+// of -fmt tsv, which record them in MAS once ingested.
+type quarantineReport struct {
+	mu   sync.Mutex
+	file *os.File
+	tsv  bool
+	n    int
+}
+
+func openQuarantine(path string, tsv bool) (*quarantineReport, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &quarantineReport{file: file, tsv: tsv}, nil
+}
+
+// add quarantines a file, returning its quarantine record, empty but with
+// -fmt tsv.
+func (q *quarantineReport) add(path string, err error) string {
+	entry := &quarantineEntry{FilePath: path, Error: strings.TrimSpace(err.Error()), Time: time.Now().UTC().Truncate(time.Second)}
+	b, jerr := json.Marshal(entry)
+	if jerr != nil {
+		return ""
+	}
+
+	q.mu.Lock()
+	q.n++
+	if _, werr := q.file.Write(append(b, '\n')); werr != nil {
+		fmt.Fprintf(os.Stderr, "quarantine report of %s failed: %v\n", path, werr)
+	}
+	q.mu.Unlock()
+
+	if !q.tsv {
+		return ""
+	}
+	recPath, rerr := recordPath(path)
+	if rerr != nil {
+		return ""
+	}
+	rec, _ := json.Marshal(map[string]interface{}{"error": entry.Error, "time": entry.Time})
+	return fmt.Sprintf("%s\tquarantine\t%s\n", recPath, rec)
+}
+
+// close closes the report, returning the number of files quarantined.
+func (q *quarantineReport) close() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n, q.file.Close()
+}
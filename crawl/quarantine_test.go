@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuarantineReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "quarantine.jsonl")
+	// the report of the previous crawls is appended to
+	if err := ioutil.WriteFile(file, []byte(`{"file_path":"/g/data/chirps/2019.tif","error":"truncated","time":"2020-01-01T00:00:00Z"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := openQuarantine(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := q.add("/g/data/chirps/2020.tif", errors.New("ERROR 1: TIFFReadDirectory failed\n"))
+	parts := strings.Split(strings.TrimSuffix(rec, "\n"), "\t")
+	if len(parts) != 3 || parts[0] != "/g/data/chirps/2020.tif" || parts[1] != "quarantine" {
+		t.Fatalf("expected the quarantine record of the file, got %q", rec)
+	}
+	var value struct {
+		Error string `json:"error"`
+		Time  string `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(parts[2]), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value.Error != "ERROR 1: TIFFReadDirectory failed" || len(value.Time) == 0 {
+		t.Errorf("unexpected quarantine record %s", parts[2])
+	}
+
+	// the OPeNDAP datasets are recorded under /thredds
+	rec = q.add("https://thredds.example.org/thredds/dodsC/chirps.nc", errors.New("timeout"))
+	if !strings.HasPrefix(rec, "/thredds/thredds.example.org/thredds/dodsC/chirps.nc\tquarantine\t") {
+		t.Errorf("expected the quarantine record under /thredds, got %q", rec)
+	}
+
+	n, err := q.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 files quarantined, got %d", n)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry quarantineEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, entry.FilePath)
+	}
+	if strings.Join(paths, ",") != "/g/data/chirps/2019.tif,/g/data/chirps/2020.tif,https://thredds.example.org/thredds/dodsC/chirps.nc" {
+		t.Errorf("expected the report appended to, got %v", paths)
+	}
+}
+
+func TestQuarantineProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl_quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// without -fmt tsv, the files are reported but not recorded
+	q, err := openQuarantine(filepath.Join(dir, "quarantine.jsonl"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	p := newCrawlProgress(2, nil, "", "")
+	p.out = &out
+	p.quarantine = q
+	p.write("/g/data/chirps/2020.tif", "/g/data/chirps/2020.tif\n", nil)
+	p.write("/g/data/chirps/2021.tif", "", errors.New("failed\n"))
+	if out.String() != "/g/data/chirps/2020.tif\n" {
+		t.Errorf("expected only the record of the file extracted, got %q", out.String())
+	}
+	if n, _ := q.close(); n != 1 {
+		t.Errorf("expected 1 file quarantined, got %d", n)
+	}
+	if s := p.status(); s.Errors != 1 || s.Records != 1 {
+		t.Errorf("unexpected progress: %+v", s)
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nci/gsky/schedule"
+	"github.com/nci/gsky/utils"
+)
+
+// The statuses of the runs of the scheduled jobs.
+const (
+	runRunning = "running"
+	runOK      = "ok"
+	runFailed  = "failed"
+	runTimeout = "timeout"
+)
+
+var jobNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// scheduledJob is a crawl run by -schedule on a cron schedule, the
+// crawler being run with the arguments of the job, its records piped to
+// the ingest command or written to the output file.
+type scheduledJob struct {
+	Name string `json:"name"`
+	// Schedule is the cron expression of the runs, in the local time of
+	// the scheduler, e.g. 0 3 * * *.
+	Schedule string `json:"schedule"`
+	// Args are the arguments of the crawler, the path followed by the
+	// flags, e.g. ["/g/data/u39", "-incremental", "-fmt", "tsv"].
+	Args []string `json:"args"`
+	// IngestCmd is the shell command reading the records of a run from
+	// stdin, e.g. mas/db/shard_append.sh u39.
+	IngestCmd string `json:"ingest_cmd"`
+	// Output is the file the records of a run are written to instead,
+	// replaced once the run succeeded.
+	Output string `json:"output"`
+	// Timeout is the maximum duration of a run in seconds, the run then
+	// being killed, unlimited if 0.
+	Timeout int `json:"timeout"`
+
+	schedule *schedule.Schedule
+}
+
+// scheduleConfig is the JSON file of the jobs of -schedule.
+type scheduleConfig struct {
+	// LogDir is the directory of the logs of the jobs, <name>.log, the
+	// logs being written to stderr prefixed by the job name if empty.
+	LogDir string          `json:"log_dir"`
+	Jobs   []*scheduledJob `json:"jobs"`
+	// Collections are the shell patterns of the files of the collections
+	// whose schedules are jobs too, e.g. /etc/gsky/collections/*.yaml.
+	Collections []string `json:"collections"`
+}
+
+// addCollections adds the jobs of the collections with a schedule, run
+// with -collection.
+func (cfg *scheduleConfig) addCollections() error {
+	for _, pattern := range cfg.Collections {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("collections %s: %v", pattern, err)
+		}
+		if len(files) == 0 {
+			log.Printf("no collections match %s", pattern)
+		}
+		for _, file := range files {
+			coll, err := loadCollection(file)
+			if err != nil {
+				return err
+			}
+			if len(coll.Schedule) == 0 {
+				log.Printf("collection %s of %s not scheduled", coll.Name, file)
+				continue
+			}
+			cfg.Jobs = append(cfg.Jobs, &scheduledJob{
+				Name:      coll.Name,
+				Schedule:  coll.Schedule,
+				Args:      []string{file, "-collection"},
+				IngestCmd: coll.IngestCmd,
+				Output:    coll.Output,
+				Timeout:   coll.Timeout,
+			})
+		}
+	}
+	return nil
+}
+
+// validate parses the schedules of the jobs and checks their arguments.
+func (cfg *scheduleConfig) validate() error {
+	if len(cfg.Jobs) == 0 {
+		return fmt.Errorf("no jobs")
+	}
+	names := make(map[string]bool)
+	for _, job := range cfg.Jobs {
+		if !jobNamePattern.MatchString(job.Name) {
+			return fmt.Errorf("invalid job name %q, expecting letters, digits, _, . and -", job.Name)
+		}
+		if names[job.Name] {
+			return fmt.Errorf("duplicate job %s", job.Name)
+		}
+		names[job.Name] = true
+
+		var err error
+		if job.schedule, err = schedule.Parse(job.Schedule); err != nil {
+			return fmt.Errorf("job %s: %v", job.Name, err)
+		}
+		if len(job.Args) == 0 || strings.HasPrefix(job.Args[0], "-") {
+			return fmt.Errorf("job %s: args expected, the path followed by the flags", job.Name)
+		}
+		for _, arg := range job.Args {
+			if arg == "-schedule" || arg == "--schedule" {
+				return fmt.Errorf("job %s: -schedule in args", job.Name)
+			}
+		}
+		if (len(job.IngestCmd) == 0) == (len(job.Output) == 0) {
+			return fmt.Errorf("job %s: either ingest_cmd or output expected", job.Name)
+		}
+		if job.Timeout < 0 {
+			return fmt.Errorf("job %s: invalid timeout %d", job.Name, job.Timeout)
+		}
+	}
+	return nil
+}
+
+// jobRun is the status of a run of a job.
+type jobRun struct {
+	Start   time.Time  `json:"start"`
+	End     *time.Time `json:"end,omitempty"`
+	Seconds float64    `json:"seconds,omitempty"`
+	Status  string     `json:"status"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// jobStatus is the status of a job served at /status.
+type jobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"next_run"`
+	Running  bool      `json:"running"`
+	Runs     int       `json:"runs"`
+	Failures int       `json:"failures"`
+	// Skipped counts the runs due while the previous one was still
+	// running.
+	Skipped int     `json:"skipped"`
+	LastRun *jobRun `json:"last_run,omitempty"`
+}
+
+// jobState is a job and its status.
+type jobState struct {
+	job    *scheduledJob
+	logDir string
+
+	mu     sync.Mutex
+	status jobStatus
+}
+
+// lineWriter writes the lines written to it prefixed, the writes of the
+// processes of a run being serialised.
+type lineWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	prefix  string
+	partial []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.partial = append(lw.partial, p...)
+	for {
+		i := bytes.IndexByte(lw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := fmt.Fprintf(lw.w, "%s%s\n", lw.prefix, lw.partial[:i]); err != nil {
+			return 0, err
+		}
+		lw.partial = lw.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush writes the last line if unterminated.
+func (lw *lineWriter) flush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.partial) > 0 {
+		fmt.Fprintf(lw.w, "%s%s\n", lw.prefix, lw.partial)
+		lw.partial = nil
+	}
+}
+
+// snapshot returns a copy of the status of the job.
+func (js *jobState) snapshot() jobStatus {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	status := js.status
+	if status.LastRun != nil {
+		run := *status.LastRun
+		status.LastRun = &run
+	}
+	return status
+}
+
+// start records the start of a run, unless the previous run is still
+// running.
+func (js *jobState) start(t time.Time) bool {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.status.Running {
+		js.status.Skipped++
+		return false
+	}
+	js.status.Running = true
+	js.status.Runs++
+	js.status.LastRun = &jobRun{Start: t, Status: runRunning}
+	return true
+}
+
+// finish records the end of the run.
+func (js *jobState) finish(status string, err error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	end := time.Now()
+	run := js.status.LastRun
+	run.End = &end
+	run.Seconds = end.Sub(run.Start).Seconds()
+	run.Status = status
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if status != runOK {
+		js.status.Failures++
+	}
+	js.status.Running = false
+}
+
+// openLog returns the log of a run of the job, appended to its file in
+// the log directory, if any, or else written to stderr.
+func (js *jobState) openLog() (*lineWriter, func(), error) {
+	if len(js.logDir) == 0 {
+		return &lineWriter{w: os.Stderr, prefix: "[" + js.job.Name + "] "}, func() {}, nil
+	}
+	f, err := os.OpenFile(filepath.Join(js.logDir, js.job.Name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &lineWriter{w: f}, func() { f.Close() }, nil
+}
+
+// run runs the crawler of the job, piping its records to the ingest
+// command or writing them to the output file.
+func (js *jobState) run(ctx context.Context, exe string) {
+	job := js.job
+	logw, closeLog, err := js.openLog()
+	if err != nil {
+		log.Printf("job %s: %v", job.Name, err)
+		js.finish(runFailed, err)
+		return
+	}
+	defer closeLog()
+	defer logw.flush()
+
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(job.Timeout)*time.Second)
+		defer cancel()
+	}
+	fmt.Fprintf(logw, "run started at %s: %s\n", time.Now().Format(time.RFC3339), strings.Join(job.Args, " "))
+	t0 := time.Now()
+
+	err = runJob(ctx, exe, job, logw)
+	status := runOK
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		status = runTimeout
+		err = fmt.Errorf("killed after the timeout of %ds", job.Timeout)
+	case err != nil:
+		status = runFailed
+	}
+	if err != nil {
+		fmt.Fprintf(logw, "run %s after %v: %v\n", status, time.Since(t0).Round(time.Second), err)
+		log.Printf("job %s %s: %v", job.Name, status, err)
+	} else {
+		fmt.Fprintf(logw, "run done in %v\n", time.Since(t0).Round(time.Second))
+		log.Printf("job %s done in %v", job.Name, time.Since(t0).Round(time.Second))
+	}
+	js.finish(status, err)
+}
+
+// runJob runs the crawler and the ingest command of a job.
+func runJob(ctx context.Context, exe string, job *scheduledJob, logw io.Writer) error {
+	crawl := exec.CommandContext(ctx, exe, job.Args...)
+	crawl.Stderr = logw
+
+	if len(job.IngestCmd) == 0 {
+		tmp := job.Output + ".tmp"
+		f, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		crawl.Stdout = f
+		err = crawl.Run()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("crawl: %v", err)
+		}
+		return os.Rename(tmp, job.Output)
+	}
+
+	ingest := exec.CommandContext(ctx, "sh", "-c", job.IngestCmd)
+	records, err := crawl.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	ingest.Stdin = records
+	ingest.Stdout = logw
+	ingest.Stderr = logw
+	if err := crawl.Start(); err != nil {
+		return fmt.Errorf("crawl: %v", err)
+	}
+	if err := ingest.Start(); err != nil {
+		crawl.Process.Kill()
+		crawl.Wait()
+		return fmt.Errorf("ingest: %v", err)
+	}
+	// The crawl is waited once the ingestion has read all its records.
+	ingestErr := ingest.Wait()
+	if ingestErr != nil {
+		crawl.Process.Kill()
+	}
+	crawlErr := crawl.Wait()
+	if crawlErr != nil {
+		return fmt.Errorf("crawl: %v", crawlErr)
+	}
+	if ingestErr != nil {
+		return fmt.Errorf("ingest: %v", ingestErr)
+	}
+	return nil
+}
+
+// loop runs the job on its schedule until ctx is done, skipping the runs
+// due while the previous one is still running.
+func (js *jobState) loop(ctx context.Context, exe string, runs *sync.WaitGroup) {
+	for {
+		next := js.job.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("job %s: no run of %s within 5 years", js.job.Name, js.job.Schedule)
+			return
+		}
+		js.mu.Lock()
+		js.status.NextRun = next
+		js.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !js.start(time.Now()) {
+			log.Printf("job %s still running, run of %s skipped", js.job.Name, next.Format(time.RFC3339))
+			continue
+		}
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			js.run(ctx, exe)
+		}()
+	}
+}
+
+// statusHandler serves the status of the jobs at /status, and of a job
+// at /status/<name>.
+func statusHandler(jobs []*jobState) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/status"), "/")
+		var statuses []jobStatus
+		for _, js := range jobs {
+			if len(name) == 0 || js.job.Name == name {
+				statuses = append(statuses, js.snapshot())
+			}
+		}
+		if len(name) == 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{"jobs": statuses})
+			return
+		}
+		if len(statuses) == 0 {
+			http.Error(w, fmt.Sprintf(`{ "error": "unknown job %s" }`, name), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(statuses[0])
+	})
+}
+
+// runSchedule runs the crawl jobs of the JSON file on their schedules
+// until SIGINT or SIGTERM, the runs in progress then being killed, and
+// serves their status on the port, if not 0.
+func runSchedule(configFile string, port int) error {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	cfg := &scheduleConfig{}
+	if err := utils.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("%s: %v", configFile, err)
+	}
+	if err := cfg.addCollections(); err != nil {
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("%s: %v", configFile, err)
+	}
+	if len(cfg.LogDir) > 0 {
+		if err := os.MkdirAll(cfg.LogDir, 0755); err != nil {
+			return err
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var jobs []*jobState
+	for _, job := range cfg.Jobs {
+		jobs = append(jobs, &jobState{job: job, logDir: cfg.LogDir, status: jobStatus{Name: job.Name, Schedule: job.Schedule}})
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].job.Name < jobs[j].job.Name })
+
+	if port > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/status", statusHandler(jobs))
+		mux.Handle("/status/", statusHandler(jobs))
+		go func() {
+			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), mux))
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("signal %v, stopping the jobs", sig)
+		cancel()
+	}()
+
+	var loops, runs sync.WaitGroup
+	for _, js := range jobs {
+		loops.Add(1)
+		go func(js *jobState) {
+			defer loops.Done()
+			js.loop(ctx, exe, &runs)
+		}(js)
+	}
+	log.Printf("scheduling %d crawl jobs of %s", len(jobs), configFile)
+	loops.Wait()
+	runs.Wait()
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	extr "github.com/nci/gsky/crawl/extractor"
+	"github.com/nci/gsky/stac"
+	"github.com/nci/gsky/utils"
+)
+
+// stacItem returns the STAC Item of the record of a file, its id that of
+// the path of the record in MAS and the href of its asset the path of the
+// file crawled, for the catalog to match MAS.
+func (c *contentCrawl) stacItem(path string, geoFile *extr.GeoFile) (*stac.Item, error) {
+	recPath, err := recordPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bbox []float64
+	var times []time.Time
+	namespaces := make(map[string]bool)
+	for _, ds := range geoFile.DataSets {
+		if len(ds.Polygon) == 0 {
+			continue
+		}
+		dsBBox, err := extr.WGS84BBox(ds.Polygon, ds.ProjWKT)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ds.DataSetName, err)
+		}
+		bbox = stac.UnionBBox(bbox, dsBBox)
+		times = append(times, ds.TimeStamps...)
+		if len(ds.NameSpace) > 0 {
+			namespaces[ds.NameSpace] = true
+		}
+	}
+	if len(bbox) != 4 {
+		return nil, fmt.Errorf("no geometry in the datasets of %s", path)
+	}
+
+	item := stac.NewItem(stac.ItemID(recPath), bbox)
+	info := geoFile.PosixInfo
+	if info == nil && len(times) == 0 {
+		info, _ = posixInfo(path)
+	}
+	fallback := time.Now()
+	if info != nil {
+		fallback = info.MTime
+	}
+	item.SetTimes(times, fallback)
+
+	if len(namespaces) > 0 {
+		var names []string
+		for ns := range namespaces {
+			names = append(names, ns)
+		}
+		sort.Strings(names)
+		item.Properties["gsky:namespaces"] = names
+	}
+	if ds := sharedGrid(geoFile.DataSets); ds != nil {
+		item.AddExtension(stac.ProjectionExtension)
+		item.Properties["proj:wkt2"] = ds.ProjWKT
+		item.Properties["proj:shape"] = []int32{ds.YSize, ds.XSize}
+		if gt := ds.GeoTransform; len(gt) == 6 {
+			item.Properties["proj:transform"] = []float64{gt[1], gt[2], gt[0], gt[4], gt[5], gt[3]}
+		}
+	}
+
+	asset := &stac.Asset{
+		Href:     path,
+		Type:     stac.MediaType(geoFile.Driver),
+		Roles:    []string{"data"},
+		Checksum: stac.Multihash(geoFile.Checksum),
+	}
+	if geoFile.PosixInfo != nil {
+		asset.Size = geoFile.PosixInfo.Size
+	}
+	if len(asset.Checksum) > 0 || asset.Size > 0 {
+		item.AddExtension(stac.FileExtension)
+	}
+	item.Assets["data"] = asset
+
+	if len(c.stacCollection) > 0 {
+		item.Collection = c.stacCollection
+		item.Links = append(item.Links,
+			stac.Link{Rel: "collection", Href: "../collection.json", Type: "application/json"},
+			stac.Link{Rel: "parent", Href: "../collection.json", Type: "application/json"})
+	}
+	return item, nil
+}
+
+// sharedGrid returns the first dataset with a projection if all the
+// datasets with a projection share its projection, size and geotransform,
+// nil otherwise.
+func sharedGrid(datasets []*extr.GeoMetaData) *extr.GeoMetaData {
+	var first *extr.GeoMetaData
+	for _, ds := range datasets {
+		if len(ds.ProjWKT) == 0 {
+			continue
+		}
+		if first == nil {
+			first = ds
+			continue
+		}
+		if ds.ProjWKT != first.ProjWKT || ds.XSize != first.XSize || ds.YSize != first.YSize || fmt.Sprint(ds.GeoTransform) != fmt.Sprint(first.GeoTransform) {
+			return nil
+		}
+	}
+	return first
+}
+
+// stacItemFile returns the file of the STAC Item of a path.
+func (c *contentCrawl) stacItemFile(path string) (string, error) {
+	recPath, err := recordPath(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(c.stacDir, "items", stac.ItemID(recPath)+".json"), nil
+}
+
+// writeSTACItem writes the STAC Item of the record of a file to the
+// directory of -stac, replacing that of a previous crawl.
+func (c *contentCrawl) writeSTACItem(path string, geoFile *extr.GeoFile) error {
+	item, err := c.stacItem(path, geoFile)
+	if err != nil {
+		return fmt.Errorf("STAC item of %s: %v", path, err)
+	}
+	file, err := c.stacItemFile(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return writeJSON(file, item)
+}
+
+// updateSTACCollection extends the STAC Collection of -stac_collection,
+// created if new, to the Items of the files crawled, those whose
+// extraction failed having none.
+func (c *contentCrawl) updateSTACCollection(pathList []string) error {
+	if len(c.stacDir) == 0 || len(c.stacCollection) == 0 {
+		return nil
+	}
+	collFile := filepath.Join(c.stacDir, "collection.json")
+	coll := stac.NewCollection(c.stacCollection, fmt.Sprintf("The files of %s crawled by GSKY", c.stacCollection))
+	if b, err := ioutil.ReadFile(collFile); err == nil {
+		if err := utils.Unmarshal(b, coll); err != nil {
+			return fmt.Errorf("invalid STAC collection %s: %v", collFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	n := 0
+	for _, path := range pathList {
+		file, err := c.stacItemFile(path)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		item := &stac.Item{}
+		if err := utils.Unmarshal(b, item); err != nil {
+			return fmt.Errorf("invalid STAC item %s: %v", file, err)
+		}
+		if err := coll.Extend(item, "items/"+filepath.Base(file)); err != nil {
+			return err
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	return writeJSON(collFile, coll)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/nci/gsky/metrics/prom"
+	"github.com/nci/gsky/utils"
+)
+
+// crawlMetrics are the Prometheus metrics of a crawl, refreshed from its
+// progress when scraped.
+type crawlMetrics struct {
+	registry  *prom.Registry
+	processed *prom.Counter
+	records   *prom.Counter
+	errors    *prom.Counter
+	files     *prom.Gauge
+	rate      *prom.Gauge
+	eta       *prom.Gauge
+}
+
+func newCrawlMetrics(progress *crawlProgress) *crawlMetrics {
+	processedVec, processed := prom.NewCounter("gsky_crawl_files_processed_total", "Number of files processed by the crawl.")
+	recordsVec, records := prom.NewCounter("gsky_crawl_records_total", "Number of files of the crawl whose records were extracted.")
+	errorsVec, errors := prom.NewCounter("gsky_crawl_errors_total", "Number of files of the crawl whose extraction failed.")
+	filesVec, files := prom.NewGauge("gsky_crawl_files", "Number of files of the crawl, processed or not.")
+	rateVec, rate := prom.NewGauge("gsky_crawl_files_per_second", "Files processed per second since the start of the crawl.")
+	etaVec, eta := prom.NewGauge("gsky_crawl_eta_seconds", "Estimated time to process the files left in seconds.")
+	m := &crawlMetrics{
+		registry:  prom.NewRegistry(),
+		processed: processed,
+		records:   records,
+		errors:    errors,
+		files:     files,
+		rate:      rate,
+		eta:       eta,
+	}
+	m.registry.MustRegister(processedVec, recordsVec, errorsVec, filesVec, rateVec, etaVec)
+	prom.RegisterProcessMetrics(m.registry, "crawl", utils.GSKYVersion)
+	m.registry.OnScrape(func() {
+		s := progress.status()
+		m.processed.Add(float64(s.Processed) - m.processed.Value())
+		m.records.Add(float64(s.Records) - m.records.Value())
+		m.errors.Add(float64(s.Errors) - m.errors.Value())
+		m.files.Set(float64(s.Total))
+		m.rate.Set(s.FilesPerSec)
+		m.eta.Set(float64(s.ETASeconds))
+	})
+	return m
+}
+
+// serveStatus serves the progress of a crawl as JSON at /status, as the
+// status files of -status, and its metrics at /metrics on the port, in
+// the background.
+func serveStatus(progress *crawlProgress, port int) {
+	metrics := newCrawlMetrics(progress)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(progress.status())
+	})
+	mux.Handle("/metrics", metrics.registry)
+	go func() {
+		log.Printf("crawl status listening on :%d/status and :%d/metrics", port, port)
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), mux))
+	}()
+}
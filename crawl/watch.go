@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchOptions are the options of the crawls of the files arriving in
+// watched directories.
+type watchOptions struct {
+	// namePattern is the shell pattern of the names of the files
+	// crawled, all the files if empty.
+	namePattern string
+	// poll is the interval of the walks of the directories, instead of
+	// inotify, if not 0.
+	poll time.Duration
+	// batch is the interval of the crawls of the files arrived.
+	batch time.Duration
+	// ingestCmd is the shell command reading the records of each crawl
+	// from stdin, the records being written to stdout if empty.
+	ingestCmd string
+	// progress counts the files arrived and crawled, for -status_port.
+	progress *crawlProgress
+}
+
+// matchName reports whether the name of a path matches the shell pattern,
+// if any.
+func matchName(pattern, path string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	matched, err := filepath.Match(pattern, filepath.Base(path))
+	return err == nil && matched
+}
+
+type fileState struct {
+	size  int64
+	mtime time.Time
+}
+
+// walkFiles returns the states of the files under dirs whose names match
+// the pattern.
+func walkFiles(dirs []string, pattern string) map[string]fileState {
+	files := make(map[string]fileState)
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Printf("watch walk of %s failed: %v", path, err)
+				return nil
+			}
+			if info.Mode().IsRegular() && matchName(pattern, path) {
+				files[path] = fileState{size: info.Size(), mtime: info.ModTime()}
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// pollWatch walks the directories every interval, sending the paths of
+// the files new or changed since the previous walks once unchanged for a
+// walk, i.e. written.
+func pollWatch(dirs []string, pattern string, interval time.Duration, paths chan<- string) {
+	seen := walkFiles(dirs, pattern)
+	pending := make(map[string]bool)
+	for range time.Tick(interval) {
+		files := walkFiles(dirs, pattern)
+		for path, state := range files {
+			if old, found := seen[path]; found && old == state {
+				if pending[path] {
+					delete(pending, path)
+					paths <- path
+				}
+				continue
+			}
+			pending[path] = true
+		}
+		for path := range pending {
+			if _, found := files[path]; !found {
+				delete(pending, path)
+			}
+		}
+		seen = files
+	}
+}
+
+// watch crawls the files arriving in the directories, with inotify on
+// Linux unless polling, in batches. The files present at start are left
+// to the crawls of the directories.
+func (c *contentCrawl) watch(dirs []string, opts *watchOptions) {
+	paths := make(chan string, 1024)
+	if opts.poll > 0 {
+		go pollWatch(dirs, opts.namePattern, opts.poll, paths)
+	} else {
+		go func() {
+			err := inotifyWatch(dirs, opts.namePattern, paths)
+			poll := time.Duration(DefaultWatchPoll) * time.Second
+			log.Printf("inotify watch failed, polling every %v: %v", poll, err)
+			pollWatch(dirs, opts.namePattern, poll, paths)
+		}()
+	}
+	log.Printf("watching %s", strings.Join(dirs, ", "))
+
+	batch := make(map[string]bool)
+	var order []string
+	ticker := time.NewTicker(opts.batch)
+	defer ticker.Stop()
+	for {
+		select {
+		case path := <-paths:
+			if !batch[path] {
+				batch[path] = true
+				order = append(order, path)
+			}
+		case <-ticker.C:
+			if len(order) == 0 {
+				continue
+			}
+			c.crawlBatch(order, opts.ingestCmd, opts.progress)
+			batch = make(map[string]bool)
+			order = nil
+		}
+	}
+}
+
+// crawlBatch extracts the records of the files arrived, piping them to
+// the ingest command, if any, or else writing them to stdout.
+func (c *contentCrawl) crawlBatch(pathList []string, ingestCmd string, progress *crawlProgress) {
+	var records strings.Builder
+	n := 0
+	progress.add(len(pathList))
+	for _, path := range pathList {
+		progress.setCurrent(path)
+		rec, err := c.record(path)
+		progress.done(path, err)
+		if err != nil {
+			os.Stderr.Write([]byte(err.Error()))
+			if c.quarantine != nil {
+				records.WriteString(c.quarantine.add(path, err))
+			}
+			continue
+		}
+		records.WriteString(rec)
+		n++
+	}
+	log.Printf("watch crawl: %d of %d files arrived extracted", n, len(pathList))
+	if err := c.updateSTACCollection(pathList); err != nil {
+		log.Printf("watch crawl: %v", err)
+	}
+	if records.Len() == 0 {
+		return
+	}
+	if len(ingestCmd) == 0 {
+		os.Stdout.WriteString(records.String())
+		return
+	}
+
+	cmd := exec.Command("sh", "-c", ingestCmd)
+	cmd.Stdin = strings.NewReader(records.String())
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	t0 := time.Now()
+	if err := cmd.Run(); err != nil {
+		log.Printf("watch ingestion of %d files failed: %v", n, err)
+		return
+	}
+	log.Printf("watch ingestion of %d files done in %v", n, time.Since(t0))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// inotifyWatch sends the paths of the files written or moved under the
+// directories, the directories created being watched too. It returns
+// once inotify fails, e.g. out of watches.
+func inotifyWatch(dirs []string, pattern string, paths chan<- string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	watched := make(map[int]string)
+	// addDir watches a directory and its subdirectories, sending the
+	// files of the directories created, which may be written before
+	// watched.
+	addDir := func(root string, created bool) error {
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if !info.IsDir() {
+				if created && info.Mode().IsRegular() && matchName(pattern, path) {
+					paths <- path
+				}
+				return nil
+			}
+			wd, err := syscall.InotifyAddWatch(fd, path, inotifyMask)
+			if err != nil {
+				return fmt.Errorf("inotify watch of %s failed: %v", path, err)
+			}
+			watched[wd] = path
+			return nil
+		})
+	}
+	for _, dir := range dirs {
+		if err := addDir(dir, false); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				log.Printf("inotify queue overflow, files arrived meanwhile left to the crawls")
+				continue
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(watched, int(event.Wd))
+				continue
+			}
+			dir, found := watched[int(event.Wd)]
+			if !found {
+				continue
+			}
+			path := filepath.Join(dir, strings.TrimRight(string(nameBytes), "\x00"))
+			switch {
+			case event.Mask&syscall.IN_ISDIR != 0:
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					if err := addDir(path, true); err != nil {
+						return err
+					}
+				}
+			case event.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+				if matchName(pattern, path) {
+					paths <- path
+				}
+			}
+		}
+	}
+}
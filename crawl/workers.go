@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// crawlWindow bounds the paths in flight or waiting for the records of
+// the paths before them, per worker.
+const crawlWindow = 4
+
+// workerResult is the reply of a crawl worker to a path.
+type workerResult struct {
+	Record string `json:"record,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type crawlJob struct {
+	index int
+	path  string
+}
+
+type crawlResult struct {
+	index  int
+	path   string
+	record string
+	err    error
+}
+
+// serve extracts the records of the paths read from r, one per line,
+// replying each with a workerResult line on w, the stdout of the worker
+// being left to the messages of GDAL.
+func (c *contentCrawl) serve(r io.Reader, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		res := workerResult{}
+		rec, err := c.record(scanner.Text())
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Record = rec
+		}
+		if err := enc.Encode(&res); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// workerProcess is a crawl worker, a crawler run with -crawl_worker,
+// isolating GDAL from the other workers: a worker crashing on a file
+// only fails that file.
+type workerProcess struct {
+	cmd     *exec.Cmd
+	paths   io.WriteCloser
+	results *bufio.Reader
+	pipe    *os.File
+}
+
+func startWorker(args []string) (*workerProcess, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{w}
+	paths, err := cmd.StdinPipe()
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &workerProcess{cmd: cmd, paths: paths, results: bufio.NewReader(r), pipe: r}, nil
+}
+
+// extract returns the reply of the worker to a path, the error being of
+// the worker failing.
+func (p *workerProcess) extract(path string) (*workerResult, error) {
+	if _, err := fmt.Fprintln(p.paths, path); err != nil {
+		return nil, err
+	}
+	line, err := p.results.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	res := &workerResult{}
+	if err := json.Unmarshal(line, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (p *workerProcess) stop() {
+	p.paths.Close()
+	if err := p.cmd.Wait(); err != nil {
+		log.Printf("crawl worker %d: %v", p.cmd.Process.Pid, err)
+	}
+	p.pipe.Close()
+}
+
+// runWorker extracts the paths of jobs with a worker process, restarted
+// once its process exits.
+func runWorker(args []string, jobs <-chan crawlJob, results chan<- crawlResult) {
+	var p *workerProcess
+	for job := range jobs {
+		if p == nil {
+			var err error
+			if p, err = startWorker(args); err != nil {
+				results <- crawlResult{index: job.index, path: job.path, err: fmt.Errorf("crawl worker of %s failed to start: %v", job.path, err)}
+				continue
+			}
+		}
+		res, err := p.extract(job.path)
+		if err != nil {
+			p.stop()
+			p = nil
+			results <- crawlResult{index: job.index, path: job.path, err: fmt.Errorf("crawl worker of %s exited: %v", job.path, err)}
+			continue
+		}
+		if len(res.Error) > 0 {
+			results <- crawlResult{index: job.index, path: job.path, err: fmt.Errorf("%s", res.Error)}
+			continue
+		}
+		results <- crawlResult{index: job.index, path: job.path, record: res.Record}
+	}
+	if p != nil {
+		p.stop()
+	}
+}
+
+// crawlConcurrently extracts the records of the paths with concurrency
+// worker processes of args, writing them with progress in the order of
+// the paths, as a serial crawl, for the ingestion to be deterministic and
+// the crawl resumable.
+func crawlConcurrently(pathList []string, concurrency int, args []string, progress *crawlProgress) {
+	crawlInOrder(pathList, concurrency, func(jobs <-chan crawlJob, results chan<- crawlResult) {
+		runWorker(args, jobs, results)
+	}, progress)
+}
+
+// crawlInOrder runs concurrency workers of the jobs of the paths, their
+// results arriving in any order, and writes the results with progress
+// in the order of the paths.
+func crawlInOrder(pathList []string, concurrency int, worker func(jobs <-chan crawlJob, results chan<- crawlResult), progress *crawlProgress) {
+	jobs := make(chan crawlJob)
+	results := make(chan crawlResult, concurrency)
+	window := make(chan struct{}, crawlWindow*concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(jobs, results)
+		}()
+	}
+	go func() {
+		for i, path := range pathList {
+			window <- struct{}{}
+			jobs <- crawlJob{index: i, path: path}
+		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]crawlResult)
+	next := 0
+	// The current file of the crawl is the next in order, that the
+	// records written wait for.
+	if len(pathList) > 0 {
+		progress.setCurrent(pathList[0])
+	}
+	for res := range results {
+		pending[res.index] = res
+		for {
+			res, found := pending[next]
+			if !found {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-window
+			progress.write(res.path, res.record, res.err)
+			if next < len(pathList) {
+				progress.setCurrent(pathList[next])
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCrawlInOrder(t *testing.T) {
+	var pathList []string
+	for i := 0; i < 50; i++ {
+		pathList = append(pathList, fmt.Sprintf("/g/data/chirps/%02d.tif", i))
+	}
+	// the results of the later paths arrive first
+	worker := func(jobs <-chan crawlJob, results chan<- crawlResult) {
+		for job := range jobs {
+			time.Sleep(time.Duration(5-job.index%5) * time.Millisecond)
+			if job.index%7 == 3 {
+				results <- crawlResult{index: job.index, path: job.path, err: fmt.Errorf("%s failed\n", job.path)}
+				continue
+			}
+			results <- crawlResult{index: job.index, path: job.path, record: job.path + "\n"}
+		}
+	}
+
+	var out bytes.Buffer
+	progress := newCrawlProgress(len(pathList), nil, "", "")
+	progress.out = &out
+	crawlInOrder(pathList, 4, worker, progress)
+
+	var expected []string
+	for i, path := range pathList {
+		if i%7 != 3 {
+			expected = append(expected, path)
+		}
+	}
+	if got := strings.Fields(out.String()); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected the records in the order of the paths, got %v", got)
+	}
+
+	s := progress.status()
+	if s.Processed != 50 || s.Records != len(expected) || s.Errors != 50-len(expected) {
+		t.Errorf("unexpected progress: %+v", s)
+	}
+	if progress.cp.LastPath != pathList[49] || len(s.CurrentPath) > 0 {
+		t.Errorf("expected the crawl to end with the last path, got %s, current %s", progress.cp.LastPath, s.CurrentPath)
+	}
+	if progress.cp.ErrorPaths[0] != pathList[3] {
+		t.Errorf("expected the errors in order, got %v", progress.cp.ErrorPaths)
+	}
+}
+
+func TestCrawlInOrderWindow(t *testing.T) {
+	const concurrency = 2
+	var pathList []string
+	for i := 0; i < 40; i++ {
+		pathList = append(pathList, fmt.Sprint(i))
+	}
+
+	var mu sync.Mutex
+	maxIndex := 0
+	release := make(chan struct{})
+	worker := func(jobs <-chan crawlJob, results chan<- crawlResult) {
+		for job := range jobs {
+			mu.Lock()
+			if job.index > maxIndex {
+				maxIndex = job.index
+			}
+			mu.Unlock()
+			if job.index == 0 {
+				<-release
+			}
+			results <- crawlResult{index: job.index, path: job.path, record: job.path + "\n"}
+		}
+	}
+
+	var out bytes.Buffer
+	progress := newCrawlProgress(len(pathList), nil, "", "")
+	progress.out = &out
+	done := make(chan struct{})
+	go func() {
+		crawlInOrder(pathList, concurrency, worker, progress)
+		close(done)
+	}()
+
+	// the first path holds back the records of the others
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	started := maxIndex
+	mu.Unlock()
+	if started >= crawlWindow*concurrency {
+		t.Errorf("expected at most %d paths started ahead of the first, got %d", crawlWindow*concurrency, started)
+	}
+	if s := progress.status(); s.Processed != 0 || s.CurrentPath != "0" {
+		t.Errorf("expected no path written before the first, got %+v", s)
+	}
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the crawl to end")
+	}
+	if got := strings.Fields(out.String()); strings.Join(got, ",") != strings.Join(pathList, ",") {
+		t.Errorf("expected the records in the order of the paths, got %v", got)
+	}
+}
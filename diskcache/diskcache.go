@@ -0,0 +1,442 @@
+// Package diskcache implements the disk cache of the rendered tiles and
+// other results of OWS. A quota manager bounds the total size of the
+// cache directory, evicting the least recently or the least frequently
+// used entries, and keeps reserved space for the layers that must not
+// be evicted by the others.
+package diskcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The eviction policies.
+const (
+	LRU = "lru"
+	LFU = "lfu"
+)
+
+// Size is a number of bytes given in JSON as a number or as a string
+// with a KB, MB, GB or TB suffix, in powers of 1024, e.g. "20GB".
+type Size int64
+
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*s = Size(v)
+		return nil
+	case string:
+		size, err := ParseSize(v)
+		if err != nil {
+			return err
+		}
+		*s = size
+		return nil
+	}
+	return fmt.Errorf("invalid size: %s", data)
+}
+
+// ParseSize parses a size such as 512MB.
+func ParseSize(value string) (Size, error) {
+	str := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		if strings.HasSuffix(str, suffix) {
+			multiplier = int64(1) << (10 * uint(i+1))
+			str = strings.TrimSuffix(str, suffix)
+			break
+		}
+	}
+	str = strings.TrimSuffix(strings.TrimSpace(str), "B")
+	n, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %v", value)
+	}
+	return Size(n * float64(multiplier)), nil
+}
+
+// Config is the location, quota and eviction policy of a cache.
+type Config struct {
+	Dir     string `json:"dir"`
+	MaxSize Size   `json:"max_size"`
+	// Policy is lru, the default, or lfu.
+	Policy string `json:"policy"`
+	// MaxAge is the duration entries are served for, e.g. 24h, forever
+	// if not set.
+	MaxAge       string         `json:"max_age"`
+	Reservations []*Reservation `json:"reservations"`
+
+	maxAge time.Duration
+}
+
+// Reservation is the space of the cache the entries of the layers
+// matching the shell patterns are kept within, whatever the entries of
+// the other layers.
+type Reservation struct {
+	Layers []string `json:"layers"`
+	Size   Size     `json:"size"`
+}
+
+// Validate checks the config and sets its defaults.
+func (c *Config) Validate() error {
+	if len(c.Dir) == 0 {
+		return fmt.Errorf("disk cache dir is required")
+	}
+	if c.MaxSize <= 0 {
+		return fmt.Errorf("disk cache max_size must be positive")
+	}
+	switch c.Policy {
+	case "":
+		c.Policy = LRU
+	case LRU, LFU:
+	default:
+		return fmt.Errorf("disk cache policy must be %s or %s: %v", LRU, LFU, c.Policy)
+	}
+	c.maxAge = 0
+	if len(c.MaxAge) > 0 {
+		maxAge, err := time.ParseDuration(c.MaxAge)
+		if err != nil || maxAge <= 0 {
+			return fmt.Errorf("disk cache max_age must be a positive duration: %v", c.MaxAge)
+		}
+		c.maxAge = maxAge
+	}
+
+	var reserved Size
+	for i, r := range c.Reservations {
+		if len(r.Layers) == 0 || r.Size <= 0 {
+			return fmt.Errorf("disk cache reservations[%d] must have layers and a positive size", i)
+		}
+		for _, p := range r.Layers {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("disk cache reservations[%d]: invalid pattern %v: %v", i, p, err)
+			}
+		}
+		reserved += r.Size
+	}
+	if reserved > c.MaxSize {
+		return fmt.Errorf("disk cache reservations exceed max_size")
+	}
+	return nil
+}
+
+// reservation returns the index of the reservation of a layer, -1 if
+// none.
+func (c *Config) reservation(layer string) int {
+	for i, r := range c.Reservations {
+		for _, p := range r.Layers {
+			if ok, _ := path.Match(p, layer); ok {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+type entry struct {
+	layer    string
+	file     string
+	size     int64
+	created  time.Time
+	accessed time.Time
+	hits     int64
+	// pending is set while the file is written.
+	pending bool
+}
+
+// Stats are the usage statistics of a cache.
+type Stats struct {
+	Dir       string           `json:"dir"`
+	Policy    string           `json:"policy"`
+	Size      int64            `json:"size"`
+	MaxSize   int64            `json:"max_size"`
+	Entries   int              `json:"entries"`
+	Hits      int64            `json:"hits"`
+	Misses    int64            `json:"misses"`
+	Evictions int64            `json:"evictions"`
+	Rejected  int64            `json:"rejected"`
+	Layers    map[string]int64 `json:"layers"`
+}
+
+// Cache is a disk cache whose total size is kept within its quota.
+type Cache struct {
+	mu        sync.Mutex
+	config    Config
+	entries   map[string]*entry
+	size      int64
+	hits      int64
+	misses    int64
+	evictions int64
+	rejected  int64
+}
+
+// Open opens the cache of a validated config, indexing the entries
+// left in its directory, and evicts the entries exceeding the quota.
+func Open(config *Config) (*Cache, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{config: *config, entries: make(map[string]*entry)}
+
+	layerDirs, err := ioutil.ReadDir(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, layerDir := range layerDirs {
+		if !layerDir.IsDir() {
+			continue
+		}
+		layer, err := url.PathUnescape(layerDir.Name())
+		if err != nil {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(config.Dir, layerDir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			file := filepath.Join(config.Dir, layerDir.Name(), fi.Name())
+			if strings.HasPrefix(fi.Name(), ".") {
+				os.Remove(file)
+				continue
+			}
+			if fi.IsDir() {
+				continue
+			}
+			c.entries[file] = &entry{layer: layer, file: file, size: fi.Size(), created: fi.ModTime(), accessed: fi.ModTime()}
+			c.size += fi.Size()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(-1, 0)
+	return c, nil
+}
+
+// Reconfigure applies a new validated config of the same directory,
+// e.g. after a config reload.
+func (c *Cache) Reconfigure(config *Config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if filepath.Clean(config.Dir) != filepath.Clean(c.config.Dir) {
+		return fmt.Errorf("disk cache dir cannot be changed from %s to %s", c.config.Dir, config.Dir)
+	}
+	c.config = *config
+	c.evict(-1, 0)
+	return nil
+}
+
+func (c *Cache) file(layer, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.config.Dir, url.PathEscape(layer), hex.EncodeToString(sum[:]))
+}
+
+// Get returns the cached data of a key of a layer.
+func (c *Cache) Get(layer, key string) ([]byte, bool) {
+	file := c.file(layer, key)
+
+	c.mu.Lock()
+	e, found := c.entries[file]
+	if found && !e.pending && c.config.maxAge > 0 && time.Since(e.created) > c.config.maxAge {
+		c.remove(e)
+		found = false
+	}
+	if !found || e.pending {
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	e.accessed = time.Now()
+	e.hits++
+	c.mu.Unlock()
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		c.mu.Lock()
+		if c.entries[file] == e {
+			c.remove(e)
+		}
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	c.mu.Lock()
+	c.hits++
+	c.mu.Unlock()
+	return data, true
+}
+
+// Put caches the data of a key of a layer, evicting other entries if
+// the quota is reached. The data is not cached if the space it needs
+// cannot be freed.
+func (c *Cache) Put(layer, key string, data []byte) error {
+	file := c.file(layer, key)
+	size := int64(len(data))
+
+	c.mu.Lock()
+	if old, found := c.entries[file]; found {
+		if old.pending {
+			// The same data is being written by another tile request.
+			c.mu.Unlock()
+			return nil
+		}
+		c.remove(old)
+	}
+	if !c.evict(c.config.reservation(layer), size) {
+		c.rejected++
+		c.mu.Unlock()
+		return fmt.Errorf("disk cache quota exceeded")
+	}
+	// The space is accounted for before writing so that concurrent
+	// writes cannot exceed the quota.
+	e := &entry{layer: layer, file: file, size: size, created: time.Now(), pending: true}
+	e.accessed = e.created
+	c.entries[file] = e
+	c.size += size
+	c.mu.Unlock()
+
+	err := writeFile(file, data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[file] != e {
+		// The entry was removed while written, e.g. by a purge, and the
+		// file renamed since would be left untracked.
+		if _, found := c.entries[file]; !found && err == nil {
+			os.Remove(file)
+		}
+		return err
+	}
+	if err != nil {
+		delete(c.entries, file)
+		c.size -= size
+		return err
+	}
+	e.pending = false
+	return nil
+}
+
+func writeFile(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// remove deletes an entry, with the lock held.
+func (c *Cache) remove(e *entry) {
+	delete(c.entries, e.file)
+	c.size -= e.size
+	os.Remove(e.file)
+}
+
+// evict frees space for an entry of the given size and reservation,
+// with the lock held. The entries of a reservation within its reserved
+// size are only evicted for entries of the same reservation, and the
+// entries being written are never evicted. It reports whether enough
+// space could be freed.
+func (c *Cache) evict(reservation int, size int64) bool {
+	maxSize := int64(c.config.MaxSize)
+	if size > maxSize {
+		return false
+	}
+	// The expired entries go first.
+	if c.config.maxAge > 0 {
+		for _, e := range c.entries {
+			if !e.pending && time.Since(e.created) > c.config.maxAge {
+				c.remove(e)
+				c.evictions++
+			}
+		}
+	}
+	if c.size+size <= maxSize {
+		return true
+	}
+
+	usage := make([]int64, len(c.config.Reservations))
+	belongs := make(map[*entry]int, len(c.entries))
+	candidates := make([]*entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		r := c.config.reservation(e.layer)
+		belongs[e] = r
+		if r >= 0 {
+			usage[r] += e.size
+		}
+		if !e.pending {
+			candidates = append(candidates, e)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if c.config.Policy == LFU && a.hits != b.hits {
+			return a.hits < b.hits
+		}
+		return a.accessed.Before(b.accessed)
+	})
+
+	for _, e := range candidates {
+		if c.size+size <= maxSize {
+			break
+		}
+		r := belongs[e]
+		if r >= 0 && r != reservation && usage[r]-e.size < int64(c.config.Reservations[r].Size) {
+			continue
+		}
+		if r >= 0 {
+			usage[r] -= e.size
+		}
+		c.remove(e)
+		c.evictions++
+	}
+	return c.size+size <= maxSize
+}
+
+// Stats returns the usage statistics of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := Stats{
+		Dir:       c.config.Dir,
+		Policy:    c.config.Policy,
+		Size:      c.size,
+		MaxSize:   int64(c.config.MaxSize),
+		Entries:   len(c.entries),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Rejected:  c.rejected,
+		Layers:    make(map[string]int64),
+	}
+	for _, e := range c.entries {
+		stats.Layers[e.layer] += e.size
+	}
+	return stats
+}
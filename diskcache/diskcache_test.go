@@ -0,0 +1,198 @@
+package diskcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T, conf string) (*Cache, func()) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config Config
+	if err := json.Unmarshal([]byte(conf), &config); err != nil {
+		t.Fatal(err)
+	}
+	config.Dir = dir
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Open(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() { os.RemoveAll(dir) }
+}
+
+func TestCache(t *testing.T) {
+	c, cleanup := newTestCache(t, `{"max_size": 300}`)
+	defer cleanup()
+
+	tile := bytes.Repeat([]byte("x"), 100)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := c.Put("chirps", key, tile); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if data, ok := c.Get("chirps", "a"); !ok || !bytes.Equal(data, tile) {
+		t.Fatalf("cached tile not found")
+	}
+	if _, ok := c.Get("other", "a"); ok {
+		t.Errorf("tile of another layer returned")
+	}
+
+	// b is the least recently used.
+	if err := c.Put("chirps", "d", tile); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("chirps", "b"); ok {
+		t.Errorf("least recently used tile not evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Get("chirps", key); !ok {
+			t.Errorf("tile %s evicted", key)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Size != 300 || stats.Entries != 3 || stats.Evictions != 1 || stats.Layers["chirps"] != 300 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if err := c.Put("chirps", "big", bytes.Repeat([]byte("x"), 400)); err == nil {
+		t.Errorf("expected error for data larger than the quota")
+	}
+
+	// The entries are indexed again on restart.
+	reopened, err := Open(&c.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := reopened.Stats(); stats.Size != 300 || stats.Entries != 3 {
+		t.Errorf("unexpected stats after restart: %+v", stats)
+	}
+	if _, ok := reopened.Get("chirps", "d"); !ok {
+		t.Errorf("tile not found after restart")
+	}
+}
+
+func TestCacheLFU(t *testing.T) {
+	c, cleanup := newTestCache(t, `{"max_size": 200, "policy": "lfu"}`)
+	defer cleanup()
+
+	tile := bytes.Repeat([]byte("x"), 100)
+	c.Put("chirps", "a", tile)
+	c.Put("chirps", "b", tile)
+	c.Get("chirps", "a")
+	c.Get("chirps", "a")
+	time.Sleep(time.Millisecond)
+	c.Get("chirps", "b")
+	c.Put("chirps", "c", tile)
+	if _, ok := c.Get("chirps", "a"); !ok {
+		t.Errorf("most frequently used tile evicted")
+	}
+	if _, ok := c.Get("chirps", "b"); ok {
+		t.Errorf("least frequently used tile not evicted")
+	}
+}
+
+func TestCachePending(t *testing.T) {
+	c, cleanup := newTestCache(t, `{"max_size": 200, "policy": "lfu"}`)
+	defer cleanup()
+
+	// a is being written, with no hits, when b and c are put.
+	tile := bytes.Repeat([]byte("x"), 100)
+	c.Put("chirps", "a", tile)
+	c.entries[c.file("chirps", "a")].pending = true
+	c.Put("chirps", "b", tile)
+	c.Put("chirps", "c", tile)
+	if err := c.Put("chirps", "a", tile); err != nil {
+		t.Fatal(err)
+	}
+	e, found := c.entries[c.file("chirps", "a")]
+	if !found || !e.pending {
+		t.Fatalf("tile being written evicted or replaced")
+	}
+	if _, ok := c.Get("chirps", "b"); ok {
+		t.Errorf("tile not evicted for c")
+	}
+	if stats := c.Stats(); stats.Size != 200 || stats.Entries != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheReservations(t *testing.T) {
+	c, cleanup := newTestCache(t, `{"max_size": 400, "reservations": [{"layers": ["forecast_*"], "size": 200}]}`)
+	defer cleanup()
+
+	tile := bytes.Repeat([]byte("x"), 100)
+	c.Put("forecast_daily", "a", tile)
+	c.Put("forecast_daily", "b", tile)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		c.Put("chirps", key, tile)
+		time.Sleep(time.Millisecond)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok := c.Get("forecast_daily", key); !ok {
+			t.Errorf("reserved tile %s evicted by another layer", key)
+		}
+	}
+	if stats := c.Stats(); stats.Layers["chirps"] != 200 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// The reserved layers may use the unreserved space as well.
+	c.Put("forecast_daily", "c", tile)
+	if stats := c.Stats(); stats.Layers["forecast_daily"] != 300 || stats.Size != 400 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	c, cleanup := newTestCache(t, `{"max_size": 400, "max_age": "10ms"}`)
+	defer cleanup()
+	c.Put("chirps", "a", []byte("tile"))
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("chirps", "a"); ok {
+		t.Errorf("expired tile returned")
+	}
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("expired tile not removed: %+v", stats)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	var config Config
+	if err := json.Unmarshal([]byte(`{"dir": "/tmp/cache", "max_size": "1.5GB"}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxSize != 3<<29 {
+		t.Errorf("unexpected max_size: %v", config.MaxSize)
+	}
+
+	invalid := []string{
+		`{"max_size": 100}`,
+		`{"dir": "/tmp/cache"}`,
+		`{"dir": "/tmp/cache", "max_size": 100, "policy": "fifo"}`,
+		`{"dir": "/tmp/cache", "max_size": 100, "max_age": "daily"}`,
+		`{"dir": "/tmp/cache", "max_size": 100, "reservations": [{"layers": ["a"], "size": 200}]}`,
+		`{"dir": "/tmp/cache", "max_size": 100, "reservations": [{"size": 10}]}`,
+	}
+	for _, conf := range invalid {
+		var config Config
+		if err := json.Unmarshal([]byte(conf), &config); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.Validate(); err == nil {
+			t.Errorf("expected error for %s", conf)
+		}
+	}
+	if _, err := ParseSize("10XB"); err == nil {
+		t.Errorf("expected error for an invalid size")
+	}
+}
@@ -6,7 +6,7 @@ v=3.0.1
 wget -q http://download.osgeo.org/gdal/${v}/gdal-${v}.tar.gz
 tar -xf gdal-${v}.tar.gz
 cd gdal-${v}
-./configure --with-geos=yes --with-netcdf
+./configure --with-geos=yes --with-netcdf --with-curl
 make -j4
 make install
 )
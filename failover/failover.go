@@ -0,0 +1,261 @@
+// Package failover routes the HTTP requests of a service replicated
+// across sites, such as MAS, to the healthiest of its endpoints. The
+// requests addressed to the primary endpoint of a group are sent to the
+// first healthy endpoint in priority order and retried on the next one
+// if it fails, while a background health check brings the endpoints
+// back once they recover.
+package failover
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthPath is the path of the health check of the endpoints.
+const HealthPath = "/readyz"
+
+// healthTimeout bounds the time of a health check.
+const healthTimeout = 2 * time.Second
+
+type endpoint struct {
+	address   string
+	healthy   bool
+	lastError string
+	checked   time.Time
+}
+
+// Transport is an http.RoundTripper failing the requests of the groups
+// over to their replicas. The requests to other hosts are sent as is.
+type Transport struct {
+	// Base sends the requests.
+	Base http.RoundTripper
+	// Notify, if set, is called whenever an endpoint changes health.
+	Notify func(address string, healthy bool, err error)
+
+	mu     sync.Mutex
+	groups map[string][]*endpoint
+}
+
+// NewTransport returns a transport sending the requests with base.
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{Base: base, groups: make(map[string][]*endpoint)}
+}
+
+// Configure sets the groups, keyed by the address of their primary
+// endpoint, e.g. host:port, with the addresses of the replicas in
+// priority order. The endpoints kept from the previous groups keep
+// their health and the new ones are assumed healthy.
+func (t *Transport) Configure(groups map[string][]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	known := make(map[string]*endpoint)
+	for _, endpoints := range t.groups {
+		for _, ep := range endpoints {
+			known[ep.address] = ep
+		}
+	}
+	t.groups = make(map[string][]*endpoint)
+	for primary, replicas := range groups {
+		if len(replicas) == 0 {
+			continue
+		}
+		var endpoints []*endpoint
+		for _, address := range append([]string{primary}, replicas...) {
+			ep, found := known[address]
+			if !found {
+				ep = &endpoint{address: address, healthy: true}
+				known[address] = ep
+			}
+			endpoints = append(endpoints, ep)
+		}
+		t.groups[primary] = endpoints
+	}
+}
+
+// Start checks the health of all the endpoints every interval until the
+// process exits. If set, refresh is called first at each check and its
+// groups are applied with Configure.
+func (t *Transport) Start(interval time.Duration, refresh func() map[string][]string) {
+	go func() {
+		for {
+			if refresh != nil {
+				t.Configure(refresh())
+			}
+			t.CheckHealth()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// CheckHealth runs the health check of all the endpoints. An endpoint
+// is healthy if its health check returns a status below 500, so that
+// the servers without health check are healthy as long as they respond.
+func (t *Transport) CheckHealth() {
+	t.mu.Lock()
+	var endpoints []*endpoint
+	seen := make(map[*endpoint]bool)
+	for _, group := range t.groups {
+		for _, ep := range group {
+			if !seen[ep] {
+				seen[ep] = true
+				endpoints = append(endpoints, ep)
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func(ep *endpoint) {
+			defer wg.Done()
+			t.setHealth(ep, t.check(ep.address))
+		}(ep)
+	}
+	wg.Wait()
+}
+
+func (t *Transport) check(address string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", "http://"+address+HealthPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.Base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("health check: %s", resp.Status)
+	}
+	return nil
+}
+
+func (t *Transport) setHealth(ep *endpoint, err error) {
+	t.mu.Lock()
+	changed := ep.healthy != (err == nil)
+	ep.healthy = err == nil
+	ep.checked = time.Now()
+	ep.lastError = ""
+	if err != nil {
+		ep.lastError = err.Error()
+	}
+	notify := t.Notify
+	t.mu.Unlock()
+
+	if changed && notify != nil {
+		notify(ep.address, err == nil, err)
+	}
+}
+
+// candidates returns the endpoints of the group of a host, the healthy
+// ones first in priority order, nil if the host isn't a primary.
+func (t *Transport) candidates(host string) []*endpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	group, found := t.groups[host]
+	if !found {
+		return nil
+	}
+	candidates := make([]*endpoint, 0, len(group))
+	for _, ep := range group {
+		if ep.healthy {
+			candidates = append(candidates, ep)
+		}
+	}
+	for _, ep := range group {
+		if !ep.healthy {
+			candidates = append(candidates, ep)
+		}
+	}
+	return candidates
+}
+
+// failoverStatus reports whether a response status is that of a proxy
+// or server unable to serve the request, which another endpoint may
+// serve.
+func failoverStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// RoundTrip sends the request to the endpoints of its group until one
+// serves it. The requests with a body which can't be replayed are only
+// sent to the first endpoint.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	candidates := t.candidates(req.URL.Host)
+	if len(candidates) == 0 {
+		return t.Base.RoundTrip(req)
+	}
+
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if !replayable {
+		candidates = candidates[:1]
+	}
+
+	var resp *http.Response
+	var err error
+	for i, ep := range candidates {
+		r := req.Clone(req.Context())
+		r.URL.Host = ep.address
+		r.Host = ep.address
+		if i > 0 && req.GetBody != nil {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err = t.Base.RoundTrip(r)
+		if err == nil && !failoverStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			break
+		}
+		failure := err
+		if failure == nil {
+			failure = fmt.Errorf("%s", resp.Status)
+		}
+		t.setHealth(ep, failure)
+		if i < len(candidates)-1 && resp != nil {
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+// EndpointStatus is the health of an endpoint of a group.
+type EndpointStatus struct {
+	Address   string    `json:"address"`
+	Primary   string    `json:"primary"`
+	Healthy   bool      `json:"healthy"`
+	Active    bool      `json:"active"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Status returns the health of the endpoints of all the groups. The
+// active endpoint of a group is the one receiving its requests.
+func (t *Transport) Status() []EndpointStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var statuses []EndpointStatus
+	for primary, group := range t.groups {
+		active := false
+		for _, ep := range group {
+			s := EndpointStatus{Address: ep.address, Primary: primary, Healthy: ep.healthy, Error: ep.lastError, CheckedAt: ep.checked}
+			if ep.healthy && !active {
+				s.Active, active = true, true
+			}
+			statuses = append(statuses, s)
+		}
+	}
+	sort.SliceStable(statuses, func(i, j int) bool { return statuses[i].Primary < statuses[j].Primary })
+	return statuses
+}
@@ -0,0 +1,108 @@
+package failover
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type testServer struct {
+	*httptest.Server
+	status int32
+	ready  int32
+}
+
+func newTestServer(name string) *testServer {
+	s := &testServer{status: http.StatusOK, ready: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == HealthPath {
+			w.WriteHeader(int(atomic.LoadInt32(&s.ready)))
+			return
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&s.status)))
+		fmt.Fprint(w, name)
+	}))
+	return s
+}
+
+func (s *testServer) address() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func get(t *testing.T, client *http.Client, address string) string {
+	resp, err := client.Get("http://" + address + "/collection?intersects")
+	if err != nil {
+		return "error"
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestTransport(t *testing.T) {
+	primary, replica := newTestServer("primary"), newTestServer("replica")
+	defer primary.Close()
+	defer replica.Close()
+	other := newTestServer("other")
+	defer other.Close()
+
+	var changes []string
+	tr := NewTransport(http.DefaultTransport)
+	tr.Notify = func(address string, healthy bool, err error) {
+		changes = append(changes, fmt.Sprintf("%s %v", address, healthy))
+	}
+	tr.Configure(map[string][]string{primary.address(): {replica.address()}})
+	client := &http.Client{Transport: tr}
+
+	if body := get(t, client, primary.address()); body != "primary" {
+		t.Errorf("expected primary, got %s", body)
+	}
+	if body := get(t, client, other.address()); body != "other" {
+		t.Errorf("expected other, got %s", body)
+	}
+
+	// The failed request is retried on the replica.
+	atomic.StoreInt32(&primary.status, http.StatusServiceUnavailable)
+	if body := get(t, client, primary.address()); body != "replica" {
+		t.Errorf("expected replica, got %s", body)
+	}
+	atomic.StoreInt32(&primary.status, http.StatusOK)
+	if body := get(t, client, primary.address()); body != "replica" {
+		t.Errorf("expected replica until the primary is healthy again, got %s", body)
+	}
+	status := tr.Status()
+	if len(status) != 2 || status[0].Healthy || status[0].Active || !status[1].Active {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	tr.CheckHealth()
+	if body := get(t, client, primary.address()); body != "primary" {
+		t.Errorf("expected primary once healthy, got %s", body)
+	}
+
+	// Unhealthy endpoints are only tried last.
+	atomic.StoreInt32(&primary.ready, http.StatusServiceUnavailable)
+	tr.CheckHealth()
+	if body := get(t, client, primary.address()); body != "replica" {
+		t.Errorf("expected replica, got %s", body)
+	}
+	atomic.StoreInt32(&replica.status, http.StatusBadGateway)
+	if body := get(t, client, primary.address()); body != "primary" {
+		t.Errorf("expected unhealthy primary as last resort, got %s", body)
+	}
+
+	replica.Close()
+	atomic.StoreInt32(&primary.ready, http.StatusOK)
+	tr.CheckHealth()
+	expected := []string{primary.address() + " false", primary.address() + " true", primary.address() + " false", replica.address() + " false", primary.address() + " true"}
+	if fmt.Sprint(changes) != fmt.Sprint(expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+}
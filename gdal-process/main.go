@@ -76,11 +76,18 @@ func dataHandler(conn net.Conn, timeout int) {
 		out = gp.ComputeReprojectExtent(in)
 	case "info":
 		out = gp.ExtractGDALInfo(in)
+	case "open":
+		out = gp.OpenDataset(in)
 	default:
 		out.Error = fmt.Sprintf("Unknown operation: %s", in.Operation)
 	}
 	done <- true
 
+	if out.Metrics == nil {
+		out.Metrics = &pb.WorkerMetrics{}
+	}
+	out.Metrics.GdalCacheUsed = gp.GDALCacheUsed()
+
 	err = sendOutput(out, conn)
 	if err != nil {
 		log.Println(err)
@@ -1,9 +1,8 @@
 module github.com/nci/gsky
 
-go 1.16
+go 1.17
 
 require (
-	github.com/CloudyKit/fastprinter v0.0.0-20200109182630-33d98a066a53 // indirect
 	github.com/edisonguo/govaluate v3.0.1-0.20200610034316-8fb8220f46f3+incompatible
 	github.com/edisonguo/jet v2.1.2+incompatible
 	github.com/golang/protobuf v1.5.2
@@ -18,3 +17,11 @@ require (
 	google.golang.org/protobuf v1.26.0
 	gopkg.in/yaml.v2 v2.4.0
 )
+
+require (
+	github.com/CloudyKit/fastprinter v0.0.0-20200109182630-33d98a066a53 // indirect
+	golang.org/x/sys v0.0.0-20210423082822-04245dca01da // indirect
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1 // indirect
+	golang.org/x/text v0.3.6 // indirect
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
+)
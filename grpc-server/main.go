@@ -8,28 +8,55 @@ import (
 	"runtime"
 	"strings"
 
+	"github.com/nci/gsky/lifecycle"
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/tracing"
 	pp "github.com/nci/gsky/worker/gdalprocess"
 	pb "github.com/nci/gsky/worker/gdalservice"
 
 	"os"
-	"os/signal"
-	"syscall"
+	"time"
 
 	reuseport "github.com/kavu/go_reuseport"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type server struct {
 	PoolSize int
 	Pool     *pp.ProcessPool
+	Recorder *pp.WarmupRecorder
+	Metrics  *workerMetrics
+	Cache    *pp.ResultCache
 }
 
-func (s *server) Process(ctx context.Context, in *pb.GeoRPCGranule) (*pb.Result, error) {
+func (s *server) Process(ctx context.Context, in *pb.GeoRPCGranule) (res *pb.Result, err error) {
 	if in.Operation == "worker_info" {
 		return &pb.Result{WorkerInfo: &pb.WorkerInfo{PoolSize: int32(s.PoolSize)}}, nil
 	}
 
+	span := tracing.SpanFromContext(ctx)
+	span.SetAttribute("gsky.operation", in.Operation)
+	span.SetAttribute("gsky.path", in.Path)
+
+	if s.Metrics != nil {
+		t0 := s.Metrics.taskStarted()
+		defer func() { s.Metrics.taskDone(in.Operation, t0, err) }()
+	}
+
+	if s.Recorder != nil && (in.Operation == "warp" || in.Operation == "drill") {
+		s.Recorder.Record(in.Path)
+	}
+
+	if s.Cache != nil && s.Cache.Cacheable(in) {
+		return s.Cache.Get(in, func() (*pb.Result, error) { return s.process(in) })
+	}
+	return s.process(in)
+}
+
+func (s *server) process(in *pb.GeoRPCGranule) (*pb.Result, error) {
 	rChan := make(chan *pb.Result, 1)
 	defer close(rChan)
 	errChan := make(chan error, 1)
@@ -45,6 +72,9 @@ func (s *server) Process(ctx context.Context, in *pb.GeoRPCGranule) (*pb.Result,
 		if out.Error != "OK" {
 			return &pb.Result{}, fmt.Errorf("%s", out.Error)
 		}
+		if in.Checksum && out.Raster != nil {
+			out.Raster.Checksum = pb.RasterChecksum(out.Raster.Data)
+		}
 		return out, nil
 	case err := <-errChan:
 		return &pb.Result{}, fmt.Errorf("Error in ops: %v", err)
@@ -57,29 +87,37 @@ func main() {
 	executable := flag.String("exec", filepath.Dir(os.Args[0])+"/gsky-gdal-process", "Executable filepath")
 	maxTaskProcessed := flag.Int("max_tasks", 20000, "Maximum number of tasks processed before starting gsky-gdal-process.")
 	oomThreshold := flag.Int("oom_threshold", int(1.5*1024*1024), "MemAvailable lower than the threshold (KB) triggers an OOM of the worker process")
+	warmupFile := flag.String("warmup", "", "File listing datasets to pre-open at startup, one path per line.")
+	warmupRecord := flag.Bool("warmup_record", false, "Periodically save the most requested datasets to the -warmup file for replay at the next startup.")
+	warmupInterval := flag.Int("warmup_interval", 300, "Interval in seconds between saves of the warm-up file.")
+	warmupTopN := flag.Int("warmup_top_n", pp.DefaultWarmupTopN, "Maximum number of datasets saved to the warm-up file.")
+	resultCacheTTL := flag.Int("result_cache_ttl", 0, "Seconds to cache warp results for reuse by identical tasks. Disabled if 0.")
+	resultCacheSize := flag.Int("result_cache_size", 512, "Maximum size in MB of the result cache.")
+	metricsPort := flag.Int("metrics_port", 0, "Port serving Prometheus metrics at /metrics. Disabled if 0.")
+	otlpEndpoint := flag.String("otlp_endpoint", "", "OTLP/HTTP endpoint receiving the traces, e.g. http://localhost:4318. Defaults to $OTEL_EXPORTER_OTLP_ENDPOINT. Tracing is disabled if empty.")
+	traceSampleRatio := flag.Float64("trace_sample_ratio", 1.0, "Fraction of the traces started by this worker that are recorded. Traces started upstream follow the sampling of their parent.")
+	logLevel := flag.String("log_level", os.Getenv("GSKY_LOG_LEVEL"), "Minimum level of the logs written: debug, info, warn or error. Defaults to info.")
+	logFormat := flag.String("log_format", os.Getenv("GSKY_LOG_FORMAT"), "Format of the logs: text or json. Defaults to text.")
+	drainDelay := flag.Int("drain_delay", 5, "Seconds between SIGTERM, from which the gRPC health service reports NOT_SERVING, and the stop of the server, for the OWS to stop sending new tasks.")
+	shutdownTimeout := flag.Int("shutdown_timeout", 20, "Maximum seconds waited for the tasks in flight to finish after the drain delay.")
 	verbose := flag.Bool("verbose", false, "verbose logging")
 	flag.Parse()
 
+	if err := logging.Init("worker", *logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	procPool, err := pp.CreateProcessPool(*poolSize, *executable, *port, *maxTaskProcessed, *verbose)
 	if err != nil {
 		log.Printf("Failed to create process pool: %v", err)
 		os.Exit(2)
 	}
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
-	go func() {
-		for {
-			select {
-			case <-signals:
-				for _, proc := range procPool.Pool {
-					proc.RemoveTempFiles()
-				}
-
-				os.Exit(1)
-			}
-		}
-	}()
+	var recorder *pp.WarmupRecorder
+	if len(*warmupFile) > 0 && *warmupRecord {
+		recorder = pp.NewWarmupRecorder()
+	}
 
 	go func() {
 		parts := strings.Split(*executable, "/")
@@ -95,8 +133,63 @@ func main() {
 		mon.StartMonitorLoop()
 	}()
 
-	s := grpc.NewServer()
-	pb.RegisterGDALServer(s, &server{Pool: procPool, PoolSize: *poolSize})
+	if len(*warmupFile) > 0 {
+		// The worker serves the health checks while warming up but is
+		// only reported ready once the datasets have been opened.
+		releaseWarmup := lifecycle.Default.Hold("warmup")
+		go func() {
+			defer releaseWarmup()
+			paths, err := pp.LoadWarmupList(*warmupFile)
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to load warm-up file: %v", err)
+			}
+			if len(paths) > 0 {
+				t0 := time.Now()
+				nOpened := procPool.Warmup(paths, *poolSize, *verbose)
+				log.Printf("Warm-up completed: %d of %d datasets opened in %v", nOpened, len(paths), time.Since(t0))
+			}
+		}()
+
+		if recorder != nil {
+			go func() {
+				for range time.Tick(time.Duration(*warmupInterval) * time.Second) {
+					if err := recorder.Save(*warmupFile, *warmupTopN); err != nil {
+						log.Printf("Failed to save warm-up file: %v", err)
+					}
+				}
+			}()
+		}
+	}
+
+	var resultCache *pp.ResultCache
+	if *resultCacheTTL > 0 {
+		resultCache = pp.NewResultCache(time.Duration(*resultCacheTTL)*time.Second, int64(*resultCacheSize)*1024*1024)
+	}
+
+	var metricsServer *workerMetrics
+	if *metricsPort > 0 {
+		metricsServer = newWorkerMetrics(procPool, resultCache)
+		go metricsServer.serve(*metricsPort)
+	}
+
+	if err := tracing.Init("gsky-worker", *otlpEndpoint, *traceSampleRatio); err != nil {
+		log.Printf("Failed to initialise tracing: %v", err)
+		os.Exit(2)
+	}
+	defer tracing.Shutdown()
+
+	s := grpc.NewServer(grpc.UnaryInterceptor(tracing.UnaryServerInterceptor))
+	pb.RegisterGDALServer(s, &server{Pool: procPool, PoolSize: *poolSize, Recorder: recorder, Metrics: metricsServer, Cache: resultCache})
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	lifecycle.Default.OnChange(func(ready bool) {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if ready {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		healthServer.SetServingStatus("", status)
+	})
 
 	lis, err := reuseport.Listen("tcp", fmt.Sprintf(":%d", *port))
 	if err != nil {
@@ -106,7 +199,35 @@ func main() {
 
 	log.Printf("GSKY gRPC is listening on :%d", *port)
 
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	serve := func() error { return s.Serve(lis) }
+	stop := func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			s.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			s.Stop()
+			return fmt.Errorf("tasks still in flight after the shutdown timeout")
+		}
+	}
+	err = lifecycle.Default.Run(serve, stop, time.Duration(*drainDelay)*time.Second, time.Duration(*shutdownTimeout)*time.Second)
+	if err != nil {
+		log.Printf("Failed to stop gracefully: %v", err)
+	}
+
+	if recorder != nil {
+		if err := recorder.Save(*warmupFile, *warmupTopN); err != nil {
+			log.Printf("Failed to save warm-up file: %v", err)
+		}
+	}
+	for _, proc := range procPool.Pool {
+		if proc != nil {
+			proc.RemoveTempFiles()
+		}
 	}
+	log.Printf("GSKY gRPC has stopped")
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nci/gsky/metrics/prom"
+	"github.com/nci/gsky/utils"
+	pp "github.com/nci/gsky/worker/gdalprocess"
+)
+
+type workerMetrics struct {
+	registry      *prom.Registry
+	inFlight      int64
+	tasks         *prom.CounterVec
+	taskDuration  *prom.HistogramVec
+	inFlightGauge *prom.Gauge
+	queueLength   *prom.Gauge
+	poolSize      *prom.Gauge
+	utilisation   *prom.Gauge
+	procCPU       *prom.GaugeVec
+	procRSS       *prom.GaugeVec
+	procOpenFiles *prom.GaugeVec
+	procGdalCache *prom.GaugeVec
+	cache         *prom.CacheMetrics
+	cacheHits     int64
+	cacheMisses   int64
+}
+
+func newWorkerMetrics(pool *pp.ProcessPool, resultCache *pp.ResultCache) *workerMetrics {
+	m := &workerMetrics{
+		registry:      prom.NewRegistry(),
+		tasks:         prom.NewCounterVec("gsky_worker_tasks_total", "Number of tasks processed by the worker.", "operation", "status"),
+		taskDuration:  prom.NewHistogramVec("gsky_worker_task_duration_seconds", "Task latency in seconds including queueing time.", nil, "operation"),
+		procCPU:       prom.NewGaugeVec("gsky_worker_process_cpu_seconds", "User and system CPU time consumed by a gsky-gdal-process since it started.", "process"),
+		procRSS:       prom.NewGaugeVec("gsky_worker_process_resident_memory_bytes", "Resident memory size of a gsky-gdal-process.", "process"),
+		procOpenFiles: prom.NewGaugeVec("gsky_worker_process_open_fds", "Number of open file descriptors of a gsky-gdal-process.", "process"),
+		procGdalCache: prom.NewGaugeVec("gsky_worker_process_gdal_cache_bytes", "GDAL block cache usage of a gsky-gdal-process as of its last task.", "process"),
+		cache:         prom.NewCacheMetrics("worker"),
+	}
+
+	inFlightVec, inFlight := prom.NewGauge("gsky_worker_tasks_in_flight", "Number of tasks being processed or queued.")
+	queueVec, queueLength := prom.NewGauge("gsky_worker_task_queue_length", "Number of tasks waiting in the process pool queue.")
+	poolVec, poolSize := prom.NewGauge("gsky_worker_pool_size", "Number of gsky-gdal-process subprocesses.")
+	utilisationVec, utilisation := prom.NewGauge("gsky_worker_utilization_ratio", "Fraction of the gsky-gdal-process subprocesses busy with a task.")
+	m.inFlightGauge = inFlight
+	m.queueLength = queueLength
+	m.poolSize = poolSize
+	m.utilisation = utilisation
+
+	m.registry.MustRegister(m.tasks, m.taskDuration, inFlightVec, queueVec, poolVec, utilisationVec, m.procCPU, m.procRSS, m.procOpenFiles, m.procGdalCache)
+	m.registry.MustRegister(m.cache.Collectors()...)
+	prom.RegisterProcessMetrics(m.registry, "worker", utils.GSKYVersion)
+	m.registry.OnScrape(func() {
+		m.collectPool(pool)
+		m.collectResultCache(resultCache)
+	})
+	return m
+}
+
+// collectPool refreshes the per-process gauges from /proc.
+func (m *workerMetrics) collectPool(pool *pp.ProcessPool) {
+	m.inFlightGauge.Set(float64(atomic.LoadInt64(&m.inFlight)))
+	m.queueLength.Set(float64(len(pool.TaskQueue)))
+	m.poolSize.Set(float64(pool.PoolSize))
+	if pool.PoolSize > 0 {
+		busy := atomic.LoadInt64(&m.inFlight)
+		if busy > int64(pool.PoolSize) {
+			busy = int64(pool.PoolSize)
+		}
+		m.utilisation.Set(float64(busy) / float64(pool.PoolSize))
+	}
+
+	m.procCPU.Reset()
+	m.procRSS.Reset()
+	m.procOpenFiles.Reset()
+	m.procGdalCache.Reset()
+	for ip, proc := range pool.Pool {
+		if proc == nil {
+			continue
+		}
+		stats, err := proc.Stats()
+		if err != nil {
+			continue
+		}
+		label := strconv.Itoa(ip)
+		m.procCPU.With(label).Set(stats.CPUSeconds)
+		m.procRSS.With(label).Set(float64(stats.RSSBytes))
+		m.procOpenFiles.With(label).Set(float64(stats.OpenFiles))
+		m.procGdalCache.With(label).Set(float64(stats.GdalCacheUsed))
+	}
+}
+
+// collectResultCache adds the result cache lookups since the last
+// scrape.
+func (m *workerMetrics) collectResultCache(resultCache *pp.ResultCache) {
+	if resultCache == nil {
+		return
+	}
+	hits, misses := resultCache.Stats()
+	m.cache.Add("result", true, float64(hits-m.cacheHits))
+	m.cache.Add("result", false, float64(misses-m.cacheMisses))
+	m.cacheHits, m.cacheMisses = hits, misses
+}
+
+func (m *workerMetrics) taskStarted() time.Time {
+	atomic.AddInt64(&m.inFlight, 1)
+	return time.Now()
+}
+
+func (m *workerMetrics) taskDone(operation string, t0 time.Time, err error) {
+	atomic.AddInt64(&m.inFlight, -1)
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.tasks.With(operation, status).Inc()
+	m.taskDuration.With(operation).Observe(time.Since(t0).Seconds())
+}
+
+// serve exposes the metrics at /metrics on the given port.
+func (m *workerMetrics) serve(port int) {
+	prom.ListenAndServe(m.registry, "worker", port)
+}
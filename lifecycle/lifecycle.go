@@ -0,0 +1,261 @@
+// Package lifecycle integrates the GSKY services with the probes and the
+// termination sequence of an orchestrator such as Kubernetes.
+//
+// A Probe reports whether the process is ready to receive traffic. It is
+// unready while an operation holds it, e.g. a config reload, and for good
+// once the process starts draining. On SIGTERM, Run marks the probe as
+// draining and waits for the load balancers to stop routing new requests
+// to the process before stopping the server, which then finishes the
+// requests in flight.
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Default is the probe of the process, held by the operations which
+// make the service temporarily unable to serve requests.
+var Default = NewProbe()
+
+// Probe tracks the readiness of a process.
+type Probe struct {
+	mu       sync.Mutex
+	holds    map[string]int
+	checks   []check
+	draining bool
+	notify   []func(ready bool)
+	ready    bool
+}
+
+type check struct {
+	name     string
+	f        func(ctx context.Context) error
+	optional bool
+}
+
+// NewProbe returns a probe which is ready until held or drained.
+func NewProbe() *Probe {
+	return &Probe{holds: make(map[string]int), ready: true}
+}
+
+// Hold marks the probe as unready for the given reason until the
+// returned function is called. Holds of the same reason may overlap.
+func (p *Probe) Hold(reason string) (release func()) {
+	p.mu.Lock()
+	p.holds[reason]++
+	p.changed()
+	p.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.holds[reason]--; p.holds[reason] <= 0 {
+				delete(p.holds, reason)
+			}
+			p.changed()
+		})
+	}
+}
+
+// AddCheck adds a dependency checked by the readiness probe, e.g. a
+// database ping. The probe is unready while f returns an error.
+func (p *Probe) AddCheck(name string, f func(ctx context.Context) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checks = append(p.checks, check{name: name, f: f})
+}
+
+// AddOptionalCheck adds a dependency reported by the readiness probe
+// without making it unready, e.g. a cache the process works without.
+func (p *Probe) AddOptionalCheck(name string, f func(ctx context.Context) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checks = append(p.checks, check{name: name, f: f, optional: true})
+}
+
+// OnChange registers f to be called whenever the readiness set by the
+// holds and the draining changes. The checks don't trigger it.
+func (p *Probe) OnChange(f func(ready bool)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.notify = append(p.notify, f)
+	f(p.ready)
+}
+
+// Drain marks the probe as unready for the remaining life of the
+// process.
+func (p *Probe) Drain() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draining = true
+	p.changed()
+}
+
+// Draining reports whether Drain was called.
+func (p *Probe) Draining() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.draining
+}
+
+// changed must be called with p.mu held.
+func (p *Probe) changed() {
+	ready := !p.draining && len(p.holds) == 0
+	if ready == p.ready {
+		return
+	}
+	p.ready = ready
+	for _, f := range p.notify {
+		f(ready)
+	}
+}
+
+// Status is the state of a probe, reported as JSON by /readyz.
+type Status struct {
+	Ready   bool     `json:"ready"`
+	Reasons []string `json:"reasons,omitempty"`
+	// Checks are the results of the checks, ok or their error. The
+	// checks are not run while the probe is held or draining.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Status returns the state of the probe, running its checks.
+func (p *Probe) Status(ctx context.Context) Status {
+	p.mu.Lock()
+	var reasons []string
+	if p.draining {
+		reasons = append(reasons, "draining")
+	}
+	for reason := range p.holds {
+		reasons = append(reasons, reason)
+	}
+	checks := p.checks
+	p.mu.Unlock()
+	sort.Strings(reasons)
+
+	var status Status
+	if len(reasons) == 0 && len(checks) > 0 {
+		status.Checks = make(map[string]string)
+		for _, c := range checks {
+			status.Checks[c.name] = "ok"
+			if err := c.f(ctx); err != nil {
+				status.Checks[c.name] = err.Error()
+				if !c.optional {
+					reasons = append(reasons, fmt.Sprintf("%s: %v", c.name, err))
+				}
+			}
+		}
+	}
+	status.Ready = len(reasons) == 0
+	status.Reasons = reasons
+	return status
+}
+
+// Ready reports whether the process is ready to receive traffic and,
+// if not, the reasons why.
+func (p *Probe) Ready(ctx context.Context) (bool, string) {
+	status := p.Status(ctx)
+	return status.Ready, strings.Join(status.Reasons, ", ")
+}
+
+// checkTimeout bounds the time spent by the readiness checks of a
+// single probe request.
+const checkTimeout = 2 * time.Second
+
+// Register adds the liveness probe at /healthz and the readiness probe
+// at /readyz to mux. Both answer with a JSON status, the readiness probe
+// with 503 while unready.
+func (p *Probe) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", p.serveReady)
+}
+
+func writeStatus(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *Probe) serveReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	status := p.Status(ctx)
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeStatus(w, code, status)
+}
+
+// Run calls serve and waits for SIGTERM or SIGINT. On SIGTERM, the probe
+// is drained and stop is called after drainDelay, the time for the
+// orchestrator to remove the process from the endpoints of its service.
+// SIGINT stops the server without delay. stop must finish the requests
+// in flight before timeout. Run returns the error of serve, if any, or
+// of stop.
+func (p *Probe) Run(serve func() error, stop func(ctx context.Context) error, drainDelay, timeout time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	errc := make(chan error, 1)
+	go func() { errc <- serve() }()
+
+	var sig os.Signal
+	select {
+	case err := <-errc:
+		return err
+	case sig = <-signals:
+	}
+
+	p.Drain()
+	if sig == syscall.SIGTERM && drainDelay > 0 {
+		select {
+		case <-time.After(drainDelay):
+		case <-signals:
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return stop(ctx)
+}
+
+// RunHTTP serves srv with Run, stopping it with srv.Shutdown.
+func (p *Probe) RunHTTP(srv *http.Server, drainDelay, timeout time.Duration) error {
+	serve := func() error {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	}
+	return p.Run(serve, srv.Shutdown, drainDelay, timeout)
+}
+
+// RunHTTPS is RunHTTP serving srv over TLS with the certificate and key
+// files, or with the certificates of srv.TLSConfig if they are empty.
+func (p *Probe) RunHTTPS(srv *http.Server, certFile, keyFile string, drainDelay, timeout time.Duration) error {
+	serve := func() error {
+		if err := srv.ListenAndServeTLS(certFile, keyFile); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	}
+	return p.Run(serve, srv.Shutdown, drainDelay, timeout)
+}
@@ -0,0 +1,85 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	p := NewProbe()
+	var changes []bool
+	p.OnChange(func(ready bool) { changes = append(changes, ready) })
+	ctx := context.Background()
+
+	if ready, _ := p.Ready(ctx); !ready {
+		t.Fatalf("new probe must be ready")
+	}
+
+	release1 := p.Hold("config_reload")
+	release2 := p.Hold("config_reload")
+	if ready, reason := p.Ready(ctx); ready || reason != "config_reload" {
+		t.Errorf("expected unready for config_reload, got %v %q", ready, reason)
+	}
+	release1()
+	release1()
+	if ready, _ := p.Ready(ctx); ready {
+		t.Errorf("probe ready with a hold left")
+	}
+	release2()
+	if ready, _ := p.Ready(ctx); !ready {
+		t.Errorf("probe unready after all holds released")
+	}
+
+	var dbErr error
+	p.AddCheck("db", func(ctx context.Context) error { return dbErr })
+	dbErr = fmt.Errorf("connection refused")
+	if ready, reason := p.Ready(ctx); ready || reason != "db: connection refused" {
+		t.Errorf("expected unready for db, got %v %q", ready, reason)
+	}
+	dbErr = nil
+
+	p.Drain()
+	release := p.Hold("worker_pool")
+	release()
+	if ready, reason := p.Ready(ctx); ready || reason != "draining" || !p.Draining() {
+		t.Errorf("expected draining, got %v %q", ready, reason)
+	}
+
+	expected := []bool{true, false, true, false}
+	if fmt.Sprint(changes) != fmt.Sprint(expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestProbeHandlers(t *testing.T) {
+	p := NewProbe()
+	mux := http.NewServeMux()
+	p.Register(mux)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/readyz"); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+	var cacheErr error
+	p.AddOptionalCheck("memcache", func(ctx context.Context) error { return cacheErr })
+	cacheErr = fmt.Errorf("connection refused")
+	if w := get("/readyz"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"memcache":"connection refused"`) {
+		t.Errorf("expected 200 with the optional check failed, got %d %q", w.Code, w.Body.String())
+	}
+	p.Drain()
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "draining") {
+		t.Errorf("expected 503 draining, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("liveness must not depend on readiness, got %d", w.Code)
+	}
+}
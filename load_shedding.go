@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nci/gsky/loadshed"
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/metrics"
+)
+
+var shedMonitor *loadshed.Monitor
+
+// requestPriority returns the priority of an OWS request under load:
+// the downloads and processing are shed first, then the rendering,
+// while the metadata requests are always served.
+func requestPriority(query map[string][]string) loadshed.Priority {
+	if _, isDap := query["dap4.ce"]; isDap {
+		return loadshed.Low
+	}
+	if len(query["request"]) == 0 {
+		return loadshed.High
+	}
+	switch query["request"][0] {
+	case "GetCoverage", "Execute":
+		return loadshed.Low
+	case "GetMap", "GetFeatureInfo":
+		return loadshed.Normal
+	}
+	return loadshed.High
+}
+
+// shedRequest writes the 503 response of a request rejected under
+// memory or CPU pressure.
+func shedRequest(ctx context.Context, w http.ResponseWriter, query map[string][]string, metricsCollector *metrics.MetricsCollector) bool {
+	priority := requestPriority(query)
+	if shedMonitor.Admit(priority) {
+		return false
+	}
+	status := shedMonitor.Status()
+	logging.FromContext(ctx).Warnf("%s priority request shed: memory %d bytes, cpu %.2f", priority, status.Memory, status.CPU)
+	owsProm.observeShed(priority)
+	metricsCollector.Info.HTTPStatus = http.StatusServiceUnavailable
+	w.Header().Set("Retry-After", "10")
+	http.Error(w, "Server overloaded, please retry later", http.StatusServiceUnavailable)
+	return true
+}
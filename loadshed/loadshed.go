@@ -0,0 +1,153 @@
+// Package loadshed rejects the low priority requests of a server under
+// memory or CPU pressure, so that the interactive requests keep being
+// served rather than the process growing until it is killed for running
+// out of memory.
+package loadshed
+
+import (
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Priority is the priority of a request. The low priority requests are
+// shed first.
+type Priority int
+
+const (
+	// Low is the priority of the data downloads and processing, e.g.
+	// WCS GetCoverage and WPS Execute.
+	Low Priority = iota
+	// Normal is the priority of the rendering requests, e.g. GetMap.
+	Normal
+	// High is the priority of the metadata requests, which are never
+	// shed.
+	High
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Low:
+		return "low"
+	case Normal:
+		return "normal"
+	}
+	return "high"
+}
+
+// The shedding levels: the requests whose priority is below the level
+// are rejected.
+const (
+	LevelNone = int(Low)
+	LevelLow  = int(Normal)
+	LevelAll  = int(High)
+)
+
+// severeFactor is the pressure above the thresholds from which the
+// normal priority requests are shed as well.
+const severeFactor = 1.25
+
+// Sample is a measurement of the resources used by the process.
+type Sample struct {
+	// Memory is the memory obtained from the OS by the Go runtime and
+	// not released, in bytes.
+	Memory uint64
+	// CPU is the CPU time used by the process since its start.
+	CPU time.Duration
+}
+
+// Status is the pressure last measured.
+type Status struct {
+	Memory uint64  `json:"memory"`
+	CPU    float64 `json:"cpu"`
+	Level  int     `json:"level"`
+}
+
+// Monitor measures the resources used by the process at regular
+// intervals and sets the shedding level from its thresholds.
+type Monitor struct {
+	memoryLimit uint64
+	cpuLimit    float64
+	numCPU      int
+	sample      func() Sample
+
+	mu     sync.Mutex
+	last   Sample
+	lastAt time.Time
+	status Status
+}
+
+// NewMonitor returns a monitor shedding the requests above memoryLimit
+// bytes or above the fraction cpuLimit of all the CPUs, each limit
+// being disabled if 0. Start starts the measurements.
+func NewMonitor(memoryLimit uint64, cpuLimit float64) *Monitor {
+	return &Monitor{memoryLimit: memoryLimit, cpuLimit: cpuLimit, numCPU: runtime.NumCPU(), sample: measure}
+}
+
+// Start measures the resources every interval until the process exits.
+func (m *Monitor) Start(interval time.Duration) {
+	m.update(m.sample(), time.Now())
+	go func() {
+		for now := range time.Tick(interval) {
+			m.update(m.sample(), now)
+		}
+	}()
+}
+
+func measure() Sample {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := Sample{Memory: ms.Sys - ms.HeapReleased}
+
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err == nil {
+		s.CPU = time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	}
+	return s
+}
+
+func (m *Monitor) update(s Sample, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cpu := m.status.CPU
+	if !m.lastAt.IsZero() && now.After(m.lastAt) {
+		cpu = float64(s.CPU-m.last.CPU) / float64(now.Sub(m.lastAt)) / float64(m.numCPU)
+	}
+	m.last, m.lastAt = s, now
+
+	pressure := 0.0
+	if m.memoryLimit > 0 {
+		pressure = float64(s.Memory) / float64(m.memoryLimit)
+	}
+	if m.cpuLimit > 0 && cpu/m.cpuLimit > pressure {
+		pressure = cpu / m.cpuLimit
+	}
+
+	level := LevelNone
+	if pressure >= severeFactor {
+		level = LevelAll
+	} else if pressure >= 1 {
+		level = LevelLow
+	}
+	m.status = Status{Memory: s.Memory, CPU: cpu, Level: level}
+}
+
+// Admit reports whether a request of the given priority is served. A
+// nil monitor admits everything.
+func (m *Monitor) Admit(p Priority) bool {
+	if m == nil {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return p == High || int(p) >= m.status.Level
+}
+
+// Status returns the pressure last measured.
+func (m *Monitor) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
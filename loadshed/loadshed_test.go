@@ -0,0 +1,44 @@
+package loadshed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	m := NewMonitor(1000, 0.5)
+	m.numCPU = 2
+	t0 := time.Now()
+
+	cases := []struct {
+		memory uint64
+		cpu    time.Duration
+		level  int
+	}{
+		{500, 0, LevelNone},
+		{1000, 0, LevelLow},
+		{1300, 0, LevelAll},
+		{500, 1200 * time.Millisecond, LevelLow},
+		{500, 1300 * time.Millisecond, LevelNone},
+	}
+	for i, c := range cases {
+		m.update(Sample{Memory: c.memory, CPU: c.cpu}, t0.Add(time.Duration(i)*time.Second))
+		if status := m.Status(); status.Level != c.level {
+			t.Errorf("case %d: expected level %d, got %+v", i, c.level, status)
+		}
+	}
+
+	m.update(Sample{Memory: 1100}, t0.Add(10*time.Second))
+	if m.Admit(Low) || !m.Admit(Normal) || !m.Admit(High) {
+		t.Errorf("unexpected admission at level %d", m.Status().Level)
+	}
+	m.update(Sample{Memory: 2000}, t0.Add(11*time.Second))
+	if m.Admit(Low) || m.Admit(Normal) || !m.Admit(High) {
+		t.Errorf("unexpected admission at level %d", m.Status().Level)
+	}
+
+	var nilMonitor *Monitor
+	if !nilMonitor.Admit(Low) {
+		t.Errorf("nil monitor must admit everything")
+	}
+}
@@ -0,0 +1,384 @@
+// Package logging implements the structured logging shared by the GSKY
+// binaries. Log records carry a level, the component emitting them and
+// arbitrary key value fields and are written either as plain text or
+// as one JSON object per line for centralised log analysis.
+//
+// Init also redirects the standard library logger so that the existing
+// log.Printf calls of the shared packages are written in the same
+// format at the info level.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log record.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name. An empty name is the info level.
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "":
+		return LevelInfo, nil
+	case "warning":
+		return LevelWarn, nil
+	}
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("invalid log level: %q, valid levels are %s", name, strings.Join(levelNames, ", "))
+}
+
+// Format is the encoding of the log records.
+type Format int
+
+const (
+	FormatText Format = iota
+	FormatJSON
+)
+
+// ParseFormat parses a format name. An empty name is the text format.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	default:
+		return FormatText, fmt.Errorf("invalid log format: %q, valid formats are text and json", name)
+	}
+}
+
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	format Format
+
+	// recent is a ring buffer of the latest warning and error records.
+	recent     []Record
+	recentNext int
+}
+
+// recentRecords is the number of warning and error records kept for
+// Recent.
+const recentRecords = 100
+
+// Record is a warning or error record returned by Recent.
+type Record struct {
+	Time      time.Time         `json:"time"`
+	Level     string            `json:"level"`
+	Component string            `json:"component,omitempty"`
+	Caller    string            `json:"caller,omitempty"`
+	Msg       string            `json:"msg"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// Recent returns the latest warning and error records, the most recent
+// first, regardless of the level of the logs written.
+func Recent() []Record {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	records := make([]Record, 0, len(out.recent))
+	for i := 1; i <= len(out.recent); i++ {
+		records = append(records, out.recent[(out.recentNext-i+len(out.recent))%len(out.recent)])
+	}
+	return records
+}
+
+func (o *output) keep(rec Record) {
+	if len(o.recent) < recentRecords {
+		o.recent = append(o.recent, rec)
+		o.recentNext = len(o.recent) % recentRecords
+		return
+	}
+	o.recent[o.recentNext] = rec
+	o.recentNext = (o.recentNext + 1) % recentRecords
+}
+
+var out = &output{w: os.Stderr, level: LevelInfo, format: FormatText}
+
+// Init configures the level and format of the logs of a binary and
+// redirects the standard library logger to the component. Empty values
+// default to the info level and the text format.
+func Init(component, level, format string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	f, err := ParseFormat(format)
+	if err != nil {
+		return err
+	}
+
+	out.mu.Lock()
+	out.level = lvl
+	out.format = f
+	out.mu.Unlock()
+
+	RedirectStdLog(component)
+	return nil
+}
+
+// SetOutput sets the destination of the logs, os.Stderr by default.
+func SetOutput(w io.Writer) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	out.w = w
+}
+
+// SetLevel sets the minimum level of the records written.
+func SetLevel(level Level) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	out.level = level
+}
+
+// RedirectStdLog writes the records of the standard library logger as
+// info records of the component.
+func RedirectStdLog(component string) {
+	log.SetPrefix("")
+	log.SetFlags(log.Lshortfile)
+	log.SetOutput(&stdWriter{logger: New(component), level: LevelInfo})
+}
+
+// Field is a key value pair attached to a log record.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger writes the records of a component. Loggers are immutable and
+// safe for concurrent use; With returns a derived logger.
+type Logger struct {
+	component string
+	fields    []Field
+}
+
+// New returns the logger of a component such as ows, mas, crawler or
+// worker.
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// With returns a logger adding the key value pairs to every record.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]Field, len(l.fields), len(l.fields)+len(keyvals)/2+1)
+	copy(fields, l.fields)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprintf("%v", keyvals[i])
+		var value interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+	}
+	return &Logger{component: l.component, fields: fields}
+}
+
+// Enabled returns true if records of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	return level >= out.level
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.output(LevelDebug, callerOf(2), fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.output(LevelInfo, callerOf(2), fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.output(LevelWarn, callerOf(2), fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.output(LevelError, callerOf(2), fmt.Sprintf(format, args...))
+}
+
+// Fatalf writes an error record and exits.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.output(LevelError, callerOf(2), fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// StdLogger returns a standard library logger writing records of the
+// level, for the code taking a *log.Logger.
+func (l *Logger) StdLogger(level Level) *log.Logger {
+	return log.New(&stdWriter{logger: l, level: level}, "", log.Lshortfile)
+}
+
+func callerOf(skip int) string {
+	_, file, line, ok := runtime.Caller(skip)
+	if !ok {
+		return ""
+	}
+	return filepath.Base(file) + ":" + strconv.Itoa(line)
+}
+
+func (l *Logger) output(level Level, caller, msg string) {
+	msg = strings.TrimRight(msg, "\n")
+	t := time.Now().UTC()
+
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if level >= LevelWarn {
+		rec := Record{Time: t, Level: level.String(), Component: l.component, Caller: caller, Msg: msg}
+		if len(l.fields) > 0 {
+			rec.Fields = make(map[string]string, len(l.fields))
+			for _, f := range l.fields {
+				rec.Fields[f.Key] = fmt.Sprintf("%v", fieldValue(f.Value))
+			}
+		}
+		out.keep(rec)
+	}
+	if level < out.level {
+		return
+	}
+
+	var buf bytes.Buffer
+	now := t.Format("2006-01-02T15:04:05.000Z07:00")
+	if out.format == FormatJSON {
+		buf.WriteString(`{"time":`)
+		writeJSON(&buf, now)
+		buf.WriteString(`,"level":`)
+		writeJSON(&buf, level.String())
+		if len(l.component) > 0 {
+			buf.WriteString(`,"component":`)
+			writeJSON(&buf, l.component)
+		}
+		if len(caller) > 0 {
+			buf.WriteString(`,"caller":`)
+			writeJSON(&buf, caller)
+		}
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, msg)
+		for _, f := range l.fields {
+			buf.WriteByte(',')
+			writeJSON(&buf, f.Key)
+			buf.WriteByte(':')
+			writeJSON(&buf, fieldValue(f.Value))
+		}
+		buf.WriteString("}\n")
+	} else {
+		buf.WriteString(now)
+		buf.WriteByte(' ')
+		buf.WriteString(strings.ToUpper(level.String()))
+		if len(l.component) > 0 {
+			buf.WriteString(" [" + l.component + "]")
+		}
+		if len(caller) > 0 {
+			buf.WriteString(" " + caller + ":")
+		}
+		buf.WriteString(" " + msg)
+		for _, f := range l.fields {
+			buf.WriteString(" " + f.Key + "=")
+			buf.WriteString(textValue(fieldValue(f.Value)))
+		}
+		buf.WriteByte('\n')
+	}
+	out.w.Write(buf.Bytes())
+}
+
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, value interface{}) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%v", value))
+	}
+	buf.Write(b)
+}
+
+func textValue(value interface{}) string {
+	s := fmt.Sprintf("%v", value)
+	if len(s) == 0 || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var reStdCaller = regexp.MustCompile(`^([\w.\-]+\.go:\d+): `)
+
+// stdWriter turns the lines of a standard library logger created with
+// the Lshortfile flag into log records.
+type stdWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w *stdWriter) Write(p []byte) (int, error) {
+	msg := string(p)
+	var caller string
+	if m := reStdCaller.FindStringSubmatch(msg); m != nil {
+		caller = m[1]
+		msg = msg[len(m[0]):]
+	}
+	w.logger.output(w.level, caller, msg)
+	return len(p), nil
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger of ctx, typically carrying the fields
+// of the request being served, or a logger without component if ctx
+// has none.
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return New("")
+}
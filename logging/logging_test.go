@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	levels := map[string]Level{"": LevelInfo, "DEBUG": LevelDebug, "warning": LevelWarn, " error ": LevelError}
+	for name, expected := range levels {
+		level, err := ParseLevel(name)
+		if err != nil || level != expected {
+			t.Errorf("%q: expected %v, got %v (%v)", name, expected, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("expected error for an invalid level")
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("expected error for an invalid format")
+	}
+}
+
+func TestJSONRecords(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	if err := Init("ows", "warn", "json"); err != nil {
+		t.Fatal(err)
+	}
+	defer Init("", "", "")
+
+	logger := New("ows").With("layer", "chirps", "err", errors.New("no data"))
+	logger.Infof("filtered")
+	logger.Warnf("GetMap failed: %v", "timeout")
+	log.Printf("below the level")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 record, got %d: %q", len(lines), buf.String())
+	}
+	var rec map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"level": "warn", "component": "ows", "msg": "GetMap failed: timeout", "layer": "chirps", "err": "no data"}
+	for k, v := range expected {
+		if rec[k] != v {
+			t.Errorf("%s: expected %q, got %v", k, v, rec[k])
+		}
+	}
+	if caller, _ := rec["caller"].(string); !strings.HasPrefix(caller, "logging_test.go:") {
+		t.Errorf("unexpected caller: %v", rec["caller"])
+	}
+}
+
+func TestStdLogRedirect(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	if err := Init("crawler", "info", "text"); err != nil {
+		t.Fatal(err)
+	}
+
+	log.Printf("crawled %d files", 3)
+	New("crawler").StdLogger(LevelError).Printf("error: %v", "open failed")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], " INFO [crawler] logging_test.go:") || !strings.HasSuffix(lines[0], ": crawled 3 files") {
+		t.Errorf("unexpected record: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], " ERROR [crawler] ") || !strings.HasSuffix(lines[1], "error: open failed") {
+		t.Errorf("unexpected record: %q", lines[1])
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(LevelDebug)
+	defer SetLevel(LevelInfo)
+
+	r := httptest.NewRequest("GET", "/ows/geoglam?service=WMS", nil)
+	r.Header.Set(RequestIDHeader, "req-42")
+	w := httptest.NewRecorder()
+	ctx := NewContext(r.Context(), New("ows").WithRequest(w, r))
+	FromContext(ctx).Debugf("serving %s", "GetMap")
+
+	if w.Header().Get(RequestIDHeader) != "req-42" {
+		t.Errorf("request ID not returned to the client")
+	}
+	if !strings.Contains(buf.String(), `serving GetMap request_id=req-42 method=GET path=/ows/geoglam`) {
+		t.Errorf("unexpected record: %q", buf.String())
+	}
+
+	if id := RequestIDFromContext(WithRequestID(ctx, "req-42")); id != "req-42" {
+		t.Errorf("expected the request ID of the context, got %q", id)
+	}
+
+	r = &http.Request{Header: http.Header{RequestIDHeader: {"bad id\n"}}}
+	if id := RequestID(r); len(id) != 16 {
+		t.Errorf("expected a generated request ID, got %q", id)
+	}
+}
+
+func TestRecent(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(LevelError)
+	defer SetLevel(LevelInfo)
+
+	logger := New("ows")
+	for i := 0; i < recentRecords+5; i++ {
+		logger.Warnf("warning %d", i)
+	}
+	logger.Infof("not kept")
+	logger.With("layer", "chirps").Errorf("GetMap failed")
+
+	recent := Recent()
+	if len(recent) != recentRecords {
+		t.Fatalf("expected %d records, got %d", recentRecords, len(recent))
+	}
+	if recent[0].Msg != "GetMap failed" || recent[0].Level != "error" || recent[0].Fields["layer"] != "chirps" {
+		t.Errorf("unexpected latest record: %+v", recent[0])
+	}
+	if last := recent[len(recent)-1].Msg; last != "warning 6" {
+		t.Errorf("unexpected oldest record: %q", last)
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("records below the level written: %q", buf.String())
+	}
+}
@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// RequestIDHeader is the header carrying the ID of a request between
+// the GSKY services and back to the client.
+const RequestIDHeader = "X-Request-Id"
+
+var reRequestID = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// RequestID returns the ID of the X-Request-Id header of r if it is
+// well formed, or a new random ID otherwise.
+func RequestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); reRequestID.MatchString(id) {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// WithRequest returns a logger adding the request ID, method and path
+// of r to every record. The request ID is returned in the X-Request-Id
+// response header.
+func (l *Logger) WithRequest(w http.ResponseWriter, r *http.Request) *Logger {
+	id := RequestID(r)
+	w.Header().Set(RequestIDHeader, id)
+	return l.With("request_id", id, "method", r.Method, "path", r.URL.Path)
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request
+// being served, sent along the requests to the other GSKY services.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID of ctx, empty if none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
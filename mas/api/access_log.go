@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/tracing"
+)
+
+// accessInfo is the information of a request logged once served, set
+// by the handlers.
+type accessInfo struct {
+	operation  string
+	client     string
+	cache      string
+	errorClass string
+}
+
+type accessInfoKey struct{}
+
+// accessInfoFrom returns the access information of the request of ctx,
+// discarded if access logs are disabled.
+func accessInfoFrom(ctx context.Context) *accessInfo {
+	if info, ok := ctx.Value(accessInfoKey{}).(*accessInfo); ok {
+		return info
+	}
+	return &accessInfo{}
+}
+
+// accessWriter records the status and the bytes of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// accessLog serves h with the logger of each request in its context,
+// carrying the request ID taken from the X-Request-Id header or
+// generated, and writes an access record once the request is served.
+func accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t0 := time.Now()
+		reqLog := logging.New("mas").WithRequest(w, r)
+		if span := tracing.SpanFromContext(r.Context()); span != nil {
+			reqLog = reqLog.With("trace_id", span.TraceIDString())
+		}
+		ctx := logging.NewContext(r.Context(), reqLog)
+		if !*accessLogs {
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		info := &accessInfo{}
+		aw := &accessWriter{ResponseWriter: w}
+		h.ServeHTTP(aw, r.WithContext(context.WithValue(ctx, accessInfoKey{}, info)))
+
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		fields := []interface{}{
+			"status", status,
+			"duration_ms", time.Since(t0).Milliseconds(),
+			"bytes", aw.bytes,
+		}
+		for _, f := range []struct{ key, value string }{
+			{"operation", info.operation},
+			{"client", info.client},
+			{"cache", info.cache},
+			{"error_class", info.errorClass},
+		} {
+			if len(f.value) > 0 {
+				fields = append(fields, f.key, f.value)
+			}
+		}
+		reqLog.With(fields...).Infof("%s %s", r.Method, r.URL.RequestURI())
+	})
+}
+
+// errorClass returns the class of a query error, e.g. data_exception
+// for the Postgres errors.
+func errorClass(err error, timedOut bool) string {
+	if timedOut {
+		return "timeout"
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Class().Name()
+	}
+	return "other"
+}
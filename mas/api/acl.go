@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// accessControl restricts the paths under the prefixes of its rules to
+// their principals, the other paths being open to all the clients.
+type accessControl struct {
+	rules []aclRule // longest prefixes first
+}
+
+type aclRule struct {
+	prefix     string
+	principals map[string]bool
+	networks   []*net.IPNet
+}
+
+// loadACL reads a YAML or JSON file mapping path prefixes to the lists
+// of their principals: key:<name> of an API key, token:<id> of a token,
+// ip:<CIDR> of the clients of a network and ip:unix of those of the unix
+// socket.
+func loadACL(file string) (*accessControl, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes map[string][]string
+	if err := yaml.Unmarshal(b, &prefixes); err != nil {
+		return nil, fmt.Errorf("invalid ACL file %s: %v", file, err)
+	}
+
+	acl := &accessControl{}
+	for prefix, principals := range prefixes {
+		rule := aclRule{prefix: path.Clean("/" + prefix), principals: make(map[string]bool)}
+		for _, principal := range principals {
+			switch {
+			case strings.HasPrefix(principal, "key:"), strings.HasPrefix(principal, "token:"), principal == "ip:unix":
+				rule.principals[principal] = true
+			case strings.HasPrefix(principal, "ip:"):
+				networks, err := parseNetworks(strings.TrimPrefix(principal, "ip:"))
+				if err != nil {
+					return nil, fmt.Errorf("invalid ACL file %s: %s: %v", file, prefix, err)
+				}
+				rule.networks = append(rule.networks, networks...)
+			default:
+				return nil, fmt.Errorf("invalid ACL file %s: %s: unknown principal %q", file, prefix, principal)
+			}
+		}
+		acl.rules = append(acl.rules, rule)
+	}
+	sort.Slice(acl.rules, func(i, j int) bool { return len(acl.rules[i].prefix) > len(acl.rules[j].prefix) })
+	return acl, nil
+}
+
+func (rule *aclRule) matches(gpath string) bool {
+	return rule.prefix == "/" || gpath == rule.prefix || strings.HasPrefix(gpath, rule.prefix+"/")
+}
+
+func (rule *aclRule) allows(r *http.Request) bool {
+	if rule.principals[clientOf(r)] {
+		return true
+	}
+	return !fromSocket(r) && containsAddr(rule.networks, r.RemoteAddr)
+}
+
+// allowed reports whether the client of r may see gpath, checked by the
+// rule of the longest prefix of gpath.
+func (acl *accessControl) allowed(r *http.Request, gpath string) bool {
+	if acl == nil {
+		return true
+	}
+	gpath = path.Clean("/" + gpath)
+	for i := range acl.rules {
+		if acl.rules[i].matches(gpath) {
+			return acl.rules[i].allows(r)
+		}
+	}
+	return true
+}
+
+// cacheVariant returns the prefixes denied to the client of r, which
+// tell apart the cached lists of paths of the clients that see different
+// paths, or an empty string for the other operations.
+func (acl *accessControl) cacheVariant(r *http.Request, operation string) string {
+	if acl == nil || operation != "list_root_gpath" && operation != "list_sub_gpath" {
+		return ""
+	}
+	var denied []string
+	for i := range acl.rules {
+		if !acl.rules[i].allows(r) {
+			denied = append(denied, acl.rules[i].prefix)
+		}
+	}
+	return strings.Join(denied, "\x00")
+}
+
+// filterSubPaths removes from the sub_paths of a list of paths those the
+// client of r may not see, the paths of the list being under base.
+func (acl *accessControl) filterSubPaths(r *http.Request, payload []byte, base string) ([]byte, error) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, err
+	}
+	raw, found := result["sub_paths"]
+	if !found {
+		return payload, nil
+	}
+	var subPaths []string
+	if err := json.Unmarshal(raw, &subPaths); err != nil {
+		return nil, err
+	}
+	visible := []string{}
+	for _, subPath := range subPaths {
+		if acl.allowed(r, base+subPath) {
+			visible = append(visible, subPath)
+		}
+	}
+	b, err := json.Marshal(visible)
+	if err != nil {
+		return nil, err
+	}
+	result["sub_paths"] = b
+	return json.Marshal(result)
+}
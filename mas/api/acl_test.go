@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testACL(t *testing.T, content string) *accessControl {
+	dir, err := ioutil.TempDir("", "mas_acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "acl.yaml")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := loadACL(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acl
+}
+
+func TestACLFilterSubPaths(t *testing.T) {
+	acl := testACL(t, `
+/g/data/restricted: [key:partner, token:3f9c2a1b, ip:127.0.0.0/8]
+/g/data/restricted/public: [ip:0.0.0.0/0, ip:::/0]
+/g/data/private: [ip:unix]
+`)
+
+	remote := httptest.NewRequest(http.MethodGet, "/g/data?list_sub_gpath", nil)
+	remote.RemoteAddr = "196.201.4.7:50000"
+	partner := withClient(remote, "key:partner")
+	local := httptest.NewRequest(http.MethodGet, "/g/data?list_sub_gpath", nil)
+	local.RemoteAddr = "127.0.0.1:50000"
+	socket := remote.WithContext(context.WithValue(remote.Context(), socketConnKey{}, true))
+
+	tests := []struct {
+		name     string
+		r        *http.Request
+		base     string
+		subPaths []string
+		visible  []string
+	}{
+		{"remote sub paths", remote, "/g/data", []string{"/chirps", "/restricted", "/restricted/public", "/restricted_other", "/private"}, []string{"/chirps", "/restricted/public", "/restricted_other"}},
+		{"partner sub paths", partner, "/g/data", []string{"/chirps", "/restricted", "/restricted/public", "/private"}, []string{"/chirps", "/restricted", "/restricted/public"}},
+		{"local sub paths", local, "/g/data", []string{"/restricted", "/private"}, []string{"/restricted"}},
+		{"socket sub paths", socket, "/g/data", []string{"/restricted", "/private"}, []string{"/private"}},
+		{"remote root paths", remote, "", []string{"/g/data/chirps", "/g/data/restricted", "/g/data/restricted/public/chirps"}, []string{"/g/data/chirps", "/g/data/restricted/public/chirps"}},
+		{"remote under restricted", remote, "/g/data/restricted", []string{"/u39", "/public"}, []string{"/public"}},
+	}
+
+	for _, test := range tests {
+		payload, _ := json.Marshal(map[string]interface{}{"error": "", "sub_paths": test.subPaths})
+		filtered, err := acl.filterSubPaths(test.r, payload, test.base)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var result struct {
+			Error    *string  `json:"error"`
+			SubPaths []string `json:"sub_paths"`
+		}
+		if err := json.Unmarshal(filtered, &result); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(result.SubPaths, test.visible) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.visible, result.SubPaths)
+		}
+		if result.Error == nil {
+			t.Errorf("%s: expected the other fields to be kept", test.name)
+		}
+	}
+
+	payload := []byte(`{"sub_paths": ["/restricted"]}`)
+	filtered, err := acl.filterSubPaths(remote, payload, "/g/data")
+	if err != nil || string(filtered) != `{"sub_paths":[]}` {
+		t.Errorf("expected an empty list, got %s: %v", filtered, err)
+	}
+	payload = []byte(`{"error": "no paths"}`)
+	if filtered, err := acl.filterSubPaths(remote, payload, "/g/data"); err != nil || string(filtered) != string(payload) {
+		t.Errorf("expected the payload without sub_paths as is, got %s: %v", filtered, err)
+	}
+
+	if acl.cacheVariant(remote, "list_sub_gpath") == acl.cacheVariant(partner, "list_sub_gpath") {
+		t.Errorf("expected the lists of the clients seeing different paths to be cached apart")
+	}
+	if variant := acl.cacheVariant(remote, "intersects"); len(variant) > 0 {
+		t.Errorf("expected no variant of the other operations, got %q", variant)
+	}
+}
+
+func TestACLAllowed(t *testing.T) {
+	acl := testACL(t, `{"/": ["key:admin"], "/g/data/open": ["ip:0.0.0.0/0"]}`)
+	r := httptest.NewRequest(http.MethodGet, "/g/data?timestamps", nil)
+	r.RemoteAddr = "196.201.4.7:50000"
+
+	for gpath, allowed := range map[string]bool{"/g/data": false, "/g/data/open/chirps": true, "g/data/open/../closed": false} {
+		if acl.allowed(r, gpath) != allowed {
+			t.Errorf("%s: expected allowed %v", gpath, allowed)
+		}
+	}
+	if !acl.allowed(withClient(r, "key:admin"), "/g/data") {
+		t.Errorf("expected the principal of / to be allowed")
+	}
+
+	var none *accessControl
+	if !none.allowed(r, "/g/data") {
+		t.Errorf("expected the paths to be open without ACL")
+	}
+}
+
+func TestLoadACLErrors(t *testing.T) {
+	for _, content := range []string{"/g/data: [user:icpac]", "/g/data: [ip:10.0.0.0/33]", "[not a map"} {
+		dir, err := ioutil.TempDir("", "mas_acl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, "acl.yaml")
+		ioutil.WriteFile(file, []byte(content), 0644)
+		if _, err := loadACL(file); err == nil || !strings.Contains(err.Error(), file) {
+			t.Errorf("%q: expected an error of the file, got %v", content, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -4,32 +4,161 @@
 package main
 
 import (
-	"crypto/md5"
+	"context"
+	"crypto/tls"
 	"database/sql"
-	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
 
 	_ "github.com/lib/pq"
-	"github.com/nci/gomemcache/memcache"
+	"github.com/nci/gsky/cql2"
+	"github.com/nci/gsky/lifecycle"
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/metrics/prom"
+	"github.com/nci/gsky/tokens"
+	"github.com/nci/gsky/tracing"
 )
 
 var (
 	db         *sql.DB
-	mc         *memcache.Client
+	ingestDB   *sql.DB
+	cache      responseCache
+	pathACL    *accessControl
+	hooks      *webhooks
+	configFile = flag.String("config", os.Getenv("GSKY_MAS_CONFIG"), "JSON or YAML file of flag names and values, overriding the command line flags and overridden by their GSKY_MAS_ environment variables.")
 	dbHost     = flag.String("dbhost", "/var/run/postgresql", "dbhost")
 	dbName     = flag.String("database", "mas", "database name")
 	dbUser     = flag.String("user", "api", "database user name")
 	dbPassword = flag.String("password", "", "database user password")
 	dbPool     = flag.Int("pool", 8, "database pool size")
 	dbLimit    = flag.Int("limit", 64, "database concurrent requests")
+	dbStatus   = newDBHealth()
 	httpPort   = flag.Int("port", 8080, "http port")
+	socketPath = flag.String("socket", "", "Unix socket served besides -port, or instead of it if -port is 0. Its clients are trusted as those of -trusted_networks.")
+	socketMode = flag.String("socket_mode", "0660", "Octal permissions of -socket.")
 	mcURI      = flag.String("memcache", "", "memcache uri host:port")
+	mcItemSize = flag.Int("memcache_item_size", 1<<20, "Maximum size in bytes of the memcached items, its -I, the larger responses being cached in chunks. Chunking is disabled if 0.")
+	tlsCert    = flag.String("tlscert", "", "PEM certificate file, with its intermediates, serving HTTPS with -tlskey instead of HTTP.")
+	tlsKey     = flag.String("tlskey", "", "PEM private key file of -tlscert.")
+
+	dbConnectTimeout  = flag.Int("db_connect_timeout", 60, "Maximum seconds MAS retries to connect to the database at startup, with an exponential backoff.")
+	prepareStatements = flag.Bool("prepare_statements", true, "Prepare the queries once per database connection. Disable behind a pooler in transaction mode, e.g. PgBouncer.")
+	dbCheckInterval   = flag.Int("db_check_interval", 5, "Interval in seconds between the checks of the database, the requests being answered with 503 while it is down. Disabled if 0.")
+
+	tlsReloadInterval = flag.Int("tls_reload_interval", 0, "Interval in seconds between the checks of -tlscert and -tlskey for a renewed certificate, loaded without restart. Disabled if 0.")
+
+	cacheBackend  = flag.String("cache_backend", "memcache", "Cache of the responses: memcache, of -memcache, or redis, of -redis.")
+	redisAddrs    = flag.String("redis", "", "Comma separated host:port of the Redis node or of some nodes of the Redis Cluster.")
+	redisPassword = flag.String("redis_password", os.Getenv("GSKY_REDIS_PASSWORD"), "Password of -redis.")
+	redisDB       = flag.Int("redis_db", 0, "Database of the Redis node.")
+	redisCluster  = flag.Bool("redis_cluster", false, "Route the keys to the masters of the Redis Cluster of -redis.")
+
+	memCacheMB  = flag.Int("mem_cache_mb", 0, "Megabytes of responses kept in process, in front of the cache of -cache_backend if any. Disabled if 0.")
+	memCacheTTL = flag.Int("mem_cache_ttl", 60, "Maximum seconds the responses are kept in process.")
+	cacheTTL    = flag.Int("cache_ttl", 0, "Seconds the responses are kept in the cache. Never expiring if 0.")
+	cacheTTLs   = flag.String("cache_ttls", "", "Comma separated operation=seconds overriding -cache_ttl, e.g. timestamps=600,files=600.")
+	adminToken  = flag.String("admin_token", os.Getenv("GSKY_ADMIN_TOKEN"), "Bearer token required by the /admin endpoints. The endpoints are disabled if empty.")
+	gzipMinSize = flag.Int("gzip_min_size", 1024, "Minimum size in bytes of the responses compressed with gzip for the clients accepting it, and in the cache. Compression is disabled if 0.")
+
+	ingestTimeout    = flag.Int("ingest_timeout", 900, "Timeout in seconds of an /ingest request, of the upsert of its records and of the refresh of their shards. Unlimited if 0.")
+	maxIngestRecords = flag.Int("max_ingest_records", 10000, "Maximum number of records of an /ingest request. Unlimited if 0.")
+	ingestUser       = flag.String("ingest_user", "ingest", "Database user name of the /ingest requests, the only one allowed to execute mas_ingest. /ingest is disabled if empty.")
+	ingestPassword   = flag.String("ingest_password", os.Getenv("GSKY_MAS_INGEST_PASSWORD"), "Database user password of -ingest_user.")
+
+	metricsPort = flag.Int("metrics_port", 0, "Port serving Prometheus metrics at /metrics. Disabled if 0.")
+	metrics     *masMetrics
+
+	logLevel         = flag.String("log_level", os.Getenv("GSKY_LOG_LEVEL"), "Minimum level of the logs written: debug, info, warn or error. Defaults to info.")
+	logFormat        = flag.String("log_format", os.Getenv("GSKY_LOG_FORMAT"), "Format of the logs: text or json. Defaults to text.")
+	accessLogs       = flag.Bool("access_log", true, "Write an info record per request served, with its request ID, operation, status, duration and bytes.")
+	otlpEndpoint     = flag.String("otlp_endpoint", "", "OTLP/HTTP endpoint receiving the traces, e.g. http://localhost:4318. Defaults to $OTEL_EXPORTER_OTLP_ENDPOINT. Tracing is disabled if empty.")
+	traceSampleRatio = flag.Float64("trace_sample_ratio", 1.0, "Fraction of the requests traced. Requests carrying a traceparent header follow the sampling of their parent.")
+
+	tokenFile       = flag.String("token_file", os.Getenv("GSKY_TOKEN_FILE"), "JSON file of the scoped API tokens issued by the OWS /admin/tokens endpoint. If set, the clients outside the trusted networks must send a token.")
+	apiKeysFile     = flag.String("api_keys_file", os.Getenv("GSKY_MAS_API_KEYS_FILE"), "File of the static API keys, one name:key per line, added to those of $GSKY_MAS_API_KEYS. If any, the clients outside the trusted networks must send a key or a token.")
+	aclFile         = flag.String("acl_file", os.Getenv("GSKY_MAS_ACL_FILE"), "YAML or JSON file mapping the path prefixes to the API keys, tokens and networks allowed to see them, the other paths being open.")
+	trustedNetworks = flag.String("trusted_networks", "127.0.0.0/8,::1/128", "Comma separated CIDRs of the clients, e.g. the OWS, allowed without token if -token_file or API keys are set.")
+
+	corsOrigins = flag.String("cors_origins", "", "Comma separated origins of the browser clients allowed, * or shell patterns such as https://*.example.org. CORS is disabled if empty.")
+	corsMethods = flag.String("cors_methods", "GET, POST", "Methods allowed to the browser clients of -cors_origins.")
+	corsHeaders = flag.String("cors_headers", "Authorization, Content-Type, X-Api-Key, X-Mas-Query-Timeout, If-None-Match", "Request headers allowed to the browser clients of -cors_origins.")
+	corsMaxAge  = flag.Int("cors_max_age", 600, "Seconds the browsers may cache the answers of the preflight requests.")
+
+	rateLimit       = flag.Float64("rate_limit", 0, "Requests per minute allowed to each client, identified by its API key, its token or its address, answered with 429 beyond. Unlimited if 0.")
+	rateBurst       = flag.Int("rate_burst", 0, "Maximum requests of a client at once within -rate_limit. Defaults to a minute of requests.")
+	rateLimitExempt = flag.String("rate_limit_exempt", "127.0.0.0/8,::1/128", "Comma separated CIDRs of the clients, e.g. the OWS, not rate limited when sending no key or token.")
+
+	drainDelay      = flag.Int("drain_delay", 5, "Seconds between SIGTERM, from which /readyz reports the server unready, and the stop of the server, for the load balancers to stop sending new requests.")
+	shutdownTimeout = flag.Int("shutdown_timeout", 20, "Maximum seconds waited for the requests in flight to finish after the drain delay.")
+	queryGrace      = flag.Int("query_grace", 10, "Maximum seconds waited for the database queries still running after the shutdown timeout, before they are cancelled.")
+
+	maxResponseSize = flag.Int("max_response_size", 0, "Maximum size in bytes of the ?intersects responses, truncated beyond with a next_token resuming the query. Unlimited if 0.")
+	maxBatchSize    = flag.Int("max_batch_size", 512, "Maximum number of queries of a ?batch_intersects request. Unlimited if 0.")
+	queryTimeout    = flag.Int("query_timeout", 60, "Default timeout in seconds of the database query of a request. Unlimited if 0.")
+	maxQueryTimeout = flag.Int("max_query_timeout", 300, "Maximum timeout in seconds requested by the X-Mas-Query-Timeout header. Unlimited if 0.")
+
+	webhookURLs         = flag.String("webhook_urls", "", "Comma separated URLs notified with signed POSTs of the ?put_ows_cache writes and of the new timestamps of -webhook_watch. Disabled if empty.")
+	webhookSecret       = flag.String("webhook_secret", os.Getenv("GSKY_MAS_WEBHOOK_SECRET"), "Secret of the HMAC-SHA256 signatures of the webhook notifications, required by -webhook_urls.")
+	webhookWatch        = flag.String("webhook_watch", "", "Comma separated gpaths whose new timestamps are notified to -webhook_urls.")
+	webhookPollInterval = flag.Int("webhook_poll_interval", 300, "Interval in seconds between the polls of the timestamps of -webhook_watch, also polled once a path is invalidated. Disabled if 0.")
+	webhookTimeout      = flag.Int("webhook_timeout", 10, "Timeout in seconds of each delivery of a webhook notification.")
 )
 
+// queryTimeoutHeader is the header of the requests setting the timeout
+// of their database query in seconds.
+const queryTimeoutHeader = "X-Mas-Query-Timeout"
+
+// queryCtx is the base context of the requests and of their database
+// queries, cancelled once the grace period of the shutdown is over.
+var queryCtx, cancelQueries = context.WithCancel(context.Background())
+
+// queryContext returns the context of the database query of a request,
+// cancelled with the request or after the -query_timeout, or the timeout
+// of its X-Mas-Query-Timeout header capped at -max_query_timeout.
+func queryContext(request *http.Request) (context.Context, context.CancelFunc, error) {
+	timeout := *queryTimeout
+	if v := request.Header.Get(queryTimeoutHeader); len(v) > 0 {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return nil, nil, fmt.Errorf("invalid %s header: %s", queryTimeoutHeader, v)
+		}
+		timeout = seconds
+		if *maxQueryTimeout > 0 && timeout > *maxQueryTimeout {
+			timeout = *maxQueryTimeout
+		}
+	}
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(request.Context())
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(request.Context(), time.Duration(timeout)*time.Second)
+	return ctx, cancel, nil
+}
+
+// drainQueries waits for the database queries in flight to finish and
+// cancels those still running after grace.
+func drainQueries(grace time.Duration) {
+	deadline := time.Now().Add(grace)
+	for db.Stats().InUse > 0 {
+		if time.Now().After(deadline) {
+			log.Printf("cancelling %d database queries still running after %v", db.Stats().InUse, grace)
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cancelQueries()
+}
+
+var masOperations = []string{"intersects", "batch_intersects", "timestamps", "files", "crawled", "quarantined", "duplicates", "summary", "extents", "list_root_gpath", "list_sub_gpath", "generate_layers", "put_ows_cache", "get_ows_cache"}
+
 // Spit out a simple JSON-formatted error message for Content-Type: application/json
 func httpJSONError(response http.ResponseWriter, err error, status int) {
 	http.Error(response, fmt.Sprintf(`{ "error": %q }`, err.Error()), status)
@@ -38,33 +167,120 @@ func httpJSONError(response http.ResponseWriter, err error, status int) {
 func handler(response http.ResponseWriter, request *http.Request) {
 
 	response.Header().Set("Content-Type", "application/json")
+	response.Header().Add("Vary", "Accept-Encoding")
 
-	var hash string
+	span := tracing.SpanFromContext(request.Context())
+	info := accessInfoFrom(request.Context())
+	if err := parseParams(response, request); err != nil {
+		httpJSONError(response, err, 400)
+		return
+	}
+	query := request.Form
+	operation := ""
+	for _, op := range masOperations {
+		if _, ok := query[op]; ok {
+			operation = op
+			span.SetName("mas " + op)
+			break
+		}
+	}
+	info.operation = operation
+	if len(operation) > 0 {
+		if perr := validateParams(operation, query); perr != nil {
+			httpParamError(response, perr)
+			return
+		}
+	}
+	if !pathACL.allowed(request, request.URL.Path) {
+		info.errorClass = "forbidden"
+		logging.FromContext(request.Context()).Warnf("Access to %s denied to %s", request.URL.Path, clientOf(request))
+		httpJSONError(response, errors.New("access denied"), http.StatusForbidden)
+		return
+	}
 
-	if mc != nil {
+	var hash string
+	streaming := operation == "intersects" && wantsStream(request)
+
+	if cache != nil && !streaming {
+
+		hash = cacheKey(request, pathACL.cacheVariant(request, operation))
+
+		if cached, isGzip, ok := cache.Get(hash); ok == nil {
+			payload, compressed := cached, []byte(nil)
+			if isGzip {
+				payload, compressed = nil, cached
+			}
+			if err := writePayload(response, request, payload, compressed); err == nil {
+				span.SetAttribute("mas.cache_hit", true)
+				info.cache = "hit"
+				return
+			}
+		}
+		info.cache = "miss"
+	}
 
-		buff := md5.Sum([]byte(request.URL.RequestURI()))
-		hash = hex.EncodeToString(buff[:])
+	// The cached responses are served while the database is down.
+	if err := dbStatus.unavailable(); err != nil {
+		info.errorClass = "unavailable"
+		metrics.observeQuery(operation, time.Now(), "unavailable", 0)
+		httpDBUnavailable(response, time.Duration(*dbCheckInterval)*time.Second)
+		return
+	}
 
-		if cached, ok := mc.Get(hash); ok == nil {
-			response.Write(cached.Value)
-			return
-		}
+	ctx, cancel, err := queryContext(request)
+	if err != nil {
+		httpJSONError(response, err, 400)
+		return
 	}
+	defer cancel()
 
-	query := request.URL.Query()
 	var payload string
-	var err error
+	var after []string
+	var pageSize int
+	t0 := time.Now()
 
 	if _, ok := query["intersects"]; ok {
 
+		wkt, srs, perr := intersectsGeometry("", request.FormValue("wkt"), request.FormValue("srs"), request.FormValue("geojson"))
+		if perr != nil {
+			httpParamError(response, perr)
+			return
+		}
+		if after, perr = decodeCursor(request.FormValue("next_token"), 2); perr != nil {
+			httpParamError(response, perr)
+			return
+		}
+		if streaming && after != nil {
+			httpParamError(response, &paramError{"next_token", "not supported by the streamed responses"})
+			return
+		}
+		pageSize, _ = strconv.Atoi(request.FormValue("page_size"))
+
+		args := []interface{}{
+			request.URL.Path,
+			srs,
+			wkt,
+			request.FormValue("nseg"),
+			request.FormValue("time"),
+			request.FormValue("until"),
+			request.FormValue("namespace"),
+			request.FormValue("metadata"),
+			request.FormValue("identitytol"),
+			request.FormValue("dptol"),
+			request.FormValue("limit"),
+		}
+		if streaming {
+			streamIntersects(ctx, response, request, t0, args)
+			return
+		}
+
 		// Use Postgres prepared statements and placeholders for input checks.
 		// The nullif() noise is to coerce Go's empty string zero values for
 		// missing parameters into proper null arguments.
 		// The string_to_array() call will return null in the case of a null
 		// argument, rather than array[] or array[null].
 
-		err = db.QueryRow(
+		err = queryRow(ctx,
 			`select mas_intersects(
 				nullif($1,'')::text,
 				nullif($2,'')::text,
@@ -78,37 +294,174 @@ func handler(response http.ResponseWriter, request *http.Request) {
 				nullif($10,'')::float,
 				nullif($11,'')::int
 			) as json`,
+			args...,
+		).Scan(&payload)
+
+	} else if _, ok := query["batch_intersects"]; ok {
+		queries, perr := batchQueries(request)
+		if perr != nil {
+			httpParamError(response, perr)
+			return
+		}
+		err = queryRow(ctx,
+			`select mas_batch_intersects(
+				nullif($1,'')::text,
+				$2::jsonb
+			) as json`,
+			request.URL.Path,
+			string(queries),
+		).Scan(&payload)
+
+	} else if _, ok := query["timestamps"]; ok && len(request.FormValue("group_by")) > 0 {
+		err = queryRow(ctx,
+			`select mas_timestamps_agg(
+				nullif($1,'')::text,
+				nullif($2,'')::timestamptz,
+				nullif($3,'')::timestamptz,
+				string_to_array(nullif($4,''), ','),
+				$5::text
+			) as json`,
 			request.URL.Path,
-			request.FormValue("srs"),
-			request.FormValue("wkt"),
-			request.FormValue("nseg"),
 			request.FormValue("time"),
 			request.FormValue("until"),
 			request.FormValue("namespace"),
-			request.FormValue("metadata"),
-			request.FormValue("identitytol"),
-			request.FormValue("dptol"),
-			request.FormValue("limit"),
+			request.FormValue("group_by"),
 		).Scan(&payload)
 
 	} else if _, ok := query["timestamps"]; ok {
-		err = db.QueryRow(
+		err = queryRow(ctx,
 			`select mas_timestamps(
 				nullif($1,'')::text,
 				nullif($2,'')::timestamptz,
 				nullif($3,'')::timestamptz,
 				string_to_array(nullif($4,''), ','),
-				nullif($5,'')::text
+				nullif($5,'')::text,
+				nullif($6,'')::integer,
+				nullif($7,'')::integer
 			) as json`,
 			request.URL.Path,
 			request.FormValue("time"),
 			request.FormValue("until"),
 			request.FormValue("namespace"),
 			request.FormValue("token"),
+			request.FormValue("offset"),
+			request.FormValue("limit"),
+		).Scan(&payload)
+
+	} else if _, ok := query["files"]; ok {
+		after, perr := decodeCursor(request.FormValue("next_token"), 2)
+		if perr != nil {
+			httpParamError(response, perr)
+			return
+		}
+		if after == nil {
+			after = []string{"", ""}
+		}
+		var filter string
+		if len(request.FormValue("filter")) > 0 {
+			if filter, err = cql2.JSONPath(request.FormValue("filter")); err != nil {
+				httpParamError(response, &paramError{"filter", err.Error()})
+				return
+			}
+		}
+		err = queryRow(ctx,
+			`select mas_files(
+				nullif($1,'')::text,
+				nullif($2,'')::timestamptz,
+				nullif($3,'')::timestamptz,
+				string_to_array(nullif($4,''), ','),
+				nullif($5,'')::integer,
+				nullif($6,'')::integer,
+				nullif($7,'')::text,
+				$8::text,
+				nullif($9,'')::text
+			) as json`,
+			request.URL.Path,
+			request.FormValue("time"),
+			request.FormValue("until"),
+			request.FormValue("namespace"),
+			request.FormValue("offset"),
+			request.FormValue("limit"),
+			after[0],
+			after[1],
+			filter,
+		).Scan(&payload)
+
+	} else if _, ok := query["crawled"]; ok {
+		after, perr := decodeCursor(request.FormValue("next_token"), 1)
+		if perr != nil {
+			httpParamError(response, perr)
+			return
+		}
+		if after == nil {
+			after = []string{""}
+		}
+		_, recursive := query["recursive"]
+		err = queryRow(ctx,
+			`select mas_crawled(
+				nullif($1,'')::text,
+				nullif($2,'')::integer,
+				nullif($3,'')::text,
+				$4::boolean
+			) as json`,
+			request.URL.Path,
+			request.FormValue("limit"),
+			after[0],
+			recursive,
+		).Scan(&payload)
+
+	} else if _, ok := query["quarantined"]; ok {
+		after, perr := decodeCursor(request.FormValue("next_token"), 1)
+		if perr != nil {
+			httpParamError(response, perr)
+			return
+		}
+		if after == nil {
+			after = []string{""}
+		}
+		err = queryRow(ctx,
+			`select mas_quarantined(
+				nullif($1,'')::text,
+				nullif($2,'')::integer,
+				nullif($3,'')::text
+			) as json`,
+			request.URL.Path,
+			request.FormValue("limit"),
+			after[0],
+		).Scan(&payload)
+
+	} else if _, ok := query["duplicates"]; ok {
+		after, perr := decodeCursor(request.FormValue("next_token"), 1)
+		if perr != nil {
+			httpParamError(response, perr)
+			return
+		}
+		if after == nil {
+			after = []string{""}
+		}
+		err = queryRow(ctx,
+			`select mas_duplicates(
+				nullif($1,'')::text,
+				nullif($2,'')::integer,
+				nullif($3,'')::text
+			) as json`,
+			request.URL.Path,
+			request.FormValue("limit"),
+			after[0],
+		).Scan(&payload)
+
+	} else if _, ok := query["summary"]; ok {
+		err = queryRow(ctx,
+			`select mas_summary(
+				nullif($1,'')::text,
+				string_to_array(nullif($2,''), ',')
+			) as json`,
+			request.URL.Path,
+			request.FormValue("namespace"),
 		).Scan(&payload)
 
 	} else if _, ok := query["extents"]; ok {
-		err = db.QueryRow(
+		err = queryRow(ctx,
 			`select mas_spatial_temporal_extents(
 				nullif($1,'')::text,
 				string_to_array(nullif($2,''), ',')
@@ -118,12 +471,12 @@ func handler(response http.ResponseWriter, request *http.Request) {
 		).Scan(&payload)
 
 	} else if _, ok := query["list_root_gpath"]; ok {
-		err = db.QueryRow(
+		err = queryRow(ctx,
 			`select mas_list_root_gpath() as json`,
 		).Scan(&payload)
 
 	} else if _, ok := query["list_sub_gpath"]; ok {
-		err = db.QueryRow(
+		err = queryRow(ctx,
 			`select mas_list_sub_gpath(
 				nullif($1,'')::text
 			) as json`,
@@ -131,7 +484,7 @@ func handler(response http.ResponseWriter, request *http.Request) {
 		).Scan(&payload)
 
 	} else if _, ok := query["generate_layers"]; ok {
-		err = db.QueryRow(
+		err = queryRow(ctx,
 			`select mas_generate_layers(
 				nullif($1,'')::text
 			) as json`,
@@ -139,7 +492,7 @@ func handler(response http.ResponseWriter, request *http.Request) {
 		).Scan(&payload)
 
 	} else if _, ok := query["put_ows_cache"]; ok {
-		err = db.QueryRow(
+		err = queryRow(ctx,
 			`select mas_put_ows_cache(
 				nullif($1,'')::text,
         nullif($2,'')::text,
@@ -151,7 +504,7 @@ func handler(response http.ResponseWriter, request *http.Request) {
 		).Scan(&payload)
 
 	} else if _, ok := query["get_ows_cache"]; ok {
-		err = db.QueryRow(
+		err = queryRow(ctx,
 			`select mas_get_ows_cache(
 				nullif($1,'')::text,
         nullif($2,'')::text
@@ -161,20 +514,74 @@ func handler(response http.ResponseWriter, request *http.Request) {
 		).Scan(&payload)
 
 	} else {
-		httpJSONError(response, errors.New("unknown operation; currently supported: ?intersects, ?timestamps, ?extents"), 400)
+		httpJSONError(response, errors.New("unknown operation; currently supported: ?intersects, ?batch_intersects, ?timestamps, ?files, ?summary, ?extents"), 400)
 		return
 	}
 
 	if err != nil {
-		httpJSONError(response, err, 400)
+		status := 400
+		queryStatus := "error"
+		timedOut := ctx.Err() == context.DeadlineExceeded
+		info.errorClass = errorClass(err, timedOut)
+		if timedOut {
+			err = fmt.Errorf("query timed out after %v", time.Since(t0).Round(time.Second))
+			status = http.StatusGatewayTimeout
+			queryStatus = "timeout"
+		} else if isConnError(err) {
+			dbStatus.failed()
+			status = http.StatusServiceUnavailable
+			queryStatus = "unavailable"
+		}
+		metrics.observeQuery(operation, t0, queryStatus, 0)
+		span.SetError(err)
+		logging.FromContext(request.Context()).Warnf("query failed: %v", err)
+		if status == http.StatusServiceUnavailable {
+			httpDBUnavailable(response, time.Duration(*dbCheckInterval)*time.Second)
+			return
+		}
+		httpJSONError(response, err, status)
 		return
 	}
 
-	response.Write([]byte(payload))
-
-	if mc != nil {
-		// don't care about errors; memcache may not necessarily retain this anyway
-		mc.Set(&memcache.Item{Key: hash, Value: []byte(payload)})
+	if operation == "put_ows_cache" {
+		hooks.publish(request.Context(), webhookEvent{Event: "ows_cache", GPath: request.URL.Path, Query: request.FormValue("query")})
+	}
+	if pathACL != nil && (operation == "list_root_gpath" || operation == "list_sub_gpath") {
+		base := ""
+		if operation == "list_sub_gpath" {
+			base = path.Clean("/" + request.URL.Path)
+		}
+		if filtered, err := pathACL.filterSubPaths(request, []byte(payload), base); err == nil {
+			payload = string(filtered)
+		} else {
+			logging.FromContext(request.Context()).Warnf("paths not filtered: %v", err)
+		}
+	}
+	if operation == "intersects" && (after != nil || pageSize > 0 || *maxResponseSize > 0 && len(payload) > *maxResponseSize) {
+		if truncated, err := truncateIntersects([]byte(payload), after, pageSize, *maxResponseSize); err == nil {
+			payload = string(truncated)
+		} else {
+			logging.FromContext(request.Context()).Warnf("response not truncated: %v", err)
+		}
+	}
+	if apiVersion(request) == apiV2 {
+		if enveloped, err := envelope([]byte(payload)); err == nil {
+			payload = string(enveloped)
+		} else {
+			logging.FromContext(request.Context()).Warnf("response not enveloped: %v", err)
+		}
+	}
+	metrics.observeQuery(operation, t0, "ok", len(payload))
+	compressed := compressPayload([]byte(payload))
+	writePayload(response, request, []byte(payload), compressed)
+
+	if cache != nil {
+		// don't care about errors; the cache may not necessarily retain this anyway
+		if compressed != nil {
+			cache.Set(hash, compressed, true, cacheExpiry[operation])
+		} else {
+			cache.Set(hash, []byte(payload), false, cacheExpiry[operation])
+		}
 	}
 
 }
@@ -182,6 +589,15 @@ func handler(response http.ResponseWriter, request *http.Request) {
 func main() {
 
 	flag.Parse()
+	if err := loadConfig(flag.CommandLine, *configFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := logging.Init("mas", *logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	log.Printf("dbHost %s dbUser %s dbName %s dbPool %d httpPort %d", *dbHost, *dbUser, *dbName, *dbPool, *httpPort)
 
@@ -192,28 +608,163 @@ func main() {
 	}
 
 	var err error
-	db, err = sql.Open("postgres", dbinfo)
+	db, err = connectDB(dbinfo, time.Duration(*dbConnectTimeout)*time.Second)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
 	defer db.Close()
 
-	// sql.Open() does lazy evaluation. Here we do some simple
-	// test to assert if connection is okay.
-	var payload string
-	err = db.QueryRow("select true").Scan(&payload)
+	db.SetMaxIdleConns(*dbPool)
+	db.SetMaxOpenConns(*dbLimit)
+	if *dbCheckInterval > 0 {
+		go dbStatus.watch(queryCtx, db, time.Duration(*dbCheckInterval)*time.Second, *dbPool)
+	}
+
+	if len(*adminToken) > 0 && len(*ingestUser) > 0 {
+		ingestInfo := fmt.Sprintf("user=%s host=%s dbname=%s sslmode=disable", *ingestUser, *dbHost, *dbName)
+		if *ingestPassword != "" {
+			ingestInfo = fmt.Sprintf("%s password=%s", ingestInfo, *ingestPassword)
+		}
+		ingestDB, err = connectDB(ingestInfo, time.Duration(*dbConnectTimeout)*time.Second)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer ingestDB.Close()
+		ingestDB.SetMaxIdleConns(1)
+	}
+
+	shared, err := newResponseCache(*cacheBackend)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	if shared != nil {
+		cache = &observedCache{name: *cacheBackend, responseCache: shared}
+	}
+	if *memCacheMB > 0 {
+		local := &observedCache{name: "memory", responseCache: newLRUCache(int64(*memCacheMB)<<20, time.Duration(*memCacheTTL)*time.Second)}
+		if cache != nil {
+			cache = &tieredCache{local: local, shared: cache}
+		} else {
+			cache = local
+		}
+	}
+	if cache != nil {
+		if cacheExpiry, err = parseCacheTTLs(*cacheTTL, *cacheTTLs); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	db.SetMaxIdleConns(*dbPool)
-	db.SetMaxOpenConns(*dbLimit)
+	if err := tracing.Init("gsky-mas", *otlpEndpoint, *traceSampleRatio); err != nil {
+		log.Fatal(err)
+	}
+	defer tracing.Shutdown()
+
+	var h http.Handler = http.HandlerFunc(handler)
+	if *rateLimit > 0 {
+		if h, err = newRateLimiter(*rateLimit, *rateBurst, *rateLimitExempt, h); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(*aclFile) > 0 {
+		if pathACL, err = loadACL(*aclFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	keys, err := loadAPIKeys(*apiKeysFile, os.Getenv("GSKY_MAS_API_KEYS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(*tokenFile) > 0 || len(keys) > 0 {
+		var store *tokens.Store
+		if len(*tokenFile) > 0 {
+			if store, err = tokens.Open(*tokenFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if h, err = newTokenAuth(store, keys, *trustedNetworks, h); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// The preflight requests carry no credentials.
+	if len(*corsOrigins) > 0 {
+		if h, err = newCORS(*corsOrigins, *corsMethods, *corsHeaders, *corsMaxAge, h); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if hooks, err = newWebhooks(*webhookURLs, *webhookSecret, *webhookWatch, time.Duration(*webhookTimeout)*time.Second); err != nil {
+		log.Fatal(err)
+	}
+	if *metricsPort > 0 {
+		metrics = newMASMetrics(db)
+		h = metrics.http.Instrument("api", h)
+		go prom.ListenAndServe(metrics.registry, "mas", *metricsPort)
+	}
+	if hooks != nil {
+		hooks.start(queryCtx, time.Duration(*webhookPollInterval)*time.Second)
+	}
 
-	if *mcURI != "" {
-		// lazy connection; errors returned in .Get
-		mc = memcache.New(*mcURI)
+	// The probes are served outside of the token authentication so that
+	// the kubelet can reach them.
+	lifecycle.Default.AddCheck("database", db.PingContext)
+	// The queries are served without the cache, uncached.
+	if shared != nil {
+		lifecycle.Default.AddOptionalCheck(*cacheBackend, shared.Ping)
+	}
+	lifecycle.Default.Register(http.DefaultServeMux)
+	http.Handle("/admin/invalidate", tracing.Handler("mas", accessLog(http.HandlerFunc(invalidateHandler))))
+	http.Handle("/ingest", tracing.Handler("mas", accessLog(http.HandlerFunc(ingestHandler))))
+	for _, prefix := range []string{"", "/v1", "/v2"} {
+		http.Handle(prefix+"/openapi.json", tracing.Handler("mas", accessLog(http.HandlerFunc(openAPIHandler))))
 	}
 
-	http.HandleFunc("/", handler)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *httpPort), nil))
+	http.Handle("/", tracing.Handler("mas", accessLog(versionRouter(h))))
+	srv := &http.Server{
+		Addr:        fmt.Sprintf(":%d", *httpPort),
+		BaseContext: func(net.Listener) context.Context { return queryCtx },
+		ConnContext: socketConnContext,
+	}
+	drain, timeout := time.Duration(*drainDelay)*time.Second, time.Duration(*shutdownTimeout)*time.Second
+	var socket net.Listener
+	if len(*socketPath) > 0 {
+		mode, err := strconv.ParseUint(*socketMode, 8, 32)
+		if err != nil {
+			log.Fatalf("invalid -socket_mode %s", *socketMode)
+		}
+		if socket, err = listenSocket(*socketPath, os.FileMode(mode)); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("serving on the unix socket %s", *socketPath)
+	}
+	if socket != nil && *httpPort > 0 {
+		serve := serveListener(srv, socket)
+		go func() {
+			if err := serve(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if socket != nil && *httpPort <= 0 {
+		err = lifecycle.Default.Run(serveListener(srv, socket), srv.Shutdown, drain, timeout)
+	} else if len(*tlsCert) > 0 || len(*tlsKey) > 0 {
+		var certs *certReloader
+		if certs, err = newCertReloader(*tlsCert, *tlsKey); err != nil {
+			log.Fatal(err)
+		}
+		if *tlsReloadInterval > 0 {
+			go certs.watch(time.Duration(*tlsReloadInterval) * time.Second)
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+		log.Printf("serving HTTPS with the certificate of %s", *tlsCert)
+		err = lifecycle.Default.RunHTTPS(srv, "", "", drain, timeout)
+	} else {
+		err = lifecycle.Default.RunHTTP(srv, drain, timeout)
+	}
+	if err != nil && err != context.DeadlineExceeded {
+		log.Fatal(err)
+	}
+	if err == context.DeadlineExceeded {
+		log.Printf("requests still in flight after the shutdown timeout")
+	}
+	drainQueries(time.Duration(*queryGrace) * time.Second)
+	log.Printf("MAS has stopped")
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// intersectsParams are the parameters of an intersects query, those of
+// the queries of a batch defaulting to those of the request.
+var intersectsParams = []string{"srs", "nseg", "time", "until", "namespace", "metadata", "identitytol", "dptol", "limit"}
+
+// batchParams are the parameters of the queries of a batch.
+var batchParams = map[string]bool{"wkt": true, "geojson": true}
+
+func init() {
+	for _, name := range intersectsParams {
+		batchParams[name] = true
+	}
+}
+
+// batchQueries returns the JSON array of the intersects queries of the
+// queries parameter of a batch, the parameters of each query normalised
+// to strings, its geometry to WKT and its missing parameters taken from
+// the request.
+func batchQueries(request *http.Request) ([]byte, *paramError) {
+	var raws []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(request.FormValue("queries")), &raws); err != nil {
+		return nil, &paramError{"queries", "expected a JSON array of queries"}
+	}
+	if len(raws) == 0 {
+		return nil, &paramError{"queries", "no query"}
+	}
+	if *maxBatchSize > 0 && len(raws) > *maxBatchSize {
+		return nil, &paramError{"queries", fmt.Sprintf("%d queries, at most %d allowed", len(raws), *maxBatchSize)}
+	}
+
+	queries := make([]map[string]string, len(raws))
+	for i, raw := range raws {
+		prefix := fmt.Sprintf("queries[%d].", i)
+		q := make(map[string]string, len(raw))
+		for name, value := range raw {
+			if !batchParams[name] {
+				return nil, &paramError{prefix + name, "unknown parameter of an intersects query"}
+			}
+			v, err := jsonParam(value)
+			if err != nil {
+				return nil, &paramError{prefix + name, err.Error()}
+			}
+			if perr := validateParam(name, v); perr != nil {
+				return nil, &paramError{prefix + name, perr.Reason}
+			}
+			q[name] = v
+		}
+		for _, name := range intersectsParams {
+			if _, found := q[name]; !found {
+				q[name] = request.FormValue(name)
+			}
+		}
+		// The geometry of the request applies to the queries without
+		// their own.
+		if _, found := q["wkt"]; !found {
+			if _, found := q["geojson"]; !found {
+				q["wkt"], q["geojson"] = request.FormValue("wkt"), request.FormValue("geojson")
+			}
+		}
+
+		wkt, srs, perr := intersectsGeometry(prefix, q["wkt"], q["srs"], q["geojson"])
+		if perr != nil {
+			return nil, perr
+		}
+		delete(q, "geojson")
+		q["wkt"], q["srs"] = wkt, srs
+		queries[i] = q
+	}
+
+	b, err := json.Marshal(queries)
+	if err != nil {
+		return nil, &paramError{"queries", err.Error()}
+	}
+	return b, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxBodySize is the maximum size in bytes of a POST body, e.g. of the
+// WKT of a large basin.
+const maxBodySize = 16 << 20
+
+// parseParams parses the parameters of a request into request.Form,
+// those of its URL and, for POST, those of its body, either form
+// encoded or a JSON object, the body taking precedence.
+func parseParams(response http.ResponseWriter, request *http.Request) error {
+	if request.Method != http.MethodPost {
+		return request.ParseForm()
+	}
+	request.Body = http.MaxBytesReader(response, request.Body, maxBodySize)
+
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		if err := request.ParseForm(); err != nil {
+			return fmt.Errorf("invalid request body: %v", err)
+		}
+		return nil
+	}
+
+	if err := request.ParseForm(); err != nil {
+		return err
+	}
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		return fmt.Errorf("invalid JSON request body: %v", err)
+	}
+	request.PostForm = make(url.Values)
+	for name, raw := range body {
+		value, err := jsonParam(raw)
+		if err != nil {
+			return fmt.Errorf("invalid JSON request body: %s: %v", name, err)
+		}
+		request.PostForm.Set(name, value)
+		request.Form[name] = []string{value}
+	}
+	return nil
+}
+
+// jsonParam returns the form value of a JSON value: a string as is, a
+// list of strings comma separated, an empty string for null and the
+// others as written, e.g. a GeoJSON geometry.
+func jsonParam(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case bytes.Equal(raw, []byte("null")):
+		return "", nil
+	case len(raw) > 0 && raw[0] == '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case len(raw) > 0 && raw[0] == '[':
+		var list []string
+		if err := json.Unmarshal(raw, &list); err == nil {
+			return strings.Join(list, ","), nil
+		}
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// paramsURI returns the URI identifying the response of a request, its
+// own for GET and its path with its sorted parameters for POST.
+func paramsURI(request *http.Request) string {
+	if request.Method != http.MethodPost {
+		return request.URL.RequestURI()
+	}
+	return request.URL.EscapedPath() + "?" + request.Form.Encode()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCacheTTL is the longest expiry memcached takes in seconds, the
+// larger values being read as unix times.
+const maxCacheTTL = 30 * 24 * 3600
+
+// cacheGenerationPrefix prefixes the cache keys of the generations
+// of the gpaths, changed to invalidate the entries under a gpath.
+const cacheGenerationPrefix = "gsky_mas_gen_"
+
+// cacheExpiry is the cache expiry in seconds of the responses of
+// each operation.
+var cacheExpiry map[string]int32
+
+// parseCacheTTLs returns the cache expiry in seconds of the
+// responses of each operation given by a comma separated list of
+// operation=seconds, the others expiring after ttl.
+func parseCacheTTLs(ttl int, list string) (map[string]int32, error) {
+	if ttl < 0 || ttl > maxCacheTTL {
+		return nil, fmt.Errorf("invalid cache TTL %d: expected 0 to %d seconds", ttl, maxCacheTTL)
+	}
+	ttls := map[string]int32{"": int32(ttl)}
+	for _, op := range masOperations {
+		ttls[op] = int32(ttl)
+	}
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		op := strings.TrimSpace(parts[0])
+		if _, found := ttls[op]; !found || len(op) == 0 {
+			return nil, fmt.Errorf("invalid cache TTL %q: unknown operation", pair)
+		}
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid cache TTL %q: expected operation=seconds", pair)
+		}
+		seconds, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || seconds < 0 || seconds > maxCacheTTL {
+			return nil, fmt.Errorf("invalid cache TTL %q: expected 0 to %d seconds", pair, maxCacheTTL)
+		}
+		ttls[op] = int32(seconds)
+	}
+	return ttls, nil
+}
+
+// generationKey returns the cache key of the generation of a gpath.
+func generationKey(gpath string) string {
+	sum := md5.Sum([]byte(gpath))
+	return cacheGenerationPrefix + hex.EncodeToString(sum[:])
+}
+
+// cacheKey returns the cache key of the response of a request,
+// derived from its parameters and from the generations of its gpath and of
+// the parents of its gpath, so that invalidating any of them misses
+// the entries cached before. A variant tells apart the responses of the
+// same request that differ between clients, and the API version those of
+// the versions.
+func cacheKey(request *http.Request, variant string) string {
+	gpath := path.Clean("/" + request.URL.Path)
+	keys := []string{generationKey("/")}
+	for i := 1; i < len(gpath); i++ {
+		if gpath[i] == '/' {
+			keys = append(keys, generationKey(gpath[:i]))
+		}
+	}
+	if gpath != "/" {
+		keys = append(keys, generationKey(gpath))
+	}
+
+	uri := paramsURI(request)
+	if len(variant) > 0 {
+		uri += "\x00variant=" + variant
+	}
+	if version := apiVersion(request); version != apiV1 {
+		uri += "\x00version=" + strconv.Itoa(version)
+	}
+	generations, err := cache.GetMulti(keys)
+	if err == nil && len(generations) > 0 {
+		var b strings.Builder
+		b.WriteString(uri)
+		for _, key := range keys {
+			if generation, found := generations[key]; found {
+				fmt.Fprintf(&b, "\x00%s=%s", key, generation)
+			}
+		}
+		uri = b.String()
+	}
+	sum := md5.Sum([]byte(uri))
+	return hex.EncodeToString(sum[:])
+}
+
+// adminAuthorized reports whether a request of the admin endpoints
+// carries the bearer token of -admin_token, answering it otherwise.
+func adminAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if len(*adminToken) == 0 {
+		httpJSONError(w, fmt.Errorf("admin endpoints are disabled"), http.StatusNotFound)
+		return false
+	}
+	var token string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if len(token) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gsky mas admin"`)
+		httpJSONError(w, fmt.Errorf("unauthorised"), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// invalidateHandler invalidates the cached responses of a gpath and of
+// the gpaths under it, e.g. POST /admin/invalidate?prefix=/g/data/u39
+func invalidateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpJSONError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if cache == nil {
+		httpJSONError(w, fmt.Errorf("cache is disabled"), http.StatusNotFound)
+		return
+	}
+	prefix := r.FormValue("prefix")
+	if len(prefix) == 0 {
+		httpJSONError(w, fmt.Errorf("prefix required, e.g. ?prefix=/g/data/u39"), http.StatusBadRequest)
+		return
+	}
+	prefix = path.Clean("/" + prefix)
+
+	generation := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := cache.Set(generationKey(prefix), []byte(generation), false, 0); err != nil {
+		httpJSONError(w, fmt.Errorf("failed to invalidate %s: %v", prefix, err), http.StatusBadGateway)
+		return
+	}
+	accessInfoFrom(r.Context()).operation = "invalidate"
+	// A crawl has likely added timestamps.
+	hooks.checkTimestamps()
+	json.NewEncoder(w).Encode(map[string]string{"prefix": prefix, "generation": generation})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nci/gomemcache/memcache"
+	"github.com/nci/gsky/redis"
+)
+
+// errCacheMiss is returned by the caches for the keys not found.
+var errCacheMiss = errors.New("cache miss")
+
+// responseCache keeps the responses shared by the MAS processes.
+type responseCache interface {
+	// Get returns the value of a key and whether it is compressed
+	// with gzip, errCacheMiss if not found.
+	Get(key string) ([]byte, bool, error)
+	// GetMulti returns the values of the keys found by key.
+	GetMulti(keys []string) (map[string][]byte, error)
+	// Set keeps a value for ttl seconds, until evicted if 0.
+	Set(key string, value []byte, compressed bool, ttl int32) error
+	// Ping checks that the cache answers.
+	Ping(ctx context.Context) error
+}
+
+// newResponseCache returns the cache of backend, nil if the backend is
+// not configured.
+func newResponseCache(backend string) (responseCache, error) {
+	switch backend {
+	case "memcache":
+		if len(*mcURI) == 0 {
+			return nil, nil
+		}
+		// lazy connection; errors returned in .Get
+		c := &memcacheCache{client: memcache.New(*mcURI)}
+		if *mcItemSize > memcacheItemOverhead {
+			c.chunkSize = *mcItemSize - memcacheItemOverhead
+		}
+		return c, nil
+	case "redis":
+		if len(*redisAddrs) == 0 {
+			return nil, nil
+		}
+		client, err := redis.New(redis.Options{
+			Addrs:    strings.Split(*redisAddrs, ","),
+			Password: *redisPassword,
+			DB:       *redisDB,
+			Cluster:  *redisCluster,
+			PoolSize: *dbLimit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &redisCache{client}, nil
+	}
+	return nil, fmt.Errorf("unknown cache backend %q: expected memcache or redis", backend)
+}
+
+const (
+	// cacheFlagChunked flags the manifests of the values stored in
+	// chunks, of more bytes than a memcached item.
+	cacheFlagChunked = 2
+	// memcacheItemOverhead is the room left in a memcached item for its
+	// key and header.
+	memcacheItemOverhead = 512
+	// maxCacheChunks bounds the chunks of a value, the larger values not
+	// being cached.
+	maxCacheChunks = 64
+)
+
+// memcacheCache splits the values of more than chunkSize bytes, if not
+// 0, into chunks of their own keys, named by the manifest kept at the
+// key of the value. The chunks of each Set having new keys, a Get never
+// mixes the chunks of two values.
+type memcacheCache struct {
+	client    memcacheClient
+	chunkSize int
+}
+
+// memcacheClient is the part of the memcache.Client used by the cache.
+type memcacheClient interface {
+	Get(key string) (*memcache.Item, error)
+	GetMulti(keys []string) (map[string]*memcache.Item, error)
+	Set(item *memcache.Item) error
+}
+
+func (c *memcacheCache) Get(key string) ([]byte, bool, error) {
+	item, err := c.client.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return nil, false, errCacheMiss
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if item.Flags&cacheFlagChunked != 0 {
+		value, err := c.getChunks(key, item.Value)
+		if err != nil {
+			return nil, false, err
+		}
+		return value, item.Flags&cacheFlagGzip != 0, nil
+	}
+	return item.Value, item.Flags&cacheFlagGzip != 0, nil
+}
+
+// getChunks reassembles the value of a manifest of its chunks, a miss
+// if any chunk was evicted.
+func (c *memcacheCache) getChunks(key string, manifest []byte) ([]byte, error) {
+	fields := strings.Fields(string(manifest))
+	if len(fields) != 3 {
+		return nil, errCacheMiss
+	}
+	n, err := strconv.Atoi(fields[1])
+	if err != nil || n <= 0 || n > maxCacheChunks {
+		return nil, errCacheMiss
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, errCacheMiss
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = chunkKey(key, fields[0], i)
+	}
+	items, err := c.client.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, 0, size)
+	for _, k := range keys {
+		item, found := items[k]
+		if !found {
+			return nil, errCacheMiss
+		}
+		value = append(value, item.Value...)
+	}
+	if len(value) != size {
+		return nil, errCacheMiss
+	}
+	return value, nil
+}
+
+// chunkKey returns the key of the chunk i of the value of key stored
+// with the id of its manifest.
+func chunkKey(key, id string, i int) string {
+	return fmt.Sprintf("%s_%s_%d", key, id, i)
+}
+
+func (c *memcacheCache) GetMulti(keys []string) (map[string][]byte, error) {
+	items, err := c.client.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(items))
+	for key, item := range items {
+		values[key] = item.Value
+	}
+	return values, nil
+}
+
+func (c *memcacheCache) Set(key string, value []byte, compressed bool, ttl int32) error {
+	item := &memcache.Item{Key: key, Value: value, Expiration: ttl}
+	if compressed {
+		item.Flags = cacheFlagGzip
+	}
+	if c.chunkSize > 0 && len(value) > c.chunkSize {
+		return c.setChunks(item)
+	}
+	return c.client.Set(item)
+}
+
+// setChunks stores the value of an item in chunks, then their manifest
+// at the key of the item, the chunks expiring with it.
+func (c *memcacheCache) setChunks(item *memcache.Item) error {
+	n := (len(item.Value) + c.chunkSize - 1) / c.chunkSize
+	if n > maxCacheChunks {
+		return fmt.Errorf("value of %d bytes too large for %d chunks of %d bytes", len(item.Value), maxCacheChunks, c.chunkSize)
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(b)
+	for i := 0; i < n; i++ {
+		end := (i + 1) * c.chunkSize
+		if end > len(item.Value) {
+			end = len(item.Value)
+		}
+		chunk := &memcache.Item{Key: chunkKey(item.Key, id, i), Value: item.Value[i*c.chunkSize : end], Expiration: item.Expiration}
+		if err := c.client.Set(chunk); err != nil {
+			return err
+		}
+	}
+	manifest := &memcache.Item{
+		Key:        item.Key,
+		Value:      []byte(fmt.Sprintf("%s %d %d", id, n, len(item.Value))),
+		Flags:      item.Flags | cacheFlagChunked,
+		Expiration: item.Expiration,
+	}
+	return c.client.Set(manifest)
+}
+
+func (c *memcacheCache) Ping(ctx context.Context) error {
+	if _, err := c.client.Get("gsky_mas_readyz"); err != nil && err != memcache.ErrCacheMiss {
+		return err
+	}
+	return nil
+}
+
+// redisCache keeps the values prefixed with their cacheFlagGzip flags,
+// Redis having no flags.
+type redisCache struct {
+	client *redis.Client
+}
+
+func (c *redisCache) Get(key string) ([]byte, bool, error) {
+	value, err := c.client.Get(context.Background(), key)
+	if err == redis.ErrNil || (err == nil && len(value) == 0) {
+		return nil, false, errCacheMiss
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value[1:], value[0]&cacheFlagGzip != 0, nil
+}
+
+func (c *redisCache) GetMulti(keys []string) (map[string][]byte, error) {
+	values, err := c.client.MGet(context.Background(), keys...)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		if len(value) == 0 {
+			delete(values, key)
+			continue
+		}
+		values[key] = value[1:]
+	}
+	return values, nil
+}
+
+func (c *redisCache) Set(key string, value []byte, compressed bool, ttl int32) error {
+	var flags byte
+	if compressed {
+		flags = cacheFlagGzip
+	}
+	return c.client.Set(context.Background(), key, append([]byte{flags}, value...), time.Duration(ttl)*time.Second)
+}
+
+func (c *redisCache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx)
+}
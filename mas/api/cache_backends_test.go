@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nci/gomemcache/memcache"
+)
+
+// fakeMemcache keeps the items in a map as memcached would.
+type fakeMemcache struct {
+	items map[string]*memcache.Item
+}
+
+func newFakeMemcache() *fakeMemcache {
+	return &fakeMemcache{items: make(map[string]*memcache.Item)}
+}
+
+func (m *fakeMemcache) Get(key string) (*memcache.Item, error) {
+	item, found := m.items[key]
+	if !found {
+		return nil, memcache.ErrCacheMiss
+	}
+	return item, nil
+}
+
+func (m *fakeMemcache) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	items := make(map[string]*memcache.Item)
+	for _, key := range keys {
+		if item, found := m.items[key]; found {
+			items[key] = item
+		}
+	}
+	return items, nil
+}
+
+func (m *fakeMemcache) Set(item *memcache.Item) error {
+	stored := *item
+	stored.Value = append([]byte{}, item.Value...)
+	m.items[item.Key] = &stored
+	return nil
+}
+
+func TestMemcacheChunks(t *testing.T) {
+	client := newFakeMemcache()
+	c := &memcacheCache{client: client, chunkSize: 10}
+
+	if err := c.Set("small", []byte("0123456789"), false, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.items) != 1 || client.items["small"].Flags&cacheFlagChunked != 0 {
+		t.Errorf("expected a value of chunkSize bytes to be stored as is, got %d items", len(client.items))
+	}
+
+	value := []byte(strings.Repeat("gsky mas ", 5))
+	if err := c.Set("large", value, true, 60); err != nil {
+		t.Fatal(err)
+	}
+	manifest := client.items["large"]
+	if manifest.Flags&cacheFlagChunked == 0 || manifest.Flags&cacheFlagGzip == 0 {
+		t.Errorf("expected the manifest to be flagged chunked and gzip, got flags %d", manifest.Flags)
+	}
+	fields := strings.Fields(string(manifest.Value))
+	if len(fields) != 3 || fields[1] != "5" || fields[2] != "45" {
+		t.Fatalf("expected a manifest of 5 chunks of 45 bytes, got %q", manifest.Value)
+	}
+	for i := 0; i < 5; i++ {
+		chunk, found := client.items[chunkKey("large", fields[0], i)]
+		if !found {
+			t.Fatalf("chunk %d not found", i)
+		}
+		if chunk.Expiration != 60 {
+			t.Errorf("expected chunk %d to expire with the value, got %d", i, chunk.Expiration)
+		}
+	}
+
+	got, compressed, err := c.Get("large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) || !compressed {
+		t.Errorf("expected the value reassembled compressed, got %q compressed %v", got, compressed)
+	}
+
+	// the chunks of a new value have new keys
+	updated := []byte(strings.Repeat("updated ", 4))
+	if err := c.Set("large", updated, false, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err = c.Get("large"); err != nil || !bytes.Equal(got, updated) {
+		t.Errorf("expected the updated value, got %q: %v", got, err)
+	}
+
+	fields = strings.Fields(string(client.items["large"].Value))
+	delete(client.items, chunkKey("large", fields[0], 1))
+	if _, _, err := c.Get("large"); err != errCacheMiss {
+		t.Errorf("expected a miss with a chunk evicted, got %v", err)
+	}
+
+	if err := c.Set("huge", make([]byte, 10*maxCacheChunks+1), false, 0); err == nil {
+		t.Errorf("expected an error for a value of more than %d chunks", maxCacheChunks)
+	}
+	if _, found := client.items["huge"]; found {
+		t.Errorf("expected the value of too many chunks not to be stored")
+	}
+}
+
+func TestMemcacheChunksDisabled(t *testing.T) {
+	client := newFakeMemcache()
+	c := &memcacheCache{client: client}
+	value := make([]byte, 1<<10)
+	if err := c.Set("key", value, false, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.items) != 1 {
+		t.Errorf("expected the value stored at its key only, got %d items", len(client.items))
+	}
+	if got, _, err := c.Get("key"); err != nil || len(got) != len(value) {
+		t.Errorf("expected the value of %d bytes, got %d: %v", len(value), len(got), err)
+	}
+	if _, _, err := c.Get("missing"); err != errCacheMiss {
+		t.Errorf("expected a miss, got %v", err)
+	}
+}
+
+func TestMemcacheBadManifest(t *testing.T) {
+	client := newFakeMemcache()
+	c := &memcacheCache{client: client, chunkSize: 10}
+	for _, manifest := range []string{"", "id 2", "id two 20", "id 0 0", "id 1000 10", "id 2 size"} {
+		client.Set(&memcache.Item{Key: "key", Value: []byte(manifest), Flags: cacheFlagChunked})
+		if _, _, err := c.Get("key"); err != errCacheMiss {
+			t.Errorf("%q: expected a miss, got %v", manifest, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseCacheTTLs(t *testing.T) {
+	tests := []struct {
+		ttl   int
+		list  string
+		want  map[string]int32
+		isErr bool
+	}{
+		{ttl: 60, list: "", want: map[string]int32{"": 60, "intersects": 60, "timestamps": 60}},
+		{ttl: 0, list: "timestamps=30, extents = 3600", want: map[string]int32{"": 0, "intersects": 0, "timestamps": 30, "extents": 3600}},
+		{ttl: 60, list: "timestamps=30,", want: map[string]int32{"timestamps": 30, "files": 60}},
+		{ttl: -1, list: "", isErr: true},
+		{ttl: maxCacheTTL + 1, list: "", isErr: true},
+		{ttl: 60, list: "unknown=30", isErr: true},
+		{ttl: 60, list: "=30", isErr: true},
+		{ttl: 60, list: "timestamps", isErr: true},
+		{ttl: 60, list: "timestamps=soon", isErr: true},
+		{ttl: 60, list: "timestamps=-1", isErr: true},
+	}
+
+	for _, test := range tests {
+		ttls, err := parseCacheTTLs(test.ttl, test.list)
+		if test.isErr {
+			if err == nil {
+				t.Errorf("%d %q: expected an error, got %v", test.ttl, test.list, ttls)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d %q: %v", test.ttl, test.list, err)
+			continue
+		}
+		for op, want := range test.want {
+			if ttls[op] != want {
+				t.Errorf("%d %q: expected %d seconds for %q, got %d", test.ttl, test.list, want, op, ttls[op])
+			}
+		}
+	}
+}
+
+func TestCacheKeyGenerations(t *testing.T) {
+	savedCache, savedToken := cache, *adminToken
+	defer func() { cache, *adminToken = savedCache, savedToken }()
+	cache = newLRUCache(1<<20, time.Minute)
+	*adminToken = "secret"
+
+	key := func(target string) string {
+		return cacheKey(httptest.NewRequest(http.MethodGet, target, nil), "")
+	}
+	invalidate := func(prefix string) {
+		r := httptest.NewRequest(http.MethodPost, "/admin/invalidate?prefix="+prefix, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		invalidateHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("invalidate %s: status %d: %s", prefix, w.Code, w.Body.String())
+		}
+	}
+
+	const target = "/g/data/u39/chirps?intersects&srs=EPSG:4326"
+	first := key(target)
+	if key(target) != first {
+		t.Fatalf("expected the same key for the same request")
+	}
+	if key(target+"&n=1") == first {
+		t.Errorf("expected another key for other parameters")
+	}
+	if cacheKey(httptest.NewRequest(http.MethodGet, target, nil), "token") == first {
+		t.Errorf("expected another key for another variant")
+	}
+
+	invalidate("/g/data/other")
+	if key(target) != first {
+		t.Errorf("expected the key to be kept after invalidating another gpath")
+	}
+
+	invalidate("/g/data/u39/chirps")
+	second := key(target)
+	if second == first {
+		t.Errorf("expected a new key after invalidating the gpath")
+	}
+
+	invalidate("/g/data")
+	if key(target) == second {
+		t.Errorf("expected a new key after invalidating a parent of the gpath")
+	}
+}
+
+func TestInvalidateHandlerUnauthorised(t *testing.T) {
+	savedCache, savedToken := cache, *adminToken
+	defer func() { cache, *adminToken = savedCache, savedToken }()
+	cache = newLRUCache(1<<20, time.Minute)
+	*adminToken = "secret"
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/invalidate?prefix=/g/data", nil)
+	r.Header.Set("Authorization", "Bearer guess")
+	w := httptest.NewRecorder()
+	invalidateHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if generations, _ := cache.GetMulti([]string{generationKey("/g/data")}); len(generations) != 0 {
+		t.Errorf("expected no generation to be set, got %v", generations)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cacheFlagGzip flags the cached values compressed with gzip.
+const cacheFlagGzip = 1
+
+// acceptsGzip reports whether the client of a request accepts gzip
+// encoded responses.
+func acceptsGzip(request *http.Request) bool {
+	for _, v := range request.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			parts := strings.Split(coding, ";")
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			accepted := true
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+					accepted = err == nil && q > 0
+				}
+			}
+			if accepted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compressPayload returns a payload compressed with gzip if it is at
+// least -gzip_min_size bytes long, nil otherwise.
+func compressPayload(payload []byte) []byte {
+	if *gzipMinSize <= 0 || len(payload) < *gzipMinSize {
+		return nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil
+	}
+	if err := zw.Close(); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// payloadETag returns the weak ETag of a payload, hashing the
+// compressed payload if any as kept in the cache.
+func payloadETag(payload, compressed []byte) string {
+	if compressed != nil {
+		payload = compressed
+	}
+	sum := md5.Sum(payload)
+	return `W/"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// matchesETag reports whether the If-None-Match header of a request
+// matches an ETag, using the weak comparison.
+func matchesETag(request *http.Request, etag string) bool {
+	for _, v := range request.Header.Values("If-None-Match") {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writePayload writes a payload, compressed with gzip if not nil, with
+// its ETag, or only the 304 status if the client has it already. The
+// compressed payload is sent to the clients accepting it and the plain
+// payload, decompressed if nil, to the others.
+func writePayload(response http.ResponseWriter, request *http.Request, payload, compressed []byte) error {
+	etag := payloadETag(payload, compressed)
+	response.Header().Set("ETag", etag)
+	if matchesETag(request, etag) {
+		response.Header().Del("Content-Type")
+		response.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	if compressed != nil && acceptsGzip(request) {
+		response.Header().Set("Content-Encoding", "gzip")
+		_, err := response.Write(compressed)
+		return err
+	}
+	if payload == nil {
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return err
+		}
+		if payload, err = ioutil.ReadAll(zr); err != nil {
+			return err
+		}
+	}
+	_, err := response.Write(payload)
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// envPrefix prefixes the names of the environment variables of the
+// flags, e.g. GSKY_MAS_PORT of -port. Kubernetes sets MAS_PORT to the
+// address of a service named mas.
+const envPrefix = "GSKY_MAS_"
+
+// envNames are the environment variables of the database flags, whose
+// names alone are ambiguous.
+var envNames = map[string]string{
+	"dbhost":   "GSKY_MAS_DB_HOST",
+	"database": "GSKY_MAS_DB_NAME",
+	"user":     "GSKY_MAS_DB_USER",
+	"password": "GSKY_MAS_DB_PASSWORD",
+	"pool":     "GSKY_MAS_DB_POOL",
+	"limit":    "GSKY_MAS_DB_LIMIT",
+}
+
+// envName returns the environment variable of a flag.
+func envName(name string) string {
+	if env, found := envNames[name]; found {
+		return env
+	}
+	return envPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// loadConfig sets the flags of fs from a JSON or YAML config file of
+// flag names and values, if file is not empty, and then from their
+// environment variables, so that the file overrides the command line
+// and the environment overrides both, e.g. the database password kept
+// out of the process listings.
+func loadConfig(fs *flag.FlagSet, file string) error {
+	if len(file) > 0 {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		// YAML being a superset of JSON, both are read as YAML.
+		var values map[string]interface{}
+		if err := yaml.Unmarshal(b, &values); err != nil {
+			return fmt.Errorf("invalid config file %s: %v", file, err)
+		}
+		for name, value := range values {
+			if fs.Lookup(name) == nil || name == "config" {
+				return fmt.Errorf("invalid config file %s: unknown setting %q", file, name)
+			}
+			if err := fs.Set(name, configValue(value)); err != nil {
+				return fmt.Errorf("invalid config file %s: %s: %v", file, name, err)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := envName(f.Name)
+		value, found := os.LookupEnv(env)
+		if !found || f.Name == "config" || err != nil {
+			return
+		}
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("invalid %s: %v", env, e)
+		}
+	})
+	return err
+}
+
+// configValue returns the flag value of a config file value, the lists
+// being comma separated.
+func configValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = fmt.Sprint(v)
+		}
+		return strings.Join(values, ",")
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
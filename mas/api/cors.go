@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// corsExposedHeaders are the response headers readable by the browser
+// clients.
+const corsExposedHeaders = "ETag, Retry-After, X-Mas-Api-Version, X-Request-Id"
+
+// cors allows the browser clients of the allowed origins, e.g. the
+// catalogue UIs, to call MAS, answering their preflight requests before
+// the authentication.
+type cors struct {
+	origins []string
+	methods string
+	headers string
+	maxAge  int
+	next    http.Handler
+}
+
+// newCORS returns the CORS handling of a comma separated list of
+// origins, * or shell patterns such as https://*.example.org, of the
+// methods and the request headers allowed.
+func newCORS(origins, methods, headers string, maxAge int, next http.Handler) (*cors, error) {
+	c := &cors{methods: methods, headers: headers, maxAge: maxAge, next: next}
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		if len(origin) == 0 {
+			continue
+		}
+		if _, err := path.Match(origin, ""); err != nil {
+			return nil, err
+		}
+		c.origins = append(c.origins, origin)
+	}
+	return c, nil
+}
+
+func (c *cors) allowed(origin string) bool {
+	for _, pattern := range c.origins {
+		if pattern == "*" {
+			return true
+		}
+		if matched, _ := path.Match(pattern, origin); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+	if len(origin) == 0 || !c.allowed(origin) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", c.methods)
+		w.Header().Set("Access-Control-Allow-Headers", c.headers)
+		if c.maxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.maxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+	c.next.ServeHTTP(w, r)
+}
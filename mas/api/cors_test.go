@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	var served int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.WriteHeader(http.StatusOK)
+	})
+	c, err := newCORS("https://catalogue.icpac.net, https://*.example.org", "GET, POST", "Authorization", 600, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method        string
+		origin        string
+		requestMethod string
+		status        int
+		allowOrigin   string
+		served        bool
+	}{
+		{method: http.MethodOptions, origin: "https://catalogue.icpac.net", requestMethod: "POST", status: http.StatusNoContent, allowOrigin: "https://catalogue.icpac.net"},
+		{method: http.MethodOptions, origin: "https://maps.example.org", requestMethod: "GET", status: http.StatusNoContent, allowOrigin: "https://maps.example.org"},
+		{method: http.MethodOptions, origin: "https://evil.org", requestMethod: "GET", status: http.StatusOK, served: true},
+		{method: http.MethodOptions, origin: "https://catalogue.icpac.net", status: http.StatusOK, allowOrigin: "https://catalogue.icpac.net", served: true},
+		{method: http.MethodGet, origin: "https://catalogue.icpac.net", status: http.StatusOK, allowOrigin: "https://catalogue.icpac.net", served: true},
+		{method: http.MethodGet, origin: "https://a.b.example.org.evil.org", status: http.StatusOK, served: true},
+		{method: http.MethodGet, status: http.StatusOK, served: true},
+	}
+
+	for _, test := range tests {
+		served = 0
+		r := httptest.NewRequest(test.method, "/g/data?timestamps", nil)
+		if len(test.origin) > 0 {
+			r.Header.Set("Origin", test.origin)
+		}
+		if len(test.requestMethod) > 0 {
+			r.Header.Set("Access-Control-Request-Method", test.requestMethod)
+		}
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+
+		name := test.method + " " + test.origin
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", name, test.status, w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+			t.Errorf("%s: expected Access-Control-Allow-Origin %q, got %q", name, test.allowOrigin, got)
+		}
+		if (served > 0) != test.served {
+			t.Errorf("%s: expected served %v, got %v", name, test.served, served > 0)
+		}
+		if w.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: expected to vary by Origin, got %q", name, w.Header()["Vary"])
+		}
+
+		preflight := test.status == http.StatusNoContent
+		if preflight {
+			if w.Header().Get("Access-Control-Allow-Methods") != "GET, POST" || w.Header().Get("Access-Control-Allow-Headers") != "Authorization" {
+				t.Errorf("%s: expected the allowed methods and headers, got %v", name, w.Header())
+			}
+			if w.Header().Get("Access-Control-Max-Age") != "600" {
+				t.Errorf("%s: expected Access-Control-Max-Age 600, got %q", name, w.Header().Get("Access-Control-Max-Age"))
+			}
+		}
+		exposed := len(test.allowOrigin) > 0 && !preflight
+		if got := w.Header().Get("Access-Control-Expose-Headers"); (got == corsExposedHeaders) != exposed {
+			t.Errorf("%s: expected exposed headers %v, got %q", name, exposed, got)
+		}
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	next := http.NotFoundHandler()
+	c, err := newCORS("*", "GET", "", 0, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.allowed("http://localhost:8080") {
+		t.Errorf("expected * to allow any origin")
+	}
+
+	c, err = newCORS(" , ", "GET", "", 0, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.origins) != 0 || c.allowed("http://localhost:8080") {
+		t.Errorf("expected no origin allowed, got %v", c.origins)
+	}
+
+	if _, err := newCORS("https://[icpac", "GET", "", 0, next); err == nil {
+		t.Errorf("expected an error for a malformed pattern")
+	}
+}
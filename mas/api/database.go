@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// dbPingTimeout bounds each check of the database.
+	dbPingTimeout = 5 * time.Second
+	// dbRetryMaxDelay caps the delay between the connection attempts at
+	// startup.
+	dbRetryMaxDelay = 30 * time.Second
+)
+
+// errDBUnavailable is the error of the requests refused while the
+// database is down.
+var errDBUnavailable = errors.New("database unavailable")
+
+// connectDB opens the database of dbinfo, retrying with an exponential
+// backoff until it answers or timeout is over, so that MAS may start
+// before Postgres.
+func connectDB(dbinfo string, timeout time.Duration) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dbinfo)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			db.Close()
+			return nil, fmt.Errorf("%v after %d attempts: %v", errDBUnavailable, attempt, err)
+		}
+		log.Printf("database unavailable, retrying in %v: %v", delay, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > dbRetryMaxDelay {
+			delay = dbRetryMaxDelay
+		}
+	}
+}
+
+// dbHealth is the availability of the database as of its last check.
+type dbHealth struct {
+	mu      sync.RWMutex
+	err     error
+	recheck chan struct{}
+}
+
+func newDBHealth() *dbHealth {
+	return &dbHealth{recheck: make(chan struct{}, 1)}
+}
+
+// unavailable returns the error of the last check of the database, nil
+// if it answered.
+func (h *dbHealth) unavailable() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.err
+}
+
+// failed requests a check of the database before the next interval,
+// after a query lost its connection.
+func (h *dbHealth) failed() {
+	select {
+	case h.recheck <- struct{}{}:
+	default:
+	}
+}
+
+// watch checks the database every interval until ctx is done. While the
+// database is down, its idle connections are closed for the queries to
+// open new ones once it is back rather than use the dead sockets.
+func (h *dbHealth) watch(ctx context.Context, db *sql.DB, interval time.Duration, maxIdle int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.recheck:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+		err := db.PingContext(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		h.mu.Lock()
+		wasDown := h.err != nil
+		h.err = err
+		h.mu.Unlock()
+		switch {
+		case err != nil && !wasDown:
+			log.Printf("database unavailable: %v", err)
+			db.SetMaxIdleConns(0)
+			db.SetMaxIdleConns(maxIdle)
+		case err == nil && wasDown:
+			log.Printf("database available again")
+		}
+	}
+}
+
+// isConnError reports whether a query failed for the loss of its
+// connection rather than for the query itself.
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Connection exceptions, and the shutdown of the server.
+		switch pqErr.Code.Name() {
+		case "admin_shutdown", "crash_shutdown", "cannot_connect_now":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// httpDBUnavailable answers a request with 503 while the database is
+// down, the clients retrying after the next check.
+func httpDBUnavailable(response http.ResponseWriter, retryAfter time.Duration) {
+	if seconds := int(retryAfter.Seconds()); seconds > 0 {
+		response.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	httpJSONError(response, errDBUnavailable, http.StatusServiceUnavailable)
+}
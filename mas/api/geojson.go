@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// paramError is an invalid parameter of a request.
+type paramError struct {
+	Parameter string
+	Reason    string
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Parameter, e.Reason)
+}
+
+// httpParamError writes the JSON error of an invalid parameter, with
+// the parameter and the reason besides the message.
+func httpParamError(response http.ResponseWriter, err *paramError) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(response).Encode(map[string]string{
+		"error":     err.Error(),
+		"parameter": err.Parameter,
+		"reason":    err.Reason,
+	})
+}
+
+// intersectsGeometry returns the WKT and the SRS of the geometry of an
+// intersects query given either as WKT or as GeoJSON, the parameters
+// named after prefix.
+func intersectsGeometry(prefix, wkt, srs, geometry string) (string, string, *paramError) {
+	if len(geometry) == 0 {
+		return wkt, srs, nil
+	}
+	if len(wkt) > 0 {
+		return "", "", &paramError{prefix + "geojson", "wkt and geojson are mutually exclusive"}
+	}
+	wkt, err := geoJSONToWKT([]byte(geometry))
+	if err != nil {
+		return "", "", &paramError{prefix + "geojson", err.Error()}
+	}
+	// GeoJSON coordinates are WGS84 longitudes and latitudes.
+	if len(srs) == 0 {
+		srs = "EPSG:4326"
+	}
+	return wkt, srs, nil
+}
+
+// geoJSONToWKT returns the WKT of a GeoJSON geometry, or of the geometry
+// of a GeoJSON feature.
+func geoJSONToWKT(data []byte) (string, error) {
+	var object struct {
+		Type     string          `json:"type"`
+		Geometry json.RawMessage `json:"geometry"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		if _, isType := err.(*json.UnmarshalTypeError); isType {
+			return "", fmt.Errorf("not a GeoJSON object")
+		}
+		return "", fmt.Errorf("invalid JSON: %v", err)
+	}
+	if object.Type == "Feature" {
+		if len(object.Geometry) == 0 || string(object.Geometry) == "null" {
+			return "", fmt.Errorf("feature without geometry")
+		}
+		data = object.Geometry
+	}
+
+	g, err := geojson.UnmarshalGeometry(data)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := writeWKT(&b, g); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writeWKT(b *strings.Builder, g *geojson.Geometry) error {
+	if len(g.MultiLineString) == 0 && len(g.MultiPolygon) == 0 && len(g.Geometries) == 0 {
+		switch g.Type {
+		case geojson.GeometryMultiLineString, geojson.GeometryMultiPolygon, geojson.GeometryCollection:
+			return fmt.Errorf("empty %s", g.Type)
+		}
+	}
+	switch g.Type {
+	case geojson.GeometryPoint:
+		b.WriteString("POINT(")
+		if err := writePosition(b, g.Point); err != nil {
+			return err
+		}
+	case geojson.GeometryMultiPoint:
+		b.WriteString("MULTIPOINT")
+		return writePositions(b, g.MultiPoint, 1, false)
+	case geojson.GeometryLineString:
+		b.WriteString("LINESTRING")
+		return writePositions(b, g.LineString, 2, false)
+	case geojson.GeometryMultiLineString:
+		b.WriteString("MULTILINESTRING(")
+		for i, line := range g.MultiLineString {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writePositions(b, line, 2, false); err != nil {
+				return err
+			}
+		}
+	case geojson.GeometryPolygon:
+		b.WriteString("POLYGON")
+		return writePolygon(b, g.Polygon)
+	case geojson.GeometryMultiPolygon:
+		b.WriteString("MULTIPOLYGON(")
+		for i, polygon := range g.MultiPolygon {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writePolygon(b, polygon); err != nil {
+				return err
+			}
+		}
+	case geojson.GeometryCollection:
+		b.WriteString("GEOMETRYCOLLECTION(")
+		for i, member := range g.Geometries {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeWKT(b, member); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+	b.WriteByte(')')
+	return nil
+}
+
+func writePolygon(b *strings.Builder, rings [][][]float64) error {
+	if len(rings) == 0 {
+		return fmt.Errorf("polygon without rings")
+	}
+	b.WriteByte('(')
+	for i, ring := range rings {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := writePositions(b, ring, 4, true); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(')')
+	return nil
+}
+
+// writePositions writes a list of at least min positions, the first
+// and the last being equal if closed.
+func writePositions(b *strings.Builder, positions [][]float64, min int, closed bool) error {
+	if len(positions) < min {
+		return fmt.Errorf("expected at least %d positions, got %d", min, len(positions))
+	}
+	if closed {
+		first, last := positions[0], positions[len(positions)-1]
+		if len(first) < 2 || len(last) < 2 || first[0] != last[0] || first[1] != last[1] {
+			return fmt.Errorf("linear ring not closed")
+		}
+	}
+	b.WriteByte('(')
+	for i, position := range positions {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := writePosition(b, position); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(')')
+	return nil
+}
+
+// writePosition writes the x and y of a position, the elevation and the
+// other coordinates being ignored.
+func writePosition(b *strings.Builder, position []float64) error {
+	if len(position) < 2 {
+		return fmt.Errorf("expected a position of at least 2 coordinates, got %d", len(position))
+	}
+	for i, v := range position[:2] {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("invalid coordinate %v", v)
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/thredds"
+	"github.com/nci/gsky/tracing"
+)
+
+// maxIngestSize is the maximum size in bytes of the body of an /ingest
+// request.
+const maxIngestSize = 256 << 20
+
+// ingestRecord is a gdal record of a crawler pushed to /ingest, with the
+// path it is recorded under.
+type ingestRecord struct {
+	Path   string          `json:"path"`
+	Record json.RawMessage `json:"record"`
+}
+
+// ingestResult is the result of mas_ingest.
+type ingestResult struct {
+	Ingested int      `json:"ingested"`
+	GPaths   []string `json:"gpaths"`
+}
+
+// parseIngestRecord validates a gdal record of the crawler and returns
+// it with its path, that of its filename or, for an OPeNDAP URL, its
+// path under /thredds as the crawler records it.
+func parseIngestRecord(raw json.RawMessage) (*ingestRecord, error) {
+	if raw = bytes.TrimSpace(raw); len(raw) == 0 || raw[0] != '{' {
+		return nil, errors.New("not a JSON object")
+	}
+	var rec struct {
+		FileName    string            `json:"filename"`
+		GeoMetaData []json.RawMessage `json:"geo_metadata"`
+	}
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return nil, err
+	}
+	recPath := strings.TrimSpace(rec.FileName)
+	if len(recPath) == 0 {
+		return nil, errors.New("filename required")
+	}
+	if strings.HasPrefix(recPath, "http://") || strings.HasPrefix(recPath, "https://") {
+		var err error
+		if recPath, err = thredds.RecordPath(recPath); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.HasPrefix(recPath, "/") || path.Clean(recPath) != recPath {
+		return nil, fmt.Errorf("filename %s is not a clean absolute path", rec.FileName)
+	}
+	if len(rec.GeoMetaData) == 0 {
+		return nil, fmt.Errorf("%s: geo_metadata required", rec.FileName)
+	}
+	return &ingestRecord{Path: recPath, Record: raw}, nil
+}
+
+// parseIngestRecords parses the records of the body of an /ingest
+// request, a record, an array of records or the JSON lines of the
+// crawler, the last record of a path replacing the previous ones.
+func parseIngestRecords(body io.Reader) ([]*ingestRecord, error) {
+	var records []*ingestRecord
+	byPath := make(map[string]int)
+	dec := json.NewDecoder(body)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON request body: %v", err)
+		}
+		values := []json.RawMessage{raw}
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] == '[' {
+			values = nil
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return nil, fmt.Errorf("invalid JSON request body: %v", err)
+			}
+		}
+		for _, value := range values {
+			rec, err := parseIngestRecord(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid record %d: %v", len(records)+1, err)
+			}
+			if i, found := byPath[rec.Path]; found {
+				records[i] = rec
+				continue
+			}
+			byPath[rec.Path] = len(records)
+			records = append(records, rec)
+		}
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no records, e.g. of gsky-crawl")
+	}
+	return records, nil
+}
+
+// ingestHandler upserts the gdal records of the crawler of a POST /ingest
+// into the shards of their paths in one transaction, e.g. those of the
+// granules of a pipeline as they are produced:
+//
+//	gsky-crawl /g/data/u39/2020.nc | curl -H "Authorization: Bearer $GSKY_ADMIN_TOKEN" --data-binary @- http://localhost:8080/ingest
+//
+// The cached responses of the shards are invalidated once committed.
+func ingestHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpJSONError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	info := accessInfoFrom(r.Context())
+	info.operation = "ingest"
+	span := tracing.SpanFromContext(r.Context())
+	span.SetName("mas ingest")
+
+	records, err := parseIngestRecords(http.MaxBytesReader(w, r.Body, maxIngestSize))
+	if err != nil {
+		httpJSONError(w, err, http.StatusBadRequest)
+		return
+	}
+	if *maxIngestRecords > 0 && len(records) > *maxIngestRecords {
+		httpJSONError(w, fmt.Errorf("%d records exceed the maximum of %d", len(records), *maxIngestRecords), http.StatusRequestEntityTooLarge)
+		return
+	}
+	body, err := json.Marshal(records)
+	if err != nil {
+		httpJSONError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if ingestDB == nil {
+		httpJSONError(w, fmt.Errorf("ingestion is disabled, -ingest_user being empty"), http.StatusNotFound)
+		return
+	}
+	if err := dbStatus.unavailable(); err != nil {
+		info.errorClass = "unavailable"
+		metrics.observeQuery("ingest", time.Now(), "unavailable", 0)
+		httpDBUnavailable(w, time.Duration(*dbCheckInterval)*time.Second)
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	if *ingestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(r.Context(), time.Duration(*ingestTimeout)*time.Second)
+	}
+	defer cancel()
+
+	var payload string
+	t0 := time.Now()
+	err = ingestDB.QueryRowContext(ctx, `select mas_ingest($1::jsonb) as json`, string(body)).Scan(&payload)
+	if err != nil {
+		status := http.StatusBadRequest
+		queryStatus := "error"
+		timedOut := ctx.Err() == context.DeadlineExceeded
+		info.errorClass = errorClass(err, timedOut)
+		if timedOut {
+			err = fmt.Errorf("ingestion timed out after %v", time.Since(t0).Round(time.Second))
+			status = http.StatusGatewayTimeout
+			queryStatus = "timeout"
+		} else if isConnError(err) {
+			dbStatus.failed()
+			status = http.StatusServiceUnavailable
+			queryStatus = "unavailable"
+		}
+		metrics.observeQuery("ingest", t0, queryStatus, 0)
+		span.SetError(err)
+		logging.FromContext(r.Context()).Warnf("ingestion of %d records failed: %v", len(records), err)
+		if status == http.StatusServiceUnavailable {
+			httpDBUnavailable(w, time.Duration(*dbCheckInterval)*time.Second)
+			return
+		}
+		httpJSONError(w, err, status)
+		return
+	}
+	metrics.observeQuery("ingest", t0, "ok", len(payload))
+
+	var result ingestResult
+	if err := json.Unmarshal([]byte(payload), &result); err != nil {
+		logging.FromContext(r.Context()).Warnf("invalid ingestion result: %v", err)
+	}
+	logging.FromContext(r.Context()).Infof("ingested %d records into %s in %v", result.Ingested, strings.Join(result.GPaths, ", "), time.Since(t0).Round(time.Millisecond))
+	if cache != nil {
+		generation := strconv.FormatInt(time.Now().UnixNano(), 10)
+		for _, gpath := range result.GPaths {
+			if err := cache.Set(generationKey(gpath), []byte(generation), false, 0); err != nil {
+				logging.FromContext(r.Context()).Warnf("failed to invalidate %s: %v", gpath, err)
+			}
+		}
+	}
+	// The records likely add timestamps.
+	hooks.checkTimestamps()
+	io.WriteString(w, payload)
+}
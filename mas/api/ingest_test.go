@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseIngestRecords(t *testing.T) {
+	const (
+		chirps  = `{"filename": "/g/data/chirps/2020.tif", "geo_metadata": [{"ds_name": "/g/data/chirps/2020.tif"}]}`
+		tamsat  = `{"filename": "/g/data/tamsat/2020.nc", "geo_metadata": [{"ds_name": "precip"}]}`
+		opendap = `{"filename": "https://example.org/thredds/dodsC/chirps/2020.nc", "geo_metadata": [{"ds_name": "precip"}]}`
+		updated = `{"filename": "/g/data/chirps/2020.tif", "geo_metadata": [{"ds_name": "updated"}]}`
+	)
+
+	tests := []struct {
+		name  string
+		body  string
+		paths []string
+		err   string
+	}{
+		{name: "record", body: chirps, paths: []string{"/g/data/chirps/2020.tif"}},
+		{name: "array", body: "[" + chirps + "," + tamsat + "]", paths: []string{"/g/data/chirps/2020.tif", "/g/data/tamsat/2020.nc"}},
+		{name: "json lines", body: chirps + "\n" + tamsat + "\n\n" + opendap + "\n", paths: []string{"/g/data/chirps/2020.tif", "/g/data/tamsat/2020.nc", "/thredds/example.org/thredds/dodsC/chirps/2020.nc"}},
+		{name: "last record of a path", body: chirps + "\n" + tamsat + "\n" + updated, paths: []string{"/g/data/chirps/2020.tif", "/g/data/tamsat/2020.nc"}},
+		{name: "empty", body: " \n", err: "no records"},
+		{name: "invalid json", body: chirps + "\n{", err: "invalid JSON request body"},
+		{name: "not an object", body: `["/g/data/chirps/2020.tif"]`, err: "invalid record 1: not a JSON object"},
+		{name: "no filename", body: tamsat + "\n" + `{"geo_metadata": [{}]}`, err: "invalid record 2: filename required"},
+		{name: "relative path", body: `{"filename": "chirps/2020.tif", "geo_metadata": [{}]}`, err: "is not a clean absolute path"},
+		{name: "unclean path", body: `{"filename": "/g/data/../etc/2020.tif", "geo_metadata": [{}]}`, err: "is not a clean absolute path"},
+		{name: "no geo_metadata", body: `{"filename": "/g/data/chirps/2020.tif"}`, err: "geo_metadata required"},
+	}
+
+	for _, test := range tests {
+		records, err := parseIngestRecords(strings.NewReader(test.body))
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error of %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(records) != len(test.paths) {
+			t.Errorf("%s: expected %d records, got %d", test.name, len(test.paths), len(records))
+			continue
+		}
+		for i, rec := range records {
+			if rec.Path != test.paths[i] {
+				t.Errorf("%s: expected record %d of %s, got %s", test.name, i, test.paths[i], rec.Path)
+			}
+		}
+	}
+
+	records, err := parseIngestRecords(strings.NewReader(chirps + "\n" + updated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(records[0].Record) != updated {
+		t.Errorf("expected the last record of the path, got %s", records[0].Record)
+	}
+}
+
+func TestIngestHandlerRequests(t *testing.T) {
+	savedToken, savedMax := *adminToken, *maxIngestRecords
+	defer func() { *adminToken, *maxIngestRecords = savedToken, savedMax }()
+	*adminToken = "secret"
+	*maxIngestRecords = 1
+
+	const record = `{"filename": "/g/data/chirps/2020.tif", "geo_metadata": [{}]}`
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		status int
+	}{
+		{name: "unauthorised", method: http.MethodPost, token: "guess", body: record, status: http.StatusUnauthorized},
+		{name: "get", method: http.MethodGet, token: "secret", status: http.StatusMethodNotAllowed},
+		{name: "invalid record", method: http.MethodPost, token: "secret", body: `{"filename": "2020.tif"}`, status: http.StatusBadRequest},
+		{name: "too many records", method: http.MethodPost, token: "secret", body: record + "\n" + strings.Replace(record, "2020", "2021", 1), status: http.StatusRequestEntityTooLarge},
+		{name: "no ingest user", method: http.MethodPost, token: "secret", body: record, status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/ingest", strings.NewReader(test.body))
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		w := httptest.NewRecorder()
+		ingestHandler(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d: %s", test.name, test.status, w.Code, w.Body.String())
+		}
+	}
+}
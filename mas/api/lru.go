@@ -0,0 +1,148 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// lruCache is a bounded in-process cache of the responses, used alone
+// by the MAS processes without memcached or Redis, or in front of them.
+// Least recently used responses are evicted first.
+type lruCache struct {
+	ttl      time.Duration
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type lruEntry struct {
+	key        string
+	value      []byte
+	compressed bool
+	expires    time.Time
+}
+
+// newLRUCache creates a cache holding up to maxBytes of responses, each
+// for at most ttl.
+func newLRUCache(maxBytes int64, ttl time.Duration) *lruCache {
+	return &lruCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *lruCache) Get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, found := c.entries[key]
+	if !found {
+		return nil, false, errCacheMiss
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(elem)
+		return nil, false, errCacheMiss
+	}
+	c.lru.MoveToFront(elem)
+	return entry.value, entry.compressed, nil
+}
+
+func (c *lruCache) GetMulti(keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if value, _, err := c.Get(key); err == nil {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// Set keeps a value for ttl seconds if less than the ttl of the cache.
+func (c *lruCache) Set(key string, value []byte, compressed bool, ttl int32) error {
+	size := int64(len(key) + len(value))
+	if size > c.maxBytes {
+		return nil
+	}
+	expiry := c.ttl
+	if ttl > 0 && time.Duration(ttl)*time.Second < expiry {
+		expiry = time.Duration(ttl) * time.Second
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, found := c.entries[key]; found {
+		c.removeElement(elem)
+	}
+	entry := &lruEntry{key: key, value: value, compressed: compressed, expires: time.Now().Add(expiry)}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+
+	for c.size > c.maxBytes {
+		c.removeElement(c.lru.Back())
+	}
+	return nil
+}
+
+func (c *lruCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *lruCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.key) + len(entry.value))
+}
+
+// tieredCache serves the responses from the in-process cache first and
+// then from the shared cache, keeping the responses of the shared cache
+// in the in-process cache.
+type tieredCache struct {
+	local  responseCache
+	shared responseCache
+}
+
+func (c *tieredCache) Get(key string) ([]byte, bool, error) {
+	if value, compressed, err := c.local.Get(key); err == nil {
+		return value, compressed, nil
+	}
+	value, compressed, err := c.shared.Get(key)
+	if err == nil {
+		c.local.Set(key, value, compressed, 0)
+	}
+	return value, compressed, err
+}
+
+// GetMulti reads the shared cache only, where the generations of the
+// gpaths are changed by any MAS process.
+func (c *tieredCache) GetMulti(keys []string) (map[string][]byte, error) {
+	return c.shared.GetMulti(keys)
+}
+
+func (c *tieredCache) Set(key string, value []byte, compressed bool, ttl int32) error {
+	c.local.Set(key, value, compressed, ttl)
+	return c.shared.Set(key, value, compressed, ttl)
+}
+
+func (c *tieredCache) Ping(ctx context.Context) error {
+	return c.shared.Ping(ctx)
+}
+
+// observedCache counts the hits and misses of a cache by name.
+type observedCache struct {
+	name string
+	responseCache
+}
+
+func (c *observedCache) Get(key string) ([]byte, bool, error) {
+	value, compressed, err := c.responseCache.Get(key)
+	metrics.observeCache(c.name, err == nil)
+	return value, compressed, err
+}
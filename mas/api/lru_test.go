@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEviction(t *testing.T) {
+	// each entry takes 1 byte of key and 9 of value
+	c := newLRUCache(30, time.Minute)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, []byte("123456789"), false, 0)
+	}
+	if _, _, err := c.Get("a"); err != nil {
+		t.Fatalf("expected a to be cached: %v", err)
+	}
+
+	// b is now the least recently used
+	c.Set("d", []byte("123456789"), false, 0)
+	if _, _, err := c.Get("b"); err != errCacheMiss {
+		t.Errorf("expected b to be evicted, got %v", err)
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, _, err := c.Get(key); err != nil {
+			t.Errorf("expected %s to be cached: %v", key, err)
+		}
+	}
+	if c.size != 30 {
+		t.Errorf("expected 30 bytes cached, got %d", c.size)
+	}
+
+	// larger than the whole cache
+	c.Set("e", make([]byte, 30), false, 0)
+	if _, _, err := c.Get("e"); err != errCacheMiss {
+		t.Errorf("expected e not to be cached, got %v", err)
+	}
+	if len(c.entries) != 3 {
+		t.Errorf("expected 3 entries kept, got %d", len(c.entries))
+	}
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	c := newLRUCache(1<<10, 20*time.Millisecond)
+	c.Set("cache", []byte("value"), false, 0)
+	c.Set("long", []byte("value"), false, 3600)
+
+	time.Sleep(40 * time.Millisecond)
+	for _, key := range []string{"cache", "long"} {
+		if _, _, err := c.Get(key); err != errCacheMiss {
+			t.Errorf("expected %s to expire with the ttl of the cache, got %v", key, err)
+		}
+	}
+	if c.size != 0 || c.lru.Len() != 0 {
+		t.Errorf("expected the expired entries to be removed, got %d bytes of %d entries", c.size, c.lru.Len())
+	}
+
+	c = newLRUCache(1<<10, time.Hour)
+	c.Set("short", []byte("value"), false, 1)
+	entry := c.entries["short"].Value.(*lruEntry)
+	if expiry := time.Until(entry.expires); expiry > time.Second {
+		t.Errorf("expected the ttl of the value to be kept, expires in %v", expiry)
+	}
+}
+
+func TestLRUCacheUpdate(t *testing.T) {
+	c := newLRUCache(1<<10, time.Minute)
+	c.Set("key", []byte("first"), false, 0)
+	c.Set("key", []byte("second value"), true, 0)
+
+	value, compressed, err := c.Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "second value" || !compressed {
+		t.Errorf("expected the updated value, got %q compressed %v", value, compressed)
+	}
+	if c.lru.Len() != 1 || len(c.entries) != 1 {
+		t.Errorf("expected 1 entry, got %d", c.lru.Len())
+	}
+	if want := int64(len("key") + len("second value")); c.size != want {
+		t.Errorf("expected %d bytes cached, got %d", want, c.size)
+	}
+
+	values, err := c.GetMulti([]string{"key", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || string(values["key"]) != "second value" {
+		t.Errorf("expected the found value only, got %v", values)
+	}
+}
+
+func TestTieredCache(t *testing.T) {
+	local := newLRUCache(1<<10, time.Minute)
+	shared := newLRUCache(1<<10, time.Minute)
+	c := &tieredCache{local: local, shared: shared}
+
+	shared.Set("key", []byte("value"), false, 0)
+	if value, _, err := c.Get("key"); err != nil || string(value) != "value" {
+		t.Fatalf("expected the shared value, got %q: %v", value, err)
+	}
+	if _, _, err := local.Get("key"); err != nil {
+		t.Errorf("expected the shared value to be kept in process: %v", err)
+	}
+
+	// the generations are read from the shared cache only
+	local.Set("gen", []byte("1"), false, 0)
+	if values, _ := c.GetMulti([]string{"gen"}); len(values) != 0 {
+		t.Errorf("expected the in-process values to be ignored, got %v", values)
+	}
+}
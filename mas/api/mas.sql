@@ -318,6 +318,76 @@ create or replace function mas_intersects(
   end
 $$;
 
+-- Find the files intersecting a bounding polygon as mas_intersects, one
+-- row per dataset, for the responses streamed by MAS
+
+create or replace function mas_intersects_rows(
+  gpath      text,
+  srs        text, -- EPSG:nnnn
+  wkt        text, -- bounding polygon
+  n_seg      integer, -- number of segments for polygon segmentation
+  time_a     timestamptz, -- time range low
+  time_b     timestamptz, -- time range high
+  namespace  text[], -- for NetCDF, the variable name
+  raw_metadata text, -- gdal, pdal
+  identity_tol float8, -- distance tolerance considered as same point
+  dp_tol       float, -- distance tolerance for Douglas-Peucker algorithm
+  limit_val    integer -- limit on number of query rows
+)
+  returns setof jsonb language sql as $$
+  select jsonb_array_elements(
+    mas_intersects(gpath, srs, wkt, n_seg, time_a, time_b, namespace,
+      raw_metadata, identity_tol, dp_tol, limit_val)->'gdal'
+  );
+$$;
+
+-- Find the files intersecting each query of a JSON array of intersects
+-- queries, of the parameters of mas_intersects as strings, and return
+-- the array of their results in order. A query failing is answered with
+-- its error without failing the others.
+
+create or replace function mas_batch_intersects(
+  gpath      text,  -- file path to search
+  queries    jsonb  -- array of queries
+)
+  returns jsonb language plpgsql as $$
+  declare
+    q          jsonb;
+    result     jsonb;
+    results    jsonb := '[]'::jsonb;
+  begin
+    if gpath is null then
+      raise exception 'invalid search path';
+    end if;
+    if jsonb_typeof(queries) is distinct from 'array' then
+      raise exception 'invalid queries';
+    end if;
+
+    for q in select value from jsonb_array_elements(queries) with ordinality as t(value, n) order by n loop
+      begin
+        result := mas_intersects(
+          gpath,
+          nullif(q->>'srs', ''),
+          nullif(q->>'wkt', ''),
+          nullif(q->>'nseg', '')::integer,
+          nullif(q->>'time', '')::timestamptz,
+          nullif(q->>'until', '')::timestamptz,
+          string_to_array(nullif(q->>'namespace', ''), ','),
+          nullif(q->>'metadata', ''),
+          nullif(q->>'identitytol', '')::float8,
+          nullif(q->>'dptol', '')::float,
+          nullif(q->>'limit', '')::integer
+        );
+      exception when others then
+        result := jsonb_build_object('error', SQLERRM);
+      end;
+      results := results || jsonb_build_array(result);
+    end loop;
+
+    return jsonb_build_object('results', results);
+  end
+$$;
+
 create or replace function codegen_shard_intersect_times()
   returns text language plpgsql as $$
   declare
@@ -517,6 +587,8 @@ create or replace function codegen_gdal_json()
               geo->>'polygon',
               'overviews',
               geo->'overviews',
+              'image_structure',
+              geo->'image_structure',
               'means',
               geo->'means',
               'sample_counts',
@@ -526,7 +598,17 @@ create or replace function codegen_gdal_json()
               'axes',
               geo->'axes',
               'geo_loc',
-              geo->'geo_loc'
+              geo->'geo_loc',
+              'chunks',
+              geo->'chunks',
+              'units',
+              geo->'units',
+              'scale_factor',
+              geo->'scale_factor',
+              'add_offset',
+              geo->'add_offset',
+              'valid_range',
+              geo->'valid_range'
             )
               as dataset
 
@@ -602,15 +684,160 @@ create or replace function mas_refresh_caches()
   end
 $$;
 
+-- Upsert the crawler records pushed to the /ingest endpoint, objects of
+-- the path of a record and of its gdal record, into the shards of their
+-- paths in the transaction of the call, then refresh the polygons, the
+-- caches and the codegens of the shards, as shard_append.sh does, for the
+-- records to be served at once. The function runs as its owner, the api
+-- role only reading the shards, and is only executable by the ingest role
+-- of the -ingest_user of MAS. Its search_path is public but while it
+-- writes a shard, that of mas_view.
+
+do $$
+  begin
+    if not exists (select true from pg_roles where rolname = 'ingest') then
+      create role ingest with password null login;
+    end if;
+    execute format('grant connect on database %I to ingest', current_database());
+  end
+$$;
+
+create or replace function mas_ingest(
+  records jsonb -- [{"path": ..., "record": {"filename": ..., ...}}, ...]
+)
+  returns jsonb language plpgsql security definer
+  set search_path = public as $$
+  declare
+    shard    text;
+    missing  text;
+    rec      record;
+    ingested integer := 0;
+    gpaths   text[] := '{}';
+  begin
+    if records is null or jsonb_typeof(records) <> 'array' then
+      raise exception 'invalid records';
+    end if;
+
+    perform public.mas_reset();
+
+    missing := (
+      select r->>'path'
+      from jsonb_array_elements(records) r
+      where not exists (
+        select true from public.shards
+        where left(r->>'path', char_length(sh_path) + 1) = concat(sh_path, '/')
+      )
+      limit 1
+    );
+    if missing is not null then
+      raise exception 'no shard for %', missing;
+    end if;
+
+    -- The records go to the shard of the longest gpath of their path.
+    for rec in
+      select s.gpath, jsonb_agg(r) as records
+      from jsonb_array_elements(records) r
+      cross join lateral (
+        select sh_path as gpath
+        from public.shards
+        where left(r->>'path', char_length(sh_path) + 1) = concat(sh_path, '/')
+        order by char_length(sh_path) desc
+        limit 1
+      ) s
+      group by s.gpath
+      order by s.gpath
+    loop
+      shard := public.mas_view(rec.gpath);
+      if shard = '' then
+        raise exception 'no schema for shard %', rec.gpath;
+      end if;
+
+      raise notice 'ingest % records into shard %', jsonb_array_length(rec.records), shard;
+      insert into ingest (in_path, in_type, in_json)
+        select r->>'path', 'gdal', r->'record'
+        from jsonb_array_elements(rec.records) r;
+
+      perform refresh_polygons();
+      perform refresh_caches();
+      perform refresh_codegens();
+
+      ingested := ingested + jsonb_array_length(rec.records);
+      gpaths := gpaths || rec.gpath;
+      perform public.mas_reset();
+    end loop;
+
+    perform public.mas_refresh_caches();
+
+    -- refresh_polygons tunes the session for the refresh, that of a
+    -- pooled connection of the ingest role here.
+    perform set_config(name, reset_val, false)
+      from pg_settings
+      where name in ('parallel_setup_cost', 'parallel_tuple_cost',
+        'max_parallel_workers', 'max_parallel_workers_per_gather',
+        'max_parallel_maintenance_workers', 'synchronous_commit',
+        'maintenance_work_mem', 'effective_cache_size');
+
+    perform public.mas_reset();
+    return jsonb_build_object('ingested', ingested, 'gpaths', to_jsonb(gpaths));
+  end
+$$;
+
+revoke execute on function mas_ingest(jsonb) from public;
+grant execute on function mas_ingest(jsonb) to ingest;
+
 -- Find all the time stamps overlapping with a given time range
 -- The time stamps are filtered by gpath, namespace
 
+-- Returns a page of the timestamps of a mas_timestamps result, from
+-- offset_val and of at most limit_val timestamps, with the total number
+-- of timestamps and the offset of the next page if any.
+create or replace function mas_timestamps_page(
+  result     jsonb,
+  offset_val integer,
+  limit_val  integer
+)
+  returns jsonb language plpgsql as $$
+  declare
+    page       jsonb;
+    total      integer;
+  begin
+
+    if offset_val is null and limit_val is null then
+      return result;
+    end if;
+    if offset_val < 0 or limit_val <= 0 then
+      raise exception 'invalid offset or limit';
+    end if;
+    offset_val := coalesce(offset_val, 0);
+
+    total := jsonb_array_length(result->'timestamps');
+    select coalesce(jsonb_agg(stamp order by i), '[]'::jsonb) into page
+    from jsonb_array_elements(result->'timestamps') with ordinality as stamps(stamp, i)
+    where i > offset_val
+    and (limit_val is null or i <= offset_val + limit_val);
+
+    result := jsonb_set(result, '{timestamps}', page)
+      || jsonb_build_object('total', total, 'offset', offset_val);
+    if limit_val is not null and offset_val + limit_val < total then
+      result := result || jsonb_build_object('next_offset', offset_val + limit_val);
+    end if;
+    return result;
+
+  end
+$$;
+
+-- The signature without pagination is dropped for the databases created
+-- before the offset and limit arguments.
+drop function if exists mas_timestamps(text, timestamptz, timestamptz, text[], text);
+
 create or replace function mas_timestamps(
   gpath      text,        -- file path to search
   time_a     timestamptz, -- time range low
   time_b     timestamptz, -- time range high
   namespace  text[],      -- the variable name
-  token      text         -- token that decides if client cache needs refresh 
+  token      text,        -- token that decides if client cache needs refresh 
+  offset_val integer,     -- number of timestamps skipped
+  limit_val  integer      -- maximum number of timestamps returned
 )
   returns jsonb language plpgsql as $$
   declare
@@ -642,7 +869,7 @@ create or replace function mas_timestamps(
 
     select value || jsonb_build_object('token', query_hash) into result from ows_cache where query_id = query_hash;
     if result is not null then
-      return result;
+      return mas_timestamps_page(result, offset_val, limit_val);
     end if;
 
     -- By default, we filter out all the future dates
@@ -687,7 +914,50 @@ create or replace function mas_timestamps(
      on conflict (query_id) do nothing;
 
      perform mas_reset();
-     return result;
+     return mas_timestamps_page(result, offset_val, limit_val);
+
+  end
+$$;
+
+create or replace function mas_timestamps_agg(
+  gpath      text,        -- file path to search
+  time_a     timestamptz, -- time range low
+  time_b     timestamptz, -- time range high
+  namespace  text[],      -- the variable name
+  group_by   text         -- bucket of the counts: day, month or year
+)
+  returns jsonb language plpgsql as $$
+  declare
+    result     jsonb;
+    prefix_len integer;
+  begin
+
+    prefix_len := case group_by
+      when 'day' then 10
+      when 'month' then 7
+      when 'year' then 4
+    end;
+    if prefix_len is null then
+      raise exception 'invalid group_by, expected day, month or year';
+    end if;
+
+    -- The timestamps of the query are those of mas_timestamps, cached in
+    -- ows_cache, which are formatted as YYYY-MM-DDTHH:MI:SS.000Z in UTC so
+    -- that their prefixes are the buckets.
+    result := mas_timestamps(gpath, time_a, time_b, namespace, null, null, null);
+
+    return jsonb_build_object(
+      'group_by', group_by,
+      'total', jsonb_array_length(result->'timestamps'),
+      'buckets', coalesce((
+        select jsonb_agg(jsonb_build_object('bucket', bucket, 'count', n) order by bucket)
+        from (
+          select left(stamp, prefix_len) as bucket, count(*) as n
+          from jsonb_array_elements_text(result->'timestamps') as stamps(stamp)
+          group by 1
+        ) as buckets
+      ), '[]'::jsonb)
+    );
 
   end
 $$;
@@ -755,6 +1025,445 @@ create or replace function mas_get_ows_cache(
   end
 $$;
 
+-- List the files under a path with their namespaces, the timestamps
+-- within the time range and their polygon, ordered by path and paged
+-- with offset_val and limit_val, after the file of the cursor if any.
+-- The files may be filtered by a SQL/JSON path matching their metadata,
+-- the jsonpath type requiring Postgres 12.
+
+-- The signatures without cursor or filter are dropped for the databases
+-- created before these arguments.
+drop function if exists mas_files(text, timestamptz, timestamptz, text[], integer, integer);
+drop function if exists mas_files(text, timestamptz, timestamptz, text[], integer, integer, text, text);
+
+create or replace function mas_files(
+  gpath       text,        -- file path to search
+  time_a      timestamptz, -- time range low
+  time_b      timestamptz, -- time range high
+  namespace   text[],      -- the variable names
+  offset_val  integer,     -- number of files skipped
+  limit_val   integer,     -- maximum number of files returned
+  cursor_path text,        -- path of the last file of the previous page
+  cursor_name text,        -- namespace of the last file of the previous page
+  filter_path text         -- SQL/JSON path of the metadata of the files
+)
+  returns jsonb language plpgsql as $$
+  declare
+    result jsonb;
+    shard text;
+  begin
+    if gpath is null then
+      raise exception 'invalid search path';
+    end if;
+    if offset_val < 0 or limit_val <= 0 then
+      raise exception 'invalid offset or limit';
+    end if;
+    offset_val := coalesce(offset_val, 0);
+
+    perform mas_reset();
+    shard := mas_view(gpath);
+    if shard = '' then
+      return jsonb_build_object('files', '[]'::jsonb, 'total', 0, 'offset', offset_val);
+    end if;
+
+    -- n numbers the files past the cursor, those of the pages.
+    result := (select
+      jsonb_build_object(
+        'files',
+        coalesce(jsonb_agg(file order by n) filter (where in_page), '[]'::jsonb),
+        'total',
+        count(*),
+        'offset',
+        offset_val,
+        'remaining',
+        count(*) filter (where past_cursor and not in_page and n > offset_val),
+        'last_key',
+        (jsonb_agg(jsonb_build_array(pa_path, po_name) order by n desc) filter (where in_page))->0
+      )
+      from (
+        select
+          *,
+          past_cursor and n > offset_val and (limit_val is null or n <= offset_val + limit_val) as in_page
+        from (
+          select
+            count(*) filter (where past_cursor) over (order by pa_path, po_name rows unbounded preceding) as n,
+            past_cursor,
+            pa_path,
+            po_name,
+            jsonb_build_object(
+              'file_path',
+              pa_path,
+              'namespace',
+              po_name,
+              'timestamps',
+              (select coalesce(jsonb_agg(to_char(t at time zone 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS".000Z"') order by t), '[]'::jsonb)
+                from unnest(stamps) t),
+              'srid',
+              public.ST_SRID(po_polygon),
+              'polygon',
+              public.ST_AsText(po_polygon)
+            ) as file
+          from (
+            select
+              pa_path,
+              coalesce(po_name, '') as po_name,
+              po_polygon,
+              array(select t from unnest(po_stamps) t
+                where (time_a is null or t >= time_a)
+                and (time_b is null or t <= time_b)
+              ) as stamps,
+              cursor_path is null or (pa_path, coalesce(po_name, '')) > (cursor_path, cursor_name)
+                as past_cursor
+            from polygons
+            inner join paths
+              on pa_hash = po_hash
+            where public.path_hash(gpath) = any(pa_parents)
+            and (namespace is null or po_name = any(namespace))
+            and (time_a is null or po_max_stamp >= time_a)
+            and (time_b is null or po_min_stamp <= time_b)
+            and (filter_path is null or exists (
+              select 1 from metadata md
+              where md.md_hash = pa_hash
+              and jsonb_path_exists(md.md_json, filter_path::jsonpath)
+            ))
+          ) g
+          where (time_a is null and time_b is null) or cardinality(stamps) > 0
+        ) numbered
+      ) f
+    );
+
+    if (result->>'remaining')::integer > 0 then
+      if cursor_path is null then
+        result := result || jsonb_build_object('next_offset', offset_val + limit_val);
+      end if;
+      result := result || jsonb_build_object('next_token',
+        translate(encode(convert_to(result->>'last_key', 'UTF8'), 'base64'), E'+/=\n', '-_'));
+    end if;
+    result := result - 'remaining' - 'last_key';
+
+    perform mas_reset();
+    return result;
+
+    end
+$$;
+
+-- List the files directly under a path crawled for their GDAL metadata,
+-- with the size, modification time and checksum of the file when
+-- crawled, if recorded by the crawler, and the time of their ingestion,
+-- for the
+-- incremental crawls to skip those unchanged since. The files of the
+-- subdirectories are listed too if recursive, for the crawler to find
+-- those deleted or corrupt since. The files are ordered by path and paged with
+-- limit_val, after the path of the cursor if any.
+drop function if exists mas_crawled(text, integer, text);
+create or replace function mas_crawled(
+  gpath       text,    -- directory to search
+  limit_val   integer, -- maximum number of files returned
+  cursor_path text,    -- path of the last file of the previous page
+  recursive   boolean  -- whether to list the files of the subdirectories
+)
+  returns jsonb language plpgsql as $$
+  declare
+    result jsonb;
+    more   boolean;
+    shard  text;
+  begin
+    if gpath is null then
+      raise exception 'invalid search path';
+    end if;
+    if limit_val <= 0 then
+      raise exception 'invalid limit';
+    end if;
+
+    perform mas_reset();
+    shard := mas_view(gpath);
+    if shard = '' then
+      return jsonb_build_object('files', '[]'::jsonb);
+    end if;
+
+    -- One more file than the page tells whether there is a next page.
+    with crawled as (
+      -- The duplicates linked to their canonical file, of -dedup, are
+      -- listed with the GDAL metadata of their duplicate record.
+      select pa_path,
+        coalesce(md_json->'gdal', md_json)->'posix_info' as posix_info,
+        coalesce(md_json->'gdal', md_json)->>'checksum' as checksum,
+        md_ingested
+      from paths
+      inner join metadata
+        on md_hash = pa_hash
+        and (md_type = 'gdal' or (md_type = 'duplicate' and md_json ? 'gdal'))
+      where (case when recursive
+        then public.path_hash(rtrim(gpath, '/')) = any(pa_parents)
+        else pa_parents[array_length(pa_parents, 1)] = public.path_hash(rtrim(gpath, '/'))
+      end)
+      and (cursor_path is null or pa_path > cursor_path)
+      order by pa_path
+      limit limit_val + 1
+    ),
+    page as (
+      select * from crawled order by pa_path limit limit_val
+    )
+    select
+      jsonb_build_object(
+        'files',
+        coalesce(jsonb_agg(jsonb_build_object(
+          'file_path',
+          pa_path,
+          'size',
+          (posix_info->>'size')::bigint,
+          'mtime',
+          posix_info->>'mtime',
+          'checksum',
+          checksum,
+          'ingested',
+          to_char(md_ingested at time zone 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+        ) order by pa_path), '[]'::jsonb),
+        'last_key',
+        jsonb_build_array(max(pa_path))
+      ),
+      (select count(*) from crawled) > count(*)
+    into result, more
+    from page;
+
+    if more then
+      result := result || jsonb_build_object('next_token',
+        translate(encode(convert_to(result->>'last_key', 'UTF8'), 'base64'), E'+/=\n', '-_'));
+    end if;
+    result := result - 'last_key';
+
+    perform mas_reset();
+    return result;
+  end
+$$;
+
+-- List the files quarantined under a directory, recursively: those whose
+-- extraction failed when crawled with -quarantine, with the error of the
+-- crawler and the time of the failure, until their GDAL metadata is
+-- ingested. The files are ordered by path and paged as mas_crawled.
+create or replace function mas_quarantined(
+  gpath       text,    -- directory to search
+  limit_val   integer, -- maximum number of files returned
+  cursor_path text     -- path of the last file of the previous page
+)
+  returns jsonb language plpgsql as $$
+  declare
+    result jsonb;
+    more   boolean;
+    shard  text;
+  begin
+    if gpath is null then
+      raise exception 'invalid search path';
+    end if;
+    if limit_val <= 0 then
+      raise exception 'invalid limit';
+    end if;
+
+    perform mas_reset();
+    shard := mas_view(gpath);
+    if shard = '' then
+      return jsonb_build_object('files', '[]'::jsonb);
+    end if;
+
+    with quarantined as (
+      select pa_path, md_json, md_ingested
+      from paths
+      inner join metadata
+        on md_hash = pa_hash and md_type = 'quarantine'
+      where public.path_hash(rtrim(gpath, '/')) = any(pa_parents)
+      and (cursor_path is null or pa_path > cursor_path)
+      order by pa_path
+      limit limit_val + 1
+    ),
+    page as (
+      select * from quarantined order by pa_path limit limit_val
+    )
+    select
+      jsonb_build_object(
+        'files',
+        coalesce(jsonb_agg(jsonb_build_object(
+          'file_path',
+          pa_path,
+          'error',
+          md_json->>'error',
+          'time',
+          md_json->>'time',
+          'ingested',
+          to_char(md_ingested at time zone 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+        ) order by pa_path), '[]'::jsonb),
+        'last_key',
+        jsonb_build_array(max(pa_path))
+      ),
+      (select count(*) from quarantined) > count(*)
+    into result, more
+    from page;
+
+    if more then
+      result := result || jsonb_build_object('next_token',
+        translate(encode(convert_to(result->>'last_key', 'UTF8'), 'base64'), E'+/=\n', '-_'));
+    end if;
+    result := result - 'last_key';
+
+    perform mas_reset();
+    return result;
+  end
+$$;
+
+-- List the duplicates under a directory, recursively: the files crawled
+-- with -dedup of the same checksum as a file of the shard, their
+-- canonical file, either linked to it without being indexed or flagged.
+-- The files are ordered by path and paged as mas_crawled.
+create or replace function mas_duplicates(
+  gpath       text,    -- directory to search
+  limit_val   integer, -- maximum number of files returned
+  cursor_path text     -- path of the last file of the previous page
+)
+  returns jsonb language plpgsql as $$
+  declare
+    result jsonb;
+    more   boolean;
+    shard  text;
+  begin
+    if gpath is null then
+      raise exception 'invalid search path';
+    end if;
+    if limit_val <= 0 then
+      raise exception 'invalid limit';
+    end if;
+
+    perform mas_reset();
+    shard := mas_view(gpath);
+    if shard = '' then
+      return jsonb_build_object('files', '[]'::jsonb);
+    end if;
+
+    with duplicates as (
+      select pa_path, md_json, md_ingested
+      from paths
+      inner join metadata
+        on md_hash = pa_hash and md_type = 'duplicate'
+      where public.path_hash(rtrim(gpath, '/')) = any(pa_parents)
+      and (cursor_path is null or pa_path > cursor_path)
+      order by pa_path
+      limit limit_val + 1
+    ),
+    page as (
+      select * from duplicates order by pa_path limit limit_val
+    )
+    select
+      jsonb_build_object(
+        'files',
+        coalesce(jsonb_agg(jsonb_build_object(
+          'file_path',
+          pa_path,
+          'canonical',
+          md_json->>'canonical',
+          'checksum',
+          md_json->>'checksum',
+          'dedup',
+          md_json->>'dedup',
+          'ingested',
+          to_char(md_ingested at time zone 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+        ) order by pa_path), '[]'::jsonb),
+        'last_key',
+        jsonb_build_array(max(pa_path))
+      ),
+      (select count(*) from duplicates) > count(*)
+    into result, more
+    from page;
+
+    if more then
+      result := result || jsonb_build_object('next_token',
+        translate(encode(convert_to(result->>'last_key', 'UTF8'), 'base64'), E'+/=\n', '-_'));
+    end if;
+    result := result - 'last_key';
+
+    perform mas_reset();
+    return result;
+  end
+$$;
+
+-- Summarize the files of a path per namespace: their number and size,
+-- their time range and their WGS84 extent.
+create or replace function mas_summary(
+  gpath      text,        -- file path to search
+  namespace  text[]       -- the variable names
+)
+  returns jsonb language plpgsql as $$
+  declare
+    result jsonb;
+    shard text;
+  begin
+    if gpath is null then
+      raise exception 'invalid search path';
+    end if;
+
+    perform mas_reset();
+    shard := mas_view(gpath);
+    if shard = '' then
+      return jsonb_build_object('namespaces', '[]'::jsonb, 'files', 0, 'bytes', 0);
+    end if;
+
+    result := (
+      with matched as (
+        select
+          po_hash,
+          po_name,
+          min(po_min_stamp) as min_stamp,
+          max(po_max_stamp) as max_stamp,
+          public.ST_Envelope(public.ST_Collect(public.ST_Transform(po_polygon, 4326))) as extent
+        from polygons
+        inner join paths
+          on pa_hash = po_hash
+        where public.path_hash(gpath) = any(pa_parents)
+        and (namespace is null or po_name = any(namespace))
+        group by po_hash, po_name
+      ),
+      sized as (
+        select matched.*, coalesce(fi_size, 0) as size
+        from matched
+        left join files
+          on fi_hash = po_hash
+      )
+      select jsonb_build_object(
+        'namespaces',
+        coalesce((
+          select jsonb_agg(jsonb_build_object(
+              'namespace', po_name,
+              'files', n,
+              'bytes', bytes,
+              'min_stamp', to_char(min_stamp at time zone 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS".000Z"'),
+              'max_stamp', to_char(max_stamp at time zone 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS".000Z"'),
+              'xmin', public.ST_XMin(extent),
+              'ymin', public.ST_YMin(extent),
+              'xmax', public.ST_XMax(extent),
+              'ymax', public.ST_YMax(extent)
+            ) order by po_name)
+          from (
+            select
+              po_name,
+              count(*) as n,
+              sum(size) as bytes,
+              min(min_stamp) as min_stamp,
+              max(max_stamp) as max_stamp,
+              public.ST_Envelope(public.ST_Collect(extent)) as extent
+            from sized
+            group by po_name
+          ) ns
+        ), '[]'::jsonb),
+        'files',
+        (select count(distinct po_hash) from sized),
+        'bytes',
+        (select coalesce(sum(size), 0) from (select distinct po_hash, size from sized) f)
+      )
+    );
+
+    perform mas_reset();
+    return result;
+
+    end
+$$;
+
 -- Find geospatial and temporal extents 
 
 create or replace function mas_spatial_temporal_extents(
@@ -867,7 +1576,8 @@ create or replace function mas_generate_layers (
           array_fill(t1.ns, ARRAY[1])
           ) || case when t2.axis is not null then
                 jsonb_build_object('axes', t2.axis)
-              else '{}'::jsonb end as layer
+              else '{}'::jsonb end
+            || coalesce(t4.band, '{}'::jsonb) as layer
           from (
             select jsonb_array_elements(namespaces->'namespaces') as ns
           ) t1
@@ -876,6 +1586,28 @@ create or replace function mas_generate_layers (
             from mas_list_namespace_axes(gpath, namespaces) ax(ns jsonb, axis jsonb[])
             where ax.ns = t1.ns
           ) t2 on true
+          left join lateral (
+            select jsonb_strip_nulls(jsonb_build_object(
+              'standard_name', geo->'standard_name',
+              'units', geo->'units',
+              'scale_factor', geo->'scale_factor',
+              'add_offset', geo->'add_offset',
+              'valid_range', geo->'valid_range'
+            )) as band
+            from polygons po
+            inner join paths pa
+              on po.po_hash = pa.pa_hash
+            inner join metadata md
+              on md.md_hash = po.po_hash
+              and md.md_type = 'gdal'
+            cross join lateral jsonb_array_elements(md.md_json->'geo_metadata') geo
+            where po.po_name = t1.ns #>> '{}'
+            and public.path_hash(gpath) = any(pa.pa_parents)
+            and regexp_replace(trim(geo->>'namespace'), '[^a-zA-Z0-9_]', '_', 'g') = po.po_name
+            and geo ?| array['standard_name', 'units', 'scale_factor', 'add_offset', 'valid_range']
+            order by geo ? 'standard_name' desc
+            limit 1
+          ) t4 on true
         ) t3
       ), '{}'::jsonb[])
     );
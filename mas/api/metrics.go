@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/metrics/prom"
+)
+
+type masMetrics struct {
+	registry      *prom.Registry
+	http          *prom.HTTPMetrics
+	cache         *prom.CacheMetrics
+	queries       *prom.CounterVec
+	queryDuration *prom.HistogramVec
+	responseBytes *prom.CounterVec
+	rateLimited   *prom.CounterVec
+	webhooks      *prom.CounterVec
+	dbConns       *prom.GaugeVec
+	dbMaxConns    *prom.Gauge
+	dbWaits       *prom.Gauge
+	dbWaitTime    *prom.Gauge
+}
+
+func newMASMetrics(db *sql.DB) *masMetrics {
+	m := &masMetrics{
+		registry:      prom.NewRegistry(),
+		http:          prom.NewHTTPMetrics("mas"),
+		cache:         prom.NewCacheMetrics("mas"),
+		queries:       prom.NewCounterVec("gsky_mas_queries_total", "Number of MAS queries.", "operation", "status"),
+		queryDuration: prom.NewHistogramVec("gsky_mas_query_duration_seconds", "MAS query latency in seconds.", nil, "operation"),
+		responseBytes: prom.NewCounterVec("gsky_mas_response_bytes_total", "Bytes of the JSON responses of the MAS queries before compression.", "operation"),
+		rateLimited:   prom.NewCounterVec("gsky_mas_rate_limited_total", "Number of MAS requests refused by the rate limit.", "client_type"),
+		webhooks:      prom.NewCounterVec("gsky_mas_webhooks_total", "Number of MAS webhook events delivered, failed or dropped.", "event", "status"),
+		dbConns:       prom.NewGaugeVec("gsky_mas_db_connections", "Number of database connections.", "state"),
+	}
+	dbWaitsVec, dbWaits := prom.NewGauge("gsky_mas_db_wait_count", "Number of connections waited for since the start.")
+	dbWaitTimeVec, dbWaitTime := prom.NewGauge("gsky_mas_db_wait_seconds", "Time waited for connections since the start in seconds.")
+	dbMaxConnsVec, dbMaxConns := prom.NewGauge("gsky_mas_db_max_connections", "Maximum number of open database connections.")
+	m.dbWaits = dbWaits
+	m.dbWaitTime = dbWaitTime
+	m.dbMaxConns = dbMaxConns
+
+	m.registry.MustRegister(m.http.Collectors()...)
+	m.registry.MustRegister(m.cache.Collectors()...)
+	m.registry.MustRegister(m.queries, m.queryDuration, m.responseBytes, m.rateLimited, m.webhooks, m.dbConns, dbWaitsVec, dbWaitTimeVec, dbMaxConnsVec)
+	prom.RegisterProcessMetrics(m.registry, "mas", "")
+	m.registry.OnScrape(func() {
+		stats := db.Stats()
+		m.dbConns.With("in_use").Set(float64(stats.InUse))
+		m.dbConns.With("idle").Set(float64(stats.Idle))
+		m.dbWaits.Set(float64(stats.WaitCount))
+		m.dbWaitTime.Set(stats.WaitDuration.Seconds())
+		m.dbMaxConns.Set(float64(stats.MaxOpenConnections))
+	})
+	return m
+}
+
+// observeQuery counts a query of status ok, error, timeout or
+// unavailable, and the bytes of its response.
+func (m *masMetrics) observeQuery(operation string, t0 time.Time, status string, bytes int) {
+	if m == nil {
+		return
+	}
+	m.queries.With(operation, status).Inc()
+	m.queryDuration.With(operation).Observe(time.Since(t0).Seconds())
+	if bytes > 0 {
+		m.responseBytes.With(operation).Add(float64(bytes))
+	}
+}
+
+// observeRateLimited counts a request refused by the rate limit by the
+// type of its client, key, token or ip.
+func (m *masMetrics) observeRateLimited(client string) {
+	if m == nil {
+		return
+	}
+	m.rateLimited.With(strings.SplitN(client, ":", 2)[0]).Inc()
+}
+
+// observeWebhook counts a webhook event of status delivered, failed
+// after its attempts or dropped from a full queue.
+func (m *masMetrics) observeWebhook(event, status string) {
+	if m == nil {
+		return
+	}
+	m.webhooks.With(event, status).Inc()
+}
+
+func (m *masMetrics) observeCache(name string, hit bool) {
+	if m == nil {
+		return
+	}
+	m.cache.Observe(name, hit)
+}
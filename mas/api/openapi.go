@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/nci/gsky/tokens"
+)
+
+// operationSummaries are the summaries of the operations in the OpenAPI
+// description.
+var operationSummaries = map[string]string{
+	"intersects":       "Files of the collection intersecting a geometry within a time range.",
+	"batch_intersects": "Several intersects queries of the collection at once.",
+	"timestamps":       "Timestamps of the collection, or their counts per bucket with group_by.",
+	"files":            "Files of the collection, with their timestamps and polygons.",
+	"crawled":          "Files directly under the path, or recursively, with their size and mtime when crawled, for the incremental crawls and the deleted files.",
+	"quarantined":      "Files under the path whose extraction failed when crawled, with the error and the time of the failure.",
+	"duplicates":       "Files under the path of the same content as another file of the shard, with their canonical file, linked to it or flagged.",
+	"summary":          "Number, size, time range and extent of the files per namespace.",
+	"extents":          "Spatial and temporal extents of the collection.",
+	"list_root_gpath":  "Root paths of the collections.",
+	"list_sub_gpath":   "Paths under the collection.",
+	"generate_layers":  "OWS layers of the collection.",
+	"put_ows_cache":    "Stores an entry of the OWS cache.",
+	"get_ows_cache":    "Reads an entry of the OWS cache.",
+}
+
+// paramSchema returns the OpenAPI schema of a parameter.
+func paramSchema(p param) map[string]interface{} {
+	switch p.kind {
+	case timestampKind:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case integerKind:
+		return map[string]interface{}{"type": "integer", "minimum": p.minimum}
+	case numberKind:
+		return map[string]interface{}{"type": "number"}
+	case listKind:
+		return map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}}
+	case enumKind:
+		return map[string]interface{}{"type": "string", "enum": p.values}
+	case flagKind:
+		return map[string]interface{}{"type": "boolean"}
+	case jsonKind:
+		return map[string]interface{}{"type": "string", "format": "json"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// openAPIDocument returns the OpenAPI 3 description of the operations
+// of masParams and operationParams. The operations being selected by a
+// query parameter, their paths are written /{gpath}?operation.
+func openAPIDocument() map[string]interface{} {
+	errorSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"error":     map[string]string{"type": "string"},
+			"parameter": map[string]string{"type": "string"},
+			"reason":    map[string]string{"type": "string"},
+		},
+	}
+	jsonResponse := func(description string, schema interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+		}
+	}
+	// The responses are shared by the operations.
+	sharedResponses := map[string]interface{}{
+		"200": jsonResponse("The result of the operation.", map[string]string{"type": "object"}),
+		"304": map[string]string{"description": "The response of the If-None-Match ETag is unchanged."},
+		"400": jsonResponse("An invalid parameter.", errorSchema),
+		"401": jsonResponse("A missing or invalid API key or token.", errorSchema),
+		"429": jsonResponse("The rate limit of the client is exceeded.", errorSchema),
+		"503": jsonResponse("The database is unavailable.", errorSchema),
+		"504": jsonResponse("The query timed out.", errorSchema),
+	}
+	responses := make(map[string]interface{}, len(sharedResponses))
+	for status := range sharedResponses {
+		responses[status] = map[string]string{"$ref": "#/components/responses/" + status}
+	}
+
+	operations := make([]string, 0, len(operationParams))
+	for operation := range operationParams {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	paths := make(map[string]interface{}, len(operations))
+	for _, operation := range operations {
+		parameters := []interface{}{
+			map[string]interface{}{
+				"name": "gpath", "in": "path", "required": true,
+				"description": "Path of the collection, e.g. g/data/chirps/daily.",
+				"schema":      map[string]string{"type": "string"},
+			},
+			map[string]interface{}{
+				"name": operation, "in": "query", "required": true, "allowEmptyValue": true,
+				"description": "Selects the operation.",
+				"schema":      map[string]string{"type": "string"},
+			},
+		}
+		properties := map[string]interface{}{operation: map[string]string{"type": "string"}}
+		for _, name := range append(operationParams[operation], tokens.APIKeyParam) {
+			p := masParams[name]
+			parameter := map[string]interface{}{
+				"name": name, "in": "query", "description": p.description, "schema": paramSchema(p),
+			}
+			if p.kind == listKind {
+				parameter["style"], parameter["explode"] = "form", false
+			}
+			if p.kind == flagKind {
+				parameter["allowEmptyValue"] = true
+			}
+			parameters = append(parameters, parameter)
+			properties[name] = map[string]interface{}{"description": p.description}
+		}
+		body := map[string]interface{}{"type": "object", "properties": properties}
+
+		paths["/{gpath}?"+operation] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": operation,
+				"summary":     operationSummaries[operation],
+				"parameters":  parameters,
+				"responses":   responses,
+			},
+			"post": map[string]interface{}{
+				"operationId": operation + "_post",
+				"summary":     operationSummaries[operation],
+				"parameters":  parameters[:1],
+				"requestBody": map[string]interface{}{
+					"description": "The query parameters as a form or a JSON object.",
+					"content": map[string]interface{}{
+						"application/x-www-form-urlencoded": map[string]interface{}{"schema": body},
+						"application/json":                  map[string]interface{}{"schema": body},
+					},
+				},
+				"responses": responses,
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "GSKY MAS",
+			"version": "1",
+			"description": "Metadata Attribute Storage API of the GSKY collections. " +
+				"The operations are selected by a query parameter, e.g. /g/data/chirps/daily?timestamps.",
+		},
+		"servers": []interface{}{
+			map[string]string{"url": "/v1", "description": "Responses of the JSON of the queries, as the unprefixed routes."},
+			map[string]string{"url": "/v2", "description": "Responses of an envelope of their data and of their page, if any."},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"responses": sharedResponses,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]string{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]string{"type": "apiKey", "in": "header", "name": tokens.APIKeyHeader},
+			},
+		},
+	}
+}
+
+var openAPIJSON, _ = json.Marshal(openAPIDocument())
+
+// openAPIHandler serves the OpenAPI description of MAS.
+func openAPIHandler(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(openAPIJSON)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/tokens"
+)
+
+// paramKind is the type of the value of a parameter.
+type paramKind int
+
+const (
+	stringKind paramKind = iota
+	timestampKind
+	integerKind
+	numberKind
+	listKind
+	enumKind
+	jsonKind
+	flagKind
+)
+
+// param describes a query parameter, validated before the query and
+// documented in the OpenAPI description.
+type param struct {
+	kind        paramKind
+	description string
+	minimum     int      // integerKind only
+	values      []string // enumKind only
+}
+
+// timestampLayouts are the layouts of the timestamp parameters, those
+// without an offset being in UTC.
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// masParams are the parameters of the operations.
+var masParams = map[string]param{
+	"time":        {kind: timestampKind, description: "Start of the time range."},
+	"until":       {kind: timestampKind, description: "End of the time range."},
+	"namespace":   {kind: listKind, description: "Comma separated namespaces, e.g. the variables of netCDF files."},
+	"srs":         {kind: stringKind, description: "SRS of wkt, e.g. EPSG:4326, the default of geojson."},
+	"wkt":         {kind: stringKind, description: "WKT of the geometry searched."},
+	"geojson":     {kind: jsonKind, description: "GeoJSON geometry or feature searched, exclusive of wkt."},
+	"nseg":        {kind: integerKind, description: "Number of segments of the edges of the geometry when reprojected."},
+	"metadata":    {kind: stringKind, description: "Raw metadata of the crawlers included with each file, e.g. gdal."},
+	"identitytol": {kind: numberKind, description: "Distance under which the points of the polygons are merged."},
+	"dptol":       {kind: numberKind, description: "Tolerance of the Douglas-Peucker simplification of the polygons."},
+	"limit":       {kind: integerKind, minimum: 1, description: "Maximum number of results."},
+	"next_token":  {kind: stringKind, description: "Token of a truncated response or a page, resuming the query after its results."},
+	"filter":      {kind: stringKind, description: "CQL2 text expression of the metadata of the files, e.g. sensor = 'MODIS' AND cloud_cover < 20."},
+	"page_size":   {kind: integerKind, minimum: 1, description: "Maximum number of datasets of a page."},
+	"offset":      {kind: integerKind, description: "Number of results skipped."},
+	"token":       {kind: stringKind, description: "Token of the previous response, answered without timestamps if unchanged."},
+	"group_by":    {kind: enumKind, values: []string{"day", "month", "year"}, description: "Bucket of the timestamps counted instead of listed."},
+	"queries":     {kind: jsonKind, description: "JSON array of intersects queries, their missing parameters taken from the request."},
+	"stream":      {kind: flagKind, description: "Stream the datasets as newline delimited JSON."},
+	"recursive":   {kind: flagKind, description: "List the files of the subdirectories too."},
+	"query":       {kind: stringKind, description: "Key of the OWS cache entry."},
+	"value":       {kind: jsonKind, description: "JSON value of the OWS cache entry."},
+
+	tokens.APIKeyParam: {kind: stringKind, description: "API key or token, preferably sent in the Authorization header."},
+}
+
+// operationParams are the parameters accepted by each operation, besides
+// api_key.
+var operationParams = map[string][]string{
+	"intersects":       {"srs", "wkt", "geojson", "nseg", "time", "until", "namespace", "metadata", "identitytol", "dptol", "limit", "next_token", "page_size", "stream"},
+	"batch_intersects": {"queries", "srs", "wkt", "geojson", "nseg", "time", "until", "namespace", "metadata", "identitytol", "dptol", "limit"},
+	"timestamps":       {"time", "until", "namespace", "token", "offset", "limit", "group_by"},
+	"files":            {"time", "until", "namespace", "offset", "limit", "next_token", "filter"},
+	"crawled":          {"limit", "next_token", "recursive"},
+	"quarantined":      {"limit", "next_token"},
+	"duplicates":       {"limit", "next_token"},
+	"summary":          {"namespace"},
+	"extents":          {"namespace"},
+	"list_root_gpath":  nil,
+	"list_sub_gpath":   nil,
+	"generate_layers":  nil,
+	"put_ows_cache":    {"query", "value"},
+	"get_ows_cache":    {"query"},
+}
+
+// validateParams checks that the parameters of a request are those of
+// its operation and that their values have the types expected, so that
+// the invalid requests are told which parameter is wrong rather than
+// answered with the error of Postgres.
+func validateParams(operation string, query url.Values) *paramError {
+	accepted := map[string]bool{operation: true, tokens.APIKeyParam: true}
+	for _, name := range operationParams[operation] {
+		accepted[name] = true
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !accepted[name] {
+			return &paramError{name, fmt.Sprintf("unknown parameter of ?%s", operation)}
+		}
+		if name == operation {
+			continue
+		}
+		if len(query[name]) > 1 {
+			return &paramError{name, "expected a single value"}
+		}
+		if err := validateParam(name, query.Get(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateParam checks the value of a parameter, empty values standing
+// for the missing parameters.
+func validateParam(name, value string) *paramError {
+	p := masParams[name]
+	if len(value) == 0 {
+		return nil
+	}
+	switch p.kind {
+	case timestampKind:
+		for _, layout := range timestampLayouts {
+			if _, err := time.Parse(layout, value); err == nil {
+				return nil
+			}
+		}
+		reason := "expected an RFC 3339 timestamp, e.g. 2020-01-31T00:00:00Z"
+		if strings.Contains(value, " ") {
+			reason += ", the + of the offsets encoded as %2B"
+		}
+		return &paramError{name, reason}
+	case integerKind:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return &paramError{name, "expected an integer"}
+		}
+		if n < p.minimum {
+			return &paramError{name, fmt.Sprintf("expected an integer of at least %d", p.minimum)}
+		}
+	case numberKind:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return &paramError{name, "expected a number"}
+		}
+	case listKind:
+		for _, item := range strings.Split(value, ",") {
+			if len(item) == 0 {
+				return &paramError{name, "expected a comma separated list without empty items"}
+			}
+		}
+	case enumKind:
+		for _, v := range p.values {
+			if value == v {
+				return nil
+			}
+		}
+		return &paramError{name, "expected " + strings.Join(p.values, ", ")}
+	case jsonKind:
+		if !json.Valid([]byte(value)) {
+			return &paramError{name, "expected JSON"}
+		}
+	}
+	return nil
+}
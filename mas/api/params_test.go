@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestValidateParams(t *testing.T) {
+	tests := []struct {
+		operation string
+		query     string
+		parameter string
+		reason    string
+	}{
+		{operation: "intersects", query: "intersects&srs=EPSG:4326&wkt=POINT(36 -1)&time=2020-01-31T00:00:00Z&until=2020-02-01&limit=10&nseg=2&dptol=0.5&api_key=k"},
+		{operation: "timestamps", query: "timestamps&namespace=precip,tmax&group_by=month&time="},
+		{operation: "intersects", query: "intersects&polygon=1", parameter: "polygon", reason: "unknown parameter of ?intersects"},
+		{operation: "timestamps", query: "timestamps&wkt=POINT(0 0)", parameter: "wkt", reason: "unknown parameter of ?timestamps"},
+		{operation: "intersects", query: "intersects&limit=1&limit=2", parameter: "limit", reason: "expected a single value"},
+		{operation: "intersects", query: "intersects&time=2020-01-31T00:00:00+03:00", parameter: "time", reason: "expected an RFC 3339 timestamp, e.g. 2020-01-31T00:00:00Z, the + of the offsets encoded as %2B"},
+		{operation: "files", query: "files&time=yesterday", parameter: "time", reason: "expected an RFC 3339 timestamp, e.g. 2020-01-31T00:00:00Z"},
+		{operation: "intersects", query: "intersects&limit=ten", parameter: "limit", reason: "expected an integer"},
+		{operation: "intersects", query: "intersects&limit=0", parameter: "limit", reason: "expected an integer of at least 1"},
+		{operation: "intersects", query: "intersects&dptol=high", parameter: "dptol", reason: "expected a number"},
+		{operation: "timestamps", query: "timestamps&namespace=precip,,tmax", parameter: "namespace", reason: "expected a comma separated list without empty items"},
+		{operation: "timestamps", query: "timestamps&group_by=week", parameter: "group_by", reason: "expected day, month, year"},
+		{operation: "intersects", query: "intersects&geojson={\"type\":", parameter: "geojson", reason: "expected JSON"},
+	}
+
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		perr := validateParams(test.operation, query)
+		if len(test.parameter) == 0 {
+			if perr != nil {
+				t.Errorf("%s: unexpected error: %v", test.query, perr)
+			}
+			continue
+		}
+		if perr == nil {
+			t.Errorf("%s: expected an error of %s", test.query, test.parameter)
+			continue
+		}
+		if perr.Parameter != test.parameter || perr.Reason != test.reason {
+			t.Errorf("%s: expected %s: %s, got %s: %s", test.query, test.parameter, test.reason, perr.Parameter, perr.Reason)
+		}
+	}
+}
+
+func TestHTTPParamError(t *testing.T) {
+	w := httptest.NewRecorder()
+	httpParamError(w, &paramError{"limit", "expected an integer"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "invalid limit: expected an integer" || body["parameter"] != "limit" || body["reason"] != "expected an integer" {
+		t.Errorf("unexpected error body: %v", body)
+	}
+}
+
+func TestOperationParams(t *testing.T) {
+	for _, op := range masOperations {
+		names, found := operationParams[op]
+		if !found {
+			t.Errorf("operation %s has no parameters defined", op)
+		}
+		for _, name := range names {
+			if _, found := masParams[name]; !found {
+				t.Errorf("parameter %s of %s is not described", name, op)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nci/gsky/logging"
+)
+
+// bucket is the token bucket of a client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the requests of each client, identified by its API
+// key, its token or its address, so that a single client cannot take
+// all the database connections of -limit.
+type rateLimiter struct {
+	perSecond float64
+	burst     float64
+	exempt    []*net.IPNet
+	next      http.Handler
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// newRateLimiter returns the limit of perMinute requests of each client
+// outside the exempt networks, up to burst requests at once.
+func newRateLimiter(perMinute float64, burst int, exemptNetworks string, next http.Handler) (*rateLimiter, error) {
+	if burst <= 0 {
+		burst = int(math.Ceil(perMinute))
+	}
+	exempt, err := parseNetworks(exemptNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit exempt network: %v", err)
+	}
+	return &rateLimiter{
+		perSecond: perMinute / 60,
+		burst:     float64(burst),
+		exempt:    exempt,
+		next:      next,
+		buckets:   make(map[string]*bucket),
+	}, nil
+}
+
+// allow reports whether a request of the client is within the limit.
+// The wait before the next request is allowed is returned otherwise.
+func (rl *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// The buckets refilled since are the same as new ones.
+	if now.Sub(rl.lastSweep) > time.Minute {
+		for c, b := range rl.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rl.perSecond >= rl.burst {
+				delete(rl.buckets, c)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	b, found := rl.buckets[client]
+	if !found {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (rl *rateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := clientOf(r)
+	if strings.HasPrefix(client, "ip:") && (fromSocket(r) || containsAddr(rl.exempt, r.RemoteAddr)) {
+		rl.next.ServeHTTP(w, r)
+		return
+	}
+	if ok, wait := rl.allow(client, time.Now()); !ok {
+		logging.FromContext(r.Context()).Warnf("Rate limit exceeded by %s", client)
+		metrics.observeRateLimited(client)
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		httpJSONError(w, fmt.Errorf("rate limit exceeded, retry after %d seconds", retryAfter), http.StatusTooManyRequests)
+		return
+	}
+	rl.next.ServeHTTP(w, r)
+}
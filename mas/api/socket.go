@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+type socketConnKey struct{}
+
+// listenSocket listens on the unix socket of path with the permissions
+// of mode, replacing the socket left by a MAS that did not stop cleanly.
+func listenSocket(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s already in use", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// socketConnContext marks the requests of the connections of the unix
+// socket, whose clients are local, those allowed by the permissions of
+// the socket.
+func socketConnContext(ctx context.Context, c net.Conn) context.Context {
+	if _, ok := c.(*net.UnixConn); ok {
+		return context.WithValue(ctx, socketConnKey{}, true)
+	}
+	return ctx
+}
+
+// serveListener returns the serving of srv on l, in plain HTTP, ended
+// by the shutdown of srv.
+func serveListener(srv *http.Server, l net.Listener) func() error {
+	return func() error {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	}
+}
+
+// fromSocket reports whether a request came through the unix socket.
+func fromSocket(r *http.Request) bool {
+	local, _ := r.Context().Value(socketConnKey{}).(bool)
+	return local
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// statements are the prepared statements of the queries of MAS by their
+// SQL, prepared on their first use and then again by database/sql on
+// each connection of the pool they run on, so that Postgres plans each
+// query once per connection rather than once per request.
+type statements struct {
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+var prepared = &statements{stmts: make(map[string]*sql.Stmt)}
+
+func (s *statements) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	s.mu.RLock()
+	stmt, found := s.stmts[query]
+	s.mu.RUnlock()
+	if found {
+		return stmt, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stmt, found := s.stmts[query]; found {
+		return stmt, nil
+	}
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.stmts[query] = stmt
+	return stmt, nil
+}
+
+// queryRow runs a query of MAS with its prepared statement, or without
+// if -prepare_statements is disabled or the statement failed to prepare,
+// the query then reporting the error.
+func queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if *prepareStatements {
+		if stmt, err := prepared.prepare(ctx, query); err == nil {
+			return stmt.QueryRowContext(ctx, args...)
+		}
+	}
+	return db.QueryRowContext(ctx, query, args...)
+}
+
+// queryRows is queryRow for the queries of several rows.
+func queryRows(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if *prepareStatements {
+		if stmt, err := prepared.prepare(ctx, query); err == nil {
+			return stmt.QueryContext(ctx, args...)
+		}
+	}
+	return db.QueryContext(ctx, query, args...)
+}
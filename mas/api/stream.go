@@ -0,0 +1,138 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/tracing"
+)
+
+// ndjsonMediaType is the media type of the streamed responses, one JSON
+// object per line.
+const ndjsonMediaType = "application/x-ndjson"
+
+// streamFlushRows is the number of rows written between flushes.
+const streamFlushRows = 64
+
+// wantsStream reports whether a request asks for a newline delimited
+// JSON response, with the stream parameter or an Accept header.
+func wantsStream(request *http.Request) bool {
+	if _, ok := request.Form["stream"]; ok {
+		return true
+	}
+	for _, v := range request.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(v, ",") {
+			if mediaType, _, _ := mime.ParseMediaType(mediaRange); mediaType == ndjsonMediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// streamIntersects writes the datasets of an intersects query of args,
+// the arguments of mas_intersects, as newline delimited JSON while the
+// rows arrive, so that MAS does not hold the whole response. The
+// responses streamed are not cached. An error after the first dataset
+// is written as the last line, {"error": "..."}.
+func streamIntersects(ctx context.Context, response http.ResponseWriter, request *http.Request, t0 time.Time, args []interface{}) {
+	span := tracing.SpanFromContext(request.Context())
+	info := accessInfoFrom(request.Context())
+
+	rows, err := queryRows(ctx,
+		`select mas_intersects_rows(
+			nullif($1,'')::text,
+			nullif($2,'')::text,
+			nullif($3,'')::text,
+			nullif($4,'')::integer,
+			nullif($5,'')::timestamptz,
+			nullif($6,'')::timestamptz,
+			string_to_array(nullif($7,''), ','),
+			nullif($8,'')::text,
+			nullif($9,'')::float8,
+			nullif($10,'')::float,
+			nullif($11,'')::int
+		) as json`,
+		args...,
+	)
+
+	var w io.Writer
+	var zw *gzip.Writer
+	flusher, _ := response.(http.Flusher)
+	bytes, n := 0, 0
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var dataset []byte
+			if err = rows.Scan(&dataset); err != nil {
+				break
+			}
+			if w == nil {
+				response.Header().Set("Content-Type", ndjsonMediaType)
+				w = response
+				if acceptsGzip(request) {
+					response.Header().Set("Content-Encoding", "gzip")
+					zw = gzip.NewWriter(response)
+					defer zw.Close()
+					w = zw
+				}
+			}
+			if _, err = w.Write(append(dataset, '\n')); err != nil {
+				break
+			}
+			bytes += len(dataset) + 1
+			if n++; n%streamFlushRows == 0 {
+				if zw != nil {
+					zw.Flush()
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+
+	if err == nil {
+		if w == nil {
+			response.Header().Set("Content-Type", ndjsonMediaType)
+		}
+		metrics.observeQuery("intersects", t0, "ok", bytes)
+		return
+	}
+
+	queryStatus := "error"
+	timedOut := ctx.Err() == context.DeadlineExceeded
+	info.errorClass = errorClass(err, timedOut)
+	if timedOut {
+		err = fmt.Errorf("query timed out after %v", time.Since(t0).Round(time.Second))
+		queryStatus = "timeout"
+	} else if isConnError(err) {
+		dbStatus.failed()
+		queryStatus = "unavailable"
+	}
+	metrics.observeQuery("intersects", t0, queryStatus, bytes)
+	span.SetError(err)
+	logging.FromContext(request.Context()).Warnf("query failed after %d datasets: %v", n, err)
+	if w == nil {
+		switch {
+		case timedOut:
+			httpJSONError(response, err, http.StatusGatewayTimeout)
+		case queryStatus == "unavailable":
+			httpDBUnavailable(response, time.Duration(*dbCheckInterval)*time.Second)
+		default:
+			httpJSONError(response, err, 400)
+		}
+		return
+	}
+	fmt.Fprintf(w, "{\"error\": %q}\n", err.Error())
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/tokens"
+)
+
+// tokenAuth restricts the MAS API to the clients of the trusted
+// networks, typically the OWS and the crawlers, to the requests
+// carrying an API token scoped to the mas operation and to the gpath
+// queried, and to those carrying one of the static API keys.
+type tokenAuth struct {
+	store   *tokens.Store
+	keys    map[string]string
+	trusted []*net.IPNet
+	next    http.Handler
+}
+
+// newTokenAuth returns the authentication of the tokens of store, if
+// not nil, and of the API keys, the names of the keys by the SHA-256
+// digest of the keys.
+func newTokenAuth(store *tokens.Store, keys map[string]string, trustedNetworks string, next http.Handler) (*tokenAuth, error) {
+	trusted, err := parseNetworks(trustedNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted network %v", err)
+	}
+	return &tokenAuth{store: store, keys: keys, trusted: trusted, next: next}, nil
+}
+
+// parseNetworks parses a comma separated list of CIDRs.
+func parseNetworks(cidrs string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if len(cidr) == 0 {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsAddr reports whether the host of remoteAddr is within one of
+// the networks.
+func containsAddr(networks []*net.IPNet, remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ta *tokenAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := tokens.FromRequest(r)
+	if len(key) == 0 {
+		if fromSocket(r) || containsAddr(ta.trusted, r.RemoteAddr) {
+			ta.next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gsky mas"`)
+		httpJSONError(w, fmt.Errorf("API token required"), http.StatusUnauthorized)
+		return
+	}
+
+	if ta.store == nil || !tokens.IsToken(key) {
+		name, found := ta.keys[keyDigest(key)]
+		if !found {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gsky mas", error="invalid_token"`)
+			httpJSONError(w, fmt.Errorf("invalid API key"), http.StatusUnauthorized)
+			return
+		}
+		ta.next.ServeHTTP(w, withClient(r, "key:"+name))
+		return
+	}
+
+	token, err := ta.store.Validate(key)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gsky mas", error="invalid_token"`)
+		httpJSONError(w, err, http.StatusUnauthorized)
+		return
+	}
+	r = withClient(r, "token:"+token.ID)
+	if !token.PermitsGPath(r.URL.Path) {
+		logging.FromContext(r.Context()).Warnf("token %s denied access to %s", token.ID, r.URL.Path)
+		httpJSONError(w, fmt.Errorf("%s is outside the scope of token %s", r.URL.Path, token.ID), http.StatusForbidden)
+		return
+	}
+	if ok, wait := ta.store.Allow(token); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		httpJSONError(w, fmt.Errorf("rate limit of token %s exceeded", token.ID), http.StatusTooManyRequests)
+		return
+	}
+	ta.next.ServeHTTP(w, r)
+}
+
+type clientKey struct{}
+
+// withClient returns r carrying the identity of its client, also
+// written in its access log.
+func withClient(r *http.Request, client string) *http.Request {
+	accessInfoFrom(r.Context()).client = client
+	return r.WithContext(context.WithValue(r.Context(), clientKey{}, client))
+}
+
+// clientOf returns the identity of the client of a request, key: or
+// token: followed by the name of its API key or the ID of its token, or
+// ip: followed by its address, ip:unix for the unix socket.
+func clientOf(r *http.Request) string {
+	if client, ok := r.Context().Value(clientKey{}).(string); ok {
+		return client
+	}
+	if fromSocket(r) {
+		return "ip:unix"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func keyDigest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadAPIKeys returns the names of the static API keys by the SHA-256
+// digest of the keys, read from a file of name:key lines, the lines
+// starting with # being comments, and from a comma separated list of
+// name:key pairs.
+func loadAPIKeys(file, list string) (map[string]string, error) {
+	var pairs []string
+	if len(file) > 0 {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pairs = strings.Split(string(b), "\n")
+	}
+	pairs = append(pairs, strings.Split(list, ",")...)
+
+	keys := make(map[string]string)
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 || strings.HasPrefix(pair, "#") {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		name, key := strings.TrimSpace(parts[0]), ""
+		if len(parts) == 2 {
+			key = strings.TrimSpace(parts[1])
+		}
+		if len(name) == 0 || len(key) < 16 {
+			return nil, fmt.Errorf("invalid API key of %q: expected name:key with a key of at least 16 characters", name)
+		}
+		if tokens.IsToken(key) {
+			return nil, fmt.Errorf("invalid API key of %s: the keys starting with %s are tokens", name, tokens.Prefix)
+		}
+		keys[keyDigest(key)] = name
+	}
+	return keys, nil
+}
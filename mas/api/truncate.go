@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+)
+
+// truncateOverhead is the room left in a truncated response for its
+// truncated and next_token fields and its other fields than the
+// datasets.
+const truncateOverhead = 256
+
+// encodeCursor returns the next_token of a page of results ending with
+// the result of the keys, opaque to the clients.
+func encodeCursor(keys ...string) string {
+	b, _ := json.Marshal(keys)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the n keys of the last result before the page of
+// the next_token parameter, nil without token. The pages following the
+// last result rather than an offset, the results added or removed
+// before it do not shift them.
+func decodeCursor(token string, n int) ([]string, *paramError) {
+	if len(token) == 0 {
+		return nil, nil
+	}
+	var keys []string
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(b, &keys)
+	}
+	if err != nil || len(keys) != n {
+		return nil, &paramError{"next_token", "invalid token"}
+	}
+	return keys, nil
+}
+
+// truncateIntersects returns the datasets of an intersects response
+// after the dataset of the cursor, if any, at most pageSize of them if
+// not 0 and fitting in maxSize bytes if not 0, at least one, with
+// truncated and the next_token of the datasets left if any. The datasets
+// are sorted by name and namespace, the keys of the cursor.
+func truncateIntersects(payload []byte, after []string, pageSize, maxSize int) ([]byte, error) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, err
+	}
+	var raws []json.RawMessage
+	if gdal, found := result["gdal"]; found {
+		if err := json.Unmarshal(gdal, &raws); err != nil {
+			return nil, err
+		}
+	}
+
+	type dataset struct {
+		Name      string `json:"ds_name"`
+		Namespace string `json:"namespace"`
+		raw       json.RawMessage
+	}
+	datasets := make([]dataset, len(raws))
+	for i, raw := range raws {
+		if err := json.Unmarshal(raw, &datasets[i]); err != nil {
+			return nil, err
+		}
+		datasets[i].raw = raw
+	}
+	less := func(a, b dataset) bool {
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Namespace < b.Namespace
+	}
+	sort.SliceStable(datasets, func(i, j int) bool { return less(datasets[i], datasets[j]) })
+
+	start := 0
+	if after != nil {
+		cursor := dataset{Name: after[0], Namespace: after[1]}
+		start = sort.Search(len(datasets), func(i int) bool { return less(cursor, datasets[i]) })
+	}
+	page := []json.RawMessage{}
+	size := truncateOverhead
+	end := start
+	for ; end < len(datasets); end++ {
+		size += len(datasets[end].raw) + 1
+		if len(page) > 0 && (maxSize > 0 && size > maxSize || pageSize > 0 && len(page) >= pageSize) {
+			break
+		}
+		page = append(page, datasets[end].raw)
+	}
+
+	b, err := json.Marshal(page)
+	if err != nil {
+		return nil, err
+	}
+	result["gdal"] = b
+	if end < len(datasets) {
+		last := datasets[end-1]
+		token, _ := json.Marshal(encodeCursor(last.Name, last.Namespace))
+		result["truncated"] = json.RawMessage("true")
+		result["next_token"] = token
+	}
+	return json.Marshal(result)
+}
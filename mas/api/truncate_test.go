@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	token := encodeCursor("/g/data/chirps/2020.tif", "precip")
+	keys, perr := decodeCursor(token, 2)
+	if perr != nil {
+		t.Fatal(perr)
+	}
+	if len(keys) != 2 || keys[0] != "/g/data/chirps/2020.tif" || keys[1] != "precip" {
+		t.Errorf("expected the keys of the cursor, got %v", keys)
+	}
+
+	if keys, perr := decodeCursor("", 2); keys != nil || perr != nil {
+		t.Errorf("expected no keys without token, got %v: %v", keys, perr)
+	}
+	for _, token := range []string{"not a token", encodeCursor("one"), "e30"} {
+		if _, perr := decodeCursor(token, 2); perr == nil || perr.Parameter != "next_token" {
+			t.Errorf("%q: expected an invalid next_token, got %v", token, perr)
+		}
+	}
+}
+
+func TestTruncateIntersects(t *testing.T) {
+	var datasets []map[string]string
+	// in another order than that of the pages
+	for _, name := range []string{"c", "a", "b", "e", "d"} {
+		for _, ns := range []string{"tmax", "precip"} {
+			datasets = append(datasets, map[string]string{"ds_name": "/g/data/" + name + ".nc", "namespace": ns})
+		}
+	}
+	payload, _ := json.Marshal(map[string]interface{}{"error": "", "gdal": datasets})
+
+	for _, size := range []struct{ pageSize, maxSize int }{{3, 0}, {0, truncateOverhead + 100}, {1, 1}} {
+		name := fmt.Sprintf("page_size %d, max_size %d", size.pageSize, size.maxSize)
+		var seen []string
+		var after []string
+		for pages := 0; ; pages++ {
+			if pages > len(datasets) {
+				t.Fatalf("%s: expected at most %d pages", name, len(datasets))
+			}
+			b, err := truncateIntersects(payload, after, size.pageSize, size.maxSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var page struct {
+				GDAL []struct {
+					Name      string `json:"ds_name"`
+					Namespace string `json:"namespace"`
+				} `json:"gdal"`
+				Truncated bool   `json:"truncated"`
+				NextToken string `json:"next_token"`
+			}
+			if err := json.Unmarshal(b, &page); err != nil {
+				t.Fatal(err)
+			}
+			if len(page.GDAL) == 0 {
+				t.Fatalf("%s: expected at least one dataset per page", name)
+			}
+			if size.pageSize > 0 && len(page.GDAL) > size.pageSize {
+				t.Errorf("%s: expected at most %d datasets, got %d", name, size.pageSize, len(page.GDAL))
+			}
+			for _, ds := range page.GDAL {
+				seen = append(seen, ds.Name+" "+ds.Namespace)
+			}
+			if !page.Truncated {
+				if len(page.NextToken) > 0 {
+					t.Errorf("%s: expected no next_token on the last page", name)
+				}
+				break
+			}
+			var perr *paramError
+			if after, perr = decodeCursor(page.NextToken, 2); perr != nil {
+				t.Fatal(perr)
+			}
+		}
+
+		if len(seen) != len(datasets) {
+			t.Fatalf("%s: expected %d datasets, got %d: %v", name, len(datasets), len(seen), seen)
+		}
+		for i := 1; i < len(seen); i++ {
+			if seen[i-1] >= seen[i] {
+				t.Errorf("%s: expected the datasets sorted once each, got %v", name, seen)
+				break
+			}
+		}
+	}
+
+	b, err := truncateIntersects(payload, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]json.RawMessage
+	json.Unmarshal(b, &result)
+	if _, found := result["truncated"]; found {
+		t.Errorf("expected no truncation without limits")
+	}
+	if _, found := result["error"]; !found {
+		t.Errorf("expected the other fields to be kept")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// apiV1 is the version of the legacy, unprefixed, routes, whose
+	// responses are the JSON of the queries as is.
+	apiV1 = 1
+	// apiV2 wraps the responses in an envelope of their data and page.
+	apiV2 = 2
+)
+
+// apiVersionHeader is the header of the responses telling their version.
+const apiVersionHeader = "X-Mas-Api-Version"
+
+// pageFields are the fields of the responses describing their page,
+// moved to the page of the v2 envelopes.
+var pageFields = []string{"total", "offset", "next_offset", "next_token", "truncated"}
+
+type versionKey struct{}
+
+// apiVersion returns the API version of a request, v1 if not routed by
+// versionRouter.
+func apiVersion(r *http.Request) int {
+	if version, ok := r.Context().Value(versionKey{}).(int); ok {
+		return version
+	}
+	return apiV1
+}
+
+// splitVersion returns the version of the /v1 or /v2 prefix of a path
+// and the path without it, or v1 and the path as is without prefix.
+func splitVersion(p string) (int, string) {
+	for _, version := range []int{apiV1, apiV2} {
+		prefix := "/v" + strconv.Itoa(version)
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			rest := strings.TrimPrefix(p, prefix)
+			if len(rest) == 0 {
+				rest = "/"
+			}
+			return version, rest
+		}
+	}
+	return apiV1, p
+}
+
+// versionRouter strips the version prefix of the requests before serving
+// them with h, so that the operations see the gpaths of the legacy
+// routes. The gpaths starting with /v1 or /v2 are reached under a prefix,
+// e.g. /v1/v2/data.
+func versionRouter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, rest := splitVersion(r.URL.Path)
+		w.Header().Set(apiVersionHeader, strconv.Itoa(version))
+		if rest != r.URL.Path {
+			u := *r.URL
+			u.Path, u.RawPath = rest, ""
+			r = r.WithContext(context.WithValue(r.Context(), versionKey{}, version))
+			r.URL = &u
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// envelope returns the v2 response of a payload: its data, less the page
+// fields of an object, and the page of these fields if any.
+func envelope(payload []byte) ([]byte, error) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &result); err != nil {
+		// The arrays, e.g. of ?batch_intersects, have no page.
+		if !json.Valid(payload) {
+			return nil, err
+		}
+		return json.Marshal(map[string]json.RawMessage{"data": payload})
+	}
+	page := make(map[string]json.RawMessage)
+	for _, field := range pageFields {
+		if value, found := result[field]; found {
+			page[field] = value
+			delete(result, field)
+		}
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]json.RawMessage{"data": data}
+	if len(page) > 0 {
+		if env["page"], err = json.Marshal(page); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(env)
+}
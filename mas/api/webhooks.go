@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nci/gsky/logging"
+)
+
+const (
+	// webhookQueueSize bounds the events waiting for delivery to each
+	// URL, the later events being dropped while a URL is unreachable.
+	webhookQueueSize = 256
+	// webhookAttempts is the number of deliveries of an event to a URL
+	// before it is dropped.
+	webhookAttempts = 4
+)
+
+// webhookEvent is the body of the notifications: ows_cache once an entry
+// of the OWS cache of gpath is stored, of the query key, and timestamps
+// once new timestamps of a watched gpath appear.
+type webhookEvent struct {
+	Event      string   `json:"event"`
+	GPath      string   `json:"gpath"`
+	Query      string   `json:"query,omitempty"`
+	Timestamps []string `json:"timestamps,omitempty"`
+	Time       string   `json:"time"`
+}
+
+// webhookDelivery is an event queued for a URL.
+type webhookDelivery struct {
+	event string
+	body  []byte
+}
+
+// webhooks posts the events to their URLs, signed with the HMAC-SHA256
+// of the secret, and polls the timestamps of the watched gpaths.
+type webhooks struct {
+	secret []byte
+	client *http.Client
+	queues map[string]chan webhookDelivery
+
+	watch   []string
+	mu      sync.Mutex
+	latest  map[string]string // latest timestamp of each watched gpath
+	recheck chan struct{}
+}
+
+// newWebhooks returns the publisher of the comma separated URLs, nil if
+// there are none, watching the comma separated gpaths.
+func newWebhooks(urls, secret, watch string, timeout time.Duration) (*webhooks, error) {
+	w := &webhooks{
+		secret:  []byte(secret),
+		client:  &http.Client{Timeout: timeout},
+		queues:  make(map[string]chan webhookDelivery),
+		latest:  make(map[string]string),
+		recheck: make(chan struct{}, 1),
+	}
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); len(u) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("invalid webhook URL %s", u)
+		}
+		w.queues[u] = make(chan webhookDelivery, webhookQueueSize)
+	}
+	if len(w.queues) == 0 {
+		return nil, nil
+	}
+	if len(w.secret) == 0 {
+		return nil, fmt.Errorf("webhook secret required")
+	}
+	for _, gpath := range strings.Split(watch, ",") {
+		if gpath = strings.TrimSpace(gpath); len(gpath) > 0 {
+			w.watch = append(w.watch, gpath)
+		}
+	}
+	return w, nil
+}
+
+// start delivers the queued events until ctx is done, and polls the
+// watched gpaths every interval if not 0.
+func (w *webhooks) start(ctx context.Context, interval time.Duration) {
+	for u, queue := range w.queues {
+		go w.deliver(ctx, u, queue)
+	}
+	if interval > 0 && len(w.watch) > 0 {
+		go w.poll(ctx, interval)
+	}
+}
+
+// publish queues an event for all the URLs, without waiting for its
+// deliveries.
+func (w *webhooks) publish(ctx context.Context, event webhookEvent) {
+	if w == nil {
+		return
+	}
+	event.Time = time.Now().UTC().Format(time.RFC3339)
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	for u, queue := range w.queues {
+		select {
+		case queue <- webhookDelivery{event.Event, body}:
+		default:
+			metrics.observeWebhook(event.Event, "dropped")
+			logging.FromContext(ctx).Warnf("webhook queue of %s full, %s event of %s dropped", u, event.Event, event.GPath)
+		}
+	}
+}
+
+// checkTimestamps requests a poll of the watched gpaths before the next
+// interval, e.g. once a collection is invalidated after a crawl.
+func (w *webhooks) checkTimestamps() {
+	if w == nil {
+		return
+	}
+	select {
+	case w.recheck <- struct{}{}:
+	default:
+	}
+}
+
+// sign returns the signature of a body sent at timestamp, the
+// hex HMAC-SHA256 of the timestamp, a dot and the body.
+func (w *webhooks) sign(timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, w.secret)
+	m.Write([]byte(timestamp + "."))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+func (w *webhooks) deliver(ctx context.Context, u string, queue chan webhookDelivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-queue:
+			status := "failed"
+			delay := time.Second
+			for attempt := 1; attempt <= webhookAttempts; attempt++ {
+				err := w.post(ctx, u, d)
+				if err == nil {
+					status = "delivered"
+					break
+				}
+				log.Printf("webhook %s of %s event failed, attempt %d: %v", u, d.event, attempt, err)
+				if attempt == webhookAttempts {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				delay *= 2
+			}
+			metrics.observeWebhook(d.event, status)
+		}
+	}
+}
+
+func (w *webhooks) post(ctx context.Context, u string, d webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gsky-mas")
+	req.Header.Set("X-Gsky-Event", d.event)
+	req.Header.Set("X-Gsky-Timestamp", timestamp)
+	req.Header.Set("X-Gsky-Signature", w.sign(timestamp, d.body))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+func (w *webhooks) poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.pollTimestamps(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.recheck:
+		}
+	}
+}
+
+// pollTimestamps publishes the timestamps of the watched gpaths later
+// than their latest timestamp of the previous poll, the first poll of a
+// gpath only recording it.
+func (w *webhooks) pollTimestamps(ctx context.Context) {
+	if dbStatus.unavailable() != nil {
+		return
+	}
+	for _, gpath := range w.watch {
+		var payload []byte
+		qctx, cancel := ctx, context.CancelFunc(func() {})
+		if *queryTimeout > 0 {
+			qctx, cancel = context.WithTimeout(ctx, time.Duration(*queryTimeout)*time.Second)
+		}
+		err := queryRow(qctx,
+			`select mas_timestamps(
+				nullif($1,'')::text,
+				null, null, null, null, null, null
+			) as json`,
+			gpath,
+		).Scan(&payload)
+		cancel()
+		if err != nil {
+			log.Printf("webhook poll of the timestamps of %s failed: %v", gpath, err)
+			continue
+		}
+		var result struct {
+			Timestamps []string `json:"timestamps"`
+		}
+		if err := json.Unmarshal(payload, &result); err != nil || len(result.Timestamps) == 0 {
+			continue
+		}
+
+		w.mu.Lock()
+		latest, seen := w.latest[gpath]
+		w.latest[gpath] = result.Timestamps[len(result.Timestamps)-1]
+		w.mu.Unlock()
+		if !seen {
+			continue
+		}
+		// The timestamps are sorted, of the same layout.
+		i := sort.Search(len(result.Timestamps), func(i int) bool { return result.Timestamps[i] > latest })
+		if i < len(result.Timestamps) {
+			w.publish(ctx, webhookEvent{Event: "timestamps", GPath: gpath, Timestamps: result.Timestamps[i:]})
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// verifyWebhook checks the signature of a webhook as a receiver would.
+func verifyWebhook(secret string, r *http.Request, body []byte) bool {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(r.Header.Get("X-Gsky-Timestamp") + "."))
+	m.Write(body)
+	expected := "sha256=" + hex.EncodeToString(m.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Gsky-Signature")))
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	type delivery struct {
+		r    *http.Request
+		body []byte
+	}
+	deliveries := make(chan delivery, 4)
+	failures := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		deliveries <- delivery{r, body}
+	}))
+	defer srv.Close()
+
+	hooks, err := newWebhooks(srv.URL, "secret", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hooks.start(ctx, 0)
+	hooks.publish(ctx, webhookEvent{Event: "ows_cache", GPath: "/g/data/chirps", Query: "wms"})
+
+	var d delivery
+	select {
+	case d = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the event to be delivered after a failure")
+	}
+	if d.r.Method != http.MethodPost || d.r.Header.Get("X-Gsky-Event") != "ows_cache" {
+		t.Errorf("expected a POST of the ows_cache event, got %s %v", d.r.Method, d.r.Header)
+	}
+	if !verifyWebhook("secret", d.r, d.body) {
+		t.Errorf("expected the signature to verify, got %q", d.r.Header.Get("X-Gsky-Signature"))
+	}
+	if verifyWebhook("other secret", d.r, d.body) {
+		t.Errorf("expected the signature not to verify with another secret")
+	}
+	tampered := append([]byte{}, d.body...)
+	tampered[len(tampered)-2] = 'x'
+	if verifyWebhook("secret", d.r, tampered) {
+		t.Errorf("expected the signature not to verify with another body")
+	}
+
+	var event webhookEvent
+	if err := json.Unmarshal(d.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.GPath != "/g/data/chirps" || event.Query != "wms" || len(event.Time) == 0 {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
+
+func TestWebhookSign(t *testing.T) {
+	w := &webhooks{secret: []byte("secret")}
+	body := []byte(`{"event":"timestamps"}`)
+	if w.sign("1600000000", body) == w.sign("1600000001", body) {
+		t.Errorf("expected the signature to depend on the timestamp")
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("X-Gsky-Timestamp", "1600000000")
+	r.Header.Set("X-Gsky-Signature", w.sign("1600000000", body))
+	if !verifyWebhook("secret", r, body) {
+		t.Errorf("expected the signature to verify")
+	}
+}
+
+func TestNewWebhooks(t *testing.T) {
+	if w, err := newWebhooks(" , ", "secret", "", time.Second); w != nil || err != nil {
+		t.Errorf("expected no webhooks without URL, got %v: %v", w, err)
+	}
+	if _, err := newWebhooks("ftp://example.org", "secret", "", time.Second); err == nil {
+		t.Errorf("expected an error for a URL other than http")
+	}
+	if _, err := newWebhooks("https://example.org/hook", "", "", time.Second); err == nil {
+		t.Errorf("expected an error without secret")
+	}
+	w, err := newWebhooks("https://example.org/hook", "secret", "/g/data/chirps, ,/g/data/tamsat", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.watch) != 2 {
+		t.Errorf("expected 2 watched gpaths, got %v", w.watch)
+	}
+}
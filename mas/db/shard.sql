@@ -137,6 +137,80 @@ create or replace function ingested_lines()
 
     drop table mypaths;
 
+    -- The files crawled with -dedup of the same checksum as a file of
+    -- the shard, their canonical file, are duplicates: the file ingested
+    -- first, not itself a duplicate, else the first path of the files
+    -- ingested together.
+    insert into mymetadata (md_hash, md_type, md_json)
+      select
+        m.md_hash,
+        'duplicate',
+        jsonb_build_object(
+          'canonical', c.pa_path,
+          'checksum', m.md_json->>'checksum',
+          'dedup', m.md_json->>'dedup'
+        ) || case
+          when m.md_json->>'dedup' = 'link' then jsonb_build_object('gdal', m.md_json)
+          else '{}'::jsonb
+        end
+      from mymetadata m
+      inner join paths p
+        on p.pa_hash = m.md_hash
+      cross join lateral (
+        select pa_path
+        from (
+          select md.md_hash, md.md_ingested, true as recorded
+          from metadata md
+          where md.md_type = 'gdal'
+          and md.md_json->>'checksum' = m.md_json->>'checksum'
+          and not exists (
+            select 1 from metadata d
+            where d.md_hash = md.md_hash and d.md_type = 'duplicate'
+          )
+          union all
+          select b.md_hash, b.md_ingested, false
+          from mymetadata b
+          where b.md_type = 'gdal'
+          and b.md_json->>'checksum' = m.md_json->>'checksum'
+        ) k
+        inner join paths
+          on pa_hash = k.md_hash
+        where k.md_hash <> m.md_hash
+        and (k.recorded or pa_path < p.pa_path)
+        order by k.recorded desc, k.md_ingested, pa_path
+        limit 1
+      ) c
+      where m.md_type = 'gdal'
+      and m.md_json->>'dedup' in ('link', 'flag')
+      and m.md_json ? 'checksum'
+    ;
+
+    -- The files whose GDAL metadata is ingested are no longer
+    -- quarantined, e.g. once repaired and crawled again, nor duplicates
+    -- but of their duplicate records ingested with them.
+    delete from metadata
+      where md_type in ('quarantine', 'duplicate')
+      and md_hash in (select md_hash from mymetadata where md_type = 'gdal')
+    ;
+
+    -- The duplicates linked to their canonical file are not indexed,
+    -- their GDAL metadata being kept in their duplicate record.
+    delete from metadata
+      where md_type = 'gdal'
+      and md_hash in (
+        select md_hash from mymetadata
+        where md_type = 'duplicate' and md_json->>'dedup' = 'link'
+      )
+    ;
+    delete from mymetadata m
+      where m.md_type = 'gdal'
+      and exists (
+        select 1 from mymetadata d
+        where d.md_hash = m.md_hash
+        and d.md_type = 'duplicate' and d.md_json->>'dedup' = 'link'
+      )
+    ;
+
     insert into metadata select * from mymetadata
       on conflict (md_hash, md_type)
       do update set
@@ -194,6 +268,11 @@ create table metadata (
 create unique index mdi_pk
   on metadata (md_hash, md_type);
 
+-- The files of a checksum, of the duplicates of -dedup
+create index mdi_checksum
+  on metadata ((md_json->>'checksum'))
+  where md_type = 'gdal';
+
 -- View of paths + metadata for files
 drop materialized view if exists files cascade;
 create materialized view files as
@@ -0,0 +1,26 @@
+#!/bin/bash
+
+# Ingest the crawler records read from stdin into a shard in place, e.g.
+# those of the files arrived crawled by gsky-crawl -watch, refreshing the
+# polygons and the caches of the shard for the files to be served at
+# once, without the shard_reset.sh and shard_refresh.sh of a full
+# ingestion.
+
+here="$( cd "$( dirname "${BASH_SOURCE[0]}" )" && pwd )"
+shard=$1
+
+(cd "$here" && ./ingest.sh "$shard") || exit 1
+
+(cd "$here" && psql -v ON_ERROR_STOP=1 -A -t -q -d mas <<EOD >/dev/null
+
+set role mas;
+set search_path to ${shard},public;
+
+select refresh_polygons();
+select refresh_caches();
+select refresh_codegens();
+
+set search_path to public;
+select mas_refresh_caches();
+EOD
+)
@@ -0,0 +1,51 @@
+#!/bin/bash
+
+# Purge the files read from stdin, one path per line, from a shard, e.g.
+# the files no longer present reported by gsky-crawl -deleted, writing
+# the paths purged to stdout. The polygons and the caches of the shard
+# are refreshed in place.
+
+here="$( cd "$( dirname "${BASH_SOURCE[0]}" )" && pwd )"
+shard=$1
+
+(cd "$here" && psql -v ON_ERROR_STOP=1 -A -t -q -d mas -f <(cat <<EOD
+
+set role mas;
+set search_path to ${shard},public;
+
+create temporary table gc_paths (gc_path text);
+\\copy gc_paths from pstdin with (format 'csv', delimiter E'\\t', quote E'\\b')
+
+begin;
+delete from metadata
+  where md_hash in (select md5(trim(gc_path))::uuid from gc_paths);
+
+-- The duplicates of the files purged, of -dedup, are indexed again with
+-- their GDAL metadata, if linked, until crawled again.
+insert into metadata (md_hash, md_type, md_json)
+  select md_hash, 'gdal', md_json->'gdal'
+  from metadata
+  where md_type = 'duplicate'
+  and md_json ? 'gdal'
+  and md_json->>'canonical' in (select trim(gc_path) from gc_paths)
+on conflict (md_hash, md_type) do nothing;
+delete from metadata
+  where md_type = 'duplicate'
+  and md_json->>'canonical' in (select trim(gc_path) from gc_paths);
+with purged as (
+  delete from paths
+    where pa_hash in (select md5(trim(gc_path))::uuid from gc_paths)
+    returning pa_path
+)
+select pa_path from purged order by pa_path;
+commit;
+
+\\o /dev/null
+select refresh_polygons();
+select refresh_caches();
+select refresh_codegens();
+
+set search_path to public;
+select mas_refresh_caches();
+EOD
+))
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nci/gsky/failover"
+)
+
+// masFailover routes the MAS queries of the namespaces configured with
+// mas_replicas to their healthiest endpoint.
+var masFailover *failover.Transport
+
+// initMASFailover installs the failover transport as the default HTTP
+// transport, which the MAS queries are sent with, and starts the
+// health checks of the replicas.
+func initMASFailover(interval time.Duration) {
+	masFailover = failover.NewTransport(http.DefaultTransport)
+	masFailover.Notify = func(address string, healthy bool, err error) {
+		if healthy {
+			Info.Printf("MAS %s is healthy again", address)
+		} else {
+			Error.Printf("MAS %s is unhealthy, failing over to its replicas: %v", address, err)
+		}
+	}
+	masFailover.Configure(masReplicaGroups())
+	http.DefaultTransport = masFailover
+	masFailover.Start(interval, masReplicaGroups)
+}
+
+// masReplicaGroups returns the mas_replicas of the mas_address of each
+// namespace.
+func masReplicaGroups() map[string][]string {
+	groups := make(map[string][]string)
+	for _, conf := range getConfigMap() {
+		if conf == nil || len(conf.ServiceConfig.MASReplicas) == 0 {
+			continue
+		}
+		if primary := conf.ServiceConfig.MASAddress; len(primary) > 0 {
+			if _, found := groups[primary]; !found {
+				groups[primary] = conf.ServiceConfig.MASReplicas
+			}
+		}
+	}
+	return groups
+}
@@ -0,0 +1,161 @@
+package prom
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// The metrics below are shared by every GSKY component and carry a
+// component label, e.g. ows, mas or worker, so that a single dashboard
+// can aggregate or break down the whole stack. Component specific
+// metrics are named gsky_<component>_<name>_<unit>.
+
+// HTTPMetrics counts the requests served by an HTTP handler.
+type HTTPMetrics struct {
+	component string
+	requests  *CounterVec
+	duration  *HistogramVec
+	inFlight  *GaugeVec
+}
+
+// NewHTTPMetrics creates the request metrics of a component.
+func NewHTTPMetrics(component string) *HTTPMetrics {
+	return &HTTPMetrics{
+		component: component,
+		requests:  NewCounterVec("gsky_http_requests_total", "Number of HTTP requests served.", "component", "handler", "code"),
+		duration:  NewHistogramVec("gsky_http_request_duration_seconds", "HTTP request latency in seconds.", nil, "component", "handler"),
+		inFlight:  NewGaugeVec("gsky_http_requests_in_flight", "Number of HTTP requests being served.", "component"),
+	}
+}
+
+// Collectors returns the collectors to register.
+func (m *HTTPMetrics) Collectors() []Collector {
+	return []Collector{m.requests, m.duration, m.inFlight}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Instrument wraps h to record its requests under the handler label.
+func (m *HTTPMetrics) Instrument(handler string, h http.Handler) http.Handler {
+	inFlight := m.inFlight.With(m.component)
+	duration := m.duration.With(m.component, handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t0 := time.Now()
+		inFlight.Inc()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			inFlight.Dec()
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			m.requests.With(m.component, handler, strconv.Itoa(sw.status)).Inc()
+			duration.Observe(time.Since(t0).Seconds())
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+// InstrumentFunc is Instrument for handler functions.
+func (m *HTTPMetrics) InstrumentFunc(handler string, h func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return m.Instrument(handler, http.HandlerFunc(h)).ServeHTTP
+}
+
+// CacheMetrics counts the lookups of the caches of a component. The hit
+// ratio of a cache is the rate of its hit lookups over the rate of all
+// its lookups.
+type CacheMetrics struct {
+	component string
+	lookups   *CounterVec
+}
+
+// NewCacheMetrics creates the cache metrics of a component.
+func NewCacheMetrics(component string) *CacheMetrics {
+	return &CacheMetrics{
+		component: component,
+		lookups:   NewCounterVec("gsky_cache_requests_total", "Number of cache lookups.", "component", "cache", "result"),
+	}
+}
+
+// Collectors returns the collectors to register.
+func (m *CacheMetrics) Collectors() []Collector {
+	return []Collector{m.lookups}
+}
+
+// Observe records a lookup of the cache.
+func (m *CacheMetrics) Observe(cache string, hit bool) {
+	m.Add(cache, hit, 1)
+}
+
+// Add records n lookups of the cache, for the caches keeping their own
+// counts.
+func (m *CacheMetrics) Add(cache string, hit bool, n float64) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.lookups.With(m.component, cache, result).Add(n)
+}
+
+// RegisterProcessMetrics registers the build and Go runtime metrics of
+// the component. The module version of the binary is used if version
+// is empty.
+func RegisterProcessMetrics(r *Registry, component, version string) {
+	if len(version) == 0 {
+		version = "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			version = info.Main.Version
+		}
+	}
+	buildInfo := NewGaugeVec("gsky_build_info", "Version of the GSKY component, always 1.", "component", "version")
+	buildInfo.With(component, version).Set(1)
+	startTime := NewGaugeVec("gsky_process_start_time_seconds", "Start time of the process since the Unix epoch in seconds.", "component")
+	startTime.With(component).Set(float64(time.Now().Unix()))
+	goroutines := NewGaugeVec("gsky_go_goroutines", "Number of goroutines.", "component")
+	heap := NewGaugeVec("gsky_go_heap_alloc_bytes", "Bytes of allocated heap objects.", "component")
+
+	r.MustRegister(buildInfo, startTime, goroutines, heap)
+	r.OnScrape(func() {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		goroutines.With(component).Set(float64(runtime.NumGoroutine()))
+		heap.With(component).Set(float64(ms.HeapAlloc))
+	})
+}
+
+// ListenAndServe exposes the registry at /metrics on the given port.
+func ListenAndServe(r *Registry, component string, port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	log.Printf("GSKY %s metrics are listening on :%d/metrics", component, port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		log.Printf("metrics server failed: %v", err)
+	}
+}
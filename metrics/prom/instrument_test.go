@@ -0,0 +1,52 @@
+package prom
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	reg := NewRegistry()
+	httpMetrics := NewHTTPMetrics("mas")
+	cacheMetrics := NewCacheMetrics("mas")
+	reg.MustRegister(httpMetrics.Collectors()...)
+	reg.MustRegister(cacheMetrics.Collectors()...)
+	RegisterProcessMetrics(reg, "mas", "1.2.3")
+
+	h := httpMetrics.InstrumentFunc("api", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "bad request", 400)
+			return
+		}
+		w.Write([]byte("{}"))
+	})
+	for _, u := range []string{"/g?intersects", "/g?intersects", "/g?fail=1"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", u, nil))
+	}
+	cacheMetrics.Observe("memcache", true)
+	cacheMetrics.Add("memcache", false, 3)
+
+	var buf bytes.Buffer
+	if err := reg.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	expected := []string{
+		`gsky_http_requests_total{component="mas",handler="api",code="200"} 2`,
+		`gsky_http_requests_total{component="mas",handler="api",code="400"} 1`,
+		`gsky_http_request_duration_seconds_count{component="mas",handler="api"} 3`,
+		`gsky_http_requests_in_flight{component="mas"} 0`,
+		`gsky_cache_requests_total{component="mas",cache="memcache",result="hit"} 1`,
+		`gsky_cache_requests_total{component="mas",cache="memcache",result="miss"} 3`,
+		`gsky_build_info{component="mas",version="1.2.3"} 1`,
+		`gsky_go_goroutines{component="mas"} `,
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("missing %q in:\n%s", e, out)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+// Package prom implements a minimal subset of the Prometheus client
+// data model (counters, gauges and histograms with labels) together
+// with the text exposition format so GSKY services can be scraped by
+// Prometheus without pulling in the full client library.
+package prom
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the default histogram buckets in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Collector is a metric family that can be written in the Prometheus
+// text exposition format.
+type Collector interface {
+	Write(w io.Writer) error
+}
+
+// Registry holds a set of collectors exposed by a metrics endpoint.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+	hooks      []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// MustRegister adds collectors to the registry.
+func (r *Registry) MustRegister(cs ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, cs...)
+}
+
+// OnScrape registers a function called before the metrics are written,
+// which allows gauges that are expensive to keep up to date to be
+// refreshed at scrape time only.
+func (r *Registry) OnScrape(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, fn)
+}
+
+// WriteText writes all the registered collectors to w.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	hooks := make([]func(), len(r.hooks))
+	copy(hooks, r.hooks)
+	collectors := make([]Collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+
+	for _, c := range collectors {
+		if err := c.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
+}
+
+type desc struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+func (d *desc) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.metricType)
+	return err
+}
+
+func (d *desc) labelKey(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("prom: %s expects %d label values, got %d", d.name, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && len(extraName) == 0 {
+		return ""
+	}
+
+	var parts []string
+	for i, name := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i])))
+	}
+	if len(extraName) > 0 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extraName, escapeLabel(extraValue)))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func escapeHelp(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+// value is a single float sample shared by counters and gauges.
+type value struct {
+	mu  sync.Mutex
+	val float64
+}
+
+func (v *value) add(delta float64) {
+	v.mu.Lock()
+	v.val += delta
+	v.mu.Unlock()
+}
+
+func (v *value) set(val float64) {
+	v.mu.Lock()
+	v.val = val
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.val
+}
+
+// valueVec is a set of samples partitioned by label values.
+type valueVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*value
+	labels map[string][]string
+}
+
+func newValueVec(name string, help string, metricType string, labelNames []string) *valueVec {
+	return &valueVec{
+		desc:   desc{name: name, help: help, metricType: metricType, labelNames: labelNames},
+		values: make(map[string]*value),
+		labels: make(map[string][]string),
+	}
+}
+
+func (vv *valueVec) with(labelValues []string) *value {
+	key := vv.labelKey(labelValues)
+	vv.mu.Lock()
+	defer vv.mu.Unlock()
+	v, ok := vv.values[key]
+	if !ok {
+		v = &value{}
+		vv.values[key] = v
+		vv.labels[key] = append([]string{}, labelValues...)
+	}
+	return v
+}
+
+// Reset removes all the samples.
+func (vv *valueVec) Reset() {
+	vv.mu.Lock()
+	vv.values = make(map[string]*value)
+	vv.labels = make(map[string][]string)
+	vv.mu.Unlock()
+}
+
+func (vv *valueVec) Write(w io.Writer) error {
+	if err := vv.writeHeader(w); err != nil {
+		return err
+	}
+
+	vv.mu.Lock()
+	keys := make([]string, 0, len(vv.values))
+	for k := range vv.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		labels := formatLabels(vv.labelNames, vv.labels[k], "", "")
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", vv.name, labels, formatFloat(vv.values[k].get())); err != nil {
+			vv.mu.Unlock()
+			return err
+		}
+	}
+	vv.mu.Unlock()
+	return nil
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v *value
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add increases the counter by delta. Negative values are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.v.add(delta)
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.get()
+}
+
+type CounterVec struct {
+	*valueVec
+}
+
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newValueVec(name, help, "counter", labelNames)}
+}
+
+func (cv *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{cv.with(labelValues)}
+}
+
+// NewCounter returns a counter without labels.
+func NewCounter(name string, help string) (*CounterVec, *Counter) {
+	cv := NewCounterVec(name, help)
+	return cv, cv.With()
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v *value
+}
+
+func (g *Gauge) Set(val float64) {
+	g.v.set(val)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.v.get()
+}
+
+type GaugeVec struct {
+	*valueVec
+}
+
+func NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{newValueVec(name, help, "gauge", labelNames)}
+}
+
+func (gv *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{gv.with(labelValues)}
+}
+
+// NewGauge returns a gauge without labels.
+func NewGauge(name string, help string) (*GaugeVec, *Gauge) {
+	gv := NewGaugeVec(name, help)
+	return gv, gv.With()
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type HistogramVec struct {
+	desc
+	buckets    []float64
+	mu         sync.Mutex
+	histograms map[string]*Histogram
+	labels     map[string][]string
+}
+
+// NewHistogramVec creates a histogram family. DefBuckets are used if
+// buckets is empty.
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return &HistogramVec{
+		desc:       desc{name: name, help: help, metricType: "histogram", labelNames: labelNames},
+		buckets:    sorted,
+		histograms: make(map[string]*Histogram),
+		labels:     make(map[string][]string),
+	}
+}
+
+func (hv *HistogramVec) With(labelValues ...string) *Histogram {
+	key := hv.labelKey(labelValues)
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	h, ok := hv.histograms[key]
+	if !ok {
+		h = &Histogram{buckets: hv.buckets, counts: make([]uint64, len(hv.buckets))}
+		hv.histograms[key] = h
+		hv.labels[key] = append([]string{}, labelValues...)
+	}
+	return h
+}
+
+func (hv *HistogramVec) Write(w io.Writer) error {
+	if err := hv.writeHeader(w); err != nil {
+		return err
+	}
+
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	keys := make([]string, 0, len(hv.histograms))
+	for k := range hv.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		h := hv.histograms[k]
+		lv := hv.labels[k]
+		h.mu.Lock()
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, formatLabels(hv.labelNames, lv, "le", formatFloat(b)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, formatLabels(hv.labelNames, lv, "le", "+Inf"), h.count)
+		labels := formatLabels(hv.labelNames, lv, "", "")
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.name, labels, formatFloat(h.sum))
+		_, err := fmt.Fprintf(w, "%s_count%s %d\n", hv.name, labels, h.count)
+		h.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package prom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := NewCounterVec("gsky_requests_total", "Total requests.", "service", "status")
+	requests.With("WMS", "200").Add(3)
+	requests.With("WCS", "500").Inc()
+
+	gv, g := NewGauge("gsky_in_flight", "In-flight requests.")
+	g.Set(2)
+	g.Dec()
+
+	hv := NewHistogramVec("gsky_duration_seconds", "Durations.", []float64{1, 0.1}, "service")
+	hv.With("WMS").Observe(0.05)
+	hv.With("WMS").Observe(0.5)
+	hv.With("WMS").Observe(5)
+
+	scrapes := 0
+	reg.OnScrape(func() { scrapes++ })
+	reg.MustRegister(requests, gv, hv)
+
+	var buf bytes.Buffer
+	if err := reg.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP gsky_requests_total Total requests.
+# TYPE gsky_requests_total counter
+gsky_requests_total{service="WCS",status="500"} 1
+gsky_requests_total{service="WMS",status="200"} 3
+# HELP gsky_in_flight In-flight requests.
+# TYPE gsky_in_flight gauge
+gsky_in_flight 1
+# HELP gsky_duration_seconds Durations.
+# TYPE gsky_duration_seconds histogram
+gsky_duration_seconds_bucket{service="WMS",le="0.1"} 1
+gsky_duration_seconds_bucket{service="WMS",le="1"} 2
+gsky_duration_seconds_bucket{service="WMS",le="+Inf"} 3
+gsky_duration_seconds_sum{service="WMS"} 5.55
+gsky_duration_seconds_count{service="WMS"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	if scrapes != 1 {
+		t.Errorf("expected 1 scrape hook call, got %d", scrapes)
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	gv := NewGaugeVec("gsky_test", "Test.", "path")
+	gv.With(`a"b\c`).Set(1)
+
+	var buf bytes.Buffer
+	gv.Write(&buf)
+	expected := "# HELP gsky_test Test.\n# TYPE gsky_test gauge\ngsky_test{path=\"a\\\"b\\\\c\"} 1\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
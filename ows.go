@@ -35,8 +35,18 @@ import (
 	"time"
 
 	"github.com/nci/gomemcache/memcache"
+	"github.com/nci/gsky/audit"
+	"github.com/nci/gsky/lifecycle"
+	"github.com/nci/gsky/loadshed"
+	"github.com/nci/gsky/logging"
 	"github.com/nci/gsky/metrics"
+	"github.com/nci/gsky/metrics/prom"
 	proc "github.com/nci/gsky/processor"
+	"github.com/nci/gsky/telemetry"
+	"github.com/nci/gsky/tokens"
+	"github.com/nci/gsky/tracing"
+	"github.com/nci/gsky/urlsign"
+	"github.com/nci/gsky/usage"
 	"github.com/nci/gsky/utils"
 
 	geo "github.com/nci/geometry"
@@ -50,15 +60,37 @@ var fileResolver *utils.RuntimeFileResolver
 var builtinPalettes *utils.BuiltinPalettes
 var mc *memcache.Client
 var (
-	port            = flag.Int("p", 8080, "Server listening port.")
-	serverDataDir   = flag.String("data_dir", utils.DataDir, "Server data directory.")
-	serverConfigDir = flag.String("conf_dir", utils.EtcDir, "Server config directory.")
-	serverLogDir    = flag.String("log_dir", "", "Server log directory.")
-	validateConfig  = flag.Bool("check_conf", false, "Validate server config files.")
-	dumpConfig      = flag.Bool("dump_conf", false, "Dump server config files.")
-	mcURI           = flag.String("memcache", "", "memcache uri host:port")
-	verbose         = flag.Bool("v", false, "Verbose mode for more server outputs.")
-	version         = flag.Bool("version", false, "Get GSKY version")
+	port              = flag.Int("p", 8080, "Server listening port.")
+	serverDataDir     = flag.String("data_dir", utils.DataDir, "Server data directory.")
+	serverConfigDir   = flag.String("conf_dir", utils.EtcDir, "Server config directory.")
+	serverLogDir      = flag.String("log_dir", "", "Server log directory.")
+	validateConfig    = flag.Bool("check_conf", false, "Validate server config files.")
+	dumpConfig        = flag.Bool("dump_conf", false, "Dump server config files.")
+	paletteDir        = flag.String("palette_dir", "", "Directory of .cpt, .sld and .json palette files loaded at startup in addition to the builtin palettes.")
+	confWatchInterval = flag.Int("conf_watch_interval", 0, "Interval in seconds between checks of the config directory for changes. A change reloads the config. Disabled if 0.")
+	adminToken        = flag.String("admin_token", os.Getenv("GSKY_ADMIN_TOKEN"), "Bearer token required by the /admin endpoints. The endpoints are disabled if empty.")
+	auditDest         = flag.String("audit_log", os.Getenv("GSKY_AUDIT_LOG"), "Append-only file or postgres:// URL receiving the audit trail of the WCS GetCoverage, DAP and WPS Execute requests. Disabled if empty.")
+	urlSigningKey     = flag.String("url_signing_key", os.Getenv("GSKY_URL_SIGNING_KEY"), "Secret key of the signed URLs issued by /sign, to be shared by all the OWS processes. Signed URLs are disabled if empty.")
+	signedURLMaxTTL   = flag.Int("signed_url_max_ttl", 86400, "Maximum validity in seconds of the signed URLs.")
+	usageDest         = flag.String("usage_store", os.Getenv("GSKY_USAGE_STORE"), "Directory or postgres:// URL storing the daily request counts per layer and client reported by /admin/usage and counted by the data quotas. Disabled if empty.")
+	telemetryEndpoint = flag.String("telemetry_endpoint", os.Getenv("GSKY_TELEMETRY_ENDPOINT"), "URL receiving the anonymous usage statistics posted as JSON every -telemetry_interval, shown by /admin/telemetry. Disabled if empty.")
+	telemetryInterval = flag.Int("telemetry_interval", 86400, "Interval in seconds between the telemetry reports.")
+	shedMemoryMB      = flag.Int("shed_memory_mb", 0, "Memory of the Go runtime in MB from which the low priority requests, i.e. GetCoverage, DAP and Execute, are rejected with 503, and GetMap and GetFeatureInfo as well from 125%. Disabled if 0.")
+	shedCPU           = flag.Float64("shed_cpu", 0, "Fraction of all the CPUs used by the process from which requests are shed as for -shed_memory_mb, e.g. 0.9. Disabled if 0.")
+	tokenFile         = flag.String("token_file", os.Getenv("GSKY_TOKEN_FILE"), "JSON file of the scoped API tokens managed by /admin/tokens and shared with MAS. Tokens are disabled if empty.")
+	stagingConfigDir  = flag.String("staging_conf_dir", "", "Default config directory of the candidate configs staged by /admin/config/stage.")
+	stagingPath       = flag.String("staging_path", "", "URL path serving the staged candidate configs side by side with /ows, e.g. /ows-staging. Disabled if empty.")
+	mcURI             = flag.String("memcache", "", "memcache uri host:port")
+	drainDelay        = flag.Int("drain_delay", 5, "Seconds between SIGTERM, from which /readyz reports the server unready, and the stop of the server, for the load balancers to stop sending new requests.")
+	shutdownTimeout   = flag.Int("shutdown_timeout", 20, "Maximum seconds waited for the requests in flight to finish after the drain delay.")
+	masHealthInterval = flag.Int("mas_health_interval", 10, "Interval in seconds between the health checks of the MAS endpoints of the namespaces with mas_replicas.")
+	metricsPort       = flag.Int("metrics_port", 0, "Port serving Prometheus metrics at /metrics. Disabled if 0.")
+	otlpEndpoint      = flag.String("otlp_endpoint", "", "OTLP/HTTP endpoint receiving the traces, e.g. http://localhost:4318. Defaults to $OTEL_EXPORTER_OTLP_ENDPOINT. Tracing is disabled if empty.")
+	traceSampleRatio  = flag.Float64("trace_sample_ratio", 1.0, "Fraction of the OWS requests traced. Requests carrying a traceparent header follow the sampling of their parent.")
+	logLevel          = flag.String("log_level", os.Getenv("GSKY_LOG_LEVEL"), "Minimum level of the logs written: debug, info, warn or error. Defaults to info.")
+	logFormat         = flag.String("log_format", os.Getenv("GSKY_LOG_FORMAT"), "Format of the logs: text or json. Defaults to text.")
+	verbose           = flag.Bool("v", false, "Verbose mode for more server outputs.")
+	version           = flag.Bool("version", false, "Get GSKY version")
 )
 
 var reWMSMap map[string]*regexp.Regexp
@@ -71,18 +103,26 @@ var (
 )
 
 var metricsLogger metrics.Logger
+var owsProm *owsMetrics
+var tokenStore *tokens.Store
 
-// init initialises the Error logger, checks
+// initServer parses the flags, initialises the Error logger, checks
 // required files are in place  and sets Config struct.
-// This is the first function to be called in main.
-func init() {
+// This is the first function to be called in main, the tests of the
+// package running without the flags and the files of the server.
+func initServer() {
 	rand.Seed(time.Now().UnixNano())
 
-	Error = log.New(os.Stderr, "OWS: ", log.Ldate|log.Ltime|log.Lshortfile)
-	Info = log.New(os.Stdout, "OWS: ", log.Ldate|log.Ltime|log.Lshortfile)
-
 	flag.Parse()
 
+	if err := logging.Init("ows", *logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	owsLog := logging.New("ows")
+	Error = owsLog.StdLogger(logging.LevelError)
+	Info = owsLog.StdLogger(logging.LevelInfo)
+
 	if *version {
 		fmt.Printf("%s\n", utils.GSKYVersion)
 		os.Exit(0)
@@ -114,6 +154,20 @@ func init() {
 	}
 
 	http.DefaultTransport.(*http.Transport).MaxConnsPerHost = proc.DefaultMASMaxConnsPerHost
+
+	builtinPalettes = utils.NewBuiltinPalettes()
+	if len(*paletteDir) > 0 {
+		if err := builtinPalettes.LoadPaletteDir(*paletteDir, *verbose); err != nil {
+			Error.Printf("Error in loading palette files: %v\n", err)
+			panic(err)
+		}
+	}
+	utils.SetPaletteLibrary(builtinPalettes)
+
+	if flag.NArg() > 0 && flag.Arg(0) == "checkconfig" {
+		runCheckConfig(flag.Args()[1:])
+	}
+
 	confMap, err := utils.LoadAllConfigFiles(utils.EtcDir, *verbose)
 	if err != nil {
 		Error.Printf("Error in loading config files: %v\n", err)
@@ -139,14 +193,71 @@ func init() {
 		mc = memcache.New(*mcURI)
 	}
 
+	if *shedMemoryMB > 0 || *shedCPU > 0 {
+		shedMonitor = loadshed.NewMonitor(uint64(*shedMemoryMB)<<20, *shedCPU)
+		shedMonitor.Start(time.Second)
+	}
+
+	if len(*auditDest) > 0 {
+		auditLog, err = audit.Open(*auditDest)
+		if err != nil {
+			Error.Printf("Error in opening audit log: %v\n", err)
+			panic(err)
+		}
+	}
+
+	if len(*urlSigningKey) > 0 {
+		urlSigner = urlsign.NewSigner([]byte(*urlSigningKey))
+	}
+
+	if len(*usageDest) > 0 {
+		store, err := usage.Open(*usageDest)
+		if err != nil {
+			Error.Printf("Error in opening usage store: %v\n", err)
+			panic(err)
+		}
+		usageRecorder = usage.NewRecorder(store)
+		go flushUsage()
+	}
+
+	if len(*telemetryEndpoint) > 0 {
+		if *telemetryInterval <= 0 {
+			err := fmt.Errorf("invalid telemetry interval: %d", *telemetryInterval)
+			Error.Printf("Error in starting telemetry: %v\n", err)
+			panic(err)
+		}
+		telemetryCollector = telemetry.NewCollector(time.Now())
+		Info.Printf("Anonymous telemetry reported to %s every %ds", *telemetryEndpoint, *telemetryInterval)
+		go reportTelemetry(*telemetryEndpoint, time.Duration(*telemetryInterval)*time.Second)
+	}
+
+	if len(*tokenFile) > 0 {
+		tokenStore, err = tokens.Open(*tokenFile)
+		if err != nil {
+			Error.Printf("Error in loading token file: %v\n", err)
+			panic(err)
+		}
+	}
+
+	if err := tracing.Init("gsky-ows", *otlpEndpoint, *traceSampleRatio); err != nil {
+		Error.Printf("Error in initialising tracing: %v\n", err)
+		panic(err)
+	}
+
 	configMap = &sync.Map{}
 	configMap.Store("config", confMap)
+	utils.RecordConfig(confMap, "startup")
 
 	utils.WatchConfig(Info, Error, configMap, *verbose)
+	if *confWatchInterval > 0 {
+		utils.WatchConfigDir(Info, Error, configMap, time.Duration(*confWatchInterval)*time.Second, *verbose)
+	}
+
+	initMASFailover(time.Duration(*masHealthInterval) * time.Second)
 
 	mutex = &sync.Mutex{}
 
-	builtinPalettes = utils.NewBuiltinPalettes()
+	utils.WatchAutoLayers(Info, Error, configMap, builtinPalettes.Palettes, *verbose)
 
 	reWMSMap = utils.CompileWMSRegexMap()
 	reWCSMap = utils.CompileWCSRegexMap()
@@ -184,6 +295,7 @@ func init() {
 }
 
 func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r *http.Request, w http.ResponseWriter, metricsCollector *metrics.MetricsCollector) {
+	reqLog := logging.FromContext(ctx)
 
 	if params.Request == nil {
 		metricsCollector.Info.HTTPStatus = 400
@@ -217,6 +329,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 		} else if len(newConf.Layers) == 0 {
 			cacheMiss = true
 		}
+		owsProm.observeCache("capabilities", !cacheMiss)
 
 		if cacheMiss {
 			newConf = conf.Copy(r)
@@ -227,7 +340,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 		}
 
 		tpl, _ := fileResolver.Lookup("templates/WMS_GetCapabilities.tpl")
-		err = utils.ExecuteWriteTemplateFile(w, newConf, tpl)
+		err = utils.ExecuteWriteTemplateFile(w, utils.AccessFromContext(ctx).FilterLayers(newConf), tpl)
 		if err != nil {
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, err.Error(), 500)
@@ -260,7 +373,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 	case "GetFeatureInfo":
 		x, y, err := utils.GetCoordinates(params)
 		if err != nil {
-			Error.Printf("%s\n", err)
+			reqLog.Errorf("%s\n", err)
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, fmt.Sprintf("Malformed WMS GetFeatureInfo request: %v", err), 400)
 			return
@@ -281,6 +394,15 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 				return
 			}
 			params.Time = currentTime
+		} else if idx, err := utils.GetLayerIndex(params, conf); err == nil {
+			t := conf.Layers[idx].RoundTime(*params.Time)
+			params.Time = &t
+		}
+		if idx, err := utils.GetLayerIndex(params, conf); err == nil {
+			conf.Layers[idx].Deprecation.SetHeaders(w.Header())
+			if !checkAccess(ctx, w, conf.Layers[idx].Name, utils.AccessView, accessScope(params.CRS, wmsBBox(params), params.Time, nil), metricsCollector) {
+				return
+			}
 		}
 
 		var times []string
@@ -302,22 +424,28 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 
 		feat_info, err := proc.GetFeatureInfo(ctx, params, conf, getConfigMap(), *verbose, metricsCollector)
 		if err != nil {
-			feat_info = fmt.Sprintf(`"error": "%v"`, err)
-			Error.Printf("%v\n", err)
+			msg, _ := json.Marshal(err.Error())
+			feat_info = fmt.Sprintf(`"error": %s`, msg)
+			reqLog.Errorf("%v\n", err)
 		}
 
 		resp := fmt.Sprintf(`{"type":"FeatureCollection","features":[{"type":"Feature","properties":{"x":%f, "y":%f, %s, %s}}]}`, x, y, timeStr, feat_info)
+		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(resp))
 
 	case "DescribeLayer":
 		conf = conf.Copy(r)
 		idx, err := utils.GetLayerIndex(params, conf)
 		if err != nil {
-			Error.Printf("%s\n", err)
+			reqLog.Errorf("%s\n", err)
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, fmt.Sprintf("Malformed WMS DescribeLayer request: %v", err), 400)
 			return
 		}
+		conf.Layers[idx].Deprecation.SetHeaders(w.Header())
+		if !checkAccess(ctx, w, conf.Layers[idx].Name, utils.AccessView, nil, metricsCollector) {
+			return
+		}
 
 		tpl, _ := fileResolver.Lookup("templates/WMS_DescribeLayer.tpl")
 		err = utils.ExecuteWriteTemplateFile(w, conf.Layers[idx], tpl)
@@ -347,11 +475,12 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 
 		idx, err := utils.GetLayerIndex(params, conf)
 		if err != nil {
-			Error.Printf("%s\n", err)
+			reqLog.Errorf("%s\n", err)
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, fmt.Sprintf("Malformed WMS GetMap request: %v", err), 400)
 			return
 		}
+		conf.Layers[idx].Deprecation.SetHeaders(w.Header())
 		if params.Time == nil {
 			currentTime, err := utils.GetCurrentTimeStamp(conf.Layers[idx].Dates)
 			if err != nil {
@@ -360,6 +489,9 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 				return
 			}
 			params.Time = currentTime
+		} else {
+			t := conf.Layers[idx].RoundTime(*params.Time)
+			params.Time = &t
 		}
 		if params.CRS == nil {
 			metricsCollector.Info.HTTPStatus = 400
@@ -392,6 +524,10 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 			endTime = &eT
 		}
 
+		if !checkAccess(ctx, w, conf.Layers[idx].Name, utils.AccessView, accessScope(params.CRS, params.BBox, params.Time, endTime), metricsCollector) {
+			return
+		}
+
 		if *params.Height > conf.Layers[idx].WmsMaxHeight || *params.Width > conf.Layers[idx].WmsMaxWidth {
 			http.Error(w, fmt.Sprintf("Requested width/height is too large, max width:%d, height:%d", conf.Layers[idx].WmsMaxWidth, conf.Layers[idx].WmsMaxHeight), 400)
 			return
@@ -399,7 +535,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 
 		styleIdx, err := utils.GetLayerStyleIndex(params, conf, idx)
 		if err != nil {
-			Error.Printf("%s\n", err)
+			reqLog.Errorf("%s\n", err)
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, fmt.Sprintf("Malformed WMS GetMap request: %v", err), 400)
 			return
@@ -411,12 +547,26 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 		}
 
 		if utils.CheckDisableServices(styleLayer, "wms") {
-			Error.Printf("WMS GetMap is disabled for this layer")
+			reqLog.Errorf("WMS GetMap is disabled for this layer")
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, "WMS GetMap is disabled for this layer", 400)
 			return
 		}
 
+		cache := getTileCache()
+		var cacheKey string
+		if cache != nil && r.Method == "GET" {
+			cacheKey = tileCacheKey(conf, r, params)
+			out, hit := cache.Get(conf.Layers[idx].Name, cacheKey)
+			owsProm.observeCache("disk", hit)
+			if hit {
+				w.Header().Set("X-Gsky-Cache", "HIT")
+				w.Write(out)
+				return
+			}
+			w.Header().Set("X-Gsky-Cache", "MISS")
+		}
+
 		offset := styleLayer.OffsetValue
 		scale := styleLayer.ScaleValue
 		clip := styleLayer.ClipValue
@@ -442,7 +592,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 			}
 			if !foundPalette {
 				msg := fmt.Sprintf("Requested palette not found: %s", *params.Palette)
-				Error.Printf(msg)
+				reqLog.Errorf(msg)
 				metricsCollector.Info.HTTPStatus = 400
 				http.Error(w, msg, 400)
 				return
@@ -460,6 +610,14 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 		}
 		reqRes := utils.GetPixelResolution(bbox, *params.Width, *params.Height)
 
+		nativeCRS, err := conf.Layers[idx].NativeCRSWKT()
+		if err != nil {
+			reqLog.Errorf("%v\n", err)
+			metricsCollector.Info.HTTPStatus = 500
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
 		geoReq := &proc.GeoTileRequest{ConfigPayLoad: proc.ConfigPayLoad{NameSpaces: styleLayer.RGBExpressions.VarList,
 			BandExpr: styleLayer.RGBExpressions,
 			Mask:     styleLayer.Mask,
@@ -474,6 +632,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 			GrpcConcLimit:       conf.Layers[idx].GrpcWmsConcPerNode,
 			QueryLimit:          -1,
 			UserSrcSRS:          conf.Layers[idx].UserSrcSRS,
+			NativeCRS:           nativeCRS,
 			UserSrcGeoTransform: conf.Layers[idx].UserSrcGeoTransform,
 			AxisMapping:         conf.Layers[idx].WmsAxisMapping,
 			GrpcTileXSize:       conf.Layers[idx].GrpcTileXSize,
@@ -485,6 +644,10 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 			MasQueryHint:        conf.Layers[idx].MasQueryHint,
 			ReqRes:              reqRes,
 			SRSCf:               conf.Layers[idx].SRSCf,
+			StreamingMerge:      conf.Layers[idx].StreamingMerge,
+			GrpcChecksum:        conf.ServiceConfig.GrpcChecksum,
+			WarpBackend:         conf.Layers[idx].WarpBackend,
+			ResampleAlg:         conf.Layers[idx].Resampling,
 			MetricsCollector:    metricsCollector,
 		},
 			Collection:  styleLayer.DataSource,
@@ -508,7 +671,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 		if params.BandExpr != nil {
 			if len(params.BandExpr.Expressions) > 0 && len(params.BandExpr.Expressions) != 1 && len(params.BandExpr.Expressions) != 3 {
 				err = fmt.Errorf("Number of band expressions must be either 1 or 3 for WMS")
-				Error.Printf("%s\n", err)
+				reqLog.Errorf("%s\n", err)
 				metricsCollector.Info.HTTPStatus = 400
 				http.Error(w, fmt.Sprintf("Malformed WMS GetMap request: %v", err), 400)
 				return
@@ -516,7 +679,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 
 			err := utils.CheckBandExpressionsComplexity(params.BandExpr, conf.Layers[idx].WmsBandExpressionCriteria)
 			if err != nil {
-				Error.Printf("%s\n", err)
+				reqLog.Errorf("%s\n", err)
 				metricsCollector.Info.HTTPStatus = 400
 				http.Error(w, fmt.Sprintf("Malformed WMS GetMap request: %v", err), 400)
 				return
@@ -552,7 +715,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 				zoomFile, _ := fileResolver.Lookup("zoom.png")
 				out, err := utils.GetEmptyTile(zoomFile, *params.Height, *params.Width)
 				if err != nil {
-					Info.Printf("Error in the utils.GetEmptyTile(zoom.png): %v\n", err)
+					reqLog.Infof("Error in the utils.GetEmptyTile(zoom.png): %v\n", err)
 					metricsCollector.Info.HTTPStatus = 500
 					http.Error(w, err.Error(), 500)
 					return
@@ -561,7 +724,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 			} else {
 				out, err := utils.GetEmptyTile("", *params.Height, *params.Width)
 				if err != nil {
-					Info.Printf("Error in the utils.GetEmptyTile(): %v\n", err)
+					reqLog.Infof("Error in the utils.GetEmptyTile(): %v\n", err)
 					metricsCollector.Info.HTTPStatus = 500
 					http.Error(w, err.Error(), 500)
 				} else {
@@ -582,7 +745,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 
 			norm, err := utils.Scale(res, scaleParams)
 			if err != nil {
-				Info.Printf("Error in the utils.Scale: %v\n", err)
+				reqLog.Infof("Error in the utils.Scale: %v\n", err)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, err.Error(), 500)
 				return
@@ -591,7 +754,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 			if len(norm) == 0 || norm[0].Width == 0 || norm[0].Height == 0 {
 				out, err := utils.GetEmptyTile(conf.Layers[idx].NoDataLegendPath, *params.Height, *params.Width)
 				if err != nil {
-					Info.Printf("Error in the utils.GetEmptyTile(): %v\n", err)
+					reqLog.Infof("Error in the utils.GetEmptyTile(): %v\n", err)
 					metricsCollector.Info.HTTPStatus = 500
 					http.Error(w, err.Error(), 500)
 				} else {
@@ -602,22 +765,27 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 
 			out, err := utils.EncodePNG(norm, palette)
 			if err != nil {
-				Info.Printf("Error in the utils.EncodePNG: %v\n", err)
+				reqLog.Infof("Error in the utils.EncodePNG: %v\n", err)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, err.Error(), 500)
 				return
 			}
 			w.Write(out)
+			if len(cacheKey) > 0 {
+				if err := cache.Put(conf.Layers[idx].Name, cacheKey, out); err != nil {
+					reqLog.Debugf("disk cache: %v", err)
+				}
+			}
 		case err := <-errChan:
-			Info.Printf("Error in the pipeline: %v\n", err)
+			reqLog.Infof("Error in the pipeline: %v\n", err)
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, err.Error(), 500)
 		case <-ctx.Done():
-			Error.Printf("Context cancelled with message: %v\n", ctx.Err())
+			reqLog.Errorf("Context cancelled with message: %v\n", ctx.Err())
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, ctx.Err().Error(), 500)
 		case <-timeoutCtx.Done():
-			Error.Printf("WMS pipeline timed out, threshold:%v seconds", conf.Layers[idx].WmsTimeout)
+			reqLog.Errorf("WMS pipeline timed out, threshold:%v seconds", conf.Layers[idx].WmsTimeout)
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, "WMS request timed out", 500)
 		}
@@ -626,7 +794,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 	case "GetLegendGraphic":
 		idx, err := utils.GetLayerIndex(params, conf)
 		if err != nil {
-			Error.Printf("%s\n", err)
+			reqLog.Errorf("%s\n", err)
 			if len(params.Layers) > 0 {
 				tpl, _ := fileResolver.Lookup("templates/WMS_ServiceException.tpl")
 				utils.ExecuteWriteTemplateFile(w, params.Layers[0], tpl)
@@ -636,9 +804,13 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 			}
 			return
 		}
+		conf.Layers[idx].Deprecation.SetHeaders(w.Header())
+		if !checkAccess(ctx, w, conf.Layers[idx].Name, utils.AccessView, nil, metricsCollector) {
+			return
+		}
 		styleIdx, err := utils.GetLayerStyleIndex(params, conf, idx)
 		if err != nil {
-			Error.Printf("%s\n", err)
+			reqLog.Errorf("%s\n", err)
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, fmt.Sprintf("Malformed WMS GetMap request: %v", err), 400)
 			return
@@ -649,9 +821,22 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 			styleLayer = &conf.Layers[idx].Styles[styleIdx]
 		}
 
+		if params.Format != nil && strings.ToLower(*params.Format) == "application/json" {
+			legend, err := json.Marshal(utils.NewLegendInfo(&conf.Layers[idx], styleLayer))
+			if err != nil {
+				reqLog.Errorf("Error in encoding legend: %v\n", err)
+				metricsCollector.Info.HTTPStatus = 500
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(legend)
+			return
+		}
+
 		b, err := ioutil.ReadFile(styleLayer.LegendPath)
 		if err != nil {
-			Error.Printf("Error reading legend image: %v, %v\n", styleLayer.LegendPath, err)
+			reqLog.Errorf("Error reading legend image: %v, %v\n", styleLayer.LegendPath, err)
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, "Legend graphics not found", 500)
 			return
@@ -666,6 +851,7 @@ func serveWMS(ctx context.Context, params utils.WMSParams, conf *utils.Config, r
 }
 
 func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r *http.Request, w http.ResponseWriter, query map[string][]string, metricsCollector *metrics.MetricsCollector) {
+	reqLog := logging.FromContext(ctx)
 	if params.Request == nil {
 		metricsCollector.Info.HTTPStatus = 400
 		http.Error(w, "Malformed WCS, a Request field needs to be specified", 400)
@@ -695,7 +881,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 		}
 
 		tpl, _ := fileResolver.Lookup("templates/WCS_GetCapabilities.tpl")
-		err := utils.ExecuteWriteTemplateFile(w, &newConf, tpl)
+		err := utils.ExecuteWriteTemplateFile(w, utils.AccessFromContext(ctx).FilterLayers(newConf), tpl)
 		if err != nil {
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, err.Error(), 500)
@@ -704,11 +890,15 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 	case "DescribeCoverage":
 		idx, err := utils.GetCoverageIndex(params, conf)
 		if err != nil {
-			Info.Printf("Error in the pipeline: %v\n", err)
+			reqLog.Infof("Error in the pipeline: %v\n", err)
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, fmt.Sprintf("Malformed WMS DescribeCoverage request: %v", err), 400)
 			return
 		}
+		conf.Layers[idx].Deprecation.SetHeaders(w.Header())
+		if !checkAccess(ctx, w, conf.Layers[idx].Name, utils.AccessView, nil, metricsCollector) {
+			return
+		}
 
 		newConf := conf.Copy(r)
 		newConf.GetLayerDates(idx, *verbose)
@@ -732,6 +922,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 			http.Error(w, fmt.Sprintf("%v: %s", err, reqURL), 400)
 			return
 		}
+		conf.Layers[idx].Deprecation.SetHeaders(w.Header())
 
 		if params.Time == nil {
 			currentTime, err := utils.GetCurrentTimeStamp(conf.Layers[idx].Dates)
@@ -741,6 +932,9 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 				return
 			}
 			params.Time = currentTime
+		} else {
+			t := conf.Layers[idx].RoundTime(*params.Time)
+			params.Time = &t
 		}
 		if params.CRS == nil {
 			metricsCollector.Info.HTTPStatus = 400
@@ -770,16 +964,20 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 			endTime = &eT
 		}
 
+		if !checkAccess(ctx, w, conf.Layers[idx].Name, utils.AccessDownload, accessScope(params.CRS, params.BBox, params.Time, endTime), metricsCollector) {
+			return
+		}
+
 		styleIdx, err := utils.GetCoverageStyleIndex(params, conf, idx)
 		if err != nil {
-			Error.Printf("%s\n", err)
+			reqLog.Errorf("%s\n", err)
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, fmt.Sprintf("Malformed WCS GetCoverage request: %v", err), 400)
 			return
 		} else if styleIdx < 0 {
 			styleCount := len(conf.Layers[idx].Styles)
 			if styleCount > 1 && params.BandExpr == nil {
-				Error.Printf("WCS style not specified")
+				reqLog.Errorf("WCS style not specified")
 				metricsCollector.Info.HTTPStatus = 400
 				http.Error(w, "WCS style not specified", 400)
 				return
@@ -794,7 +992,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 		}
 
 		if utils.CheckDisableServices(styleLayer, "wcs") {
-			Error.Printf("WCS GetCoverage is disabled for this layer")
+			reqLog.Errorf("WCS GetCoverage is disabled for this layer")
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, "WCS GetCoverage is disabled for this layer", 400)
 			return
@@ -803,7 +1001,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 		if params.BandExpr != nil {
 			err := utils.CheckBandExpressionsComplexity(params.BandExpr, conf.Layers[idx].WcsBandExpressionCriteria)
 			if err != nil {
-				Error.Printf("%s\n", err)
+				reqLog.Errorf("%s\n", err)
 				metricsCollector.Info.HTTPStatus = 400
 				http.Error(w, fmt.Sprintf("Malformed WCS GetCoverage request: %v", err), 400)
 				return
@@ -820,6 +1018,14 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 
 		_, isWorker := query["wbbox"]
 
+		nativeCRS, err := conf.Layers[idx].NativeCRSWKT()
+		if err != nil {
+			reqLog.Errorf("%v\n", err)
+			metricsCollector.Info.HTTPStatus = 500
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
 		getGeoTileRequest := func(width int, height int, bbox []float64, offX int, offY int) *proc.GeoTileRequest {
 			geoReq := &proc.GeoTileRequest{ConfigPayLoad: proc.ConfigPayLoad{NameSpaces: styleLayer.RGBExpressions.VarList,
 				BandExpr: styleLayer.RGBExpressions,
@@ -834,6 +1040,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 				GrpcConcLimit:       conf.Layers[idx].GrpcWcsConcPerNode,
 				QueryLimit:          -1,
 				UserSrcSRS:          conf.Layers[idx].UserSrcSRS,
+				NativeCRS:           nativeCRS,
 				UserSrcGeoTransform: conf.Layers[idx].UserSrcGeoTransform,
 				NoReprojection:      params.NoReprojection,
 				AxisMapping:         params.AxisMapping,
@@ -846,6 +1053,10 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 				MasQueryHint:        conf.Layers[idx].MasQueryHint,
 				SRSCf:               conf.Layers[idx].SRSCf,
 				FusionUnscale:       1,
+				StreamingMerge:      conf.Layers[idx].StreamingMerge,
+				GrpcChecksum:        conf.ServiceConfig.GrpcChecksum,
+				WarpBackend:         conf.Layers[idx].WarpBackend,
+				ResampleAlg:         conf.Layers[idx].Resampling,
 				MetricsCollector:    metricsCollector,
 			},
 				Collection: styleLayer.DataSource,
@@ -883,6 +1094,12 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 		defer ctxCancel()
 		errChan := make(chan error, 100)
 
+		if utils.IsCustomCRS(*params.CRS) {
+			metricsCollector.Info.HTTPStatus = 400
+			http.Error(w, fmt.Sprintf("WCS GetCoverage does not support the custom CRS %s", *params.CRS), 400)
+			return
+		}
+
 		epsg, err := utils.ExtractEPSGCode(*params.CRS)
 		if err != nil {
 			metricsCollector.Info.HTTPStatus = 400
@@ -893,7 +1110,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 		if *params.Width <= 0 || *params.Height <= 0 {
 			if isWorker {
 				msg := "WCS: worker width or height negative"
-				Info.Printf(msg)
+				reqLog.Infof(msg)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, msg, 500)
 				return
@@ -902,7 +1119,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 			geoReq := getGeoTileRequest(0, 0, params.BBox, 0, 0)
 			maxWidth, maxHeight, err := proc.ComputeReprojectionExtent(ctx, geoReq, conf.ServiceConfig.MASAddress, conf.ServiceConfig.WorkerNodes, epsg, params.BBox, *verbose)
 			if *verbose {
-				Info.Printf("WCS: Output image size: width=%v, height=%v", maxWidth, maxHeight)
+				reqLog.Infof("WCS: Output image size: width=%v, height=%v", maxWidth, maxHeight)
 			}
 			if maxWidth > 0 && maxHeight > 0 {
 				*params.Width = maxWidth
@@ -917,7 +1134,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 				reqURL += fmt.Sprintf("&width=%d&height=%d", maxWidth, maxHeight)
 			} else {
 				errMsg := "WCS: failed to compute output extent"
-				Info.Printf(errMsg, err)
+				reqLog.Infof(errMsg, err)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, errMsg, 500)
 				return
@@ -956,14 +1173,14 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 					parsedURL, err := url.Parse(worker)
 					if err != nil {
 						if *verbose {
-							Info.Printf("WCS: invalid worker hostname %v, (%v of %v)\n", worker, iw, len(conf.ServiceConfig.OWSClusterNodes))
+							reqLog.Infof("WCS: invalid worker hostname %v, (%v of %v)\n", worker, iw, len(conf.ServiceConfig.OWSClusterNodes))
 						}
 						continue
 					}
 
 					if parsedURL.Host == conf.ServiceConfig.OWSHostname {
 						if *verbose {
-							Info.Printf("WCS: skipping worker whose hostname == OWSHostName %v, (%v of %v)\n", worker, iw, len(conf.ServiceConfig.OWSClusterNodes))
+							reqLog.Infof("WCS: skipping worker whose hostname == OWSHostName %v, (%v of %v)\n", worker, iw, len(conf.ServiceConfig.OWSClusterNodes))
 						}
 						continue
 					}
@@ -1059,24 +1276,30 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 				}
 
 				if *verbose {
-					Info.Printf("WCS worker (%v of %v): %v\n", iw, len(workerTileRequests)-1, queryURL)
+					reqLog.Infof("WCS worker (%v of %v): %v\n", iw, len(workerTileRequests)-1, queryURL)
 				}
 
 				trans := &http.Transport{}
 				req, err := http.NewRequest("GET", queryURL, nil)
 				if err != nil {
 					errMsg := fmt.Sprintf("WCS: worker NewRequest error: %v", err)
-					Info.Printf(errMsg)
+					reqLog.Infof(errMsg)
 					metricsCollector.Info.HTTPStatus = 500
 					http.Error(w, errMsg, 500)
 					return
 				}
+				if key := tokens.FromRequest(r); len(key) > 0 {
+					req.Header.Set(utils.APIKeyHeader, key)
+				}
+				if secret := conf.ServiceConfig.OWSClusterSecret; len(secret) > 0 {
+					req.Header.Set(clusterSecretHeader, secret)
+				}
 				defer trans.CancelRequest(req)
 
 				tempFileHandle, err := ioutil.TempFile(conf.ServiceConfig.TempDir, "worker_raster_")
 				if err != nil {
 					errMsg := fmt.Sprintf("WCS: failed to create raster temp file for WCS worker: %v", err)
-					Info.Printf(errMsg)
+					reqLog.Infof(errMsg)
 					metricsCollector.Info.HTTPStatus = 500
 					http.Error(w, errMsg, 500)
 					return
@@ -1130,7 +1353,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 		tp := proc.InitTilePipeline(ctx, styleLayer.MASAddress, conf.ServiceConfig.WorkerNodes, conf.Layers[idx].MaxGrpcRecvMsgSize, conf.Layers[idx].WcsPolygonShardConcLimit, conf.ServiceConfig.MaxGrpcBufferSize, errChan)
 		for ir, geoReq := range workerTileRequests[0] {
 			if *verbose {
-				Info.Printf("WCS: processing tile (%d of %d): xOff:%v, yOff:%v, width:%v, height:%v", ir+1, len(workerTileRequests[0]), geoReq.OffX, geoReq.OffY, geoReq.Width, geoReq.Height)
+				reqLog.Infof("WCS: processing tile (%d of %d): xOff:%v, yOff:%v, width:%v, height:%v", ir+1, len(workerTileRequests[0]), geoReq.OffX, geoReq.OffY, geoReq.Width, geoReq.Height)
 			}
 
 			hasOverview := len(styleLayer.Overviews) > 0
@@ -1143,7 +1366,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 						geoReq.Overview = &styleLayer.Overviews[iOvr]
 					}
 				} else if *verbose {
-					Info.Printf("WCS: processing tile (%d of %d): %v", ir+1, len(workerTileRequests[0]), err)
+					reqLog.Infof("WCS: processing tile (%d of %d): %v", ir+1, len(workerTileRequests[0]), err)
 				}
 			}
 
@@ -1161,7 +1384,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 					if err != nil {
 						utils.RemoveGdalTempFile(masterTempFile)
 						errMsg := fmt.Sprintf("EncodeGdalOpen() failed: %v", err)
-						Info.Printf(errMsg)
+						reqLog.Infof(errMsg)
 						metricsCollector.Info.HTTPStatus = 500
 						http.Error(w, errMsg, 500)
 						return
@@ -1174,7 +1397,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 
 				bn, err := utils.EncodeGdal(hDstDS, res, geoReq.OffX, geoReq.OffY)
 				if err != nil {
-					Info.Printf("Error in the utils.EncodeGdal: %v\n", err)
+					reqLog.Infof("Error in the utils.EncodeGdal: %v\n", err)
 					metricsCollector.Info.HTTPStatus = 500
 					http.Error(w, err.Error(), 500)
 					return
@@ -1182,22 +1405,22 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 				bandNames = bn
 
 			case err := <-errChan:
-				Info.Printf("WCS: error in the pipeline: %v\n", err)
+				reqLog.Infof("WCS: error in the pipeline: %v\n", err)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, err.Error(), 500)
 				return
 			case err := <-workerErrChan:
-				Info.Printf("WCS worker error: %v\n", err)
+				reqLog.Infof("WCS worker error: %v\n", err)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, err.Error(), 500)
 				return
 			case <-ctx.Done():
-				Error.Printf("Context cancelled with message: %v\n", ctx.Err())
+				reqLog.Errorf("Context cancelled with message: %v\n", ctx.Err())
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, ctx.Err().Error(), 500)
 				return
 			case <-timeoutCtx.Done():
-				Error.Printf("WCS pipeline timed out, threshold:%v seconds", conf.Layers[idx].WcsTimeout)
+				reqLog.Errorf("WCS pipeline timed out, threshold:%v seconds", conf.Layers[idx].WcsTimeout)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, "WCS pipeline timed out", 500)
 				return
@@ -1233,7 +1456,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 					}
 					err := utils.EncodeGdalMerge(ctx, hDstDS, "geotiff", workerTempFileName, width, height, offX, offY)
 					if err != nil {
-						Info.Printf("%v\n", err)
+						reqLog.Infof("%v\n", err)
 						metricsCollector.Info.HTTPStatus = 500
 						http.Error(w, err.Error(), 500)
 						return
@@ -1243,19 +1466,19 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 
 					if *verbose {
 						t1 := time.Since(t0)
-						Info.Printf("WCS: merge %v to %v done (%v of %v), time: %v", workerTempFileName, masterTempFile, nWorkerDone, len(workerTileRequests)-1, t1)
+						reqLog.Infof("WCS: merge %v to %v done (%v of %v), time: %v", workerTempFileName, masterTempFile, nWorkerDone, len(workerTileRequests)-1, t1)
 					}
 
 					if nWorkerDone == len(workerTileRequests)-1 {
 						allWorkerDone = true
 					}
 				case err := <-workerErrChan:
-					Info.Printf("%v\n", err)
+					reqLog.Infof("%v\n", err)
 					metricsCollector.Info.HTTPStatus = 500
 					http.Error(w, err.Error(), 500)
 					return
 				case <-ctx.Done():
-					Error.Printf("Context cancelled with message: %v\n", ctx.Err())
+					reqLog.Errorf("Context cancelled with message: %v\n", ctx.Err())
 					metricsCollector.Info.HTTPStatus = 500
 					http.Error(w, ctx.Err().Error(), 500)
 					return
@@ -1274,7 +1497,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 			err := utils.EncodeDap4(w, masterTempFile, bandNames, *verbose)
 			if err != nil {
 				errMsg := fmt.Sprintf("DAP: error: %v", err)
-				Info.Printf(errMsg)
+				reqLog.Infof(errMsg)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, errMsg, 500)
 			}
@@ -1303,7 +1526,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 		fileHandle, err := os.Open(masterTempFile)
 		if err != nil {
 			errMsg := fmt.Sprintf("Error opening raster file: %v", err)
-			Info.Printf(errMsg)
+			reqLog.Infof(errMsg)
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, errMsg, 500)
 		}
@@ -1312,7 +1535,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 		fileInfo, err := fileHandle.Stat()
 		if err != nil {
 			errMsg := fmt.Sprintf("file stat() failed: %v", err)
-			Info.Printf(errMsg)
+			reqLog.Infof(errMsg)
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, errMsg, 500)
 		}
@@ -1321,13 +1544,13 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 		bytesSent, err := io.Copy(w, fileHandle)
 		if err != nil {
 			errMsg := fmt.Sprintf("SendFile failed: %v", err)
-			Info.Printf(errMsg)
+			reqLog.Infof(errMsg)
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, errMsg, 500)
 		}
 
 		if *verbose {
-			Info.Printf("WCS: file_size:%v, bytes_sent:%v\n", fileInfo.Size(), bytesSent)
+			reqLog.Infof("WCS: file_size:%v, bytes_sent:%v\n", fileInfo.Size(), bytesSent)
 		}
 
 		return
@@ -1339,6 +1562,7 @@ func serveWCS(ctx context.Context, params utils.WCSParams, conf *utils.Config, r
 }
 
 func serveWPS(ctx context.Context, params utils.WPSParams, conf *utils.Config, r *http.Request, w http.ResponseWriter, metricsCollector *metrics.MetricsCollector) {
+	reqLog := logging.FromContext(ctx)
 	if params.Request == nil {
 		metricsCollector.Info.HTTPStatus = 400
 		http.Error(w, "Malformed WPS, a Request field needs to be specified", 400)
@@ -1351,7 +1575,7 @@ func serveWPS(ctx context.Context, params utils.WPSParams, conf *utils.Config, r
 	case "GetCapabilities":
 		newConf := conf.Copy(r)
 		tpl, _ := fileResolver.Lookup("templates/WPS_GetCapabilities.tpl")
-		err := utils.ExecuteWriteTemplateFile(w, newConf, tpl)
+		err := utils.ExecuteWriteTemplateFile(w, utils.AccessFromContext(ctx).FilterLayers(newConf), tpl)
 		if err != nil {
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, err.Error(), 500)
@@ -1359,12 +1583,15 @@ func serveWPS(ctx context.Context, params utils.WPSParams, conf *utils.Config, r
 	case "DescribeProcess":
 		idx, err := utils.GetProcessIndex(params, conf)
 		if err != nil {
-			Error.Printf("Requested process not found: %v, %v\n", err, reqURL)
+			reqLog.Errorf("Requested process not found: %v, %v\n", err, reqURL)
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, fmt.Sprintf("%v: %s", err, reqURL), 400)
 			return
 		}
 		process := conf.Processes[idx]
+		if !checkAccess(ctx, w, process.Identifier, utils.AccessProcess, nil, metricsCollector) {
+			return
+		}
 		tpl, _ := fileResolver.Lookup("templates/WPS_DescribeProcess.tpl")
 		err = utils.ExecuteWriteTemplateFile(w, process, tpl)
 		if err != nil {
@@ -1374,21 +1601,21 @@ func serveWPS(ctx context.Context, params utils.WPSParams, conf *utils.Config, r
 	case "Execute":
 		idx, err := utils.GetProcessIndex(params, conf)
 		if err != nil {
-			Error.Printf("Requested process not found: %v, %v\n", err, reqURL)
+			reqLog.Errorf("Requested process not found: %v, %v\n", err, reqURL)
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, fmt.Sprintf("%v: %s", err, reqURL), 400)
 			return
 		}
 		process := conf.Processes[idx]
 		if len(process.DataSources) == 0 {
-			Error.Printf("No data source specified")
+			reqLog.Errorf("No data source specified")
 			metricsCollector.Info.HTTPStatus = 500
 			http.Error(w, "No data source specified", 500)
 			return
 		}
 
 		if len(params.FeatCol.Features) == 0 {
-			Info.Printf("The request does not contain the 'feature' property.\n")
+			reqLog.Infof("The request does not contain the 'feature' property.\n")
 			metricsCollector.Info.HTTPStatus = 400
 			http.Error(w, "The request does not contain the 'feature' property", 400)
 			return
@@ -1396,6 +1623,9 @@ func serveWPS(ctx context.Context, params utils.WPSParams, conf *utils.Config, r
 
 		var feat []byte
 		geom := params.FeatCol.Features[0].Geometry
+		if !checkAccess(ctx, w, process.Identifier, utils.AccessProcess, wpsAccessScope(params, geom), metricsCollector) {
+			return
+		}
 		switch geom := geom.(type) {
 
 		case *geo.Point:
@@ -1408,7 +1638,7 @@ func serveWPS(ctx context.Context, params utils.WPSParams, conf *utils.Config, r
 				log.Println("Requested polygon has an area of", area)
 			}
 			if area == 0.0 || area > process.MaxArea {
-				Info.Printf("The requested area %.02f, is too large.\n", area)
+				reqLog.Infof("The requested area %.02f, is too large.\n", area)
 				metricsCollector.Info.HTTPStatus = 400
 				http.Error(w, "The requested area is too large. Please try with a smaller one.", 400)
 				return
@@ -1509,22 +1739,24 @@ func serveWPS(ctx context.Context, params utils.WPSParams, conf *utils.Config, r
 			}
 
 			geoReq := proc.GeoDrillRequest{Geometry: string(feat),
-				CRS:              "EPSG:4326",
-				Collection:       dataSource.DataSource,
-				NameSpaces:       dataSource.RGBExpressions.VarList,
-				BandExpr:         dataSource.RGBExpressions,
-				Mask:             dataSource.Mask,
-				VRTURL:           dataSource.VRTURL,
-				StartTime:        startDateTime,
-				EndTime:          endDateTime,
-				ClipUpper:        clipUpper,
-				ClipLower:        clipLower,
-				RasterXSize:      dataSource.RasterXSize,
-				RasterYSize:      dataSource.RasterYSize,
-				GrpcConcLimit:    dataSource.GrpcWpsConcPerNode,
-				IndexTileXSize:   dataSource.IndexTileXSize,
-				IndexTileYSize:   dataSource.IndexTileYSize,
-				MetricsCollector: metricsCollector,
+				CRS:                   "EPSG:4326",
+				Collection:            dataSource.DataSource,
+				NameSpaces:            dataSource.RGBExpressions.VarList,
+				BandExpr:              dataSource.RGBExpressions,
+				Mask:                  dataSource.Mask,
+				VRTURL:                dataSource.VRTURL,
+				StartTime:             startDateTime,
+				EndTime:               endDateTime,
+				ClipUpper:             clipUpper,
+				ClipLower:             clipLower,
+				RasterXSize:           dataSource.RasterXSize,
+				RasterYSize:           dataSource.RasterYSize,
+				GrpcConcLimit:         dataSource.GrpcWpsConcPerNode,
+				IndexTileXSize:        dataSource.IndexTileXSize,
+				IndexTileYSize:        dataSource.IndexTileYSize,
+				PolygonShardConcLimit: dataSource.WpsPolygonShardConcLimit,
+				BandBatchSize:         dataSource.WpsBandBatchSize,
+				MetricsCollector:      metricsCollector,
 			}
 
 			dp := proc.InitDrillPipeline(ctx, conf.ServiceConfig.MASAddress, conf.ServiceConfig.WorkerNodes, process.IdentityTol, process.DpTol, errChan)
@@ -1538,17 +1770,17 @@ func serveWPS(ctx context.Context, params utils.WPSParams, conf *utils.Config, r
 			case res := <-proc:
 				result.WriteString(res)
 			case err := <-errChan:
-				Info.Printf("Error in the pipeline: %v\n", err)
+				reqLog.Infof("Error in the pipeline: %v\n", err)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, err.Error(), 500)
 				return
 			case <-ctx.Done():
-				Error.Printf("Context cancelled with message: %v\n", ctx.Err())
+				reqLog.Errorf("Context cancelled with message: %v\n", ctx.Err())
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, ctx.Err().Error(), 500)
 				return
 			case <-timeoutCtx.Done():
-				Error.Printf("WPS pipeline timed out, threshold:%v seconds", process.WpsTimeout)
+				reqLog.Errorf("WPS pipeline timed out, threshold:%v seconds", process.WpsTimeout)
 				metricsCollector.Info.HTTPStatus = 500
 				http.Error(w, "WPS request timed out", 500)
 				return
@@ -1588,8 +1820,9 @@ func getMASAddress() (string, error) {
 			return "", err
 		}
 		masAddress = rootConfig.ServiceConfig.MASAddress
-		confMap["."] = rootConfig
-		configMap.Store("config", confMap)
+		utils.UpdateConfigMap(configMap, func(confMap map[string]*utils.Config) {
+			confMap["."] = rootConfig
+		})
 	}
 
 	masAddress = strings.TrimSpace(masAddress)
@@ -1599,14 +1832,46 @@ func getMASAddress() (string, error) {
 	return masAddress, nil
 }
 
+// traceOWSRequest names the server span of the request after the OWS
+// operation and records the requested layers.
+func traceOWSRequest(ctx context.Context, query map[string][]string) {
+	span := tracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	service := query["service"][0]
+	span.SetAttribute("ows.service", service)
+	if request, ok := query["request"]; ok && len(request) > 0 {
+		span.SetName(service + " " + request[0])
+		span.SetAttribute("ows.request", request[0])
+	}
+	for _, key := range []string{"layers", "coverage", "identifier", "time", "crs", "srs"} {
+		if values, ok := query[key]; ok && len(values) > 0 {
+			span.SetAttribute("ows."+key, values[0])
+		}
+	}
+}
+
 // owsHandler handles every request received on /ows
-func generalHandler(conf *utils.Config, w http.ResponseWriter, r *http.Request) {
+func generalHandler(conf *utils.Config, namespace string, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, max-age=0")
+
+	reqLog := logging.New("ows").WithRequest(w, r)
+	if span := tracing.SpanFromContext(r.Context()); span != nil {
+		reqLog = reqLog.With("trace_id", span.TraceIDString())
+	}
+	if access := utils.AccessFromContext(r.Context()); access != nil && len(access.User) > 0 {
+		reqLog = reqLog.With("user", access.User)
+	}
+	if access := utils.AccessFromContext(r.Context()); access != nil && access.Token != nil {
+		reqLog = reqLog.With("token", access.Token.ID)
+	}
+	ctx := logging.NewContext(r.Context(), reqLog)
+	ctx = logging.WithRequestID(ctx, w.Header().Get(logging.RequestIDHeader))
 	if *verbose {
-		Info.Printf("%s\n", r.URL.String())
+		reqLog.Infof("%s", r.URL.String())
 	}
-	ctx := r.Context()
 
 	metricsCollector := metrics.NewMetricsCollector(metricsLogger)
 	defer metricsCollector.Log()
@@ -1646,6 +1911,34 @@ func generalHandler(conf *utils.Config, w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	if err := utils.CheckQueryValues(query); err != nil {
+		metricsCollector.Info.HTTPStatus = 400
+		http.Error(w, fmt.Sprintf("Malformed request: %v", err), 400)
+		return
+	}
+
+	worker := clusterWorkerRequest(conf, r, query)
+	w, recordAudit := startAudit(ctx, w, r, namespace, query, worker)
+	defer recordAudit()
+	w, recordUsage := startUsage(ctx, w, r, conf, namespace, query, worker)
+	defer recordUsage()
+	defer recordSLO(conf, query, t0, metricsCollector.Info)
+	defer recordTelemetry(query, metricsCollector.Info)
+
+	if shedRequest(ctx, w, query, metricsCollector) {
+		return
+	}
+
+	release, allowed := limitRequest(ctx, w, r, query, metricsCollector)
+	if !allowed {
+		return
+	}
+	defer release()
+
+	if !checkQuota(ctx, w, r, query, worker, metricsCollector) {
+		return
+	}
+
 	if _, fOK := query["dap4.ce"]; fOK {
 		if len(query["dap4.ce"]) == 0 {
 			metricsCollector.Info.HTTPStatus = 400
@@ -1682,6 +1975,9 @@ func generalHandler(conf *utils.Config, w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	traceOWSRequest(ctx, query)
+	defer owsProm.observeRequest(query, t0, metricsCollector.Info)
+
 	switch query["service"][0] {
 	case "WMS":
 		params, err := utils.WMSParamsChecker(query, reWMSMap)
@@ -1726,16 +2022,49 @@ func generalHandler(conf *utils.Config, w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func owsHandler(w http.ResponseWriter, r *http.Request) {
+// owsNameSpace returns the namespace of an OWS request path under
+// prefix, e.g. /ows/
+func owsNameSpace(prefix string, urlPath string) string {
 	namespace := "."
-	if len(r.URL.Path) > len("/ows/") {
-		namespace = r.URL.Path[len("/ows/"):]
+	if len(urlPath) > len(prefix) {
+		namespace = urlPath[len(prefix):]
 		dapExt := ".dap"
 		if len(namespace) >= len(dapExt) && namespace[len(namespace)-len(dapExt):] == dapExt {
 			namespace = namespace[:len(namespace)-len(dapExt)]
 		}
 	}
+	return namespace
+}
+
+func owsHandler(w http.ResponseWriter, r *http.Request) {
+	namespace := owsNameSpace("/ows/", r.URL.Path)
 	confMap := getConfigMap()
+
+	var vhost *utils.VirtualHost
+	if rootConfig, found := confMap["."]; found && rootConfig != nil && len(rootConfig.ServiceConfig.VirtualHosts) > 0 {
+		vhost = rootConfig.ServiceConfig.FindVirtualHost(r.Host)
+		if vhost != nil {
+			ns, err := vhost.ResolveNameSpace(namespace)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid dataset namespace: %v\n", namespace), 404)
+				return
+			}
+			namespace = ns
+		}
+		if !rootConfig.ServiceConfig.IsNameSpaceAllowed(namespace, vhost) {
+			if *verbose {
+				log.Printf("owsHandler: namespace %v is restricted for host %v", namespace, r.Host)
+			}
+			http.Error(w, fmt.Sprintf("Invalid dataset namespace: %v\n", namespace), 404)
+			return
+		}
+	}
+
+	r, authorised := authoriseOWS(w, r, confMap, namespace)
+	if !authorised {
+		return
+	}
+
 	config, ok := confMap[namespace]
 	if !ok || config == nil {
 		namespaceErr := func(err error) {
@@ -1782,14 +2111,54 @@ func owsHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-		for k, v := range conf {
-			utils.PostprocessServiceConfig(v, confMap, *verbose)
-			confMap[k] = v
-		}
-		configMap.Store("config", confMap)
+		utils.UpdateConfigMap(configMap, func(confMap map[string]*utils.Config) {
+			for k, v := range conf {
+				utils.PostprocessServiceConfig(v, confMap, *verbose)
+				confMap[k] = v
+			}
+		})
 		config, _ = conf[namespace]
 	}
-	generalHandler(config, w, r)
+	generalHandler(utils.ApplyVirtualHost(config, vhost), namespace, w, r)
+}
+
+// stagingHandler serves the staged candidate configs under the
+// staging path with the same namespaces as /ows.
+func stagingHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := "/" + strings.Trim(*stagingPath, "/") + "/"
+	namespace := owsNameSpace(prefix, r.URL.Path)
+
+	confMap, stagingDir, found := utils.StagedConfigs.ConfigMap()
+	if !found {
+		http.Error(w, "No staged config\n", 404)
+		return
+	}
+
+	r, authorised := authoriseOWS(w, r, confMap, namespace)
+	if !authorised {
+		return
+	}
+
+	config, ok := confMap[namespace]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid dataset namespace: %v\n", namespace), 404)
+		return
+	}
+	if config == nil {
+		conf, err := utils.LoadConfigOnDemand(stagingDir, namespace, *verbose)
+		if err != nil {
+			Info.Printf("Invalid staged namespace: %v for url: %v, err: %v\n", namespace, r.URL.Path, err)
+			http.Error(w, fmt.Sprintf("Invalid dataset namespace: %v\n", namespace), 404)
+			return
+		}
+		config = conf[namespace]
+		if config == nil {
+			http.Error(w, fmt.Sprintf("Invalid dataset namespace: %v\n", namespace), 404)
+			return
+		}
+		utils.PostprocessServiceConfig(config, confMap, *verbose)
+	}
+	generalHandler(config, namespace, w, r)
 }
 
 func fileHandler(w http.ResponseWriter, r *http.Request) {
@@ -1907,13 +2276,62 @@ func cataloguesHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	initServer()
+
+	if flag.NArg() > 0 && flag.Arg(0) == "selftest" {
+		runSelfTest(flag.Args()[1:])
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "bench" {
+		runBench(flag.Args()[1:])
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "seed" {
+		runSeed(flag.Args()[1:])
+	}
+
+	ows := owsHandler
+	if *metricsPort > 0 {
+		owsProm = newOWSMetrics()
+		ows = owsProm.http.InstrumentFunc("ows", owsHandler)
+		go prom.ListenAndServe(owsProm.registry, "ows", *metricsPort)
+	}
+
 	http.HandleFunc("/", fileHandler)
-	http.HandleFunc("/ows", owsHandler)
-	http.HandleFunc("/ows/", owsHandler)
+	http.HandleFunc("/ows", tracing.HandlerFunc("ows", ows))
+	http.HandleFunc("/ows/", tracing.HandlerFunc("ows", ows))
 	http.HandleFunc(fmt.Sprintf("/%s", utils.CatalogueDirName), cataloguesHandler)
 	http.HandleFunc(fmt.Sprintf("/%s/", utils.CatalogueDirName), cataloguesHandler)
+	http.HandleFunc("/sign", signHandler)
+	http.HandleFunc("/admin/config/history", configHistoryHandler)
+	http.HandleFunc("/admin/config/rollback", configRollbackHandler)
+	http.HandleFunc("/admin/config/effective", effectiveConfigHandler)
+	http.HandleFunc("/admin/config/stage", configStageHandler)
+	http.HandleFunc("/admin/config/promote", configPromoteHandler)
+	http.HandleFunc("/admin/status", statusHandler)
+	http.HandleFunc("/admin/tokens", tokensHandler)
+	http.HandleFunc("/admin/slo", sloHandler)
+	http.HandleFunc("/admin/seed", seedHandler)
+	http.HandleFunc("/admin/usage", usageHandler)
+	http.HandleFunc("/admin/telemetry", telemetryHandler)
+	lifecycle.Default.Register(http.DefaultServeMux)
+	if len(strings.Trim(*stagingPath, "/")) > 0 {
+		staging := "/" + strings.Trim(*stagingPath, "/")
+		http.HandleFunc(staging, tracing.HandlerFunc("ows-staging", stagingHandler))
+		http.HandleFunc(staging+"/", tracing.HandlerFunc("ows-staging", stagingHandler))
+	}
+
+	go scheduleTileSeeding()
 
 	listeningHost := fmt.Sprintf("0.0.0.0:%d", *port)
 	Info.Printf("GSKY is listening on %s", listeningHost)
-	log.Fatal(http.ListenAndServe(listeningHost, nil))
+	srv := &http.Server{Addr: listeningHost, Handler: securityHandler(http.DefaultServeMux)}
+	if err := lifecycle.Default.RunHTTP(srv, time.Duration(*drainDelay)*time.Second, time.Duration(*shutdownTimeout)*time.Second); err != nil {
+		log.Fatal(err)
+	}
+	Info.Printf("GSKY has stopped")
+	if usageRecorder != nil {
+		if err := usageRecorder.Flush(); err != nil {
+			Error.Printf("Error in flushing the usage counts: %v", err)
+		}
+	}
+	tracing.Shutdown()
 }
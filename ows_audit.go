@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nci/gsky/audit"
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/utils"
+)
+
+var auditLog audit.Log
+
+// auditWriter counts the bytes returned by an audited request.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *auditWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *auditWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// auditedRequest returns the service, operation and layer of the data
+// requests recorded in the audit trail: WCS GetCoverage, DAP and WPS
+// Execute.
+func auditedRequest(query map[string][]string) (service, operation, layer string, audited bool) {
+	first := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	if ceStr := first("dap4.ce"); len(ceStr) > 0 {
+		if ce, err := utils.ParseDap4ConstraintExpr(ceStr); err == nil {
+			layer = ce.Dataset
+		}
+		return "DAP", "dap4", layer, true
+	}
+	switch operation = first("request"); operation {
+	case "GetCoverage":
+		return "WCS", operation, first("coverage"), true
+	case "Execute":
+		return "WPS", operation, first("identifier"), true
+	}
+	return "", "", "", false
+}
+
+// startAudit returns the response writer counting the bytes of an
+// audited request and the function recording it once served. The
+// writer is returned unchanged if the request is not audited, e.g. the
+// request of the tiles of a GetCoverage of another OWS cluster node,
+// worker, part of the request recorded by that node.
+func startAudit(ctx context.Context, w http.ResponseWriter, r *http.Request, namespace string, query map[string][]string, worker bool) (http.ResponseWriter, func()) {
+	if auditLog == nil || worker {
+		return w, func() {}
+	}
+	service, operation, layer, audited := auditedRequest(query)
+	if !audited {
+		return w, func() {}
+	}
+
+	t0 := time.Now()
+	aw := &auditWriter{ResponseWriter: w}
+	return aw, func() {
+		rec := &audit.Record{
+			Time:       t0.UTC(),
+			RequestID:  w.Header().Get(logging.RequestIDHeader),
+			RemoteAddr: clientIP(r),
+			PeerAddr:   r.RemoteAddr,
+			NameSpace:  namespace,
+			Service:    service,
+			Operation:  operation,
+			Layer:      layer,
+			Params:     audit.Params(query),
+			Bytes:      aw.bytes,
+			Status:     aw.status,
+			DurationMS: time.Since(t0).Milliseconds(),
+		}
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		if access := utils.AccessFromContext(r.Context()); access != nil {
+			rec.User = access.User
+			if access.Token != nil {
+				rec.Token = access.Token.ID
+			}
+		}
+		if err := auditLog.Write(rec); err != nil {
+			logging.FromContext(ctx).Errorf("audit trail: %v", err)
+		}
+	}
+}
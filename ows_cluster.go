@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nci/gsky/utils"
+)
+
+// clusterSecretHeader carries the ows_cluster_secret with the requests
+// of the tiles of a WCS GetCoverage sent to the other OWS cluster nodes.
+const clusterSecretHeader = "X-Gsky-Cluster-Secret"
+
+// clusterNodesTTL is how long the addresses of the ows_cluster_nodes are
+// cached.
+const clusterNodesTTL = time.Minute
+
+// clusterWorkerRequest reports whether a request is that of the tiles of
+// a WCS GetCoverage of another OWS cluster node, of the wbbox parameters,
+// its bytes being counted, limited and audited with the request of that
+// node. The worker parameters of the other clients are not trusted: the
+// request must carry the ows_cluster_secret, or come directly from one
+// of the ows_cluster_nodes.
+func clusterWorkerRequest(conf *utils.Config, r *http.Request, query map[string][]string) bool {
+	if _, isWorker := query["wbbox"]; !isWorker || conf == nil {
+		return false
+	}
+	if secret := conf.ServiceConfig.OWSClusterSecret; len(secret) > 0 {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(clusterSecretHeader)), []byte(secret)) == 1 {
+			return true
+		}
+	}
+	return clusterNodes.contains(conf.ServiceConfig.OWSClusterNodes, utils.ClientIP(r, nil))
+}
+
+// clusterNodeAddrs caches the IP addresses of the ows_cluster_nodes.
+type clusterNodeAddrs struct {
+	mu       sync.Mutex
+	key      string
+	ips      map[string]bool
+	resolved time.Time
+}
+
+var clusterNodes = &clusterNodeAddrs{}
+
+// contains reports whether the IP address is that of one of the nodes,
+// their host names being resolved at most every clusterNodesTTL.
+func (a *clusterNodeAddrs) contains(nodes []string, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil || len(nodes) == 0 {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := strings.Join(nodes, ",")
+	if key != a.key || time.Since(a.resolved) > clusterNodesTTL {
+		a.ips = make(map[string]bool)
+		for _, node := range nodes {
+			parsedURL, err := url.Parse(node)
+			if err != nil {
+				continue
+			}
+			host := parsedURL.Hostname()
+			if nodeIP := net.ParseIP(host); nodeIP != nil {
+				a.ips[nodeIP.String()] = true
+				continue
+			}
+			hostAddrs, err := net.LookupHost(host)
+			if err != nil {
+				continue
+			}
+			for _, hostAddr := range hostAddrs {
+				if nodeIP := net.ParseIP(hostAddr); nodeIP != nil {
+					a.ips[nodeIP.String()] = true
+				}
+			}
+		}
+		a.key = key
+		a.resolved = time.Now()
+	}
+	return a.ips[addr.String()]
+}
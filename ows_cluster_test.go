@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nci/gsky/utils"
+)
+
+func TestClusterWorkerRequest(t *testing.T) {
+	conf := &utils.Config{}
+	conf.ServiceConfig.OWSClusterNodes = []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"}
+	worker := map[string][]string{"request": {"GetCoverage"}, "wbbox": {"0,0,1,1"}}
+	front := map[string][]string{"request": {"GetCoverage"}}
+
+	tests := []struct {
+		peer   string
+		secret string
+		xff    string
+		query  map[string][]string
+		worker bool
+	}{
+		{"10.0.0.2:51000", "", "", worker, true},
+		{"10.0.0.3:51000", "", "", worker, true},
+		{"10.0.0.2:51000", "", "", front, false},
+		// The worker parameters of other clients are not trusted.
+		{"192.0.2.1:51000", "", "", worker, false},
+		{"192.0.2.1:51000", "", "10.0.0.2", worker, false},
+		{"192.0.2.1:51000", "guess", "", worker, false},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", "/ows", nil)
+		r.RemoteAddr = test.peer
+		if len(test.secret) > 0 {
+			r.Header.Set(clusterSecretHeader, test.secret)
+		}
+		if len(test.xff) > 0 {
+			r.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if got := clusterWorkerRequest(conf, r, test.query); got != test.worker {
+			t.Errorf("test %d: worker %v instead of %v", i, got, test.worker)
+		}
+	}
+
+	// Behind a load balancer, the nodes send the shared secret.
+	conf.ServiceConfig.OWSClusterSecret = "s3cret"
+	r := httptest.NewRequest("GET", "/ows", nil)
+	r.RemoteAddr = "192.0.2.9:51000"
+	r.Header.Set(clusterSecretHeader, "s3cret")
+	if !clusterWorkerRequest(conf, r, worker) {
+		t.Errorf("request of the cluster secret not trusted")
+	}
+	r.Header.Set(clusterSecretHeader, "s3cre")
+	if clusterWorkerRequest(conf, r, worker) {
+		t.Errorf("request of a wrong secret trusted")
+	}
+	if clusterWorkerRequest(nil, r, worker) {
+		t.Errorf("request without config trusted")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nci/gsky/loadshed"
+	"github.com/nci/gsky/metrics"
+	"github.com/nci/gsky/metrics/prom"
+	"github.com/nci/gsky/slo"
+	"github.com/nci/gsky/utils"
+)
+
+// owsRequests are the OWS operations broken down in the metrics; other
+// values of the request parameter are reported as "other" to bound the
+// cardinality of the labels.
+var owsRequests = map[string]bool{
+	"GetCapabilities":  true,
+	"GetMap":           true,
+	"GetFeatureInfo":   true,
+	"DescribeLayer":    true,
+	"GetLegendGraphic": true,
+	"DescribeCoverage": true,
+	"GetCoverage":      true,
+	"DescribeProcess":  true,
+	"Execute":          true,
+}
+
+var owsServices = map[string]bool{"WMS": true, "WCS": true, "WPS": true}
+
+type owsMetrics struct {
+	registry       *prom.Registry
+	http           *prom.HTTPMetrics
+	cache          *prom.CacheMetrics
+	requests       *prom.CounterVec
+	duration       *prom.HistogramVec
+	masDuration    *prom.HistogramVec
+	workerDuration *prom.HistogramVec
+	granules       *prom.HistogramVec
+	shed           *prom.CounterVec
+	sloIndicator   *prom.GaugeVec
+	sloObjective   *prom.GaugeVec
+	sloBudget      *prom.GaugeVec
+	sloBurnRate    *prom.GaugeVec
+}
+
+func newOWSMetrics() *owsMetrics {
+	m := &owsMetrics{
+		registry:       prom.NewRegistry(),
+		http:           prom.NewHTTPMetrics("ows"),
+		cache:          prom.NewCacheMetrics("ows"),
+		requests:       prom.NewCounterVec("gsky_ows_requests_total", "Number of OWS requests.", "service", "request", "code"),
+		duration:       prom.NewHistogramVec("gsky_ows_request_duration_seconds", "OWS request latency in seconds.", nil, "service", "request"),
+		masDuration:    prom.NewHistogramVec("gsky_ows_mas_duration_seconds", "Time spent querying MAS per OWS request in seconds.", nil, "service", "request"),
+		workerDuration: prom.NewHistogramVec("gsky_ows_worker_duration_seconds", "Time spent in worker tasks per OWS request in seconds.", nil, "service", "request"),
+		granules:       prom.NewHistogramVec("gsky_ows_granules", "Number of granules read per OWS request.", []float64{1, 4, 16, 64, 256, 1024, 4096}, "service", "request"),
+		shed:           prom.NewCounterVec("gsky_ows_shed_requests_total", "Number of OWS requests rejected under memory or CPU pressure.", "priority"),
+		sloIndicator:   prom.NewGaugeVec("gsky_ows_slo_indicator_ratio", "Fraction of the OWS requests meeting the indicator over the SLO window.", "service", "layer", "sli"),
+		sloObjective:   prom.NewGaugeVec("gsky_ows_slo_objective_ratio", "Objective of the indicator.", "service", "layer", "sli"),
+		sloBudget:      prom.NewGaugeVec("gsky_ows_slo_error_budget_remaining_ratio", "Fraction of the error budget of the SLO window not consumed, negative once exhausted.", "service", "layer", "sli"),
+		sloBurnRate:    prom.NewGaugeVec("gsky_ows_slo_burn_rate", "Rate the error budget was consumed at over the last hour, 1 exhausting it at the end of the window.", "service", "layer", "sli"),
+	}
+	m.registry.MustRegister(m.http.Collectors()...)
+	m.registry.MustRegister(m.cache.Collectors()...)
+	m.registry.MustRegister(m.requests, m.duration, m.masDuration, m.workerDuration, m.granules, m.shed)
+	m.registry.MustRegister(m.sloIndicator, m.sloObjective, m.sloBudget, m.sloBurnRate)
+	prom.RegisterProcessMetrics(m.registry, "ows", utils.GSKYVersion)
+	m.registry.OnScrape(m.collectSLO)
+	return m
+}
+
+// collectSLO refreshes the SLO gauges from the report of the tracker.
+func (m *owsMetrics) collectSLO() {
+	m.sloIndicator.Reset()
+	m.sloObjective.Reset()
+	m.sloBudget.Reset()
+	m.sloBurnRate.Reset()
+	tracker := getSLOTracker()
+	if tracker == nil {
+		return
+	}
+	for _, s := range tracker.Report(time.Now()).SLOs {
+		for sli, ind := range map[string]*slo.Indicator{"availability": s.Availability, "latency": s.Latency} {
+			if ind == nil {
+				continue
+			}
+			m.sloIndicator.With(s.Service, s.Layer, sli).Set(ind.Value)
+			m.sloObjective.With(s.Service, s.Layer, sli).Set(ind.Objective)
+			m.sloBudget.With(s.Service, s.Layer, sli).Set(ind.BudgetRemaining)
+			m.sloBurnRate.With(s.Service, s.Layer, sli).Set(ind.BurnRate)
+		}
+	}
+}
+
+// observeRequest records an OWS request once it has been served.
+func (m *owsMetrics) observeRequest(query map[string][]string, t0 time.Time, info *metrics.MetricsInfo) {
+	if m == nil {
+		return
+	}
+	service, request := "other", "other"
+	if v, ok := query["service"]; ok && len(v) > 0 && owsServices[v[0]] {
+		service = v[0]
+	}
+	if v, ok := query["request"]; ok && len(v) > 0 && owsRequests[v[0]] {
+		request = v[0]
+	}
+
+	m.requests.With(service, request, strconv.Itoa(info.HTTPStatus)).Inc()
+	m.duration.With(service, request).Observe(time.Since(t0).Seconds())
+	if info.Indexer != nil && info.Indexer.Duration > 0 {
+		m.masDuration.With(service, request).Observe(info.Indexer.Duration.Seconds())
+	}
+	if info.RPC != nil && info.RPC.Duration > 0 {
+		m.workerDuration.With(service, request).Observe(info.RPC.Duration.Seconds())
+		m.granules.With(service, request).Observe(float64(info.RPC.NumTiledGranules))
+	}
+}
+
+// observeCache records a cache lookup for the metrics and the admin
+// status page.
+func (m *owsMetrics) observeCache(cache string, hit bool) {
+	utils.RecordCacheLookup(cache, hit)
+	if m == nil {
+		return
+	}
+	m.cache.Observe(cache, hit)
+}
+
+// observeShed records a request rejected by the load shedding.
+func (m *owsMetrics) observeShed(priority loadshed.Priority) {
+	if m == nil {
+		return
+	}
+	m.shed.With(priority.String()).Inc()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/metrics"
+	"github.com/nci/gsky/quota"
+	"github.com/nci/gsky/usage"
+)
+
+// quotaUsage caches the bytes delivered to each client during the
+// current month, read from the usage store. The store is read again
+// every usageFlushInterval so that the downloads served by the other
+// OWS processes sharing it count, and the downloads served here are
+// added in between.
+type quotaUsage struct {
+	mu      sync.Mutex
+	day     string
+	updated time.Time
+	clients map[string]*quota.Usage
+}
+
+var dataQuotaUsage quotaUsage
+
+func (q *quotaUsage) get(conf *quota.Config, client string, now time.Time) (quota.Usage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now = now.UTC()
+	day := now.Format(usage.DayFormat)
+	if day != q.day || now.Sub(q.updated) >= usageFlushInterval {
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		rows, err := usageRecorder.Query(monthStart, now)
+		if err != nil {
+			return quota.Usage{}, err
+		}
+		clients := make(map[string]*quota.Usage)
+		for _, row := range rows {
+			if !conf.Counts(row.Service) {
+				continue
+			}
+			u, found := clients[row.Client]
+			if !found {
+				u = &quota.Usage{}
+				clients[row.Client] = u
+			}
+			u.Month += row.Bytes
+			if row.Day == day {
+				u.Day += row.Bytes
+			}
+		}
+		q.day, q.updated, q.clients = day, now, clients
+	}
+
+	if u, found := q.clients[client]; found {
+		return *u, nil
+	}
+	return quota.Usage{}, nil
+}
+
+func (q *quotaUsage) add(client string, bytes int64, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.clients == nil || now.UTC().Format(usage.DayFormat) != q.day {
+		return
+	}
+	u, found := q.clients[client]
+	if !found {
+		u = &quota.Usage{}
+		q.clients[client] = u
+	}
+	u.Day += bytes
+	u.Month += bytes
+}
+
+// dataQuotas returns the data quotas of the root config, nil if none or
+// if the usage is not counted.
+func dataQuotas() *quota.Config {
+	rootConfig := getConfigMap()["."]
+	if rootConfig == nil || usageRecorder == nil {
+		return nil
+	}
+	return rootConfig.ServiceConfig.DataQuotas
+}
+
+// countQuota adds the bytes of a response to the data quota of its
+// client once served.
+func countQuota(service, client string, bytes int64) {
+	if conf := dataQuotas(); conf != nil && conf.Counts(service) {
+		dataQuotaUsage.add(client, bytes, time.Now())
+	}
+}
+
+// checkQuota rejects the data requests of the clients which exceeded
+// their data quota, with the time of its reset in Retry-After. The
+// requests are served if the usage store cannot be read. The requests of
+// the tiles of a GetCoverage of another OWS cluster node, worker, are
+// checked by that node.
+func checkQuota(ctx context.Context, w http.ResponseWriter, r *http.Request, query map[string][]string, worker bool, metricsCollector *metrics.MetricsCollector) bool {
+	conf := dataQuotas()
+	if conf == nil || worker {
+		return true
+	}
+	service, _, _, data := auditedRequest(query)
+	if !data || !conf.Counts(service) {
+		return true
+	}
+
+	client := usageClient(r)
+	now := time.Now()
+	u, err := dataQuotaUsage.get(conf, client, now)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("data quota of %s: %v", client, err)
+		return true
+	}
+	err = conf.Check(client, u, now)
+	if err == nil {
+		return true
+	}
+
+	logging.FromContext(ctx).Warnf("%v", err)
+	metricsCollector.Info.HTTPStatus = http.StatusTooManyRequests
+	retryAfter := err.(*quota.Error).Reset.Sub(now)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/usage"
+	"github.com/nci/gsky/utils"
+)
+
+var usageRecorder *usage.Recorder
+
+// usageFlushInterval is the interval the usage counts are flushed to the
+// store at.
+const usageFlushInterval = time.Minute
+
+// flushUsage flushes the usage counts to the store until the process
+// exits.
+func flushUsage() {
+	for range time.Tick(usageFlushInterval) {
+		if err := usageRecorder.Flush(); err != nil {
+			Error.Printf("Error in flushing the usage counts: %v", err)
+		}
+	}
+}
+
+// usageClient identifies the client of a request by its user, its API
+// token or its IP address, through the trusted proxies, in this order.
+func usageClient(r *http.Request) string {
+	if access := utils.AccessFromContext(r.Context()); access != nil {
+		if len(access.User) > 0 {
+			return "user:" + access.User
+		}
+		if access.Token != nil {
+			return "token:" + access.Token.ID
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// startUsage returns the response writer counting the bytes of an OWS
+// request and the function counting it once served. The requests of
+// the tiles of a GetCoverage of another OWS cluster node, worker, are
+// counted by that node.
+func startUsage(ctx context.Context, w http.ResponseWriter, r *http.Request, conf *utils.Config, namespace string, query map[string][]string, worker bool) (http.ResponseWriter, func()) {
+	if usageRecorder == nil || worker {
+		return w, func() {}
+	}
+
+	t0 := time.Now()
+	aw, counted := w.(*auditWriter)
+	if !counted {
+		aw = &auditWriter{ResponseWriter: w}
+	}
+	return aw, func() {
+		service := owsService(query)
+		if len(service) == 0 {
+			return
+		}
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		client := usageClient(r)
+		usageRecorder.Record(t0, namespace, service, configLayer(conf, service, query), client, status, aw.bytes)
+		countQuota(service, client, aw.bytes)
+	}
+}
+
+// usageHandler reports the usage counts of a period, grouped by any of
+// year, month, day, namespace, service, layer and client, as JSON or
+// CSV, e.g.
+// GET /admin/usage?from=2024-01-01&to=2024-12-31&group_by=layer,month&format=csv
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if usageRecorder == nil {
+		http.Error(w, "usage reporting is disabled", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	to := now
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if v := r.FormValue(p.name); len(v) > 0 {
+			t, err := time.Parse(usage.DayFormat, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s, expected YYYY-MM-DD: %v", p.name, err), http.StatusBadRequest)
+				return
+			}
+			*p.t = t
+		}
+	}
+
+	groupBy := []string{"layer"}
+	if v := r.FormValue("group_by"); len(v) > 0 {
+		groupBy = strings.Split(v, ",")
+	}
+	filter := usage.Filter{
+		NameSpace: r.FormValue("namespace"),
+		Service:   r.FormValue("service"),
+		Layer:     r.FormValue("layer"),
+		Client:    r.FormValue("client"),
+	}
+
+	rows, err := usageRecorder.Query(from, to)
+	if err != nil {
+		logging.FromContext(r.Context()).Errorf("usage report: %v", err)
+		http.Error(w, fmt.Sprintf("usage report: %v", err), http.StatusInternalServerError)
+		return
+	}
+	report, err := usage.Aggregate(rows, filter, groupBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.FormValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"gsky_usage_%s_%s.csv\"", from.Format(usage.DayFormat), to.Format(usage.DayFormat)))
+		usage.WriteCSV(w, report, groupBy)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"from":     from.Format(usage.DayFormat),
+		"to":       to.Format(usage.DayFormat),
+		"group_by": groupBy,
+		"usage":    report,
+	})
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"sync"
 	"time"
 
+	"github.com/nci/gsky/tracing"
 	pb "github.com/nci/gsky/worker/gdalservice"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -39,6 +41,7 @@ func (gi *GeoDrillGRPC) Run(bandStrides int, decileCount int, pixelCount int, pi
 	const DefaultWpsRecvMsgSize = 100 * 1024 * 1024
 	opts := []grpc.DialOption{
 		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor),
 		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(DefaultWpsRecvMsgSize)),
 	}
 
@@ -53,6 +56,7 @@ func (gi *GeoDrillGRPC) Run(bandStrides int, decileCount int, pixelCount int, pi
 	}
 
 	var metrics []*pb.WorkerMetrics
+	var metricsLock sync.Mutex
 	var geoReq *GeoDrillGranule
 	var cLimiter *ConcLimiter
 
@@ -92,72 +96,80 @@ func (gi *GeoDrillGRPC) Run(bandStrides int, decileCount int, pixelCount int, pi
 			}
 		}
 
-		if geoReq.MetricsCollector != nil {
-			if i == 0 {
-				defer func(t0 time.Time) { geoReq.MetricsCollector.Info.RPC.Duration += time.Since(t0) }(start)
-				defer func() {
-					for i := 0; i < len(metrics); i++ {
-						if metrics[i] != nil {
-							geoReq.MetricsCollector.Info.RPC.BytesRead += metrics[i].BytesRead
-							geoReq.MetricsCollector.Info.RPC.UserTime += metrics[i].UserTime
-							geoReq.MetricsCollector.Info.RPC.SysTime += metrics[i].SysTime
+		for _, batch := range getBandBatches(len(gran.TimeStamps), gran.BandBatchSize, bandStrides) {
+			if geoReq.MetricsCollector != nil {
+				if i == 0 {
+					defer func(t0 time.Time) { geoReq.MetricsCollector.Info.RPC.Duration += time.Since(t0) }(start)
+					defer func() {
+						for i := 0; i < len(metrics); i++ {
+							if metrics[i] != nil {
+								geoReq.MetricsCollector.Info.RPC.BytesRead += metrics[i].BytesRead
+								geoReq.MetricsCollector.Info.RPC.UserTime += metrics[i].UserTime
+								geoReq.MetricsCollector.Info.RPC.SysTime += metrics[i].SysTime
+							}
 						}
-					}
-				}()
+					}()
 
+				}
+				geoReq.MetricsCollector.Info.RPC.NumTiledGranules++
+				metricsLock.Lock()
+				metrics = append(metrics, &pb.WorkerMetrics{})
+				metricsLock.Unlock()
 			}
-			geoReq.MetricsCollector.Info.RPC.NumTiledGranules++
-			metrics = append(metrics, &pb.WorkerMetrics{})
-		}
 
-		if cLimiter == nil {
-			cLimiter = NewConcLimiter(geoReq.GrpcConcLimit * len(conns))
-		}
-
-		i++
-		select {
-		case <-gi.Context.Done():
-			gi.sendError(fmt.Errorf("Drill gRPC: context has been cancel: %v", gi.Context.Err()))
-			return
-		case err := <-gi.Error:
-			gi.sendError(err)
-			return
-		default:
-			cLimiter.Increase()
-			go func(g *GeoDrillGranule, conc *ConcLimiter, iTile int) {
-				defer conc.Decrease()
-				c := pb.NewGDALClient(conns[(iTile+workerStart)%len(conns)])
-				bands, err := getBands(g.TimeStamps)
-
-				granule := &pb.GeoRPCGranule{Operation: "drill", Path: g.Path, Geometry: g.Geometry, Bands: bands, Height: float32(gran.RasterYSize), Width: float32(gran.RasterXSize), BandStrides: int32(bandStrides), DrillDecileCount: int32(decileCount), ClipUpper: gran.ClipUpper, ClipLower: gran.ClipLower, PixelCount: int32(pixelCount), PixelStat: pixelStat, VRT: g.VRT}
-				r, err := c.Process(gi.Context, granule)
-				if err != nil {
-					gi.sendError(fmt.Errorf("Drill gRPC: %v", err))
-					r = &pb.Result{}
-					return
-				}
+			if cLimiter == nil {
+				cLimiter = NewConcLimiter(geoReq.GrpcConcLimit * len(conns))
+			}
 
-				nCols := int(r.Shape[1])
-				nRows := int(r.Shape[0])
-				for i := 0; i < nCols; i++ {
-					ns := g.NameSpace
-					if i > 0 {
-						ns = g.NameSpace + fmt.Sprintf(DecileNamespace, i)
-					}
-					tsRow := make([]*pb.TimeSeries, nRows)
-					for ir := 0; ir < nRows; ir++ {
-						tsRow[ir] = r.TimeSeries[ir*nCols+i]
-					}
-					if gi.checkCancellation() {
+			i++
+			select {
+			case <-gi.Context.Done():
+				gi.sendError(fmt.Errorf("Drill gRPC: context has been cancel: %v", gi.Context.Err()))
+				return
+			case err := <-gi.Error:
+				gi.sendError(err)
+				return
+			default:
+				cLimiter.Increase()
+				go func(g *GeoDrillGranule, conc *ConcLimiter, iTile int, bandBgn int, bandEnd int) {
+					defer conc.Decrease()
+					c := pb.NewGDALClient(conns[(iTile+workerStart)%len(conns)])
+					dates := g.TimeStamps[bandBgn:bandEnd]
+					bands, _ := getBands(g.TimeStamps)
+					bands = bands[bandBgn:bandEnd]
+
+					granule := &pb.GeoRPCGranule{Operation: "drill", Path: g.Path, Geometry: g.Geometry, Bands: bands, Height: float32(g.RasterYSize), Width: float32(g.RasterXSize), BandStrides: int32(bandStrides), DrillDecileCount: int32(decileCount), ClipUpper: g.ClipUpper, ClipLower: g.ClipLower, PixelCount: int32(pixelCount), PixelStat: pixelStat, VRT: g.VRT}
+					r, err := c.Process(gi.Context, granule)
+					if err != nil {
+						gi.sendError(fmt.Errorf("Drill gRPC: %v", err))
+						r = &pb.Result{}
 						return
 					}
-					gi.Out <- &DrillResult{NameSpace: ns, Data: tsRow, NoData: r.Raster.NoData, Dates: g.TimeStamps}
-				}
 
-				if geoReq.MetricsCollector != nil {
-					metrics[iTile-1] = r.Metrics
-				}
-			}(gran, cLimiter, i)
+					nCols := int(r.Shape[1])
+					nRows := int(r.Shape[0])
+					for i := 0; i < nCols; i++ {
+						ns := g.NameSpace
+						if i > 0 {
+							ns = g.NameSpace + fmt.Sprintf(DecileNamespace, i)
+						}
+						tsRow := make([]*pb.TimeSeries, nRows)
+						for ir := 0; ir < nRows; ir++ {
+							tsRow[ir] = r.TimeSeries[ir*nCols+i]
+						}
+						if gi.checkCancellation() {
+							return
+						}
+						gi.Out <- &DrillResult{NameSpace: ns, Data: tsRow, NoData: r.Raster.NoData, Dates: dates}
+					}
+
+					if geoReq.MetricsCollector != nil {
+						metricsLock.Lock()
+						metrics[iTile-1] = r.Metrics
+						metricsLock.Unlock()
+					}
+				}(gran, cLimiter, i, batch[0], batch[1])
+			}
 		}
 	}
 
@@ -190,6 +202,31 @@ func (gi *GeoDrillGRPC) checkCancellation() bool {
 	}
 }
 
+// getBandBatches splits nBands bands into [begin, end) ranges of at
+// most batchSize bands so that a long time series of a single granule
+// can be drilled by multiple workers in parallel. The batch size is
+// rounded up to a multiple of bandStrides to keep the interpolation of
+// strided bands identical to drilling the whole granule at once.
+func getBandBatches(nBands int, batchSize int, bandStrides int) [][2]int {
+	if batchSize <= 0 || batchSize >= nBands {
+		return [][2]int{{0, nBands}}
+	}
+
+	if bandStrides > 1 && batchSize%bandStrides != 0 {
+		batchSize += bandStrides - batchSize%bandStrides
+	}
+
+	var batches [][2]int
+	for bgn := 0; bgn < nBands; bgn += batchSize {
+		end := bgn + batchSize
+		if end > nBands {
+			end = nBands
+		}
+		batches = append(batches, [2]int{bgn, end})
+	}
+	return batches
+}
+
 func getBands(times []time.Time) ([]int32, error) {
 	out := make([]int32, len(times))
 
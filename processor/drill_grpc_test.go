@@ -0,0 +1,28 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetBandBatches(t *testing.T) {
+	testCases := []struct {
+		nBands      int
+		batchSize   int
+		bandStrides int
+		expected    [][2]int
+	}{
+		{10, 0, 1, [][2]int{{0, 10}}},
+		{10, 20, 1, [][2]int{{0, 10}}},
+		{10, 4, 1, [][2]int{{0, 4}, {4, 8}, {8, 10}}},
+		{10, 4, 3, [][2]int{{0, 6}, {6, 10}}},
+		{0, 4, 1, [][2]int{{0, 0}}},
+	}
+
+	for _, tc := range testCases {
+		batches := getBandBatches(tc.nBands, tc.batchSize, tc.bandStrides)
+		if !reflect.DeepEqual(batches, tc.expected) {
+			t.Errorf("getBandBatches(%d, %d, %d): expected %v, got %v", tc.nBands, tc.batchSize, tc.bandStrides, tc.expected, batches)
+		}
+	}
+}
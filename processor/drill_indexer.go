@@ -13,14 +13,15 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 	"unsafe"
 
 	"github.com/edisonguo/jet"
 	geo "github.com/nci/geometry"
+	"github.com/nci/gsky/tracing"
 	"github.com/nci/gsky/utils"
 )
 
@@ -42,6 +43,7 @@ type DrillIndexer struct {
 	IdentityTol float64
 	DpTol       float64
 	Approx      bool
+	metricsLock sync.Mutex
 }
 
 func NewDrillIndexer(ctx context.Context, apiAddr string, identityTol float64, dpTol float64, approx bool, errChan chan error) *DrillIndexer {
@@ -121,61 +123,11 @@ func (p *DrillIndexer) Run(verbose bool) {
 			log.Printf("Drill Indexer: %v", err)
 		}
 
-		tiledResChanSize := len(tiledGeoms)
-		if tiledResChanSize > 100 {
-			tiledResChanSize = 100
+		concLimit := geoReq.PolygonShardConcLimit
+		if concLimit <= 0 {
+			concLimit = 1
 		}
 
-		tiledRes := make(chan *TiledResponse, tiledResChanSize)
-
-		go func() {
-			defer close(tiledRes)
-			for ig, geomWKT := range tiledGeoms {
-				postBody := url.Values{"wkt": {geomWKT}}
-				if verbose {
-					logRequestGeometry(fmt.Sprintf("Drill Indexer(%d/%d): ", ig+1, len(tiledGeoms)), reqURL, geomWKT)
-				}
-
-				start := time.Now()
-				resp, err := http.PostForm(reqURL, postBody)
-				if err != nil {
-					p.sendError(fmt.Errorf("Drill Indexer: POST request to %s failed. Error: %v", reqURL, err))
-					continue
-				}
-
-				body, err := ioutil.ReadAll(resp.Body)
-				resp.Body.Close()
-				if err != nil {
-					p.sendError(fmt.Errorf("Drill Indexer: Error parsing response body from %s. Error: %v", reqURL, err))
-					break
-				}
-
-				indexTime := time.Since(start)
-				if geoReq.MetricsCollector != nil {
-					geoReq.MetricsCollector.Info.Indexer.Duration += indexTime
-				}
-
-				var metadata MetadataResponse
-				err = json.Unmarshal(body, &metadata)
-				if err != nil {
-					fmt.Println(string(body))
-					p.sendError(fmt.Errorf("Drill Indexer: Problem parsing JSON response from %s. Error: %v", reqURL, err))
-					break
-				}
-
-				if len(metadata.Error) > 0 {
-					fmt.Printf("Drill Indexer error: %v", string(body))
-					p.sendError(fmt.Errorf("Drill Indexer error: %v", metadata.Error))
-					break
-				}
-
-				if p.checkCancellation() {
-					return
-				}
-				tiledRes <- &TiledResponse{IndexerID: ig, NumIndexers: len(tiledGeoms), IndexerTime: indexTime, Metadata: &metadata}
-			}
-		}()
-
 		var template *jet.Template
 		if geoReq.Mask != nil {
 			path := "."
@@ -191,14 +143,16 @@ func (p *DrillIndexer) Run(verbose bool) {
 			}
 		}
 
+		// The tiles are processed in order, the granules of a dataset
+		// found by several tiles being those of the first.
 		isFirst := true
 		dedupGranules := make(map[string]struct{})
-		for res := range tiledRes {
+		tilesInOrder(len(tiledGeoms), concLimit, p.checkCancellation, func(ig int) *TiledResponse {
+			return p.queryTiledGeometry(reqURL, tiledGeoms[ig], ig, len(tiledGeoms), geoReq, verbose)
+		}, func(res *TiledResponse) bool {
 			p.processDatasets(res, geoReq, template, dedupGranules, verbose, &isFirst)
-			if p.checkCancellation() {
-				break
-			}
-		}
+			return !p.checkCancellation()
+		})
 
 		if geoReq.MetricsCollector != nil {
 			geoReq.MetricsCollector.Info.Indexer.NumFiles += len(dedupGranules)
@@ -211,11 +165,100 @@ func (p *DrillIndexer) Run(verbose bool) {
 	}
 }
 
+// tilesInOrder queries the n tiles of a geometry, concLimit at a time,
+// and processes their responses in the order of the tiles, queried at
+// most 2*concLimit tiles ahead. No more tiles are queried nor processed
+// once a tile failed, its response being nil, process returned false or
+// cancelled returned true.
+func tilesInOrder(n int, concLimit int, cancelled func() bool, query func(ig int) *TiledResponse, process func(res *TiledResponse) bool) {
+	pending := make(chan chan *TiledResponse, concLimit)
+	stop := make(chan struct{})
+	go func() {
+		defer close(pending)
+		cLimiter := NewConcLimiter(concLimit)
+		defer cLimiter.Wait()
+		for ig := 0; ig < n; ig++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if cancelled() {
+				return
+			}
+
+			res := make(chan *TiledResponse, 1)
+			cLimiter.Increase()
+			pending <- res
+			go func(ig int) {
+				defer cLimiter.Decrease()
+				res <- query(ig)
+			}(ig)
+		}
+	}()
+
+	// The responses of the tiles queried once stopped are discarded.
+	for res := range pending {
+		select {
+		case <-stop:
+			continue
+		default:
+		}
+		if r := <-res; r == nil || !process(r) {
+			close(stop)
+		}
+	}
+}
+
+func (p *DrillIndexer) queryTiledGeometry(reqURL string, geomWKT string, ig int, nGeoms int, geoReq *GeoDrillRequest, verbose bool) *TiledResponse {
+	postBody := url.Values{"wkt": {geomWKT}}
+	if verbose {
+		logRequestGeometry(fmt.Sprintf("Drill Indexer(%d/%d): ", ig+1, nGeoms), reqURL, geomWKT)
+	}
+
+	start := time.Now()
+	resp, err := tracing.PostForm(p.Context, reqURL, postBody)
+	if err != nil {
+		p.sendError(fmt.Errorf("Drill Indexer: POST request to %s failed. Error: %v", reqURL, err))
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		p.sendError(fmt.Errorf("Drill Indexer: Error parsing response body from %s. Error: %v", reqURL, err))
+		return nil
+	}
+
+	indexTime := time.Since(start)
+	if geoReq.MetricsCollector != nil {
+		p.metricsLock.Lock()
+		geoReq.MetricsCollector.Info.Indexer.Duration += indexTime
+		p.metricsLock.Unlock()
+	}
+
+	var metadata MetadataResponse
+	err = json.Unmarshal(body, &metadata)
+	if err != nil {
+		fmt.Println(string(body))
+		p.sendError(fmt.Errorf("Drill Indexer: Problem parsing JSON response from %s. Error: %v", reqURL, err))
+		return nil
+	}
+
+	if len(metadata.Error) > 0 {
+		fmt.Printf("Drill Indexer error: %v", string(body))
+		p.sendError(fmt.Errorf("Drill Indexer error: %v", metadata.Error))
+		return nil
+	}
+
+	return &TiledResponse{IndexerID: ig, NumIndexers: nGeoms, IndexerTime: indexTime, Metadata: &metadata}
+}
+
 func (p *DrillIndexer) processDatasets(res *TiledResponse, geoReq *GeoDrillRequest, template *jet.Template, dedupGranules map[string]struct{}, verbose bool, isFirst *bool) {
 	metadata := res.Metadata
 	switch len(metadata.GDALDatasets) {
 	case 0:
-		p.Out <- &GeoDrillGranule{"NULL", utils.EmptyTileNS, "Byte", nil, geoReq.Geometry, geoReq.CRS, "", nil, nil, 0, false, 0, 0, 0, 0, 0, geoReq.MetricsCollector}
+		p.Out <- &GeoDrillGranule{"NULL", utils.EmptyTileNS, "Byte", nil, geoReq.Geometry, geoReq.CRS, "", nil, nil, 0, false, 0, 0, 0, 0, 0, 0, geoReq.MetricsCollector}
 	default:
 		var grans []*GeoDrillGranule
 		var effectiveDatasets []*GDALDataset
@@ -225,7 +268,7 @@ func (p *DrillIndexer) processDatasets(res *TiledResponse, geoReq *GeoDrillReque
 			}
 			dedupGranules[ds.DSName+ds.NameSpace] = struct{}{}
 
-			grans = append(grans, &GeoDrillGranule{ds.DSName, ds.NameSpace, ds.ArrayType, ds.TimeStamps, geoReq.Geometry, geoReq.CRS, "", ds.Means, ds.SampleCounts, ds.NoData, p.Approx, geoReq.ClipUpper, geoReq.ClipLower, geoReq.RasterXSize, geoReq.RasterYSize, geoReq.GrpcConcLimit, geoReq.MetricsCollector})
+			grans = append(grans, &GeoDrillGranule{ds.DSName, ds.NameSpace, ds.ArrayType, ds.TimeStamps, geoReq.Geometry, geoReq.CRS, "", ds.Means, ds.SampleCounts, ds.NoData, p.Approx, geoReq.ClipUpper, geoReq.ClipLower, geoReq.RasterXSize, geoReq.RasterYSize, geoReq.GrpcConcLimit, geoReq.BandBatchSize, geoReq.MetricsCollector})
 			effectiveDatasets = append(effectiveDatasets, ds)
 		}
 		if len(grans) == 0 {
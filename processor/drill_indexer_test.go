@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTilesInOrder(t *testing.T) {
+	testCases := []struct {
+		name      string
+		n         int
+		concLimit int
+		failed    int
+		stopAt    int
+		processed []int
+	}{
+		{name: "single tile", n: 1, concLimit: 1, failed: -1, stopAt: -1, processed: []int{0}},
+		{name: "in order", n: 8, concLimit: 3, failed: -1, stopAt: -1, processed: []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{name: "failed tile", n: 8, concLimit: 3, failed: 4, stopAt: -1, processed: []int{0, 1, 2, 3}},
+		{name: "failed first tile", n: 8, concLimit: 2, failed: 0, stopAt: -1, processed: nil},
+		{name: "stopped", n: 8, concLimit: 3, failed: -1, stopAt: 2, processed: []int{0, 1, 2}},
+	}
+
+	for _, tc := range testCases {
+		var mu sync.Mutex
+		var queried []int
+		// the responses of the later tiles arrive first
+		query := func(ig int) *TiledResponse {
+			mu.Lock()
+			queried = append(queried, ig)
+			mu.Unlock()
+			time.Sleep(time.Duration(tc.n-ig) * time.Millisecond)
+			if ig == tc.failed {
+				return nil
+			}
+			return &TiledResponse{IndexerID: ig, NumIndexers: tc.n}
+		}
+
+		var processed []int
+		tilesInOrder(tc.n, tc.concLimit, func() bool { return false }, query, func(res *TiledResponse) bool {
+			processed = append(processed, res.IndexerID)
+			return res.IndexerID != tc.stopAt
+		})
+		if !reflect.DeepEqual(processed, tc.processed) {
+			t.Errorf("%s: expected the tiles %v processed, got %v", tc.name, tc.processed, processed)
+		}
+
+		// the tiles are no longer queried once failed or stopped
+		last := tc.failed
+		if tc.stopAt >= 0 {
+			last = tc.stopAt
+		}
+		if last >= 0 && len(queried) > last+1+2*tc.concLimit {
+			t.Errorf("%s: expected at most %d tiles queried, got %v", tc.name, last+1+2*tc.concLimit, queried)
+		}
+	}
+}
+
+func TestTilesInOrderCancelled(t *testing.T) {
+	var mu sync.Mutex
+	nQueried := 0
+	cancelled := false
+	query := func(ig int) *TiledResponse {
+		mu.Lock()
+		nQueried++
+		mu.Unlock()
+		return &TiledResponse{IndexerID: ig}
+	}
+
+	var processed []int
+	tilesInOrder(100, 2, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return cancelled
+	}, query, func(res *TiledResponse) bool {
+		processed = append(processed, res.IndexerID)
+		if res.IndexerID == 10 {
+			mu.Lock()
+			cancelled = true
+			mu.Unlock()
+		}
+		return true
+	})
+
+	if len(processed) < 11 || len(processed) > 11+4 {
+		t.Errorf("expected the tiles processed up to the cancellation, got %v", processed)
+	}
+	for i, ig := range processed {
+		if ig != i {
+			t.Fatalf("expected the tiles processed in order, got %v", processed)
+		}
+	}
+	if nQueried > 11+4 {
+		t.Errorf("expected no tiles queried once cancelled, got %d", nQueried)
+	}
+}
@@ -33,12 +33,15 @@ func (dm *DrillMerger) Run(suffix string, namespaces []string, templateFileName
 		defer log.Printf("Drill Merger done")
 	}
 	defer close(dm.Out)
-	results := make(map[string]map[string][]*pb.TimeSeries)
+	// Results are aggregated into running totals as soon as they
+	// arrive from the workers so that the memory footprint does not
+	// grow with the number of granule batches.
+	results := make(map[string]map[string]*drillAccum)
 
 	var drillResult *DrillResult
 	for drillRes := range dm.In {
 		if _, ok := results[drillRes.NameSpace]; !ok {
-			results[drillRes.NameSpace] = make(map[string][]*pb.TimeSeries)
+			results[drillRes.NameSpace] = make(map[string]*drillAccum)
 			nsFound := false
 			for _, ns := range namespaces {
 				if ns == drillRes.NameSpace {
@@ -52,12 +55,16 @@ func (dm *DrillMerger) Run(suffix string, namespaces []string, templateFileName
 		}
 
 		for i, date := range drillRes.Dates {
+			if i >= len(drillRes.Data) {
+				break
+			}
 			isoDate := date.Format(ISOFormat)
-			if _, ok := results[drillRes.NameSpace][isoDate]; !ok {
-				results[drillRes.NameSpace][isoDate] = []*pb.TimeSeries{drillRes.Data[i]}
-			} else {
-				results[drillRes.NameSpace][isoDate] = append(results[drillRes.NameSpace][isoDate], drillRes.Data[i])
+			acc, ok := results[drillRes.NameSpace][isoDate]
+			if !ok {
+				acc = &drillAccum{}
+				results[drillRes.NameSpace][isoDate] = acc
 			}
+			acc.add(drillRes.Data[i])
 		}
 
 		if drillResult == nil {
@@ -78,16 +85,12 @@ func (dm *DrillMerger) Run(suffix string, namespaces []string, templateFileName
 	for _, key := range dates {
 		values := map[string]float64{}
 		for _, ns := range namespaces {
-			total := 0.0
-			count := 0
-			for _, data := range results[ns][key] {
-				if !math.IsNaN(data.Value) {
-					total += data.Value * float64(data.Count)
-					count += int(data.Count)
-				}
+			acc, ok := results[ns][key]
+			if !ok {
+				continue
 			}
-			if !math.IsNaN(total) && count > 0 {
-				values[ns] = total / float64(count)
+			if val, ok := acc.mean(); ok {
+				values[ns] = val
 			}
 		}
 
@@ -170,6 +173,28 @@ func (dm *DrillMerger) Run(suffix string, namespaces []string, templateFileName
 	dm.Out <- fmt.Sprintf(out.String(), suffix)
 }
 
+// drillAccum is the running count-weighted sum of the drill results of
+// a namespace at a given date.
+type drillAccum struct {
+	total float64
+	count int
+}
+
+func (a *drillAccum) add(data *pb.TimeSeries) {
+	if data == nil || math.IsNaN(data.Value) {
+		return
+	}
+	a.total += data.Value * float64(data.Count)
+	a.count += int(data.Count)
+}
+
+func (a *drillAccum) mean() (float64, bool) {
+	if math.IsNaN(a.total) || a.count <= 0 {
+		return 0, false
+	}
+	return a.total / float64(a.count), true
+}
+
 func (dm *DrillMerger) sendError(err error) {
 	select {
 	case dm.Error <- err:
@@ -10,23 +10,25 @@ import (
 )
 
 type GeoDrillRequest struct {
-	Geometry         string
-	CRS              string
-	Collection       string
-	NameSpaces       []string
-	BandExpr         *utils.BandExpressions
-	Mask             *utils.Mask
-	VRTURL           string
-	StartTime        time.Time
-	EndTime          time.Time
-	ClipUpper        float32
-	ClipLower        float32
-	RasterXSize      float64
-	RasterYSize      float64
-	GrpcConcLimit    int
-	IndexTileXSize   float64
-	IndexTileYSize   float64
-	MetricsCollector *metrics.MetricsCollector
+	Geometry              string
+	CRS                   string
+	Collection            string
+	NameSpaces            []string
+	BandExpr              *utils.BandExpressions
+	Mask                  *utils.Mask
+	VRTURL                string
+	StartTime             time.Time
+	EndTime               time.Time
+	ClipUpper             float32
+	ClipLower             float32
+	RasterXSize           float64
+	RasterYSize           float64
+	GrpcConcLimit         int
+	IndexTileXSize        float64
+	IndexTileYSize        float64
+	PolygonShardConcLimit int
+	BandBatchSize         int
+	MetricsCollector      *metrics.MetricsCollector
 }
 
 type GeoDrillGranule struct {
@@ -46,6 +48,7 @@ type GeoDrillGranule struct {
 	RasterXSize      float64
 	RasterYSize      float64
 	GrpcConcLimit    int
+	BandBatchSize    int
 	MetricsCollector *metrics.MetricsCollector
 }
 
@@ -2,8 +2,10 @@ package processor
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,6 +18,34 @@ type featureInfo struct {
 	Namespaces []string
 	DsFiles    []string
 	DsDates    []string
+	Units      string
+	Format     *utils.LegendConfig
+}
+
+// formatValue formats a band value with the number format of the legend
+// config of the layer style, if any.
+func (ftInfo *featureInfo) formatValue(value interface{}) string {
+	if !ftInfo.Format.HasNumberFormat() {
+		return fmt.Sprintf("%v", value)
+	}
+
+	var v float64
+	switch t := value.(type) {
+	case int8:
+		v = float64(t)
+	case uint8:
+		v = float64(t)
+	case int16:
+		v = float64(t)
+	case uint16:
+		v = float64(t)
+	case float32:
+		// keeps the shortest representation of the float32 value
+		v, _ = strconv.ParseFloat(strconv.FormatFloat(float64(t), 'g', -1, 32), 64)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+	return ftInfo.Format.FormatValue(v)
 }
 
 func GetFeatureInfo(ctx context.Context, params utils.WMSParams, conf *utils.Config, configMap map[string]*utils.Config, verbose bool, metricsCollector *metrics.MetricsCollector) (string, error) {
@@ -76,7 +106,7 @@ func GetFeatureInfo(ctx context.Context, params utils.WMSParams, conf *utils.Con
 				if value == noData {
 					valueStr = `"n/a"`
 				} else {
-					valueStr = fmt.Sprintf("%v", value)
+					valueStr = ftInfo.formatValue(value)
 				}
 
 			case *utils.ByteRaster:
@@ -85,7 +115,7 @@ func GetFeatureInfo(ctx context.Context, params utils.WMSParams, conf *utils.Con
 				if value == noData {
 					valueStr = `"n/a"`
 				} else {
-					valueStr = fmt.Sprintf("%v", value)
+					valueStr = ftInfo.formatValue(value)
 				}
 
 			case *utils.Int16Raster:
@@ -94,7 +124,7 @@ func GetFeatureInfo(ctx context.Context, params utils.WMSParams, conf *utils.Con
 				if value == noData {
 					valueStr = `"n/a"`
 				} else {
-					valueStr = fmt.Sprintf("%v", value)
+					valueStr = ftInfo.formatValue(value)
 				}
 
 			case *utils.UInt16Raster:
@@ -103,7 +133,7 @@ func GetFeatureInfo(ctx context.Context, params utils.WMSParams, conf *utils.Con
 				if value == noData {
 					valueStr = `"n/a"`
 				} else {
-					valueStr = fmt.Sprintf("%v", value)
+					valueStr = ftInfo.formatValue(value)
 				}
 
 			case *utils.Float32Raster:
@@ -112,7 +142,7 @@ func GetFeatureInfo(ctx context.Context, params utils.WMSParams, conf *utils.Con
 				if value == noData {
 					valueStr = `"n/a"`
 				} else {
-					valueStr = fmt.Sprintf("%v", value)
+					valueStr = ftInfo.formatValue(value)
 				}
 			}
 
@@ -124,6 +154,11 @@ func GetFeatureInfo(ctx context.Context, params utils.WMSParams, conf *utils.Con
 		out += `}`
 	}
 
+	if len(ftInfo.Units) > 0 {
+		units, _ := json.Marshal(ftInfo.Units)
+		out += fmt.Sprintf(`, "units": %s`, units)
+	}
+
 	if len(ftInfo.DsDates) > 0 {
 		out += `, "data_available_for_dates":[`
 		for i, ts := range ftInfo.DsDates {
@@ -205,6 +240,11 @@ func getRaster(ctx context.Context, params utils.WMSParams, conf *utils.Config,
 		bandExpr = styleLayer.RGBExpressions
 	}
 
+	if params.BandExpr == nil {
+		ftInfo.Units = styleLayer.DisplayUnits()
+		ftInfo.Format = styleLayer.Legend
+	}
+
 	if params.Height == nil || params.Width == nil {
 		return nil, fmt.Errorf("Request should contain valid 'width' and 'height' parameters.")
 	}
@@ -255,6 +295,11 @@ func getRaster(ctx context.Context, params utils.WMSParams, conf *utils.Config,
 
 	params.BBox = []float64{xmin, ymin, xmax, ymax}
 
+	nativeCRS, err := conf.Layers[idx].NativeCRSWKT()
+	if err != nil {
+		return nil, err
+	}
+
 	geoReq := &GeoTileRequest{ConfigPayLoad: ConfigPayLoad{NameSpaces: namespaces,
 		BandExpr:            bandExpr,
 		Mask:                styleLayer.Mask,
@@ -263,12 +308,16 @@ func getRaster(ctx context.Context, params utils.WMSParams, conf *utils.Config,
 		GrpcConcLimit:       conf.Layers[idx].GrpcWmsConcPerNode,
 		QueryLimit:          -1,
 		UserSrcSRS:          conf.Layers[idx].UserSrcSRS,
+		NativeCRS:           nativeCRS,
 		UserSrcGeoTransform: conf.Layers[idx].UserSrcGeoTransform,
 		AxisMapping:         conf.Layers[idx].WmsAxisMapping,
 		MasQueryHint:        conf.Layers[idx].MasQueryHint,
 		ReqRes:              reqRes,
 		SRSCf:               conf.Layers[idx].SRSCf,
 		FusionUnscale:       1,
+		GrpcChecksum:        conf.ServiceConfig.GrpcChecksum,
+		WarpBackend:         conf.Layers[idx].WarpBackend,
+		ResampleAlg:         conf.Layers[idx].Resampling,
 		GrpcTileXSize:       conf.Layers[idx].GrpcTileXSize,
 		GrpcTileYSize:       conf.Layers[idx].GrpcTileYSize,
 		IndexTileXSize:      conf.Layers[idx].IndexTileXSize,
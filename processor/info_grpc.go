@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
+	"github.com/nci/gsky/tracing"
 	pb "github.com/nci/gsky/worker/gdalservice"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -62,7 +63,7 @@ func (gi *GeoInfoGRPC) Run() {
 
 	conns := make([]*grpc.ClientConn, len(gi.Clients))
 	for i, client := range gi.Clients {
-		conn, err := grpc.Dial(client, grpc.WithInsecure())
+		conn, err := grpc.Dial(client, grpc.WithInsecure(), grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor))
 		if err != nil {
 			log.Fatalf("gRPC connection problem: %v", err)
 		}
@@ -11,6 +11,8 @@ import (
 	"reflect"
 	"unsafe"
 
+	"github.com/nci/gsky/tracing"
+	"github.com/nci/gsky/utils"
 	pb "github.com/nci/gsky/worker/gdalservice"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -47,6 +49,7 @@ func ComputeReprojectionExtent(ctx context.Context, geoReq *GeoTileRequest, masA
 
 	opts := []grpc.DialOption{
 		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor),
 		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(DefaultRecvMsgSize)),
 	}
 
@@ -73,7 +76,7 @@ func ComputeReprojectionExtent(ctx context.Context, geoReq *GeoTileRequest, masA
 
 	hSRS := C.OSRNewSpatialReference(nil)
 	defer C.OSRDestroySpatialReference(hSRS)
-	crsC := C.CString(geoReq.CRS)
+	crsC := C.CString(utils.ResolveCRS(geoReq.CRS))
 	defer C.free(unsafe.Pointer(crsC))
 	C.OSRSetFromUserInput(hSRS, crsC)
 	var projWKTC *C.char
@@ -14,6 +14,8 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/nci/gsky/tracing"
+	"github.com/nci/gsky/utils"
 	pb "github.com/nci/gsky/worker/gdalservice"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -58,6 +60,11 @@ func (gi *GeoRasterGRPC) Run(varList []string, verbose bool) {
 	var projWKT string
 	var cLimiter *ConcLimiter
 
+	// In streaming mode rasters are sent to the merger as soon as
+	// they are returned by the workers rather than after all the
+	// granules have been processed.
+	streaming := false
+
 	accumMetrics := &pb.WorkerMetrics{}
 
 	var outRasters []*FlexRaster
@@ -98,6 +105,7 @@ func (gi *GeoRasterGRPC) Run(varList []string, verbose bool) {
 
 			opts := []grpc.DialOption{
 				grpc.WithInsecure(),
+				grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor),
 				grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(gi.MaxGrpcRecvMsgSize)),
 			}
 
@@ -125,7 +133,7 @@ func (gi *GeoRasterGRPC) Run(varList []string, verbose bool) {
 			}
 
 			hSRS := C.OSRNewSpatialReference(nil)
-			crsC := C.CString(g0.CRS)
+			crsC := C.CString(utils.ResolveCRS(g0.CRS))
 			C.OSRSetFromUserInput(hSRS, crsC)
 			var projWKTC *C.char
 			C.OSRExportToWkt(hSRS, &projWKTC)
@@ -138,6 +146,14 @@ func (gi *GeoRasterGRPC) Run(varList []string, verbose bool) {
 			g0.DstGeoTransform = BBox2Geot(g0.Width, g0.Height, g0.BBox)
 
 			cLimiter = NewConcLimiter(g0.GrpcConcLimit * len(connPool))
+
+			// Data source masks are merged with the rasters sharing
+			// the same geo stamp which requires all the rasters to be
+			// available at once.
+			streaming = g0.StreamingMerge && g0.Mask == nil
+			if verbose && streaming {
+				log.Printf("tile grpc: streaming rasters to merger")
+			}
 		}
 
 		if g0.GrpcTileXSize > 0.0 || g0.GrpcTileYSize > 0.0 {
@@ -191,7 +207,7 @@ func (gi *GeoRasterGRPC) Run(varList []string, verbose bool) {
 
 						tileBBox := []float64{xMin, yMin, xMax, yMax}
 						tileGeot := BBox2Geot(tileXSize, tileYSize, tileBBox)
-						tileGran := &GeoTileGranule{ConfigPayLoad: g.ConfigPayLoad, RawPath: g.RawPath, Path: g.Path, NameSpace: g.NameSpace, VarNameSpace: g.VarNameSpace, RasterType: g.RasterType, TimeStamp: g.TimeStamp, BandIdx: g.BandIdx, Polygon: g.Polygon, BBox: tileBBox, Height: tileYSize, Width: tileXSize, RawHeight: g.Height, RawWidth: g.Width, OffX: x, OffY: g.Height - y - tileYSize, CRS: g.CRS, SrcSRS: g.SrcSRS, SrcGeoTransform: g.SrcGeoTransform, DstGeoTransform: tileGeot, GeoLocation: g.GeoLocation}
+						tileGran := &GeoTileGranule{ConfigPayLoad: g.ConfigPayLoad, RawPath: g.RawPath, Path: g.Path, NameSpace: g.NameSpace, VarNameSpace: g.VarNameSpace, RasterType: g.RasterType, TimeStamp: g.TimeStamp, BandIdx: g.BandIdx, Polygon: g.Polygon, BBox: tileBBox, Height: tileYSize, Width: tileXSize, RawHeight: g.Height, RawWidth: g.Width, OffX: x, OffY: g.Height - y - tileYSize, CRS: g.CRS, SrcSRS: g.SrcSRS, SrcGeoTransform: g.SrcGeoTransform, DstGeoTransform: tileGeot, GeoLocation: g.GeoLocation, COG: g.COG}
 						grans = append(grans, tileGran)
 					}
 				}
@@ -213,6 +229,14 @@ func (gi *GeoRasterGRPC) Run(varList []string, verbose bool) {
 			default:
 				if gran.Path == "NULL" {
 					outRasters[iGran] = &FlexRaster{ConfigPayLoad: gran.ConfigPayLoad, Data: make([]uint8, gran.Width*gran.Height), Height: gran.Height, Width: gran.Width, OffX: gran.OffX, OffY: gran.OffY, Type: gran.RasterType, NoData: 0.0, NameSpace: gran.NameSpace, TimeStamp: gran.TimeStamp, Polygon: gran.Polygon}
+					if streaming {
+						outRasters[iGran].Streamed = true
+						select {
+						case gi.Out <- []*FlexRaster{outRasters[iGran]}:
+						case <-gi.Context.Done():
+						}
+						outRasters[iGran] = nil
+					}
 					continue
 				}
 
@@ -246,7 +270,16 @@ func (gi *GeoRasterGRPC) Run(varList []string, verbose bool) {
 						rawHeight = g.RawHeight
 						rawWidth = g.RawWidth
 					}
-					outRasters[idx] = &FlexRaster{ConfigPayLoad: g.ConfigPayLoad, Data: r.Raster.Data, Height: rawHeight, Width: rawWidth, DataHeight: rHeight, DataWidth: rWidth, OffX: rOffX, OffY: rOffY, Type: r.Raster.RasterType, NoData: r.Raster.NoData, NameSpace: g.NameSpace, TimeStamp: g.TimeStamp, Polygon: g.Polygon, GeomMask: r.Raster.Mask}
+					flex := &FlexRaster{ConfigPayLoad: g.ConfigPayLoad, Data: r.Raster.Data, Height: rawHeight, Width: rawWidth, DataHeight: rHeight, DataWidth: rWidth, OffX: rOffX, OffY: rOffY, Type: r.Raster.RasterType, NoData: r.Raster.NoData, NameSpace: g.NameSpace, TimeStamp: g.TimeStamp, Polygon: g.Polygon, GeomMask: r.Raster.Mask}
+					if streaming {
+						flex.Streamed = true
+						select {
+						case gi.Out <- []*FlexRaster{flex}:
+						case <-gi.Context.Done():
+						}
+						return
+					}
+					outRasters[idx] = flex
 				}(gran, iGran)
 			}
 			iGran++
@@ -294,7 +327,9 @@ func (gi *GeoRasterGRPC) Run(varList []string, verbose bool) {
 		return
 	}
 
-	gi.Out <- outRasters
+	if !streaming {
+		gi.Out <- outRasters
+	}
 }
 
 func (gi *GeoRasterGRPC) sendError(err error) {
@@ -369,6 +404,10 @@ func getRPCRaster(ctx context.Context, g *GeoTileGranule, projWKT string, geot [
 		granule.SrcSRS = g.SrcSRS
 	}
 
+	if len(g.NativeCRS) > 0 {
+		granule.SrcSRS = g.NativeCRS
+	}
+
 	if g.UserSrcGeoTransform > 0 {
 		granule.SrcGeot = g.SrcGeoTransform
 	}
@@ -381,11 +420,29 @@ func getRPCRaster(ctx context.Context, g *GeoTileGranule, projWKT string, geot [
 		granule.SRSCf = int32(g.SRSCf)
 	}
 
+	granule.Checksum = g.GrpcChecksum
+	granule.WarpBackend = g.WarpBackend
+	granule.ResampleAlg = g.ResampleAlg
+	granule.Cog = g.COG
+
 	r, err := c.Process(ctx, granule)
 	if err != nil {
 		return nil, err
 	}
 
+	// Corrupted pixel payloads are retried once before giving up
+	if granule.Checksum && r.Raster != nil && !r.Raster.VerifyChecksum() {
+		log.Printf("tile grpc: checksum mismatch for %s, band %d, retrying", g.Path, g.BandIdx)
+		r, err = c.Process(ctx, granule)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Raster != nil && !r.Raster.VerifyChecksum() {
+			return nil, fmt.Errorf("tile grpc: checksum mismatch for %s, band %d", g.Path, g.BandIdx)
+		}
+	}
+
 	return r, nil
 }
 
@@ -8,12 +8,12 @@ import (
 	"log"
 	"math"
 	"math/rand"
-	"net/http"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/nci/gsky/tracing"
 	"github.com/nci/gsky/utils"
 )
 
@@ -40,6 +40,12 @@ type GeoLocInfo struct {
 	PixelStep   int    `json:"pixel_step"`
 }
 
+// ImageStructure is the layout of the pixels of a dataset in its file
+// recorded by the crawler, COG for the cloud optimized GeoTIFFs.
+type ImageStructure struct {
+	Layout string `json:"layout"`
+}
+
 type GDALDataset struct {
 	RawPath      string         `json:"file_path"`
 	DSName       string         `json:"ds_name"`
@@ -55,6 +61,7 @@ type GDALDataset struct {
 	Axes         []*DatasetAxis `json:"axes"`
 	GeoLocation  *GeoLocInfo    `json:"geo_loc"`
 	IsOutRange   bool
+	Structure    *ImageStructure `json:"image_structure"`
 }
 
 type MetadataResponse struct {
@@ -165,10 +172,21 @@ func (p *TileIndexer) Run(verbose bool) {
 				nameSpaces = ""
 			}
 			var bboxWkt string
+			indexCRS := geoReq.CRS
 			if geoReq.MasQueryHint != "non_spatial" {
 				bboxWkt = BBox2WKT(geoReq.BBox)
+				// MAS only knows the CRS of the spatial_ref_sys table
+				if utils.IsCustomCRS(geoReq.CRS) {
+					canonicalBBox, err := utils.GetCanonicalBbox(geoReq.CRS, geoReq.BBox)
+					if err != nil {
+						p.sendError(fmt.Errorf("Indexer: failed to transform bbox from %s: %v", geoReq.CRS, err))
+						return
+					}
+					bboxWkt = BBox2WKT(canonicalBBox)
+					indexCRS = "EPSG:3857"
+				}
 			}
-			url = p.getIndexerURL(geoReq, nameSpaces, bboxWkt, geoReq.CRS)
+			url = p.getIndexerURL(geoReq, nameSpaces, bboxWkt, indexCRS)
 			if isInit {
 				if geoReq.MetricsCollector != nil {
 					defer func() { geoReq.MetricsCollector.Info.Indexer.Duration += time.Since(t0) }()
@@ -316,7 +334,7 @@ func (p *TileIndexer) URLIndexGet(ctx context.Context, url string, geoReq *GeoTi
 		defer cLimiter.Decrease()
 	}
 
-	resp, err := http.Get(url)
+	resp, err := tracing.Get(ctx, url)
 	if err != nil {
 		p.sendError(fmt.Errorf("GET request to %s failed. Error: %v", url, err))
 		out <- &GeoTileGranule{ConfigPayLoad: ConfigPayLoad{NameSpaces: []string{utils.EmptyTileNS}, ScaleParams: geoReq.ScaleParams, Palette: geoReq.Palette}, Path: "NULL", NameSpace: utils.EmptyTileNS, RasterType: "Byte", TimeStamp: 0, BBox: geoReq.BBox, Height: geoReq.Height, Width: geoReq.Width, OffX: geoReq.OffX, OffY: geoReq.OffY, CRS: geoReq.CRS}
@@ -525,7 +543,7 @@ func (p *TileIndexer) URLIndexGet(ctx context.Context, url string, geoReq *GeoTi
 				}
 
 				if !isEmptyTile || (isEmptyTile && !bandFound) {
-					gran := &GeoTileGranule{ConfigPayLoad: geoReq.ConfigPayLoad, RawPath: ds.RawPath, Path: ds.DSName, NameSpace: namespace, VarNameSpace: ds.NameSpace, RasterType: ds.ArrayType, TimeStamp: float64(aggTimeStamp), BandIdx: bandIdx, Polygon: ds.Polygon, BBox: geoReq.BBox, Height: geoReq.Height, Width: geoReq.Width, CRS: geoReq.CRS, SrcSRS: ds.SRS, SrcGeoTransform: ds.GeoTransform, GeoLocation: ds.GeoLocation, ClipFeature: geoReq.ClipFeature}
+					gran := &GeoTileGranule{ConfigPayLoad: geoReq.ConfigPayLoad, RawPath: ds.RawPath, Path: ds.DSName, NameSpace: namespace, VarNameSpace: ds.NameSpace, RasterType: ds.ArrayType, TimeStamp: float64(aggTimeStamp), BandIdx: bandIdx, Polygon: ds.Polygon, BBox: geoReq.BBox, Height: geoReq.Height, Width: geoReq.Width, CRS: geoReq.CRS, SrcSRS: ds.SRS, SrcGeoTransform: ds.GeoTransform, GeoLocation: ds.GeoLocation, ClipFeature: geoReq.ClipFeature, COG: ds.Structure != nil && ds.Structure.Layout == "COG"}
 					if isEmptyTile {
 						gran.Path = "NULL"
 						gran.RasterType = "Byte"
@@ -223,60 +223,162 @@ func MergeMaskedRaster(r *FlexRaster, canvasMap map[string]*FlexRaster, mask []b
 	return
 }
 
+// MergeStreamedRaster merges a raster received in streaming mode into its
+// canvas. Rasters can arrive in any order, therefore the timestamp of
+// each canvas pixel is tracked in stamps so that the most recent valid
+// value always wins regardless of the arrival order.
+func MergeStreamedRaster(r *FlexRaster, canvas *FlexRaster, stamps []float64, mask []bool) (err error) {
+	var isValid func(iSrc, iDst int) bool
+	var copyVal func(iSrc, iDst int)
+	switch r.Type {
+	case "SignedByte":
+		canvasData := int8View(canvas.Data)
+		data := int8View(r.Data)
+		nodata := int8(r.NoData)
+		isValid = func(iSrc, iDst int) bool {
+			return data[iSrc] != nodata && (canvasData[iDst] == nodata || r.TimeStamp >= stamps[iDst])
+		}
+		copyVal = func(iSrc, iDst int) { canvasData[iDst] = data[iSrc] }
+	case "Byte":
+		canvasData := canvas.Data
+		data := r.Data
+		nodata := uint8(r.NoData)
+		isValid = func(iSrc, iDst int) bool {
+			return data[iSrc] != nodata && (canvasData[iDst] == nodata || r.TimeStamp >= stamps[iDst])
+		}
+		copyVal = func(iSrc, iDst int) { canvasData[iDst] = data[iSrc] }
+	case "Int16":
+		canvasData := int16View(canvas.Data)
+		data := int16View(r.Data)
+		nodata := int16(r.NoData)
+		isValid = func(iSrc, iDst int) bool {
+			return data[iSrc] != nodata && (canvasData[iDst] == nodata || r.TimeStamp >= stamps[iDst])
+		}
+		copyVal = func(iSrc, iDst int) { canvasData[iDst] = data[iSrc] }
+	case "UInt16":
+		canvasData := uint16View(canvas.Data)
+		data := uint16View(r.Data)
+		nodata := uint16(r.NoData)
+		isValid = func(iSrc, iDst int) bool {
+			return data[iSrc] != nodata && (canvasData[iDst] == nodata || r.TimeStamp >= stamps[iDst])
+		}
+		copyVal = func(iSrc, iDst int) { canvasData[iDst] = data[iSrc] }
+	case "Float32":
+		canvasData := float32View(canvas.Data)
+		data := float32View(r.Data)
+		nodata := float32(r.NoData)
+		isValid = func(iSrc, iDst int) bool {
+			return data[iSrc] != nodata && (canvasData[iDst] == nodata || r.TimeStamp >= stamps[iDst])
+		}
+		copyVal = func(iSrc, iDst int) { canvasData[iDst] = data[iSrc] }
+	default:
+		return fmt.Errorf("MergeStreamedRaster hasn't been implemented for Raster type %s", r.Type)
+	}
+
+	iSrc := 0
+	for ir := 0; ir < r.DataHeight; ir++ {
+		for ic := 0; ic < r.DataWidth; ic++ {
+			iDst := (ir+r.OffY)*r.Width + ic + r.OffX
+			if !mask[iSrc] && isValid(iSrc, iDst) {
+				copyVal(iSrc, iDst)
+				stamps[iDst] = r.TimeStamp
+			}
+			iSrc++
+		}
+	}
+
+	if r.TimeStamp > canvas.TimeStamp {
+		canvas.TimeStamp = r.TimeStamp
+	}
+	return
+}
+
+func newCanvas(r *FlexRaster) *FlexRaster {
+	return &FlexRaster{TimeStamp: 0, ConfigPayLoad: r.ConfigPayLoad,
+		NoData: r.NoData, Data: initNoDataSlice(r.Type, r.NoData, r.Width*r.Height),
+		Height: r.Height, Width: r.Width, OffX: r.OffX, OffY: r.OffY,
+		Type: r.Type, NameSpace: r.NameSpace}
+}
+
 func initNoDataSlice(rType string, noDataValue float64, size int) []uint8 {
+	// The canvas is allocated as bytes and filled through a typed view of
+	// them, the typed slice never outliving its canvas.
 	switch rType {
 	case "SignedByte":
-		out := make([]int8, size)
+		buf := make([]uint8, size)
+		out := int8View(buf)
 		fill := int8(noDataValue)
-		for i := 0; i < size; i++ {
+		for i := range out {
 			out[i] = fill
 		}
-		headr := *(*reflect.SliceHeader)(unsafe.Pointer(&out))
-		return *(*[]uint8)(unsafe.Pointer(&headr))
+		return buf
 	case "Byte":
-		out := make([]uint8, size)
+		buf := make([]uint8, size)
 		fill := uint8(noDataValue)
-		for i := 0; i < size; i++ {
-			out[i] = fill
+		for i := range buf {
+			buf[i] = fill
 		}
-		headr := *(*reflect.SliceHeader)(unsafe.Pointer(&out))
-		return *(*[]uint8)(unsafe.Pointer(&headr))
+		return buf
 	case "Int16":
-		out := make([]int16, size)
+		buf := make([]uint8, size*SizeofInt16)
+		out := int16View(buf)
 		fill := int16(noDataValue)
-		for i := 0; i < size; i++ {
+		for i := range out {
 			out[i] = fill
 		}
-		headr := *(*reflect.SliceHeader)(unsafe.Pointer(&out))
-		headr.Len *= SizeofInt16
-		headr.Cap *= SizeofInt16
-		return *(*[]uint8)(unsafe.Pointer(&headr))
+		return buf
 	case "UInt16":
-		out := make([]uint16, size)
+		buf := make([]uint8, size*SizeofUint16)
+		out := uint16View(buf)
 		fill := uint16(noDataValue)
-		for i := 0; i < size; i++ {
+		for i := range out {
 			out[i] = fill
 		}
-		headr := *(*reflect.SliceHeader)(unsafe.Pointer(&out))
-		headr.Len *= SizeofUint16
-		headr.Cap *= SizeofUint16
-		return *(*[]uint8)(unsafe.Pointer(&headr))
+		return buf
 	case "Float32":
-		out := make([]float32, size)
+		buf := make([]uint8, size*SizeofFloat32)
+		out := float32View(buf)
 		fill := float32(noDataValue)
-		for i := 0; i < size; i++ {
+		for i := range out {
 			out[i] = fill
 		}
-		headr := *(*reflect.SliceHeader)(unsafe.Pointer(&out))
-		headr.Len *= SizeofFloat32
-		headr.Cap *= SizeofFloat32
-		return *(*[]uint8)(unsafe.Pointer(&headr))
+		return buf
 	default:
 		return []uint8{}
 	}
 
 }
 
+// int8View, int16View, uint16View and float32View return the typed views
+// of the bytes of a raster, sharing their memory.
+func int8View(buf []uint8) []int8 {
+	if len(buf) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*int8)(unsafe.Pointer(&buf[0])), len(buf))
+}
+
+func int16View(buf []uint8) []int16 {
+	if len(buf) < SizeofInt16 {
+		return nil
+	}
+	return unsafe.Slice((*int16)(unsafe.Pointer(&buf[0])), len(buf)/SizeofInt16)
+}
+
+func uint16View(buf []uint8) []uint16 {
+	if len(buf) < SizeofUint16 {
+		return nil
+	}
+	return unsafe.Slice((*uint16)(unsafe.Pointer(&buf[0])), len(buf)/SizeofUint16)
+}
+
+func float32View(buf []uint8) []float32 {
+	if len(buf) < SizeofFloat32 {
+		return nil
+	}
+	return unsafe.Slice((*float32)(unsafe.Pointer(&buf[0])), len(buf)/SizeofFloat32)
+}
+
 func ProcessRasterStack(rasterStack map[float64][]*FlexRaster, maskMap map[float64][]bool, canvasMap map[string]*FlexRaster) (map[string]*FlexRaster, error) {
 	var keys []float64
 	for k := range rasterStack {
@@ -289,10 +391,7 @@ func ProcessRasterStack(rasterStack map[float64][]*FlexRaster, maskMap map[float
 		for _, r := range rasterStack[geoStamp] {
 			if _, ok := canvasMap[r.NameSpace]; !ok {
 				// Raster namespace doesn't have a canvas yet
-				canvasMap[r.NameSpace] = &FlexRaster{TimeStamp: 0, ConfigPayLoad: r.ConfigPayLoad,
-					NoData: r.NoData, Data: initNoDataSlice(r.Type, r.NoData, r.Width*r.Height),
-					Height: r.Height, Width: r.Width, OffX: r.OffX, OffY: r.OffY,
-					Type: r.Type, NameSpace: r.NameSpace}
+				canvasMap[r.NameSpace] = newCanvas(r)
 			}
 			if mask, ok := maskMap[geoStamp]; ok {
 				err = MergeMaskedRaster(r, canvasMap, mask)
@@ -450,6 +549,7 @@ func (enc *RasterMerger) Run(bandExpr *utils.BandExpressions, verbose bool) {
 	defer close(enc.Out)
 
 	canvasMap := map[string]*FlexRaster{}
+	stampMap := map[string][]float64{}
 	for inRasters := range enc.In {
 		select {
 		case <-enc.Context.Done():
@@ -469,6 +569,14 @@ func (enc *RasterMerger) Run(bandExpr *utils.BandExpressions, verbose bool) {
 				continue
 			}
 
+			if r.Streamed {
+				if err := mergeStreamed(r, canvasMap, stampMap); err != nil {
+					enc.sendError(err)
+					return
+				}
+				continue
+			}
+
 			h := fnv.New32a()
 			h.Write([]byte(r.Polygon))
 			geoStamp := r.TimeStamp + float64(h.Sum32())
@@ -747,6 +855,29 @@ func (enc *RasterMerger) Run(bandExpr *utils.BandExpressions, verbose bool) {
 	enc.Out <- out
 }
 
+func mergeStreamed(r *FlexRaster, canvasMap map[string]*FlexRaster, stampMap map[string][]float64) error {
+	canvas, ok := canvasMap[r.NameSpace]
+	if !ok {
+		canvas = newCanvas(r)
+		canvasMap[r.NameSpace] = canvas
+		stampMap[r.NameSpace] = make([]float64, r.Width*r.Height)
+	}
+
+	mask := make([]bool, r.DataHeight*r.DataWidth)
+	if r.GeomMask != nil {
+		for i, m := range r.GeomMask {
+			if i >= len(mask) {
+				break
+			}
+			if m != 255 {
+				mask[i] = true
+			}
+		}
+	}
+
+	return MergeStreamedRaster(r, canvas, stampMap[r.NameSpace], mask)
+}
+
 func (enc *RasterMerger) sendError(err error) {
 	select {
 	case enc.Error <- err:
@@ -0,0 +1,72 @@
+package processor
+
+import (
+	"testing"
+)
+
+func TestMergeStreamedRasterOrder(t *testing.T) {
+	newRaster := func(ts float64, data []uint8) *FlexRaster {
+		return &FlexRaster{Data: data, Height: 1, Width: 3, DataHeight: 1, DataWidth: 3, Type: "Byte", NoData: 0, NameSpace: "ns", TimeStamp: ts}
+	}
+
+	orders := [][]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+	expected := []uint8{1, 2, 3}
+	for _, order := range orders {
+		rasters := []*FlexRaster{
+			newRaster(1, []uint8{1, 1, 1}),
+			newRaster(2, []uint8{0, 2, 2}),
+			newRaster(3, []uint8{0, 0, 3}),
+		}
+
+		canvasMap := map[string]*FlexRaster{}
+		stampMap := map[string][]float64{}
+		for _, i := range order {
+			if err := mergeStreamed(rasters[i], canvasMap, stampMap); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		canvas := canvasMap["ns"]
+		for i, val := range expected {
+			if canvas.Data[i] != val {
+				t.Errorf("order %v: expected %v, got %v", order, expected, canvas.Data)
+				break
+			}
+		}
+		if canvas.TimeStamp != 3 {
+			t.Errorf("order %v: expected canvas timestamp 3, got %v", order, canvas.TimeStamp)
+		}
+	}
+}
+
+func TestInitNoDataSlice(t *testing.T) {
+	testCases := []struct {
+		rType string
+		size  int
+	}{
+		{"SignedByte", 1}, {"Byte", 1}, {"Int16", 2}, {"UInt16", 2}, {"Float32", 4},
+	}
+	for _, tc := range testCases {
+		data := initNoDataSlice(tc.rType, 7, 5)
+		if len(data) != 5*tc.size {
+			t.Errorf("%s: expected %d bytes, got %d", tc.rType, 5*tc.size, len(data))
+			continue
+		}
+		canvas := &FlexRaster{Data: data, Height: 1, Width: 5, Type: tc.rType, NoData: 7}
+		r := &FlexRaster{Data: initNoDataSlice(tc.rType, 0, 5), Height: 1, Width: 5, DataHeight: 1, DataWidth: 5, Type: tc.rType, NoData: 0, TimeStamp: 1}
+		r.Data[tc.size] = 1
+		if err := MergeStreamedRaster(r, canvas, make([]float64, 5), make([]bool, 5)); err != nil {
+			t.Fatal(err)
+		}
+		if got := initNoDataSlice(tc.rType, 7, 1); string(canvas.Data[:tc.size]) != string(got) {
+			t.Errorf("%s: expected the nodata of the canvas kept, got %v", tc.rType, canvas.Data)
+		}
+		if string(canvas.Data[tc.size:2*tc.size]) != string(r.Data[tc.size:2*tc.size]) {
+			t.Errorf("%s: expected the value of the raster merged, got %v", tc.rType, canvas.Data)
+		}
+	}
+
+	if data := initNoDataSlice("Int16", 0, 0); len(data) != 0 {
+		t.Errorf("expected an empty canvas, got %v", data)
+	}
+}
@@ -520,6 +520,10 @@ func (dp *TilePipeline) prepareInputGeoRequests(geoReq *GeoTileRequest, depLayer
 			ReqRes:              geoReq.ReqRes,
 			SRSCf:               layer.SRSCf,
 			FusionUnscale:       geoReq.FusionUnscale,
+			StreamingMerge:      layer.StreamingMerge,
+			GrpcChecksum:        geoReq.GrpcChecksum,
+			WarpBackend:         layer.WarpBackend,
+			ResampleAlg:         layer.Resampling,
 			GrpcTileXSize:       layer.GrpcTileXSize,
 			GrpcTileYSize:       layer.GrpcTileYSize,
 			IndexTileXSize:      layer.IndexTileXSize,
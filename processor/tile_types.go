@@ -28,19 +28,25 @@ type ConfigPayLoad struct {
 	QueryLimit            int
 	UserSrcGeoTransform   int
 	UserSrcSRS            int
-	NoReprojection        bool
-	AxisMapping           int
-	GrpcTileXSize         float64
-	GrpcTileYSize         float64
-	IndexTileXSize        float64
-	IndexTileYSize        float64
-	SpatialExtent         []float64
-	IndexResLimit         float64
-	MasQueryHint          string
-	ReqRes                float64
-	SRSCf                 int
-	FusionUnscale         int
-	MetricsCollector      *metrics.MetricsCollector
+	// NativeCRS is the WKT overriding the CRS of the data files
+	NativeCRS        string
+	NoReprojection   bool
+	AxisMapping      int
+	GrpcTileXSize    float64
+	GrpcTileYSize    float64
+	IndexTileXSize   float64
+	IndexTileYSize   float64
+	SpatialExtent    []float64
+	IndexResLimit    float64
+	MasQueryHint     string
+	ReqRes           float64
+	SRSCf            int
+	FusionUnscale    int
+	StreamingMerge   bool
+	GrpcChecksum     bool
+	WarpBackend      string
+	ResampleAlg      string
+	MetricsCollector *metrics.MetricsCollector
 }
 
 type GeoTileIdxSelector struct {
@@ -95,6 +101,7 @@ type GeoTileGranule struct {
 	RasterType          string
 	GeoLocation         *GeoLocInfo
 	ClipFeature         *geo.Feature
+	COG                 bool
 }
 
 type FlexRaster struct {
@@ -109,6 +116,7 @@ type FlexRaster struct {
 	TimeStamp             float64
 	Polygon               string
 	GeomMask              []int32
+	Streamed              bool
 }
 
 type Raster interface {
@@ -0,0 +1,146 @@
+// Package quota defines the daily and monthly quotas of data delivered
+// to each client, as required by the fair-use policy for the external
+// users. The bytes delivered are counted by the usage store, so that
+// the OWS processes sharing a store enforce the quotas together.
+package quota
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/nci/gsky/diskcache"
+)
+
+// DefaultServices are the services whose responses count towards the
+// quotas by default.
+var DefaultServices = []string{"WCS", "WPS", "DAP"}
+
+// The periods of the quotas.
+const (
+	Daily   = "daily"
+	Monthly = "monthly"
+)
+
+// Config is the quotas of the clients. It is configured in the service
+// config of the root namespace and applies to all namespaces.
+type Config struct {
+	// Services are the services counted and limited, WCS, WPS and DAP
+	// by default.
+	Services []string `json:"services"`
+	// Rules are matched in order, the first matching a client setting
+	// its quotas. The clients without rule are unlimited.
+	Rules []*Rule `json:"rules"`
+
+	services map[string]bool
+}
+
+// Rule sets the quotas of clients.
+type Rule struct {
+	// Clients are shell patterns of the clients, identified as in the
+	// usage reports by user:, token: or ip: followed by the user, the
+	// token ID or the address, e.g. user:* or token:*. A rule without
+	// clients matches every client.
+	Clients []string `json:"clients"`
+	// Daily and Monthly are the bytes delivered per UTC day and month,
+	// unlimited if 0.
+	Daily   diskcache.Size `json:"daily"`
+	Monthly diskcache.Size `json:"monthly"`
+}
+
+// Validate checks the config and sets its defaults.
+func (c *Config) Validate() error {
+	if len(c.Services) == 0 {
+		c.Services = DefaultServices
+	}
+	c.services = make(map[string]bool)
+	for _, s := range c.Services {
+		c.services[s] = true
+	}
+	for i, r := range c.Rules {
+		if r.Daily < 0 || r.Monthly < 0 {
+			return fmt.Errorf("data quota rules[%d]: negative quota", i)
+		}
+		for _, p := range r.Clients {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("data quota rules[%d]: invalid pattern %v: %v", i, p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Counts reports whether the responses of a service count towards the
+// quotas.
+func (c *Config) Counts(service string) bool {
+	return c.services[service]
+}
+
+// Rule returns the rule of a client, nil if it is unlimited.
+func (c *Config) Rule(client string) *Rule {
+	for _, r := range c.Rules {
+		if len(r.Clients) == 0 {
+			return r
+		}
+		for _, p := range r.Clients {
+			if ok, _ := path.Match(p, client); ok {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// Usage is the bytes delivered to a client during the current day and
+// month.
+type Usage struct {
+	Day   int64 `json:"day"`
+	Month int64 `json:"month"`
+}
+
+// Error is the error of a client which exceeded a quota.
+type Error struct {
+	Client string
+	Period string
+	Limit  int64
+	Used   int64
+	// Reset is the time the quota is reset at.
+	Reset time.Time
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s data quota of %s exceeded by %s with %s delivered, further downloads are refused until %s",
+		e.Period, FormatSize(e.Limit), e.Client, FormatSize(e.Used), e.Reset.Format(time.RFC3339))
+}
+
+// Check returns an *Error if a client with the usage exceeded a quota.
+func (c *Config) Check(client string, usage Usage, now time.Time) error {
+	r := c.Rule(client)
+	if r == nil {
+		return nil
+	}
+	now = now.UTC()
+	if r.Daily > 0 && usage.Day >= int64(r.Daily) {
+		reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return &Error{Client: client, Period: Daily, Limit: int64(r.Daily), Used: usage.Day, Reset: reset}
+	}
+	if r.Monthly > 0 && usage.Month >= int64(r.Monthly) {
+		reset := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		return &Error{Client: client, Period: Monthly, Limit: int64(r.Monthly), Used: usage.Month, Reset: reset}
+	}
+	return nil
+}
+
+// FormatSize formats a number of bytes in powers of 1024, e.g. 1.5 GB.
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGT"[exp])
+}
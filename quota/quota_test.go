@@ -0,0 +1,55 @@
+package quota
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	var c Config
+	err := json.Unmarshal([]byte(`{
+		"rules": [
+			{"clients": ["user:icpac", "ip:10.*"]},
+			{"clients": ["user:*", "token:*"], "daily": "10GB", "monthly": "100GB"},
+			{"daily": "1GB"}
+		]
+	}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Counts("WCS") || c.Counts("WMS") {
+		t.Errorf("unexpected default services: %v", c.Services)
+	}
+
+	const gb = int64(1) << 30
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	if err := c.Check("user:icpac", Usage{Day: 100 * gb, Month: 1000 * gb}, now); err != nil {
+		t.Errorf("expected unlimited client, got %v", err)
+	}
+	if err := c.Check("user:kenya", Usage{Day: 9 * gb, Month: 50 * gb}, now); err != nil {
+		t.Errorf("unexpected error within quota: %v", err)
+	}
+
+	err = c.Check("user:kenya", Usage{Day: 10 * gb, Month: 50 * gb}, now)
+	if qe, ok := err.(*Error); !ok || qe.Period != Daily || !qe.Reset.Equal(time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected daily quota error, got %v", err)
+	}
+	err = c.Check("token:abc", Usage{Day: gb, Month: 100 * gb}, now)
+	if qe, ok := err.(*Error); !ok || qe.Period != Monthly || !qe.Reset.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected monthly quota error, got %v", err)
+	}
+	if err := c.Check("ip:192.168.1.1", Usage{Day: gb}, now); err == nil {
+		t.Errorf("expected the default rule applied to anonymous clients")
+	} else if err.Error() != "daily data quota of 1.0 GB exceeded by ip:192.168.1.1 with 1.0 GB delivered, further downloads are refused until 2024-03-11T00:00:00Z" {
+		t.Errorf("unexpected error message: %v", err)
+	}
+
+	invalid := Config{Rules: []*Rule{{Clients: []string{"["}}}}
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("expected error for an invalid pattern")
+	}
+}
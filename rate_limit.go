@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/metrics"
+	"github.com/nci/gsky/utils"
+)
+
+// clientIP returns the IP address of the client of a request, through
+// the trusted proxies of the root config.
+func clientIP(r *http.Request) string {
+	if rootConfig := getConfigMap()["."]; rootConfig != nil {
+		return rootConfig.ServiceConfig.ClientIP(r)
+	}
+	return utils.ClientIP(r, nil)
+}
+
+// limitRequest applies the rate limits of the root config to an OWS
+// request and writes the 429 response if a limit is exceeded. The
+// release function returned must be called once the request is served.
+func limitRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, query map[string][]string, metricsCollector *metrics.MetricsCollector) (func(), bool) {
+	rootConfig := getConfigMap()["."]
+	if rootConfig == nil || rootConfig.ServiceConfig.RateLimits == nil {
+		return func() {}, true
+	}
+
+	request := "GetCoverage"
+	if _, isDap := query["dap4.ce"]; !isDap {
+		if len(query["request"]) == 0 {
+			return func() {}, true
+		}
+		request = query["request"][0]
+	}
+
+	ip := rootConfig.ServiceConfig.ClientIP(r)
+	var key string
+	if access := utils.AccessFromContext(ctx); access != nil {
+		if access.Token != nil {
+			key = "token:" + access.Token.ID
+		} else if len(access.User) > 0 {
+			key = "user:" + access.User
+		}
+	}
+
+	release, err := rootConfig.ServiceConfig.RateLimits.Acquire(request, ip, key)
+	if err != nil {
+		logging.FromContext(ctx).Warnf("%v", err)
+		metricsCollector.Info.HTTPStatus = http.StatusTooManyRequests
+		retryAfter := err.(*utils.RateLimitError).RetryAfter
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return nil, false
+	}
+	return release, true
+}
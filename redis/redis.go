@@ -0,0 +1,392 @@
+// Package redis implements a small Redis client, speaking RESP over a
+// pool of connections to a single node or to the masters of a Redis
+// Cluster, for the caches of GSKY. Only the commands of bulk string
+// arguments are supported, which covers GET, SET, MGET, DEL and PING.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNil is returned by Get for the keys not found.
+var ErrNil = errors.New("redis: nil")
+
+// Error is an error reply of the server.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Options configures a Client.
+type Options struct {
+	// Addrs are the host:port of the node or, with Cluster, of some of
+	// the nodes of the cluster, the others being discovered.
+	Addrs []string
+	// Password authenticates the connections with AUTH if set.
+	Password string
+	// DB is the database selected on a single node.
+	DB int
+	// Cluster routes the commands to the masters of the slots of their
+	// keys.
+	Cluster bool
+	// PoolSize is the maximum number of idle connections kept per node,
+	// 8 by default.
+	PoolSize int
+	// Timeout bounds the dial and each command, 5 seconds by default.
+	Timeout time.Duration
+}
+
+// Client is a Redis client safe for concurrent use.
+type Client struct {
+	opts Options
+
+	mu    sync.Mutex
+	pools map[string]chan *conn
+	slots []string
+}
+
+// New returns a client of the nodes of opts. The slots of a cluster are
+// loaded from the first node answering.
+func New(opts Options) (*Client, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("redis: no address")
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 8
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	c := &Client{opts: opts, pools: make(map[string]chan *conn)}
+	if opts.Cluster {
+		if err := c.loadSlots(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Get returns the value of a key, or ErrNil if not found.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := c.Do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrNil
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected GET reply %T", reply)
+	}
+	return value, nil
+}
+
+// MGet returns the values of the keys found by key. The keys of a
+// cluster are read one by one as they may be of different slots.
+func (c *Client) MGet(ctx context.Context, keys ...string) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	if c.opts.Cluster {
+		for _, key := range keys {
+			value, err := c.Get(ctx, key)
+			if err == ErrNil {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			values[key] = value
+		}
+		return values, nil
+	}
+
+	reply, err := c.Do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	replies, ok := reply.([]interface{})
+	if !ok || len(replies) != len(keys) {
+		return nil, fmt.Errorf("redis: unexpected MGET reply %T", reply)
+	}
+	for i, r := range replies {
+		if value, ok := r.([]byte); ok {
+			values[keys[i]] = value
+		}
+	}
+	return values, nil
+}
+
+// Set sets the value of a key, expiring after ttl if not 0.
+func (c *Client) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+// Ping checks that the node, or a node of the cluster, answers.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Do sends a command and returns its reply: nil, a string for the
+// status replies, an int64, a []byte or a []interface{}. The error
+// replies are returned as Error. The commands of a cluster are sent to
+// the master of the slot of their first key, following the MOVED and
+// ASK redirections.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	addr := c.opts.Addrs[0]
+	if c.opts.Cluster && len(args) > 1 {
+		addr = c.slotAddr(slot(args[1]))
+	}
+
+	var asking bool
+	for redirects := 0; ; redirects++ {
+		reply, err := c.do(ctx, addr, asking, args)
+		e, isError := err.(Error)
+		if !c.opts.Cluster || !isError || redirects >= 3 {
+			return reply, err
+		}
+		// MOVED <slot> <addr> and ASK <slot> <addr>
+		fields := strings.Fields(string(e))
+		if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+			return reply, err
+		}
+		addr, asking = fields[2], fields[0] == "ASK"
+		if !asking {
+			if n, err := strconv.Atoi(fields[1]); err == nil {
+				c.mu.Lock()
+				c.slots[n] = addr
+				c.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, addr string, asking bool, args []string) (interface{}, error) {
+	cn, err := c.get(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(c.opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.SetDeadline(deadline)
+
+	if asking {
+		if _, err := cn.roundTrip([]string{"ASKING"}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	reply, err := cn.roundTrip(args)
+	if _, isError := err.(Error); err != nil && !isError {
+		cn.Close()
+		return nil, err
+	}
+	c.put(addr, cn)
+	return reply, err
+}
+
+// get returns an idle connection to addr or a new one.
+func (c *Client) get(ctx context.Context, addr string) (*conn, error) {
+	select {
+	case cn := <-c.pool(addr):
+		return cn, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: c.opts.Timeout}
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	cn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	if len(c.opts.Password) > 0 {
+		if _, err := cn.roundTrip([]string{"AUTH", c.opts.Password}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.opts.DB != 0 && !c.opts.Cluster {
+		if _, err := cn.roundTrip([]string{"SELECT", strconv.Itoa(c.opts.DB)}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(addr string, cn *conn) {
+	select {
+	case c.pool(addr) <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (c *Client) pool(addr string) chan *conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, found := c.pools[addr]
+	if !found {
+		p = make(chan *conn, c.opts.PoolSize)
+		c.pools[addr] = p
+	}
+	return p
+}
+
+// slotAddr returns the address of the master of a slot.
+func (c *Client) slotAddr(n int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if addr := c.slots[n]; len(addr) > 0 {
+		return addr
+	}
+	return c.opts.Addrs[0]
+}
+
+// loadSlots loads the masters of the slots with CLUSTER SLOTS.
+func (c *Client) loadSlots(ctx context.Context) error {
+	var lastErr error
+	for _, addr := range c.opts.Addrs {
+		reply, err := c.do(ctx, addr, false, []string{"CLUSTER", "SLOTS"})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		slots := make([]string, clusterSlots)
+		ranges, _ := reply.([]interface{})
+		for _, r := range ranges {
+			// start, end, [ip, port, id], replicas
+			fields, _ := r.([]interface{})
+			if len(fields) < 3 {
+				continue
+			}
+			start, _ := fields[0].(int64)
+			end, _ := fields[1].(int64)
+			master, _ := fields[2].([]interface{})
+			if len(master) < 2 || start < 0 || end >= clusterSlots {
+				continue
+			}
+			ip, _ := master[0].([]byte)
+			port, _ := master[1].(int64)
+			host := string(ip)
+			if len(host) == 0 {
+				host, _, _ = net.SplitHostPort(addr)
+			}
+			for n := start; n <= end; n++ {
+				slots[n] = net.JoinHostPort(host, strconv.FormatInt(port, 10))
+			}
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("redis: failed to load the cluster slots: %v", lastErr)
+}
+
+// clusterSlots is the number of hash slots of a Redis Cluster.
+const clusterSlots = 16384
+
+// slot returns the hash slot of a key, that of its {hash tag} if any.
+func slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// crc16 is the CRC16-CCITT (XModem) checksum of the cluster slots.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// conn is a connection speaking RESP.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (cn *conn) roundTrip(args []string) (interface{}, error) {
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(cn.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(cn.r)
+}
+
+// readReply reads a RESP reply.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, Error(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			replies[i], err = readReply(r)
+			if _, isError := err.(Error); err != nil && !isError {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
@@ -0,0 +1,140 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer serves GET, SET, MGET and PING from a map, answering the
+// keys of moved with a MOVED redirection.
+type fakeServer struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	data  map[string]string
+	moved map[string]string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, data: make(map[string]string), moved: make(map[string]string)}
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(nc)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeServer) serve(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		s.mu.Lock()
+		var out string
+		if addr, found := s.moved[args[len(args)-1]]; found && len(args) == 2 {
+			out = fmt.Sprintf("-MOVED %d %s\r\n", slot(args[1]), addr)
+		} else {
+			switch strings.ToUpper(args[0]) {
+			case "PING":
+				out = "+PONG\r\n"
+			case "SET":
+				s.data[args[1]] = args[2]
+				out = "+OK\r\n"
+			case "GET":
+				out = bulk(s.data, args[1])
+			case "MGET":
+				out = fmt.Sprintf("*%d\r\n", len(args)-1)
+				for _, key := range args[1:] {
+					out += bulk(s.data, key)
+				}
+			default:
+				out = "-ERR unknown command\r\n"
+			}
+		}
+		s.mu.Unlock()
+		nc.Write([]byte(out))
+	}
+}
+
+func bulk(data map[string]string, key string) string {
+	value, found := data[key]
+	if !found {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func TestClient(t *testing.T) {
+	s := newFakeServer(t)
+	c, err := New(Options{Addrs: []string{s.ln.Addr().String()}, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "a", []byte("x\r\ny"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Get(ctx, "a"); err != nil || string(value) != "x\r\ny" {
+		t.Errorf("unexpected value %q, %v", value, err)
+	}
+	if _, err := c.Get(ctx, "b"); err != ErrNil {
+		t.Errorf("expected ErrNil, got %v", err)
+	}
+	if values, err := c.MGet(ctx, "a", "b"); err != nil || len(values) != 1 || string(values["a"]) != "x\r\ny" {
+		t.Errorf("unexpected values %q, %v", values, err)
+	}
+	if _, err := c.Do(ctx, "FLUSHALL"); err == nil || err.Error() != "ERR unknown command" {
+		t.Errorf("expected error reply, got %v", err)
+	}
+}
+
+func TestClusterRedirect(t *testing.T) {
+	s1, s2 := newFakeServer(t), newFakeServer(t)
+	s2.data["k"] = "v"
+	s1.moved["k"] = s2.ln.Addr().String()
+
+	c := &Client{opts: Options{Addrs: []string{s1.ln.Addr().String()}, Cluster: true, PoolSize: 1, Timeout: time.Second}, pools: make(map[string]chan *conn), slots: make([]string, clusterSlots)}
+	if value, err := c.Get(context.Background(), "k"); err != nil || string(value) != "v" {
+		t.Fatalf("unexpected value %q, %v", value, err)
+	}
+	if addr := c.slotAddr(slot("k")); addr != s2.ln.Addr().String() {
+		t.Errorf("expected the slot moved to %s, got %s", s2.ln.Addr(), addr)
+	}
+}
+
+func TestSlot(t *testing.T) {
+	// The examples of the Redis Cluster specification.
+	if got := crc16("123456789"); got != 0x31c3 {
+		t.Errorf("unexpected crc16 %x", got)
+	}
+	if slot("{user1000}.following") != slot("{user1000}.followers") {
+		t.Errorf("expected the keys of a hash tag in the same slot")
+	}
+}
@@ -0,0 +1,93 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, 10, 14, 10, 17, 30, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		expr string
+		next string
+	}{
+		{"* * * * *", "2026-10-14T10:18:00Z"},
+		{"0 3 * * *", "2026-10-15T03:00:00Z"},
+		{"*/15 * * * *", "2026-10-14T10:30:00Z"},
+		{"5/20 * * * *", "2026-10-14T10:25:00Z"},
+		{"0 9-17/4 * * mon-fri", "2026-10-14T13:00:00Z"},
+		{"30 2 * * sat,sun", "2026-10-17T02:30:00Z"},
+		{"0 0 * * 7", "2026-10-18T00:00:00Z"},
+		{"0 0 1 jan *", "2027-01-01T00:00:00Z"},
+		{"0 0 29 2 *", "2028-02-29T00:00:00Z"},
+		// Either the day of month or the day of week when both are set.
+		{"0 0 1 * fri", "2026-10-16T00:00:00Z"},
+		{"@hourly", "2026-10-14T11:00:00Z"},
+		{"@monthly", "2026-11-01T00:00:00Z"},
+		{"@weekly", "2026-10-18T00:00:00Z"},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if next := s.Next(from).Format(time.RFC3339); next != test.next {
+			t.Errorf("%s: next run %s instead of %s", test.expr, next, test.next)
+		}
+	}
+}
+
+func TestNextStrictlyAfter(t *testing.T) {
+	s, err := Parse("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)
+	if next := s.Next(run); !next.Equal(run.AddDate(0, 0, 1)) {
+		t.Errorf("next run of %v at %v", run, next)
+	}
+}
+
+func TestNextLocation(t *testing.T) {
+	nairobi := time.FixedZone("EAT", 3*3600)
+	s, err := Parse("0 6 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := s.Next(time.Date(2026, 10, 14, 5, 0, 0, 0, nairobi))
+	if want := time.Date(2026, 10, 14, 6, 0, 0, 0, nairobi); !next.Equal(want) {
+		t.Errorf("next run %v instead of %v", next, want)
+	}
+}
+
+func TestNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("next run of %s at %v", s, next)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@often",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("%q parsed", expr)
+		}
+	}
+}
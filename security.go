@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/nci/gsky/utils"
+)
+
+// securityHandler sets the security headers of the root config on the
+// responses of h, and rejects the requests whose Host header, reflected
+// in the capabilities and the catalogues, isn't a host name.
+func securityHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var headers *utils.SecurityHeaders
+		if rootConfig := getConfigMap()["."]; rootConfig != nil {
+			headers = rootConfig.ServiceConfig.SecurityHeaders
+		}
+		headers.Set(w.Header(), utils.ParseRequestProtocol(r) == "https")
+
+		if !utils.ValidHost(r.Host) {
+			http.Error(w, "invalid Host header", http.StatusBadRequest)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,211 @@
+// Package seeding defines the tile seeding jobs, which render the GetMap
+// tiles of layers over a region and a range of zoom levels ahead of the
+// first viewers so that they are served from the tile cache, e.g. the
+// latest forecast run over the IGAD region every morning.
+package seeding
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TileSize is the width and height of the tiles.
+const TileSize = 256
+
+// MaxZoom is the highest zoom level of the tiles.
+const MaxZoom = 22
+
+// MaxTiles bounds the number of tiles rendered by a job.
+const MaxTiles = 1000000
+
+// DefaultConcurrency is the number of tiles rendered at the same time
+// by default.
+const DefaultConcurrency = 2
+
+// webMercatorOrigin is the half width of the EPSG:3857 tile matrix.
+const webMercatorOrigin = 20037508.342789244
+
+// maxLatitude is the latitude of the edges of the EPSG:3857 tile matrix.
+const maxLatitude = 85.0511
+
+// Job renders the EPSG:3857 tiles of layers over a region.
+type Job struct {
+	Name string `json:"name"`
+	// NameSpace is the namespace of the layers, the root namespace by
+	// default.
+	NameSpace string   `json:"namespace"`
+	Layers    []string `json:"layers"`
+	// BBox is the EPSG:4326 extent of the tiles: minx,miny,maxx,maxy.
+	BBox    []float64 `json:"bbox"`
+	MinZoom int       `json:"min_zoom"`
+	MaxZoom int       `json:"max_zoom"`
+	// Times are the timestamps of the tiles, the current timestamp of
+	// each layer by default.
+	Times []string `json:"times"`
+	// Params are the query parameters added to the GetMap requests or
+	// replacing them, removed if empty, so that the tiles are those
+	// requested by the map clients, e.g. {"transparent": "true"}.
+	Params map[string]string `json:"params"`
+	// Schedule are the times of the day in UTC the job runs at, e.g.
+	// "06:30". The job only runs on demand if empty.
+	Schedule []string `json:"schedule"`
+	// Concurrency is the number of tiles rendered at the same time.
+	Concurrency int `json:"concurrency"`
+
+	schedule []time.Duration
+}
+
+// Validate checks the job and sets its defaults.
+func (j *Job) Validate() error {
+	if len(j.Name) == 0 {
+		return fmt.Errorf("tile seeding job without name")
+	}
+	if len(j.NameSpace) == 0 {
+		j.NameSpace = "."
+	}
+	if len(j.Layers) == 0 {
+		return fmt.Errorf("tile seeding job %s: no layers", j.Name)
+	}
+	if len(j.BBox) != 4 || j.BBox[0] >= j.BBox[2] || j.BBox[1] >= j.BBox[3] ||
+		j.BBox[0] < -180 || j.BBox[2] > 180 || j.BBox[1] < -90 || j.BBox[3] > 90 {
+		return fmt.Errorf("tile seeding job %s: invalid bbox %v, expected minx,miny,maxx,maxy in EPSG:4326", j.Name, j.BBox)
+	}
+	if j.MinZoom < 0 || j.MaxZoom < j.MinZoom || j.MaxZoom > MaxZoom {
+		return fmt.Errorf("tile seeding job %s: invalid zoom levels %d-%d", j.Name, j.MinZoom, j.MaxZoom)
+	}
+	if j.Concurrency < 0 {
+		return fmt.Errorf("tile seeding job %s: invalid concurrency %d", j.Name, j.Concurrency)
+	}
+	if j.Concurrency == 0 {
+		j.Concurrency = DefaultConcurrency
+	}
+	if n := j.Count(); n > MaxTiles {
+		return fmt.Errorf("tile seeding job %s: %d tiles, more than %d", j.Name, n, MaxTiles)
+	}
+
+	j.schedule = nil
+	for _, s := range j.Schedule {
+		t, err := time.Parse("15:04", s)
+		if err != nil {
+			return fmt.Errorf("tile seeding job %s: invalid schedule %s, expected HH:MM", j.Name, s)
+		}
+		j.schedule = append(j.schedule, time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)
+	}
+	return nil
+}
+
+// Count returns the number of tiles of the job.
+func (j *Job) Count() int {
+	n := 0
+	for z := j.MinZoom; z <= j.MaxZoom; z++ {
+		x0, y0, x1, y1 := j.tileRange(z)
+		n += (x1 - x0 + 1) * (y1 - y0 + 1)
+	}
+	times := len(j.Times)
+	if times == 0 {
+		times = 1
+	}
+	return n * len(j.Layers) * times
+}
+
+// tileRange returns the first and last tiles of the bbox at a zoom level.
+func (j *Job) tileRange(z int) (int, int, int, int) {
+	x0, y1 := LonLatToTile(j.BBox[0], j.BBox[1], z)
+	x1, y0 := LonLatToTile(j.BBox[2], j.BBox[3], z)
+	return x0, y0, x1, y1
+}
+
+// Requests calls f with the GetMap query of each tile of the job, the
+// low zoom levels first, until f returns false.
+func (j *Job) Requests(f func(query url.Values) bool) {
+	times := j.Times
+	if len(times) == 0 {
+		times = []string{""}
+	}
+	for z := j.MinZoom; z <= j.MaxZoom; z++ {
+		x0, y0, x1, y1 := j.tileRange(z)
+		for _, timestamp := range times {
+			for _, layer := range j.Layers {
+				for y := y0; y <= y1; y++ {
+					for x := x0; x <= x1; x++ {
+						if !f(j.query(layer, timestamp, z, x, y)) {
+							return
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (j *Job) query(layer, timestamp string, z, x, y int) url.Values {
+	bbox := TileBBox(z, x, y)
+	coords := make([]string, len(bbox))
+	for i, c := range bbox {
+		coords[i] = strconv.FormatFloat(c, 'f', -1, 64)
+	}
+	query := url.Values{
+		"service": {"WMS"},
+		"request": {"GetMap"},
+		"version": {"1.3.0"},
+		"layers":  {layer},
+		"styles":  {""},
+		"crs":     {"EPSG:3857"},
+		"bbox":    {strings.Join(coords, ",")},
+		"width":   {strconv.Itoa(TileSize)},
+		"height":  {strconv.Itoa(TileSize)},
+		"format":  {"image/png"},
+	}
+	if len(timestamp) > 0 {
+		query.Set("time", timestamp)
+	}
+	for key, value := range j.Params {
+		key = strings.ToLower(key)
+		if len(value) > 0 {
+			query.Set(key, value)
+		} else {
+			query.Del(key)
+		}
+	}
+	return query
+}
+
+// Scheduled returns the last time the job was scheduled to run at
+// before now, the zero time if it has no schedule.
+func (j *Job) Scheduled(now time.Time) time.Time {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var last time.Time
+	for _, offset := range j.schedule {
+		t := day.Add(offset)
+		if t.After(now) {
+			t = t.AddDate(0, 0, -1)
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// LonLatToTile returns the tile of a zoom level containing a point.
+func LonLatToTile(lon, lat float64, z int) (int, int) {
+	n := float64(int(1) << uint(z))
+	lat = math.Max(-maxLatitude, math.Min(maxLatitude, lat)) * math.Pi / 180
+	x := int((lon + 180) / 360 * n)
+	y := int((1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n)
+	clamp := func(v int) int { return int(math.Max(0, math.Min(n-1, float64(v)))) }
+	return clamp(x), clamp(y)
+}
+
+// TileBBox returns the EPSG:3857 extent of a tile: minx,miny,maxx,maxy.
+func TileBBox(z, x, y int) []float64 {
+	size := 2 * webMercatorOrigin / float64(int(1)<<uint(z))
+	minX := -webMercatorOrigin + float64(x)*size
+	maxY := webMercatorOrigin - float64(y)*size
+	return []float64{minX, maxY - size, minX + size, maxY}
+}
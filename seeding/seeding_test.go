@@ -0,0 +1,114 @@
+package seeding
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestJob(t *testing.T, conf string) *Job {
+	var job Job
+	if err := json.Unmarshal([]byte(conf), &job); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return &job
+}
+
+func TestRequests(t *testing.T) {
+	job := newTestJob(t, `{
+		"name": "igad",
+		"layers": ["chirps_daily", "gefs_forecast"],
+		"bbox": [21.8, -12.0, 51.5, 23.0],
+		"min_zoom": 0,
+		"max_zoom": 4,
+		"params": {"Transparent": "true", "styles": ""}
+	}`)
+	if job.NameSpace != "." || job.Concurrency != DefaultConcurrency {
+		t.Errorf("unexpected defaults: %+v", job)
+	}
+
+	var queries []url.Values
+	job.Requests(func(query url.Values) bool {
+		queries = append(queries, query)
+		return true
+	})
+	// 1 tile at zoom 0, 2 at 1 and 2, 2x2 at 3 and 3x3 at 4 for each
+	// layer.
+	if len(queries) != 2*(1+2+2+4+9) || job.Count() != len(queries) {
+		t.Fatalf("expected %d tiles, got %d requests", job.Count(), len(queries))
+	}
+	first := queries[0]
+	if first.Get("layers") != "chirps_daily" || first.Get("transparent") != "true" || first.Get("time") != "" {
+		t.Errorf("unexpected query: %v", first)
+	}
+	if _, found := first["styles"]; found {
+		t.Errorf("expected styles removed: %v", first)
+	}
+	if first.Get("bbox") != "-20037508.342789244,-20037508.342789244,20037508.342789244,20037508.342789244" {
+		t.Errorf("unexpected bbox of the zoom 0 tile: %s", first.Get("bbox"))
+	}
+
+	n := 0
+	job.Requests(func(query url.Values) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Errorf("expected the requests to stop, got %d", n)
+	}
+}
+
+func TestTiles(t *testing.T) {
+	if x, y := LonLatToTile(0, 0, 1); x != 1 || y != 1 {
+		t.Errorf("expected tile 1,1, got %d,%d", x, y)
+	}
+	if x, y := LonLatToTile(180, -90, 3); x != 7 || y != 7 {
+		t.Errorf("expected the edges clamped, got %d,%d", x, y)
+	}
+	bbox := TileBBox(1, 1, 0)
+	if bbox[0] != 0 || bbox[1] != 0 || math.Abs(bbox[2]-webMercatorOrigin) > 1e-6 || math.Abs(bbox[3]-webMercatorOrigin) > 1e-6 {
+		t.Errorf("unexpected tile bbox: %v", bbox)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	job := newTestJob(t, `{"name": "igad", "layers": ["a"], "bbox": [21.8, -12.0, 51.5, 23.0], "schedule": ["06:30", "18:00"]}`)
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	for now, expected := range map[time.Time]time.Time{
+		day.Add(5 * time.Hour):                day.Add(-6 * time.Hour),
+		day.Add(6*time.Hour + 30*time.Minute): day.Add(6*time.Hour + 30*time.Minute),
+		day.Add(20 * time.Hour):               day.Add(18 * time.Hour),
+	} {
+		if scheduled := job.Scheduled(now); !scheduled.Equal(expected) {
+			t.Errorf("expected %v scheduled at %v, got %v", expected, now, scheduled)
+		}
+	}
+
+	if scheduled := newTestJob(t, `{"name": "adhoc", "layers": ["a"], "bbox": [0, 0, 1, 1]}`).Scheduled(day); !scheduled.IsZero() {
+		t.Errorf("expected no schedule, got %v", scheduled)
+	}
+
+	invalid := []string{
+		`{"layers": ["a"], "bbox": [0, 0, 1, 1]}`,
+		`{"name": "a", "bbox": [0, 0, 1, 1]}`,
+		`{"name": "a", "layers": ["a"], "bbox": [0, 0, 1]}`,
+		`{"name": "a", "layers": ["a"], "bbox": [1, 0, 0, 1]}`,
+		`{"name": "a", "layers": ["a"], "bbox": [0, 0, 1, 1], "min_zoom": 5, "max_zoom": 3}`,
+		`{"name": "a", "layers": ["a"], "bbox": [-180, -90, 180, 90], "max_zoom": 12}`,
+		`{"name": "a", "layers": ["a"], "bbox": [0, 0, 1, 1], "schedule": ["6am"]}`,
+	}
+	for _, conf := range invalid {
+		var job Job
+		if err := json.Unmarshal([]byte(conf), &job); err != nil {
+			t.Fatal(err)
+		}
+		if err := job.Validate(); err == nil {
+			t.Errorf("expected error for %s", conf)
+		}
+	}
+}
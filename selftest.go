@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/utils"
+)
+
+// selfTestStage is the outcome of a stage of the self-test.
+type selfTestStage struct {
+	name     string
+	passed   bool
+	skipped  bool
+	detail   string
+	duration time.Duration
+}
+
+// selfTest exercises the chain serving a layer: MAS, the workers and
+// the rendering of WMS and WCS requests by this OWS.
+type selfTest struct {
+	namespace string
+	layer     *utils.Layer
+	config    *utils.Config
+	apiKey    string
+	timeout   time.Duration
+
+	bbox   []float64
+	time   string
+	stages []selfTestStage
+}
+
+// runSelfTest implements the `gsky selftest` subcommand. The stages are
+// run in order against a layer, by default the first layer of the
+// namespace, e.g. one of the sample data of the docker image, and their
+// outcome is printed. The exit status is 1 if any stage failed.
+func runSelfTest(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	namespace := fs.String("namespace", ".", "Namespace of the layer tested.")
+	layerName := fs.String("layer", "", "Name of the layer tested. Defaults to the first layer of the namespace.")
+	apiKey := fs.String("api_key", "", "API key or token of the OWS requests if access control is enabled.")
+	timeout := fs.Duration("timeout", 60*time.Second, "Timeout of each stage.")
+	fs.Parse(args)
+
+	st := &selfTest{namespace: *namespace, apiKey: *apiKey, timeout: *timeout}
+	st.run("config", func() (string, error) { return st.checkConfig(*layerName) })
+	st.run("mas", st.checkMAS)
+	st.run("worker", st.checkWorkers)
+	st.run("wms_getmap", st.checkGetMap)
+	st.run("wcs_getcoverage", st.checkGetCoverage)
+
+	failed := 0
+	for _, stage := range st.stages {
+		status := "PASS"
+		if stage.skipped {
+			status = "SKIP"
+		} else if !stage.passed {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%-4s %-16s %8.0fms  %s\n", status, stage.name, float64(stage.duration)/float64(time.Millisecond), stage.detail)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d stages failed\n", failed, len(st.stages))
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// run runs a stage, which is skipped if a previous one failed.
+func (st *selfTest) run(name string, stage func() (string, error)) {
+	for _, s := range st.stages {
+		if !s.passed {
+			st.stages = append(st.stages, selfTestStage{name: name, skipped: true, detail: "previous stage failed"})
+			return
+		}
+	}
+
+	t0 := time.Now()
+	detail, err := stage()
+	s := selfTestStage{name: name, passed: err == nil, detail: detail, duration: time.Since(t0)}
+	if err != nil {
+		s.detail = err.Error()
+	}
+	st.stages = append(st.stages, s)
+}
+
+func (st *selfTest) checkConfig(layerName string) (string, error) {
+	confMap := getConfigMap()
+	config, found := confMap[st.namespace]
+	if !found {
+		return "", fmt.Errorf("namespace %s not found", st.namespace)
+	}
+	if config == nil {
+		conf, err := utils.LoadConfigOnDemand(utils.EtcDir, st.namespace, *verbose)
+		if err != nil {
+			return "", err
+		}
+		config = conf[st.namespace]
+		utils.PostprocessServiceConfig(config, confMap, *verbose)
+	}
+
+	for i := range config.Layers {
+		if len(layerName) == 0 || config.Layers[i].Name == layerName {
+			st.layer = &config.Layers[i]
+			break
+		}
+	}
+	if st.layer == nil {
+		return "", fmt.Errorf("layer %q not found in namespace %s", layerName, st.namespace)
+	}
+	st.config = config
+	return fmt.Sprintf("%d namespaces, layer %s", len(confMap), st.layer.Name), nil
+}
+
+// checkMAS queries the extent of the data source of the layer, which is
+// the bbox of the OWS requests.
+func (st *selfTest) checkMAS() (string, error) {
+	masAddress := st.layer.MASAddress
+	if len(masAddress) == 0 {
+		masAddress = st.config.ServiceConfig.MASAddress
+	}
+	if len(masAddress) == 0 {
+		return "", fmt.Errorf("mas_address is empty")
+	}
+
+	client := &http.Client{Timeout: st.timeout}
+	query := fmt.Sprintf("http://%s%s?extents", masAddress, st.layer.DataSource)
+	resp, err := client.Get(query)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var extent struct {
+		XMin     *float64 `json:"xmin"`
+		YMin     *float64 `json:"ymin"`
+		XMax     *float64 `json:"xmax"`
+		YMax     *float64 `json:"ymax"`
+		MaxStamp string   `json:"max_stamp"`
+		Error    string   `json:"error"`
+	}
+	if err := json.Unmarshal(body, &extent); err != nil {
+		return "", fmt.Errorf("invalid MAS response: %v", err)
+	}
+	if len(extent.Error) > 0 {
+		return "", fmt.Errorf("MAS error: %s", extent.Error)
+	}
+	if extent.XMin == nil || extent.YMin == nil || extent.XMax == nil || extent.YMax == nil {
+		return "", fmt.Errorf("no files indexed under %s", st.layer.DataSource)
+	}
+	st.bbox = []float64{*extent.XMin, *extent.YMin, *extent.XMax, *extent.YMax}
+
+	if ts, err := utils.GetCurrentTimeStamp(st.layer.Dates); err == nil {
+		st.time = ts.Format(utils.ISOFormat)
+	} else if t, err := time.Parse("2006-01-02T15:04:05", extent.MaxStamp); err == nil {
+		st.time = t.Format(utils.ISOFormat)
+	}
+	return fmt.Sprintf("%s: %s indexed, latest %s", masAddress, st.layer.DataSource, st.time), nil
+}
+
+func (st *selfTest) checkWorkers() (string, error) {
+	nodes := st.config.ServiceConfig.WorkerNodes
+	if len(nodes) == 0 {
+		return "", fmt.Errorf("worker_nodes is empty")
+	}
+	var failed []string
+	poolSize := 0
+	for _, status := range utils.CheckWorkerStatus(nodes, st.timeout) {
+		if !status.Reachable {
+			failed = append(failed, fmt.Sprintf("%s: %s", status.Address, status.Error))
+		}
+		poolSize += status.PoolSize
+	}
+	if len(failed) > 0 {
+		return "", fmt.Errorf("%d of %d workers unreachable: %s", len(failed), len(nodes), strings.Join(failed, "; "))
+	}
+	return fmt.Sprintf("%d workers, %d processes", len(nodes), poolSize), nil
+}
+
+// request serves an OWS request in process and returns its response.
+func (st *selfTest) request(params url.Values) (*httptest.ResponseRecorder, error) {
+	if len(st.time) > 0 {
+		params.Set("time", st.time)
+	}
+	path := "/ows"
+	if st.namespace != "." {
+		path += "/" + st.namespace
+	}
+	r := httptest.NewRequest("GET", path+"?"+params.Encode(), nil)
+	if len(st.apiKey) > 0 {
+		r.Header.Set(utils.APIKeyHeader, st.apiKey)
+	}
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		owsHandler(w, r)
+	}()
+	select {
+	case <-done:
+	case <-time.After(st.timeout):
+		return nil, fmt.Errorf("timeout after %v", st.timeout)
+	}
+	if w.Code != http.StatusOK {
+		return nil, fmt.Errorf("%d: %s", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	return w, nil
+}
+
+func (st *selfTest) bboxParam() string {
+	return fmt.Sprintf("%f,%f,%f,%f", st.bbox[0], st.bbox[1], st.bbox[2], st.bbox[3])
+}
+
+func (st *selfTest) checkGetMap() (string, error) {
+	w, err := st.request(url.Values{
+		"service": {"WMS"},
+		"request": {"GetMap"},
+		"version": {"1.3.0"},
+		"layers":  {st.layer.Name},
+		"crs":     {"EPSG:3857"},
+		"bbox":    {st.bboxParam()},
+		"width":   {"256"},
+		"height":  {"256"},
+		"format":  {"image/png"},
+	})
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(w.Body.Bytes(), []byte("\x89PNG")) {
+		return "", fmt.Errorf("response is not a PNG image")
+	}
+	return fmt.Sprintf("256x256 PNG, %d bytes", w.Body.Len()), nil
+}
+
+func (st *selfTest) checkGetCoverage() (string, error) {
+	w, err := st.request(url.Values{
+		"service":  {"WCS"},
+		"request":  {"GetCoverage"},
+		"version":  {"1.0.0"},
+		"coverage": {st.layer.Name},
+		"crs":      {"EPSG:3857"},
+		"bbox":     {st.bboxParam()},
+		"width":    {"64"},
+		"height":   {"64"},
+		"format":   {"GeoTIFF"},
+	})
+	if err != nil {
+		return "", err
+	}
+	body := w.Body.Bytes()
+	if !bytes.HasPrefix(body, []byte("II*\x00")) && !bytes.HasPrefix(body, []byte("MM\x00*")) {
+		return "", fmt.Errorf("response is not a GeoTIFF")
+	}
+	return fmt.Sprintf("64x64 GeoTIFF, %d bytes", len(body)), nil
+}
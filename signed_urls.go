@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/urlsign"
+	"github.com/nci/gsky/utils"
+)
+
+var urlSigner *urlsign.Signer
+
+// defaultSignedURLTTL is the validity of the signed URLs if not given.
+const defaultSignedURLTTL = time.Hour
+
+// The prefixes of the signers of the signed URLs.
+const (
+	signerUser  = "user:"
+	signerToken = "token:"
+)
+
+// authoriseSigned verifies a signed OWS request and returns the access
+// of its signer. The URLs signed with an API token stop working once it
+// is revoked, and those signed by a user once the user is removed.
+func authoriseSigned(w http.ResponseWriter, r *http.Request, ac *utils.AccessControl, namespace string) (*utils.Access, bool) {
+	signer, err := urlSigner.Verify(r.URL, time.Now())
+	if err != nil {
+		writeAccessError(w, utils.NewAccessError(true, "%v", err))
+		return nil, false
+	}
+
+	switch {
+	case strings.HasPrefix(signer, signerToken):
+		if tokenStore == nil {
+			writeAccessError(w, utils.NewAccessError(true, "API tokens are disabled"))
+			return nil, false
+		}
+		token, err := tokenStore.Lookup(strings.TrimPrefix(signer, signerToken))
+		if err != nil {
+			writeAccessError(w, utils.NewAccessError(true, "token of the signed URL: %v", err))
+			return nil, false
+		}
+		return tokenAccess(w, token, ac, namespace)
+
+	case strings.HasPrefix(signer, signerUser):
+		access, err := utils.UserAccess(ac, strings.TrimPrefix(signer, signerUser), namespace)
+		if err != nil {
+			writeAccessError(w, err)
+			return nil, false
+		}
+		return access, true
+
+	default:
+		access, err := ac.Authorise(r, namespace)
+		if err != nil {
+			writeAccessError(w, err)
+			return nil, false
+		}
+		return access, true
+	}
+}
+
+// signHandler signs the URL of an OWS data request on behalf of the
+// caller, identified by its API key or token as for the OWS requests,
+// e.g. GET /sign?url=/ows/licensed?service=WCS%26request=GetCoverage...&ttl=3600
+// The signed URL carries the access of the caller until it expires.
+func signHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if urlSigner == nil {
+		http.Error(w, "signed URLs are disabled", http.StatusNotFound)
+		return
+	}
+
+	target, err := url.Parse(r.FormValue("url"))
+	if err != nil || (target.Path != "/ows" && !strings.HasPrefix(target.Path, "/ows/")) {
+		http.Error(w, "url must be an OWS request, e.g. /ows?service=WCS&request=GetCoverage&...", http.StatusBadRequest)
+		return
+	}
+	query, err := utils.ParseQuery(target.RawQuery)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse url: %v", err), http.StatusBadRequest)
+		return
+	}
+	if _, _, _, data := auditedRequest(query); !data {
+		http.Error(w, "only the WCS GetCoverage, DAP and WPS Execute requests can be signed", http.StatusBadRequest)
+		return
+	}
+
+	maxTTL := time.Duration(*signedURLMaxTTL) * time.Second
+	ttl := defaultSignedURLTTL
+	if v := r.FormValue("ttl"); len(v) > 0 {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			http.Error(w, fmt.Sprintf("invalid ttl: %s", v), http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	r, authorised := authoriseOWS(w, r, getConfigMap(), owsNameSpace("/ows/", target.Path))
+	if !authorised {
+		return
+	}
+	var signer string
+	if access := utils.AccessFromContext(r.Context()); access != nil {
+		if access.Token != nil {
+			signer = signerToken + access.Token.ID
+		} else if len(access.User) > 0 {
+			signer = signerUser + access.User
+		}
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	signed := urlSigner.Sign(&url.URL{Path: target.Path, RawQuery: target.RawQuery}, signer, expires)
+	Info.Printf("URL signed for %q until %s: %s", signer, expires.UTC().Format(utils.ISOFormat), target.Path)
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"url":     utils.GetHostURL(r) + signed.String(),
+		"expires": expires.UTC(),
+	})
+}
@@ -0,0 +1,421 @@
+// Package slo tracks the service level indicators of the OWS requests,
+// availability and latency, per service and per layer, and reports the
+// error budget left by their objectives over a rolling window.
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The defaults of the config.
+const (
+	DefaultWindow         = 30 * 24 * time.Hour
+	DefaultReportInterval = 24 * time.Hour
+)
+
+// maxBuckets is the number of buckets of the window, which sets the
+// resolution of the indicators.
+const maxBuckets = 720
+
+// burnWindow is the recent period the burn rate is measured over.
+const burnWindow = time.Hour
+
+// Config is the objectives of the OWS requests.
+type Config struct {
+	// Window is the rolling period of the objectives, e.g. 720h.
+	Window string `json:"window"`
+	// ReportInterval is the interval of the error budget reports
+	// written to the log.
+	ReportInterval string `json:"report_interval"`
+	// StateFile keeps the indicators across restarts if set.
+	StateFile  string       `json:"state_file"`
+	Objectives []*Objective `json:"objectives"`
+
+	window         time.Duration
+	reportInterval time.Duration
+}
+
+// Objective is the availability and latency objectives of the requests
+// of the services and layers matched. The first objective matching a
+// request applies.
+type Objective struct {
+	// Services are the services matched, e.g. WMS or DAP, all if empty.
+	Services []string `json:"services"`
+	// Layers are the shell patterns of the layers matched. The
+	// objectives without layers also apply to the totals of their
+	// services.
+	Layers []string `json:"layers"`
+	// Availability is the fraction of requests not failing with a
+	// server error, e.g. 0.999.
+	Availability float64 `json:"availability"`
+	// LatencyThreshold is the duration, e.g. 2s, within which the
+	// fraction LatencyTarget of the requests must be served.
+	LatencyThreshold string  `json:"latency_threshold"`
+	LatencyTarget    float64 `json:"latency_target"`
+
+	latencyThreshold time.Duration
+}
+
+// Validate checks the config and sets its defaults.
+func (c *Config) Validate() error {
+	var err error
+	if c.window, err = parseDuration("window", c.Window, DefaultWindow); err != nil {
+		return err
+	}
+	if c.reportInterval, err = parseDuration("report_interval", c.ReportInterval, DefaultReportInterval); err != nil {
+		return err
+	}
+	if len(c.Objectives) == 0 {
+		return fmt.Errorf("slo objectives are required")
+	}
+	for i, o := range c.Objectives {
+		if o.Availability == 0 && len(o.LatencyThreshold) == 0 {
+			return fmt.Errorf("slo objectives[%d] must have an availability or a latency_threshold", i)
+		}
+		if o.Availability < 0 || o.Availability >= 1 {
+			return fmt.Errorf("slo objectives[%d]: availability must be between 0 and 1: %v", i, o.Availability)
+		}
+		o.latencyThreshold = 0
+		if len(o.LatencyThreshold) > 0 {
+			if o.latencyThreshold, err = parseDuration(fmt.Sprintf("objectives[%d] latency_threshold", i), o.LatencyThreshold, 0); err != nil {
+				return err
+			}
+			if o.LatencyTarget <= 0 || o.LatencyTarget >= 1 {
+				return fmt.Errorf("slo objectives[%d]: latency_target must be between 0 and 1: %v", i, o.LatencyTarget)
+			}
+		}
+		for _, p := range o.Layers {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("slo objectives[%d]: invalid pattern %v: %v", i, p, err)
+			}
+		}
+	}
+	return nil
+}
+
+func parseDuration(name, value string, defaultValue time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("slo %s must be a positive duration: %v", name, value)
+	}
+	return d, nil
+}
+
+// ReportIntervalDuration returns the interval of the reports.
+func (c *Config) ReportIntervalDuration() time.Duration {
+	return c.reportInterval
+}
+
+// objective returns the objective of a request, nil if none. The totals
+// of a service have an empty layer.
+func (c *Config) objective(service, layer string) *Objective {
+	for _, o := range c.Objectives {
+		if len(o.Services) > 0 && !contains(o.Services, service) {
+			continue
+		}
+		if len(layer) == 0 {
+			if len(o.Layers) == 0 {
+				return o
+			}
+			continue
+		}
+		if len(o.Layers) == 0 {
+			return o
+		}
+		for _, p := range o.Layers {
+			if matched, _ := path.Match(p, layer); matched {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket counts the requests of a period of the window.
+type bucket struct {
+	Index  int64 `json:"i"`
+	Total  int64 `json:"t"`
+	Errors int64 `json:"e"`
+	Slow   int64 `json:"s"`
+}
+
+type seriesKey struct {
+	service, layer string
+}
+
+// series is the buckets of a service and layer, oldest first.
+type series struct {
+	Service string    `json:"service"`
+	Layer   string    `json:"layer,omitempty"`
+	Buckets []*bucket `json:"buckets"`
+}
+
+// Tracker counts the requests of the objectives of a config.
+type Tracker struct {
+	mu         sync.Mutex
+	config     *Config
+	bucketSize time.Duration
+	series     map[seriesKey]*series
+	since      time.Time
+}
+
+// Open returns a tracker of a validated config, restoring the indicators
+// saved in its state file, if any.
+func Open(config *Config) (*Tracker, error) {
+	t := &Tracker{series: make(map[seriesKey]*series), since: time.Now()}
+	t.Reconfigure(config)
+	if len(config.StateFile) > 0 {
+		if err := t.load(config.StateFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Reconfigure applies a new validated config. The indicators recorded
+// are kept if the window is unchanged.
+func (t *Tracker) Reconfigure(config *Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bucketSize := config.window / maxBuckets
+	if bucketSize < time.Minute {
+		bucketSize = time.Minute
+	}
+	if bucketSize != t.bucketSize {
+		t.series = make(map[seriesKey]*series)
+		t.since = time.Now()
+	}
+	t.config = config
+	t.bucketSize = bucketSize
+}
+
+// Record counts a request of a layer of a service, the layer being
+// empty if the request has none. The requests failing with a 5xx
+// status consume the availability error budget and those slower than
+// the latency threshold of their objective the latency budget.
+func (t *Tracker) Record(service, layer string, status int, latency time.Duration, now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index := now.UnixNano() / int64(t.bucketSize)
+	t.record(service, "", status, latency, index)
+	if len(layer) > 0 {
+		t.record(service, layer, status, latency, index)
+	}
+}
+
+func (t *Tracker) record(service, layer string, status int, latency time.Duration, index int64) {
+	o := t.config.objective(service, layer)
+	if o == nil {
+		return
+	}
+	key := seriesKey{service, layer}
+	s, found := t.series[key]
+	if !found {
+		s = &series{Service: service, Layer: layer}
+		t.series[key] = s
+	}
+
+	n := len(s.Buckets)
+	if n == 0 || s.Buckets[n-1].Index != index {
+		oldest := index - maxBuckets
+		i := 0
+		for i < n && s.Buckets[i].Index <= oldest {
+			i++
+		}
+		s.Buckets = append(s.Buckets[i:], &bucket{Index: index})
+	}
+	b := s.Buckets[len(s.Buckets)-1]
+	b.Total++
+	if status >= 500 {
+		b.Errors++
+	}
+	if o.latencyThreshold > 0 && latency > o.latencyThreshold {
+		b.Slow++
+	}
+}
+
+// Report is the indicators of the objectives over the window.
+type Report struct {
+	Generated time.Time `json:"generated"`
+	Window    string    `json:"window"`
+	// Since is the time the indicators have been recorded from, if
+	// later than the start of the window.
+	Since time.Time `json:"since"`
+	SLOs  []SLO     `json:"slos"`
+}
+
+// SLO is the indicators of a service, or of a layer of a service.
+type SLO struct {
+	Service      string     `json:"service"`
+	Layer        string     `json:"layer,omitempty"`
+	Requests     int64      `json:"requests"`
+	Errors       int64      `json:"errors"`
+	Slow         int64      `json:"slow"`
+	Availability *Indicator `json:"availability,omitempty"`
+	Latency      *Indicator `json:"latency,omitempty"`
+}
+
+// Indicator is the value of an indicator and the error budget left by
+// its objective.
+type Indicator struct {
+	Objective float64 `json:"objective"`
+	Threshold string  `json:"threshold,omitempty"`
+	// Value is the fraction of good requests over the window.
+	Value float64 `json:"value"`
+	// BudgetRemaining is the fraction of the error budget of the window
+	// not consumed, negative once exhausted.
+	BudgetRemaining float64 `json:"error_budget_remaining"`
+	// BurnRate is the rate the error budget was consumed at over the
+	// last hour, 1 being the rate exhausting it at the end of the
+	// window.
+	BurnRate float64 `json:"burn_rate"`
+}
+
+func newIndicator(objective float64, total, bad, recentTotal, recentBad int64) *Indicator {
+	ind := &Indicator{Objective: objective, Value: 1, BudgetRemaining: 1}
+	budget := 1 - objective
+	if total > 0 {
+		ind.Value = 1 - float64(bad)/float64(total)
+		ind.BudgetRemaining = 1 - float64(bad)/float64(total)/budget
+	}
+	if recentTotal > 0 {
+		ind.BurnRate = float64(recentBad) / float64(recentTotal) / budget
+	}
+	return ind
+}
+
+// Report returns the indicators of the window ending at now, sorted by
+// service and layer.
+func (t *Tracker) Report(now time.Time) Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index := now.UnixNano() / int64(t.bucketSize)
+	oldest := index - maxBuckets
+	recent := index - int64((burnWindow+t.bucketSize-1)/t.bucketSize)
+	report := Report{Generated: now, Window: t.config.window.String(), Since: t.since, SLOs: []SLO{}}
+	if start := now.Add(-t.config.window); start.After(t.since) {
+		report.Since = start
+	}
+
+	for key, s := range t.series {
+		o := t.config.objective(key.service, key.layer)
+		if o == nil {
+			continue
+		}
+		slo := SLO{Service: key.service, Layer: key.layer}
+		var recentTotal, recentErrors, recentSlow int64
+		for _, b := range s.Buckets {
+			if b.Index <= oldest || b.Index > index {
+				continue
+			}
+			slo.Requests += b.Total
+			slo.Errors += b.Errors
+			slo.Slow += b.Slow
+			if b.Index > recent {
+				recentTotal += b.Total
+				recentErrors += b.Errors
+				recentSlow += b.Slow
+			}
+		}
+		if o.Availability > 0 {
+			slo.Availability = newIndicator(o.Availability, slo.Requests, slo.Errors, recentTotal, recentErrors)
+		}
+		if o.latencyThreshold > 0 {
+			slo.Latency = newIndicator(o.LatencyTarget, slo.Requests, slo.Slow, recentTotal, recentSlow)
+			slo.Latency.Threshold = o.latencyThreshold.String()
+		}
+		report.SLOs = append(report.SLOs, slo)
+	}
+	sort.Slice(report.SLOs, func(i, j int) bool {
+		if report.SLOs[i].Service != report.SLOs[j].Service {
+			return report.SLOs[i].Service < report.SLOs[j].Service
+		}
+		return report.SLOs[i].Layer < report.SLOs[j].Layer
+	})
+	return report
+}
+
+// state is the content of the state file.
+type state struct {
+	BucketSize time.Duration `json:"bucket_size"`
+	Since      time.Time     `json:"since"`
+	Series     []*series     `json:"series"`
+}
+
+// Save writes the indicators to the state file of the config, if any.
+func (t *Tracker) Save() error {
+	t.mu.Lock()
+	st := state{BucketSize: t.bucketSize, Since: t.since}
+	for _, s := range t.series {
+		st.Series = append(st.Series, s)
+	}
+	data, err := json.Marshal(st)
+	stateFile := t.config.StateFile
+	t.mu.Unlock()
+	if err != nil || len(stateFile) == 0 {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(stateFile), ".slo")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), stateFile)
+}
+
+func (t *Tracker) load(stateFile string) error {
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return err
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("invalid slo state file %s: %v", stateFile, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st.BucketSize != t.bucketSize {
+		return nil
+	}
+	for _, s := range st.Series {
+		t.series[seriesKey{s.Service, s.Layer}] = s
+	}
+	if !st.Since.IsZero() {
+		t.since = st.Since
+	}
+	return nil
+}
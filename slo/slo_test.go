@@ -0,0 +1,131 @@
+package slo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestConfig(t *testing.T, conf string) *Config {
+	var config Config
+	if err := json.Unmarshal([]byte(conf), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return &config
+}
+
+const testConfig = `{
+	"window": "24h",
+	"objectives": [
+		{"services": ["WMS"], "layers": ["chirps_*"], "availability": 0.9, "latency_threshold": "1s", "latency_target": 0.5},
+		{"services": ["WMS"], "availability": 0.99}
+	]
+}`
+
+func TestTracker(t *testing.T) {
+	tracker, err := Open(newTestConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	for i := 0; i < 10; i++ {
+		tracker.Record("WMS", "chirps_daily", 200, 500*time.Millisecond, t0)
+	}
+	tracker.Record("WMS", "chirps_daily", 500, 2*time.Second, t0)
+	tracker.Record("WMS", "chirps_daily", 404, 2*time.Second, t0.Add(-2*time.Hour))
+	tracker.Record("WMS", "other", 200, time.Second, t0)
+	tracker.Record("WCS", "chirps_daily", 500, time.Second, t0)
+
+	report := tracker.Report(t0)
+	if len(report.SLOs) != 3 {
+		t.Fatalf("expected 3 SLOs, got %+v", report.SLOs)
+	}
+	total, layer, other := report.SLOs[0], report.SLOs[1], report.SLOs[2]
+	if total.Layer != "" || total.Requests != 13 || total.Errors != 1 || total.Latency != nil {
+		t.Errorf("unexpected service totals: %+v", total)
+	}
+	if layer.Layer != "chirps_daily" || layer.Requests != 12 || layer.Errors != 1 || layer.Slow != 2 {
+		t.Errorf("unexpected layer SLO: %+v", layer)
+	}
+	if other.Layer != "other" || other.Latency != nil {
+		t.Errorf("unexpected layer SLO: %+v", other)
+	}
+
+	approx := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	if a := layer.Availability; !approx(a.Value, 11.0/12) || !approx(a.BudgetRemaining, 1-(1.0/12)/0.1) || !approx(a.BurnRate, (1.0/11)/0.1) {
+		t.Errorf("unexpected availability: %+v", a)
+	}
+	if l := layer.Latency; !approx(l.Value, 10.0/12) || l.Threshold != "1s" || !approx(l.BurnRate, (1.0/11)/0.5) {
+		t.Errorf("unexpected latency: %+v", l)
+	}
+
+	// The requests out of the window are dropped.
+	report = tracker.Report(t0.Add(23 * time.Hour))
+	if report.SLOs[1].Requests != 11 || report.SLOs[1].Availability.BurnRate != 0 {
+		t.Errorf("unexpected SLO at the end of the window: %+v", report.SLOs[1])
+	}
+	report = tracker.Report(t0.Add(25 * time.Hour))
+	if report.SLOs[1].Requests != 0 || report.SLOs[1].Availability.Value != 1 {
+		t.Errorf("unexpected SLO after the window: %+v", report.SLOs[1])
+	}
+}
+
+func TestTrackerState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := newTestConfig(t, testConfig)
+	config.StateFile = filepath.Join(dir, "slo.json")
+	tracker, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	tracker.Record("WMS", "chirps_daily", 500, 0, t0)
+	if err := tracker.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := restored.Report(t0); len(report.SLOs) != 2 || report.SLOs[1].Errors != 1 {
+		t.Errorf("indicators not restored: %+v", report.SLOs)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	invalid := []string{
+		`{}`,
+		`{"window": "monthly", "objectives": [{"availability": 0.99}]}`,
+		`{"objectives": [{"services": ["WMS"]}]}`,
+		`{"objectives": [{"availability": 99.9}]}`,
+		`{"objectives": [{"latency_threshold": "2s"}]}`,
+		`{"objectives": [{"availability": 0.99, "layers": ["["]}]}`,
+	}
+	for _, conf := range invalid {
+		var config Config
+		if err := json.Unmarshal([]byte(conf), &config); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.Validate(); err == nil {
+			t.Errorf("expected error for %s", conf)
+		}
+	}
+
+	config := newTestConfig(t, `{"objectives": [{"availability": 0.99}]}`)
+	if config.window != DefaultWindow || config.ReportIntervalDuration() != DefaultReportInterval {
+		t.Errorf("unexpected defaults: %+v", config)
+	}
+}
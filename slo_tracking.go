@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nci/gsky/metrics"
+	"github.com/nci/gsky/slo"
+	"github.com/nci/gsky/utils"
+)
+
+// sloTracker is the tracker of the objectives configured by the slo of
+// the root config. It is opened on the first request and reconfigured
+// when the config is reloaded.
+var sloTracker struct {
+	mu      sync.Mutex
+	tracker *slo.Tracker
+	config  *slo.Config
+}
+
+// sloSaveInterval is the interval the indicators are saved to the state
+// file at.
+const sloSaveInterval = time.Minute
+
+func getSLOTracker() *slo.Tracker {
+	rootConfig := getConfigMap()["."]
+	if rootConfig == nil || rootConfig.ServiceConfig.SLO == nil {
+		return nil
+	}
+	config := rootConfig.ServiceConfig.SLO
+
+	sloTracker.mu.Lock()
+	defer sloTracker.mu.Unlock()
+	if config == sloTracker.config {
+		return sloTracker.tracker
+	}
+	sloTracker.config = config
+	if sloTracker.tracker == nil {
+		tracker, err := slo.Open(config)
+		if err != nil {
+			Error.Printf("Error in opening the SLO tracker: %v", err)
+			return nil
+		}
+		sloTracker.tracker = tracker
+		go reportSLO(tracker)
+	} else {
+		sloTracker.tracker.Reconfigure(config)
+	}
+	return sloTracker.tracker
+}
+
+// reportSLO saves the indicators and writes the error budget report to
+// the log at the report interval of the config.
+func reportSLO(tracker *slo.Tracker) {
+	lastReport := time.Now()
+	for now := range time.Tick(sloSaveInterval) {
+		if err := tracker.Save(); err != nil {
+			Error.Printf("Error in saving the SLO state: %v", err)
+		}
+
+		sloTracker.mu.Lock()
+		interval := sloTracker.config.ReportIntervalDuration()
+		sloTracker.mu.Unlock()
+		if now.Sub(lastReport) < interval {
+			continue
+		}
+		lastReport = now
+
+		report := tracker.Report(now)
+		for _, s := range report.SLOs {
+			name := s.Service
+			if len(s.Layer) > 0 {
+				name += " " + s.Layer
+			}
+			for i, ind := range []*slo.Indicator{s.Availability, s.Latency} {
+				if ind == nil {
+					continue
+				}
+				sli := [...]string{"availability", "latency"}[i]
+				Info.Printf("SLO %s %s over %s: %.4f of %d requests, objective %.4f, %.1f%% of error budget left, burn rate %.2f", name, sli, report.Window, ind.Value, s.Requests, ind.Objective, 100*ind.BudgetRemaining, ind.BurnRate)
+				if ind.BudgetRemaining <= 0 {
+					Error.Printf("SLO %s %s: error budget exhausted", name, sli)
+				}
+			}
+		}
+	}
+}
+
+// configLayer returns the layer of an OWS request if it is a layer or a
+// process of the config, so that the cardinality of the indicators and
+// of the usage counts is bounded by the config.
+func configLayer(conf *utils.Config, service string, query map[string][]string) string {
+	first := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return strings.Split(values[0], ",")[0]
+		}
+		return ""
+	}
+
+	var name string
+	switch service {
+	case "WMS":
+		for _, key := range []string{"layers", "query_layers", "layer"} {
+			if name = first(key); len(name) > 0 {
+				break
+			}
+		}
+	case "WCS":
+		name = first("coverage")
+	case "WPS":
+		name = first("identifier")
+		for _, proc := range conf.Processes {
+			if proc.Identifier == name {
+				return name
+			}
+		}
+		return ""
+	case "DAP":
+		if ce, err := utils.ParseDap4ConstraintExpr(first("dap4.ce")); err == nil {
+			name = ce.Dataset
+		}
+	}
+	for i := range conf.Layers {
+		if conf.Layers[i].Name == name {
+			return name
+		}
+	}
+	return ""
+}
+
+// recordSLO records a served OWS request in the SLO tracker.
+func recordSLO(conf *utils.Config, query map[string][]string, t0 time.Time, info *metrics.MetricsInfo) {
+	tracker := getSLOTracker()
+	if tracker == nil {
+		return
+	}
+	if _, isWorker := query["wbbox"]; isWorker {
+		return
+	}
+
+	service := owsService(query)
+	if len(service) == 0 {
+		return
+	}
+	tracker.Record(service, configLayer(conf, service, query), info.HTTPStatus, time.Since(t0), time.Now())
+}
+
+// owsService returns the service of an OWS request, DAP for the DAP4
+// requests, or an empty string if unknown.
+func owsService(query map[string][]string) string {
+	if _, isDap := query["dap4.ce"]; isDap {
+		return "DAP"
+	}
+	if v := query["service"]; len(v) > 0 && owsServices[v[0]] {
+		return v[0]
+	}
+	return ""
+}
+
+// sloHandler serves the error budget report of the SLO tracker, e.g.
+// GET /admin/slo?service=WMS&layer=chirps_daily
+func sloHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tracker := getSLOTracker()
+	if tracker == nil {
+		http.Error(w, "no slo in the root config", http.StatusNotFound)
+		return
+	}
+
+	report := tracker.Report(time.Now())
+	service, layer := r.FormValue("service"), r.FormValue("layer")
+	if len(service) > 0 || len(layer) > 0 {
+		slos := report.SLOs[:0]
+		for _, s := range report.SLOs {
+			if (len(service) == 0 || s.Service == service) && (len(layer) == 0 || s.Layer == layer) {
+				slos = append(slos, s)
+			}
+		}
+		report.SLOs = slos
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeAdminJSON(w, http.StatusOK, report)
+}
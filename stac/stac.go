@@ -0,0 +1,264 @@
+// Package stac defines the STAC 1.0 Items and Collections written by the
+// crawler alongside its records, for the same crawls to populate MAS and
+// a STAC catalog consistent with it.
+package stac
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Version is the STAC version of the Items and Collections.
+const Version = "1.0.0"
+
+// The extensions of the fields of the Items.
+const (
+	ProjectionExtension = "https://stac-extensions.github.io/projection/v1.1.0/schema.json"
+	FileExtension       = "https://stac-extensions.github.io/file/v2.1.0/schema.json"
+)
+
+// Link is a link of an Item or of a Collection.
+type Link struct {
+	Rel   string `json:"rel"`
+	Href  string `json:"href"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// Asset is a file of an Item, with the checksum and the size of the
+// file extension.
+type Asset struct {
+	Href     string   `json:"href"`
+	Type     string   `json:"type,omitempty"`
+	Title    string   `json:"title,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	Checksum string   `json:"file:checksum,omitempty"`
+	Size     int64    `json:"file:size,omitempty"`
+}
+
+// Geometry is a GeoJSON polygon.
+type Geometry struct {
+	Type        string         `json:"type"`
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+// Item is a STAC Item, the record of a file.
+type Item struct {
+	Type           string                 `json:"type"`
+	StacVersion    string                 `json:"stac_version"`
+	StacExtensions []string               `json:"stac_extensions,omitempty"`
+	ID             string                 `json:"id"`
+	Geometry       *Geometry              `json:"geometry"`
+	BBox           []float64              `json:"bbox,omitempty"`
+	Properties     map[string]interface{} `json:"properties"`
+	Links          []Link                 `json:"links"`
+	Assets         map[string]*Asset      `json:"assets"`
+	Collection     string                 `json:"collection,omitempty"`
+}
+
+// Extent is the spatial and temporal extent of a Collection.
+type Extent struct {
+	Spatial struct {
+		BBox [][]float64 `json:"bbox"`
+	} `json:"spatial"`
+	Temporal struct {
+		Interval [][]*time.Time `json:"interval"`
+	} `json:"temporal"`
+}
+
+// Collection is a STAC Collection, that of the Items of a crawl.
+type Collection struct {
+	Type        string `json:"type"`
+	StacVersion string `json:"stac_version"`
+	ID          string `json:"id"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description"`
+	License     string `json:"license"`
+	Extent      Extent `json:"extent"`
+	Links       []Link `json:"links"`
+}
+
+var nonIDChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// ItemID returns the id of the Item of a path, the path with the
+// characters other than letters, digits, ., _ and - replaced by _, e.g.
+// g_data_chirps_daily_chirps-v2.0.2020.01.01.tif.
+func ItemID(path string) string {
+	return strings.Trim(nonIDChars.ReplaceAllString(strings.Trim(path, "/"), "_"), "_")
+}
+
+// NewItem returns the Item of a file of the bounding box in longitudes
+// and latitudes, west, south, east and north.
+func NewItem(id string, bbox []float64) *Item {
+	w, s, e, n := bbox[0], bbox[1], bbox[2], bbox[3]
+	return &Item{
+		Type:        "Feature",
+		StacVersion: Version,
+		ID:          id,
+		Geometry: &Geometry{
+			Type:        "Polygon",
+			Coordinates: [][][2]float64{{{w, s}, {e, s}, {e, n}, {w, n}, {w, s}}},
+		},
+		BBox:       []float64{w, s, e, n},
+		Properties: make(map[string]interface{}),
+		Links:      []Link{},
+		Assets:     make(map[string]*Asset),
+	}
+}
+
+// AddExtension adds the schema of an extension to the Item, once.
+func (item *Item) AddExtension(schema string) {
+	for _, ext := range item.StacExtensions {
+		if ext == schema {
+			return
+		}
+	}
+	item.StacExtensions = append(item.StacExtensions, schema)
+}
+
+// SetTimes sets the datetime of the Item, the time of its data, or its
+// start_datetime and end_datetime if several, the fallback, e.g. the
+// modification time of a file without timestamps, if none.
+func (item *Item) SetTimes(times []time.Time, fallback time.Time) {
+	if len(times) == 0 {
+		item.Properties["datetime"] = fallback.UTC().Format(time.RFC3339)
+		return
+	}
+	start, end := times[0], times[0]
+	for _, t := range times[1:] {
+		if t.Before(start) {
+			start = t
+		}
+		if t.After(end) {
+			end = t
+		}
+	}
+	if start.Equal(end) {
+		item.Properties["datetime"] = start.UTC().Format(time.RFC3339)
+		return
+	}
+	item.Properties["datetime"] = nil
+	item.Properties["start_datetime"] = start.UTC().Format(time.RFC3339)
+	item.Properties["end_datetime"] = end.UTC().Format(time.RFC3339)
+}
+
+// Interval returns the time interval of the Item, from its start_datetime
+// to its end_datetime or at its datetime.
+func (item *Item) Interval() (time.Time, time.Time, error) {
+	parse := func(key string) (time.Time, error) {
+		s, _ := item.Properties[key].(string)
+		return time.Parse(time.RFC3339, s)
+	}
+	if t, err := parse("datetime"); err == nil {
+		return t, t, nil
+	}
+	start, err := parse("start_datetime")
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("item %s: no datetime", item.ID)
+	}
+	end, err := parse("end_datetime")
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("item %s: no end_datetime", item.ID)
+	}
+	return start, end, nil
+}
+
+// UnionBBox returns the bounding box of two bounding boxes, b if a is
+// empty.
+func UnionBBox(a, b []float64) []float64 {
+	if len(a) != 4 {
+		return append([]float64(nil), b...)
+	}
+	return []float64{math.Min(a[0], b[0]), math.Min(a[1], b[1]), math.Max(a[2], b[2]), math.Max(a[3], b[3])}
+}
+
+// NewCollection returns an empty Collection.
+func NewCollection(id, description string) *Collection {
+	coll := &Collection{
+		Type:        "Collection",
+		StacVersion: Version,
+		ID:          id,
+		Description: description,
+		License:     "proprietary",
+		Links:       []Link{},
+	}
+	coll.Extent.Spatial.BBox = [][]float64{}
+	coll.Extent.Temporal.Interval = [][]*time.Time{}
+	return coll
+}
+
+// Extend extends the extent of the Collection to the Item, linking the
+// Item at href once.
+func (coll *Collection) Extend(item *Item, href string) error {
+	start, end, err := item.Interval()
+	if err != nil {
+		return err
+	}
+	if len(coll.Extent.Spatial.BBox) == 0 {
+		coll.Extent.Spatial.BBox = [][]float64{nil}
+	}
+	coll.Extent.Spatial.BBox[0] = UnionBBox(coll.Extent.Spatial.BBox[0], item.BBox)
+
+	if len(coll.Extent.Temporal.Interval) == 0 || len(coll.Extent.Temporal.Interval[0]) != 2 {
+		coll.Extent.Temporal.Interval = [][]*time.Time{{&start, &end}}
+	} else {
+		interval := coll.Extent.Temporal.Interval[0]
+		if interval[0] != nil && start.Before(*interval[0]) {
+			interval[0] = &start
+		}
+		if interval[1] != nil && end.After(*interval[1]) {
+			interval[1] = &end
+		}
+	}
+
+	for _, link := range coll.Links {
+		if link.Rel == "item" && link.Href == href {
+			return nil
+		}
+	}
+	coll.Links = append(coll.Links, Link{Rel: "item", Href: href, Type: "application/geo+json"})
+	return nil
+}
+
+// mediaTypes are the media types of the GDAL drivers.
+var mediaTypes = map[string]string{
+	"GTiff":       "image/tiff; application=geotiff",
+	"COG":         "image/tiff; application=geotiff; profile=cloud-optimized",
+	"netCDF":      "application/netcdf",
+	"GSKY_netCDF": "application/netcdf",
+	"HDF5":        "application/x-hdf5",
+	"HDF4":        "application/x-hdf",
+	"GRIB":        "application/wmo-GRIB2",
+	"JP2OpenJPEG": "image/jp2",
+	"Zarr":        "application/vnd+zarr",
+}
+
+// MediaType returns the media type of the files of a GDAL driver, empty
+// if unknown.
+func MediaType(driver string) string {
+	return mediaTypes[driver]
+}
+
+// multihashPrefixes are the hex of the multihash code and digest length
+// of the algorithms of the checksums of the crawler.
+var multihashPrefixes = map[string]string{
+	"sha256": "1220",
+	"md5":    "d50110",
+}
+
+// Multihash returns the multihash of the file extension of a checksum of
+// the crawler, e.g. 1220<hex> of sha256:<hex>, empty if unknown.
+func Multihash(checksum string) string {
+	parts := strings.SplitN(checksum, ":", 2)
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return ""
+	}
+	prefix, found := multihashPrefixes[parts[0]]
+	if !found {
+		return ""
+	}
+	return prefix + parts[1]
+}
@@ -0,0 +1,102 @@
+package stac
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestItemID(t *testing.T) {
+	tests := map[string]string{
+		"/g/data/chirps/daily/chirps-v2.0.2020.01.01.tif": "g_data_chirps_daily_chirps-v2.0.2020.01.01.tif",
+		"/vsis3/bucket/a b/c.nc":                          "vsis3_bucket_a_b_c.nc",
+		"dap.example.org/thredds/dodsC/x.nc":              "dap.example.org_thredds_dodsC_x.nc",
+	}
+	for path, id := range tests {
+		if got := ItemID(path); got != id {
+			t.Errorf("%s: id %s instead of %s", path, got, id)
+		}
+	}
+}
+
+func TestSetTimes(t *testing.T) {
+	fallback := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	t1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	item := NewItem("a", []float64{30, -5, 40, 5})
+	item.SetTimes(nil, fallback)
+	if item.Properties["datetime"] != "2026-10-14T00:00:00Z" {
+		t.Errorf("datetime %v without times", item.Properties["datetime"])
+	}
+
+	item = NewItem("b", []float64{30, -5, 40, 5})
+	item.SetTimes([]time.Time{t2, t1, t2}, fallback)
+	if item.Properties["datetime"] != nil ||
+		item.Properties["start_datetime"] != "2020-01-01T00:00:00Z" ||
+		item.Properties["end_datetime"] != "2020-12-31T00:00:00Z" {
+		t.Errorf("properties %v", item.Properties)
+	}
+	start, end, err := item.Interval()
+	if err != nil || !start.Equal(t1) || !end.Equal(t2) {
+		t.Errorf("interval %v %v %v", start, end, err)
+	}
+}
+
+func TestCollectionExtend(t *testing.T) {
+	coll := NewCollection("chirps", "CHIRPS daily rainfall")
+	a := NewItem("a", []float64{30, -5, 40, 5})
+	a.SetTimes([]time.Time{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}, time.Time{})
+	b := NewItem("b", []float64{20, -10, 35, 0})
+	b.SetTimes([]time.Time{time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)}, time.Time{})
+
+	for _, item := range []*Item{a, b, a} {
+		if err := coll.Extend(item, "items/"+item.ID+".json"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bbox := coll.Extent.Spatial.BBox[0]
+	if bbox[0] != 20 || bbox[1] != -10 || bbox[2] != 40 || bbox[3] != 5 {
+		t.Errorf("bbox %v", bbox)
+	}
+	interval := coll.Extent.Temporal.Interval[0]
+	if interval[0].Year() != 2019 || interval[1].Year() != 2020 {
+		t.Errorf("interval %v %v", interval[0], interval[1])
+	}
+	if len(coll.Links) != 2 {
+		t.Errorf("%d links instead of 2", len(coll.Links))
+	}
+
+	// The extent of a Collection read back is extended.
+	b1, err := json.Marshal(coll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := &Collection{}
+	if err := json.Unmarshal(b1, read); err != nil {
+		t.Fatal(err)
+	}
+	c := NewItem("c", []float64{45, 0, 50, 12})
+	c.SetTimes([]time.Time{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}, time.Time{})
+	if err := read.Extend(c, "items/c.json"); err != nil {
+		t.Fatal(err)
+	}
+	bbox = read.Extent.Spatial.BBox[0]
+	if bbox[0] != 20 || bbox[2] != 50 || bbox[3] != 12 || read.Extent.Temporal.Interval[0][1].Year() != 2021 {
+		t.Errorf("extent %v %v", bbox, read.Extent.Temporal.Interval[0])
+	}
+}
+
+func TestMultihash(t *testing.T) {
+	tests := map[string]string{
+		"sha256:ab12": "1220ab12",
+		"md5:cd34":    "d50110cd34",
+		"crc32:0000":  "",
+		"ab12":        "",
+	}
+	for checksum, multihash := range tests {
+		if got := Multihash(checksum); got != multihash {
+			t.Errorf("%s: multihash %q instead of %q", checksum, got, multihash)
+		}
+	}
+}
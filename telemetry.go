@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nci/gsky/metrics"
+	"github.com/nci/gsky/telemetry"
+	"github.com/nci/gsky/utils"
+)
+
+var telemetryCollector *telemetry.Collector
+
+// telemetryTimeout is the timeout of the requests sending the reports.
+const telemetryTimeout = 30 * time.Second
+
+// telemetryOperations are the operations counted by their name, the
+// others counting as telemetry.Other so that the reports carry no value
+// chosen by the clients other than the formats.
+var telemetryOperations = map[string]bool{
+	"GetCapabilities":  true,
+	"GetMap":           true,
+	"GetFeatureInfo":   true,
+	"GetLegendGraphic": true,
+	"DescribeLayer":    true,
+	"DescribeCoverage": true,
+	"GetCoverage":      true,
+	"DescribeProcess":  true,
+	"Execute":          true,
+}
+
+// recordTelemetry counts a served OWS request in the telemetry. The
+// requests of the WCS cluster workers are counted by the front OWS.
+func recordTelemetry(query map[string][]string, info *metrics.MetricsInfo) {
+	if telemetryCollector == nil {
+		return
+	}
+	if _, isWorker := query["wbbox"]; isWorker {
+		return
+	}
+	service := owsService(query)
+	if len(service) == 0 {
+		return
+	}
+
+	operation := "dap4"
+	if service != "DAP" {
+		operation = telemetry.Other
+		if v := query["request"]; len(v) > 0 && telemetryOperations[v[0]] {
+			operation = v[0]
+		}
+	}
+	var format string
+	if v := query["format"]; len(v) > 0 {
+		format = v[0]
+	}
+	telemetryCollector.Record(service, operation, format, info.HTTPStatus)
+}
+
+// telemetryReport returns the telemetry report of the current period,
+// with the size of the config and the features enabled.
+func telemetryReport(reset bool) *telemetry.Report {
+	report := telemetryCollector.Report(time.Now(), reset)
+	report.Version = utils.GSKYVersion
+
+	features := make(map[string]bool)
+	for key, flagged := range map[string]bool{
+		"audit_log":       len(*auditDest) > 0,
+		"usage_store":     len(*usageDest) > 0,
+		"api_tokens":      len(*tokenFile) > 0,
+		"signed_urls":     len(*urlSigningKey) > 0,
+		"memcache":        len(*mcURI) > 0,
+		"metrics":         *metricsPort > 0,
+		"tracing":         len(*otlpEndpoint) > 0,
+		"load_shedding":   *shedMemoryMB > 0 || *shedCPU > 0,
+		"config_watching": *confWatchInterval > 0,
+	} {
+		if flagged {
+			features[key] = true
+		}
+	}
+
+	configMap := getConfigMap()
+	report.NameSpaces = len(configMap)
+	for _, conf := range configMap {
+		report.Layers += len(conf.Layers)
+		report.Processes += len(conf.Processes)
+
+		sc := &conf.ServiceConfig
+		for key, configured := range map[string]bool{
+			"mas_replicas":     len(sc.MASReplicas) > 0,
+			"virtual_hosts":    len(sc.VirtualHosts) > 0,
+			"custom_crs":       len(sc.CustomCRS) > 0,
+			"auto_layers":      sc.EnableAutoLayers,
+			"access_control":   sc.AccessControl != nil,
+			"rate_limits":      sc.RateLimits != nil,
+			"data_quotas":      sc.DataQuotas != nil,
+			"disk_cache":       sc.DiskCache != nil,
+			"slo":              sc.SLO != nil,
+			"tile_seeding":     len(sc.TileSeeding) > 0,
+			"security_headers": sc.SecurityHeaders != nil,
+		} {
+			if configured {
+				features[key] = true
+			}
+		}
+	}
+	report.Features = []string{}
+	for key := range features {
+		report.Features = append(report.Features, key)
+	}
+	sort.Strings(report.Features)
+	return report
+}
+
+// reportTelemetry sends the telemetry report of each period to the
+// telemetry endpoint until the process exits. The requests of a report
+// which cannot be sent are not counted again.
+func reportTelemetry(endpoint string, interval time.Duration) {
+	client := &http.Client{Timeout: telemetryTimeout}
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), telemetryTimeout)
+		if err := telemetry.Send(ctx, client, endpoint, telemetryReport(true)); err != nil {
+			Info.Printf("Failed to send the telemetry report: %v", err)
+		}
+		cancel()
+	}
+}
+
+// telemetryHandler serves the telemetry report of the current period
+// as it will be sent, e.g. GET /admin/telemetry
+func telemetryHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if telemetryCollector == nil {
+		http.Error(w, "telemetry is disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeAdminJSON(w, http.StatusOK, telemetryReport(false))
+}
@@ -0,0 +1,174 @@
+// Package telemetry collects the anonymous usage statistics that the
+// GSKY instances opting in report periodically to the maintainers: the
+// version, the size of the config, the features enabled and the
+// requests served per service, operation and format. The reports carry
+// no layer, namespace, host, user or client address.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxKeys is the maximum number of distinct service, operation and
+// format combinations counted in a period, the others counting as
+// Other.
+const MaxKeys = 200
+
+// Other replaces the operations and formats beyond MaxKeys.
+const Other = "other"
+
+// Report is the statistics reported at the end of a period.
+type Report struct {
+	// Instance is a random ID of the process, telling the reports of
+	// the same process from those of the others.
+	Instance  string    `json:"instance"`
+	Version   string    `json:"version"`
+	GoVersion string    `json:"go_version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	Uptime    int64     `json:"uptime_seconds"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+
+	NameSpaces int      `json:"namespaces"`
+	Layers     int      `json:"layers"`
+	Processes  int      `json:"processes"`
+	Features   []string `json:"features"`
+	Requests   []Count  `json:"requests"`
+}
+
+// Count is the number of requests of a service, operation and format,
+// and of those which failed.
+type Count struct {
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	Format    string `json:"format,omitempty"`
+	Requests  int64  `json:"requests"`
+	Errors    int64  `json:"errors"`
+}
+
+// Collector counts the requests of the current period.
+type Collector struct {
+	instance string
+	started  time.Time
+
+	mu     sync.Mutex
+	from   time.Time
+	counts map[Count]*Count
+}
+
+// NewCollector returns a collector of a process started at now, with
+// a random instance ID.
+func NewCollector(now time.Time) *Collector {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &Collector{
+		instance: hex.EncodeToString(id),
+		started:  now,
+		from:     now,
+		counts:   make(map[Count]*Count),
+	}
+}
+
+// Record counts a request, failed if its status is 400 or more.
+func (c *Collector) Record(service, operation, format string, status int) {
+	key := Count{Service: service, Operation: operation, Format: NormaliseFormat(format)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count, found := c.counts[key]
+	if !found {
+		if len(c.counts) >= MaxKeys {
+			key.Operation, key.Format = Other, Other
+		}
+		if count, found = c.counts[key]; !found {
+			count = &Count{Service: key.Service, Operation: key.Operation, Format: key.Format}
+			c.counts[key] = count
+		}
+	}
+	count.Requests++
+	if status >= 400 {
+		count.Errors++
+	}
+}
+
+// Report returns the report of the period ending at now, with the
+// requests counted, and starts a new period if reset.
+func (c *Collector) Report(now time.Time, reset bool) *Report {
+	c.mu.Lock()
+	report := &Report{
+		Instance:  c.instance,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Uptime:    int64(now.Sub(c.started).Seconds()),
+		From:      c.from.UTC(),
+		To:        now.UTC(),
+		Requests:  []Count{},
+	}
+	for _, count := range c.counts {
+		report.Requests = append(report.Requests, *count)
+	}
+	if reset {
+		c.from = now
+		c.counts = make(map[Count]*Count)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(report.Requests, func(i, j int) bool {
+		a, b := report.Requests[i], report.Requests[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Operation != b.Operation {
+			return a.Operation < b.Operation
+		}
+		return a.Format < b.Format
+	})
+	return report
+}
+
+// NormaliseFormat returns the format of a request in lower case and
+// without its parameters, e.g. image/png for image/png; mode=8bit.
+func NormaliseFormat(format string) string {
+	if i := strings.Index(format, ";"); i >= 0 {
+		format = format[:i]
+	}
+	format = strings.ToLower(strings.TrimSpace(format))
+	if len(format) > 64 {
+		return Other
+	}
+	return format
+}
+
+// Send posts a report as JSON to the endpoint.
+func Send(ctx context.Context, client *http.Client, endpoint string, report *Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	t0 := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	c := NewCollector(t0)
+	c.Record("WMS", "GetMap", "image/png", 200)
+	c.Record("WMS", "GetMap", "Image/PNG; mode=8bit", 500)
+	c.Record("WCS", "GetCoverage", "GeoTIFF", 200)
+
+	report := c.Report(t0.Add(time.Hour), true)
+	if len(report.Instance) == 0 || report.Uptime != 3600 || len(report.Requests) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if got := report.Requests[1]; got != (Count{Service: "WMS", Operation: "GetMap", Format: "image/png", Requests: 2, Errors: 1}) {
+		t.Errorf("unexpected GetMap count: %+v", got)
+	}
+	if next := c.Report(t0.Add(2*time.Hour), false); len(next.Requests) != 0 || !next.From.Equal(t0.Add(time.Hour)) {
+		t.Errorf("expected a new period, got %+v", next)
+	}
+
+	for i := 0; i < MaxKeys+10; i++ {
+		c.Record("WMS", "GetMap", fmt.Sprintf("image/x%d", i), 200)
+	}
+	report = c.Report(t0.Add(3*time.Hour), false)
+	if last := report.Requests[len(report.Requests)-1]; len(report.Requests) != MaxKeys+1 || last.Format != Other || last.Requests != 10 {
+		t.Errorf("expected the formats beyond MaxKeys counted as other, got %d counts, %+v", len(report.Requests), last)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var received Report
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	report := NewCollector(time.Now()).Report(time.Now(), false)
+	report.Version = "1.2.3"
+	if err := Send(context.Background(), srv.Client(), srv.URL, report); err != nil {
+		t.Fatal(err)
+	}
+	if received.Version != "1.2.3" || received.Instance != report.Instance {
+		t.Errorf("unexpected report received: %+v", received)
+	}
+	if err := Send(context.Background(), srv.Client(), srv.URL+"/missing\x7f", report); err == nil {
+		t.Errorf("expected error for an invalid endpoint")
+	}
+}
@@ -1,7 +1,7 @@
 <?xml version="1.0" encoding="UTF-8"?><WCS_Capabilities xmlns="http://www.opengis.net/wcs" xmlns:gml="http://www.opengis.net/gml" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.opengis.net/wcs http://schemas.opengis.net/wcs/1.0.0/wcsCapabilities.xsd" version="1.0.0">
   <Service>
     <name>gsky</name>
-    <label>gsky</label>
+    <label>{{ if .ServiceConfig.Title }}{{ .ServiceConfig.Title | html }}{{ else }}gsky{{ end }}</label>
     <fees>NONE</fees>
     <accessConstraints>NONE</accessConstraints>
   </Service>
@@ -42,7 +42,7 @@
   <ContentMetadata>
 	{{ range $index, $value := .Layers }}
     <CoverageOfferingBrief>
-      <description>{{ .Abstract }}</description>
+      <description>{{ .Abstract }}{{ if .Deprecation }} {{ .Deprecation.Notice | html }}{{ end }}</description>
       <name>{{ .Name }}</name>
       <label>{{ .Title }}</label>
       <lonLatEnvelope srsName="urn:ogc:def:crs:OGC:1.3:CRS84">
@@ -51,6 +51,13 @@
         <gml:timePosition>{{ .EffectiveStartDate }}</gml:timePosition>
         <gml:timePosition>{{ .EffectiveEndDate }}</gml:timePosition>
       </lonLatEnvelope>
+      {{ if .Deprecation }}
+      <keywords>
+        <keyword>deprecated</keyword>
+        {{ if .Deprecation.Sunset }}<keyword>sunset:{{ .Deprecation.Sunset | html }}</keyword>{{ end }}
+        {{ if .Deprecation.ReplacedBy }}<keyword>replaced_by:{{ .Deprecation.ReplacedBy | html }}</keyword>{{ end }}
+      </keywords>
+      {{ end }}
     </CoverageOfferingBrief>
 	{{end}}
   </ContentMetadata>
@@ -1,4 +1,4 @@
 <?xml version="1.0" encoding="UTF-8" standalone="no"?><!DOCTYPE ServiceExceptionReport SYSTEM "http://gsky.nci.org.au/schemas/wms/1.1.1/WMS_exception_1_1_1.dtd"> 
 <ServiceExceptionReport version="1.1.1" >   
-	<ServiceException code="LayerNotDefined" locator="MapLayerInfoKvpParser">{{ . }} no such layer on this server.</ServiceException>
+	<ServiceException code="LayerNotDefined" locator="MapLayerInfoKvpParser">{{ . | html }} no such layer on this server.</ServiceException>
 </ServiceExceptionReport>
@@ -1,8 +1,8 @@
 <?xml version="1.0" encoding="UTF-8"?><WMS_Capabilities version="1.3.0" updateSequence="312" xmlns="http://www.opengis.net/wms" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:dea="http://dea.ga.gov.au/namespaces/wms_extensions" xsi:schemaLocation="http://www.opengis.net/wms http://schemas.opengis.net/wms/1.3.0/capabilities_1_3_0.xsd">
 	<Service>
 		<Name>WMS</Name>
-		<Title>GSKY Web Map Service</Title>
-		<Abstract>This service relies on GSKY - A Scalable, Distributed Geospatial Data Service. https://geonetwork.nci.org.au/geonetwork/srv/eng/catalog.search#/metadata/dc9fb2db-8d6f-4b76-a734-93ac7fbc9201</Abstract>
+		<Title>{{ if .ServiceConfig.Title }}{{ .ServiceConfig.Title | html }}{{ else }}GSKY Web Map Service{{ end }}</Title>
+		<Abstract>{{ if .ServiceConfig.Abstract }}{{ .ServiceConfig.Abstract | html }}{{ else }}This service relies on GSKY - A Scalable, Distributed Geospatial Data Service. https://geonetwork.nci.org.au/geonetwork/srv/eng/catalog.search#/metadata/dc9fb2db-8d6f-4b76-a734-93ac7fbc9201{{ end }}</Abstract>
 		<KeywordList>
 			<Keyword>WFS</Keyword>
 			<Keyword>WMS</Keyword>
@@ -11,8 +11,8 @@
 		<OnlineResource xlink:type="simple" xlink:href="{{ .ServiceConfig.OWSProtocol }}://{{ .ServiceConfig.OWSHostname }}/ows/{{ .ServiceConfig.NameSpace }}" />
 		<ContactInformation>
 		    <ContactPersonPrimary>
-		        <ContactOrganization>National Computational Infrastructure</ContactOrganization>
-			<ContactPerson>GSKY Developers</ContactPerson>
+		        <ContactOrganization>{{ if .ServiceConfig.ContactOrganization }}{{ .ServiceConfig.ContactOrganization | html }}{{ else }}National Computational Infrastructure{{ end }}</ContactOrganization>
+			<ContactPerson>{{ if .ServiceConfig.ContactPerson }}{{ .ServiceConfig.ContactPerson | html }}{{ else }}GSKY Developers{{ end }}</ContactPerson>
 		    </ContactPersonPrimary>
 			<ContactAddress>
 				<Address>143 Ward Road</Address>
@@ -21,7 +21,7 @@
 				<PostCode>2601</PostCode>
 				<Country>Australia</Country>
 			</ContactAddress>
-			<ContactElectronicMailAddress>help@nci.org.au</ContactElectronicMailAddress>
+			<ContactElectronicMailAddress>{{ if .ServiceConfig.ContactEmail }}{{ .ServiceConfig.ContactEmail | html }}{{ else }}help@nci.org.au{{ end }}</ContactElectronicMailAddress>
 		</ContactInformation>
 		<Fees>NONE</Fees>
 		<LayerLimit>1</LayerLimit>
@@ -80,11 +80,14 @@
 		</dea:SupportedExtension>
 		{{ end }}
 		<Layer>
-			<Title>GSKY Web Map Service</Title>
+			<Title>{{ if .ServiceConfig.Title }}{{ .ServiceConfig.Title | html }}{{ else }}GSKY Web Map Service{{ end }}</Title>
 			<Abstract>A compliant implementation of WMS</Abstract>
 			<!--All supported EPSG projections:-->
 			<CRS>EPSG:3857</CRS>
 			<CRS>EPSG:4326</CRS>
+			{{ range .ServiceConfig.CustomCRS }}
+			<CRS>{{ .Code }}</CRS>
+			{{ end }}
 			<EX_GeographicBoundingBox>
 				<westBoundLongitude>-180.0</westBoundLongitude>
 				<eastBoundLongitude>180.0</eastBoundLongitude>
@@ -96,7 +99,14 @@
 			<Layer queryable="1" opaque="0">
 				<Name>{{ .Name }}</Name>
 				<Title>{{ .Title }}</Title>
-				<Abstract>{{ .Abstract }}</Abstract>
+				<Abstract>{{ .Abstract }}{{ if .Deprecation }} {{ .Deprecation.Notice | html }}{{ end }}</Abstract>
+				{{ if .Deprecation }}
+				<KeywordList>
+					<Keyword vocabulary="gsky:deprecation">deprecated</Keyword>
+					{{ if .Deprecation.Sunset }}<Keyword vocabulary="gsky:sunset">{{ .Deprecation.Sunset | html }}</Keyword>{{ end }}
+					{{ if .Deprecation.ReplacedBy }}<Keyword vocabulary="gsky:replaced_by">{{ .Deprecation.ReplacedBy | html }}</Keyword>{{ end }}
+				</KeywordList>
+				{{ end }}
 				<CRS>EPSG:4326</CRS>
 				<EX_GeographicBoundingBox>
 					<westBoundLongitude>-180.0</westBoundLongitude>
@@ -1,8 +1,8 @@
 <?xml version="1.0" encoding="UTF-8" standalone="yes"?>
 <wps:Capabilities xmlns:ows="http://www.opengis.net/ows/1.1" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:wps="http://www.opengis.net/wps/1.0.0" xml:lang="en-US" service="WPS" updateSequence="1" version="1.0.0">
 	<ows:ServiceIdentification>
-		<ows:Title>GSKY WPS</ows:Title>
-		<ows:Abstract>GSKY - A Scalable, Distributed Geospatial Data Service. https://geonetwork.nci.org.au/geonetwork/srv/eng/catalog.search#/metadata/dc9fb2db-8d6f-4b76-a734-93ac7fbc9201</ows:Abstract>
+		<ows:Title>{{ if .ServiceConfig.Title }}{{ .ServiceConfig.Title | html }}{{ else }}GSKY WPS{{ end }}</ows:Title>
+		<ows:Abstract>{{ if .ServiceConfig.Abstract }}{{ .ServiceConfig.Abstract | html }}{{ else }}GSKY - A Scalable, Distributed Geospatial Data Service. https://geonetwork.nci.org.au/geonetwork/srv/eng/catalog.search#/metadata/dc9fb2db-8d6f-4b76-a734-93ac7fbc9201{{ end }}</ows:Abstract>
 		<ows:Keywords>
 			<ows:Keyword>WPS</ows:Keyword>
 			<ows:Keyword>GIS</ows:Keyword>
@@ -15,10 +15,10 @@
 		<ows:AccessConstraints>None</ows:AccessConstraints>
 	</ows:ServiceIdentification>
 	<ows:ServiceProvider>
-		<ows:ProviderName>Australian National Computational Infrastructure.</ows:ProviderName>
+		<ows:ProviderName>{{ if .ServiceConfig.ContactOrganization }}{{ .ServiceConfig.ContactOrganization | html }}{{ else }}Australian National Computational Infrastructure.{{ end }}</ows:ProviderName>
 		<ows:ProviderSite xlink:href="https://www.nci.org.au"/>
 		<ows:ServiceContact>
-			<ows:IndividualName>GSKY Developers</ows:IndividualName>
+			<ows:IndividualName>{{ if .ServiceConfig.ContactPerson }}{{ .ServiceConfig.ContactPerson | html }}{{ else }}GSKY Developers{{ end }}</ows:IndividualName>
 			<ows:PositionName>Data Service Innovation</ows:PositionName>
 			<ows:ContactInfo>
 				<ows:Phone>
@@ -30,7 +30,7 @@
 					<ows:AdministrativeArea>ACT</ows:AdministrativeArea>
 					<ows:PostalCode>2601</ows:PostalCode>
 					<ows:Country>Australia</ows:Country>
-					<ows:ElectronicMailAddress>help@nci.org.au</ows:ElectronicMailAddress>
+					<ows:ElectronicMailAddress>{{ if .ServiceConfig.ContactEmail }}{{ .ServiceConfig.ContactEmail | html }}{{ else }}help@nci.org.au{{ end }}</ows:ElectronicMailAddress>
 				</ows:Address>
 			</ows:ContactInfo>
 		</ows:ServiceContact>
@@ -0,0 +1,128 @@
+<!DOCTYPE html>
+<html lang="en" dir="ltr">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>GSKY Status</title>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<style>
+body {
+  font-family:"Segoe UI","Fira Sans","Droid Sans","Helvetica Neue","Arial","sans-serif";
+  font-size: 14px;
+  margin: 0px 20px 20px 20px;
+}
+
+h2 {
+  margin-top: 28px;
+  font-size: 18px;
+}
+
+table {
+  border-collapse: collapse;
+  width: 100%;
+}
+
+th, td {
+  text-align: left;
+  padding: 4px 8px;
+  border-bottom: 0.5px solid #eeeeee;
+  vertical-align: top;
+}
+
+th {
+  background-color: #fafbfc;
+}
+
+.ok {
+  color: #22863a;
+}
+
+.fail {
+  color: #cb2431;
+}
+
+.warn {
+  color: #b08800;
+}
+
+.summary {
+  color: #586069;
+}
+</style>
+</head>
+<body>
+<h1>GSKY Status</h1>
+<p class="summary">Version {{.Version}}, up since {{.StartTime.Format "2006-01-02 15:04:05 MST"}}, generated at {{.Generated.Format "2006-01-02 15:04:05 MST"}}. <a href="?check_mas=true">Check layers against MAS</a> | <a href="?format=json">JSON</a></p>
+
+<h2>MAS</h2>
+{{if .MAS}}
+<table>
+<tr><th>Address</th><th>Status</th><th>Latency (ms)</th><th>Error</th></tr>
+{{range .MAS}}
+<tr><td>{{.Address}}</td>{{if .Reachable}}<td class="ok">reachable</td>{{else}}<td class="fail">unreachable</td>{{end}}<td>{{printf "%.1f" .LatencyMS}}</td><td>{{.Error}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p class="summary">No MAS configured.</p>
+{{end}}
+{{if .MASFailover}}
+<table>
+<tr><th>Primary</th><th>Endpoint</th><th>Health</th><th>Checked</th><th>Error</th></tr>
+{{range .MASFailover}}
+<tr><td>{{.Primary}}</td><td>{{.Address}}{{if .Active}} (active){{end}}</td>{{if .Healthy}}<td class="ok">healthy</td>{{else}}<td class="fail">unhealthy</td>{{end}}<td>{{.CheckedAt.Format "2006-01-02 15:04:05"}}</td><td>{{.Error}}</td></tr>
+{{end}}
+</table>
+{{end}}
+
+<h2>Workers</h2>
+{{if .Workers}}
+<table>
+<tr><th>Address</th><th>Status</th><th>Pool size</th><th>Latency (ms)</th><th>Error</th></tr>
+{{range .Workers}}
+<tr><td>{{.Address}}</td>{{if .Reachable}}<td class="ok">reachable</td>{{else}}<td class="fail">unreachable</td>{{end}}<td>{{.PoolSize}}</td><td>{{printf "%.1f" .LatencyMS}}</td><td>{{.Error}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p class="summary">No workers configured.</p>
+{{end}}
+
+<h2>Caches</h2>
+{{if .Caches}}
+<table>
+<tr><th>Cache</th><th>Hits</th><th>Misses</th><th>Hit ratio</th></tr>
+{{range .Caches}}
+<tr><td>{{.Cache}}</td><td>{{.Hits}}</td><td>{{.Misses}}</td><td>{{printf "%.2f" .HitRatio}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p class="summary">No cache lookups yet.</p>
+{{end}}
+{{with .DiskCache}}
+<table>
+<tr><th>Disk cache</th><th>Policy</th><th>Size</th><th>Max size</th><th>Entries</th><th>Evictions</th><th>Rejected</th></tr>
+<tr><td>{{.Dir}}</td><td>{{.Policy}}</td><td>{{.Size}}</td><td>{{.MaxSize}}</td><td>{{.Entries}}</td><td>{{.Evictions}}</td><td>{{.Rejected}}</td></tr>
+</table>
+{{end}}
+
+<h2>Layers</h2>
+<table>
+<tr><th>Namespace</th><th>Name</th><th>Title</th><th>Status</th><th>Issues</th></tr>
+{{range .Layers}}
+<tr><td>{{.NameSpace}}</td><td>{{.Name}}</td><td>{{.Title}}</td>{{if not .Healthy}}<td class="fail">issues</td>{{else if .Deprecated}}<td class="warn">deprecated{{if .Sunset}}, sunset {{.Sunset}}{{end}}</td>{{else}}<td class="ok">ok</td>{{end}}<td>{{range .Issues}}{{.}}<br>{{end}}</td></tr>
+{{end}}
+</table>
+
+<h2>Recent errors</h2>
+{{if .RecentErrors}}
+<table>
+<tr><th>Time</th><th>Level</th><th>Component</th><th>Caller</th><th>Message</th></tr>
+{{range .RecentErrors}}
+<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td{{if eq .Level "error"}} class="fail"{{else}} class="warn"{{end}}>{{.Level}}</td><td>{{.Component}}</td><td>{{.Caller}}</td><td>{{.Msg}}{{range $k, $v := .Fields}} {{$k}}={{$v}}{{end}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p class="summary">No recent errors.</p>
+{{end}}
+</body>
+</html>
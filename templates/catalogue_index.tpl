@@ -94,17 +94,17 @@ a:hover {
 <div id="container">
   <ul class="nav">
   {{ range $index, $nav := .Navigations }}
-  <li><a href="{{ $nav.URL }}">{{ $nav.Title }}</a></li>
+  <li><a href="{{ $nav.URL | html }}">{{ $nav.Title | html }}</a></li>
   <li>/</li>
   {{ end }}
-  <li>{{ .Title }}</li>
+  <li>{{ .Title | html }}</li>
   </ul>
 
   <br />
 
   <ul class="list">
   {{ range $index, $e := .Endpoints }}
-  <li><a href="{{ $e.URL }}">{{ $e.Title }}</a></li>
+  <li><a href="{{ $e.URL | html }}">{{ $e.Title | html }}</a></li>
   {{ end }}
   </ul>
 </div>
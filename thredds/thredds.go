@@ -0,0 +1,276 @@
+// Package thredds walks the trees of THREDDS catalogs, following their
+// catalogRef elements, and returns the OPeNDAP URLs of their datasets, so
+// that the crawler can extract the metadata of remote datasets through
+// the netCDF driver of GDAL and MAS can serve them without local copies.
+package thredds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DefaultMaxDepth bounds the nesting of the catalogs walked.
+const DefaultMaxDepth = 32
+
+// maxCatalogSize bounds the size of each catalog read.
+const maxCatalogSize = 64 << 20
+
+// Dataset is a dataset of a catalog served by OPeNDAP.
+type Dataset struct {
+	Name string
+	ID   string
+	// URL is the OPeNDAP URL of the dataset, opened by GDAL.
+	URL string
+}
+
+// Walker walks the catalogs with its client.
+type Walker struct {
+	Client   *http.Client
+	MaxDepth int
+}
+
+type catalog struct {
+	Services []service `xml:"service"`
+	Datasets []dataset `xml:"dataset"`
+	Refs     []ref     `xml:"catalogRef"`
+}
+
+type service struct {
+	Name     string    `xml:"name,attr"`
+	Type     string    `xml:"serviceType,attr"`
+	Base     string    `xml:"base,attr"`
+	Services []service `xml:"service"`
+}
+
+type dataset struct {
+	Name        string     `xml:"name,attr"`
+	ID          string     `xml:"ID,attr"`
+	URLPath     string     `xml:"urlPath,attr"`
+	ServiceAttr string     `xml:"serviceName,attr"`
+	ServiceName string     `xml:"serviceName"`
+	Metadata    []metadata `xml:"metadata"`
+	Access      []access   `xml:"access"`
+	Datasets    []dataset  `xml:"dataset"`
+	Refs        []ref      `xml:"catalogRef"`
+}
+
+type metadata struct {
+	Inherited   bool   `xml:"inherited,attr"`
+	ServiceName string `xml:"serviceName"`
+}
+
+type access struct {
+	ServiceName string `xml:"serviceName,attr"`
+	URLPath     string `xml:"urlPath,attr"`
+}
+
+type ref struct {
+	Href string `xml:"http://www.w3.org/1999/xlink href,attr"`
+}
+
+// Walk calls fn with the OPeNDAP datasets of the catalog of catalogURL
+// and of the catalogs it refers to, each catalog being read once. The
+// datasets without OPeNDAP access are skipped.
+func (w *Walker) Walk(catalogURL string, fn func(Dataset) error) error {
+	u, err := url.Parse(catalogURL)
+	if err != nil {
+		return err
+	}
+	maxDepth := w.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	return w.walk(catalogXML(u), 0, maxDepth, make(map[string]bool), fn)
+}
+
+func (w *Walker) walk(u *url.URL, depth, maxDepth int, visited map[string]bool, fn func(Dataset) error) error {
+	if visited[u.String()] {
+		return nil
+	}
+	visited[u.String()] = true
+	if depth > maxDepth {
+		return fmt.Errorf("catalog %s nested deeper than %d catalogs", u, maxDepth)
+	}
+
+	cat, err := w.read(u)
+	if err != nil {
+		return err
+	}
+	services := make(map[string]service)
+	for _, s := range cat.Services {
+		addService(services, s)
+	}
+
+	var refs []ref
+	var visit func(ds dataset, inherited string) error
+	visit = func(ds dataset, inherited string) error {
+		name := inherited
+		for _, md := range ds.Metadata {
+			if len(md.ServiceName) > 0 {
+				name = md.ServiceName
+				if md.Inherited {
+					inherited = md.ServiceName
+				}
+			}
+		}
+		if len(ds.ServiceName) > 0 {
+			name = ds.ServiceName
+		}
+		if len(ds.ServiceAttr) > 0 {
+			name = ds.ServiceAttr
+		}
+
+		for _, a := range ds.Access {
+			if base, found := opendapBase(services, a.ServiceName); found {
+				if err := fn(Dataset{ds.Name, ds.ID, resolve(u, base+a.URLPath)}); err != nil {
+					return err
+				}
+				return w.visitChildren(ds, inherited, visit, &refs)
+			}
+		}
+		if len(ds.URLPath) > 0 {
+			if base, found := opendapBase(services, name); found {
+				if err := fn(Dataset{ds.Name, ds.ID, resolve(u, base+ds.URLPath)}); err != nil {
+					return err
+				}
+			}
+		}
+		return w.visitChildren(ds, inherited, visit, &refs)
+	}
+
+	refs = append(refs, cat.Refs...)
+	for _, ds := range cat.Datasets {
+		if err := visit(ds, ""); err != nil {
+			return err
+		}
+	}
+	for _, r := range refs {
+		if len(r.Href) == 0 {
+			continue
+		}
+		child, err := u.Parse(r.Href)
+		if err != nil {
+			return fmt.Errorf("invalid catalogRef %s of %s: %v", r.Href, u, err)
+		}
+		if err := w.walk(catalogXML(child), depth+1, maxDepth, visited, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Walker) visitChildren(ds dataset, inherited string, visit func(dataset, string) error, refs *[]ref) error {
+	*refs = append(*refs, ds.Refs...)
+	for _, child := range ds.Datasets {
+		if err := visit(child, inherited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Walker) read(u *url.URL) (*catalog, error) {
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("catalog %s: %s", u, resp.Status)
+	}
+	cat := &catalog{}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxCatalogSize)).Decode(cat); err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %v", u, err)
+	}
+	return cat, nil
+}
+
+// addService adds a service and the services of a compound service by
+// name, the compound services standing for their OPeNDAP service.
+func addService(services map[string]service, s service) {
+	for _, child := range s.Services {
+		addService(services, child)
+		if strings.EqualFold(child.Type, "OPENDAP") && strings.EqualFold(s.Type, "Compound") {
+			services[s.Name] = child
+		}
+	}
+	if _, found := services[s.Name]; !found {
+		services[s.Name] = s
+	}
+}
+
+// opendapBase returns the base of the OPeNDAP service of name, or of
+// the only OPeNDAP service of the catalog if name is empty.
+func opendapBase(services map[string]service, name string) (string, bool) {
+	if len(name) == 0 {
+		var bases []string
+		for _, s := range services {
+			if strings.EqualFold(s.Type, "OPENDAP") {
+				bases = append(bases, s.Base)
+			}
+		}
+		if len(bases) > 0 && allEqual(bases) {
+			return bases[0], true
+		}
+		return "", false
+	}
+	s, found := services[name]
+	if !found || !strings.EqualFold(s.Type, "OPENDAP") {
+		return "", false
+	}
+	return s.Base, true
+}
+
+func allEqual(values []string) bool {
+	for _, v := range values {
+		if v != values[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve returns the URL of a reference relative to the catalog.
+func resolve(catalogURL *url.URL, ref string) string {
+	u, err := catalogURL.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+// catalogXML returns the URL of the XML of a catalog, the HTML catalogs
+// of the THREDDS servers being served as XML under the same path.
+func catalogXML(u *url.URL) *url.URL {
+	if strings.HasSuffix(u.Path, ".html") {
+		xmlURL := *u
+		xmlURL.Path = strings.TrimSuffix(u.Path, ".html") + ".xml"
+		xmlURL.RawPath = ""
+		return &xmlURL
+	}
+	return u
+}
+
+// RecordPath returns the path of the records of the dataset of an
+// OPeNDAP URL in MAS, /thredds/ followed by the host and the path of the
+// URL, e.g. /thredds/example.org/thredds/dodsC/chirps/2020.nc, whose
+// parents are the gpaths of its collections.
+func RecordPath(opendapURL string) (string, error) {
+	u, err := url.Parse(opendapURL)
+	if err != nil {
+		return "", err
+	}
+	if len(u.Host) == 0 {
+		return "", fmt.Errorf("invalid OPeNDAP URL %s", opendapURL)
+	}
+	return path.Join("/thredds", u.Host, u.Path), nil
+}
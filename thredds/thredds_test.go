@@ -0,0 +1,84 @@
+package thredds
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const rootCatalog = `<?xml version="1.0" encoding="UTF-8"?>
+<catalog xmlns="http://www.unidata.ucar.edu/namespaces/thredds/InvCatalog/v1.0" xmlns:xlink="http://www.w3.org/1999/xlink">
+  <service name="all" serviceType="Compound" base="">
+    <service name="odap" serviceType="OPENDAP" base="/thredds/dodsC/" />
+    <service name="http" serviceType="HTTPServer" base="/thredds/fileServer/" />
+  </service>
+  <dataset name="CHIRPS" ID="chirps">
+    <metadata inherited="true"><serviceName>all</serviceName></metadata>
+    <dataset name="chirps-2019.nc" ID="chirps/2019" urlPath="chirps/chirps-2019.nc" />
+    <dataset name="chirps-2019.csv" ID="chirps/csv" urlPath="chirps/chirps-2019.csv" serviceName="http" />
+    <dataset name="chirps-2018.nc" ID="chirps/2018">
+      <access serviceName="odap" urlPath="chirps/old/chirps-2018.nc" />
+    </dataset>
+    <catalogRef xlink:href="2020/catalog.html" xlink:title="2020" name="" />
+  </dataset>
+</catalog>`
+
+const yearCatalog = `<?xml version="1.0" encoding="UTF-8"?>
+<catalog xmlns="http://www.unidata.ucar.edu/namespaces/thredds/InvCatalog/v1.0" xmlns:xlink="http://www.w3.org/1999/xlink">
+  <service name="dap" serviceType="OPeNDAP" base="/thredds/dodsC/" />
+  <dataset name="chirps-2020.nc" ID="chirps/2020" urlPath="chirps/2020/chirps-2020.nc" />
+  <catalogRef xlink:href="../catalog.xml" xlink:title="up" />
+</catalog>`
+
+func TestWalk(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/thredds/catalog/chirps/catalog.xml":
+			fmt.Fprint(w, rootCatalog)
+		case "/thredds/catalog/chirps/2020/catalog.xml":
+			fmt.Fprint(w, yearCatalog)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	var urls []string
+	w := &Walker{Client: srv.Client()}
+	err := w.Walk(srv.URL+"/thredds/catalog/chirps/catalog.html", func(ds Dataset) error {
+		urls = append(urls, ds.URL)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		srv.URL + "/thredds/dodsC/chirps/chirps-2019.nc",
+		srv.URL + "/thredds/dodsC/chirps/old/chirps-2018.nc",
+		srv.URL + "/thredds/dodsC/chirps/2020/chirps-2020.nc",
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("expected %v, got %v", expected, urls)
+	}
+
+	stop := errors.New("stop")
+	if err := w.Walk(srv.URL+"/thredds/catalog/chirps/catalog.xml", func(Dataset) error { return stop }); err != stop {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+	if err := w.Walk(srv.URL+"/thredds/catalog/missing.xml", func(Dataset) error { return nil }); err == nil {
+		t.Errorf("expected an error for a missing catalog")
+	}
+}
+
+func TestRecordPath(t *testing.T) {
+	p, err := RecordPath("https://thredds.example.org/thredds/dodsC/chirps/2020/chirps-2020.nc")
+	if err != nil || p != "/thredds/thredds.example.org/thredds/dodsC/chirps/2020/chirps-2020.nc" {
+		t.Errorf("unexpected record path %q, %v", p, err)
+	}
+	if _, err := RecordPath("/g/data/chirps.nc"); err == nil {
+		t.Errorf("expected an error for a path")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nci/gsky/diskcache"
+	"github.com/nci/gsky/utils"
+)
+
+// tileCache is the disk cache of the GetMap tiles configured by the
+// disk_cache of the root config. It is opened on the first request and
+// reconfigured when the config is reloaded.
+var tileCache struct {
+	mu     sync.Mutex
+	cache  *diskcache.Cache
+	config *diskcache.Config
+}
+
+func getTileCache() *diskcache.Cache {
+	rootConfig := getConfigMap()["."]
+	if rootConfig == nil || rootConfig.ServiceConfig.DiskCache == nil {
+		return nil
+	}
+	config := rootConfig.ServiceConfig.DiskCache
+
+	tileCache.mu.Lock()
+	defer tileCache.mu.Unlock()
+	if config == tileCache.config {
+		return tileCache.cache
+	}
+	tileCache.config = config
+	if tileCache.cache == nil {
+		cache, err := diskcache.Open(config)
+		if err != nil {
+			Error.Printf("Error in opening the disk cache: %v", err)
+			return nil
+		}
+		tileCache.cache = cache
+	} else if err := tileCache.cache.Reconfigure(config); err != nil {
+		Error.Printf("Error in reconfiguring the disk cache: %v", err)
+	}
+	return tileCache.cache
+}
+
+// tileCacheKey identifies a GetMap tile by the query parameters of the
+// request, with the bbox rounded to 9 significant digits and the time
+// replaced by the time resolved for it. The requests without time thus
+// do not return the tiles of a previous timestamp, and the tiles seeded
+// match the requests of the map clients, which format the bbox and the
+// time differently.
+func tileCacheKey(conf *utils.Config, r *http.Request, params utils.WMSParams) string {
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		if strings.ToLower(key) != utils.APIKeyParam {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(conf.ServiceConfig.NameSpace)
+	for _, key := range keys {
+		value := strings.Join(query[key], ",")
+		switch strings.ToLower(key) {
+		case "bbox":
+			if len(params.BBox) == 4 {
+				value = fmt.Sprintf("%.9g,%.9g,%.9g,%.9g", params.BBox[0], params.BBox[1], params.BBox[2], params.BBox[3])
+			}
+		case "time":
+			if params.Time != nil {
+				continue
+			}
+		}
+		b.WriteString("&" + strings.ToLower(key) + "=" + value)
+	}
+	if params.Time != nil {
+		b.WriteString("&resolved_time=" + params.Time.Format(utils.ISOFormat))
+	}
+	return b.String()
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nci/gsky/lifecycle"
+	"github.com/nci/gsky/logging"
+	"github.com/nci/gsky/metrics"
+	"github.com/nci/gsky/seeding"
+	"github.com/nci/gsky/utils"
+)
+
+// seedStatus is the progress of the last run of a tile seeding job.
+type seedStatus struct {
+	Job       string    `json:"job"`
+	Schedule  []string  `json:"schedule,omitempty"`
+	Running   bool      `json:"running"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Total     int       `json:"total"`
+	Cached    int       `json:"cached"`
+	Rendered  int       `json:"rendered"`
+	Errors    int       `json:"errors"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// tileSeeder is the status of the tile seeding jobs run by this server,
+// keyed by job name.
+var tileSeeder struct {
+	mu   sync.Mutex
+	runs map[string]*seedStatus
+}
+
+// tileSeedingJobs returns the tile seeding jobs of the root config.
+func tileSeedingJobs() []*seeding.Job {
+	rootConfig := getConfigMap()["."]
+	if rootConfig == nil {
+		return nil
+	}
+	return rootConfig.ServiceConfig.TileSeeding
+}
+
+// scheduleTileSeeding starts the tile seeding jobs at the times of their
+// schedule until the process exits. The runs missed while the server was
+// down are not caught up on.
+func scheduleTileSeeding() {
+	since := time.Now()
+	for now := range time.Tick(time.Minute) {
+		for _, job := range tileSeedingJobs() {
+			if job.Scheduled(now).After(since) {
+				if _, started := startTileSeeding(job); !started {
+					Info.Printf("Tile seeding %s is still running, skipping its scheduled run", job.Name)
+				}
+			}
+		}
+		since = now
+	}
+}
+
+// startTileSeeding starts a run of a job unless it is already running.
+func startTileSeeding(job *seeding.Job) (seedStatus, bool) {
+	tileSeeder.mu.Lock()
+	defer tileSeeder.mu.Unlock()
+	if tileSeeder.runs == nil {
+		tileSeeder.runs = make(map[string]*seedStatus)
+	}
+	if run, found := tileSeeder.runs[job.Name]; found && run.Running {
+		return *run, false
+	}
+	run := &seedStatus{Job: job.Name, Schedule: job.Schedule, Running: true, Started: time.Now(), Total: job.Count()}
+	tileSeeder.runs[job.Name] = run
+	go seedTiles(job, run)
+	return *run, true
+}
+
+// seedTiles renders the tiles of a job through the GetMap handler, which
+// stores them in the tile cache. The tiles already cached are skipped.
+func seedTiles(job *seeding.Job, run *seedStatus) {
+	Info.Printf("Tile seeding %s started: %d tiles", job.Name, run.Total)
+	record := func(cached bool, err error) {
+		tileSeeder.mu.Lock()
+		defer tileSeeder.mu.Unlock()
+		switch {
+		case err != nil:
+			run.Errors++
+			run.LastError = err.Error()
+		case cached:
+			run.Cached++
+		default:
+			run.Rendered++
+		}
+	}
+
+	conf := getConfigMap()[job.NameSpace]
+	if getTileCache() == nil {
+		record(false, fmt.Errorf("no disk_cache in the root config"))
+	} else if conf == nil {
+		record(false, fmt.Errorf("namespace %s not found", job.NameSpace))
+	} else {
+		ctx := logging.NewContext(context.Background(), logging.New("ows").With("seeding", job.Name))
+		queries := make(chan url.Values)
+		var wg sync.WaitGroup
+		for i := 0; i < job.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for query := range queries {
+					record(seedTile(ctx, conf, query))
+				}
+			}()
+		}
+		job.Requests(func(query url.Values) bool {
+			if lifecycle.Default.Draining() {
+				return false
+			}
+			queries <- query
+			return true
+		})
+		close(queries)
+		wg.Wait()
+	}
+
+	tileSeeder.mu.Lock()
+	run.Running = false
+	run.Finished = time.Now()
+	status := *run
+	tileSeeder.mu.Unlock()
+
+	msg := fmt.Sprintf("Tile seeding %s finished in %v: %d rendered, %d already cached, %d errors", job.Name, status.Finished.Sub(status.Started).Round(time.Second), status.Rendered, status.Cached, status.Errors)
+	if status.Errors > 0 {
+		Error.Printf("%s, last error: %s", msg, status.LastError)
+	} else {
+		Info.Printf("%s", msg)
+	}
+}
+
+// seedTile serves the GetMap of a tile, bypassing the access control and
+// the rate limits, and reports whether it was already cached.
+func seedTile(ctx context.Context, conf *utils.Config, query url.Values) (bool, error) {
+	r, err := http.NewRequest(http.MethodGet, "/ows?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	r = r.WithContext(ctx)
+	parsed, err := utils.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return false, err
+	}
+	params, err := utils.WMSParamsChecker(parsed, reWMSMap)
+	if err != nil {
+		return false, err
+	}
+
+	w := httptest.NewRecorder()
+	serveWMS(ctx, params, conf, r, w, metrics.NewMetricsCollector(nil))
+	if w.Code != http.StatusOK {
+		return false, fmt.Errorf("%s bbox %s: %d %s", query.Get("layers"), query.Get("bbox"), w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	return w.Header().Get("X-Gsky-Cache") == "HIT", nil
+}
+
+// seedHandler lists the tile seeding jobs with the status of their last
+// run, and runs a job on demand, e.g. POST /admin/seed?job=igad_forecast
+func seedHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorised(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tileSeeder.mu.Lock()
+		statuses := []seedStatus{}
+		for _, job := range tileSeedingJobs() {
+			status := seedStatus{Job: job.Name, Schedule: job.Schedule, Total: job.Count()}
+			if run, found := tileSeeder.runs[job.Name]; found {
+				status = *run
+			}
+			statuses = append(statuses, status)
+		}
+		tileSeeder.mu.Unlock()
+		w.Header().Set("Cache-Control", "no-store")
+		writeAdminJSON(w, http.StatusOK, statuses)
+
+	case http.MethodPost:
+		name := r.FormValue("job")
+		for _, job := range tileSeedingJobs() {
+			if job.Name != name {
+				continue
+			}
+			status, started := startTileSeeding(job)
+			if !started {
+				writeAdminJSON(w, http.StatusConflict, status)
+				return
+			}
+			Info.Printf("Tile seeding %s started by %s", name, adminAuthor(r))
+			writeAdminJSON(w, http.StatusAccepted, status)
+			return
+		}
+		http.Error(w, fmt.Sprintf("tile seeding job %q not found", name), http.StatusNotFound)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// runSeed implements `gsky-ows seed`, which requests the tiles of a
+// region from a running server so that they are cached, e.g. from cron
+// or after a deployment. It exits with status 1 if any tile failed.
+func runSeed(args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	baseURL := fs.String("url", "http://localhost:8080/ows", "OWS endpoint of the instance seeded, including the namespace if any.")
+	layers := fs.String("layers", "", "Comma separated names of the layers seeded.")
+	times := fs.String("time", "", "Comma separated times of the tiles. Defaults to the current time of each layer.")
+	bboxParam := fs.String("bbox", "21.8,-12.0,51.5,23.0", "EPSG:4326 extent of the tiles: minx,miny,maxx,maxy. Defaults to the IGAD region.")
+	zoom := fs.String("zoom", "3-7", "Range of the zoom levels seeded.")
+	extra := fs.String("params", "", "Query parameters of the map clients added to the GetMap requests, e.g. transparent=true&styles=anomaly")
+	apiKey := fs.String("api_key", "", "API key or token of the requests if access control is enabled.")
+	concurrency := fs.Int("concurrency", seeding.DefaultConcurrency, "Number of tiles requested at the same time.")
+	timeout := fs.Duration("timeout", 60*time.Second, "Timeout of each request.")
+	fs.Parse(args)
+
+	job, err := seedJobFromFlags(*layers, *times, *bboxParam, *zoom, *extra, *concurrency)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+		os.Exit(2)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	get := func(query url.Values) (bool, error) {
+		req, err := http.NewRequest(http.MethodGet, *baseURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return false, err
+		}
+		if len(*apiKey) > 0 {
+			req.Header.Set(utils.APIKeyHeader, *apiKey)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false, fmt.Errorf("%s bbox %s: %s", query.Get("layers"), query.Get("bbox"), resp.Status)
+		}
+		return resp.Header.Get("X-Gsky-Cache") == "HIT", nil
+	}
+
+	var mu sync.Mutex
+	var cached, rendered, failed int
+	queries := make(chan url.Values)
+	var wg sync.WaitGroup
+	for i := 0; i < job.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for query := range queries {
+				hit, err := get(query)
+				mu.Lock()
+				switch {
+				case err != nil:
+					failed++
+					fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+				case hit:
+					cached++
+				default:
+					rendered++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	t0 := time.Now()
+	fmt.Printf("Seeding %d tiles of %s\n", job.Count(), *baseURL)
+	job.Requests(func(query url.Values) bool {
+		queries <- query
+		return true
+	})
+	close(queries)
+	wg.Wait()
+
+	fmt.Printf("Seeded in %v: %d rendered, %d already cached, %d errors\n", time.Since(t0).Round(time.Second), rendered, cached, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// seedJobFromFlags returns the job of the flags of `gsky-ows seed`.
+func seedJobFromFlags(layers, times, bbox, zoom, extra string, concurrency int) (*seeding.Job, error) {
+	job := &seeding.Job{Name: "seed", Concurrency: concurrency, Params: make(map[string]string)}
+	for _, layer := range strings.Split(layers, ",") {
+		if layer = strings.TrimSpace(layer); len(layer) > 0 {
+			job.Layers = append(job.Layers, layer)
+		}
+	}
+	for _, t := range strings.Split(times, ",") {
+		if t = strings.TrimSpace(t); len(t) > 0 {
+			job.Times = append(job.Times, t)
+		}
+	}
+	for _, v := range strings.Split(bbox, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bbox: %v", err)
+		}
+		job.BBox = append(job.BBox, f)
+	}
+
+	zooms := strings.SplitN(zoom, "-", 2)
+	var err error
+	if job.MinZoom, err = strconv.Atoi(zooms[0]); err != nil {
+		return nil, fmt.Errorf("invalid zoom %s", zoom)
+	}
+	job.MaxZoom = job.MinZoom
+	if len(zooms) == 2 {
+		if job.MaxZoom, err = strconv.Atoi(zooms[1]); err != nil {
+			return nil, fmt.Errorf("invalid zoom %s", zoom)
+		}
+	}
+
+	params, err := url.ParseQuery(extra)
+	if err != nil {
+		return nil, fmt.Errorf("invalid params: %v", err)
+	}
+	for key := range params {
+		job.Params[key] = params.Get(key)
+	}
+	return job, job.Validate()
+}
@@ -0,0 +1,465 @@
+// Package tokens implements the scoped API tokens accepted by OWS and
+// MAS. The tokens are issued and revoked through the admin endpoints of
+// OWS and kept in a JSON file shared with MAS, which stores the SHA-256
+// digests of the tokens rather than the tokens themselves. Each process
+// reloads the file when it changes so that a revocation takes effect
+// everywhere within seconds.
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyHeader and APIKeyParam carry the API key or token of a request
+// besides the bearer token of the Authorization header. The query
+// parameter is for the WMS clients not able to set headers.
+const (
+	APIKeyHeader = "X-Api-Key"
+	APIKeyParam  = "api_key"
+)
+
+// Prefix starts every token so that tokens can be told apart from the
+// API keys of the access control.
+const Prefix = "gsky_"
+
+// The operations a token may be scoped to. MAS covers the queries of
+// the MAS API.
+const (
+	OperationView     = "view"
+	OperationDownload = "download"
+	OperationProcess  = "process"
+	OperationMAS      = "mas"
+)
+
+// reloadInterval bounds how often the token file is checked for changes.
+const reloadInterval = time.Second
+
+var (
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("token expired")
+	ErrRevoked = errors.New("token revoked")
+)
+
+// Token is the scope and state of an API token. Empty scope lists
+// allow everything; namespaces and layers are shell patterns and gpaths
+// are MAS path prefixes.
+type Token struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// User is the access control user whose roles also apply to the
+	// requests of the token.
+	User       string   `json:"user,omitempty"`
+	NameSpaces []string `json:"namespaces,omitempty"`
+	Layers     []string `json:"layers,omitempty"`
+	Operations []string `json:"operations,omitempty"`
+	GPaths     []string `json:"gpaths,omitempty"`
+	// RateLimit is the number of requests per minute allowed to each
+	// OWS and MAS process, unlimited if 0.
+	RateLimit float64    `json:"rate_limit,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	Created   time.Time  `json:"created"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	Revoked   *time.Time `json:"revoked,omitempty"`
+	RevokedBy string     `json:"revoked_by,omitempty"`
+	Digest    string     `json:"sha256,omitempty"`
+}
+
+func (t *Token) validate() error {
+	if len(strings.TrimSpace(t.Name)) == 0 {
+		return fmt.Errorf("token name is required")
+	}
+	for _, op := range t.Operations {
+		switch op {
+		case OperationView, OperationDownload, OperationProcess, OperationMAS:
+		default:
+			return fmt.Errorf("unknown operation %v, valid operations are %s, %s, %s and %s", op, OperationView, OperationDownload, OperationProcess, OperationMAS)
+		}
+	}
+	for _, patterns := range [][]string{t.NameSpaces, t.Layers} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid pattern %v: %v", p, err)
+			}
+		}
+	}
+	for _, p := range t.GPaths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("gpath must be absolute: %v", p)
+		}
+	}
+	if t.RateLimit < 0 {
+		return fmt.Errorf("rate_limit must not be negative")
+	}
+	return nil
+}
+
+// Active returns the error of an expired or revoked token.
+func (t *Token) Active(now time.Time) error {
+	if t.Revoked != nil {
+		return ErrRevoked
+	}
+	if t.Expires != nil && !now.Before(*t.Expires) {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (t *Token) permitsOperation(operation string) bool {
+	if len(t.Operations) == 0 {
+		return true
+	}
+	for _, op := range t.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// Permits reports whether the token allows the operation on the layer
+// or process of the namespace.
+func (t *Token) Permits(namespace, layer, operation string) bool {
+	matchAny := func(patterns []string, value string) bool {
+		if len(patterns) == 0 {
+			return true
+		}
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, value); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return t.permitsOperation(operation) && matchAny(t.NameSpaces, namespace) && matchAny(t.Layers, layer)
+}
+
+// PermitsGPath reports whether the token allows MAS queries on gpath.
+func (t *Token) PermitsGPath(gpath string) bool {
+	if !t.permitsOperation(OperationMAS) {
+		return false
+	}
+	if len(t.GPaths) == 0 {
+		return true
+	}
+	gpath = path.Clean("/" + gpath)
+	for _, p := range t.GPaths {
+		p = path.Clean(p)
+		if gpath == p || p == "/" || strings.HasPrefix(gpath, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest returns the bearer token, API key header or API key query
+// parameter of a request, in that order.
+func FromRequest(r *http.Request) string {
+	const bearer = "Bearer "
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, bearer) {
+		return strings.TrimSpace(strings.TrimPrefix(auth, bearer))
+	}
+	if key := r.Header.Get(APIKeyHeader); len(key) > 0 {
+		return key
+	}
+	return r.URL.Query().Get(APIKeyParam)
+}
+
+// IsToken reports whether key has the format of a token.
+func IsToken(key string) bool {
+	return strings.HasPrefix(key, Prefix)
+}
+
+func digest(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type tokenFile struct {
+	Tokens []*Token `json:"tokens"`
+}
+
+// Store is the set of tokens of a token file.
+type Store struct {
+	path string
+
+	mu       sync.Mutex
+	tokens   map[string]*Token
+	fileInfo os.FileInfo
+	checked  time.Time
+	limiters map[string]*limiter
+}
+
+// Open loads the token file, which is created on the first token
+// issued if it does not exist.
+func Open(filePath string) (*Store, error) {
+	s := &Store{path: filePath, tokens: make(map[string]*Token), limiters: make(map[string]*limiter)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the token file if it changed since it was last read.
+func (s *Store) load() error {
+	s.checked = time.Now()
+	fi, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.tokens = make(map[string]*Token)
+		s.fileInfo = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// The file is replaced on every change, so a change of file is
+	// detected even within the resolution of the modification time.
+	if s.fileInfo != nil && os.SameFile(fi, s.fileInfo) && fi.ModTime().Equal(s.fileInfo.ModTime()) && fi.Size() == s.fileInfo.Size() {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var tf tokenFile
+	if err := json.Unmarshal(data, &tf); err != nil {
+		return fmt.Errorf("token file %s: %v", s.path, err)
+	}
+	tokens := make(map[string]*Token, len(tf.Tokens))
+	for _, t := range tf.Tokens {
+		if t != nil && len(t.ID) > 0 {
+			tokens[t.ID] = t
+		}
+	}
+	s.tokens = tokens
+	s.fileInfo = fi
+	return nil
+}
+
+// save writes the token file atomically.
+func (s *Store) save() error {
+	tf := tokenFile{Tokens: make([]*Token, 0, len(s.tokens))}
+	for _, t := range s.tokens {
+		tf.Tokens = append(tf.Tokens, t)
+	}
+	sort.Slice(tf.Tokens, func(i, j int) bool { return tf.Tokens[i].Created.Before(tf.Tokens[j].Created) })
+	data, err := json.MarshalIndent(tf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".tokens-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.fileInfo = fi
+	}
+	return nil
+}
+
+// Issue creates a token with the scope of spec and returns its secret,
+// which is not stored and cannot be retrieved later.
+func (s *Store) Issue(spec Token, createdBy string) (string, *Token, error) {
+	if err := spec.validate(); err != nil {
+		return "", nil, err
+	}
+	now := time.Now().UTC()
+	if spec.Expires != nil && !spec.Expires.After(now) {
+		return "", nil, fmt.Errorf("token expiry is in the past")
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	t := spec
+	t.ID = hex.EncodeToString(id)
+	key := Prefix + t.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	t.Digest = digest(key)
+	t.Created = now
+	t.CreatedBy = createdBy
+	t.Revoked = nil
+	t.RevokedBy = ""
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return "", nil, err
+	}
+	s.tokens[t.ID] = &t
+	if err := s.save(); err != nil {
+		delete(s.tokens, t.ID)
+		return "", nil, err
+	}
+	issued := t
+	issued.Digest = ""
+	return key, &issued, nil
+}
+
+// Revoke revokes the token with the given ID.
+func (s *Store) Revoke(id, revokedBy string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	t, found := s.tokens[id]
+	if !found {
+		return nil, fmt.Errorf("token %s not found", id)
+	}
+	if t.Revoked != nil {
+		return nil, fmt.Errorf("token %s already revoked", id)
+	}
+	revoked := *t
+	now := time.Now().UTC()
+	revoked.Revoked = &now
+	revoked.RevokedBy = revokedBy
+	s.tokens[id] = &revoked
+	if err := s.save(); err != nil {
+		s.tokens[id] = t
+		return nil, err
+	}
+	delete(s.limiters, id)
+	out := revoked
+	out.Digest = ""
+	return &out, nil
+}
+
+// List returns the tokens without their digests, the oldest first.
+func (s *Store) List() ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	list := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		out := *t
+		out.Digest = ""
+		list = append(list, out)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list, nil
+}
+
+// Validate returns the token of the secret if it is active.
+func (s *Store) Validate(key string) (*Token, error) {
+	if !IsToken(key) {
+		return nil, ErrInvalid
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, Prefix), "_", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.checked) >= reloadInterval {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+	t, found := s.tokens[parts[0]]
+	if !found || subtle.ConstantTimeCompare([]byte(t.Digest), []byte(digest(key))) != 1 {
+		return nil, ErrInvalid
+	}
+	if err := t.Active(time.Now()); err != nil {
+		return nil, err
+	}
+	out := *t
+	return &out, nil
+}
+
+// Lookup returns the token with the given ID if it is active, e.g. the
+// token a URL was signed with.
+func (s *Store) Lookup(id string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.checked) >= reloadInterval {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+	t, found := s.tokens[id]
+	if !found {
+		return nil, ErrInvalid
+	}
+	if err := t.Active(time.Now()); err != nil {
+		return nil, err
+	}
+	out := *t
+	out.Digest = ""
+	return &out, nil
+}
+
+// limiter is a token bucket holding up to a minute of requests.
+type limiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// Allow reports whether a request of the token is within its rate
+// limit. The wait before the next request is allowed is returned
+// otherwise.
+func (s *Store) Allow(t *Token) (bool, time.Duration) {
+	if t.RateLimit <= 0 {
+		return true, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	perSecond := t.RateLimit / 60
+	l, found := s.limiters[t.ID]
+	if !found {
+		l = &limiter{tokens: t.RateLimit, last: now}
+		s.limiters[t.ID] = l
+	}
+	l.tokens += now.Sub(l.last).Seconds() * perSecond
+	if l.tokens > t.RateLimit {
+		l.tokens = t.RateLimit
+	}
+	l.last = now
+	if l.tokens < 1 {
+		wait := time.Duration((1 - l.tokens) / perSecond * float64(time.Second))
+		return false, wait
+	}
+	l.tokens--
+	return true, 0
+}
@@ -0,0 +1,175 @@
+package tokens
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "tokens.json")
+
+	store, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	secret, token, err := store.Issue(Token{Name: "portal", Layers: []string{"chirps_*"}, Expires: &expires}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsToken(secret) || len(token.ID) == 0 || len(token.Digest) > 0 || token.CreatedBy != "admin" {
+		t.Errorf("unexpected token issued: %v, %+v", secret, token)
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("unexpected token file mode: %v", fi.Mode())
+	}
+	data, _ := ioutil.ReadFile(file)
+	if strings.Contains(string(data), secret) {
+		t.Errorf("token secret stored in the token file")
+	}
+
+	// Another process sharing the token file.
+	other, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validated, err := other.Validate(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validated.ID != token.ID || validated.Name != "portal" {
+		t.Errorf("unexpected token validated: %+v", validated)
+	}
+	if looked, err := other.Lookup(token.ID); err != nil || looked.Name != "portal" || len(looked.Digest) > 0 {
+		t.Errorf("unexpected token looked up: %+v, %v", looked, err)
+	}
+	if _, err := other.Validate(secret + "x"); err != ErrInvalid {
+		t.Errorf("expected ErrInvalid, got %v", err)
+	}
+	if _, err := other.Validate("key-ke"); err != ErrInvalid {
+		t.Errorf("expected ErrInvalid, got %v", err)
+	}
+
+	if _, err := store.Revoke(token.ID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Revoke(token.ID, "admin"); err == nil {
+		t.Errorf("expected error revoking a revoked token")
+	}
+	if _, err := store.Validate(secret); err != ErrRevoked {
+		t.Errorf("expected ErrRevoked, got %v", err)
+	}
+	other.checked = time.Time{}
+	if _, err := other.Validate(secret); err != ErrRevoked {
+		t.Errorf("revocation not reloaded, got %v", err)
+	}
+	if _, err := other.Lookup(token.ID); err != ErrRevoked {
+		t.Errorf("expected ErrRevoked, got %v", err)
+	}
+
+	list, err := other.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Revoked == nil || list[0].RevokedBy != "admin" || len(list[0].Digest) > 0 {
+		t.Errorf("unexpected tokens listed: %+v", list)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if _, _, err := store.Issue(Token{Name: "old", Expires: &past}, "admin"); err == nil {
+		t.Errorf("expected error for an expired token")
+	}
+	if _, _, err := store.Issue(Token{Name: "bad", Operations: []string{"delete"}}, "admin"); err == nil {
+		t.Errorf("expected error for an unknown operation")
+	}
+	if _, _, err := store.Issue(Token{}, "admin"); err == nil {
+		t.Errorf("expected error for a token without name")
+	}
+}
+
+func TestPermits(t *testing.T) {
+	token := &Token{
+		NameSpaces: []string{"chirps"},
+		Layers:     []string{"rainfall_*"},
+		Operations: []string{OperationView, OperationMAS},
+		GPaths:     []string{"/g/data/chirps"},
+	}
+	cases := []struct {
+		namespace, layer, operation string
+		permitted                   bool
+	}{
+		{"chirps", "rainfall_daily", OperationView, true},
+		{"chirps", "rainfall_daily", OperationDownload, false},
+		{"chirps", "elevation", OperationView, false},
+		{"other", "rainfall_daily", OperationView, false},
+	}
+	for _, c := range cases {
+		if token.Permits(c.namespace, c.layer, c.operation) != c.permitted {
+			t.Errorf("%s/%s %s: expected permitted=%v", c.namespace, c.layer, c.operation, c.permitted)
+		}
+	}
+	if !(&Token{}).Permits("any", "layer", OperationProcess) {
+		t.Errorf("empty scope must permit everything")
+	}
+
+	for gpath, permitted := range map[string]bool{
+		"/g/data/chirps":         true,
+		"/g/data/chirps/v2/2020": true,
+		"/g/data/chirps2":        false,
+		"/g/data/chirps/../x":    false,
+	} {
+		if token.PermitsGPath(gpath) != permitted {
+			t.Errorf("%s: expected permitted=%v", gpath, permitted)
+		}
+	}
+	if (&Token{Operations: []string{OperationView}}).PermitsGPath("/g/data") {
+		t.Errorf("MAS query permitted without the mas operation")
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ows?api_key=param", nil)
+	if key := FromRequest(r); key != "param" {
+		t.Errorf("unexpected key: %v", key)
+	}
+	r.Header.Set(APIKeyHeader, "header")
+	if key := FromRequest(r); key != "header" {
+		t.Errorf("unexpected key: %v", key)
+	}
+	r.Header.Set("Authorization", "Bearer bearer")
+	if key := FromRequest(r); key != "bearer" {
+		t.Errorf("unexpected key: %v", key)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	store := &Store{limiters: make(map[string]*limiter)}
+	token := &Token{ID: "t", RateLimit: 2}
+	for i := 0; i < 2; i++ {
+		if ok, _ := store.Allow(token); !ok {
+			t.Fatalf("request %d denied", i)
+		}
+	}
+	ok, wait := store.Allow(token)
+	if ok || wait <= 0 || wait > 30*time.Second {
+		t.Errorf("expected rate limit, got %v, %v", ok, wait)
+	}
+	if ok, _ := store.Allow(&Token{ID: "u"}); !ok {
+		t.Errorf("token without rate limit denied")
+	}
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryClientInterceptor runs each gRPC call in a client span and
+// propagates the trace to the server in the call metadata.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if _, ok := parentSpanContext(ctx); !ok {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	ctx, span := StartSpan(ctx, strings.TrimPrefix(method, "/"), KindClient)
+	defer span.End()
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("net.peer.name", cc.Target())
+
+	if sc, ok := parentSpanContext(ctx); ok {
+		ctx = metadata.AppendToOutgoingContext(ctx, TraceparentHeader, sc.Traceparent())
+	}
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	span.SetError(err)
+	return err
+}
+
+// UnaryServerInterceptor runs each gRPC call in a server span continuing
+// the trace received in the call metadata.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !Enabled() {
+		return handler(ctx, req)
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(TraceparentHeader); len(values) > 0 {
+			if sc, err := ParseTraceparent(values[0]); err == nil {
+				ctx = ContextWithRemote(ctx, sc)
+			}
+		}
+	}
+
+	ctx, span := StartSpan(ctx, strings.TrimPrefix(info.FullMethod, "/"), KindServer)
+	defer span.End()
+	span.SetAttribute("rpc.system", "grpc")
+
+	res, err := handler(ctx, req)
+	span.SetError(err)
+	return res, err
+}
@@ -0,0 +1,206 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nci/gsky/logging"
+)
+
+// TraceparentHeader is the W3C Trace Context header carrying the span
+// context between services.
+const TraceparentHeader = "traceparent"
+
+// TraceIDHeader is the response header returning the trace ID of the
+// request to the client so that a slow request can be looked up in the
+// tracing backend.
+const TraceIDHeader = "X-Trace-Id"
+
+// ParseTraceparent parses a traceparent header value of the form
+// 00-<trace-id>-<parent-id>-<flags>.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent: %q", value)
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("unsupported traceparent version: %q", value)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("invalid traceparent trace id: %q", value)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("invalid traceparent parent id: %q", value)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("invalid traceparent flags: %q", value)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent: %q", value)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// Traceparent formats the span context as a traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", sc.TraceID[:], sc.SpanID[:], flags)
+}
+
+// Inject sets the traceparent header of an outgoing request from the
+// current span of ctx.
+func Inject(ctx context.Context, header http.Header) {
+	if sc, ok := parentSpanContext(ctx); ok {
+		header.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// Extract returns a copy of ctx carrying the span context of the
+// traceparent header of an incoming request if any.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	value := header.Get(TraceparentHeader)
+	if len(value) == 0 {
+		return ctx
+	}
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemote(ctx, sc)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Handler wraps h in a server span named after the service and the
+// method of the request. The span continues the trace of the incoming
+// traceparent header and is available to h from the request context.
+func Handler(service string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Enabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, span := StartSpan(Extract(r.Context(), r.Header), service+" "+r.Method, KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("http.host", r.Host)
+		if len(r.UserAgent()) > 0 {
+			span.SetAttribute("http.user_agent", r.UserAgent())
+		}
+		w.Header().Set(TraceIDHeader, span.TraceIDString())
+
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", rec.status)
+		if rec.status >= 500 {
+			span.SetError(fmt.Errorf("%s", http.StatusText(rec.status)))
+		}
+	})
+}
+
+// HandlerFunc is Handler for handler functions.
+func HandlerFunc(service string, h func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return Handler(service, http.HandlerFunc(h)).ServeHTTP
+}
+
+// spanBody ends the client span of a request once its response body
+// has been read and closed.
+type spanBody struct {
+	io.ReadCloser
+	span *Span
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.End()
+	return err
+}
+
+// Do sends req with http.DefaultClient in a client span child of the
+// current span of ctx, with the request ID of ctx. The span ends when
+// the response body is closed. ctx only carries the trace and the
+// request ID, it does not cancel the request.
+func Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if id := logging.RequestIDFromContext(ctx); len(id) > 0 {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+	ctx, span := StartSpan(ctx, "HTTP "+req.Method, KindClient)
+	if span == nil {
+		return http.DefaultClient.Do(req)
+	}
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	Inject(ctx, req.Header)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		span.SetError(err)
+		span.End()
+		return resp, err
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.SetError(fmt.Errorf("%s", resp.Status))
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// Get is http.Get propagating the trace of ctx.
+func Get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return Do(ctx, req)
+}
+
+// PostForm is http.PostForm propagating the trace of ctx.
+func PostForm(ctx context.Context, rawURL string, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("POST", rawURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return Do(ctx, req)
+}
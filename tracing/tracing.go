@@ -0,0 +1,298 @@
+// Package tracing implements distributed tracing across the GSKY
+// services. Spans are propagated between OWS, MAS and the gRPC workers
+// with the W3C Trace Context traceparent header and exported to an
+// OpenTelemetry collector, or any backend accepting OTLP/HTTP such as
+// Jaeger, so that a single request can be followed through the whole
+// system.
+//
+// Tracing is disabled until Init is called with a non-empty endpoint,
+// in which case all the functions of this package are cheap no-ops.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SpanKind is the role of a span in a trace.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = iota
+	KindServer
+	KindClient
+)
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid returns true if both the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Span is a timed operation of a trace. A nil Span is valid and all
+// its methods are no-ops, which is what StartSpan returns if tracing is
+// disabled.
+type Span struct {
+	SpanContext
+	ParentID [8]byte
+	Name     string
+	Kind     SpanKind
+	Start    time.Time
+	Finish   time.Time
+
+	mu      sync.Mutex
+	attrs   map[string]interface{}
+	errMsg  string
+	isError bool
+	ended   bool
+}
+
+type tracer struct {
+	mu          sync.RWMutex
+	sampleRatio float64
+	exporter    *exporter
+}
+
+var defaultTracer = &tracer{}
+
+// Init enables tracing for the service. The spans are exported to the
+// OTLP/HTTP endpoint, e.g. http://localhost:4318. If endpoint or
+// serviceName are empty, OTEL_EXPORTER_OTLP_ENDPOINT and
+// OTEL_SERVICE_NAME are used instead. sampleRatio is the fraction of the
+// traces started by this service that are recorded; the traces started
+// upstream follow the sampling decision of their parent.
+func Init(serviceName, endpoint string, sampleRatio float64) error {
+	if len(endpoint) == 0 {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if len(endpoint) == 0 {
+		return nil
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); len(name) > 0 {
+		serviceName = name
+	}
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return fmt.Errorf("trace sample ratio must be within [0, 1]: %v", sampleRatio)
+	}
+
+	exp, err := newExporter(serviceName, endpoint)
+	if err != nil {
+		return err
+	}
+
+	defaultTracer.mu.Lock()
+	old := defaultTracer.exporter
+	defaultTracer.sampleRatio = sampleRatio
+	defaultTracer.exporter = exp
+	defaultTracer.mu.Unlock()
+
+	if old != nil {
+		old.shutdown()
+	}
+	return nil
+}
+
+// Shutdown flushes the pending spans and disables tracing.
+func Shutdown() {
+	defaultTracer.mu.Lock()
+	exp := defaultTracer.exporter
+	defaultTracer.exporter = nil
+	defaultTracer.mu.Unlock()
+
+	if exp != nil {
+		exp.shutdown()
+	}
+}
+
+// Enabled returns true if the spans are being exported.
+func Enabled() bool {
+	defaultTracer.mu.RLock()
+	defer defaultTracer.mu.RUnlock()
+	return defaultTracer.exporter != nil
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the current span of ctx if any.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// ContextWithRemote returns a copy of ctx carrying the span context
+// received from an upstream service.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func parentSpanContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext, true
+	}
+	if ctx != nil {
+		if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok && sc.IsValid() {
+			return sc, true
+		}
+	}
+	return SpanContext{}, false
+}
+
+// StartSpan starts a span as a child of the current span of ctx, or of
+// the remote span context if ctx carries one. The returned context
+// carries the new span. The span must be ended with End.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	defaultTracer.mu.RLock()
+	enabled := defaultTracer.exporter != nil
+	ratio := defaultTracer.sampleRatio
+	defaultTracer.mu.RUnlock()
+	if !enabled {
+		return ctx, nil
+	}
+
+	span := &Span{Name: name, Kind: kind, Start: time.Now()}
+	if parent, ok := parentSpanContext(ctx); ok {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.Sampled = parent.Sampled
+	} else {
+		span.TraceID = newTraceID()
+		span.Sampled = sampled(span.TraceID, ratio)
+	}
+	span.SpanID = newSpanID()
+	return ContextWithSpan(ctx, span), span
+}
+
+// sampled makes the sampling decision from the trace ID so that it is
+// consistent for a given trace.
+func sampled(traceID [16]byte, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/(1<<53) < ratio
+}
+
+func newTraceID() [16]byte {
+	var id [16]byte
+	for id == [16]byte{} {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() [8]byte {
+	var id [8]byte
+	for id == [8]byte{} {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// SetName renames the span, typically once the operation served by a
+// server span is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Name = name
+}
+
+// SetAttribute records a key value pair describing the span. Values are
+// exported as strings unless they are booleans, integers or floats.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	switch v := value.(type) {
+	case bool, int64, float64, string:
+		s.attrs[key] = v
+	case int:
+		s.attrs[key] = int64(v)
+	case int32:
+		s.attrs[key] = int64(v)
+	case float32:
+		s.attrs[key] = float64(v)
+	case fmt.Stringer:
+		s.attrs[key] = v.String()
+	default:
+		s.attrs[key] = fmt.Sprintf("%v", v)
+	}
+}
+
+// SetError marks the span as failed. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.isError = true
+	s.errMsg = err.Error()
+}
+
+// End finishes the span and queues it for export if it is sampled.
+// Calling End more than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.Finish = time.Now()
+	s.mu.Unlock()
+
+	if !s.Sampled {
+		return
+	}
+	defaultTracer.mu.RLock()
+	exp := defaultTracer.exporter
+	defaultTracer.mu.RUnlock()
+	if exp != nil {
+		exp.enqueue(s)
+	}
+}
+
+// TraceIDString returns the hex encoded trace ID of the span, or an
+// empty string for a nil span.
+func (s *Span) TraceIDString() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", s.TraceID[:])
+}
@@ -0,0 +1,204 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nci/gsky/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTraceparent(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.Traceparent() != value {
+		t.Errorf("unexpected span context %+v", sc)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	}
+	for _, v := range invalid {
+		if _, err := ParseTraceparent(v); err == nil {
+			t.Errorf("expected error parsing %q", v)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	Shutdown()
+	ctx, span := StartSpan(context.Background(), "noop", KindInternal)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Errorf("span started with tracing disabled")
+	}
+	span.SetAttribute("k", "v")
+	span.SetError(nil)
+	span.End()
+}
+
+func TestSampling(t *testing.T) {
+	var id [16]byte
+	if !sampled(id, 1) || sampled(id, 0) {
+		t.Errorf("unexpected sampling decision")
+	}
+	n := 0
+	for i := 0; i < 1000; i++ {
+		if sampled(newTraceID(), 0.25) {
+			n++
+		}
+	}
+	if n < 150 || n > 350 {
+		t.Errorf("expected about 250 sampled traces, got %d", n)
+	}
+}
+
+type collector struct {
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req otlpRequest
+	if r.URL.Path != otlpTracesPath || json.NewDecoder(r.Body).Decode(&req) != nil {
+		http.Error(w, "bad request", 400)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	col := &collector{}
+	colServer := httptest.NewServer(col)
+	defer colServer.Close()
+
+	if err := Init("gsky-test", colServer.URL, 1); err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown()
+
+	var masTraceparent, masRequestID string
+	mas := httptest.NewServer(Handler("mas", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		masTraceparent = r.Header.Get(TraceparentHeader)
+		masRequestID = r.Header.Get(logging.RequestIDHeader)
+		w.Write([]byte("{}"))
+	})))
+	defer mas.Close()
+
+	ows := httptest.NewServer(HandlerFunc("ows", func(w http.ResponseWriter, r *http.Request) {
+		SpanFromContext(r.Context()).SetName("WMS GetMap")
+		resp, err := Get(logging.WithRequestID(r.Context(), "req-42"), mas.URL+"/g/data?intersects")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		http.Error(w, "worker failure", 500)
+	}))
+	defer ows.Close()
+
+	req, _ := http.NewRequest("GET", ows.URL+"/ows", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(TraceIDHeader) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unexpected trace id header: %q", resp.Header.Get(TraceIDHeader))
+	}
+	if len(masTraceparent) == 0 {
+		t.Fatalf("traceparent not propagated to MAS")
+	}
+	if masRequestID != "req-42" {
+		t.Errorf("request ID not propagated to MAS: %q", masRequestID)
+	}
+
+	Shutdown()
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	if len(col.spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(col.spans))
+	}
+	byName := make(map[string]otlpSpan)
+	for _, s := range col.spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %s not part of the trace: %s", s.Name, s.TraceID)
+		}
+		byName[s.Name] = s
+	}
+	owsSpan, client, masSpan := byName["WMS GetMap"], byName["HTTP GET"], byName["mas GET"]
+	if owsSpan.ParentSpanID != "00f067aa0ba902b7" || owsSpan.Kind != otlpKindServer {
+		t.Errorf("unexpected OWS span: %+v", owsSpan)
+	}
+	if owsSpan.Status == nil || owsSpan.Status.Code != otlpStatusError {
+		t.Errorf("expected OWS span in error: %+v", owsSpan)
+	}
+	if client.ParentSpanID != owsSpan.SpanID || client.Kind != otlpKindClient {
+		t.Errorf("unexpected client span: %+v", client)
+	}
+	if masSpan.ParentSpanID != client.SpanID || masTraceparent != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+client.SpanID+"-01" {
+		t.Errorf("unexpected MAS span: %+v", masSpan)
+	}
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	col := &collector{}
+	colServer := httptest.NewServer(col)
+	defer colServer.Close()
+
+	if err := Init("gsky-test", colServer.URL, 1); err != nil {
+		t.Fatal(err)
+	}
+	defer Shutdown()
+
+	ctx, span := StartSpan(context.Background(), "tile grpc", KindInternal)
+	cc, err := grpc.Dial("localhost:6000", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	var serverSpan *Span
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		serverCtx := metadata.NewIncomingContext(context.Background(), md)
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		_, err := UnaryServerInterceptor(serverCtx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			serverSpan = SpanFromContext(ctx)
+			return nil, nil
+		})
+		return err
+	}
+	if err := UnaryClientInterceptor(ctx, "/gdalservice.GDAL/Process", nil, nil, cc, invoker); err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+
+	if serverSpan == nil || serverSpan.TraceID != span.TraceID || serverSpan.Name != "gdalservice.GDAL/Process" {
+		t.Fatalf("trace not propagated to the worker: %+v", serverSpan)
+	}
+	if serverSpan.ParentID == span.SpanID {
+		t.Errorf("worker span not a child of the client span")
+	}
+}
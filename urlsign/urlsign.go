@@ -0,0 +1,139 @@
+// Package urlsign signs the URLs of the OWS data requests, e.g. of large
+// WCS extracts and WPS results, with an HMAC and an expiry, so that the
+// links can be shared and downloaded by browsers and scripts without
+// embedding the API key of their signer, and stop working once expired.
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/tokens"
+)
+
+// The query parameters added to the signed URLs, the signature last.
+const (
+	ExpiresParam   = "expires"
+	SignerParam    = "signed_by"
+	SignatureParam = "signature"
+)
+
+var (
+	ErrInvalid = errors.New("invalid URL signature")
+	ErrExpired = errors.New("signed URL expired")
+)
+
+// Signer signs and verifies URLs with a secret key shared by all the
+// OWS processes.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a signer with a secret key.
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign returns the URL signed on behalf of signer until expires. The
+// API key and the signature parameters of the URL are removed first.
+func (s *Signer) Sign(u *url.URL, signer string, expires time.Time) *url.URL {
+	var params []string
+	for _, p := range splitQuery(u.RawQuery) {
+		switch strings.ToLower(paramName(p)) {
+		case tokens.APIKeyParam, ExpiresParam, SignerParam, SignatureParam:
+		default:
+			params = append(params, p)
+		}
+	}
+	params = append(params,
+		ExpiresParam+"="+strconv.FormatInt(expires.Unix(), 10),
+		SignerParam+"="+url.QueryEscape(signer))
+	query := strings.Join(params, "&")
+
+	signed := *u
+	signed.RawQuery = query + "&" + SignatureParam + "=" + s.mac(u.Path, query)
+	return &signed
+}
+
+// Verify returns the signer of a signed URL, ErrInvalid if the URL was
+// altered or ErrExpired if it expired.
+func (s *Signer) Verify(u *url.URL, now time.Time) (string, error) {
+	sep := "&" + SignatureParam + "="
+	i := strings.LastIndex(u.RawQuery, sep)
+	if i < 0 {
+		return "", ErrInvalid
+	}
+	query, signature := u.RawQuery[:i], u.RawQuery[i+len(sep):]
+	if !hmac.Equal([]byte(signature), []byte(s.mac(u.Path, query))) {
+		return "", ErrInvalid
+	}
+
+	var expires, signer string
+	for _, p := range splitQuery(query) {
+		switch paramName(p) {
+		case ExpiresParam:
+			expires = p[len(ExpiresParam)+1:]
+		case SignerParam:
+			signer = p[len(SignerParam)+1:]
+		}
+	}
+	t, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	if !now.Before(time.Unix(t, 0)) {
+		return "", ErrExpired
+	}
+	signer, err = url.QueryUnescape(signer)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	return signer, nil
+}
+
+// IsSigned reports whether a URL carries a signature, valid or not.
+func IsSigned(u *url.URL) bool {
+	return strings.Contains(u.RawQuery, "&"+SignatureParam+"=")
+}
+
+func (s *Signer) mac(path, query string) string {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(path + "?" + query))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// splitQuery splits a raw query on the & not escaped by a backslash, as
+// the OWS query parser does.
+func splitQuery(query string) []string {
+	var params []string
+	start := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] == '&' && (i == 0 || query[i-1] != '\\') {
+			if i > start {
+				params = append(params, query[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(query) {
+		params = append(params, query[start:])
+	}
+	return params
+}
+
+func paramName(p string) string {
+	if i := strings.Index(p, "="); i >= 0 {
+		p = p[:i]
+	}
+	name, err := url.QueryUnescape(p)
+	if err != nil {
+		return p
+	}
+	return name
+}
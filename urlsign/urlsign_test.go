@@ -0,0 +1,54 @@
+package urlsign
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	u, err := url.Parse("https://gsky.example.com/ows/licensed?service=WCS&request=GetCoverage&coverage=rainfall_daily&bbox=33,-5,42,5&api_key=key1&format=GeoTIFF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	signed := s.Sign(u, "user:kenya", now.Add(time.Hour))
+	if strings.Contains(signed.RawQuery, "key1") || !IsSigned(signed) || IsSigned(u) {
+		t.Errorf("unexpected signed URL: %s", signed)
+	}
+
+	signer, err := s.Verify(signed, now)
+	if err != nil || signer != "user:kenya" {
+		t.Errorf("expected user:kenya, got %q, %v", signer, err)
+	}
+	if _, err := s.Verify(signed, now.Add(2*time.Hour)); err != ErrExpired {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+
+	// Signing again replaces the signature.
+	resigned := s.Sign(signed, "token:abc", now.Add(time.Minute))
+	if signer, err := s.Verify(resigned, now); err != nil || signer != "token:abc" || strings.Count(resigned.RawQuery, SignatureParam) != 1 {
+		t.Errorf("unexpected URL signed again: %s, %q, %v", resigned, signer, err)
+	}
+
+	for _, alter := range []func(*url.URL){
+		func(u *url.URL) { u.RawQuery = strings.Replace(u.RawQuery, "bbox=33", "bbox=30", 1) },
+		func(u *url.URL) {
+			u.RawQuery = strings.Replace(u.RawQuery, "signed_by=user%3Akenya", "signed_by=user%3Aadmin", 1)
+		},
+		func(u *url.URL) { u.RawQuery = "api_key=key2&" + u.RawQuery },
+		func(u *url.URL) { u.Path = "/ows/other" },
+		func(u *url.URL) { u.RawQuery = strings.SplitN(u.RawQuery, "&"+SignatureParam, 2)[0] },
+	} {
+		altered := *signed
+		alter(&altered)
+		if _, err := s.Verify(&altered, now); err != ErrInvalid {
+			t.Errorf("expected ErrInvalid for %s, got %v", altered.String(), err)
+		}
+	}
+	if _, err := NewSigner([]byte("other")).Verify(signed, now); err != ErrInvalid {
+		t.Errorf("expected ErrInvalid with another key, got %v", err)
+	}
+}
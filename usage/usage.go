@@ -0,0 +1,497 @@
+// Package usage aggregates the OWS requests served per day, layer and
+// client, i.e. the request counts, the unique clients and the data
+// volume of each layer, for the annual usage statistics required by the
+// data providers. The daily counts are kept in a directory of JSON files
+// or in a PostgreSQL table, and reported grouped over any period.
+package usage
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// DayFormat is the format of the days of the counts.
+const DayFormat = "2006-01-02"
+
+// Key identifies the requests counted together.
+type Key struct {
+	Day       string `json:"day"`
+	NameSpace string `json:"namespace"`
+	Service   string `json:"service"`
+	Layer     string `json:"layer"`
+	Client    string `json:"client"`
+}
+
+// Counts are the totals of the requests of a key.
+type Counts struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func (c *Counts) add(o *Counts) {
+	c.Requests += o.Requests
+	c.Errors += o.Errors
+	c.Bytes += o.Bytes
+}
+
+// Row is the counts of a key.
+type Row struct {
+	Key
+	Counts
+}
+
+// Store keeps the daily counts.
+type Store interface {
+	// Add adds counts to those stored.
+	Add(counts map[Key]*Counts) error
+	// Query returns the counts of the days from from to to included.
+	Query(from, to time.Time) ([]Row, error)
+	Close() error
+}
+
+// Open opens the store given as a postgres:// or postgresql:// URL, or
+// as a directory path otherwise.
+func Open(dest string) (Store, error) {
+	if strings.HasPrefix(dest, "postgres://") || strings.HasPrefix(dest, "postgresql://") {
+		return openDB(dest)
+	}
+	return openDir(dest)
+}
+
+// Recorder counts the requests in memory until they are flushed to the
+// store.
+type Recorder struct {
+	store Store
+
+	mu      sync.Mutex
+	pending map[Key]*Counts
+}
+
+// NewRecorder returns a recorder flushing to store.
+func NewRecorder(store Store) *Recorder {
+	return &Recorder{store: store, pending: make(map[Key]*Counts)}
+}
+
+// Record counts a request served at t. The requests with a status of
+// 400 or above are counted as errors.
+func (r *Recorder) Record(t time.Time, namespace, service, layer, client string, status int, bytes int64) {
+	key := Key{Day: t.UTC().Format(DayFormat), NameSpace: namespace, Service: service, Layer: layer, Client: client}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, found := r.pending[key]
+	if !found {
+		c = &Counts{}
+		r.pending[key] = c
+	}
+	c.Requests++
+	c.Bytes += bytes
+	if status >= 400 {
+		c.Errors++
+	}
+}
+
+// Flush adds the counts recorded to the store. They are kept for the
+// next flush if the store fails.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[Key]*Counts)
+	r.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := r.store.Add(pending)
+	if err != nil {
+		r.mu.Lock()
+		for key, c := range pending {
+			if p, found := r.pending[key]; found {
+				c.add(p)
+			}
+			r.pending[key] = c
+		}
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// Query returns the counts of the days from from to to included, with
+// those not flushed yet.
+func (r *Recorder) Query(from, to time.Time) ([]Row, error) {
+	rows, err := r.store.Query(from, to)
+	if err != nil {
+		return nil, err
+	}
+	fromDay, toDay := from.UTC().Format(DayFormat), to.UTC().Format(DayFormat)
+	r.mu.Lock()
+	for key, c := range r.pending {
+		if key.Day >= fromDay && key.Day <= toDay {
+			rows = append(rows, Row{Key: key, Counts: *c})
+		}
+	}
+	r.mu.Unlock()
+	return rows, nil
+}
+
+// dirStore keeps the counts of each day in a JSON file of a directory.
+// The directory is not to be shared by several processes.
+type dirStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func openDir(dir string) (*dirStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &dirStore{dir: dir}, nil
+}
+
+func (s *dirStore) path(day string) string {
+	return filepath.Join(s.dir, day+".json")
+}
+
+func (s *dirStore) read(day string) ([]Row, error) {
+	data, err := ioutil.ReadFile(s.path(day))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rows []Row
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("%s: %v", s.path(day), err)
+	}
+	return rows, nil
+}
+
+func (s *dirStore) Add(counts map[Key]*Counts) error {
+	days := make(map[string]map[Key]*Counts)
+	for key, c := range counts {
+		if days[key.Day] == nil {
+			days[key.Day] = make(map[Key]*Counts)
+		}
+		days[key.Day][key] = c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for day, dayCounts := range days {
+		rows, err := s.read(day)
+		if err != nil {
+			return err
+		}
+		merged := make(map[Key]*Counts, len(rows)+len(dayCounts))
+		for i := range rows {
+			merged[rows[i].Key] = &rows[i].Counts
+		}
+		for key, c := range dayCounts {
+			if m, found := merged[key]; found {
+				m.add(c)
+			} else {
+				copied := *c
+				merged[key] = &copied
+			}
+		}
+
+		rows = make([]Row, 0, len(merged))
+		for key, c := range merged {
+			rows = append(rows, Row{Key: key, Counts: *c})
+		}
+		sortRows(rows)
+		data, err := json.Marshal(rows)
+		if err != nil {
+			return err
+		}
+		tmp := s.path(day) + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, s.path(day)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *dirStore) Query(from, to time.Time) ([]Row, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	fromDay, toDay := from.UTC().Format(DayFormat), to.UTC().Format(DayFormat)
+	var rows []Row
+	for _, file := range files {
+		day := strings.TrimSuffix(filepath.Base(file), ".json")
+		if day < fromDay || day > toDay {
+			continue
+		}
+		dayRows, err := s.read(day)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, dayRows...)
+	}
+	return rows, nil
+}
+
+func (s *dirStore) Close() error {
+	return nil
+}
+
+const createTable = `create table if not exists gsky_usage (
+	day date not null,
+	namespace text not null,
+	service text not null,
+	layer text not null,
+	client text not null,
+	requests bigint not null,
+	errors bigint not null,
+	bytes bigint not null,
+	primary key (day, namespace, service, layer, client)
+)`
+
+// dbStore keeps the counts in the gsky_usage table, which is created if
+// it does not exist. It may be shared by all the OWS processes.
+type dbStore struct {
+	db *sql.DB
+}
+
+func openDB(url string) (*dbStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createTable); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("usage table: %v", err)
+	}
+	return &dbStore{db: db}, nil
+}
+
+func (s *dbStore) Add(counts map[Key]*Counts) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for key, c := range counts {
+		_, err := tx.Exec(`insert into gsky_usage (day, namespace, service, layer, client, requests, errors, bytes)
+			values ($1, $2, $3, $4, $5, $6, $7, $8)
+			on conflict (day, namespace, service, layer, client) do update set
+			requests = gsky_usage.requests + excluded.requests,
+			errors = gsky_usage.errors + excluded.errors,
+			bytes = gsky_usage.bytes + excluded.bytes`,
+			key.Day, key.NameSpace, key.Service, key.Layer, key.Client, c.Requests, c.Errors, c.Bytes)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *dbStore) Query(from, to time.Time) ([]Row, error) {
+	res, err := s.db.Query(`select to_char(day, 'YYYY-MM-DD'), namespace, service, layer, client, requests, errors, bytes
+		from gsky_usage where day between $1 and $2`,
+		from.UTC().Format(DayFormat), to.UTC().Format(DayFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var rows []Row
+	for res.Next() {
+		var r Row
+		if err := res.Scan(&r.Day, &r.NameSpace, &r.Service, &r.Layer, &r.Client, &r.Requests, &r.Errors, &r.Bytes); err != nil {
+			return nil, err
+		}
+		rows = append(rows, r)
+	}
+	return rows, res.Err()
+}
+
+func (s *dbStore) Close() error {
+	return s.db.Close()
+}
+
+// Groups are the fields the counts may be grouped by.
+var Groups = []string{"year", "month", "day", "namespace", "service", "layer", "client"}
+
+// ReportRow is the counts of a group, with the number of unique clients
+// of the group.
+type ReportRow struct {
+	Period    string `json:"period,omitempty"`
+	NameSpace string `json:"namespace,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Layer     string `json:"layer,omitempty"`
+	Client    string `json:"client,omitempty"`
+	Counts
+	Clients int `json:"clients"`
+}
+
+// Filter selects the rows of a report, its empty fields matching any
+// value.
+type Filter struct {
+	NameSpace string
+	Service   string
+	Layer     string
+	Client    string
+}
+
+func (f *Filter) match(r *Row) bool {
+	return (len(f.NameSpace) == 0 || f.NameSpace == r.NameSpace) &&
+		(len(f.Service) == 0 || f.Service == r.Service) &&
+		(len(f.Layer) == 0 || f.Layer == r.Layer) &&
+		(len(f.Client) == 0 || f.Client == r.Client)
+}
+
+// Aggregate sums the rows matching the filter by the groups, of which
+// at most one of year, month and day, sorted by group.
+func Aggregate(rows []Row, filter Filter, groupBy []string) ([]ReportRow, error) {
+	group := make(map[string]bool)
+	periods := 0
+	for _, g := range groupBy {
+		valid := false
+		for _, v := range Groups {
+			valid = valid || g == v
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown group %q, must be one of %s", g, strings.Join(Groups, ", "))
+		}
+		if g == "year" || g == "month" || g == "day" {
+			periods++
+		}
+		group[g] = true
+	}
+	if periods > 1 {
+		return nil, fmt.Errorf("at most one of year, month and day can be grouped by")
+	}
+
+	type aggregate struct {
+		row     ReportRow
+		clients map[string]bool
+	}
+	groups := make(map[ReportRow]*aggregate)
+	var keys []ReportRow
+	for i := range rows {
+		r := &rows[i]
+		if !filter.match(r) {
+			continue
+		}
+		var key ReportRow
+		switch {
+		case group["year"]:
+			key.Period = r.Day[:4]
+		case group["month"]:
+			key.Period = r.Day[:7]
+		case group["day"]:
+			key.Period = r.Day
+		}
+		if group["namespace"] {
+			key.NameSpace = r.NameSpace
+		}
+		if group["service"] {
+			key.Service = r.Service
+		}
+		if group["layer"] {
+			key.Layer = r.Layer
+		}
+		if group["client"] {
+			key.Client = r.Client
+		}
+
+		agg, found := groups[key]
+		if !found {
+			agg = &aggregate{row: key, clients: make(map[string]bool)}
+			groups[key] = agg
+			keys = append(keys, key)
+		}
+		agg.row.add(&r.Counts)
+		agg.clients[r.Client] = true
+	}
+
+	report := make([]ReportRow, 0, len(keys))
+	for _, key := range keys {
+		agg := groups[key]
+		agg.row.Clients = len(agg.clients)
+		report = append(report, agg.row)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		for _, f := range [][2]string{{a.Period, b.Period}, {a.NameSpace, b.NameSpace}, {a.Service, b.Service}, {a.Layer, b.Layer}, {a.Client, b.Client}} {
+			if f[0] != f[1] {
+				return f[0] < f[1]
+			}
+		}
+		return false
+	})
+	return report, nil
+}
+
+// WriteCSV writes a report as CSV with a header, with the columns of the
+// groups followed by the counts.
+func WriteCSV(w io.Writer, report []ReportRow, groupBy []string) error {
+	cw := csv.NewWriter(w)
+	var header []string
+	for _, g := range Groups {
+		for _, v := range groupBy {
+			if g == v {
+				header = append(header, g)
+			}
+		}
+	}
+	if err := cw.Write(append(header, "requests", "errors", "bytes", "clients")); err != nil {
+		return err
+	}
+	for _, r := range report {
+		var record []string
+		for _, g := range header {
+			switch g {
+			case "year", "month", "day":
+				record = append(record, r.Period)
+			case "namespace":
+				record = append(record, r.NameSpace)
+			case "service":
+				record = append(record, r.Service)
+			case "layer":
+				record = append(record, r.Layer)
+			case "client":
+				record = append(record, r.Client)
+			}
+		}
+		record = append(record, strconv.FormatInt(r.Requests, 10), strconv.FormatInt(r.Errors, 10), strconv.FormatInt(r.Bytes, 10), strconv.Itoa(r.Clients))
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func sortRows(rows []Row) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].Key, rows[j].Key
+		for _, f := range [][2]string{{a.NameSpace, b.NameSpace}, {a.Service, b.Service}, {a.Layer, b.Layer}, {a.Client, b.Client}} {
+			if f[0] != f[1] {
+				return f[0] < f[1]
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,86 @@
+package usage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := NewRecorder(store)
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	rec.Record(day, ".", "WMS", "chirps_daily", "ip:10.0.0.1", 200, 1000)
+	rec.Record(day, ".", "WMS", "chirps_daily", "ip:10.0.0.1", 200, 1000)
+	rec.Record(day, ".", "WCS", "chirps_daily", "user:kenya", 500, 10)
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	rec.Record(day, ".", "WMS", "chirps_daily", "ip:10.0.0.1", 200, 500)
+	rec.Record(day.AddDate(0, 1, 0), ".", "WMS", "chirps_daily", "ip:10.0.0.2", 200, 100)
+	rec.Record(day.AddDate(-1, 0, 0), ".", "WMS", "chirps_daily", "ip:10.0.0.3", 200, 100)
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	rec.Record(day, ".", "WMS", "gefs_forecast", "ip:10.0.0.1", 404, 0)
+
+	// The counts are kept across restarts and merged with those not
+	// flushed yet.
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.store = store
+	rows, err := rec.Query(day.AddDate(0, -1, 0), day.AddDate(0, 2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Aggregate(rows, Filter{}, []string{"layer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 2 {
+		t.Fatalf("expected 2 layers, got %+v", report)
+	}
+	if r := report[0]; r.Layer != "chirps_daily" || r.Requests != 5 || r.Errors != 1 || r.Bytes != 2610 || r.Clients != 3 {
+		t.Errorf("unexpected layer usage: %+v", r)
+	}
+	if r := report[1]; r.Layer != "gefs_forecast" || r.Requests != 1 || r.Errors != 1 {
+		t.Errorf("unexpected layer usage: %+v", r)
+	}
+
+	report, err = Aggregate(rows, Filter{Layer: "chirps_daily", Service: "WMS"}, []string{"month", "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 2 || report[0].Period != "2024-03" || report[0].Client != "ip:10.0.0.1" || report[0].Requests != 3 || report[1].Period != "2024-04" {
+		t.Errorf("unexpected monthly usage: %+v", report)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, report, []string{"client", "month"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "month,client,requests,errors,bytes,clients\n2024-03,ip:10.0.0.1,3,0,2500,1\n2024-04,ip:10.0.0.2,1,0,100,1\n"
+	if buf.String() != expected {
+		t.Errorf("expected CSV\n%s\ngot\n%s", expected, buf.String())
+	}
+
+	for _, groupBy := range [][]string{{"week"}, {"day", "month"}} {
+		if _, err := Aggregate(rows, Filter{}, groupBy); err == nil {
+			t.Errorf("expected error grouping by %v", groupBy)
+		}
+	}
+}
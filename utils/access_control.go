@@ -0,0 +1,517 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nci/gsky/tokens"
+)
+
+// APIKeyHeader and APIKeyParam carry the API key of an OWS request. The
+// query parameter is for the WMS clients not able to set headers.
+const (
+	APIKeyHeader = tokens.APIKeyHeader
+	APIKeyParam  = tokens.APIKeyParam
+)
+
+// The operations access rules apply to. View covers the WMS requests
+// and the metadata of the WCS coverages, download the WCS GetCoverage
+// and DAP requests and process the WPS requests.
+const (
+	AccessView     = "view"
+	AccessDownload = "download"
+	AccessProcess  = "process"
+)
+
+// AccessControl maps the API keys of the users to roles whose rules
+// allow or deny access to layers and processes. It is configured in the
+// service config of the root namespace and applies to all namespaces.
+//
+// A layer or process is protected if a rule of any role matches its
+// namespace and name, whatever the operations of the rule. A protected
+// one is only served to the users with a role allowing the request and
+// no role denying it. The others are served according to DefaultPolicy.
+type AccessControl struct {
+	// DefaultPolicy is allow or deny, allow by default.
+	DefaultPolicy string `json:"default_policy"`
+	// AnonymousRoles are the roles of the requests without API key.
+	AnonymousRoles []string      `json:"anonymous_roles"`
+	Users          []*AccessUser `json:"users"`
+	Roles          []*AccessRole `json:"roles"`
+
+	roles map[string]*AccessRole
+	users map[string]*AccessUser
+	keys  map[string]*AccessUser
+}
+
+// AccessUser is a user or an application identified by its API keys.
+type AccessUser struct {
+	Name string `json:"name"`
+	// APIKeys are the keys in clear or their SHA-256 hex digests
+	// prefixed by sha256:
+	APIKeys []string `json:"api_keys"`
+	Roles   []string `json:"roles"`
+}
+
+// AccessRole is a named set of access rules.
+type AccessRole struct {
+	Name  string        `json:"name"`
+	Rules []*AccessRule `json:"rules"`
+}
+
+// AccessRule allows or denies operations on layers. The namespaces and
+// layers are shell patterns, e.g. licensed_*, and an empty list matches
+// everything. The layers also match the identifiers of the processes.
+//
+// The bbox, in EPSG:4326, and the time range restrict the rule: an
+// allow rule only allows the requests within them and a deny rule only
+// denies the requests overlapping them.
+type AccessRule struct {
+	Effect     string    `json:"effect"`
+	NameSpaces []string  `json:"namespaces"`
+	Layers     []string  `json:"layers"`
+	Operations []string  `json:"operations"`
+	BBox       []float64 `json:"bbox"`
+	StartTime  string    `json:"start_time"`
+	EndTime    string    `json:"end_time"`
+
+	canonicalBBox []float64
+	start         *time.Time
+	end           *time.Time
+}
+
+func (ac *AccessControl) validate() error {
+	switch ac.DefaultPolicy {
+	case "":
+		ac.DefaultPolicy = "allow"
+	case "allow", "deny":
+	default:
+		return fmt.Errorf("access control default_policy must be allow or deny: %v", ac.DefaultPolicy)
+	}
+
+	ac.roles = make(map[string]*AccessRole)
+	for _, role := range ac.Roles {
+		if len(role.Name) == 0 {
+			return fmt.Errorf("access control role must have a name")
+		}
+		if _, found := ac.roles[role.Name]; found {
+			return fmt.Errorf("duplicated access control role: %v", role.Name)
+		}
+		for i, rule := range role.Rules {
+			if err := rule.validate(); err != nil {
+				return fmt.Errorf("access control role %v, rules[%d]: %v", role.Name, i, err)
+			}
+		}
+		ac.roles[role.Name] = role
+	}
+
+	for _, role := range ac.AnonymousRoles {
+		if _, found := ac.roles[role]; !found {
+			return fmt.Errorf("access control anonymous role not found: %v", role)
+		}
+	}
+
+	ac.users = make(map[string]*AccessUser)
+	ac.keys = make(map[string]*AccessUser)
+	for _, user := range ac.Users {
+		if len(user.Name) == 0 {
+			return fmt.Errorf("access control user must have a name")
+		}
+		if _, found := ac.users[user.Name]; found {
+			return fmt.Errorf("duplicated access control user: %v", user.Name)
+		}
+		ac.users[user.Name] = user
+		for _, role := range user.Roles {
+			if _, found := ac.roles[role]; !found {
+				return fmt.Errorf("access control user %v: role not found: %v", user.Name, role)
+			}
+		}
+		for _, key := range user.APIKeys {
+			digest := apiKeyDigest(key)
+			if strings.HasPrefix(key, "sha256:") {
+				digest = strings.ToLower(strings.TrimPrefix(key, "sha256:"))
+			}
+			if len(key) == 0 || len(digest) != sha256.Size*2 {
+				return fmt.Errorf("access control user %v: invalid API key", user.Name)
+			}
+			if _, found := ac.keys[digest]; found {
+				return fmt.Errorf("access control user %v: API key shared with another user", user.Name)
+			}
+			ac.keys[digest] = user
+		}
+	}
+	return nil
+}
+
+func (rule *AccessRule) validate() error {
+	if rule.Effect != "allow" && rule.Effect != "deny" {
+		return fmt.Errorf("effect must be allow or deny: %v", rule.Effect)
+	}
+	for _, op := range rule.Operations {
+		if op != AccessView && op != AccessDownload && op != AccessProcess {
+			return fmt.Errorf("unknown operation %v, valid operations are %s, %s and %s", op, AccessView, AccessDownload, AccessProcess)
+		}
+	}
+	for _, patterns := range [][]string{rule.NameSpaces, rule.Layers} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid pattern %v: %v", p, err)
+			}
+		}
+	}
+
+	if len(rule.BBox) > 0 {
+		if len(rule.BBox) != 4 || rule.BBox[0] >= rule.BBox[2] || rule.BBox[1] >= rule.BBox[3] {
+			return fmt.Errorf("bbox must be xmin, ymin, xmax, ymax: %v", rule.BBox)
+		}
+		rule.canonicalBBox = lonLatToCanonicalBBox(rule.BBox)
+	}
+
+	var err error
+	if rule.start, err = parseAccessTime(rule.StartTime); err != nil {
+		return err
+	}
+	if rule.end, err = parseAccessTime(rule.EndTime); err != nil {
+		return err
+	}
+	if rule.start != nil && rule.end != nil && rule.end.Before(*rule.start) {
+		return fmt.Errorf("end_time is before start_time")
+	}
+	return nil
+}
+
+func parseAccessTime(value string) (*time.Time, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD", value)
+}
+
+func apiKeyDigest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// lonLatToCanonicalBBox converts a EPSG:4326 bbox to EPSG:3857, the CRS
+// of GetCanonicalBbox.
+func lonLatToCanonicalBBox(bbox []float64) []float64 {
+	const radius = 6378137.0
+	const maxLat = 85.0511287798
+	x := func(lon float64) float64 { return radius * lon * math.Pi / 180 }
+	y := func(lat float64) float64 {
+		lat = math.Max(-maxLat, math.Min(maxLat, lat))
+		return radius * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+	}
+	return []float64{x(bbox[0]), y(bbox[1]), x(bbox[2]), y(bbox[3])}
+}
+
+// AccessError is returned for the requests denied by the access
+// control.
+type AccessError struct {
+	// Anonymous is set for the requests without API key, which are
+	// answered with 401 rather than 403.
+	Anonymous bool
+	msg       string
+}
+
+// NewAccessError returns the error of a denied request.
+func NewAccessError(anonymous bool, format string, args ...interface{}) *AccessError {
+	return &AccessError{Anonymous: anonymous, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *AccessError) Error() string {
+	return e.msg
+}
+
+// StatusCode returns the HTTP status of the denied request.
+func (e *AccessError) StatusCode() int {
+	if e.Anonymous {
+		return http.StatusUnauthorized
+	}
+	return http.StatusForbidden
+}
+
+// Access is the authorisation of the requests of a user to a namespace.
+// A nil Access allows everything.
+type Access struct {
+	User      string
+	NameSpace string
+	// Token is the API token of the request, if any. Its scope limits
+	// the requests in addition to the roles of its user.
+	Token *tokens.Token
+
+	ac    *AccessControl
+	roles []*AccessRole
+}
+
+// Authorise identifies the user of an OWS request to a namespace by its
+// API key. Requests without API key get the anonymous roles.
+func (ac *AccessControl) Authorise(r *http.Request, namespace string) (*Access, error) {
+	if ac == nil {
+		return nil, nil
+	}
+
+	key := tokens.FromRequest(r)
+	access := &Access{NameSpace: namespace, ac: ac}
+	roleNames := ac.AnonymousRoles
+	if len(key) > 0 {
+		user := ac.lookupKey(key)
+		if user == nil {
+			return nil, &AccessError{Anonymous: true, msg: "invalid API key"}
+		}
+		access.User = user.Name
+		roleNames = user.Roles
+	}
+	for _, name := range roleNames {
+		access.roles = append(access.roles, ac.roles[name])
+	}
+	return access, nil
+}
+
+// TokenAccess returns the access of the requests made with a validated
+// token to a namespace. The roles of the user of the token apply if it
+// has one, otherwise only the scope of the token limits the requests.
+// ac may be nil if the access control is disabled.
+func TokenAccess(ac *AccessControl, token *tokens.Token, namespace string) (*Access, error) {
+	access := &Access{User: token.User, NameSpace: namespace, Token: token, ac: ac}
+	if len(token.User) == 0 {
+		return access, nil
+	}
+	var user *AccessUser
+	if ac != nil {
+		user = ac.users[token.User]
+	}
+	if user == nil {
+		return nil, &AccessError{msg: fmt.Sprintf("user of token %s not found", token.ID)}
+	}
+	for _, name := range user.Roles {
+		access.roles = append(access.roles, ac.roles[name])
+	}
+	return access, nil
+}
+
+// UserAccess returns the access of the requests made on behalf of a
+// named user to a namespace, e.g. with a URL signed by the user. ac may
+// be nil if the access control is disabled.
+func UserAccess(ac *AccessControl, name, namespace string) (*Access, error) {
+	if ac == nil {
+		return nil, nil
+	}
+	user := ac.users[name]
+	if user == nil {
+		return nil, &AccessError{Anonymous: true, msg: fmt.Sprintf("user %s not found", name)}
+	}
+	access := &Access{User: user.Name, NameSpace: namespace, ac: ac}
+	for _, role := range user.Roles {
+		access.roles = append(access.roles, ac.roles[role])
+	}
+	return access, nil
+}
+
+// HasUser reports whether the access control has the named user.
+func (ac *AccessControl) HasUser(name string) bool {
+	return ac != nil && ac.users[name] != nil
+}
+
+// lookupKey compares the digests of the keys so that the lookup time
+// does not depend on the key.
+func (ac *AccessControl) lookupKey(key string) *AccessUser {
+	return ac.keys[apiKeyDigest(key)]
+}
+
+// AccessScope is the extent of a data request: Bbox is in EPSG:3857, as
+// returned by GetCanonicalBbox, and Start to End the requested time
+// range. A nil field is an unknown extent, which only rules without the
+// corresponding restriction allow.
+type AccessScope struct {
+	BBox  []float64
+	Start *time.Time
+	End   *time.Time
+}
+
+// Check returns an AccessError if the operation on the layer or process
+// is not allowed. A nil scope checks access to the metadata of the
+// layer, e.g. for GetCapabilities, which any allow rule grants
+// regardless of its restrictions.
+func (a *Access) Check(layer, operation string, scope *AccessScope) error {
+	if a == nil {
+		return nil
+	}
+	if a.Token != nil {
+		if !a.Token.Permits(a.NameSpace, layer, operation) {
+			return &AccessError{msg: fmt.Sprintf("%s access to %s is outside the scope of token %s", operation, layer, a.Token.ID)}
+		}
+		if a.ac == nil || len(a.Token.User) == 0 {
+			return nil
+		}
+	}
+
+	protected := false
+	for _, role := range a.ac.Roles {
+		for _, rule := range role.Rules {
+			if rule.matchesLayer(a.NameSpace, layer) {
+				protected = true
+				break
+			}
+		}
+	}
+
+	allowed := !protected && a.ac.DefaultPolicy == "allow"
+	for _, role := range a.roles {
+		for _, rule := range role.Rules {
+			if !rule.matches(a.NameSpace, layer, operation) {
+				continue
+			}
+			if rule.Effect == "deny" {
+				if scope == nil && rule.isRestricted() {
+					continue
+				}
+				if scope == nil || rule.overlaps(scope) {
+					return a.denied(layer, operation)
+				}
+			} else if scope == nil || rule.contains(scope) {
+				allowed = true
+			}
+		}
+	}
+
+	if !allowed {
+		return a.denied(layer, operation)
+	}
+	return nil
+}
+
+// Allowed reports whether the metadata of the layer or process may be
+// advertised to the user.
+func (a *Access) Allowed(layer, operation string) bool {
+	return a.Check(layer, operation, nil) == nil
+}
+
+func (a *Access) denied(layer, operation string) error {
+	return &AccessError{Anonymous: len(a.User) == 0 && a.Token == nil, msg: fmt.Sprintf("%s access to %s is denied", operation, layer)}
+}
+
+func (rule *AccessRule) matches(namespace, layer, operation string) bool {
+	if len(rule.Operations) > 0 {
+		found := false
+		for _, op := range rule.Operations {
+			found = found || op == operation
+		}
+		if !found {
+			return false
+		}
+	}
+	return rule.matchesLayer(namespace, layer)
+}
+
+func (rule *AccessRule) matchesLayer(namespace, layer string) bool {
+	matchAny := func(patterns []string, value string) bool {
+		if len(patterns) == 0 {
+			return true
+		}
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, value); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return matchAny(rule.NameSpaces, namespace) && matchAny(rule.Layers, layer)
+}
+
+func (rule *AccessRule) isRestricted() bool {
+	return rule.canonicalBBox != nil || rule.start != nil || rule.end != nil
+}
+
+// contains reports whether the scope is within the restrictions of the
+// rule.
+func (rule *AccessRule) contains(scope *AccessScope) bool {
+	if b := rule.canonicalBBox; b != nil {
+		s := scope.BBox
+		if len(s) != 4 || s[0] < b[0] || s[1] < b[1] || s[2] > b[2] || s[3] > b[3] {
+			return false
+		}
+	}
+	if rule.start != nil && (scope.Start == nil || scope.Start.Before(*rule.start)) {
+		return false
+	}
+	if rule.end != nil {
+		end := scope.End
+		if end == nil {
+			end = scope.Start
+		}
+		if end == nil || end.After(*rule.end) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlaps reports whether the scope intersects the restrictions of the
+// rule. An unknown extent is deemed to overlap.
+func (rule *AccessRule) overlaps(scope *AccessScope) bool {
+	if b, s := rule.canonicalBBox, scope.BBox; b != nil && len(s) == 4 {
+		if s[2] < b[0] || s[0] > b[2] || s[3] < b[1] || s[1] > b[3] {
+			return false
+		}
+	}
+	end := scope.End
+	if end == nil {
+		end = scope.Start
+	}
+	if rule.start != nil && end != nil && end.Before(*rule.start) {
+		return false
+	}
+	if rule.end != nil && scope.Start != nil && scope.Start.After(*rule.end) {
+		return false
+	}
+	return true
+}
+
+type accessContextKey struct{}
+
+// NewAccessContext returns a context carrying the access of a request.
+func NewAccessContext(ctx context.Context, access *Access) context.Context {
+	return context.WithValue(ctx, accessContextKey{}, access)
+}
+
+// AccessFromContext returns the access of a request, nil if the access
+// control is disabled.
+func AccessFromContext(ctx context.Context) *Access {
+	access, _ := ctx.Value(accessContextKey{}).(*Access)
+	return access
+}
+
+// FilterLayers returns a shallow copy of config with only the layers
+// and processes advertised to the user.
+func (a *Access) FilterLayers(config *Config) *Config {
+	if a == nil || config == nil {
+		return config
+	}
+	newConf := *config
+	newConf.Layers = make([]Layer, 0, len(config.Layers))
+	for _, layer := range config.Layers {
+		if a.Allowed(layer.Name, AccessView) {
+			newConf.Layers = append(newConf.Layers, layer)
+		}
+	}
+	newConf.Processes = make([]Process, 0, len(config.Processes))
+	for _, proc := range config.Processes {
+		if a.Allowed(proc.Identifier, AccessProcess) {
+			newConf.Processes = append(newConf.Processes, proc)
+		}
+	}
+	return &newConf
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nci/gsky/tokens"
+)
+
+const testAccessControl = `{
+	"anonymous_roles": ["public"],
+	"users": [
+		{"name": "kenya", "api_keys": ["key-ke"], "roles": ["public", "kenya"]},
+		{"name": "viewer", "api_keys": ["sha256:f6804c745e4d2b70f0caf0800bac2358a368c02fcc96b361192948511abdee61"], "roles": ["public"]}
+	],
+	"roles": [
+		{"name": "public", "rules": [
+			{"effect": "allow", "layers": ["chirps_*"], "operations": ["view"]}
+		]},
+		{"name": "kenya", "rules": [
+			{"effect": "allow", "namespaces": ["licensed"], "layers": ["rainfall_*"], "operations": ["view", "download"],
+			 "bbox": [33.5, -5, 42, 5.5], "start_time": "2010-01-01"},
+			{"effect": "deny", "namespaces": ["licensed"], "layers": ["rainfall_daily"], "operations": ["download"],
+			 "end_time": "2014-12-31"}
+		]}
+	]
+}`
+
+func TestAccessControl(t *testing.T) {
+	var ac AccessControl
+	if err := json.Unmarshal([]byte(testAccessControl), &ac); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	authorise := func(key, namespace string) *Access {
+		r := httptest.NewRequest("GET", "/ows/"+namespace+"?service=WMS&api_key="+key, nil)
+		access, err := ac.Authorise(r, namespace)
+		if err != nil {
+			t.Fatalf("key %q: %v", key, err)
+		}
+		return access
+	}
+	date := func(s string) *time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return &d
+	}
+	nairobi := lonLatToCanonicalBBox([]float64{36.6, -1.5, 37.1, -1.1})
+	lagos := lonLatToCanonicalBBox([]float64{3.2, 6.4, 3.6, 6.7})
+
+	anonymous := authorise("", "licensed")
+	kenya := authorise("key-ke", "licensed")
+	viewer := authorise("key-viewer", "licensed")
+	if kenya.User != "kenya" || viewer.User != "viewer" {
+		t.Errorf("unexpected users: %v, %v", kenya.User, viewer.User)
+	}
+
+	cases := []struct {
+		name      string
+		access    *Access
+		layer     string
+		operation string
+		scope     *AccessScope
+		allowed   bool
+	}{
+		{"unprotected layer", anonymous, "elevation", AccessDownload, &AccessScope{}, true},
+		{"public layer", anonymous, "chirps_daily", AccessView, &AccessScope{}, true},
+		{"public layer not downloadable", anonymous, "chirps_daily", AccessDownload, &AccessScope{}, false},
+		{"licensed layer anonymous", anonymous, "rainfall_daily", AccessView, &AccessScope{BBox: nairobi, Start: date("2020-01-01")}, false},
+		{"licensed layer other user", viewer, "rainfall_daily", AccessView, &AccessScope{BBox: nairobi, Start: date("2020-01-01")}, false},
+		{"licensed layer metadata anonymous", anonymous, "rainfall_daily", AccessView, nil, false},
+		{"licensed layer in bbox", kenya, "rainfall_monthly", AccessView, &AccessScope{BBox: nairobi, Start: date("2020-01-01")}, true},
+		{"licensed layer outside bbox", kenya, "rainfall_monthly", AccessView, &AccessScope{BBox: lagos, Start: date("2020-01-01")}, false},
+		{"licensed layer before start", kenya, "rainfall_monthly", AccessView, &AccessScope{BBox: nairobi, Start: date("2005-01-01")}, false},
+		{"licensed layer unknown bbox", kenya, "rainfall_monthly", AccessView, &AccessScope{Start: date("2020-01-01")}, false},
+		{"licensed layer metadata", kenya, "rainfall_monthly", AccessView, nil, true},
+		{"denied download period", kenya, "rainfall_daily", AccessDownload, &AccessScope{BBox: nairobi, Start: date("2012-01-01")}, false},
+		{"download after denied period", kenya, "rainfall_daily", AccessDownload, &AccessScope{BBox: nairobi, Start: date("2016-01-01")}, true},
+		{"deny rule ignored for metadata", kenya, "rainfall_daily", AccessDownload, nil, true},
+	}
+	for _, c := range cases {
+		err := c.access.Check(c.layer, c.operation, c.scope)
+		if (err == nil) != c.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", c.name, c.allowed, err)
+		}
+	}
+
+	other := authorise("key-ke", "other")
+	if err := other.Check("rainfall_monthly", AccessView, &AccessScope{BBox: nairobi, Start: date("2020-01-01")}); err != nil {
+		t.Errorf("rules of other namespaces applied: %v", err)
+	}
+
+	err := anonymous.Check("rainfall_daily", AccessView, nil)
+	if ae, ok := err.(*AccessError); !ok || ae.StatusCode() != 401 {
+		t.Errorf("expected 401 for anonymous requests, got %v", err)
+	}
+	err = kenya.Check("chirps_daily", AccessDownload, nil)
+	if ae, ok := err.(*AccessError); !ok || ae.StatusCode() != 403 {
+		t.Errorf("expected 403 for users, got %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/ows", nil)
+	r.Header.Set(APIKeyHeader, "wrong")
+	if _, err := ac.Authorise(r, "."); err == nil {
+		t.Errorf("expected error for an invalid API key")
+	}
+
+	var nilAccess *Access
+	if err := nilAccess.Check("rainfall_daily", AccessDownload, nil); err != nil {
+		t.Errorf("nil access must allow everything: %v", err)
+	}
+
+	conf := &Config{
+		Layers:    []Layer{{Name: "chirps_daily"}, {Name: "rainfall_daily"}, {Name: "elevation"}},
+		Processes: []Process{{Identifier: "rainfall_daily"}, {Identifier: "drought_index"}},
+	}
+	filtered := anonymous.FilterLayers(conf)
+	if len(filtered.Layers) != 2 || filtered.Layers[1].Name != "elevation" || len(filtered.Processes) != 1 {
+		t.Errorf("unexpected layers advertised: %+v", filtered.Layers)
+	}
+	if len(conf.Layers) != 3 {
+		t.Errorf("config modified by FilterLayers")
+	}
+}
+
+func TestAccessControlValidate(t *testing.T) {
+	invalid := []string{
+		`{"default_policy": "maybe"}`,
+		`{"roles": [{"name": "r", "rules": [{"effect": "permit"}]}]}`,
+		`{"roles": [{"name": "r", "rules": [{"effect": "allow", "operations": ["delete"]}]}]}`,
+		`{"roles": [{"name": "r", "rules": [{"effect": "allow", "bbox": [10, 0, 0, 10]}]}]}`,
+		`{"roles": [{"name": "r", "rules": [{"effect": "allow", "start_time": "yesterday"}]}]}`,
+		`{"roles": [{"name": "r", "rules": [{"effect": "allow", "layers": ["["]}]}]}`,
+		`{"anonymous_roles": ["missing"]}`,
+		`{"users": [{"name": "u", "roles": ["missing"]}]}`,
+		`{"users": [{"name": "u", "api_keys": ["sha256:abc"]}]}`,
+		`{"users": [{"name": "u", "api_keys": ["k"]}, {"name": "v", "api_keys": ["k"]}]}`,
+	}
+	for _, conf := range invalid {
+		var ac AccessControl
+		if err := json.Unmarshal([]byte(conf), &ac); err != nil {
+			t.Fatal(err)
+		}
+		if err := ac.validate(); err == nil {
+			t.Errorf("expected error for %s", conf)
+		}
+	}
+}
+
+func TestTokenAccess(t *testing.T) {
+	var ac AccessControl
+	if err := json.Unmarshal([]byte(testAccessControl), &ac); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.validate(); err != nil {
+		t.Fatal(err)
+	}
+	nairobi := lonLatToCanonicalBBox([]float64{36.6, -1.5, 37.1, -1.1})
+	start, _ := time.Parse("2006-01-02", "2020-01-01")
+	scope := &AccessScope{BBox: nairobi, Start: &start}
+
+	token := &tokens.Token{ID: "t1", Layers: []string{"rainfall_*"}, Operations: []string{tokens.OperationView}}
+	access, err := TokenAccess(&ac, token, "licensed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := access.Check("rainfall_daily", AccessView, scope); err != nil {
+		t.Errorf("token without user must only be limited by its scope: %v", err)
+	}
+	err = access.Check("rainfall_daily", AccessDownload, scope)
+	if ae, ok := err.(*AccessError); !ok || ae.StatusCode() != 403 {
+		t.Errorf("expected 403 outside the token scope, got %v", err)
+	}
+
+	token.User = "viewer"
+	access, err = TokenAccess(&ac, token, "licensed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := access.Check("rainfall_daily", AccessView, scope); err == nil {
+		t.Errorf("roles of the token user not applied")
+	}
+	token.User = "kenya"
+	access, _ = TokenAccess(&ac, token, "licensed")
+	if err := access.Check("rainfall_daily", AccessView, scope); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	token.User = "missing"
+	if _, err := TokenAccess(&ac, token, "licensed"); err == nil {
+		t.Errorf("expected error for an unknown token user")
+	}
+	if _, err := TokenAccess(nil, token, "licensed"); err == nil {
+		t.Errorf("expected error for a token user without access control")
+	}
+}
+
+func TestUserAccess(t *testing.T) {
+	var ac AccessControl
+	if err := json.Unmarshal([]byte(testAccessControl), &ac); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.validate(); err != nil {
+		t.Fatal(err)
+	}
+	nairobi := lonLatToCanonicalBBox([]float64{36.6, -1.5, 37.1, -1.1})
+	start, _ := time.Parse("2006-01-02", "2020-01-01")
+	scope := &AccessScope{BBox: nairobi, Start: &start}
+
+	access, err := UserAccess(&ac, "kenya", "licensed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.User != "kenya" || access.Check("rainfall_daily", AccessDownload, scope) != nil {
+		t.Errorf("roles of the user not applied: %+v", access)
+	}
+	access, _ = UserAccess(&ac, "viewer", "licensed")
+	if access.Check("rainfall_daily", AccessDownload, scope) == nil {
+		t.Errorf("expected download denied to viewer")
+	}
+	if _, err := UserAccess(&ac, "missing", "licensed"); err == nil {
+		t.Errorf("expected error for an unknown user")
+	}
+	if access, err := UserAccess(nil, "kenya", "licensed"); access != nil || err != nil {
+		t.Errorf("expected nil access without access control, got %+v, %v", access, err)
+	}
+}
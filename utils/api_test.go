@@ -18,19 +18,19 @@ func TestGenerateDatesMas(t *testing.T) {
 	namespaces := []string{"bare_soil", "phot_veg", "nphot_veg"}
 
 	step, _ := time.ParseDuration("0s")
-	res := GenerateDatesMas("2001-01-02", "2015-01-01T00:00:00.000Z", masAddress, collection, namespaces, step)
+	res, _ := GenerateDatesMas("2001-01-02", "2015-01-01T00:00:00.000Z", masAddress, collection, namespaces, step, "", false)
 	if len(res) != 0 {
 		t.Errorf("Start date test failed. Expecting empty output, actual: %v", res)
 		return
 	}
 
-	res = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2015-01-01T00:00:00", masAddress, collection, namespaces, step)
+	res, _ = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2015-01-01T00:00:00", masAddress, collection, namespaces, step, "", false)
 	if len(res) != 0 {
 		t.Errorf("End date test failed. Expecting empty output, actual: %v", res)
 		return
 	}
 
-	res = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2015-01-01T00:00:00.000Z", "127.0.0.0", collection, namespaces, step)
+	res, _ = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2015-01-01T00:00:00.000Z", "127.0.0.0", collection, namespaces, step, "", false)
 	if len(res) != 0 {
 		t.Errorf("MAS connection test failed. Expecting empty output, actual: %v", res)
 		return
@@ -41,43 +41,43 @@ func TestGenerateDatesMas(t *testing.T) {
 	masOnline := err == nil
 
 	if masOnline {
-		res = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2015-01-01T00:00:00.000Z", masAddress, "no_collection", namespaces, step)
+		res, _ = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2015-01-01T00:00:00.000Z", masAddress, "no_collection", namespaces, step, "", false)
 		if len(res) != 0 {
 			t.Errorf("Collection test failed. Expecting empty output, actual: %v", res)
 			return
 		}
 
-		res = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2015-01-01T00:00:00.000Z", masAddress, collection, []string{"no_namespace"}, step)
+		res, _ = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2015-01-01T00:00:00.000Z", masAddress, collection, []string{"no_namespace"}, step, "", false)
 		if len(res) != 0 {
 			t.Errorf("Namespace test failed. Expecting empty output, actual: %v", res)
 			return
 		}
 
-		res = GenerateDatesMas("", "2015-01-01T00:00:00.000Z", masAddress, collection, namespaces, step)
+		res, _ = GenerateDatesMas("", "2015-01-01T00:00:00.000Z", masAddress, collection, namespaces, step, "", false)
 		if len(res) == 0 {
 			t.Errorf("Empty start date test failed. Expecting some outputs, but got empty ouputs")
 			return
 		}
 
-		res = GenerateDatesMas("   ", "2015-01-01T00:00:00.000Z", masAddress, collection, namespaces, step)
+		res, _ = GenerateDatesMas("   ", "2015-01-01T00:00:00.000Z", masAddress, collection, namespaces, step, "", false)
 		if len(res) == 0 {
 			t.Errorf("Empty start date test failed. Expecting some outputs, but got empty ouputs")
 			return
 		}
 
-		res = GenerateDatesMas("", "", masAddress, collection, namespaces, step)
+		res, _ = GenerateDatesMas("", "", masAddress, collection, namespaces, step, "", false)
 		if len(res) == 0 {
 			t.Errorf("Empty end date test failed. Expecting some outputs, but got empty ouputs")
 			return
 		}
 
-		res = GenerateDatesMas("", "   ", masAddress, collection, namespaces, step)
+		res, _ = GenerateDatesMas("", "   ", masAddress, collection, namespaces, step, "", false)
 		if len(res) == 0 {
 			t.Errorf("Empty end date test failed. Expecting some outputs, but got empty ouputs")
 			return
 		}
 
-		res = GenerateDatesMas("", "", masAddress, collection, []string{}, step)
+		res, _ = GenerateDatesMas("", "", masAddress, collection, []string{}, step, "", false)
 		if len(res) == 0 {
 			t.Errorf("Empty namespace test failed. Expecting some outputs, but got empty ouputs")
 			return
@@ -92,7 +92,7 @@ func TestGenerateDatesMas(t *testing.T) {
 		}
 
 		step, _ = time.ParseDuration(fmt.Sprintf("%dh", 24*60))
-		res = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2018-01-01T00:00:00.000Z", masAddress, collection, namespaces, step)
+		res, _ = GenerateDatesMas("2015-01-02T00:00:00.000Z", "2018-01-01T00:00:00.000Z", masAddress, collection, namespaces, step, "", false)
 		if len(res) < 2 {
 			t.Errorf("number of timestamps < 2: %v", res)
 			return
@@ -115,28 +115,28 @@ func TestGetLayerDates(t *testing.T) {
 	config := &Config{}
 
 	config.Layers = append(config.Layers, Layer{StartISODate: "", EndISODate: "", TimeGen: "yearly"})
-	config.GetLayerDates(0)
+	config.GetLayerDates(0, false)
 	if len(config.Layers[0].Dates) > 0 {
 		t.Errorf("Invalid date string but got successfully converted: %v\n", config.Layers[0].Dates)
 		return
 	}
 
 	config.Layers[0] = Layer{StartISODate: "2015-01-01T00:00:00.000Z", EndISODate: "", TimeGen: "yearly"}
-	config.GetLayerDates(0)
+	config.GetLayerDates(0, false)
 	if len(config.Layers[0].Dates) > 0 {
 		t.Errorf("Invalid date string but got successfully converted: %v\n", config.Layers[0].Dates)
 		return
 	}
 
 	config.Layers[0] = Layer{StartISODate: "2015-01-01T00:00:00.000Z", EndISODate: "2018-01-01T00:00:00.000Z", TimeGen: "yearly"}
-	config.GetLayerDates(0)
+	config.GetLayerDates(0, false)
 	if len(config.Layers[0].Dates) != 3 {
 		t.Errorf("Failed to generate dates: %v\n", config.Layers[0].Dates)
 		return
 	}
 
 	config.Layers[0] = Layer{StartISODate: "2015-01-01T00:00:00.000Z", EndISODate: "now", TimeGen: "yearly"}
-	config.GetLayerDates(0)
+	config.GetLayerDates(0, false)
 	if len(config.Layers[0].Dates) == 0 {
 		t.Errorf("Failed to parse now() as end date: %#v\n", config.Layers[0])
 		return
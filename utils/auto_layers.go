@@ -0,0 +1,369 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultAutoLayersNameSpace = "auto"
+const DefaultAutoLayersRefreshInterval = 3600
+const DefaultAutoLayersNamePattern = "{collection}_{name}"
+const DefaultAutoLayersTitlePattern = "{collection} {name}"
+
+// AutoLayersConfig configures the layers materialised from MAS. Layers
+// are generated for each variable found under the data sources and are
+// served under their own namespace.
+type AutoLayersConfig struct {
+	NameSpace       string   `json:"namespace"`
+	DataSources     []string `json:"data_sources"`
+	Discover        bool     `json:"discover"`
+	RefreshInterval int      `json:"refresh_interval"`
+	NamePattern     string   `json:"name_pattern"`
+	TitlePattern    string   `json:"title_pattern"`
+	// LayerTemplate holds the default settings of the generated layers
+	LayerTemplate          map[string]interface{} `json:"layer_template"`
+	Palettes               []*Palette             `json:"palettes"`
+	PalettesByStandardName map[string]string      `json:"palettes_by_standard_name"`
+	DefaultPalette         string                 `json:"default_palette"`
+}
+
+func (ac *AutoLayersConfig) setDefaults() {
+	if len(ac.NameSpace) == 0 {
+		ac.NameSpace = DefaultAutoLayersNameSpace
+	}
+	if ac.RefreshInterval <= 0 {
+		ac.RefreshInterval = DefaultAutoLayersRefreshInterval
+	}
+	if len(ac.NamePattern) == 0 {
+		ac.NamePattern = DefaultAutoLayersNamePattern
+	}
+	if len(ac.TitlePattern) == 0 {
+		ac.TitlePattern = DefaultAutoLayersTitlePattern
+	}
+}
+
+// expandPattern substitutes {collection}, {data_source}, {name} and
+// {standard_name} in a name or title pattern.
+func expandPattern(pattern string, dataSource string, layer *Layer) string {
+	standardName := layer.StandardName
+	if len(standardName) == 0 {
+		standardName = layer.Name
+	}
+	r := strings.NewReplacer(
+		"{collection}", path.Base(dataSource),
+		"{data_source}", dataSource,
+		"{name}", layer.Name,
+		"{standard_name}", standardName,
+	)
+	return strings.TrimSpace(r.Replace(pattern))
+}
+
+// findPalette looks a palette up by name, first in the configured
+// palettes then in the builtin ones.
+func (ac *AutoLayersConfig) findPalette(name string, builtin []*Palette) *Palette {
+	if len(name) == 0 {
+		return nil
+	}
+	for _, palettes := range [][]*Palette{ac.Palettes, builtin} {
+		for _, p := range palettes {
+			if strings.ToLower(p.Name) == strings.ToLower(name) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// applyTemplate builds the final layer from the layer template and the
+// layer generated by MAS. The fields generated by MAS take precedence
+// over the template.
+func (ac *AutoLayersConfig) applyTemplate(dataSource string, masLayer *Layer, builtin []*Palette) (map[string]interface{}, error) {
+	layer := make(map[string]interface{})
+	if ac.LayerTemplate != nil {
+		// deep copy as the template is shared by all the layers
+		tmpl, err := json.Marshal(ac.LayerTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(tmpl, &layer); err != nil {
+			return nil, err
+		}
+	}
+
+	generated := map[string]interface{}{
+		"name":           expandPattern(ac.NamePattern, dataSource, masLayer),
+		"title":          expandPattern(ac.TitlePattern, dataSource, masLayer),
+		"data_source":    masLayer.DataSource,
+		"time_generator": masLayer.TimeGen,
+		"rgb_products":   masLayer.RGBProducts,
+	}
+	if len(masLayer.AxesInfo) > 0 {
+		generated["axes"] = masLayer.AxesInfo
+	}
+	if len(masLayer.StandardName) > 0 {
+		generated["standard_name"] = masLayer.StandardName
+	}
+	for k, v := range generated {
+		layer[k] = v
+	}
+
+	// The packed values are decoded and scaled to their valid range unless
+	// the template converts them.
+	if _, found := layer["unit_conversion"]; !found {
+		if uc := masLayer.packedConversion(); uc != nil {
+			layer["unit_conversion"] = uc
+		} else if len(masLayer.Units) > 0 {
+			setLegendUnits(layer, masLayer.Units)
+		}
+		if scaling := masLayer.validRangeScaling(); scaling != nil {
+			_, hasOffset := layer["offset_value"]
+			_, hasClip := layer["clip_value"]
+			_, hasScale := layer["scale_value"]
+			if !hasOffset && !hasClip && !hasScale {
+				for k, v := range scaling {
+					layer[k] = v
+				}
+			}
+		}
+	}
+
+	if _, found := layer["palette"]; !found {
+		paletteName := ac.PalettesByStandardName[masLayer.StandardName]
+		if len(paletteName) == 0 {
+			paletteName = ac.PalettesByStandardName[masLayer.Name]
+		}
+		if len(paletteName) == 0 {
+			paletteName = ac.DefaultPalette
+		}
+		if palette := ac.findPalette(paletteName, builtin); palette != nil {
+			layer["palette"] = palette
+		} else if len(paletteName) > 0 {
+			log.Printf("auto layers: palette %s not found for layer %s", paletteName, generated["name"])
+		}
+	}
+
+	return layer, nil
+}
+
+// packedConversion returns the unit conversion decoding the packed values
+// of a MAS layer to its units, nil if not packed.
+func (layer *Layer) packedConversion() *UnitConversion {
+	uc := &UnitConversion{From: layer.Units, To: layer.Units, Scale: 1}
+	if layer.ScaleFactor != nil {
+		uc.Scale = *layer.ScaleFactor
+	}
+	if layer.AddOffset != nil {
+		uc.Offset = *layer.AddOffset
+	}
+	if uc.Scale == 0 || (uc.Scale == 1 && uc.Offset == 0) {
+		return nil
+	}
+	return uc
+}
+
+// validRangeScaling returns the scaling parameters mapping the valid range
+// of a MAS layer, decoded, to the palette.
+func (layer *Layer) validRangeScaling() map[string]interface{} {
+	if len(layer.ValidRange) != 2 {
+		return nil
+	}
+	lo, hi := layer.ValidRange[0], layer.ValidRange[1]
+	if uc := layer.packedConversion(); uc != nil {
+		lo, hi = uc.ConvertValue(lo), uc.ConvertValue(hi)
+	}
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	if hi-lo <= 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"offset_value": -lo,
+		"clip_value":   hi - lo,
+		"scale_value":  254 / (hi - lo),
+	}
+}
+
+// setLegendUnits sets the units of the legend of a layer unless set by its
+// template.
+func setLegendUnits(layer map[string]interface{}, units string) {
+	legend, found := layer["legend"].(map[string]interface{})
+	if !found {
+		if _, found := layer["legend"]; found {
+			return
+		}
+		legend = make(map[string]interface{})
+		layer["legend"] = legend
+	}
+	if _, found := legend["units"]; !found {
+		legend["units"] = units
+	}
+}
+
+func getMASJSON(masAddress, gpath, queryOp string, result interface{}) error {
+	url := strings.Replace(fmt.Sprintf("http://%s/%s?%s", masAddress, strings.Trim(gpath, "/"), queryOp), " ", "%20", -1)
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("MAS (%s) error: %v,%v", queryOp, url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("MAS (%s) error: %v,%v", queryOp, url, err)
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("MAS (%s) json response error: %v", queryOp, err)
+	}
+	return nil
+}
+
+// autoLayersDataSources returns the collections to generate layers for.
+// With discovery enabled the sub-paths of each data source are added so
+// newly crawled collections are picked up automatically.
+func (ac *AutoLayersConfig) autoLayersDataSources(masAddress string) []string {
+	seen := make(map[string]bool)
+	var dataSources []string
+	add := func(ds string) {
+		ds = "/" + strings.Trim(ds, "/")
+		if !seen[ds] {
+			seen[ds] = true
+			dataSources = append(dataSources, ds)
+		}
+	}
+
+	for _, ds := range ac.DataSources {
+		add(ds)
+		if !ac.Discover {
+			continue
+		}
+
+		var gpathInfo gpathMetadata
+		if err := getMASJSON(masAddress, ds, "list_sub_gpath", &gpathInfo); err != nil {
+			log.Printf("auto layers: %v", err)
+			continue
+		}
+		if len(gpathInfo.Error) > 0 {
+			log.Printf("auto layers: MAS (list_sub_gpath) error for %s: %v", ds, gpathInfo.Error)
+			continue
+		}
+		for _, sub := range gpathInfo.Paths {
+			add(path.Join(ds, sub))
+		}
+	}
+
+	sort.Strings(dataSources)
+	return dataSources
+}
+
+// GenerateAutoLayers materialises the auto layers config of the root
+// config. Data sources failing to generate layers are logged and
+// skipped. Layer names must be unique, later duplicates are dropped.
+func GenerateAutoLayers(rootConfig *Config, builtin []*Palette, verbose bool) (map[string]*Config, error) {
+	ac := *rootConfig.ServiceConfig.AutoLayers
+	ac.setDefaults()
+
+	masAddress := rootConfig.ServiceConfig.MASAddress
+	var layers []map[string]interface{}
+	layerNames := make(map[string]bool)
+	for _, ds := range ac.autoLayersDataSources(masAddress) {
+		masLayers, err := LoadLayersFromMAS(masAddress, strings.Trim(ds, "/"), verbose)
+		if err != nil {
+			log.Printf("auto layers: %s: %v", ds, err)
+			continue
+		}
+
+		for il := range masLayers.Layers {
+			layer, err := ac.applyTemplate(ds, &masLayers.Layers[il], builtin)
+			if err != nil {
+				return nil, err
+			}
+			name := layer["name"].(string)
+			if layerNames[name] {
+				log.Printf("auto layers: duplicated layer name %s from %s, skipped", name, ds)
+				continue
+			}
+			layerNames[name] = true
+			layers = append(layers, layer)
+		}
+	}
+
+	if verbose {
+		log.Printf("auto layers: %d layers generated under namespace %s", len(layers), ac.NameSpace)
+	}
+
+	config := map[string]interface{}{
+		"service_config": ServiceConfig{
+			MASAddress:   rootConfig.ServiceConfig.MASAddress,
+			WorkerNodes:  rootConfig.ServiceConfig.WorkerNodes,
+			OWSHostname:  rootConfig.ServiceConfig.OWSHostname,
+			OWSProtocol:  rootConfig.ServiceConfig.OWSProtocol,
+			TempDir:      rootConfig.ServiceConfig.TempDir,
+			GrpcChecksum: rootConfig.ServiceConfig.GrpcChecksum,
+		},
+		"layers": layers,
+	}
+
+	configStr, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("auto layers config error: %v", err)
+	}
+
+	conf := &Config{}
+	if err := conf.LoadConfigString(configStr, verbose); err != nil {
+		return nil, fmt.Errorf("auto layers config error: %v", err)
+	}
+	if err := conf.postprocessConfig(ac.NameSpace); err != nil {
+		return nil, fmt.Errorf("auto layers config error: %v", err)
+	}
+
+	return map[string]*Config{ac.NameSpace: conf}, nil
+}
+
+var autoLayersNameSpaces = make(map[string]bool)
+
+// WatchAutoLayers periodically regenerates the auto layers and swaps in
+// the new namespace config. The auto layers settings are read from the
+// current root config at each refresh, so they follow config reloads.
+func WatchAutoLayers(infoLog, errLog *log.Logger, configMap *sync.Map, builtin []*Palette, verbose bool) {
+	go func() {
+		for {
+			// Checks whether auto layers are enabled by a config reload
+			interval := 60
+			v, _ := configMap.Load("config")
+			rootConfig, _ := v.(map[string]*Config)["."]
+			if rootConfig != nil && rootConfig.ServiceConfig.AutoLayers != nil {
+				interval = DefaultAutoLayersRefreshInterval
+				if rootConfig.ServiceConfig.AutoLayers.RefreshInterval > 0 {
+					interval = rootConfig.ServiceConfig.AutoLayers.RefreshInterval
+				}
+
+				t0 := time.Now()
+				confMap, err := GenerateAutoLayers(rootConfig, builtin, verbose)
+				if err != nil {
+					errLog.Printf("Error in generating auto layers: %v\n", err)
+				} else {
+					UpdateConfigMap(configMap, func(m map[string]*Config) {
+						for ns, conf := range confMap {
+							m[ns] = conf
+							autoLayersNameSpaces[ns] = true
+						}
+					})
+					if verbose {
+						infoLog.Printf("Auto layers refreshed in %v", time.Since(t0))
+					}
+				}
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+}
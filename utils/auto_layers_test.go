@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestAutoLayersApplyTemplate(t *testing.T) {
+	ac := &AutoLayersConfig{
+		LayerTemplate: map[string]interface{}{
+			"abstract":    "Generated layer",
+			"title":       "overridden",
+			"wms_timeout": 10.0,
+		},
+		Palettes:               []*Palette{{Name: "rain"}},
+		PalettesByStandardName: map[string]string{"precipitation_amount": "rain"},
+		DefaultPalette:         "greys",
+	}
+	ac.setDefaults()
+
+	builtin := []*Palette{{Name: "Greys"}}
+
+	masLayer := &Layer{
+		Name:         "precip",
+		DataSource:   "/g/data/chirps/v2",
+		TimeGen:      "mas",
+		RGBProducts:  []string{"precip"},
+		StandardName: "precipitation_amount",
+	}
+	layer, err := ac.applyTemplate(masLayer.DataSource, masLayer, builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if layer["name"] != "v2_precip" || layer["title"] != "v2 precip" {
+		t.Errorf("unexpected name or title: %v, %v", layer["name"], layer["title"])
+	}
+	if layer["abstract"] != "Generated layer" || layer["wms_timeout"] != 10.0 {
+		t.Errorf("template fields are not applied: %v", layer)
+	}
+	if p, ok := layer["palette"].(*Palette); !ok || p.Name != "rain" {
+		t.Errorf("expected the palette matched by standard_name, got %v", layer["palette"])
+	}
+
+	masLayer = &Layer{Name: "tmax", DataSource: "/g/data/era5", RGBProducts: []string{"tmax"}}
+	layer, err = ac.applyTemplate(masLayer.DataSource, masLayer, builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := layer["palette"].(*Palette); !ok || p.Name != "Greys" {
+		t.Errorf("expected the default builtin palette, got %v", layer["palette"])
+	}
+
+	if _, found := ac.LayerTemplate["name"]; found {
+		t.Errorf("the layer template must not be modified")
+	}
+}
+
+func TestAutoLayersBandValues(t *testing.T) {
+	ac := &AutoLayersConfig{}
+	ac.setDefaults()
+
+	scale, offset := 0.5, 200.0
+	masLayer := &Layer{
+		Name:        "t2m",
+		DataSource:  "/g/data/era5",
+		RGBProducts: []string{"t2m"},
+		Units:       "K",
+		ScaleFactor: &scale,
+		AddOffset:   &offset,
+		ValidRange:  []float64{-100, 100},
+	}
+	layer, err := ac.applyTemplate(masLayer.DataSource, masLayer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc, ok := layer["unit_conversion"].(*UnitConversion)
+	if !ok || uc.Scale != scale || uc.Offset != offset || uc.To != "K" {
+		t.Errorf("expected the unit conversion decoding the packed values, got %v", layer["unit_conversion"])
+	}
+	if layer["offset_value"] != -150.0 || layer["clip_value"] != 100.0 || layer["scale_value"] != 2.54 {
+		t.Errorf("expected the scaling of the decoded valid range, got %v, %v, %v", layer["offset_value"], layer["clip_value"], layer["scale_value"])
+	}
+
+	// The template takes precedence.
+	ac.LayerTemplate = map[string]interface{}{
+		"unit_conversion": map[string]interface{}{"from": "K", "to": "degC"},
+	}
+	masLayer = &Layer{Name: "tmax", DataSource: "/g/data/era5", Units: "K", ValidRange: []float64{200, 330}}
+	layer, err = ac.applyTemplate(masLayer.DataSource, masLayer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := layer["offset_value"]; found {
+		t.Errorf("the valid range of values converted by the template must not be scaled")
+	}
+
+	ac.LayerTemplate = map[string]interface{}{"clip_value": 50.0}
+	layer, err = ac.applyTemplate(masLayer.DataSource, masLayer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := layer["offset_value"]; found || layer["clip_value"] != 50.0 {
+		t.Errorf("the scaling of the template must be kept, got %v", layer)
+	}
+	if legend, ok := layer["legend"].(map[string]interface{}); !ok || legend["units"] != "K" {
+		t.Errorf("expected the legend units of the MAS layer, got %v", layer["legend"])
+	}
+}
@@ -9846,6 +9846,474 @@ func NewBuiltinPalettes() *BuiltinPalettes {
           "A": 255
         }
       ]
+    },
+    {
+      "name": "turbo",
+      "interpolate": true,
+      "colours": [
+        {
+          "R": 35,
+          "G": 23,
+          "B": 27,
+          "A": 255
+        },
+        {
+          "R": 73,
+          "G": 62,
+          "B": 175,
+          "A": 255
+        },
+        {
+          "R": 68,
+          "G": 106,
+          "B": 238,
+          "A": 255
+        },
+        {
+          "R": 50,
+          "G": 149,
+          "B": 247,
+          "A": 255
+        },
+        {
+          "R": 38,
+          "G": 189,
+          "B": 225,
+          "A": 255
+        },
+        {
+          "R": 41,
+          "G": 221,
+          "B": 187,
+          "A": 255
+        },
+        {
+          "R": 64,
+          "G": 243,
+          "B": 146,
+          "A": 255
+        },
+        {
+          "R": 102,
+          "G": 253,
+          "B": 109,
+          "A": 255
+        },
+        {
+          "R": 150,
+          "G": 250,
+          "B": 80,
+          "A": 255
+        },
+        {
+          "R": 198,
+          "G": 235,
+          "B": 59,
+          "A": 255
+        },
+        {
+          "R": 238,
+          "G": 208,
+          "B": 45,
+          "A": 255
+        },
+        {
+          "R": 255,
+          "G": 171,
+          "B": 36,
+          "A": 255
+        },
+        {
+          "R": 255,
+          "G": 128,
+          "B": 29,
+          "A": 255
+        },
+        {
+          "R": 238,
+          "G": 84,
+          "B": 21,
+          "A": 255
+        },
+        {
+          "R": 201,
+          "G": 45,
+          "B": 12,
+          "A": 255
+        },
+        {
+          "R": 161,
+          "G": 18,
+          "B": 2,
+          "A": 255
+        },
+        {
+          "R": 144,
+          "G": 13,
+          "B": 0,
+          "A": 255
+        }
+      ]
+    },
+    {
+      "name": "turbo_r",
+      "interpolate": true,
+      "colours": [
+        {
+          "R": 144,
+          "G": 13,
+          "B": 0,
+          "A": 255
+        },
+        {
+          "R": 161,
+          "G": 18,
+          "B": 2,
+          "A": 255
+        },
+        {
+          "R": 201,
+          "G": 45,
+          "B": 12,
+          "A": 255
+        },
+        {
+          "R": 238,
+          "G": 84,
+          "B": 21,
+          "A": 255
+        },
+        {
+          "R": 255,
+          "G": 128,
+          "B": 29,
+          "A": 255
+        },
+        {
+          "R": 255,
+          "G": 171,
+          "B": 36,
+          "A": 255
+        },
+        {
+          "R": 238,
+          "G": 208,
+          "B": 45,
+          "A": 255
+        },
+        {
+          "R": 198,
+          "G": 235,
+          "B": 59,
+          "A": 255
+        },
+        {
+          "R": 150,
+          "G": 250,
+          "B": 80,
+          "A": 255
+        },
+        {
+          "R": 102,
+          "G": 253,
+          "B": 109,
+          "A": 255
+        },
+        {
+          "R": 64,
+          "G": 243,
+          "B": 146,
+          "A": 255
+        },
+        {
+          "R": 41,
+          "G": 221,
+          "B": 187,
+          "A": 255
+        },
+        {
+          "R": 38,
+          "G": 189,
+          "B": 225,
+          "A": 255
+        },
+        {
+          "R": 50,
+          "G": 149,
+          "B": 247,
+          "A": 255
+        },
+        {
+          "R": 68,
+          "G": 106,
+          "B": 238,
+          "A": 255
+        },
+        {
+          "R": 73,
+          "G": 62,
+          "B": 175,
+          "A": 255
+        },
+        {
+          "R": 35,
+          "G": 23,
+          "B": 27,
+          "A": 255
+        }
+      ]
+    },
+    {
+      "name": "precipitation",
+      "interpolate": true,
+      "colours": [
+        {
+          "R": 255,
+          "G": 255,
+          "B": 255,
+          "A": 255
+        },
+        {
+          "R": 190,
+          "G": 230,
+          "B": 255,
+          "A": 255
+        },
+        {
+          "R": 120,
+          "G": 190,
+          "B": 255,
+          "A": 255
+        },
+        {
+          "R": 40,
+          "G": 130,
+          "B": 240,
+          "A": 255
+        },
+        {
+          "R": 20,
+          "G": 160,
+          "B": 80,
+          "A": 255
+        },
+        {
+          "R": 130,
+          "G": 210,
+          "B": 60,
+          "A": 255
+        },
+        {
+          "R": 250,
+          "G": 230,
+          "B": 50,
+          "A": 255
+        },
+        {
+          "R": 250,
+          "G": 150,
+          "B": 30,
+          "A": 255
+        },
+        {
+          "R": 230,
+          "G": 40,
+          "B": 30,
+          "A": 255
+        },
+        {
+          "R": 160,
+          "G": 20,
+          "B": 120,
+          "A": 255
+        }
+      ]
+    },
+    {
+      "name": "precipitation_r",
+      "interpolate": true,
+      "colours": [
+        {
+          "R": 160,
+          "G": 20,
+          "B": 120,
+          "A": 255
+        },
+        {
+          "R": 230,
+          "G": 40,
+          "B": 30,
+          "A": 255
+        },
+        {
+          "R": 250,
+          "G": 150,
+          "B": 30,
+          "A": 255
+        },
+        {
+          "R": 250,
+          "G": 230,
+          "B": 50,
+          "A": 255
+        },
+        {
+          "R": 130,
+          "G": 210,
+          "B": 60,
+          "A": 255
+        },
+        {
+          "R": 20,
+          "G": 160,
+          "B": 80,
+          "A": 255
+        },
+        {
+          "R": 40,
+          "G": 130,
+          "B": 240,
+          "A": 255
+        },
+        {
+          "R": 120,
+          "G": 190,
+          "B": 255,
+          "A": 255
+        },
+        {
+          "R": 190,
+          "G": 230,
+          "B": 255,
+          "A": 255
+        },
+        {
+          "R": 255,
+          "G": 255,
+          "B": 255,
+          "A": 255
+        }
+      ]
+    },
+    {
+      "name": "anomaly_diverging",
+      "interpolate": true,
+      "colours": [
+        {
+          "R": 140,
+          "G": 81,
+          "B": 10,
+          "A": 255
+        },
+        {
+          "R": 191,
+          "G": 129,
+          "B": 45,
+          "A": 255
+        },
+        {
+          "R": 223,
+          "G": 194,
+          "B": 125,
+          "A": 255
+        },
+        {
+          "R": 246,
+          "G": 232,
+          "B": 195,
+          "A": 255
+        },
+        {
+          "R": 255,
+          "G": 255,
+          "B": 255,
+          "A": 255
+        },
+        {
+          "R": 199,
+          "G": 229,
+          "B": 240,
+          "A": 255
+        },
+        {
+          "R": 146,
+          "G": 197,
+          "B": 222,
+          "A": 255
+        },
+        {
+          "R": 67,
+          "G": 147,
+          "B": 195,
+          "A": 255
+        },
+        {
+          "R": 33,
+          "G": 102,
+          "B": 172,
+          "A": 255
+        }
+      ]
+    },
+    {
+      "name": "anomaly_diverging_r",
+      "interpolate": true,
+      "colours": [
+        {
+          "R": 33,
+          "G": 102,
+          "B": 172,
+          "A": 255
+        },
+        {
+          "R": 67,
+          "G": 147,
+          "B": 195,
+          "A": 255
+        },
+        {
+          "R": 146,
+          "G": 197,
+          "B": 222,
+          "A": 255
+        },
+        {
+          "R": 199,
+          "G": 229,
+          "B": 240,
+          "A": 255
+        },
+        {
+          "R": 255,
+          "G": 255,
+          "B": 255,
+          "A": 255
+        },
+        {
+          "R": 246,
+          "G": 232,
+          "B": 195,
+          "A": 255
+        },
+        {
+          "R": 223,
+          "G": 194,
+          "B": 125,
+          "A": 255
+        },
+        {
+          "R": 191,
+          "G": 129,
+          "B": 45,
+          "A": 255
+        },
+        {
+          "R": 140,
+          "G": 81,
+          "B": 10,
+          "A": 255
+        }
+      ]
     }
   ]
 }`)
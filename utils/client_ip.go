@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseNetworks parses the CIDRs of a config setting.
+func parseNetworks(setting string, cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid network %v: %v", setting, cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func inNetworks(ip string, networks []*net.IPNet) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostIP strips the port of an address, if any.
+func hostIP(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// ClientIP returns the IP address of the client of a request, that of
+// the direct peer unless the peer is one of the trusted proxies. The
+// X-Forwarded-For header of a trusted proxy is read from the right, the
+// client being the first address not of a trusted proxy, as the
+// addresses on its left are set by the client and may be forged.
+func ClientIP(r *http.Request, proxies []*net.IPNet) string {
+	client := hostIP(r.RemoteAddr)
+	if !inNetworks(client, proxies) {
+		return client
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hostIP(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		client = hop
+		if !inNetworks(hop, proxies) {
+			break
+		}
+	}
+	return client
+}
+
+// ClientIP returns the IP address of the client of a request, through
+// the trusted proxies of the service config.
+func (sc *ServiceConfig) ClientIP(r *http.Request) string {
+	return ClientIP(r, sc.trustedProxies)
+}
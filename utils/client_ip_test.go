@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseNetworks("trusted_proxies", []string{"10.0.0.0/24", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		peer string
+		xff  string
+		ip   string
+	}{
+		// The header of the other peers is ignored.
+		{"192.0.2.1:4242", "", "192.0.2.1"},
+		{"192.0.2.1:4242", "10.0.0.1", "192.0.2.1"},
+		{"192.0.2.1:4242", "198.51.100.7", "192.0.2.1"},
+		// That of a trusted proxy is read from the right.
+		{"10.0.0.5:80", "198.51.100.7", "198.51.100.7"},
+		{"10.0.0.5:80", "203.0.113.9, 198.51.100.7", "198.51.100.7"},
+		{"10.0.0.5:80", "203.0.113.9, 198.51.100.7, 10.0.0.6", "198.51.100.7"},
+		{"10.0.0.5:80", "10.0.0.1, 10.0.0.6", "10.0.0.1"},
+		{"10.0.0.5:80", "not-an-ip, 198.51.100.7", "198.51.100.7"},
+		{"10.0.0.5:80", "198.51.100.7, not-an-ip", "10.0.0.5"},
+		{"10.0.0.5:80", "", "10.0.0.5"},
+		{"[fd00::1]:80", "[2001:db8::1]:1234", "2001:db8::1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/ows", nil)
+		r.RemoteAddr = test.peer
+		if len(test.xff) > 0 {
+			r.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if ip := ClientIP(r, proxies); ip != test.ip {
+			t.Errorf("peer %s, X-Forwarded-For %q: client %s instead of %s", test.peer, test.xff, ip, test.ip)
+		}
+	}
+
+	if _, err := parseNetworks("trusted_proxies", []string{"10.0.0.1"}); err == nil {
+		t.Errorf("expected error for a network without prefix length")
+	}
+}
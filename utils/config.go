@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -21,6 +22,10 @@ import (
 
 	goeval "github.com/edisonguo/govaluate"
 	"github.com/edisonguo/jet"
+	"github.com/nci/gsky/diskcache"
+	"github.com/nci/gsky/quota"
+	"github.com/nci/gsky/seeding"
+	"github.com/nci/gsky/slo"
 	pb "github.com/nci/gsky/worker/gdalservice"
 	geojson "github.com/paulmach/go.geojson"
 	"golang.org/x/net/context"
@@ -50,6 +55,9 @@ const DefaultGrpcWpsConcPerNode = 16
 
 const DefaultWmsPolygonShardConcLimit = 2
 const DefaultWcsPolygonShardConcLimit = 2
+const DefaultWpsPolygonShardConcLimit = 4
+
+const DefaultWpsBandBatchSize = 128
 
 const DefaultWmsMaxWidth = 512
 const DefaultWmsMaxHeight = 512
@@ -75,13 +83,37 @@ type ServiceConfig struct {
 	OWSHostname       string `json:"ows_hostname"`
 	OWSProtocol       string `json:"ows_protocol"`
 	NameSpace         string
-	MASAddress        string   `json:"mas_address"`
-	WorkerNodes       []string `json:"worker_nodes"`
-	OWSClusterNodes   []string `json:"ows_cluster_nodes"`
-	TempDir           string   `json:"temp_dir"`
-	MaxGrpcBufferSize int      `json:"max_grpc_buffer_size"`
-	EnableAutoLayers  bool     `json:"enable_auto_layers"`
-	OWSCacheGPath     string   `json:"ows_cache_gpath"`
+	MASAddress        string            `json:"mas_address"`
+	MASReplicas       []string          `json:"mas_replicas"`
+	WorkerNodes       []string          `json:"worker_nodes"`
+	OWSClusterNodes   []string          `json:"ows_cluster_nodes"`
+	TempDir           string            `json:"temp_dir"`
+	MaxGrpcBufferSize int               `json:"max_grpc_buffer_size"`
+	EnableAutoLayers  bool              `json:"enable_auto_layers"`
+	OWSCacheGPath     string            `json:"ows_cache_gpath"`
+	GrpcChecksum      bool              `json:"grpc_checksum"`
+	AutoLayers        *AutoLayersConfig `json:"auto_layers"`
+	VirtualHosts      []*VirtualHost    `json:"virtual_hosts"`
+	CustomCRS         []*CRSDefinition  `json:"custom_crs"`
+	AccessControl     *AccessControl    `json:"access_control"`
+	RateLimits        *RateLimits       `json:"rate_limits"`
+	DataQuotas        *quota.Config     `json:"data_quotas"`
+	DiskCache         *diskcache.Config `json:"disk_cache"`
+	SLO               *slo.Config       `json:"slo"`
+	TileSeeding       []*seeding.Job    `json:"tile_seeding"`
+	SecurityHeaders   *SecurityHeaders  `json:"security_headers"`
+	ServiceMetadata
+	// TrustedProxies are the CIDRs of the reverse proxies in front of
+	// the OWS, whose X-Forwarded-For headers identify the clients of the
+	// rate limits, the data quotas, the usage and the audit trail.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// OWSClusterSecret is the secret the OWS cluster nodes send with the
+	// requests of their tiles to the other nodes, for these requests not
+	// to be counted twice, if their addresses are not those of the
+	// ows_cluster_nodes, e.g. behind a load balancer.
+	OWSClusterSecret string `json:"ows_cluster_secret"`
+
+	trustedProxies []*net.IPNet
 }
 
 type Mask struct {
@@ -173,6 +205,8 @@ type Layer struct {
 	GrpcWpsConcPerNode           int        `json:"grpc_wps_conc_per_node"`
 	WmsPolygonShardConcLimit     int        `json:"wms_polygon_shard_conc_limit"`
 	WcsPolygonShardConcLimit     int        `json:"wcs_polygon_shard_conc_limit"`
+	WpsPolygonShardConcLimit     int        `json:"wps_polygon_shard_conc_limit"`
+	WpsBandBatchSize             int        `json:"wps_band_batch_size"`
 	BandStrides                  int        `json:"band_strides"`
 	WmsMaxWidth                  int        `json:"wms_max_width"`
 	WmsMaxHeight                 int        `json:"wms_max_height"`
@@ -188,6 +222,7 @@ type Layer struct {
 	NoDataLegendPath             string                            `json:"nodata_legend_path"`
 	AxesInfo                     []*LayerAxis                      `json:"axes"`
 	UserSrcSRS                   int                               `json:"src_srs"`
+	NativeCRS                    string                            `json:"native_crs"`
 	UserSrcGeoTransform          int                               `json:"src_geo_transform"`
 	DefaultGeoBbox               []float64                         `json:"default_geo_bbox"`
 	DefaultGeoSize               []int                             `json:"default_geo_size"`
@@ -207,6 +242,22 @@ type Layer struct {
 	RasterYSize                  float64                           `json:"raster_y_size"`
 	WmsBandExpressionCriteria    *BandExpressionComplexityCriteria `json:"wms_band_expr_criteria"`
 	WcsBandExpressionCriteria    *BandExpressionComplexityCriteria `json:"wcs_band_expr_criteria"`
+	StreamingMerge               bool                              `json:"streaming_merge"`
+	WarpBackend                  string                            `json:"warp_backend"`
+	Resampling                   string                            `json:"resampling"`
+	StandardName                 string                            `json:"standard_name"`
+	UnitConversion               *UnitConversion                   `json:"unit_conversion"`
+	Legend                       *LegendConfig                     `json:"legend"`
+	TimeResolution               string                            `json:"time_resolution"`
+	TimeRounding                 string                            `json:"time_rounding"`
+	Deprecation                  *LayerDeprecation                 `json:"deprecation"`
+	// Units, ScaleFactor, AddOffset and ValidRange are those of the bands
+	// of the layers generated by MAS, mapped to the unit conversion and
+	// the scaling of the auto layers.
+	Units       string    `json:"units"`
+	ScaleFactor *float64  `json:"scale_factor"`
+	AddOffset   *float64  `json:"add_offset"`
+	ValidRange  []float64 `json:"valid_range"`
 }
 
 // Process contains all the details that a WPS needs
@@ -279,6 +330,7 @@ type Config struct {
 	Processes     []Process             `json:"processes"`
 	Extensions    []CapabilityExtension `json:"extensions"`
 	WmsClipConfig WmsClipConfig         `json:"wms_clip_config"`
+	Include       []string              `json:"include"`
 }
 
 // ISOFormat is the string used to format Go ISO times
@@ -968,14 +1020,27 @@ func (config *Config) postprocessConfig(ns string) error {
 				config.Layers[i].Styles[j].LegendHeight = DefaultLegendHeight
 			}
 
-			bandExpr, err := ParseBandExpressions(config.Layers[i].Styles[j].RGBProducts)
+			if config.Layers[i].Styles[j].UnitConversion == nil {
+				config.Layers[i].Styles[j].UnitConversion = config.Layers[i].UnitConversion
+			} else if err := config.Layers[i].Styles[j].UnitConversion.resolve(); err != nil {
+				return fmt.Errorf("Layer %v, style %v, unit_conversion error: %v", config.Layers[i].Name, config.Layers[i].Styles[j].Name, err)
+			}
+			unitConversion := config.Layers[i].Styles[j].UnitConversion
+
+			if config.Layers[i].Styles[j].Legend == nil {
+				config.Layers[i].Styles[j].Legend = config.Layers[i].Legend
+			} else if err := config.Layers[i].Styles[j].Legend.validate(); err != nil {
+				return fmt.Errorf("Layer %v, style %v, %v", config.Layers[i].Name, config.Layers[i].Styles[j].Name, err)
+			}
+
+			bandExpr, err := ParseBandExpressions(unitConversion.ConvertBands(config.Layers[i].Styles[j].RGBProducts))
 			if err != nil {
 				return fmt.Errorf("Layer %v, style %v, RGBExpression parsing error: %v", config.Layers[i].Name, config.Layers[i].Styles[j].Name, err)
 			}
 			config.Layers[i].Styles[j].RGBExpressions = bandExpr
 
 			if len(config.Layers[i].Styles[j].FeatureInfoBands) > 0 {
-				featureInfoExpr, err := ParseBandExpressions(config.Layers[i].Styles[j].FeatureInfoBands)
+				featureInfoExpr, err := ParseBandExpressions(unitConversion.ConvertBands(config.Layers[i].Styles[j].FeatureInfoBands))
 				if err != nil {
 					return fmt.Errorf("Layer %v, style %v, FeatureInfoExpression parsing error: %v", config.Layers[i].Name, config.Layers[i].Styles[j].Name, err)
 				}
@@ -1192,6 +1257,9 @@ func (config *Config) Copy(r *http.Request) *Config {
 		OWSProtocol: config.ServiceConfig.OWSProtocol,
 		NameSpace:   config.ServiceConfig.NameSpace,
 		MASAddress:  config.ServiceConfig.MASAddress,
+
+		CustomCRS:       CustomCRSDefinitions(),
+		ServiceMetadata: config.ServiceConfig.ServiceMetadata,
 	}
 
 	hasOWSHostname := len(strings.TrimSpace(config.ServiceConfig.OWSHostname)) > 0
@@ -1215,7 +1283,7 @@ func (config *Config) Copy(r *http.Request) *Config {
 			Title:              layer.Title,
 			Abstract:           layer.Abstract,
 			NameSpace:          layer.NameSpace,
-			OWSHostname:        layer.OWSHostname,
+			OWSHostname:        newConf.ServiceConfig.OWSHostname,
 			OWSProtocol:        newConf.ServiceConfig.OWSProtocol,
 			Styles:             layer.Styles,
 			AxesInfo:           layer.AxesInfo,
@@ -1232,9 +1300,7 @@ func (config *Config) Copy(r *http.Request) *Config {
 			Dates:              layer.Dates,
 			EffectiveStartDate: layer.EffectiveStartDate,
 			EffectiveEndDate:   layer.EffectiveEndDate,
-		}
-		if !hasOWSHostname {
-			newConf.Layers[i].OWSHostname = r.Host
+			Deprecation:        layer.Deprecation,
 		}
 	}
 
@@ -1529,7 +1595,8 @@ func ParseBandExpressions(bands []string) (*BandExpressions, error) {
 
 // LoadConfigFileTemplate parses the config as a Jet
 // template and escapes any GSKY here docs (i.e. $gdoc$)
-// into valid one-line JSON strings.
+// into valid one-line JSON strings. Environment variable and
+// secret references are then substituted by InterpolateConfig.
 func LoadConfigFileTemplate(configFile string) ([]byte, error) {
 	path := filepath.Dir(configFile)
 
@@ -1566,7 +1633,7 @@ func LoadConfigFileTemplate(configFile string) ([]byte, error) {
 	rawStr := resBuf.String()
 	nHereDocs := strings.Count(rawStr, gdocSym)
 	if nHereDocs == 0 {
-		return []byte(rawStr), nil
+		return InterpolateConfig([]byte(rawStr))
 	}
 
 	if nHereDocs%2 != 0 {
@@ -1584,7 +1651,7 @@ func LoadConfigFileTemplate(configFile string) ([]byte, error) {
 		}
 	}
 
-	return []byte(escapedStr), nil
+	return InterpolateConfig([]byte(escapedStr))
 }
 
 func getGrpcPoolSize(config *Config, verbose bool) int {
@@ -1695,15 +1762,27 @@ func (config *Config) LoadConfigFile(configFile string, verbose bool) error {
 		return fmt.Errorf("Error while reading config file: %s. Error: %v", configFile, err)
 	}
 
-	return config.LoadConfigString(cfg, verbose)
+	return config.loadConfig(cfg, filepath.Dir(configFile), verbose)
 }
 
 func (config *Config) LoadConfigString(cfg []byte, verbose bool) error {
+	return config.loadConfig(cfg, "", verbose)
+}
+
+// loadConfig parses the config document. Relative include entries are
+// resolved against baseDir.
+func (config *Config) loadConfig(cfg []byte, baseDir string, verbose bool) error {
 	err := Unmarshal(cfg, config)
 	if err != nil {
 		return fmt.Errorf("Error at JSON parsing config document: %v", err)
 	}
 
+	if len(config.Include) > 0 {
+		if err := config.mergeIncludes(baseDir, verbose); err != nil {
+			return err
+		}
+	}
+
 	if len(config.ServiceConfig.TempDir) > 0 {
 		if verbose {
 			log.Printf("Creating temp directory: %v", config.ServiceConfig.TempDir)
@@ -1721,6 +1800,72 @@ func (config *Config) LoadConfigString(cfg []byte, verbose bool) error {
 
 	config.ServiceConfig.MaxGrpcBufferSize = config.ServiceConfig.MaxGrpcBufferSize * 1024 * 1024
 
+	for _, vh := range config.ServiceConfig.VirtualHosts {
+		if err := vh.validate(); err != nil {
+			return err
+		}
+	}
+
+	if config.ServiceConfig.AccessControl != nil {
+		if err := config.ServiceConfig.AccessControl.validate(); err != nil {
+			return err
+		}
+	}
+
+	proxies, err := parseNetworks("trusted_proxies", config.ServiceConfig.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	config.ServiceConfig.trustedProxies = proxies
+
+	if config.ServiceConfig.RateLimits != nil {
+		if err := config.ServiceConfig.RateLimits.validate(); err != nil {
+			return err
+		}
+	}
+
+	if config.ServiceConfig.DataQuotas != nil {
+		if err := config.ServiceConfig.DataQuotas.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if config.ServiceConfig.DiskCache != nil {
+		if err := config.ServiceConfig.DiskCache.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if config.ServiceConfig.SLO != nil {
+		if err := config.ServiceConfig.SLO.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if config.ServiceConfig.SecurityHeaders != nil {
+		if err := config.ServiceConfig.SecurityHeaders.Validate(); err != nil {
+			return err
+		}
+	}
+
+	seedingJobs := make(map[string]bool)
+	for _, job := range config.ServiceConfig.TileSeeding {
+		if err := job.Validate(); err != nil {
+			return err
+		}
+		if seedingJobs[job.Name] {
+			return fmt.Errorf("duplicate tile seeding job %s", job.Name)
+		}
+		seedingJobs[job.Name] = true
+	}
+
+	for _, crs := range config.ServiceConfig.CustomCRS {
+		if err := crs.validate(); err != nil {
+			return err
+		}
+		RegisterCustomCRS(crs)
+	}
+
 	grpcPoolSize := getGrpcPoolSize(config, verbose)
 	if verbose {
 		log.Printf("average grpc worker pool size: %d", grpcPoolSize)
@@ -1735,18 +1880,61 @@ func (config *Config) LoadConfigString(cfg []byte, verbose bool) error {
 		return path
 	}
 	for i, layer := range config.Layers {
-		bandExpr, err := ParseBandExpressions(layer.RGBProducts)
+		if layer.UnitConversion != nil {
+			if err := layer.UnitConversion.resolve(); err != nil {
+				return fmt.Errorf("Layer %v unit_conversion error: %v", layer.Name, err)
+			}
+		}
+
+		if layer.Legend != nil {
+			if err := layer.Legend.validate(); err != nil {
+				return fmt.Errorf("Layer %v %v", layer.Name, err)
+			}
+		}
+
+		if layer.Deprecation != nil {
+			if err := layer.Deprecation.validate(); err != nil {
+				return fmt.Errorf("Layer %v %v", layer.Name, err)
+			}
+		}
+
+		bandExpr, err := ParseBandExpressions(layer.UnitConversion.ConvertBands(layer.RGBProducts))
 		if err != nil {
 			return fmt.Errorf("Layer %v RGBExpression parsing error: %v", layer.Name, err)
 		}
 		config.Layers[i].RGBExpressions = bandExpr
 
-		featureInfoExpr, err := ParseBandExpressions(layer.FeatureInfoBands)
+		featureInfoExpr, err := ParseBandExpressions(layer.UnitConversion.ConvertBands(layer.FeatureInfoBands))
 		if err != nil {
 			return fmt.Errorf("Layer %v FeatureInfoExpression parsing error: %v", layer.Name, err)
 		}
 		config.Layers[i].FeatureInfoExpressions = featureInfoExpr
 
+		switch layer.WarpBackend {
+		case "", "cpu", "opencl":
+		default:
+			return fmt.Errorf("Layer %v: unsupported warp_backend: %v", layer.Name, layer.WarpBackend)
+		}
+
+		switch layer.Resampling {
+		case "", "near", "bilinear", "cubic", "cubicspline", "lanczos", "average", "mode":
+		default:
+			return fmt.Errorf("Layer %v: unsupported resampling: %v", layer.Name, layer.Resampling)
+		}
+
+		if err := validateTimeResolution(&layer); err != nil {
+			return err
+		}
+
+		if err := resolvePaletteRefs(&config.Layers[i]); err != nil {
+			return err
+		}
+		for j := range config.Layers[i].Styles {
+			if err := resolvePaletteRefs(&config.Layers[i].Styles[j]); err != nil {
+				return err
+			}
+		}
+
 		if len(strings.TrimSpace(config.Layers[i].TimestampsLoadStrategy)) == 0 {
 			config.Layers[i].TimestampsLoadStrategy = "on_demand"
 		}
@@ -1912,6 +2100,14 @@ func (config *Config) LoadConfigString(cfg []byte, verbose bool) error {
 				config.Processes[i].DataSources[ids].GrpcWpsConcPerNode = conc
 			}
 
+			if ds.WpsPolygonShardConcLimit <= 0 {
+				config.Processes[i].DataSources[ids].WpsPolygonShardConcLimit = DefaultWpsPolygonShardConcLimit
+			}
+
+			if ds.WpsBandBatchSize == 0 {
+				config.Processes[i].DataSources[ids].WpsBandBatchSize = DefaultWpsBandBatchSize
+			}
+
 			if len(ds.MetadataURL) > 0 {
 				config.Processes[i].DataSources[ids].MetadataURL = resolveFilePath(config.Processes[i].DataSources[ids].MetadataURL, proc.Identifier)
 			}
@@ -1973,12 +2169,9 @@ func WatchConfig(infoLog, errLog *log.Logger, configMap *sync.Map, verbose bool)
 			select {
 			case <-sighup:
 				infoLog.Println("Caught SIGHUP, reloading config...")
-				confMap, err := LoadAllConfigFiles(EtcDir, verbose)
-				if err != nil {
+				if err := ReloadConfig(configMap, "SIGHUP", verbose); err != nil {
 					errLog.Printf("Error in loading config files: %v\n", err)
-					continue
 				}
-				configMap.Store("config", confMap)
 			}
 		}
 	}()
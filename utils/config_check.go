@@ -0,0 +1,349 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConfigIssue is a problem found in a layer or process definition by
+// CheckConfig.
+type ConfigIssue struct {
+	NameSpace string
+	Object    string
+	Message   string
+}
+
+func (ci ConfigIssue) String() string {
+	ns := ci.NameSpace
+	if len(ns) == 0 {
+		ns = "."
+	}
+	return fmt.Sprintf("namespace %s, %s: %s", ns, ci.Object, ci.Message)
+}
+
+// ConfigChecker validates loaded configs beyond what is required to
+// parse them, i.e. the checks catch configs that load fine but fail at
+// request time.
+type ConfigChecker struct {
+	// CheckMAS enables looking up data sources and band variables in MAS.
+	CheckMAS bool
+	Issues   []ConfigIssue
+
+	client    *http.Client
+	masExtent map[string]map[string]interface{}
+}
+
+func NewConfigChecker(checkMAS bool) *ConfigChecker {
+	return &ConfigChecker{
+		CheckMAS:  checkMAS,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		masExtent: make(map[string]map[string]interface{}),
+	}
+}
+
+// CheckConfig runs all the checks against the given configs and returns
+// the issues found, sorted by namespace.
+func (cc *ConfigChecker) CheckConfig(confMap map[string]*Config) []ConfigIssue {
+	namespaces := make([]string, 0, len(confMap))
+	for ns := range confMap {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	for _, ns := range namespaces {
+		config := confMap[ns]
+		if config == nil {
+			continue
+		}
+
+		cc.checkCustomCRS(ns, config)
+
+		layerNames := make(map[string]bool)
+		for i := range config.Layers {
+			layer := &config.Layers[i]
+			obj := fmt.Sprintf("layer %s", layer.Name)
+			if len(layer.Name) == 0 {
+				cc.addIssue(ns, fmt.Sprintf("layer[%d]", i), "name is empty")
+			} else if layerNames[layer.Name] {
+				cc.addIssue(ns, obj, "duplicated layer name, only the first definition is served")
+			}
+			layerNames[layer.Name] = true
+
+			cc.checkLayer(ns, obj, config, layer)
+
+			styleNames := make(map[string]bool)
+			for j := range layer.Styles {
+				style := &layer.Styles[j]
+				styleObj := fmt.Sprintf("%s, style %s", obj, style.Name)
+				if len(style.Name) == 0 {
+					cc.addIssue(ns, fmt.Sprintf("%s, style[%d]", obj, j), "name is empty")
+				} else if styleNames[style.Name] {
+					cc.addIssue(ns, styleObj, "duplicated style name")
+				}
+				styleNames[style.Name] = true
+				cc.checkPalettes(ns, styleObj, style)
+				cc.checkFile(ns, styleObj, "legend_path", style.LegendPath)
+			}
+
+			for j := range layer.Overviews {
+				ovr := &layer.Overviews[j]
+				cc.checkDataSource(ns, fmt.Sprintf("%s, overview[%d]", obj, j), config, ovr.MASAddress, ovr.DataSource, nil)
+			}
+		}
+
+		for i := range config.Processes {
+			proc := &config.Processes[i]
+			obj := fmt.Sprintf("process %s", proc.Identifier)
+			if len(proc.DataSources) == 0 {
+				cc.addIssue(ns, obj, "no data_sources defined")
+			}
+			for j := range proc.DataSources {
+				ds := &proc.DataSources[j]
+				cc.checkDataSource(ns, fmt.Sprintf("%s, data_sources[%d]", obj, j), config, ds.MASAddress, ds.DataSource, nil)
+			}
+		}
+	}
+
+	return cc.Issues
+}
+
+func (cc *ConfigChecker) addIssue(ns, obj, msg string, args ...interface{}) {
+	cc.Issues = append(cc.Issues, ConfigIssue{NameSpace: ns, Object: obj, Message: fmt.Sprintf(msg, args...)})
+}
+
+func (cc *ConfigChecker) checkLayer(ns, obj string, config *Config, layer *Layer) {
+	if len(layer.InputLayers) == 0 && len(layer.DataSource) == 0 {
+		cc.addIssue(ns, obj, "data_source is empty")
+	}
+
+	if len(layer.InputLayers) == 0 && len(layer.RGBProducts) == 0 {
+		cc.addIssue(ns, obj, "rgb_products is empty, at least one band is required")
+	}
+
+	for _, dt := range []struct {
+		name  string
+		value string
+	}{{"start_isodate", layer.StartISODate}, {"end_isodate", layer.EndISODate}} {
+		switch strings.TrimSpace(strings.ToLower(dt.value)) {
+		case "", "now", "mas":
+			continue
+		}
+		if _, err := time.Parse(ISOFormat, dt.value); err != nil {
+			cc.addIssue(ns, obj, "%s %q is not in the %s format", dt.name, dt.value, ISOFormat)
+		}
+	}
+
+	for _, bbox := range []struct {
+		name  string
+		value []float64
+	}{{"default_geo_bbox", layer.DefaultGeoBbox}, {"spatial_extent", layer.SpatialExtent}} {
+		if len(bbox.value) == 0 {
+			continue
+		}
+		if len(bbox.value) != 4 {
+			cc.addIssue(ns, obj, "%s must have 4 values: xmin, ymin, xmax, ymax", bbox.name)
+			continue
+		}
+		if bbox.value[0] >= bbox.value[2] || bbox.value[1] >= bbox.value[3] {
+			cc.addIssue(ns, obj, "%s %v has xmin >= xmax or ymin >= ymax", bbox.name, bbox.value)
+		}
+	}
+
+	cc.checkCRS(ns, obj, "native_crs", layer.NativeCRS)
+
+	if layer.ColourScale != ColourLinearScale && layer.ColourScale != ColourLogScale {
+		cc.addIssue(ns, obj, "colour_scale must be %d (linear) or %d (log)", ColourLinearScale, ColourLogScale)
+	}
+
+	cc.checkPalettes(ns, obj, layer)
+	cc.checkFile(ns, obj, "legend_path", layer.LegendPath)
+	cc.checkFile(ns, obj, "nodata_legend_path", layer.NoDataLegendPath)
+
+	if len(layer.InputLayers) == 0 {
+		var bandVars []string
+		if layer.RGBExpressions != nil {
+			bandVars = layer.RGBExpressions.VarList
+		}
+		cc.checkDataSource(ns, obj, config, layer.MASAddress, layer.DataSource, bandVars)
+	}
+
+	if layer.Mask != nil && len(layer.Mask.DataSource) > 0 {
+		var maskVars []string
+		if len(layer.Mask.ID) > 0 {
+			maskVars = []string{layer.Mask.ID}
+		}
+		cc.checkDataSource(ns, obj+", mask", config, layer.MASAddress, layer.Mask.DataSource, maskVars)
+	}
+}
+
+// checkCustomCRS verifies the custom_crs definitions of the namespace,
+// which are otherwise only registered by the config loader.
+func (cc *ConfigChecker) checkCustomCRS(ns string, config *Config) {
+	for i, crs := range config.ServiceConfig.CustomCRS {
+		if crs == nil {
+			continue
+		}
+		def := *crs
+		if err := def.validate(); err != nil {
+			cc.addIssue(ns, fmt.Sprintf("custom_crs[%d]", i), "%v", err)
+		}
+	}
+}
+
+// checkCRS verifies that a CRS code refers to a registered custom CRS or
+// is accepted by GDAL.
+func (cc *ConfigChecker) checkCRS(ns, obj, field, code string) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) == 0 {
+		return
+	}
+	if customCRSCodeRE.MatchString(code) && !IsCustomCRS(code) {
+		authority := strings.SplitN(code, ":", 2)[0]
+		if authority != "EPSG" && authority != "CRS" {
+			cc.addIssue(ns, obj, "%s %s is not defined in custom_crs", field, code)
+			return
+		}
+	}
+	if _, err := CRSToWKT(code); err != nil {
+		cc.addIssue(ns, obj, "%s %s: %v", field, code, err)
+	}
+}
+
+func (cc *ConfigChecker) checkPalettes(ns, obj string, layer *Layer) {
+	palettes := layer.Palettes
+	if layer.Palette != nil {
+		palettes = append([]*Palette{layer.Palette}, palettes...)
+	}
+
+	names := make(map[string]bool)
+	for i, palette := range palettes {
+		if palette == nil {
+			continue
+		}
+		paletteObj := fmt.Sprintf("%s, palette %s", obj, palette.Name)
+		if len(palette.Name) == 0 {
+			paletteObj = fmt.Sprintf("%s, palette[%d]", obj, i)
+		} else if names[palette.Name] {
+			cc.addIssue(ns, paletteObj, "duplicated palette name")
+		}
+		names[palette.Name] = true
+
+		if len(palette.Colours) == 0 {
+			cc.addIssue(ns, paletteObj, "colours is empty")
+		} else if palette.Interpolate && len(palette.Colours) < 2 {
+			cc.addIssue(ns, paletteObj, "at least 2 colours are required for interpolation")
+		}
+	}
+}
+
+func (cc *ConfigChecker) checkFile(ns, obj, field, filePath string) {
+	if len(filePath) == 0 {
+		return
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		cc.addIssue(ns, obj, "%s %s is not accessible: %v", field, filePath, err)
+	}
+}
+
+// checkDataSource verifies that MAS has indexed files under the data
+// source and, if given, files for each of the band variables.
+func (cc *ConfigChecker) checkDataSource(ns, obj string, config *Config, masAddress, dataSource string, bandVars []string) {
+	if !cc.CheckMAS || len(dataSource) == 0 {
+		return
+	}
+
+	if len(masAddress) == 0 {
+		masAddress = config.ServiceConfig.MASAddress
+	}
+	if len(masAddress) == 0 {
+		cc.addIssue(ns, obj, "mas_address is empty")
+		return
+	}
+
+	extent, err := cc.queryExtent(masAddress, dataSource, "")
+	if err != nil {
+		cc.addIssue(ns, obj, "data_source %s: %v", dataSource, err)
+		return
+	}
+	if extent == nil {
+		cc.addIssue(ns, obj, "data_source %s has no files indexed in MAS (%s)", dataSource, masAddress)
+		return
+	}
+
+	for _, bandVar := range bandVars {
+		extent, err := cc.queryExtent(masAddress, dataSource, bandVar)
+		if err != nil {
+			cc.addIssue(ns, obj, "data_source %s, band %s: %v", dataSource, bandVar, err)
+			continue
+		}
+		if extent == nil {
+			cc.addIssue(ns, obj, "band %s is not found under data_source %s in MAS (%s)", bandVar, dataSource, masAddress)
+		}
+	}
+}
+
+// queryExtent returns the spatial and temporal extents of the data
+// source in MAS, or nil if there is no file indexed.
+func (cc *ConfigChecker) queryExtent(masAddress, dataSource, namespace string) (map[string]interface{}, error) {
+	query := fmt.Sprintf("http://%s%s?extents&namespace=%s", masAddress, dataSource, url.QueryEscape(namespace))
+	if extent, found := cc.masExtent[query]; found {
+		return extent, nil
+	}
+
+	resp, err := cc.client.Get(query)
+	if err != nil {
+		return nil, fmt.Errorf("MAS http error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("MAS http error: %v", err)
+	}
+
+	var extent map[string]interface{}
+	if err := json.Unmarshal(body, &extent); err != nil {
+		return nil, fmt.Errorf("MAS json response error: %v", err)
+	}
+	if msg, found := extent["error"]; found {
+		return nil, fmt.Errorf("MAS error: %v", msg)
+	}
+	if xmin, found := extent["xmin"]; !found || xmin == nil {
+		extent = nil
+	}
+
+	cc.masExtent[query] = extent
+	return extent, nil
+}
+
+// LoadAllConfigFilesForCheck loads all the config files including the
+// on-demand namespaces which are otherwise only loaded at request time.
+func LoadAllConfigFilesForCheck(searchPath string, verbose bool) (map[string]*Config, error) {
+	confMap, err := LoadAllConfigFiles(searchPath, verbose)
+	if err != nil {
+		return nil, err
+	}
+
+	for ns, config := range confMap {
+		if config != nil {
+			continue
+		}
+		nsConfMap, err := LoadConfigOnDemand(searchPath, ns, verbose)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %s: %v", ns, err)
+		}
+		for k, v := range nsConfMap {
+			if len(strings.TrimSpace(k)) > 0 {
+				confMap[k] = v
+			}
+		}
+	}
+	return confMap, nil
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConfigChecker(t *testing.T) {
+	mas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/g/data/good" && r.FormValue("namespace") != "missing" {
+			fmt.Fprint(w, `{"xmin": 0, "ymin": 0, "xmax": 1, "ymax": 1}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer mas.Close()
+	masAddress := strings.TrimPrefix(mas.URL, "http://")
+
+	confMap := map[string]*Config{
+		".": {
+			ServiceConfig: ServiceConfig{
+				MASAddress: masAddress,
+				CustomCRS:  []*CRSDefinition{{Code: "GSKY:100001", Proj4: "+proj=longlat +datum=WGS84", WKT: "GEOGCS[]"}},
+			},
+			Layers: []Layer{
+				{
+					Name:           "good",
+					DataSource:     "/g/data/good",
+					RGBProducts:    []string{"band1"},
+					RGBExpressions: &BandExpressions{VarList: []string{"band1"}},
+					Palette:        &Palette{Name: "p", Colours: []color.RGBA{{}, {}}},
+					NativeCRS:      "EPSG:4326",
+				},
+				{
+					Name:           "good",
+					DataSource:     "/g/data/bad",
+					RGBProducts:    []string{"band1"},
+					RGBExpressions: &BandExpressions{VarList: []string{"band1"}},
+					StartISODate:   "2020-01-01",
+				},
+				{
+					Name:           "band",
+					DataSource:     "/g/data/good",
+					RGBProducts:    []string{"missing"},
+					RGBExpressions: &BandExpressions{VarList: []string{"missing"}},
+					Palettes:       []*Palette{{Name: "p", Interpolate: true, Colours: []color.RGBA{{}}}},
+					DefaultGeoBbox: []float64{10, 0, 0, 10},
+				},
+				{
+					Name:        "crs",
+					DataSource:  "/g/data/good",
+					RGBProducts: []string{"band1"},
+					NativeCRS:   "EPSG:999999",
+				},
+				{
+					Name:        "custom_crs",
+					DataSource:  "/g/data/good",
+					RGBProducts: []string{"band1"},
+					NativeCRS:   "gsky:100999",
+				},
+			},
+		},
+		"on_demand": nil,
+	}
+
+	issues := NewConfigChecker(true).CheckConfig(confMap)
+	expected := []string{
+		"namespace ., custom_crs[0]: custom CRS GSKY:100001 must be defined by exactly one of proj4, wkt and grid_mapping",
+		"namespace ., layer good: duplicated layer name, only the first definition is served",
+		`namespace ., layer good: start_isodate "2020-01-01" is not in the 2006-01-02T15:04:05.000Z format`,
+		"namespace ., layer good: data_source /g/data/bad has no files indexed in MAS (" + masAddress + ")",
+		"namespace ., layer band: default_geo_bbox [10 0 0 10] has xmin >= xmax or ymin >= ymax",
+		"namespace ., layer band, palette p: at least 2 colours are required for interpolation",
+		"namespace ., layer band: band missing is not found under data_source /g/data/good in MAS (" + masAddress + ")",
+		"namespace ., layer crs: native_crs EPSG:999999: invalid CRS definition: EPSG:999999",
+		"namespace ., layer custom_crs: native_crs GSKY:100999 is not defined in custom_crs",
+	}
+
+	if len(issues) != len(expected) {
+		t.Fatalf("expected %d issues, got %d: %v", len(expected), len(issues), issues)
+	}
+	for i, issue := range issues {
+		if issue.String() != expected[i] {
+			t.Errorf("issue %d: expected %q, got %q", i, expected[i], issue.String())
+		}
+	}
+
+	issues = NewConfigChecker(false).CheckConfig(confMap)
+	if len(issues) != 7 {
+		t.Errorf("expected 7 issues without MAS checks, got %d: %v", len(issues), issues)
+	}
+}
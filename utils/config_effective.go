@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minRedactedSecretLen avoids redacting short secret values which could
+// match unrelated parts of the config.
+const minRedactedSecretLen = 4
+
+// configSecrets maps the values read from ${file://...} and ${VAR}
+// references to their reference so that the secrets can be redacted from
+// config dumps.
+var configSecrets = struct {
+	sync.Mutex
+	refs map[string]string
+}{refs: make(map[string]string)}
+
+// redactedConfigFields are the config fields whose values are secrets
+// whatever their source.
+var redactedConfigFields = map[string]bool{
+	"api_keys":           true,
+	"ows_cluster_secret": true,
+}
+
+const redactedConfigValue = "[redacted]"
+
+func registerConfigSecret(value, ref string) {
+	if len(value) < minRedactedSecretLen {
+		return
+	}
+	configSecrets.Lock()
+	configSecrets.refs[value] = ref
+	configSecrets.Unlock()
+}
+
+// EffectiveConfig is the configuration currently in effect as returned
+// by the effective config admin endpoint.
+type EffectiveConfig struct {
+	Version *ConfigVersion     `json:"version,omitempty"`
+	Configs map[string]*Config `json:"configs"`
+}
+
+// DumpEffectiveConfig encodes the fully resolved configs, i.e. after
+// includes, templating and interpolation, as JSON. Only the given
+// namespace is dumped if namespace is not empty. The values interpolated
+// from secret files and environment variables are replaced by their
+// ${file://...} or ${VAR} reference, and the API keys and the cluster
+// secret by [redacted].
+func DumpEffectiveConfig(confMap map[string]*Config, namespace string) ([]byte, error) {
+	eff := EffectiveConfig{Configs: confMap}
+	if len(namespace) > 0 {
+		conf, found := confMap[namespace]
+		if !found {
+			return nil, fmt.Errorf("namespace %s not found", namespace)
+		}
+		eff.Configs = map[string]*Config{namespace: conf}
+	}
+	if v, found := ConfigVersions.Active(); found {
+		eff.Version = &v
+	}
+
+	data, err := encodeJSON(eff, "")
+	if err != nil {
+		return nil, err
+	}
+
+	configSecrets.Lock()
+	values := make([]string, 0, len(configSecrets.refs))
+	for v := range configSecrets.refs {
+		values = append(values, v)
+	}
+	// longer secrets first in case a secret contains another one
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	refs := make([]string, len(values))
+	for i, v := range values {
+		refs[i] = fmt.Sprintf("${%s}", configSecrets.refs[v])
+	}
+	configSecrets.Unlock()
+
+	data, err = redactConfigJSON(data, func(field, value string) string {
+		if redactedConfigFields[field] && len(value) > 0 {
+			return redactedConfigValue
+		}
+		for i, v := range values {
+			value = strings.Replace(value, v, refs[i], -1)
+		}
+		return value
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type jsonFrame struct {
+	object bool
+	n      int
+	field  string
+}
+
+// redactConfigJSON rewrites the string values of a JSON document through
+// redact, given the name of their field, that of the enclosing field for
+// the elements of an array. The keys, the numbers and the order of the
+// document are kept.
+func redactConfigJSON(data []byte, redact func(field, value string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	var stack []*jsonFrame
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			buf.WriteByte(byte(d))
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		var top *jsonFrame
+		field := ""
+		isKey := false
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+			if top.object && top.n%2 == 0 {
+				isKey = true
+				if top.n > 0 {
+					buf.WriteByte(',')
+				}
+			} else if top.object {
+				buf.WriteByte(':')
+			} else if top.n > 0 {
+				buf.WriteByte(',')
+			}
+			top.n++
+			field = top.field
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			buf.WriteByte(byte(v))
+			stack = append(stack, &jsonFrame{object: v == '{', field: field})
+		case string:
+			if isKey {
+				top.field = v
+			} else {
+				v = redact(field, v)
+			}
+			b, err := encodeJSON(v, "")
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(b)
+		case json.Number:
+			buf.WriteString(v.String())
+		case bool:
+			buf.WriteString(strconv.FormatBool(v))
+		case nil:
+			buf.WriteString("null")
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeJSON(v interface{}, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if len(indent) > 0 {
+		enc.SetIndent("", indent)
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimSuffix(buf.String(), "\n")), nil
+}
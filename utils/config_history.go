@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const DefaultConfigHistorySize = 20
+
+// ConfigVersion is a configuration applied by the server.
+type ConfigVersion struct {
+	Version   int       `json:"version"`
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
+	Author    string    `json:"author"`
+	Source    string    `json:"source"`
+	Active    bool      `json:"active"`
+
+	confMap map[string]*Config
+}
+
+// ConfigHistory keeps the most recently applied configurations in
+// memory so that the server can be rolled back to any of them.
+type ConfigHistory struct {
+	mu          sync.Mutex
+	maxSize     int
+	nextVersion int
+	active      int
+	versions    []*ConfigVersion
+}
+
+// ConfigVersions is the history of the configurations applied by the
+// OWS server.
+var ConfigVersions = NewConfigHistory(DefaultConfigHistorySize)
+
+func NewConfigHistory(maxSize int) *ConfigHistory {
+	return &ConfigHistory{maxSize: maxSize, nextVersion: 1}
+}
+
+// Record adds an applied configuration to the history and marks it as
+// the active one.
+func (h *ConfigHistory) Record(confMap map[string]*Config, hash, author, source string) ConfigVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	v := &ConfigVersion{
+		Version:   h.nextVersion,
+		Hash:      hash,
+		Timestamp: time.Now().UTC(),
+		Author:    author,
+		Source:    source,
+		confMap:   confMap,
+	}
+	h.nextVersion++
+	h.active = v.Version
+
+	h.versions = append(h.versions, v)
+	if h.maxSize > 0 && len(h.versions) > h.maxSize {
+		h.versions = h.versions[len(h.versions)-h.maxSize:]
+	}
+	return h.copyVersion(v)
+}
+
+// List returns the versions in the history, most recent first.
+func (h *ConfigHistory) List() []ConfigVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions := make([]ConfigVersion, 0, len(h.versions))
+	for i := len(h.versions) - 1; i >= 0; i-- {
+		versions = append(versions, h.copyVersion(h.versions[i]))
+	}
+	return versions
+}
+
+// Active returns the version currently in effect.
+func (h *ConfigHistory) Active() (ConfigVersion, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, v := range h.versions {
+		if v.Version == h.active {
+			return h.copyVersion(v), true
+		}
+	}
+	return ConfigVersion{}, false
+}
+
+func (h *ConfigHistory) get(version int) *ConfigVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, v := range h.versions {
+		if v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+func (h *ConfigHistory) copyVersion(v *ConfigVersion) ConfigVersion {
+	res := *v
+	res.Active = v.Version == h.active
+	res.confMap = nil
+	return res
+}
+
+// RecordConfig records confMap loaded from the config files under EtcDir
+// into ConfigVersions. The author is the owner of the most recently
+// modified config file.
+func RecordConfig(confMap map[string]*Config, source string) ConfigVersion {
+	hash, author := configContentInfo(EtcDir)
+	return ConfigVersions.Record(confMap, hash, author, source)
+}
+
+// RollbackConfig makes a previous version of the configuration active
+// again. The rollback is recorded as a new version. The rolled back
+// configuration stays in effect until the next reload of the config
+// files.
+func RollbackConfig(configMap *sync.Map, version int, author string) (ConfigVersion, error) {
+	configMapLock.Lock()
+	defer configMapLock.Unlock()
+
+	v := ConfigVersions.get(version)
+	if v == nil {
+		return ConfigVersion{}, fmt.Errorf("config version %d not found in history", version)
+	}
+
+	confMap := make(map[string]*Config, len(v.confMap))
+	for ns, conf := range v.confMap {
+		confMap[ns] = conf
+	}
+	keepAutoLayersNameSpaces(configMap, confMap)
+	replaceConfigMap(configMap, confMap)
+
+	return ConfigVersions.Record(v.confMap, v.Hash, author, fmt.Sprintf("rollback to version %d", version)), nil
+}
+
+// configContentInfo returns the fingerprint of the files under the
+// search path, that of the config directory watch, and the owner of the
+// most recently modified one.
+func configContentInfo(searchPath string) (string, string) {
+	hash, latest, _ := configFilesInfo(searchPath)
+	if latest == nil {
+		return hash, ""
+	}
+	return hash, fileOwner(latest)
+}
+
+func fileOwner(info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	uid := strconv.Itoa(int(stat.Uid))
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username
+	}
+	return uid
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestConfigHistoryRollback(t *testing.T) {
+	saved := ConfigVersions
+	defer func() { ConfigVersions = saved }()
+	ConfigVersions = NewConfigHistory(2)
+
+	conf1 := map[string]*Config{".": {Layers: []Layer{{Name: "v1"}}}}
+	conf2 := map[string]*Config{".": {Layers: []Layer{{Name: "v2"}}}}
+	conf3 := map[string]*Config{".": {Layers: []Layer{{Name: "v3"}}}}
+
+	ConfigVersions.Record(conf1, "h1", "alice", "startup")
+	ConfigVersions.Record(conf2, "h2", "bob", "SIGHUP")
+	ConfigVersions.Record(conf3, "h3", "bob", "SIGHUP")
+
+	versions := ConfigVersions.List()
+	if len(versions) != 2 || versions[0].Version != 3 || versions[1].Version != 2 {
+		t.Fatalf("unexpected history: %+v", versions)
+	}
+	if !versions[0].Active || versions[1].Active {
+		t.Errorf("expected version 3 to be the only active version: %+v", versions)
+	}
+
+	configMap := &sync.Map{}
+	configMap.Store("config", conf3)
+
+	if _, err := RollbackConfig(configMap, 1, "carol"); err == nil {
+		t.Errorf("expected error rolling back to a version dropped from history")
+	}
+
+	v, err := RollbackConfig(configMap, 2, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != 4 || v.Hash != "h2" || v.Author != "carol" || !v.Active {
+		t.Errorf("unexpected rollback version: %+v", v)
+	}
+
+	cur, _ := configMap.Load("config")
+	if name := cur.(map[string]*Config)["."].Layers[0].Name; name != "v2" {
+		t.Errorf("expected config v2 after rollback, got %s", name)
+	}
+}
+
+func TestConfigContentInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config_history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"layers": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, author := configContentInfo(dir)
+	sum, err := configFingerprint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != sum {
+		t.Errorf("expected the hash of the config directory fingerprint %s, got %s", sum, hash)
+	}
+	if len(author) == 0 {
+		t.Errorf("expected the owner of the config file")
+	}
+	if _, author := configContentInfo(filepath.Join(dir, "missing")); len(author) > 0 {
+		t.Errorf("expected no owner without config files, got %s", author)
+	}
+}
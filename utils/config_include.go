@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ConfigFragment is a partial config holding the layers and processes
+// of a collection. Fragments are merged into the config that includes
+// them so each team can manage its own layer definitions.
+type ConfigFragment struct {
+	Layers    []Layer   `json:"layers"`
+	Processes []Process `json:"processes"`
+}
+
+// resolveIncludes expands the include entries of a config into a sorted
+// list of fragment files. An entry is either a directory, in which case
+// all the *.json files directly under it are included, or a file glob
+// pattern. Relative entries are relative to baseDir.
+func resolveIncludes(baseDir string, includes []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(baseDir, inc)
+		}
+
+		var matches []string
+		if info, err := os.Stat(inc); err == nil && info.IsDir() {
+			matches, err = filepath.Glob(filepath.Join(inc, "*.json"))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			matches, err = filepath.Glob(inc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid include pattern %s: %v", inc, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("include %s matches no files", inc)
+			}
+		}
+
+		sort.Strings(matches)
+		for _, file := range matches {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// mergeIncludes loads the fragments referenced by the config's include
+// entries and appends their layers and processes to the config. Layer
+// and process names must be unique across the config and its fragments.
+func (config *Config) mergeIncludes(baseDir string, verbose bool) error {
+	files, err := resolveIncludes(baseDir, config.Include)
+	if err != nil {
+		return err
+	}
+
+	layerSrc := make(map[string]string)
+	for _, layer := range config.Layers {
+		layerSrc[layer.Name] = "main config"
+	}
+	procSrc := make(map[string]string)
+	for _, proc := range config.Processes {
+		procSrc[proc.Identifier] = "main config"
+	}
+
+	for _, file := range files {
+		if verbose {
+			log.Printf("Loading config fragment: %s", file)
+		}
+
+		data, err := LoadConfigFileTemplate(file)
+		if err != nil {
+			return fmt.Errorf("Error while reading config fragment: %s. Error: %v", file, err)
+		}
+
+		var frag ConfigFragment
+		if err := Unmarshal(data, &frag); err != nil {
+			return fmt.Errorf("Error at JSON parsing config fragment %s: %v", file, err)
+		}
+
+		for _, layer := range frag.Layers {
+			if src, found := layerSrc[layer.Name]; found {
+				return fmt.Errorf("layer %s in %s is already defined in %s", layer.Name, file, src)
+			}
+			layerSrc[layer.Name] = file
+		}
+		for _, proc := range frag.Processes {
+			if src, found := procSrc[proc.Identifier]; found {
+				return fmt.Errorf("process %s in %s is already defined in %s", proc.Identifier, file, src)
+			}
+			procSrc[proc.Identifier] = file
+		}
+
+		config.Layers = append(config.Layers, frag.Layers...)
+		config.Processes = append(config.Processes, frag.Processes...)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeIncludes(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gsky_include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fragDir := filepath.Join(tmpDir, "layers.d")
+	if err := os.Mkdir(fragDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fragments := map[string]string{
+		"b_team.json": `{"layers": [{"name": "b1"}, {"name": "b2"}]}`,
+		"a_team.json": `{"layers": [{"name": "a1"}], "processes": [{"identifier": "drill_a"}]}`,
+		"notes.txt":   `not a fragment`,
+	}
+	for name, content := range fragments {
+		if err := ioutil.WriteFile(filepath.Join(fragDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{Layers: []Layer{{Name: "main"}}, Include: []string{"layers.d"}}
+	if err := config.mergeIncludes(tmpDir, false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"main", "a1", "b1", "b2"}
+	if len(config.Layers) != len(expected) {
+		t.Fatalf("expected %d layers, got %d", len(expected), len(config.Layers))
+	}
+	for i, layer := range config.Layers {
+		if layer.Name != expected[i] {
+			t.Errorf("layer %d: expected %s, got %s", i, expected[i], layer.Name)
+		}
+	}
+	if len(config.Processes) != 1 || config.Processes[0].Identifier != "drill_a" {
+		t.Errorf("unexpected processes: %v", config.Processes)
+	}
+
+	config = &Config{Layers: []Layer{{Name: "b2"}}, Include: []string{filepath.Join(fragDir, "b_*.json")}}
+	if err := config.mergeIncludes(tmpDir, false); err == nil {
+		t.Errorf("expected an error for a duplicated layer name")
+	}
+
+	config = &Config{Include: []string{"missing/*.json"}}
+	if err := config.mergeIncludes(tmpDir, false); err == nil {
+		t.Errorf("expected an error for an include matching no files")
+	}
+}
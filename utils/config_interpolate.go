@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const secretFilePrefix = "file://"
+
+// InterpolateConfig substitutes references of the following forms in a
+// config document:
+//
+//	${VAR}            value of the environment variable VAR
+//	${VAR:-default}   value of VAR, or default if VAR is unset or empty
+//	${file:///path}   content of the file at /path, e.g. a mounted secret
+//
+// $${ produces a literal ${. Substituted values are JSON-escaped since
+// the references are expected inside JSON strings. Referencing an unset
+// variable without a default or an unreadable file is an error.
+func InterpolateConfig(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte("${")) {
+		return data, nil
+	}
+
+	var out bytes.Buffer
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, '$')
+		if idx < 0 {
+			out.Write(data)
+			break
+		}
+		out.Write(data[:idx])
+		data = data[idx:]
+
+		if bytes.HasPrefix(data, []byte("$${")) {
+			out.WriteString("${")
+			data = data[3:]
+			continue
+		}
+
+		if !bytes.HasPrefix(data, []byte("${")) {
+			out.WriteByte('$')
+			data = data[1:]
+			continue
+		}
+
+		end := bytes.IndexByte(data, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed reference: %s", truncateRef(data))
+		}
+
+		ref := string(data[2:end])
+		val, err := resolveConfigRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString(jsonEscape(val))
+		data = data[end+1:]
+	}
+
+	return out.Bytes(), nil
+}
+
+func resolveConfigRef(ref string) (string, error) {
+	if strings.HasPrefix(ref, secretFilePrefix) {
+		fileName := ref[len(secretFilePrefix):]
+		val, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret ${%s}: %v", ref, err)
+		}
+		secret := strings.TrimRight(string(val), "\r\n")
+		registerConfigSecret(secret, ref)
+		return secret, nil
+	}
+
+	name := ref
+	defVal := ""
+	hasDefault := false
+	if idx := strings.Index(ref, ":-"); idx >= 0 {
+		name = ref[:idx]
+		defVal = ref[idx+2:]
+		hasDefault = true
+	}
+
+	if !isEnvVarName(name) {
+		return "", fmt.Errorf("invalid environment variable name in ${%s}", ref)
+	}
+
+	val, found := os.LookupEnv(name)
+	if hasDefault && len(val) == 0 {
+		return defVal, nil
+	}
+	if !found {
+		return "", fmt.Errorf("environment variable %s referenced by ${%s} is not set", name, ref)
+	}
+	registerConfigSecret(val, name)
+	return val, nil
+}
+
+func isEnvVarName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func truncateRef(data []byte) string {
+	if len(data) > 32 {
+		return string(data[:32]) + "..."
+	}
+	return string(data)
+}
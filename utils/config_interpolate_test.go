@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterpolateConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gsky_interpolate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	secretFile := filepath.Join(tmpDir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("pa\"ss\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("GSKY_TEST_MAS", "mas.example.com:8888")
+	os.Setenv("GSKY_TEST_EMPTY", "")
+	defer os.Unsetenv("GSKY_TEST_MAS")
+	defer os.Unsetenv("GSKY_TEST_EMPTY")
+
+	for _, tc := range []struct {
+		in       string
+		expected string
+	}{
+		{`{"mas_address": "${GSKY_TEST_MAS}"}`, `{"mas_address": "mas.example.com:8888"}`},
+		{`{"a": "${GSKY_TEST_UNSET:-x}", "b": "${GSKY_TEST_EMPTY:-y}"}`, `{"a": "x", "b": "y"}`},
+		{`{"password": "${file://` + secretFile + `}"}`, `{"password": "pa\"ss"}`},
+		{`{"literal": "$${GSKY_TEST_MAS}", "price": "$5"}`, `{"literal": "${GSKY_TEST_MAS}", "price": "$5"}`},
+		{`{"empty": "${GSKY_TEST_EMPTY}"}`, `{"empty": ""}`},
+	} {
+		out, err := InterpolateConfig([]byte(tc.in))
+		if err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+			continue
+		}
+		if string(out) != tc.expected {
+			t.Errorf("expected %s, got %s", tc.expected, string(out))
+		}
+	}
+
+	for _, in := range []string{
+		`"${GSKY_TEST_UNSET}"`,
+		`"${GSKY_TEST_MAS"`,
+		`"${1BAD}"`,
+		`"${file://` + filepath.Join(tmpDir, "missing") + `}"`,
+	} {
+		if _, err := InterpolateConfig([]byte(in)); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+}
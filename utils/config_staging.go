@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StagedConfig is a candidate configuration loaded side by side with
+// the live one.
+type StagedConfig struct {
+	Dir       string    `json:"dir"`
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
+	Author    string    `json:"author"`
+	// Issues are the problems found by the validation pass. A
+	// candidate with issues is only promoted if forced.
+	Issues []string `json:"issues"`
+
+	confMap map[string]*Config
+}
+
+// ConfigStaging holds at most one candidate configuration until it is
+// promoted to the live configuration or discarded.
+type ConfigStaging struct {
+	mu     sync.Mutex
+	staged *StagedConfig
+}
+
+// StagedConfigs is the candidate configuration of the OWS server.
+var StagedConfigs = &ConfigStaging{}
+
+// Stage loads and validates the config files under dir as the
+// candidate configuration, replacing any previous candidate. Parse
+// errors fail the staging while the issues found by the config checks
+// are reported in the returned StagedConfig.
+func (s *ConfigStaging) Stage(dir, author string, checkMAS, verbose bool) (StagedConfig, error) {
+	confMap, err := LoadAllConfigFiles(dir, verbose)
+	if err != nil {
+		return StagedConfig{}, fmt.Errorf("failed to load staged config from %s: %v", dir, err)
+	}
+	if len(confMap) == 0 {
+		return StagedConfig{}, fmt.Errorf("no config files found in %s", dir)
+	}
+
+	staged := &StagedConfig{
+		Dir:       dir,
+		Timestamp: time.Now().UTC(),
+		Author:    author,
+		Issues:    []string{},
+		confMap:   confMap,
+	}
+	staged.Hash, _ = configContentInfo(dir)
+	for _, issue := range NewConfigChecker(checkMAS).CheckConfig(confMap) {
+		staged.Issues = append(staged.Issues, issue.String())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.staged = staged
+	return *staged, nil
+}
+
+// Status returns the candidate configuration if any.
+func (s *ConfigStaging) Status() (StagedConfig, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.staged == nil {
+		return StagedConfig{}, false
+	}
+	return *s.staged, true
+}
+
+// ConfigMap returns the configs of the candidate configuration and the
+// directory they were loaded from.
+func (s *ConfigStaging) ConfigMap() (map[string]*Config, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.staged == nil {
+		return nil, "", false
+	}
+	return s.staged.confMap, s.staged.Dir, true
+}
+
+// Discard drops the candidate configuration. It returns false if
+// nothing was staged.
+func (s *ConfigStaging) Discard() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := s.staged != nil
+	s.staged = nil
+	return found
+}
+
+// Promote atomically replaces the live configuration with the candidate
+// one. The config directory of the candidate becomes EtcDir so that
+// later reloads and on-demand namespaces are read from it.
+func (s *ConfigStaging) Promote(configMap *sync.Map, author string, force bool) (ConfigVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.staged == nil {
+		return ConfigVersion{}, fmt.Errorf("no staged config")
+	}
+	if len(s.staged.Issues) > 0 && !force {
+		return ConfigVersion{}, fmt.Errorf("staged config has %d issues, promotion must be forced", len(s.staged.Issues))
+	}
+
+	configMapLock.Lock()
+	defer configMapLock.Unlock()
+
+	confMap := make(map[string]*Config, len(s.staged.confMap))
+	for ns, conf := range s.staged.confMap {
+		confMap[ns] = conf
+	}
+	keepAutoLayersNameSpaces(configMap, confMap)
+	replaceConfigMap(configMap, confMap)
+	EtcDir = s.staged.Dir
+
+	v := ConfigVersions.Record(confMap, s.staged.Hash, author, fmt.Sprintf("promotion of staged config %s", s.staged.Dir))
+	s.staged = nil
+	return v, nil
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConfigStagingPromote(t *testing.T) {
+	savedVersions, savedEtcDir := ConfigVersions, EtcDir
+	defer func() { ConfigVersions, EtcDir = savedVersions, savedEtcDir }()
+	ConfigVersions = NewConfigHistory(5)
+
+	blue := map[string]*Config{".": {Layers: []Layer{{Name: "blue"}}}}
+	green := map[string]*Config{".": {Layers: []Layer{{Name: "green"}}}}
+	configMap := &sync.Map{}
+	configMap.Store("config", blue)
+
+	staging := &ConfigStaging{}
+	if _, err := staging.Promote(configMap, "alice", false); err == nil {
+		t.Errorf("expected error promoting without a staged config")
+	}
+
+	staging.staged = &StagedConfig{Dir: "/etc/gsky/green", Hash: "h2", Issues: []string{"namespace ., layer green: missing data_source"}, confMap: green}
+	if _, err := staging.Promote(configMap, "alice", false); err == nil {
+		t.Errorf("expected error promoting a staged config with issues")
+	}
+	if cur, _ := configMap.Load("config"); cur.(map[string]*Config)["."].Layers[0].Name != "blue" {
+		t.Errorf("live config replaced by a failed promotion")
+	}
+
+	v, err := staging.Promote(configMap, "alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Hash != "h2" || v.Author != "alice" || !v.Active {
+		t.Errorf("unexpected promoted version: %+v", v)
+	}
+	if cur, _ := configMap.Load("config"); cur.(map[string]*Config)["."].Layers[0].Name != "green" {
+		t.Errorf("expected green config after promotion")
+	}
+	if EtcDir != "/etc/gsky/green" {
+		t.Errorf("expected config dir of the promoted config, got %s", EtcDir)
+	}
+	if _, found := staging.Status(); found {
+		t.Errorf("staged config kept after promotion")
+	}
+
+	staging.staged = &StagedConfig{Dir: "/etc/gsky/blue", confMap: blue}
+	if !staging.Discard() || staging.Discard() {
+		t.Errorf("unexpected discard result")
+	}
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nci/gsky/lifecycle"
+)
+
+// configMapLock serialises updates of the config map shared by the OWS
+// handlers. Readers never take the lock: updates are applied to a copy
+// of the map which then replaces the current one, so a request always
+// sees either the old or the new configuration as a whole.
+var configMapLock sync.Mutex
+
+// UpdateConfigMap applies update to a copy of the config map stored
+// under the "config" key and atomically replaces it.
+func UpdateConfigMap(configMap *sync.Map, update func(confMap map[string]*Config)) {
+	configMapLock.Lock()
+	defer configMapLock.Unlock()
+
+	newConfMap := make(map[string]*Config)
+	if v, found := configMap.Load("config"); found {
+		for k, conf := range v.(map[string]*Config) {
+			newConfMap[k] = conf
+		}
+	}
+	update(newConfMap)
+	configMap.Store("config", newConfMap)
+}
+
+// ReloadConfig re-parses all the config files under EtcDir. The current
+// configuration is replaced only if all the files are loaded without
+// errors, otherwise the server keeps serving the previous one. The new
+// configuration is recorded in ConfigVersions with the given source.
+// The server is reported unready until the reload completes.
+func ReloadConfig(configMap *sync.Map, source string, verbose bool) error {
+	defer lifecycle.Default.Hold("config_reload")()
+	configMapLock.Lock()
+	defer configMapLock.Unlock()
+
+	confMap, err := LoadAllConfigFiles(EtcDir, verbose)
+	if err != nil {
+		return err
+	}
+
+	keepAutoLayersNameSpaces(configMap, confMap)
+	replaceConfigMap(configMap, confMap)
+	RecordConfig(confMap, source)
+	return nil
+}
+
+// replaceConfigMap stores a new config map replacing the whole current
+// one. If the worker nodes change, the server is reported unready until
+// the new worker pool is reachable.
+func replaceConfigMap(configMap *sync.Map, confMap map[string]*Config) {
+	oldNodes := rootWorkerNodes(configMap)
+	configMap.Store("config", confMap)
+	if newNodes := rootWorkerNodes(configMap); strings.Join(newNodes, ",") != strings.Join(oldNodes, ",") {
+		go waitWorkerPool(newNodes)
+	}
+}
+
+func rootWorkerNodes(configMap *sync.Map) []string {
+	if v, found := configMap.Load("config"); found {
+		if conf := v.(map[string]*Config)["."]; conf != nil {
+			return conf.ServiceConfig.WorkerNodes
+		}
+	}
+	return nil
+}
+
+// workerPoolTimeout bounds the time the server stays unready waiting for
+// the workers of a new config.
+const workerPoolTimeout = 30 * time.Second
+
+// waitWorkerPool reports the server unready until one of the worker
+// nodes of a new config is reachable, so that a reconfigured worker pool
+// which is still starting doesn't fail the requests.
+func waitWorkerPool(nodes []string) {
+	if len(nodes) == 0 {
+		return
+	}
+	defer lifecycle.Default.Hold("worker_pool")()
+	deadline := time.Now().Add(workerPoolTimeout)
+	for time.Now().Before(deadline) {
+		for _, status := range CheckWorkerStatus(nodes, 2*time.Second) {
+			if status.Reachable {
+				return
+			}
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// keepAutoLayersNameSpaces copies the generated namespaces of the
+// current config map missing from confMap. They are kept until the
+// next auto layers refresh.
+func keepAutoLayersNameSpaces(configMap *sync.Map, confMap map[string]*Config) {
+	if v, found := configMap.Load("config"); found {
+		for ns := range autoLayersNameSpaces {
+			if _, found := confMap[ns]; !found {
+				confMap[ns] = v.(map[string]*Config)[ns]
+			}
+		}
+	}
+}
+
+// WatchConfigDir polls the config directories every interval and
+// reloads the configuration whenever a file under them is added,
+// removed or modified.
+func WatchConfigDir(infoLog, errLog *log.Logger, configMap *sync.Map, interval time.Duration, verbose bool) {
+	go func() {
+		lastSum, err := configFingerprint(EtcDir)
+		if err != nil {
+			errLog.Printf("Error in scanning config directory: %v\n", err)
+		}
+
+		for range time.Tick(interval) {
+			sum, err := configFingerprint(EtcDir)
+			if err != nil {
+				errLog.Printf("Error in scanning config directory: %v\n", err)
+				continue
+			}
+			if sum == lastSum {
+				continue
+			}
+
+			infoLog.Println("Config directory changed, reloading config...")
+			if err := ReloadConfig(configMap, "config directory watch", verbose); err != nil {
+				errLog.Printf("Error in loading config files: %v\n", err)
+			}
+			// A failed reload isn't retried until the files change again
+			lastSum = sum
+		}
+	}()
+}
+
+// configFingerprint returns a digest of the names, sizes and modification
+// times of all the files under the search path.
+func configFingerprint(searchPath string) (string, error) {
+	sum, _, err := configFilesInfo(searchPath)
+	return sum, err
+}
+
+// configFilesInfo returns the fingerprint of the files under the search
+// path and the file info of the most recently modified one, nil if none.
+func configFilesInfo(searchPath string) (string, os.FileInfo, error) {
+	h := sha1.New()
+	var latest os.FileInfo
+	for _, rootDir := range strings.Split(searchPath, ":") {
+		rootDir = strings.TrimSpace(rootDir)
+		if len(rootDir) == 0 {
+			continue
+		}
+		if _, err := os.Stat(rootDir); err != nil {
+			continue
+		}
+
+		err := symWalk(rootDir, rootDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			info, err = os.Stat(path)
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00%d\n", filepath.Clean(path), info.Size(), info.ModTime().UnixNano())
+			if latest == nil || info.ModTime().After(latest.ModTime()) {
+				latest = info
+			}
+			return nil
+		})
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), latest, nil
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfigFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, []byte(`{"layers": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := func() string {
+		sum, err := configFingerprint(dir + ":" + filepath.Join(dir, "missing"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+	sum := fingerprint()
+	if fingerprint() != sum {
+		t.Fatalf("expected the fingerprint of the files unchanged to be the same")
+	}
+
+	changes := []struct {
+		name   string
+		change func() error
+	}{
+		{"add", func() error {
+			os.MkdirAll(filepath.Join(dir, "chirps"), 0755)
+			return ioutil.WriteFile(filepath.Join(dir, "chirps", "config.json"), []byte(`{"layers": []}`), 0644)
+		}},
+		{"edit", func() error {
+			return ioutil.WriteFile(file, []byte(`{"layers": [{}]}`), 0644)
+		}},
+		{"touch", func() error {
+			mtime := time.Now().Add(time.Hour)
+			return os.Chtimes(file, mtime, mtime)
+		}},
+		{"delete", func() error {
+			return os.Remove(filepath.Join(dir, "chirps", "config.json"))
+		}},
+	}
+	for _, c := range changes {
+		if err := c.change(); err != nil {
+			t.Fatal(err)
+		}
+		newSum := fingerprint()
+		if newSum == sum {
+			t.Errorf("%s: expected the fingerprint to change", c.name)
+		}
+		sum = newSum
+	}
+}
+
+func TestReloadConfigInvalid(t *testing.T) {
+	savedVersions, savedEtcDir := ConfigVersions, EtcDir
+	defer func() { ConfigVersions, EtcDir = savedVersions, savedEtcDir }()
+	ConfigVersions = NewConfigHistory(5)
+
+	dir, err := ioutil.TempDir("", "config_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"layers": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	EtcDir = dir
+
+	current := map[string]*Config{".": {Layers: []Layer{{Name: "current"}}}}
+	configMap := &sync.Map{}
+	configMap.Store("config", current)
+
+	if err := ReloadConfig(configMap, "config directory watch", false); err == nil {
+		t.Fatalf("expected an error reloading an invalid config")
+	}
+	v, _ := configMap.Load("config")
+	if confMap := v.(map[string]*Config); len(confMap) != 1 || confMap["."] != current["."] {
+		t.Errorf("expected the current config to be kept, got %v", confMap)
+	}
+	if versions := ConfigVersions.List(); len(versions) != 0 {
+		t.Errorf("expected no config version recorded, got %+v", versions)
+	}
+}
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CF grid mappings of the parametric custom CRS definitions
+const (
+	GridMappingRotatedPole = "rotated_latitude_longitude"
+	GridMappingLCC         = "lambert_conformal_conic"
+)
+
+var customCRSCodeRE = regexp.MustCompile(`^[A-Z]+:[0-9]+$`)
+
+// CRSDefinition defines a coordinate reference system not representable
+// by an EPSG code, such as the rotated pole and Lambert conformal grids
+// of regional climate models. The CRS is given by either a PROJ string,
+// a WKT string or the CF grid mapping attributes.
+type CRSDefinition struct {
+	// Code is the identifier of the CRS in the requests and
+	// capabilities, e.g. GSKY:100001
+	Code  string `json:"code"`
+	Title string `json:"title"`
+	Proj4 string `json:"proj4"`
+	WKT   string `json:"wkt"`
+
+	GridMapping                string    `json:"grid_mapping"`
+	GridNorthPoleLatitude      float64   `json:"grid_north_pole_latitude"`
+	GridNorthPoleLongitude     float64   `json:"grid_north_pole_longitude"`
+	NorthPoleGridLongitude     float64   `json:"north_pole_grid_longitude"`
+	StandardParallel           []float64 `json:"standard_parallel"`
+	LongitudeOfCentralMeridian float64   `json:"longitude_of_central_meridian"`
+	LatitudeOfProjectionOrigin float64   `json:"latitude_of_projection_origin"`
+	FalseEasting               float64   `json:"false_easting"`
+	FalseNorthing              float64   `json:"false_northing"`
+	// EarthRadius is the radius of a spherical earth in metres. The
+	// WGS84 datum is used if not set.
+	EarthRadius float64 `json:"earth_radius"`
+
+	// definition is the WKT of the CRS as resolved by GDAL
+	definition string
+}
+
+func formatProjParam(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func (crs *CRSDefinition) earthShape() string {
+	if crs.EarthRadius > 0 {
+		r := formatProjParam(crs.EarthRadius)
+		return fmt.Sprintf("+a=%s +b=%s", r, r)
+	}
+	return "+datum=WGS84"
+}
+
+// ProjString returns the PROJ string of the CRS or an empty string if
+// the CRS is defined by WKT.
+func (crs *CRSDefinition) ProjString() string {
+	switch crs.GridMapping {
+	case GridMappingRotatedPole:
+		// rotated coordinates are in degrees as with the netCDF
+		// driver of GDAL
+		return fmt.Sprintf("+proj=ob_tran +o_proj=longlat +o_lat_p=%s +o_lon_p=%s +lon_0=%s %s +to_meter=0.0174532925199433 +wktext +no_defs",
+			formatProjParam(crs.GridNorthPoleLatitude),
+			formatProjParam(crs.NorthPoleGridLongitude),
+			formatProjParam(180+crs.GridNorthPoleLongitude),
+			crs.earthShape())
+	case GridMappingLCC:
+		lat1 := crs.StandardParallel[0]
+		lat2 := lat1
+		if len(crs.StandardParallel) > 1 {
+			lat2 = crs.StandardParallel[1]
+		}
+		return fmt.Sprintf("+proj=lcc +lat_1=%s +lat_2=%s +lat_0=%s +lon_0=%s +x_0=%s +y_0=%s %s +units=m +no_defs",
+			formatProjParam(lat1),
+			formatProjParam(lat2),
+			formatProjParam(crs.LatitudeOfProjectionOrigin),
+			formatProjParam(crs.LongitudeOfCentralMeridian),
+			formatProjParam(crs.FalseEasting),
+			formatProjParam(crs.FalseNorthing),
+			crs.earthShape())
+	}
+	return strings.TrimSpace(crs.Proj4)
+}
+
+func (crs *CRSDefinition) validate() error {
+	crs.Code = strings.ToUpper(strings.TrimSpace(crs.Code))
+	if !customCRSCodeRE.MatchString(crs.Code) {
+		return fmt.Errorf("custom CRS code must be of the form AUTHORITY:NUMBER: %v", crs.Code)
+	}
+	authority := strings.SplitN(crs.Code, ":", 2)[0]
+	if authority == "EPSG" || authority == "CRS" {
+		return fmt.Errorf("custom CRS %v must not use the %v authority", crs.Code, authority)
+	}
+
+	nDefs := 0
+	for _, def := range []string{crs.Proj4, crs.WKT, crs.GridMapping} {
+		if len(strings.TrimSpace(def)) > 0 {
+			nDefs++
+		}
+	}
+	if nDefs != 1 {
+		return fmt.Errorf("custom CRS %v must be defined by exactly one of proj4, wkt and grid_mapping", crs.Code)
+	}
+
+	switch crs.GridMapping {
+	case "", GridMappingRotatedPole:
+	case GridMappingLCC:
+		if len(crs.StandardParallel) < 1 || len(crs.StandardParallel) > 2 {
+			return fmt.Errorf("custom CRS %v: lambert_conformal_conic requires one or two standard_parallel values", crs.Code)
+		}
+	default:
+		return fmt.Errorf("custom CRS %v: unsupported grid_mapping: %v", crs.Code, crs.GridMapping)
+	}
+
+	def := strings.TrimSpace(crs.WKT)
+	if len(def) == 0 {
+		def = crs.ProjString()
+	}
+	wkt, err := crsToWKT(def)
+	if err != nil {
+		return fmt.Errorf("custom CRS %v: %v", crs.Code, err)
+	}
+	crs.definition = wkt
+	return nil
+}
+
+var customCRSMap = struct {
+	sync.RWMutex
+	defs map[string]*CRSDefinition
+}{defs: make(map[string]*CRSDefinition)}
+
+// RegisterCustomCRS makes the custom CRS available to all the
+// namespaces. A CRS of the same code registered earlier is replaced.
+func RegisterCustomCRS(crs *CRSDefinition) {
+	customCRSMap.Lock()
+	defer customCRSMap.Unlock()
+	customCRSMap.defs[crs.Code] = crs
+}
+
+func lookupCustomCRS(code string) (*CRSDefinition, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	customCRSMap.RLock()
+	defer customCRSMap.RUnlock()
+	crs, found := customCRSMap.defs[code]
+	return crs, found
+}
+
+// IsCustomCRS reports whether code refers to a registered custom CRS.
+func IsCustomCRS(code string) bool {
+	_, found := lookupCustomCRS(code)
+	return found
+}
+
+// ResolveCRS returns the WKT of a custom CRS for GDAL. Any other CRS
+// is returned as is.
+func ResolveCRS(code string) string {
+	if crs, found := lookupCustomCRS(code); found {
+		return crs.definition
+	}
+	return code
+}
+
+// CustomCRSDefinitions returns the registered custom CRS sorted by code.
+func CustomCRSDefinitions() []*CRSDefinition {
+	customCRSMap.RLock()
+	defs := make([]*CRSDefinition, 0, len(customCRSMap.defs))
+	for _, crs := range customCRSMap.defs {
+		defs = append(defs, crs)
+	}
+	customCRSMap.RUnlock()
+
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Code < defs[j].Code })
+	return defs
+}
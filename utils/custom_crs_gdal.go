@@ -0,0 +1,50 @@
+package utils
+
+// #include <stdlib.h>
+// #include "ogr_srs_api.h"
+// #cgo pkg-config: gdal
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// CRSToWKT returns the WKT of a custom CRS or of any CRS accepted by
+// GDAL.
+func CRSToWKT(crs string) (string, error) {
+	return crsToWKT(ResolveCRS(strings.TrimSpace(crs)))
+}
+
+// NativeCRSWKT returns the WKT of the native_crs of the layer or an
+// empty string if the CRS of the data files is to be used.
+func (layer *Layer) NativeCRSWKT() (string, error) {
+	if len(strings.TrimSpace(layer.NativeCRS)) == 0 {
+		return "", nil
+	}
+	wkt, err := CRSToWKT(layer.NativeCRS)
+	if err != nil {
+		return "", fmt.Errorf("Layer %v native_crs: %v", layer.Name, err)
+	}
+	return wkt, nil
+}
+
+// crsToWKT converts a user CRS definition accepted by GDAL to WKT.
+func crsToWKT(def string) (string, error) {
+	defC := C.CString(def)
+	defer C.free(unsafe.Pointer(defC))
+
+	hSRS := C.OSRNewSpatialReference(nil)
+	defer C.OSRDestroySpatialReference(hSRS)
+	if C.OSRSetFromUserInput(hSRS, defC) != C.OGRERR_NONE {
+		return "", fmt.Errorf("invalid CRS definition: %v", def)
+	}
+
+	var wktC *C.char
+	if C.OSRExportToWkt(hSRS, &wktC) != C.OGRERR_NONE {
+		return "", fmt.Errorf("failed to export CRS to WKT: %v", def)
+	}
+	defer C.free(unsafe.Pointer(wktC))
+	return C.GoString(wktC), nil
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestCustomCRSProjString(t *testing.T) {
+	rotated := &CRSDefinition{
+		Code:                   "GSKY:100001",
+		GridMapping:            GridMappingRotatedPole,
+		GridNorthPoleLatitude:  39.25,
+		GridNorthPoleLongitude: -162,
+		EarthRadius:            6371229,
+	}
+	expected := "+proj=ob_tran +o_proj=longlat +o_lat_p=39.25 +o_lon_p=0 +lon_0=18 +a=6371229 +b=6371229 +to_meter=0.0174532925199433 +wktext +no_defs"
+	if proj := rotated.ProjString(); proj != expected {
+		t.Errorf("expected %v, got %v", expected, proj)
+	}
+
+	lcc := &CRSDefinition{
+		Code:                       "GSKY:100002",
+		GridMapping:                GridMappingLCC,
+		StandardParallel:           []float64{-10},
+		LongitudeOfCentralMeridian: 35,
+		LatitudeOfProjectionOrigin: -10,
+	}
+	expected = "+proj=lcc +lat_1=-10 +lat_2=-10 +lat_0=-10 +lon_0=35 +x_0=0 +y_0=0 +datum=WGS84 +units=m +no_defs"
+	if proj := lcc.ProjString(); proj != expected {
+		t.Errorf("expected %v, got %v", expected, proj)
+	}
+}
+
+func TestCustomCRSValidate(t *testing.T) {
+	invalid := []*CRSDefinition{
+		{Code: "rotated", Proj4: "+proj=longlat"},
+		{Code: "EPSG:900001", Proj4: "+proj=longlat"},
+		{Code: "GSKY:1"},
+		{Code: "GSKY:1", Proj4: "+proj=longlat", GridMapping: GridMappingRotatedPole},
+		{Code: "GSKY:1", GridMapping: GridMappingLCC},
+		{Code: "GSKY:1", GridMapping: "polar_stereographic"},
+	}
+	for _, crs := range invalid {
+		if err := crs.validate(); err == nil {
+			t.Errorf("expected error for %+v", crs)
+		}
+	}
+}
+
+func TestResolveCRS(t *testing.T) {
+	RegisterCustomCRS(&CRSDefinition{Code: "TEST:1", definition: "PROJCS[test]"})
+	if !IsCustomCRS("test:1") || ResolveCRS("TEST:1") != "PROJCS[test]" {
+		t.Errorf("custom CRS not resolved")
+	}
+	if IsCustomCRS("EPSG:4326") || ResolveCRS("EPSG:4326") != "EPSG:4326" {
+		t.Errorf("EPSG CRS resolved as custom CRS")
+	}
+
+	found := false
+	for _, crs := range CustomCRSDefinitions() {
+		found = found || crs.Code == "TEST:1"
+	}
+	if !found {
+		t.Errorf("custom CRS not listed")
+	}
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var deprecationDateFormats = []string{"2006-01-02", time.RFC3339}
+
+// LayerDeprecation marks a layer as deprecated. The layer is served as
+// usual and the notice is advertised in the capabilities and the
+// response headers of the layer.
+type LayerDeprecation struct {
+	// Since is the date the layer was deprecated
+	Since string `json:"since"`
+	// Sunset is the date the layer is to be retired
+	Sunset string `json:"sunset"`
+	// ReplacedBy is the name of the layer replacing the deprecated one
+	ReplacedBy string `json:"replaced_by"`
+	// Link is the URL of the documentation of the deprecation
+	Link    string `json:"link"`
+	Message string `json:"message"`
+
+	sinceTime  time.Time
+	sunsetTime time.Time
+}
+
+func parseDeprecationDate(value string) (time.Time, error) {
+	var err error
+	for _, format := range deprecationDateFormats {
+		var t time.Time
+		t, err = time.Parse(format, strings.TrimSpace(value))
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func (ld *LayerDeprecation) validate() error {
+	if len(ld.Since) > 0 {
+		t, err := parseDeprecationDate(ld.Since)
+		if err != nil {
+			return fmt.Errorf("invalid deprecation since date: %v", ld.Since)
+		}
+		ld.sinceTime = t
+	}
+	if len(ld.Sunset) > 0 {
+		t, err := parseDeprecationDate(ld.Sunset)
+		if err != nil {
+			return fmt.Errorf("invalid deprecation sunset date: %v", ld.Sunset)
+		}
+		ld.sunsetTime = t
+	}
+	if strings.ContainsAny(ld.Link, "<> \t\r\n") {
+		return fmt.Errorf("invalid deprecation link: %v", ld.Link)
+	}
+	if !ld.sinceTime.IsZero() && !ld.sunsetTime.IsZero() && ld.sunsetTime.Before(ld.sinceTime) {
+		return fmt.Errorf("deprecation sunset date is before the since date")
+	}
+	return nil
+}
+
+// Notice returns the human readable deprecation notice appended to the
+// abstract of the layer.
+func (ld *LayerDeprecation) Notice() string {
+	if ld == nil {
+		return ""
+	}
+	notice := "DEPRECATED."
+	if len(ld.Message) > 0 {
+		notice += " " + ld.Message
+	}
+	if !ld.sunsetTime.IsZero() {
+		notice += fmt.Sprintf(" This layer will be retired on %s.", ld.sunsetTime.Format("2006-01-02"))
+	}
+	if len(ld.ReplacedBy) > 0 {
+		notice += fmt.Sprintf(" Use %s instead.", ld.ReplacedBy)
+	}
+	return notice
+}
+
+// SetHeaders sets the Deprecation (RFC 9745) and Sunset (RFC 8594)
+// response headers of the layer.
+func (ld *LayerDeprecation) SetHeaders(header http.Header) {
+	if ld == nil {
+		return
+	}
+	if !ld.sinceTime.IsZero() {
+		header.Set("Deprecation", "@"+strconv.FormatInt(ld.sinceTime.Unix(), 10))
+	} else {
+		header.Set("Deprecation", "true")
+	}
+	if !ld.sunsetTime.IsZero() {
+		header.Set("Sunset", ld.sunsetTime.Format(http.TimeFormat))
+	}
+	if len(ld.Link) > 0 {
+		header.Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, ld.Link))
+	}
+	if len(ld.ReplacedBy) > 0 {
+		header.Set("X-Gsky-Replaced-By", ld.ReplacedBy)
+	}
+}
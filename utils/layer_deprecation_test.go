@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLayerDeprecation(t *testing.T) {
+	ld := &LayerDeprecation{
+		Since:      "2024-01-01",
+		Sunset:     "2024-06-30T00:00:00Z",
+		ReplacedBy: "chirps_v3",
+		Link:       "https://example.org/notices/chirps_v2",
+		Message:    "CHIRPS v2 is superseded by v3.",
+	}
+	if err := ld.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "DEPRECATED. CHIRPS v2 is superseded by v3. This layer will be retired on 2024-06-30. Use chirps_v3 instead."
+	if notice := ld.Notice(); notice != expected {
+		t.Errorf("expected notice %q, got %q", expected, notice)
+	}
+
+	header := http.Header{}
+	ld.SetHeaders(header)
+	headers := map[string]string{
+		"Deprecation":        "@1704067200",
+		"Sunset":             "Sun, 30 Jun 2024 00:00:00 GMT",
+		"Link":               `<https://example.org/notices/chirps_v2>; rel="deprecation"`,
+		"X-Gsky-Replaced-By": "chirps_v3",
+	}
+	for k, v := range headers {
+		if header.Get(k) != v {
+			t.Errorf("header %v: expected %q, got %q", k, v, header.Get(k))
+		}
+	}
+
+	header = http.Header{}
+	var notDeprecated *LayerDeprecation
+	notDeprecated.SetHeaders(header)
+	if len(header) != 0 || len(notDeprecated.Notice()) != 0 {
+		t.Errorf("deprecation notice for a layer not deprecated")
+	}
+
+	invalid := []*LayerDeprecation{
+		{Sunset: "30/06/2024"},
+		{Since: "2024-06-30", Sunset: "2024-01-01"},
+		{Link: "https://example.org/a b"},
+	}
+	for _, ld := range invalid {
+		if err := ld.validate(); err == nil {
+			t.Errorf("expected error for %+v", ld)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const DefaultLegendTicks = 5
+
+// LegendConfig configures the text of the legend of a layer style and
+// the formatting of the values reported by GetLegendGraphic and
+// GetFeatureInfo.
+type LegendConfig struct {
+	Title string `json:"title"`
+	// Units overrides the display units of the layer
+	Units string `json:"units"`
+	Ticks int    `json:"ticks"`
+	// Decimals is the number of digits after the decimal point. The
+	// shortest representation is used if not set.
+	Decimals   *int `json:"decimals"`
+	Scientific bool `json:"scientific"`
+}
+
+func (lc *LegendConfig) validate() error {
+	if lc.Ticks < 0 {
+		return fmt.Errorf("legend ticks must not be negative")
+	}
+	if lc.Decimals != nil && (*lc.Decimals < 0 || *lc.Decimals > 20) {
+		return fmt.Errorf("legend decimals must be between 0 and 20")
+	}
+	return nil
+}
+
+// HasNumberFormat reports whether the values are to be formatted.
+func (lc *LegendConfig) HasNumberFormat() bool {
+	return lc != nil && (lc.Decimals != nil || lc.Scientific)
+}
+
+// FormatValue formats a value as configured. The result is a valid JSON
+// number.
+func (lc *LegendConfig) FormatValue(value float64) string {
+	fmtChar := byte('f')
+	if lc != nil && lc.Scientific {
+		fmtChar = 'e'
+	}
+	prec := -1
+	if lc != nil && lc.Decimals != nil {
+		prec = *lc.Decimals
+	}
+	return strconv.FormatFloat(value, fmtChar, prec, 64)
+}
+
+// LegendTick is a labelled value of a legend.
+type LegendTick struct {
+	Value float64 `json:"value"`
+	Label string  `json:"label"`
+}
+
+// LegendInfo describes the colour mapping of a layer style. It is
+// returned by GetLegendGraphic for FORMAT=application/json so that
+// clients can render their own legends.
+type LegendInfo struct {
+	Layer       string       `json:"layer"`
+	Style       string       `json:"style,omitempty"`
+	Title       string       `json:"title"`
+	Units       string       `json:"units,omitempty"`
+	Min         *float64     `json:"min,omitempty"`
+	Max         *float64     `json:"max,omitempty"`
+	ColourScale string       `json:"colour_scale"`
+	Interpolate bool         `json:"interpolate"`
+	Colours     []string     `json:"colours"`
+	Ticks       []LegendTick `json:"ticks,omitempty"`
+}
+
+// NewLegendInfo builds the legend of styleLayer, which is either layer
+// or one of its styles. The value range is in the display units and is
+// only known if the style has fixed scaling parameters.
+func NewLegendInfo(layer *Layer, styleLayer *Layer) *LegendInfo {
+	legend := &LegendInfo{
+		Layer:       layer.Name,
+		Title:       styleLayer.Title,
+		Units:       styleLayer.DisplayUnits(),
+		ColourScale: "linear",
+	}
+	if styleLayer != layer {
+		legend.Style = styleLayer.Name
+	}
+	if styleLayer.Legend != nil && len(styleLayer.Legend.Title) > 0 {
+		legend.Title = styleLayer.Legend.Title
+	}
+	if len(legend.Title) == 0 {
+		legend.Title = layer.Title
+	}
+	if styleLayer.ColourScale == ColourLogScale {
+		legend.ColourScale = "log"
+	}
+
+	if styleLayer.Palette != nil {
+		legend.Interpolate = styleLayer.Palette.Interpolate
+		for _, c := range styleLayer.Palette.Colours {
+			legend.Colours = append(legend.Colours, fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A))
+		}
+	}
+
+	if minVal, maxVal, ok := styleValueRange(styleLayer); ok {
+		if styleLayer.ColourScale == ColourLogScale {
+			minVal = math.Pow(10, minVal)
+			maxVal = math.Pow(10, maxVal)
+		}
+		legend.Min = &minVal
+		legend.Max = &maxVal
+		legend.Ticks = legendTicks(styleLayer, minVal, maxVal)
+	}
+	return legend
+}
+
+// styleValueRange returns the values mapped to the first and the last
+// colours of the palette by the scaling parameters. As in the tile
+// scaler, values are offset then clipped before scaling to a byte.
+func styleValueRange(layer *Layer) (float64, float64, bool) {
+	offset := layer.OffsetValue
+	clip := layer.ClipValue
+	scale := layer.ScaleValue
+	if scale == 0 && clip == 0 && offset == 0 {
+		// scaled on the fly by the data range of each tile
+		return 0, 0, false
+	}
+	if scale <= 0 {
+		if clip <= 0 {
+			scale = 1
+		} else {
+			scale = 254 / clip
+		}
+	}
+
+	top := 254 / scale
+	if clip > 0 && clip < top {
+		top = clip
+	}
+	return -offset, top - offset, true
+}
+
+// legendTicks spreads the ticks evenly over the value range, or
+// logarithmically for the log colour scale.
+func legendTicks(layer *Layer, minVal, maxVal float64) []LegendTick {
+	nTicks := DefaultLegendTicks
+	if layer.Legend != nil && layer.Legend.Ticks > 0 {
+		nTicks = layer.Legend.Ticks
+	}
+
+	ticks := make([]LegendTick, nTicks)
+	for i := range ticks {
+		frac := 0.0
+		if nTicks > 1 {
+			frac = float64(i) / float64(nTicks-1)
+		}
+
+		var value float64
+		if layer.ColourScale == ColourLogScale && minVal > 0 {
+			value = math.Pow(10, math.Log10(minVal)+frac*(math.Log10(maxVal)-math.Log10(minVal)))
+		} else {
+			value = minVal + frac*(maxVal-minVal)
+		}
+
+		label := layer.Legend.FormatValue(value)
+		if !layer.Legend.HasNumberFormat() {
+			label = strconv.FormatFloat(value, 'g', 6, 64)
+		}
+		ticks[i] = LegendTick{Value: value, Label: label}
+	}
+	return ticks
+}
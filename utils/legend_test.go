@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestLegendInfo(t *testing.T) {
+	layer := &Layer{Name: "tas", Title: "Temperature", OffsetValue: 10, ClipValue: 50,
+		UnitConversion: &UnitConversion{From: "K", To: "degC"}}
+	legend := NewLegendInfo(layer, layer)
+	if legend.Units != "degC" || legend.Title != "Temperature" || legend.Style != "" {
+		t.Errorf("unexpected legend: %+v", legend)
+	}
+	if legend.Min == nil || legend.Max == nil || *legend.Min != -10 || *legend.Max != 40 {
+		t.Errorf("unexpected legend range: %v, %v", legend.Min, legend.Max)
+	}
+	if len(legend.Ticks) != DefaultLegendTicks || legend.Ticks[2].Label != "15" {
+		t.Errorf("unexpected legend ticks: %+v", legend.Ticks)
+	}
+
+	style := &Layer{Name: "auto"}
+	legend = NewLegendInfo(layer, style)
+	if legend.Style != "auto" || legend.Title != "Temperature" || legend.Min != nil || len(legend.Ticks) != 0 {
+		t.Errorf("unexpected style legend: %+v", legend)
+	}
+
+	decimals := 1
+	style = &Layer{Name: "log", ColourScale: ColourLogScale, ClipValue: 3, ScaleValue: 254.0 / 3,
+		Legend: &LegendConfig{Title: "Rainfall", Units: "mm", Ticks: 4, Decimals: &decimals, Scientific: true}}
+	legend = NewLegendInfo(layer, style)
+	if legend.Title != "Rainfall" || legend.Units != "mm" || legend.ColourScale != "log" {
+		t.Errorf("unexpected log legend: %+v", legend)
+	}
+	expected := []string{"1.0e+00", "1.0e+01", "1.0e+02", "1.0e+03"}
+	if len(legend.Ticks) != len(expected) {
+		t.Fatalf("unexpected log legend ticks: %+v", legend.Ticks)
+	}
+	for i, tick := range legend.Ticks {
+		if tick.Label != expected[i] {
+			t.Errorf("expected tick label %s, got %s", expected[i], tick.Label)
+		}
+	}
+}
+
+func TestLegendFormatValue(t *testing.T) {
+	var lc *LegendConfig
+	if lc.HasNumberFormat() || lc.FormatValue(1.5) != "1.5" {
+		t.Errorf("unexpected format without config")
+	}
+
+	decimals := 2
+	lc = &LegendConfig{Decimals: &decimals}
+	if v := lc.FormatValue(3.14159); v != "3.14" {
+		t.Errorf("expected 3.14, got %s", v)
+	}
+
+	lc = &LegendConfig{Scientific: true}
+	if v := lc.FormatValue(12345); v != "1.2345e+04" {
+		t.Errorf("expected 1.2345e+04, got %s", v)
+	}
+
+	bad := -1
+	if err := (&LegendConfig{Decimals: &bad}).validate(); err == nil {
+		t.Errorf("expected error for negative decimals")
+	}
+}
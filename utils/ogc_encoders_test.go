@@ -43,25 +43,25 @@ func TestEncodeGdal(t *testing.T) {
 	}
 
 	// we test all the four corner cases
-	err = EncodeGdal(hDstDS, rs, 0, 0)
+	_, err = EncodeGdal(hDstDS, rs, 0, 0)
 	if err != nil {
 		t.Errorf("failed to write to gdal dataset file: %v", err)
 		return
 	}
 
-	err = EncodeGdal(hDstDS, rs, width-raster.Width, 0)
+	_, err = EncodeGdal(hDstDS, rs, width-raster.Width, 0)
 	if err != nil {
 		t.Errorf("failed to write to gdal dataset file: %v", err)
 		return
 	}
 
-	err = EncodeGdal(hDstDS, rs, width-raster.Width, height-raster.Height)
+	_, err = EncodeGdal(hDstDS, rs, width-raster.Width, height-raster.Height)
 	if err != nil {
 		t.Errorf("failed to write to gdal dataset file: %v", err)
 		return
 	}
 
-	err = EncodeGdal(hDstDS, rs, 0, height-raster.Height)
+	_, err = EncodeGdal(hDstDS, rs, 0, height-raster.Height)
 	if err != nil {
 		t.Errorf("failed to write to gdal dataset file: %v", err)
 		return
@@ -74,11 +74,11 @@ func testEncodeGdalFlush(t *testing.T) {
 	hDstDS, tempFile, err := EncodeGdalOpen("/tmp", 256, 256, "geotiff", []float64{-179, 0.359, 0, 80, 0, -0.16}, 4326, rs, 1000, 1000, 1)
 	defer os.Remove(tempFile)
 
-	newDstDS, err := EncodeGdalFlush(hDstDS, tempFile, "geotiff")
-	if err != nil || newDstDS == nil {
-		t.Errorf("Failed to re-open existing dataset file: %v", err)
+	if err != nil {
+		t.Errorf("failed to create gdal file: %v", err)
 		return
 	}
+	EncodeGdalFlush(hDstDS)
 }
 
 func testEncodeGdalMerge(t *testing.T) {
@@ -0,0 +1,351 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// paletteLibrary holds the named palettes that layers can refer to by
+// name, e.g. "palette": {"name": "turbo"}
+var paletteLibrary *BuiltinPalettes
+
+// SetPaletteLibrary sets the palettes used to resolve palette references
+// in layer configs.
+func SetPaletteLibrary(palettes *BuiltinPalettes) {
+	paletteLibrary = palettes
+}
+
+// Find looks a palette up by name. The lookup is case insensitive.
+func (bp *BuiltinPalettes) Find(name string) *Palette {
+	for _, p := range bp.Palettes {
+		if strings.ToLower(p.Name) == strings.ToLower(name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Add adds palettes to the library. A palette replaces the existing one
+// with the same name.
+func (bp *BuiltinPalettes) Add(palettes ...*Palette) {
+	for _, palette := range palettes {
+		replaced := false
+		for i, p := range bp.Palettes {
+			if strings.ToLower(p.Name) == strings.ToLower(palette.Name) {
+				bp.Palettes[i] = palette
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			bp.Palettes = append(bp.Palettes, palette)
+		}
+	}
+}
+
+// LoadPaletteDir loads all the palette files under dir into the library.
+// Files with extensions .cpt (GMT colour palette tables), .sld (SLD
+// ColorMap) and .json (gsky palettes) are recognised, other files are
+// ignored.
+func (bp *BuiltinPalettes) LoadPaletteDir(dir string, verbose bool) error {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".cpt", ".sld", ".json":
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		palettes, err := LoadPaletteFile(file)
+		if err != nil {
+			return err
+		}
+		if verbose {
+			for _, p := range palettes {
+				log.Printf("Loaded palette %s from %s", p.Name, file)
+			}
+		}
+		bp.Add(palettes...)
+	}
+	return nil
+}
+
+// LoadPaletteFile loads the palettes in a .cpt, .sld or .json file. The
+// palettes of .cpt and .sld files are named after the file name without
+// extension.
+func LoadPaletteFile(filePath string) ([]*Palette, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	var palettes []*Palette
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".cpt":
+		var palette *Palette
+		palette, err = parseCPT(name, data)
+		palettes = []*Palette{palette}
+	case ".sld":
+		var palette *Palette
+		palette, err = parseSLDColorMap(name, data)
+		palettes = []*Palette{palette}
+	case ".json":
+		palettes, err = parsePaletteJSON(data)
+	default:
+		err = fmt.Errorf("unknown palette file format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("palette file %s: %v", filePath, err)
+	}
+
+	for _, p := range palettes {
+		if len(p.Name) == 0 {
+			return nil, fmt.Errorf("palette file %s: palette name is empty", filePath)
+		}
+		if len(p.Colours) == 0 {
+			return nil, fmt.Errorf("palette file %s: palette %s has no colours", filePath, p.Name)
+		}
+	}
+	return palettes, nil
+}
+
+// parseCPT parses a GMT colour palette table. Each line defines a slice
+// as "z0 r g b z1 r g b" or "z0 r/g/b z1 r/g/b". The palette is
+// interpolated unless every slice has a constant colour. The background,
+// foreground and NaN colours (B, F, N lines) are ignored.
+func parseCPT(name string, data []byte) (*Palette, error) {
+	palette := &Palette{Name: name}
+	interpolate := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			if strings.Contains(strings.ToUpper(line), "COLOR_MODEL") && !strings.Contains(strings.ToUpper(line), "RGB") {
+				return nil, fmt.Errorf("line %d: only the RGB colour model is supported", lineNo)
+			}
+			continue
+		}
+		if len(line) == 0 {
+			continue
+		}
+		switch line[0] {
+		case 'B', 'F', 'N':
+			continue
+		}
+		if idx := strings.Index(line, ";"); idx >= 0 {
+			line = line[:idx]
+		}
+
+		fields := strings.Fields(strings.Replace(line, "/", " ", -1))
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("line %d: expected z0 r g b z1 r g b", lineNo)
+		}
+
+		var lower, upper color.RGBA
+		for i, c := range []*color.RGBA{&lower, &upper} {
+			var rgb [3]uint8
+			for j := 0; j < 3; j++ {
+				v, err := strconv.ParseFloat(fields[i*4+1+j], 64)
+				if err != nil || v < 0 || v > 255 {
+					return nil, fmt.Errorf("line %d: invalid colour component %q", lineNo, fields[i*4+1+j])
+				}
+				rgb[j] = uint8(v)
+			}
+			*c = color.RGBA{rgb[0], rgb[1], rgb[2], 255}
+		}
+
+		if lower != upper {
+			interpolate = true
+		}
+		palette.Colours = append(palette.Colours, lower, upper)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	palette.Interpolate = interpolate
+	if !interpolate {
+		// one colour per slice
+		var colours []color.RGBA
+		for i := 0; i < len(palette.Colours); i += 2 {
+			colours = append(colours, palette.Colours[i])
+		}
+		palette.Colours = colours
+	} else {
+		palette.Colours = dedupColours(palette.Colours)
+	}
+	return palette, nil
+}
+
+// dedupColours removes consecutive duplicated colours such as the shared
+// boundary colours of continuous cpt slices.
+func dedupColours(colours []color.RGBA) []color.RGBA {
+	var res []color.RGBA
+	for i, c := range colours {
+		if i > 0 && c == colours[i-1] {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// parseSLDColorMap parses the ColorMapEntry elements of the first
+// ColorMap of an SLD document. A ColorMap of type ramp, the default, is
+// interpolated.
+func parseSLDColorMap(name string, data []byte) (*Palette, error) {
+	palette := &Palette{Name: name, Interpolate: true}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	inColorMap := false
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		switch elem := tok.(type) {
+		case xml.StartElement:
+			switch elem.Name.Local {
+			case "ColorMap":
+				if len(palette.Colours) > 0 {
+					return palette, nil
+				}
+				inColorMap = true
+				for _, attr := range elem.Attr {
+					if attr.Name.Local == "type" && attr.Value != "ramp" {
+						palette.Interpolate = false
+					}
+				}
+			case "ColorMapEntry":
+				if !inColorMap {
+					continue
+				}
+				var colour color.RGBA
+				colour.A = 255
+				hasColour := false
+				for _, attr := range elem.Attr {
+					switch attr.Name.Local {
+					case "color":
+						c, err := parseHexColour(attr.Value)
+						if err != nil {
+							return nil, err
+						}
+						colour.R, colour.G, colour.B = c.R, c.G, c.B
+						hasColour = true
+					case "opacity":
+						v, err := strconv.ParseFloat(attr.Value, 64)
+						if err != nil || v < 0 || v > 1 {
+							return nil, fmt.Errorf("invalid opacity %q", attr.Value)
+						}
+						colour.A = uint8(v*255 + 0.5)
+					}
+				}
+				if !hasColour {
+					return nil, fmt.Errorf("ColorMapEntry without color")
+				}
+				palette.Colours = append(palette.Colours, colour)
+			}
+		case xml.EndElement:
+			if elem.Name.Local == "ColorMap" {
+				inColorMap = false
+			}
+		}
+	}
+
+	if len(palette.Colours) == 0 {
+		return nil, fmt.Errorf("no ColorMapEntry found")
+	}
+	return palette, nil
+}
+
+func parseHexColour(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q, expected #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q, expected #rrggbb", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// parsePaletteJSON accepts the {"palettes": [...]} format of the builtin
+// palettes, a list of palettes or a single palette.
+func parsePaletteJSON(data []byte) ([]*Palette, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var palettes []*Palette
+		err := Unmarshal(data, &palettes)
+		return palettes, err
+	}
+
+	var obj struct {
+		Palettes []*Palette `json:"palettes"`
+		Palette
+	}
+	if err := Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	if len(obj.Palettes) > 0 {
+		return obj.Palettes, nil
+	}
+	palette := obj.Palette
+	return []*Palette{&palette}, nil
+}
+
+// resolvePaletteRefs replaces the palettes given only by name with the
+// palettes of the same name in the palette library.
+func resolvePaletteRefs(layer *Layer) error {
+	resolve := func(palette *Palette) (*Palette, error) {
+		if palette == nil || len(palette.Colours) > 0 || len(palette.Name) == 0 {
+			return palette, nil
+		}
+		if paletteLibrary != nil {
+			if p := paletteLibrary.Find(palette.Name); p != nil {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("Layer %v: palette %v not found", layer.Name, palette.Name)
+	}
+
+	var err error
+	layer.Palette, err = resolve(layer.Palette)
+	if err != nil {
+		return err
+	}
+	for i := range layer.Palettes {
+		layer.Palettes[i], err = resolve(layer.Palettes[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
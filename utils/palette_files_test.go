@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPaletteDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gsky_palettes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"temp.cpt": `# COLOR_MODEL = RGB
+0 0 0 255 10 255/255/255
+10 255 255 255 20 255 0 0
+B 0 0 0
+F 255 255 255
+N 128 128 128
+`,
+		"classes.cpt": `0 0 0 255 1 0 0 255
+1 0 255 0 2 0 255 0
+`,
+		"rain.sld": `<?xml version="1.0" encoding="UTF-8"?>
+<StyledLayerDescriptor xmlns="http://www.opengis.net/sld">
+  <NamedLayer><UserStyle><FeatureTypeStyle><Rule><RasterSymbolizer>
+    <ColorMap type="intervals">
+      <ColorMapEntry color="#FFFFFF" quantity="0" opacity="0"/>
+      <ColorMapEntry color="#00ff00" quantity="10"/>
+    </ColorMap>
+  </RasterSymbolizer></Rule></FeatureTypeStyle></UserStyle></NamedLayer>
+</StyledLayerDescriptor>`,
+		"custom.json": `{"palettes": [{"name": "viridis", "interpolate": true, "colours": [{"R": 1, "A": 255}, {"G": 1, "A": 255}]}]}`,
+		"README.txt":  "not a palette",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bp := NewBuiltinPalettes()
+	n := len(bp.Palettes)
+	if err := bp.LoadPaletteDir(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(bp.Palettes) != n+3 {
+		t.Errorf("expected %d palettes, got %d", n+3, len(bp.Palettes))
+	}
+
+	temp := bp.Find("TEMP")
+	if temp == nil || !temp.Interpolate || len(temp.Colours) != 3 || temp.Colours[2] != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("unexpected cpt palette: %+v", temp)
+	}
+
+	classes := bp.Find("classes")
+	if classes == nil || classes.Interpolate || len(classes.Colours) != 2 {
+		t.Errorf("unexpected discrete cpt palette: %+v", classes)
+	}
+
+	rain := bp.Find("rain")
+	if rain == nil || rain.Interpolate || len(rain.Colours) != 2 ||
+		rain.Colours[0] != (color.RGBA{255, 255, 255, 0}) || rain.Colours[1] != (color.RGBA{0, 255, 0, 255}) {
+		t.Errorf("unexpected sld palette: %+v", rain)
+	}
+
+	viridis := bp.Find("viridis")
+	if viridis == nil || len(viridis.Colours) != 2 || viridis.Colours[0].R != 1 {
+		t.Errorf("expected viridis to be replaced by the palette file: %+v", viridis)
+	}
+
+	for _, name := range []string{"turbo", "precipitation", "anomaly_diverging_r"} {
+		if bp.Find(name) == nil {
+			t.Errorf("builtin palette %s not found", name)
+		}
+	}
+}
+
+func TestResolvePaletteRefs(t *testing.T) {
+	saved := paletteLibrary
+	defer func() { paletteLibrary = saved }()
+	SetPaletteLibrary(NewBuiltinPalettes())
+
+	layer := &Layer{Name: "test", Palette: &Palette{Name: "turbo"}, Palettes: []*Palette{{Name: "precipitation"}}}
+	if err := resolvePaletteRefs(layer); err != nil {
+		t.Fatal(err)
+	}
+	if len(layer.Palette.Colours) == 0 || len(layer.Palettes[0].Colours) == 0 {
+		t.Errorf("palette references not resolved")
+	}
+
+	layer = &Layer{Name: "test", Palette: &Palette{Name: "no_such_palette"}}
+	if err := resolvePaletteRefs(layer); err == nil {
+		t.Errorf("expected error for unknown palette")
+	}
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The OWS requests rate limits apply to by default, being the ones
+// expensive enough for scripted bulk downloads to degrade the service.
+var rateLimitedRequests = []string{"GetMap", "GetCoverage", "Execute"}
+
+// rateLimitIdle is how long the state of an idle client is kept.
+const rateLimitIdle = 10 * time.Minute
+
+// RateLimits limits the rate and the concurrency of the OWS requests of
+// each client. It is configured in the service config of the root
+// namespace and applies to all namespaces. The state of the clients is
+// reset when the config is reloaded.
+type RateLimits struct {
+	// TrustedNetworks are the CIDRs of the clients exempted from the
+	// limits, e.g. the WCS cluster nodes.
+	TrustedNetworks []string         `json:"trusted_networks"`
+	Rules           []*RateLimitRule `json:"rules"`
+
+	trusted   []*net.IPNet
+	mu        sync.Mutex
+	clients   map[string]*rateLimitClient
+	lastSweep time.Time
+}
+
+// RateLimitRule limits the requests of each client identified by its
+// IP address or by its API key or token. The clients without key are
+// identified by their IP address.
+type RateLimitRule struct {
+	// Requests are the OWS requests limited, GetMap, GetCoverage and
+	// Execute by default.
+	Requests []string `json:"requests"`
+	// Per is ip or key, ip by default.
+	Per string `json:"per"`
+	// Rate is the sustained number of requests per minute, unlimited
+	// if 0, and Burst the number of requests allowed at once after an
+	// idle period, Rate/10 by default and at least 1.
+	Rate  float64 `json:"rate"`
+	Burst float64 `json:"burst"`
+	// MaxConcurrent is the number of requests served at the same time,
+	// unlimited if 0.
+	MaxConcurrent int `json:"max_concurrent"`
+}
+
+type rateLimitClient struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+// RateLimitError is returned for the requests exceeding a limit, which
+// are answered with 429.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	msg        string
+}
+
+func (e *RateLimitError) Error() string {
+	return e.msg
+}
+
+func (rl *RateLimits) validate() error {
+	trusted, err := parseNetworks("rate_limits.trusted_networks", rl.TrustedNetworks)
+	if err != nil {
+		return err
+	}
+	rl.trusted = trusted
+
+	for i, rule := range rl.Rules {
+		if len(rule.Requests) == 0 {
+			rule.Requests = rateLimitedRequests
+		}
+		switch rule.Per {
+		case "":
+			rule.Per = "ip"
+		case "ip", "key":
+		default:
+			return fmt.Errorf("rate_limits.rules[%d]: per must be ip or key: %v", i, rule.Per)
+		}
+		if rule.Rate < 0 || rule.Burst < 0 || rule.MaxConcurrent < 0 {
+			return fmt.Errorf("rate_limits.rules[%d]: rate, burst and max_concurrent must not be negative", i)
+		}
+		if rule.Rate > 0 && rule.Burst == 0 {
+			rule.Burst = rule.Rate / 10
+			if rule.Burst < 1 {
+				rule.Burst = 1
+			}
+		}
+		if rule.Rate > 0 && rule.Burst < 1 {
+			return fmt.Errorf("rate_limits.rules[%d]: burst must be at least 1", i)
+		}
+	}
+	rl.clients = make(map[string]*rateLimitClient)
+	return nil
+}
+
+func (rule *RateLimitRule) applies(request string) bool {
+	for _, req := range rule.Requests {
+		if strings.EqualFold(req, request) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rl *RateLimits) isTrusted(ip string) bool {
+	return inNetworks(ip, rl.trusted)
+}
+
+// Acquire checks an OWS request of the client with the given IP address,
+// that of ServiceConfig.ClientIP, and key, empty for anonymous clients,
+// against the limits. The release
+// function returned must be called once the request is served. A
+// RateLimitError is returned if a limit is exceeded.
+func (rl *RateLimits) Acquire(request, ip, key string) (func(), error) {
+	if rl == nil || rl.isTrusted(ip) {
+		return func() {}, nil
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rl.sweep(now)
+
+	var acquired []*rateLimitClient
+	undo := func() {
+		for _, c := range acquired {
+			c.inFlight--
+		}
+	}
+
+	for i, rule := range rl.Rules {
+		if !rule.applies(request) {
+			continue
+		}
+		id := "ip:" + ip
+		if rule.Per == "key" && len(key) > 0 {
+			id = "key:" + key
+		}
+		id = fmt.Sprintf("%d/%s", i, id)
+
+		c, found := rl.clients[id]
+		if !found {
+			c = &rateLimitClient{tokens: rule.Burst, last: now}
+			rl.clients[id] = c
+		}
+
+		if rule.MaxConcurrent > 0 && c.inFlight >= rule.MaxConcurrent {
+			undo()
+			return nil, &RateLimitError{RetryAfter: time.Second, msg: fmt.Sprintf("too many concurrent %s requests, at most %d allowed", request, rule.MaxConcurrent)}
+		}
+
+		if rule.Rate > 0 {
+			perSecond := rule.Rate / 60
+			c.tokens += now.Sub(c.last).Seconds() * perSecond
+			if c.tokens > rule.Burst {
+				c.tokens = rule.Burst
+			}
+			c.last = now
+			if c.tokens < 1 {
+				wait := time.Duration((1 - c.tokens) / perSecond * float64(time.Second))
+				undo()
+				return nil, &RateLimitError{RetryAfter: wait, msg: fmt.Sprintf("rate limit of %s requests exceeded, at most %g per minute allowed", request, rule.Rate)}
+			}
+			c.tokens--
+		}
+		c.last = now
+		c.inFlight++
+		acquired = append(acquired, c)
+	}
+
+	return func() {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		undo()
+	}, nil
+}
+
+// sweep forgets the idle clients so that the state does not grow with
+// the number of clients seen.
+func (rl *RateLimits) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	for id, c := range rl.clients {
+		if c.inFlight == 0 && now.Sub(c.last) > rateLimitIdle {
+			delete(rl.clients, id)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimits(t *testing.T) {
+	var rl RateLimits
+	conf := `{
+		"trusted_networks": ["10.0.0.0/8"],
+		"rules": [
+			{"requests": ["GetMap"], "rate": 60, "burst": 2},
+			{"requests": ["GetCoverage"], "per": "key", "max_concurrent": 1}
+		]
+	}`
+	if err := json.Unmarshal([]byte(conf), &rl); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := rl.Acquire("GetMap", "192.0.2.1", ""); err != nil {
+			t.Fatalf("request %d within burst limited: %v", i, err)
+		}
+	}
+	_, err := rl.Acquire("GetMap", "192.0.2.1", "")
+	rle, ok := err.(*RateLimitError)
+	if !ok || rle.RetryAfter <= 0 {
+		t.Errorf("expected RateLimitError, got %v", err)
+	}
+	if _, err := rl.Acquire("GetMap", "192.0.2.2", ""); err != nil {
+		t.Errorf("other client limited: %v", err)
+	}
+	if _, err := rl.Acquire("GetMap", "10.1.2.3", ""); err != nil {
+		t.Errorf("trusted client limited: %v", err)
+	}
+	if _, err := rl.Acquire("GetCapabilities", "192.0.2.1", ""); err != nil {
+		t.Errorf("request without rule limited: %v", err)
+	}
+
+	release, err := rl.Acquire("GetCoverage", "192.0.2.1", "key-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rl.Acquire("GetCoverage", "192.0.2.3", "key-a"); err == nil {
+		t.Errorf("concurrent request of the same key allowed")
+	}
+	if _, err := rl.Acquire("GetCoverage", "192.0.2.1", "key-b"); err != nil {
+		t.Errorf("request of another key limited: %v", err)
+	}
+	release()
+	if _, err := rl.Acquire("GetCoverage", "192.0.2.3", "key-a"); err != nil {
+		t.Errorf("request after release limited: %v", err)
+	}
+
+	var nilLimits *RateLimits
+	if _, err := nilLimits.Acquire("GetMap", "192.0.2.1", ""); err != nil {
+		t.Errorf("nil rate limits must allow everything: %v", err)
+	}
+
+	invalid := []string{
+		`{"trusted_networks": ["10.0.0.0"]}`,
+		`{"rules": [{"per": "user"}]}`,
+		`{"rules": [{"rate": -1}]}`,
+		`{"rules": [{"rate": 60, "burst": 0.5}]}`,
+	}
+	for _, conf := range invalid {
+		var rl RateLimits
+		if err := json.Unmarshal([]byte(conf), &rl); err != nil {
+			t.Fatal(err)
+		}
+		if err := rl.validate(); err == nil {
+			t.Errorf("expected error for %s", conf)
+		}
+	}
+}
+
+// TestRateLimitsForwardedFor checks that the clients are not exempted or
+// given fresh limits by the X-Forwarded-For headers they send.
+func TestRateLimitsForwardedFor(t *testing.T) {
+	var sc ServiceConfig
+	conf := `{
+		"trusted_proxies": ["192.0.2.254/32"],
+		"rate_limits": {
+			"trusted_networks": ["10.0.0.0/8"],
+			"rules": [{"requests": ["GetMap"], "rate": 60, "burst": 2}]
+		}
+	}`
+	if err := json.Unmarshal([]byte(conf), &sc); err != nil {
+		t.Fatal(err)
+	}
+	proxies, err := parseNetworks("trusted_proxies", sc.TrustedProxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.trustedProxies = proxies
+	if err := sc.RateLimits.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	acquire := func(peer, xff string) error {
+		r := httptest.NewRequest("GET", "/ows?service=WMS&request=GetMap", nil)
+		r.RemoteAddr = peer
+		r.Header.Set("X-Forwarded-For", xff)
+		_, err := sc.RateLimits.Acquire("GetMap", sc.ClientIP(r), "")
+		return err
+	}
+
+	// A fresh X-Forwarded-For on each request is the same client.
+	var limited int
+	for i := 0; i < 5; i++ {
+		if acquire("192.0.2.1:4000", fmt.Sprintf("198.51.100.%d", i)) != nil {
+			limited++
+		}
+	}
+	if limited != 3 {
+		t.Errorf("%d of 5 requests of random X-Forwarded-For limited instead of 3", limited)
+	}
+
+	// An X-Forwarded-For of a trusted network does not exempt the client.
+	limited = 0
+	for i := 0; i < 5; i++ {
+		if acquire("192.0.2.2:4000", "10.0.0.1") != nil {
+			limited++
+		}
+	}
+	if limited != 3 {
+		t.Errorf("%d of 5 requests of a trusted X-Forwarded-For limited instead of 3", limited)
+	}
+
+	// Through a trusted proxy, the clients are those of the header.
+	for i := 0; i < 2; i++ {
+		if err := acquire("192.0.2.254:80", "203.0.113.1"); err != nil {
+			t.Errorf("client behind the proxy limited: %v", err)
+		}
+	}
+	if acquire("192.0.2.254:80", "203.0.113.1") == nil {
+		t.Errorf("client behind the proxy not limited beyond its burst")
+	}
+	if err := acquire("192.0.2.254:80", "203.0.113.2"); err != nil {
+		t.Errorf("other client behind the proxy limited: %v", err)
+	}
+	if err := acquire("192.0.2.254:80", "10.0.0.7"); err != nil {
+		t.Errorf("trusted network behind the proxy limited: %v", err)
+	}
+}
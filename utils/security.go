@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SecurityHeaders are the security headers set on the responses of the
+// OWS server. It is configured in the service config of the root
+// namespace. The responses are sent with X-Content-Type-Options nosniff
+// whether it is configured or not.
+type SecurityHeaders struct {
+	// HSTS is the Strict-Transport-Security header of the requests
+	// received over HTTPS, e.g. "max-age=31536000; includeSubDomains".
+	HSTS string `json:"hsts"`
+	// FrameOptions is the X-Frame-Options header, DENY or SAMEORIGIN.
+	FrameOptions          string `json:"frame_options"`
+	ContentSecurityPolicy string `json:"content_security_policy"`
+	ReferrerPolicy        string `json:"referrer_policy"`
+	// Headers are other headers set, or removed if empty, e.g.
+	// {"X-Content-Type-Options": ""}.
+	Headers map[string]string `json:"headers"`
+}
+
+// Validate checks the headers and normalises the frame options.
+func (h *SecurityHeaders) Validate() error {
+	if len(h.HSTS) > 0 && !strings.HasPrefix(strings.ToLower(h.HSTS), "max-age=") {
+		return fmt.Errorf("security_headers: invalid hsts %q, expected max-age=<seconds>", h.HSTS)
+	}
+	if len(h.FrameOptions) > 0 {
+		h.FrameOptions = strings.ToUpper(h.FrameOptions)
+		if h.FrameOptions != "DENY" && h.FrameOptions != "SAMEORIGIN" {
+			return fmt.Errorf("security_headers: invalid frame_options %q, expected DENY or SAMEORIGIN", h.FrameOptions)
+		}
+	}
+	for _, v := range []string{h.HSTS, h.ContentSecurityPolicy, h.ReferrerPolicy} {
+		if hasControlChars(v) {
+			return fmt.Errorf("security_headers: control characters in %q", v)
+		}
+	}
+	for name, v := range h.Headers {
+		if len(name) == 0 || strings.ContainsAny(name, " :") || hasControlChars(name) || hasControlChars(v) {
+			return fmt.Errorf("security_headers: invalid header %q: %q", name, v)
+		}
+	}
+	return nil
+}
+
+// Set sets the headers on a response, the HSTS header only if the
+// request was received over HTTPS.
+func (h *SecurityHeaders) Set(header http.Header, https bool) {
+	header.Set("X-Content-Type-Options", "nosniff")
+	if h == nil {
+		return
+	}
+	for name, v := range map[string]string{
+		"X-Frame-Options":         h.FrameOptions,
+		"Content-Security-Policy": h.ContentSecurityPolicy,
+		"Referrer-Policy":         h.ReferrerPolicy,
+	} {
+		if len(v) > 0 {
+			header.Set(name, v)
+		}
+	}
+	if https && len(h.HSTS) > 0 {
+		header.Set("Strict-Transport-Security", h.HSTS)
+	}
+	for name, v := range h.Headers {
+		if len(v) > 0 {
+			header.Set(name, v)
+		} else {
+			header.Del(name)
+		}
+	}
+}
+
+// markupParams are the query parameters holding expressions, whose
+// comparison operators are allowed.
+var markupParams = map[string]bool{"dap4.ce": true, "rangesubset": true, "code": true}
+
+// CheckQueryValues rejects the OWS query parameters with control
+// characters, or with markup outside of the expressions, since some of
+// them are reflected in the XML and HTML responses. Tabs and line
+// breaks are allowed, e.g. in the geometries of the WPS requests.
+func CheckQueryValues(query map[string][]string) error {
+	for key, values := range query {
+		if hasControlChars(key) || strings.ContainsAny(key, "<>") {
+			return fmt.Errorf("invalid parameter name %q", key)
+		}
+		for _, v := range values {
+			if hasControlChars(strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(v)) {
+				return fmt.Errorf("invalid control character in parameter %s", key)
+			}
+			if !markupParams[key] && strings.ContainsAny(v, "<>") {
+				return fmt.Errorf("invalid character in parameter %s: %q", key, v)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidHost reports whether the Host header of a request is a host name
+// or an IP address with an optional port. Other characters are allowed
+// by HTTP but would be reflected in the URLs of the capabilities.
+func ValidHost(host string) bool {
+	for _, c := range host {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune(".-_:[]", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func hasControlChars(s string) bool {
+	for _, c := range s {
+		if c < 0x20 || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
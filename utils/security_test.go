@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	header := http.Header{}
+	var none *SecurityHeaders
+	none.Set(header, true)
+	if header.Get("X-Content-Type-Options") != "nosniff" || len(header) != 1 {
+		t.Errorf("unexpected default headers: %v", header)
+	}
+
+	h := &SecurityHeaders{
+		HSTS:         "max-age=31536000; includeSubDomains",
+		FrameOptions: "sameorigin",
+		Headers:      map[string]string{"X-Content-Type-Options": "", "Permissions-Policy": "geolocation=()"},
+	}
+	if err := h.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	header = http.Header{}
+	h.Set(header, false)
+	if header.Get("X-Frame-Options") != "SAMEORIGIN" || header.Get("Permissions-Policy") != "geolocation=()" {
+		t.Errorf("unexpected headers: %v", header)
+	}
+	if _, found := header["X-Content-Type-Options"]; found {
+		t.Errorf("expected X-Content-Type-Options removed: %v", header)
+	}
+	if _, found := header["Strict-Transport-Security"]; found {
+		t.Errorf("expected no HSTS over HTTP: %v", header)
+	}
+	h.Set(header, true)
+	if header.Get("Strict-Transport-Security") != h.HSTS {
+		t.Errorf("expected HSTS over HTTPS: %v", header)
+	}
+
+	for _, invalid := range []*SecurityHeaders{
+		{HSTS: "1 year"},
+		{FrameOptions: "ALLOW-FROM https://example.com"},
+		{ReferrerPolicy: "no-referrer\r\nSet-Cookie: a=b"},
+		{Headers: map[string]string{"X-Bad: a": "b"}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected error for %+v", invalid)
+		}
+	}
+}
+
+func TestCheckQueryValues(t *testing.T) {
+	valid := []map[string][]string{
+		{"layers": {"chirps_daily"}, "bbox": {"33,-5,42,5"}, "time": {"2020-01-01T00:00:00.000Z"}},
+		{"geometry": {"{\n\t\"type\": \"Point\",\n\t\"coordinates\": [36.8, -1.3]\n}"}},
+		{"code": {"ndvi > 0.5"}, "dap4.ce": {"chirps{precip<10}"}},
+	}
+	for _, query := range valid {
+		if err := CheckQueryValues(query); err != nil {
+			t.Errorf("unexpected error for %v: %v", query, err)
+		}
+	}
+
+	invalid := []map[string][]string{
+		{"layers": {"<script>alert(1)</script>"}},
+		{"styles": {"a\x00b"}},
+		{"<x>": {"1"}},
+	}
+	for _, query := range invalid {
+		if err := CheckQueryValues(query); err == nil {
+			t.Errorf("expected error for %v", query)
+		}
+	}
+}
+
+func TestValidHost(t *testing.T) {
+	for host, valid := range map[string]bool{
+		"":                    true,
+		"gsky.icpac.net":      true,
+		"localhost:8080":      true,
+		"[::1]:8080":          true,
+		"10.0.0.1":            true,
+		"evil.com'/><x":       false,
+		"a&b.com":             false,
+		"gsky.icpac.net/path": false,
+	} {
+		if ValidHost(host) != valid {
+			t.Errorf("expected ValidHost(%q) = %v", host, valid)
+		}
+	}
+}
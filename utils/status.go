@@ -0,0 +1,256 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nci/gsky/diskcache"
+	"github.com/nci/gsky/failover"
+	"github.com/nci/gsky/logging"
+	pb "github.com/nci/gsky/worker/gdalservice"
+	"google.golang.org/grpc"
+)
+
+// DefaultStatusTimeout bounds the MAS and worker checks of a status
+// report.
+const DefaultStatusTimeout = 3 * time.Second
+
+var serverStartTime = time.Now()
+
+// StatusReport is the state of the OWS server shown by the admin status
+// page.
+type StatusReport struct {
+	Version      string                    `json:"version"`
+	StartTime    time.Time                 `json:"start_time"`
+	Generated    time.Time                 `json:"generated"`
+	Layers       []LayerStatus             `json:"layers"`
+	MAS          []ServiceStatus           `json:"mas"`
+	MASFailover  []failover.EndpointStatus `json:"mas_failover,omitempty"`
+	Workers      []ServiceStatus           `json:"workers"`
+	Caches       []CacheStats              `json:"caches"`
+	DiskCache    *diskcache.Stats          `json:"disk_cache,omitempty"`
+	RecentErrors []logging.Record          `json:"recent_errors"`
+}
+
+// LayerStatus is the health of a layer. A layer is healthy if the config
+// checks found no issue with it.
+type LayerStatus struct {
+	NameSpace  string   `json:"namespace"`
+	Name       string   `json:"name"`
+	Title      string   `json:"title"`
+	Healthy    bool     `json:"healthy"`
+	Deprecated bool     `json:"deprecated"`
+	Sunset     string   `json:"sunset,omitempty"`
+	Issues     []string `json:"issues,omitempty"`
+}
+
+// ServiceStatus is the connectivity of a MAS or worker node.
+type ServiceStatus struct {
+	Address   string  `json:"address"`
+	Reachable bool    `json:"reachable"`
+	LatencyMS float64 `json:"latency_ms"`
+	// PoolSize is the number of worker processes of a worker node.
+	PoolSize int    `json:"pool_size,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CacheStats are the lookups of a cache of the OWS server.
+type CacheStats struct {
+	Cache    string  `json:"cache"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+var cacheStats = struct {
+	sync.Mutex
+	lookups map[string]*CacheStats
+}{lookups: make(map[string]*CacheStats)}
+
+// RecordCacheLookup counts a lookup of a cache of the OWS server.
+func RecordCacheLookup(cache string, hit bool) {
+	cacheStats.Lock()
+	defer cacheStats.Unlock()
+	cs, ok := cacheStats.lookups[cache]
+	if !ok {
+		cs = &CacheStats{Cache: cache}
+		cacheStats.lookups[cache] = cs
+	}
+	if hit {
+		cs.Hits++
+	} else {
+		cs.Misses++
+	}
+}
+
+// CacheStatistics returns the lookups of the caches since the start of
+// the server, sorted by cache name.
+func CacheStatistics() []CacheStats {
+	cacheStats.Lock()
+	defer cacheStats.Unlock()
+	stats := make([]CacheStats, 0, len(cacheStats.lookups))
+	for _, cs := range cacheStats.lookups {
+		s := *cs
+		if total := s.Hits + s.Misses; total > 0 {
+			s.HitRatio = float64(s.Hits) / float64(total)
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Cache < stats[j].Cache })
+	return stats
+}
+
+// NewStatusReport builds the status report of the configs. The config
+// checks look up the data sources in MAS if checkMAS is set, which is
+// slow for large configs.
+func NewStatusReport(confMap map[string]*Config, checkMAS bool, timeout time.Duration) *StatusReport {
+	report := &StatusReport{
+		Version:      GSKYVersion,
+		StartTime:    serverStartTime,
+		Generated:    time.Now(),
+		Layers:       []LayerStatus{},
+		Caches:       CacheStatistics(),
+		RecentErrors: logging.Recent(),
+	}
+
+	issues := make(map[string][]string)
+	for _, issue := range NewConfigChecker(checkMAS).CheckConfig(confMap) {
+		key := layerIssueKey(issue.NameSpace, issue.Object)
+		issues[key] = append(issues[key], issue.Message)
+	}
+
+	namespaces := make([]string, 0, len(confMap))
+	for ns := range confMap {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var masAddresses, workerNodes []string
+	seen := make(map[string]bool)
+	for _, ns := range namespaces {
+		config := confMap[ns]
+		if config == nil {
+			continue
+		}
+		if addr := strings.TrimSpace(config.ServiceConfig.MASAddress); len(addr) > 0 && !seen["mas "+addr] {
+			seen["mas "+addr] = true
+			masAddresses = append(masAddresses, addr)
+		}
+		for _, node := range config.ServiceConfig.WorkerNodes {
+			if node = strings.TrimSpace(node); len(node) > 0 && !seen["worker "+node] {
+				seen["worker "+node] = true
+				workerNodes = append(workerNodes, node)
+			}
+		}
+
+		for i := range config.Layers {
+			layer := &config.Layers[i]
+			ls := LayerStatus{
+				NameSpace: ns,
+				Name:      layer.Name,
+				Title:     layer.Title,
+				Issues:    issues[layerIssueKey(ns, "layer "+layer.Name)],
+			}
+			ls.Healthy = len(ls.Issues) == 0
+			if layer.Deprecation != nil {
+				ls.Deprecated = true
+				ls.Sunset = layer.Deprecation.Sunset
+			}
+			report.Layers = append(report.Layers, ls)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		report.MAS = CheckMASStatus(masAddresses, timeout)
+	}()
+	go func() {
+		defer wg.Done()
+		report.Workers = CheckWorkerStatus(workerNodes, timeout)
+	}()
+	wg.Wait()
+	return report
+}
+
+// layerIssueKey maps the issues of a layer, its styles and overviews to
+// the layer.
+func layerIssueKey(ns, obj string) string {
+	if idx := strings.Index(obj, ", "); idx >= 0 {
+		obj = obj[:idx]
+	}
+	return ns + "\x00" + obj
+}
+
+// CheckMASStatus queries the root gpaths of the MAS nodes.
+func CheckMASStatus(addresses []string, timeout time.Duration) []ServiceStatus {
+	client := &http.Client{Timeout: timeout}
+	statuses := make([]ServiceStatus, len(addresses))
+	var wg sync.WaitGroup
+	for i, addr := range addresses {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			statuses[i] = ServiceStatus{Address: addr}
+			t0 := time.Now()
+			resp, err := client.Get(fmt.Sprintf("http://%s/?list_root_gpath", addr))
+			statuses[i].LatencyMS = float64(time.Since(t0)) / float64(time.Millisecond)
+			if err != nil {
+				statuses[i].Error = err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK {
+				statuses[i].Error = fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+				return
+			}
+			statuses[i].Reachable = true
+		}(i, addr)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// CheckWorkerStatus queries the pool size of the gRPC worker nodes.
+func CheckWorkerStatus(nodes []string, timeout time.Duration) []ServiceStatus {
+	statuses := make([]ServiceStatus, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			statuses[i] = ServiceStatus{Address: node}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			t0 := time.Now()
+			conn, err := grpc.DialContext(ctx, node, grpc.WithInsecure(), grpc.WithBlock())
+			if err != nil {
+				statuses[i].Error = err.Error()
+				return
+			}
+			defer conn.Close()
+
+			r, err := pb.NewGDALClient(conn).Process(ctx, &pb.GeoRPCGranule{Operation: "worker_info"})
+			statuses[i].LatencyMS = float64(time.Since(t0)) / float64(time.Millisecond)
+			if err != nil {
+				statuses[i].Error = err.Error()
+				return
+			}
+			statuses[i].Reachable = true
+			if r.WorkerInfo != nil {
+				statuses[i].PoolSize = int(r.WorkerInfo.PoolSize)
+			}
+		}(i, node)
+	}
+	wg.Wait()
+	return statuses
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheStatistics(t *testing.T) {
+	RecordCacheLookup("test_b", true)
+	RecordCacheLookup("test_a", false)
+	RecordCacheLookup("test_b", false)
+	RecordCacheLookup("test_b", true)
+
+	var found []CacheStats
+	for _, cs := range CacheStatistics() {
+		if strings.HasPrefix(cs.Cache, "test_") {
+			found = append(found, cs)
+		}
+	}
+	if len(found) != 2 || found[0].Cache != "test_a" || found[1].Cache != "test_b" {
+		t.Fatalf("unexpected cache statistics: %+v", found)
+	}
+	if found[1].Hits != 2 || found[1].Misses != 1 || found[1].HitRatio < 0.66 || found[1].HitRatio > 0.67 {
+		t.Errorf("unexpected statistics of test_b: %+v", found[1])
+	}
+	if found[0].HitRatio != 0 {
+		t.Errorf("unexpected hit ratio of test_a: %v", found[0].HitRatio)
+	}
+}
+
+func TestStatusReport(t *testing.T) {
+	mas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"gpaths": []}`))
+	}))
+	defer mas.Close()
+	masAddress := strings.TrimPrefix(mas.URL, "http://")
+
+	confMap := map[string]*Config{
+		"a": {
+			ServiceConfig: ServiceConfig{MASAddress: masAddress, WorkerNodes: []string{"127.0.0.1:1"}},
+			Layers: []Layer{
+				{Name: "bad", DataSource: "/g/data/a", RGBProducts: []string{"b1"}, StartISODate: "2020-01-01"},
+				{Name: "old", DataSource: "/g/data/a", RGBProducts: []string{"b1"}, Deprecation: &LayerDeprecation{Sunset: "2030-01-01"}},
+			},
+		},
+		"b": {
+			ServiceConfig: ServiceConfig{MASAddress: masAddress, WorkerNodes: []string{"127.0.0.1:1"}},
+		},
+	}
+
+	report := NewStatusReport(confMap, false, 200*time.Millisecond)
+	if len(report.Layers) != 2 {
+		t.Fatalf("expected 2 layers, got %+v", report.Layers)
+	}
+	if bad := report.Layers[0]; bad.Healthy || len(bad.Issues) != 1 || !strings.Contains(bad.Issues[0], "start_isodate") {
+		t.Errorf("unexpected status of layer bad: %+v", bad)
+	}
+	if old := report.Layers[1]; !old.Healthy || !old.Deprecated || old.Sunset != "2030-01-01" {
+		t.Errorf("unexpected status of layer old: %+v", old)
+	}
+
+	if len(report.MAS) != 1 || !report.MAS[0].Reachable {
+		t.Errorf("unexpected MAS status: %+v", report.MAS)
+	}
+	if len(report.Workers) != 1 || report.Workers[0].Reachable || len(report.Workers[0].Error) == 0 {
+		t.Errorf("unexpected worker status: %+v", report.Workers)
+	}
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Temporal cadences of layers
+const (
+	TimeResolutionHourly  = "hourly"
+	TimeResolutionDaily   = "daily"
+	TimeResolutionDekadal = "dekadal"
+	TimeResolutionMonthly = "monthly"
+	TimeResolutionYearly  = "yearly"
+)
+
+// Rounding modes of the requested TIME values
+const (
+	TimeRoundingNone    = "none"
+	TimeRoundingFloor   = "floor"
+	TimeRoundingCeil    = "ceil"
+	TimeRoundingNearest = "nearest"
+	TimeRoundingSnap    = "snap"
+)
+
+func validateTimeResolution(layer *Layer) error {
+	switch layer.TimeResolution {
+	case "", TimeResolutionHourly, TimeResolutionDaily, TimeResolutionDekadal, TimeResolutionMonthly, TimeResolutionYearly:
+	default:
+		return fmt.Errorf("Layer %v: unsupported time_resolution: %v", layer.Name, layer.TimeResolution)
+	}
+
+	switch layer.TimeRounding {
+	case "", TimeRoundingNone, TimeRoundingSnap:
+	case TimeRoundingFloor, TimeRoundingCeil, TimeRoundingNearest:
+		if len(layer.TimeResolution) == 0 {
+			return fmt.Errorf("Layer %v: time_rounding %v requires time_resolution", layer.Name, layer.TimeRounding)
+		}
+	default:
+		return fmt.Errorf("Layer %v: unsupported time_rounding: %v", layer.Name, layer.TimeRounding)
+	}
+	return nil
+}
+
+// timePeriodStart returns the start of the period of the given
+// resolution containing t. Dekads start on the 1st, 11th and 21st day
+// of the month.
+func timePeriodStart(t time.Time, resolution string) time.Time {
+	t = t.UTC()
+	switch resolution {
+	case TimeResolutionHourly:
+		return t.Truncate(time.Hour)
+	case TimeResolutionDaily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case TimeResolutionDekadal:
+		day := 1
+		if t.Day() > 20 {
+			day = 21
+		} else if t.Day() > 10 {
+			day = 11
+		}
+		return time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, time.UTC)
+	case TimeResolutionMonthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case TimeResolutionYearly:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t
+}
+
+// nextTimePeriod returns the start of the period following the one
+// starting at start.
+func nextTimePeriod(start time.Time, resolution string) time.Time {
+	switch resolution {
+	case TimeResolutionHourly:
+		return start.Add(time.Hour)
+	case TimeResolutionDaily:
+		return start.AddDate(0, 0, 1)
+	case TimeResolutionDekadal:
+		if start.Day() == 21 {
+			return time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		return start.AddDate(0, 0, 10)
+	case TimeResolutionMonthly:
+		return start.AddDate(0, 1, 0)
+	case TimeResolutionYearly:
+		return start.AddDate(1, 0, 0)
+	}
+	return start
+}
+
+// RoundTime maps a requested TIME value to the time slice intended by
+// the client according to the time_resolution and time_rounding of the
+// layer. With the snap rounding, the time is snapped to the nearest
+// available date of the layer, within one period of the time resolution
+// if configured.
+func (layer *Layer) RoundTime(t time.Time) time.Time {
+	switch layer.TimeRounding {
+	case TimeRoundingFloor:
+		return timePeriodStart(t, layer.TimeResolution)
+	case TimeRoundingCeil:
+		start := timePeriodStart(t, layer.TimeResolution)
+		if start.Equal(t) {
+			return start
+		}
+		return nextTimePeriod(start, layer.TimeResolution)
+	case TimeRoundingNearest:
+		start := timePeriodStart(t, layer.TimeResolution)
+		next := nextTimePeriod(start, layer.TimeResolution)
+		if next.Sub(t) < t.Sub(start) {
+			return next
+		}
+		return start
+	case TimeRoundingSnap:
+		return layer.snapTime(t)
+	}
+	return t
+}
+
+func (layer *Layer) snapTime(t time.Time) time.Time {
+	dates := layer.Dates
+	if len(dates) == 0 {
+		return t
+	}
+
+	idx := sort.Search(len(dates), func(i int) bool {
+		d, err := time.Parse(ISOFormat, dates[i])
+		return err == nil && !d.Before(t)
+	})
+
+	var nearest time.Time
+	found := false
+	for _, i := range []int{idx - 1, idx} {
+		if i < 0 || i >= len(dates) {
+			continue
+		}
+		d, err := time.Parse(ISOFormat, dates[i])
+		if err != nil {
+			continue
+		}
+		if !found || absDuration(d.Sub(t)) < absDuration(nearest.Sub(t)) {
+			nearest = d
+			found = true
+		}
+	}
+	if !found {
+		return t
+	}
+
+	if len(layer.TimeResolution) > 0 {
+		start := timePeriodStart(t, layer.TimeResolution)
+		tolerance := nextTimePeriod(start, layer.TimeResolution).Sub(start)
+		if absDuration(nearest.Sub(t)) > tolerance {
+			return t
+		}
+	}
+	return nearest
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoundTime(t *testing.T) {
+	parse := func(s string) time.Time {
+		tm, err := time.Parse(ISOFormat, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		resolution string
+		rounding   string
+		in         string
+		out        string
+	}{
+		{"", "", "2021-03-15T10:20:00.000Z", "2021-03-15T10:20:00.000Z"},
+		{TimeResolutionHourly, TimeRoundingNearest, "2021-03-15T10:40:00.000Z", "2021-03-15T11:00:00.000Z"},
+		{TimeResolutionDaily, TimeRoundingFloor, "2021-03-15T23:59:59.000Z", "2021-03-15T00:00:00.000Z"},
+		{TimeResolutionDaily, TimeRoundingCeil, "2021-03-15T00:00:00.000Z", "2021-03-15T00:00:00.000Z"},
+		{TimeResolutionDaily, TimeRoundingCeil, "2021-03-15T00:00:01.000Z", "2021-03-16T00:00:00.000Z"},
+		{TimeResolutionDekadal, TimeRoundingFloor, "2021-03-15T12:00:00.000Z", "2021-03-11T00:00:00.000Z"},
+		{TimeResolutionDekadal, TimeRoundingCeil, "2021-03-25T12:00:00.000Z", "2021-04-01T00:00:00.000Z"},
+		{TimeResolutionDekadal, TimeRoundingNearest, "2021-02-27T00:00:00.000Z", "2021-03-01T00:00:00.000Z"},
+		{TimeResolutionMonthly, TimeRoundingFloor, "2021-12-31T12:00:00.000Z", "2021-12-01T00:00:00.000Z"},
+		{TimeResolutionMonthly, TimeRoundingNearest, "2021-12-31T12:00:00.000Z", "2022-01-01T00:00:00.000Z"},
+		{TimeResolutionYearly, TimeRoundingFloor, "2021-06-30T00:00:00.000Z", "2021-01-01T00:00:00.000Z"},
+	}
+
+	for _, test := range tests {
+		layer := &Layer{Name: "test", TimeResolution: test.resolution, TimeRounding: test.rounding}
+		if err := validateTimeResolution(layer); err != nil {
+			t.Fatal(err)
+		}
+		if out := layer.RoundTime(parse(test.in)); !out.Equal(parse(test.out)) {
+			t.Errorf("%s %s %s: expected %s, got %s", test.resolution, test.rounding, test.in, test.out, out.Format(ISOFormat))
+		}
+	}
+
+	layer := &Layer{Name: "test", TimeResolution: TimeResolutionDaily, TimeRounding: TimeRoundingSnap,
+		Dates: []string{"2021-03-01T12:00:00.000Z", "2021-03-02T12:00:00.000Z", "2021-03-10T12:00:00.000Z"}}
+	if out := layer.RoundTime(parse("2021-03-02T03:00:00.000Z")); !out.Equal(parse("2021-03-02T12:00:00.000Z")) {
+		t.Errorf("expected snap to 2021-03-02T12:00:00.000Z, got %s", out.Format(ISOFormat))
+	}
+	// beyond one day of the nearest date
+	if out := layer.RoundTime(parse("2021-03-06T00:00:00.000Z")); !out.Equal(parse("2021-03-06T00:00:00.000Z")) {
+		t.Errorf("expected no snapping, got %s", out.Format(ISOFormat))
+	}
+
+	if err := validateTimeResolution(&Layer{TimeRounding: TimeRoundingFloor}); err == nil {
+		t.Errorf("expected error for floor rounding without time_resolution")
+	}
+	if err := validateTimeResolution(&Layer{TimeResolution: "weekly"}); err == nil {
+		t.Errorf("expected error for unsupported time_resolution")
+	}
+}
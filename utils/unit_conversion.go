@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UnitConversion converts the band values of a layer from the source
+// units to the display units as value * scale + offset. The conversion
+// applies before styling, i.e. the offset, scale and clip values of the
+// layer are in the display units.
+type UnitConversion struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Scale and Offset are looked up from From and To for the known
+	// conversions if both are 0.
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+type unitConversionFactor struct {
+	scale  float64
+	offset float64
+}
+
+// knownUnitConversions are keyed by the normalised source and display
+// units
+var knownUnitConversions = map[[2]string]unitConversionFactor{
+	{"k", "degc"}:                  {1, -273.15},
+	{"k", "degf"}:                  {1.8, -459.67},
+	{"degc", "k"}:                  {1, 273.15},
+	{"degc", "degf"}:               {1.8, 32},
+	{"degf", "degc"}:               {5.0 / 9.0, -160.0 / 9.0},
+	{"kg m-2 s-1", "mm/day"}:       {86400, 0},
+	{"kg m-2 s-1", "mm/hour"}:      {3600, 0},
+	{"kg m-2 s-1", "mm/month"}:     {86400 * 30, 0},
+	{"mm/hour", "mm/day"}:          {24, 0},
+	{"m", "mm"}:                    {1000, 0},
+	{"m", "cm"}:                    {100, 0},
+	{"m", "km"}:                    {0.001, 0},
+	{"m s-1", "km/h"}:              {3.6, 0},
+	{"m s-1", "knots"}:             {1.0 / 0.514444, 0},
+	{"pa", "hpa"}:                  {0.01, 0},
+	{"kg kg-1", "g/kg"}:            {1000, 0},
+	{"1", "%"}:                     {100, 0},
+	{"fraction", "%"}:              {100, 0},
+	{"kg m-2", "mm"}:               {1, 0},
+	{"j m-2", "kwh m-2"}:           {1.0 / 3.6e6, 0},
+	{"w m-2", "mj m-2 day-1"}:      {0.0864, 0},
+	{"kg m-2 s-1", "kg m-2 day-1"}: {86400, 0},
+}
+
+// normaliseUnits maps the common spellings of units to the keys of
+// knownUnitConversions.
+func normaliseUnits(units string) string {
+	u := strings.ToLower(strings.TrimSpace(units))
+	u = strings.Join(strings.Fields(u), " ")
+	switch u {
+	case "kelvin", "degk", "°k":
+		return "k"
+	case "c", "°c", "deg_c", "degrees_c", "degree_celsius", "celsius", "deg c":
+		return "degc"
+	case "f", "°f", "deg_f", "degrees_f", "fahrenheit", "deg f":
+		return "degf"
+	case "kg/m2/s", "kg m^-2 s^-1", "kg/m^2/s":
+		return "kg m-2 s-1"
+	case "mm d-1", "mm day-1", "mm/d":
+		return "mm/day"
+	case "mm h-1", "mm hr-1", "mm/h", "mm/hr":
+		return "mm/hour"
+	case "m/s", "m s^-1":
+		return "m s-1"
+	case "km h-1", "kmh", "kph":
+		return "km/h"
+	case "kt", "kts", "knot":
+		return "knots"
+	case "mb", "mbar", "millibar":
+		return "hpa"
+	case "g kg-1":
+		return "g/kg"
+	case "kg/m2", "kg m^-2":
+		return "kg m-2"
+	}
+	return u
+}
+
+// resolve looks up the scale and offset of the known conversions if
+// they are not given explicitly.
+func (uc *UnitConversion) resolve() error {
+	if uc.Scale != 0 || uc.Offset != 0 {
+		if uc.Scale == 0 {
+			uc.Scale = 1
+		}
+		return nil
+	}
+
+	factor, found := knownUnitConversions[[2]string{normaliseUnits(uc.From), normaliseUnits(uc.To)}]
+	if !found {
+		return fmt.Errorf("unknown unit conversion from '%s' to '%s', scale and offset must be specified", uc.From, uc.To)
+	}
+	uc.Scale = factor.scale
+	uc.Offset = factor.offset
+	return nil
+}
+
+// ConvertBands rewrites band expressions so that their values are in
+// the display units. The output band names are preserved.
+func (uc *UnitConversion) ConvertBands(bands []string) []string {
+	if uc == nil {
+		return bands
+	}
+
+	converted := make([]string, len(bands))
+	for i, band := range bands {
+		name := strings.TrimSpace(band)
+		expr := name
+		if parts := strings.SplitN(band, "=", 2); len(parts) == 2 {
+			name = strings.TrimSpace(parts[0])
+			expr = strings.TrimSpace(parts[1])
+		}
+
+		expr = fmt.Sprintf("(%s) * %s", expr, strconv.FormatFloat(uc.Scale, 'f', -1, 64))
+		if uc.Offset > 0 {
+			expr += " + " + strconv.FormatFloat(uc.Offset, 'f', -1, 64)
+		} else if uc.Offset < 0 {
+			expr += " - " + strconv.FormatFloat(-uc.Offset, 'f', -1, 64)
+		}
+		converted[i] = name + "=" + expr
+	}
+	return converted
+}
+
+// ConvertValue converts a value from the source units to the display
+// units.
+func (uc *UnitConversion) ConvertValue(value float64) float64 {
+	if uc == nil {
+		return value
+	}
+	return value*uc.Scale + uc.Offset
+}
+
+// DisplayUnits returns the units of the values served for the layer.
+// The units of the legend config take precedence over the units of the
+// unit conversion.
+func (layer *Layer) DisplayUnits() string {
+	if layer.Legend != nil && len(layer.Legend.Units) > 0 {
+		return layer.Legend.Units
+	}
+	if layer.UnitConversion == nil {
+		return ""
+	}
+	return layer.UnitConversion.To
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"math"
+	"testing"
+
+	goeval "github.com/edisonguo/govaluate"
+)
+
+func TestUnitConversion(t *testing.T) {
+	uc := &UnitConversion{From: "K", To: "°C"}
+	if err := uc.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	if uc.Scale != 1 || uc.Offset != -273.15 {
+		t.Errorf("unexpected K to °C conversion: %+v", uc)
+	}
+
+	bands := uc.ConvertBands([]string{"tas", "diff = tasmax - tasmin"})
+	expected := []string{"tas=(tas) * 1 - 273.15", "diff=(tasmax - tasmin) * 1 - 273.15"}
+	for i := range expected {
+		if bands[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], bands[i])
+		}
+	}
+
+	expr, err := goeval.NewEvaluableExpression("(tas) * 1 - 273.15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := expr.Evaluate(map[string]interface{}{"tas": []float32{273.15, 300}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, ok := res.([]float32)
+	if !ok || len(vals) != 2 || math.Abs(float64(vals[0])) > 1e-3 || math.Abs(float64(vals[1])-26.85) > 1e-3 {
+		t.Errorf("unexpected converted values: %v", res)
+	}
+
+	uc = &UnitConversion{From: "kg m-2 s-1", To: "mm/day"}
+	if err := uc.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	if v := uc.ConvertValue(0.0001); math.Abs(v-8.64) > 1e-9 {
+		t.Errorf("expected 8.64 mm/day, got %v", v)
+	}
+
+	uc = &UnitConversion{From: "furlong", To: "mm"}
+	if err := uc.resolve(); err == nil {
+		t.Errorf("expected error for unknown conversion")
+	}
+
+	uc = &UnitConversion{From: "furlong", To: "m", Scale: 201.168}
+	if err := uc.resolve(); err != nil || uc.Scale != 201.168 || uc.Offset != 0 {
+		t.Errorf("unexpected explicit conversion: %+v, %v", uc, err)
+	}
+
+	var nilConv *UnitConversion
+	if bands := nilConv.ConvertBands([]string{"tas"}); bands[0] != "tas" {
+		t.Errorf("expected bands unchanged without conversion")
+	}
+}
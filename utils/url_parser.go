@@ -145,10 +145,12 @@ func ParseRemoteAddr(r *http.Request) string {
 	return r.RemoteAddr
 }
 
+// ParseRequestProtocol returns http or https, as given by the
+// X-Forwarded-Proto header if set to either.
 func ParseRequestProtocol(r *http.Request) string {
 	protocol := r.Header.Get("X-Forwarded-Proto")
-	protocol = strings.TrimSpace(protocol)
-	if len(protocol) == 0 {
+	protocol = strings.ToLower(strings.TrimSpace(protocol))
+	if protocol != "http" && protocol != "https" {
 		if r.TLS == nil {
 			protocol = "http"
 		} else {
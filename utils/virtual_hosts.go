@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+// ServiceMetadata is the service description advertised by
+// GetCapabilities. The built-in GSKY description is used for the fields
+// not set.
+type ServiceMetadata struct {
+	Title               string `json:"title"`
+	Abstract            string `json:"abstract"`
+	ContactOrganization string `json:"contact_organization"`
+	ContactPerson       string `json:"contact_person"`
+	ContactEmail        string `json:"contact_email"`
+}
+
+// VirtualHost maps the requests for a set of hostnames to a namespace
+// of the config tree so that a single deployment can serve different
+// catalogs depending on the Host header.
+type VirtualHost struct {
+	// Hosts are the hostnames served by the virtual host. A leading
+	// "*." matches any subdomain.
+	Hosts []string `json:"hosts"`
+	// NameSpace is the namespace serving /ows for these hosts. The
+	// namespaces in the request URL are resolved under it.
+	NameSpace string `json:"namespace"`
+	// Restricted namespaces are only served to the hosts of the
+	// virtual host.
+	Restricted  bool   `json:"restricted"`
+	OWSHostname string `json:"ows_hostname"`
+	OWSProtocol string `json:"ows_protocol"`
+	ServiceMetadata
+}
+
+func normaliseHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+func (vh *VirtualHost) validate() error {
+	if len(vh.Hosts) == 0 {
+		return fmt.Errorf("virtual host must have at least one host")
+	}
+	ns := path.Clean(vh.NameSpace)
+	if path.IsAbs(ns) || strings.HasPrefix(ns, "..") {
+		return fmt.Errorf("invalid virtual host namespace: %v", vh.NameSpace)
+	}
+	vh.NameSpace = ns
+	return nil
+}
+
+// Matches reports whether the virtual host serves host, which may
+// include a port.
+func (vh *VirtualHost) Matches(host string) bool {
+	host = normaliseHost(host)
+	for _, h := range vh.Hosts {
+		h = normaliseHost(h)
+		if strings.HasPrefix(h, "*.") {
+			if strings.HasSuffix(host, h[1:]) {
+				return true
+			}
+		} else if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveNameSpace maps the namespace of the request URL to a namespace
+// of the config tree. The URL namespace may also be given relative to
+// the root of the config tree as advertised by GetCapabilities.
+func (vh *VirtualHost) ResolveNameSpace(namespace string) (string, error) {
+	ns := path.Clean(namespace)
+	if path.IsAbs(ns) || strings.HasPrefix(ns, "..") {
+		return "", fmt.Errorf("invalid namespace: %v", namespace)
+	}
+	if vh.containsNameSpace(ns) {
+		return ns, nil
+	}
+	if ns == "." {
+		return vh.NameSpace, nil
+	}
+	return vh.NameSpace + "/" + ns, nil
+}
+
+func (vh *VirtualHost) containsNameSpace(namespace string) bool {
+	return vh.NameSpace == "." || namespace == vh.NameSpace || strings.HasPrefix(namespace, vh.NameSpace+"/")
+}
+
+// FindVirtualHost returns the virtual host serving host or nil if none.
+func (sc *ServiceConfig) FindVirtualHost(host string) *VirtualHost {
+	for _, vh := range sc.VirtualHosts {
+		if vh.Matches(host) {
+			return vh
+		}
+	}
+	return nil
+}
+
+// IsNameSpaceAllowed reports whether namespace may be served to the
+// requests of vhost, which is nil for the hosts without virtual host.
+func (sc *ServiceConfig) IsNameSpaceAllowed(namespace string, vhost *VirtualHost) bool {
+	for _, vh := range sc.VirtualHosts {
+		if vh.Restricted && vh != vhost && vh.containsNameSpace(namespace) {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyVirtualHost returns a shallow copy of config with the service
+// metadata, hostname and protocol of the virtual host.
+func ApplyVirtualHost(config *Config, vhost *VirtualHost) *Config {
+	if vhost == nil || config == nil {
+		return config
+	}
+	newConf := *config
+	// the Host header of the request is advertised if not configured
+	newConf.ServiceConfig.OWSHostname = vhost.OWSHostname
+	if len(vhost.OWSProtocol) > 0 {
+		newConf.ServiceConfig.OWSProtocol = vhost.OWSProtocol
+	}
+
+	md := &newConf.ServiceConfig.ServiceMetadata
+	if len(vhost.Title) > 0 {
+		md.Title = vhost.Title
+	}
+	if len(vhost.Abstract) > 0 {
+		md.Abstract = vhost.Abstract
+	}
+	if len(vhost.ContactOrganization) > 0 {
+		md.ContactOrganization = vhost.ContactOrganization
+	}
+	if len(vhost.ContactPerson) > 0 {
+		md.ContactPerson = vhost.ContactPerson
+	}
+	if len(vhost.ContactEmail) > 0 {
+		md.ContactEmail = vhost.ContactEmail
+	}
+	return &newConf
+}
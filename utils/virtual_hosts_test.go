@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	internal := &VirtualHost{
+		Hosts:           []string{"internal.example.org", "*.intra.example.org"},
+		NameSpace:       "internal/",
+		Restricted:      true,
+		ServiceMetadata: ServiceMetadata{Title: "Internal catalog"},
+	}
+	public := &VirtualHost{
+		Hosts:     []string{"maps.example.org"},
+		NameSpace: "public",
+	}
+	sc := &ServiceConfig{VirtualHosts: []*VirtualHost{internal, public}}
+	for _, vh := range sc.VirtualHosts {
+		if err := vh.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hosts := map[string]*VirtualHost{
+		"internal.example.org":      internal,
+		"INTERNAL.example.org:8080": internal,
+		"gis.intra.example.org":     internal,
+		"intra.example.org":         nil,
+		"maps.example.org":          public,
+		"other.example.org":         nil,
+	}
+	for host, expected := range hosts {
+		if vh := sc.FindVirtualHost(host); vh != expected {
+			t.Errorf("host %v: unexpected virtual host %v", host, vh)
+		}
+	}
+
+	namespaces := map[string]string{
+		".":               "internal",
+		"":                "internal",
+		"sub":             "internal/sub",
+		"internal":        "internal",
+		"internal/sub/x/": "internal/sub/x",
+	}
+	for in, out := range namespaces {
+		ns, err := internal.ResolveNameSpace(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ns != out {
+			t.Errorf("namespace %v: expected %v, got %v", in, out, ns)
+		}
+	}
+	for _, in := range []string{"../public", "sub/../../public", "/etc"} {
+		if _, err := internal.ResolveNameSpace(in); err == nil {
+			t.Errorf("namespace %v: expected error", in)
+		}
+	}
+
+	if sc.IsNameSpaceAllowed("internal/sub", nil) || sc.IsNameSpaceAllowed("internal", public) {
+		t.Errorf("restricted namespace served to other hosts")
+	}
+	if !sc.IsNameSpaceAllowed("internal/sub", internal) || !sc.IsNameSpaceAllowed("internals", nil) || !sc.IsNameSpaceAllowed("public", nil) {
+		t.Errorf("namespace wrongly restricted")
+	}
+
+	conf := &Config{ServiceConfig: ServiceConfig{OWSHostname: "gsky.example.org", ServiceMetadata: ServiceMetadata{Title: "GSKY", ContactPerson: "Ops"}}}
+	vConf := ApplyVirtualHost(conf, internal)
+	if vConf.ServiceConfig.Title != "Internal catalog" || vConf.ServiceConfig.ContactPerson != "Ops" || len(vConf.ServiceConfig.OWSHostname) != 0 {
+		t.Errorf("unexpected service config: %+v", vConf.ServiceConfig)
+	}
+	if conf.ServiceConfig.Title != "GSKY" {
+		t.Errorf("shared config modified")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -88,6 +89,7 @@ var WMSRegexpMap = map[string]string{"service": `^WMS$`,
 	"y":       `^[0-9]+$`,
 	"width":   `^[0-9]+$`,
 	"height":  `^[0-9]+$`,
+	"format":  `^[A-Za-z0-9_.+/-]+$`,
 	"axis":    `^[A-Za-z_][A-Za-z0-9_]*$`,
 	"time":    `^\d{4}-(?:1[0-2]|0[1-9])-(?:3[01]|0[1-9]|[12][0-9])T[0-2]\d:[0-5]\d:[0-5]\d(Z|\.\d+Z)$`}
 
@@ -190,7 +192,9 @@ func getFeatureGeometryFromVectorFile(wmsClipconfig WmsClipConfig, clipParam str
 		buff := md5.Sum([]byte(pStr))
 		hash = hex.EncodeToString(buff[:])
 
-		if cached, ok := mc.Get(hash); ok == nil {
+		cached, ok := mc.Get(hash)
+		RecordCacheLookup("clip", ok == nil)
+		if ok == nil {
 			geomWKT := cached.Value
 
 			feat, err := wktToFeature(string(geomWKT))
@@ -365,6 +369,12 @@ func WMSParamsChecker(params map[string][]string, compREMap map[string]*regexp.R
 		}
 	}
 
+	if format, formatOK := params["format"]; formatOK {
+		if compREMap["format"].MatchString(format[0]) {
+			jsonFields = append(jsonFields, fmt.Sprintf(`"format":"%s"`, format[0]))
+		}
+	}
+
 	if clipFeature, clipFeatureOk := params["clip_feature"]; clipFeatureOk && clipFeature[0] != "" {
 		jsonFields = append(jsonFields, fmt.Sprintf(`"clip_feature":"%s"`, clipFeature[0]))
 	}
@@ -727,6 +737,14 @@ func GetCanonicalBbox(srs string, bbox []float64) ([]float64, error) {
 		return box, nil
 	}
 
+	// The edges of rotated and conic grids are curves in the canonical
+	// CRS whose extents are not given by the corners alone.
+	nEdgePoints := 1
+	if IsCustomCRS(srs) {
+		srs = ResolveCRS(srs)
+		nEdgePoints = customCRSEdgePoints
+	}
+
 	var opts []*C.char
 	opts = append(opts, C.CString(fmt.Sprintf("SRC_SRS=%s", srs)))
 	opts = append(opts, C.CString(fmt.Sprintf("DST_SRS=%s", dst)))
@@ -740,6 +758,10 @@ func GetCanonicalBbox(srs string, bbox []float64) ([]float64, error) {
 	}
 	defer C.GDALDestroyGenImgProjTransformer(transformArg)
 
+	if nEdgePoints > 1 {
+		return transformBboxEdges(transformArg, bbox, nEdgePoints)
+	}
+
 	dx := []C.double{C.double(bbox[0]), C.double(bbox[2])}
 	dy := []C.double{C.double(bbox[1]), C.double(bbox[3])}
 	dz := make([]C.double, 2)
@@ -753,6 +775,44 @@ func GetCanonicalBbox(srs string, bbox []float64) ([]float64, error) {
 	}
 }
 
+const customCRSEdgePoints = 21
+
+// transformBboxEdges returns the extent of the bbox edges sampled at
+// nPoints points each.
+func transformBboxEdges(transformArg unsafe.Pointer, bbox []float64, nPoints int) ([]float64, error) {
+	var dx, dy []C.double
+	for i := 0; i < nPoints; i++ {
+		fx := bbox[0] + (bbox[2]-bbox[0])*float64(i)/float64(nPoints-1)
+		fy := bbox[1] + (bbox[3]-bbox[1])*float64(i)/float64(nPoints-1)
+		dx = append(dx, C.double(fx), C.double(fx), C.double(bbox[0]), C.double(bbox[2]))
+		dy = append(dy, C.double(bbox[1]), C.double(bbox[3]), C.double(fy), C.double(fy))
+	}
+	dz := make([]C.double, len(dx))
+	bSuccess := make([]C.int, len(dx))
+
+	C.GDALGenImgProjTransform(transformArg, C.int(0), C.int(len(dx)), &dx[0], &dy[0], &dz[0], &bSuccess[0])
+
+	var box []float64
+	for i := range dx {
+		if bSuccess[i] == 0 {
+			continue
+		}
+		x, y := float64(dx[i]), float64(dy[i])
+		if box == nil {
+			box = []float64{x, y, x, y}
+			continue
+		}
+		box[0] = math.Min(box[0], x)
+		box[1] = math.Min(box[1], y)
+		box[2] = math.Max(box[2], x)
+		box[3] = math.Max(box[3], y)
+	}
+	if box == nil {
+		return bbox, fmt.Errorf("GDALGenImgProjTransform failed")
+	}
+	return box, nil
+}
+
 func GetPixelResolution(bbox []float64, width int, height int) float64 {
 	xRes := (bbox[2] - bbox[0]) / float64(width)
 	yRes := (bbox[3] - bbox[1]) / float64(height)
@@ -0,0 +1,11 @@
+package gdalprocess
+
+// #include "gdal.h"
+// #cgo pkg-config: gdal
+import "C"
+
+// GDALCacheUsed returns the number of bytes currently held by the GDAL
+// raster block cache of this process.
+func GDALCacheUsed() int64 {
+	return int64(C.GDALGetCacheUsed64())
+}
@@ -0,0 +1,47 @@
+package gdalprocess
+
+// #include <stdlib.h>
+// #include "gdal.h"
+// #include "cpl_string.h"
+// #cgo pkg-config: gdal
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	pb "github.com/nci/gsky/worker/gdalservice"
+)
+
+// OpenDataset opens the dataset and reads the first block of its first
+// band. It has no output other than its side effects on the file system
+// and GDAL caches and is used to warm up worker processes.
+func OpenDataset(in *pb.GeoRPCGranule) *pb.Result {
+	cPath := C.CString(in.Path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	hDataset := C.GDALOpen(cPath, C.GA_ReadOnly)
+	if hDataset == nil {
+		err := C.CPLGetLastErrorMsg()
+		return &pb.Result{Error: fmt.Sprintf("GDAL Dataset is null %v: Error: %s", in.Path, C.GoString(err))}
+	}
+	defer C.GDALClose(hDataset)
+
+	if C.GDALGetRasterCount(hDataset) > 0 {
+		hBand := C.GDALGetRasterBand(hDataset, 1)
+
+		var blockXSize, blockYSize C.int
+		C.GDALGetBlockSize(hBand, &blockXSize, &blockYSize)
+		dType := C.GDALGetRasterDataType(hBand)
+		dSize := C.GDALGetDataTypeSizeBytes(dType)
+
+		bufSize := C.size_t(blockXSize) * C.size_t(blockYSize) * C.size_t(dSize)
+		if bufSize > 0 {
+			buf := C.malloc(bufSize)
+			defer C.free(buf)
+			C.GDALReadBlock(hBand, 0, 0, buf)
+		}
+	}
+
+	return &pb.Result{Error: "OK"}
+}
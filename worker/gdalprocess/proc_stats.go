@@ -0,0 +1,109 @@
+package gdalprocess
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// clockTicks is the USER_HZ value used by /proc/<pid>/stat, which is 100
+// on all the Linux platforms GSKY is deployed to.
+const clockTicks = 100
+
+// ProcStats holds the resource usage of a gsky-gdal-process subprocess.
+type ProcStats struct {
+	Pid           int
+	CPUSeconds    float64
+	RSSBytes      int64
+	OpenFiles     int
+	GdalCacheUsed int64
+}
+
+// Stats reads the resource usage of the subprocess from /proc.
+func (p *Process) Stats() (*ProcStats, error) {
+	if p.Cmd == nil || p.Cmd.Process == nil {
+		return nil, fmt.Errorf("process not started")
+	}
+
+	stats, err := ReadProcStats(p.Cmd.Process.Pid)
+	if err != nil {
+		return nil, err
+	}
+	stats.GdalCacheUsed = atomic.LoadInt64(&p.GdalCacheUsed)
+	return stats, nil
+}
+
+// ReadProcStats reads CPU time, resident memory and the number of open
+// file descriptors of pid from /proc.
+func ReadProcStats(pid int) (*ProcStats, error) {
+	stats := &ProcStats{Pid: pid}
+
+	statBytes, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	cpu, err := parseProcStatCPU(string(statBytes))
+	if err != nil {
+		return nil, err
+	}
+	stats.CPUSeconds = cpu
+
+	statusBytes, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	stats.RSSBytes = parseProcStatusRSS(string(statusBytes))
+
+	fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err == nil {
+		stats.OpenFiles = len(fds)
+	}
+
+	return stats, nil
+}
+
+// parseProcStatCPU returns utime + stime in seconds from the content of
+// /proc/<pid>/stat. The command name in the second field may contain
+// spaces so the remaining fields are located after the last ')'.
+func parseProcStatCPU(stat string) (float64, error) {
+	idx := strings.LastIndex(stat, ")")
+	if idx < 0 {
+		return 0, fmt.Errorf("invalid stat format")
+	}
+
+	// fields after the command name start at field 3 (state)
+	fields := strings.Fields(stat[idx+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("invalid stat format")
+	}
+
+	utime, err := strconv.ParseFloat(fields[11], 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseFloat(fields[12], 64)
+	if err != nil {
+		return 0, err
+	}
+	return (utime + stime) / clockTicks, nil
+}
+
+func parseProcStatusRSS(status string) int64 {
+	for _, line := range strings.Split(status, "\n") {
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return 0
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -13,8 +14,10 @@ import (
 	"bytes"
 	"io"
 	"log"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/nci/gsky/logging"
 	pb "github.com/nci/gsky/worker/gdalservice"
 )
 
@@ -40,6 +43,7 @@ type Process struct {
 	MaxTaskProcessed int
 	ErrorMsg         chan *ErrorMsg
 	Verbose          bool
+	GdalCacheUsed    int64
 }
 
 func NewProcess(tQueue chan *Task, binary string, port int, errChan chan *ErrorMsg, maxTaskProcessed int, verbose bool) *Process {
@@ -69,7 +73,7 @@ func NewProcess(tQueue chan *Task, binary string, port int, errChan chan *ErrorM
 		cmd.Stdout = cmd.Stderr
 	}
 
-	return &Process{tQueue, addr, tmpFileName, cmd, combinedOutput, maxTaskProcessed, errChan, verbose}
+	return &Process{tQueue, addr, tmpFileName, cmd, combinedOutput, maxTaskProcessed, errChan, verbose, 0}
 }
 
 func (p *Process) Start() error {
@@ -149,6 +153,10 @@ func (p *Process) Start() error {
 				break
 			}
 
+			if out.Metrics != nil {
+				atomic.StoreInt64(&p.GdalCacheUsed, out.Metrics.GdalCacheUsed)
+			}
+
 			task.Resp <- out
 
 			taskProcessed++
@@ -165,6 +173,7 @@ func (p *Process) Start() error {
 
 		// relay subprocess stderr and stdout to our stdout, with pid
 		if p.CombinedOutput != nil {
+			procLog := logging.New("gdal-process").With("pid", p.Cmd.Process.Pid)
 			reader := bufio.NewReader(p.CombinedOutput)
 			for {
 				line, err := reader.ReadString('\n')
@@ -172,7 +181,7 @@ func (p *Process) Start() error {
 					break
 				}
 
-				log.Println(p.Cmd.Process.Pid, line)
+				procLog.Infof("%s", strings.TrimRight(line, "\n"))
 			}
 		}
 
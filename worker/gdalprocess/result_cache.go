@@ -0,0 +1,157 @@
+package gdalprocess
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	pb "github.com/nci/gsky/worker/gdalservice"
+	"google.golang.org/protobuf/proto"
+)
+
+// ResultCache is a short-lived cache of task results keyed by the full
+// task payload, i.e. file, bands, source window and target grid. It lets
+// overlapping concurrent requests such as adjacent WMS tiles sharing the
+// same source granule window at low zoom levels reuse a single read and
+// warp. Identical tasks arriving while the first one is still running
+// wait for its result instead of being processed again.
+type ResultCache struct {
+	ttl      time.Duration
+	maxBytes int64
+
+	mu       sync.Mutex
+	size     int64
+	entries  map[string]*list.Element
+	lru      *list.List
+	inFlight map[string]*resultCall
+	hits     int64
+	misses   int64
+}
+
+type resultEntry struct {
+	key     string
+	res     *pb.Result
+	size    int64
+	expires time.Time
+}
+
+type resultCall struct {
+	done chan struct{}
+	res  *pb.Result
+	err  error
+}
+
+// NewResultCache creates a cache holding results for ttl and up to
+// maxBytes of raster data. Least recently used results are evicted first.
+func NewResultCache(ttl time.Duration, maxBytes int64) *ResultCache {
+	return &ResultCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inFlight: make(map[string]*resultCall),
+	}
+}
+
+// Cacheable reports whether results of the task are cached.
+func (c *ResultCache) Cacheable(in *pb.GeoRPCGranule) bool {
+	return in.Operation == "warp"
+}
+
+// ResultCacheKey returns the cache key of a task.
+func ResultCacheKey(in *pb.GeoRPCGranule) (string, error) {
+	buf, err := proto.MarshalOptions{Deterministic: true}.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Get returns the cached result of the task, or computes it with load.
+// Only successful results are cached. The returned result is shared
+// between callers and must not be modified.
+func (c *ResultCache) Get(in *pb.GeoRPCGranule, load func() (*pb.Result, error)) (*pb.Result, error) {
+	key, err := ResultCacheKey(in)
+	if err != nil {
+		return load()
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	if elem, found := c.entries[key]; found {
+		entry := elem.Value.(*resultEntry)
+		if now.Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.hits++
+			c.mu.Unlock()
+			return entry.res, nil
+		}
+		c.removeElement(elem)
+	}
+
+	if call, found := c.inFlight[key]; found {
+		c.hits++
+		c.mu.Unlock()
+		<-call.done
+		return call.res, call.err
+	}
+
+	call := &resultCall{done: make(chan struct{})}
+	c.inFlight[key] = call
+	c.misses++
+	c.mu.Unlock()
+
+	call.res, call.err = load()
+
+	c.mu.Lock()
+	delete(c.inFlight, key)
+	if call.err == nil && call.res != nil && call.res.Error == "OK" {
+		c.add(key, call.res)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.res, call.err
+}
+
+func (c *ResultCache) add(key string, res *pb.Result) {
+	var size int64
+	if res.Raster != nil {
+		size = int64(len(res.Raster.Data))
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	entry := &resultEntry{key: key, res: res, size: size, expires: time.Now().Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+
+	for c.size > c.maxBytes {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *ResultCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*resultEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// Size returns the number of bytes of raster data held by the cache.
+func (c *ResultCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Stats returns the number of cache hits and misses.
+func (c *ResultCache) Stats() (int64, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
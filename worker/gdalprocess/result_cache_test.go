@@ -0,0 +1,94 @@
+package gdalprocess
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/nci/gsky/worker/gdalservice"
+)
+
+func TestResultCache(t *testing.T) {
+	c := NewResultCache(time.Minute, 10)
+
+	var nLoads int32
+	load := func(n int) func() (*pb.Result, error) {
+		return func() (*pb.Result, error) {
+			atomic.AddInt32(&nLoads, 1)
+			time.Sleep(10 * time.Millisecond)
+			return &pb.Result{Raster: &pb.Raster{Data: make([]byte, n)}, Error: "OK"}, nil
+		}
+	}
+
+	g1 := &pb.GeoRPCGranule{Operation: "warp", Path: "/data/a.nc", Bands: []int32{1}, DstGeot: []float64{0, 1, 0, 0, 0, -1}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(g1, load(4)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if nLoads != 1 {
+		t.Errorf("expected identical concurrent tasks to be loaded once, got %d loads", nLoads)
+	}
+	if hits, misses := c.Stats(); hits != 3 || misses != 1 {
+		t.Errorf("unexpected stats: hits=%d, misses=%d", hits, misses)
+	}
+
+	g2 := &pb.GeoRPCGranule{Operation: "warp", Path: "/data/a.nc", Bands: []int32{2}, DstGeot: []float64{0, 1, 0, 0, 0, -1}}
+	c.Get(g2, load(4))
+	if nLoads != 2 {
+		t.Errorf("expected a different band to be loaded, got %d loads", nLoads)
+	}
+
+	g3 := &pb.GeoRPCGranule{Operation: "warp", Path: "/data/b.nc", Bands: []int32{1}}
+	c.Get(g3, load(4))
+	if c.Size() != 8 {
+		t.Errorf("expected the least recently used result to be evicted, cache size: %d", c.Size())
+	}
+
+	c.Get(g1, load(4))
+	if nLoads != 4 {
+		t.Errorf("expected an evicted result to be reloaded, got %d loads", nLoads)
+	}
+
+	c.Get(g3, load(20))
+	if nLoads != 4 {
+		t.Errorf("expected a cached result, got %d loads", nLoads)
+	}
+}
+
+func TestResultCacheExpiry(t *testing.T) {
+	c := NewResultCache(time.Millisecond, 1024)
+	g := &pb.GeoRPCGranule{Operation: "warp", Path: "/data/a.nc"}
+
+	nLoads := 0
+	load := func() (*pb.Result, error) {
+		nLoads++
+		return &pb.Result{Error: "OK"}, nil
+	}
+
+	c.Get(g, load)
+	time.Sleep(5 * time.Millisecond)
+	c.Get(g, load)
+	if nLoads != 2 {
+		t.Errorf("expected expired result to be reloaded, got %d loads", nLoads)
+	}
+
+	failed := func() (*pb.Result, error) {
+		nLoads++
+		return &pb.Result{Error: "failed"}, nil
+	}
+	c = NewResultCache(time.Minute, 1024)
+	c.Get(g, failed)
+	c.Get(g, failed)
+	if nLoads != 4 {
+		t.Errorf("expected failed results not to be cached, got %d loads", nLoads)
+	}
+}
@@ -0,0 +1,173 @@
+package gdalprocess
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/nci/gsky/worker/gdalservice"
+)
+
+// DefaultWarmupTopN is the number of most popular datasets persisted
+// by the WarmupRecorder for replay at the next startup.
+const DefaultWarmupTopN = 200
+
+// LoadWarmupList reads a warm-up file containing one dataset path per
+// line. Empty lines and lines starting with '#' are ignored. Duplicated
+// paths are only returned once.
+func LoadWarmupList(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		paths = append(paths, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// Warmup pre-opens the given datasets through the process pool so that
+// file system caches and GDAL driver state are hot before the worker
+// starts serving live traffic. At most conc datasets are opened
+// concurrently. The number of datasets successfully opened is returned.
+func (p *ProcessPool) Warmup(paths []string, conc int, verbose bool) int {
+	if conc <= 0 {
+		conc = 1
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	nOpened := 0
+	limiter := make(chan struct{}, conc)
+	for _, path := range paths {
+		limiter <- struct{}{}
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			defer func() { <-limiter }()
+
+			rChan := make(chan *pb.Result, 1)
+			errChan := make(chan error, 1)
+			p.AddQueue(&Task{Payload: &pb.GeoRPCGranule{Operation: "open", Path: path}, Resp: rChan, Error: errChan})
+
+			select {
+			case out := <-rChan:
+				if out.Error != "OK" {
+					if verbose {
+						log.Printf("Warmup: failed to open %s: %s", path, out.Error)
+					}
+					return
+				}
+				mu.Lock()
+				nOpened++
+				mu.Unlock()
+			case err := <-errChan:
+				if verbose {
+					log.Printf("Warmup: failed to open %s: %v", path, err)
+				}
+			}
+		}(path)
+	}
+	wg.Wait()
+
+	return nOpened
+}
+
+// WarmupRecorder keeps track of how often datasets are requested so
+// the most popular ones can be replayed by Warmup after a restart.
+type WarmupRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func NewWarmupRecorder() *WarmupRecorder {
+	return &WarmupRecorder{counts: make(map[string]int)}
+}
+
+// Record counts a request against the dataset at path.
+func (r *WarmupRecorder) Record(path string) {
+	if len(path) == 0 {
+		return
+	}
+	r.mu.Lock()
+	r.counts[path]++
+	r.mu.Unlock()
+}
+
+// TopN returns up to n dataset paths ordered by descending popularity.
+func (r *WarmupRecorder) TopN(n int) []string {
+	r.mu.Lock()
+	paths := make([]string, 0, len(r.counts))
+	counts := make(map[string]int, len(r.counts))
+	for path, cnt := range r.counts {
+		paths = append(paths, path)
+		counts[path] = cnt
+	}
+	r.mu.Unlock()
+
+	sort.Slice(paths, func(i, j int) bool {
+		if counts[paths[i]] != counts[paths[j]] {
+			return counts[paths[i]] > counts[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+
+	if n > 0 && len(paths) > n {
+		paths = paths[:n]
+	}
+	return paths
+}
+
+// Save writes the n most popular datasets to fileName in the format
+// understood by LoadWarmupList. The file is replaced atomically.
+func (r *WarmupRecorder) Save(fileName string, n int) error {
+	paths := r.TopN(n)
+	if len(paths) == 0 {
+		return nil
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	w := bufio.NewWriter(tmpFile)
+	fmt.Fprintf(w, "# gsky worker warm-up list, generated at %s\n", time.Now().UTC().Format(time.RFC3339))
+	for _, path := range paths {
+		fmt.Fprintln(w, path)
+	}
+	if err := w.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), fileName)
+}
@@ -0,0 +1,48 @@
+package gdalprocess
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWarmupRecorder(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gsky_warmup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	r := NewWarmupRecorder()
+	for i := 0; i < 3; i++ {
+		r.Record("/data/a.nc")
+	}
+	r.Record("/data/b.nc")
+	r.Record("/data/c.nc")
+	r.Record("/data/c.nc")
+
+	top := r.TopN(2)
+	if len(top) != 2 || top[0] != "/data/a.nc" || top[1] != "/data/c.nc" {
+		t.Errorf("unexpected top datasets: %v", top)
+	}
+
+	fileName := filepath.Join(tmpDir, "warmup.txt")
+	if err := r.Save(fileName, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := LoadWarmupList(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/data/a.nc", "/data/c.nc", "/data/b.nc"}
+	if len(paths) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, paths)
+		}
+	}
+}
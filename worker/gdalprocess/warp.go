@@ -5,6 +5,7 @@ package gdalprocess
 
 #include "warper.hxx"
 #include "gdal.h"
+#include "cpl_conv.h"
 */
 import "C"
 
@@ -42,6 +43,7 @@ import (
 	"log"
 	"math"
 	"reflect"
+	"runtime"
 	"syscall"
 	"time"
 	"unsafe"
@@ -59,6 +61,66 @@ var GDALTypes = map[C.GDALDataType]string{0: "Unkown", 1: "Byte", 2: "UInt16", 3
 	8: "CInt16", 9: "CInt32", 10: "CFloat32", 11: "CFloat64",
 	12: "TypeCount"}
 
+// ResampleAlgs maps resampling method names to GDALResampleAlg values.
+// Resampling methods other than nearest neighbour are warped by the GDAL
+// warp kernel rather than the fast warper.
+var ResampleAlgs = map[string]int{
+	"near":        0,
+	"bilinear":    1,
+	"cubic":       2,
+	"cubicspline": 3,
+	"lanczos":     4,
+	"average":     5,
+	"mode":        6,
+}
+
+// The warpers of the granules.
+const (
+	// warperFast is warp_operation_fast, of the nearest neighbour.
+	warperFast = iota
+	// warperKernel and warperOpenCL are warp_operation_kernel, the GDAL
+	// warp kernel on CPU and on OpenCL.
+	warperKernel
+	warperOpenCL
+)
+
+var warperNames = []string{"fast", "kernel", "opencl"}
+
+// warpers returns the warpers tried in turn for a granule of a warp
+// backend and resampling method, and its GDALResampleAlg: the OpenCL
+// kernel first for the opencl backend, falling back to the CPU warper of
+// the resampling, the fast warper being the CPU warper of the nearest
+// neighbour.
+func warpers(backend string, resampling string) ([]int, int) {
+	resampleAlg, ok := ResampleAlgs[resampling]
+	if !ok {
+		resampleAlg = ResampleAlgs["near"]
+	}
+	cpu := warperFast
+	if resampleAlg != ResampleAlgs["near"] {
+		cpu = warperKernel
+	}
+	if backend == "opencl" {
+		return []int{warperOpenCL, cpu}, resampleAlg
+	}
+	return []int{cpu}, resampleAlg
+}
+
+// runWarpers runs warp with the warpers in turn until one succeeds,
+// returning the error code of the last warper run.
+func runWarpers(warpers []int, path string, warp func(warper int) int) int {
+	cErr := -1
+	for i, warper := range warpers {
+		if cErr = warp(warper); cErr == 0 {
+			break
+		}
+		if i < len(warpers)-1 {
+			log.Printf("%s warper fail: %v, falling back to %s warper: %s", warperNames[warper], cErr, warperNames[warpers[i+1]], path)
+		}
+	}
+	return cErr
+}
+
 func ComputeReprojectExtent(in *pb.GeoRPCGranule) *pb.Result {
 	srcFileC := C.CString(in.Path)
 	defer C.free(unsafe.Pointer(srcFileC))
@@ -120,6 +182,20 @@ func WarpRaster(in *pb.GeoRPCGranule) *pb.Result {
 	filePathC := C.CString(in.Path)
 	defer C.free(unsafe.Pointer(filePathC))
 
+	// The overviews of the COGs being internal, as recorded in MAS, the
+	// directories of the objects are not listed for the external ones,
+	// the overview being read by ranges of its tiles.
+	if in.Cog {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		readDirKeyC := C.CString("GDAL_DISABLE_READDIR_ON_OPEN")
+		defer C.free(unsafe.Pointer(readDirKeyC))
+		emptyDirC := C.CString("EMPTY_DIR")
+		defer C.free(unsafe.Pointer(emptyDirC))
+		C.CPLSetThreadLocalConfigOption(readDirKeyC, emptyDirC)
+		defer C.CPLSetThreadLocalConfigOption(readDirKeyC, nil)
+	}
+
 	var dstProjRefC *C.char
 	if len(in.DstSRS) > 0 {
 		dstProjRefC = C.CString(in.DstSRS)
@@ -182,7 +258,17 @@ func WarpRaster(in *pb.GeoRPCGranule) *pb.Result {
 
 	var resUsage0, resUsage1 syscall.Rusage
 	syscall.Getrusage(syscall.RUSAGE_SELF, &resUsage0)
-	cErr := C.warp_operation_fast(filePathC, srcProjRefC, pSrcGeot, pGeoLoc, dstProjRefC, (*C.double)(&in.DstGeot[0]), C.int(in.Width), C.int(in.Height), C.int(in.Bands[0]), C.int(in.SRSCf), (*unsafe.Pointer)(&dstBufC), (*C.int)(&dstBufSize), (*C.int)(&dstBboxC[0]), (*C.double)(&noData), (*C.GDALDataType)(&dType), &bytesReadC)
+	warperList, resampleAlg := warpers(in.WarpBackend, in.ResampleAlg)
+	cErr := runWarpers(warperList, in.Path, func(warper int) int {
+		if warper == warperFast {
+			return int(C.warp_operation_fast(filePathC, srcProjRefC, pSrcGeot, pGeoLoc, dstProjRefC, (*C.double)(&in.DstGeot[0]), C.int(in.Width), C.int(in.Height), C.int(in.Bands[0]), C.int(in.SRSCf), (*unsafe.Pointer)(&dstBufC), (*C.int)(&dstBufSize), (*C.int)(&dstBboxC[0]), (*C.double)(&noData), (*C.GDALDataType)(&dType), &bytesReadC))
+		}
+		useOpenCL := 0
+		if warper == warperOpenCL {
+			useOpenCL = 1
+		}
+		return int(C.warp_operation_kernel(filePathC, srcProjRefC, pSrcGeot, pGeoLoc, dstProjRefC, (*C.double)(&in.DstGeot[0]), C.int(in.Width), C.int(in.Height), C.int(in.Bands[0]), C.int(in.SRSCf), C.int(resampleAlg), C.int(useOpenCL), (*unsafe.Pointer)(&dstBufC), (*C.int)(&dstBufSize), (*C.int)(&dstBboxC[0]), (*C.double)(&noData), (*C.GDALDataType)(&dType), &bytesReadC))
+	})
 	syscall.Getrusage(syscall.RUSAGE_SELF, &resUsage1)
 
 	metrics := &pb.WorkerMetrics{
@@ -1,33 +1,53 @@
 package gdalprocess
 
 import (
-	"os"
+	"reflect"
 	"testing"
-
-	"github.com/nci/gsky/utils"
-	pb "github.com/nci/gsky/worker/gdalservice"
 )
 
-func TestComputeReprojectExtent(t *testing.T) {
-	if _, err := os.Stat("/g/data2/tc43/modis-fc/v310/tiles/monthly/cover/FC_Monthly_Medoid.v310.MCD43A4.h29v12.2017.006.nc"); os.IsNotExist(err) {
-		t.Skip("Test data file is unavailable. Skipping tests")
-		return
+func TestWarpers(t *testing.T) {
+	tests := []struct {
+		backend     string
+		resampling  string
+		warpers     []int
+		resampleAlg int
+	}{
+		{"", "", []int{warperFast}, 0},
+		{"cpu", "near", []int{warperFast}, 0},
+		{"cpu", "bilinear", []int{warperKernel}, 1},
+		{"", "lanczos", []int{warperKernel}, 4},
+		{"cpu", "unknown", []int{warperFast}, 0},
+		{"opencl", "", []int{warperOpenCL, warperFast}, 0},
+		{"opencl", "cubic", []int{warperOpenCL, warperKernel}, 2},
+		{"opencl", "mode", []int{warperOpenCL, warperKernel}, 6},
 	}
-
-	raster := utils.ByteRaster{NameSpace: "test-ns", Data: make([]uint8, 25), Width: 5, Height: 5}
-	geot := []float64{-179, 0.359, 0, 80, 0, -0.16}
-	rs := []utils.Raster{&raster}
-	_, tempFile, _ := utils.EncodeGdalOpen("/tmp", 256, 256, "geotiff", geot, 4326, rs, 1000, 1000, 1)
-	defer os.Remove(tempFile)
-
-	geo := &pb.GeoRPCGranule{Path: "NETCDF:\"/g/data2/tc43/modis-fc/v310/tiles/monthly/cover/FC_Monthly_Medoid.v310.MCD43A4.h29v12.2017.006.nc\":phot_veg", EPSG: 4326, Geot: geot}
-	res := ComputeReprojectExtent(geo)
-	expected := []uint8{210, 75, 0, 0, 0, 0, 0, 0, 188, 33, 0, 0, 0, 0, 0, 0}
-	for i, val := range res.Raster.Data {
-		if val != expected[i] {
-			t.Errorf("unexpected value %v, %v:", expected, res.Raster.Data)
-			return
+	for _, test := range tests {
+		warperList, resampleAlg := warpers(test.backend, test.resampling)
+		if !reflect.DeepEqual(warperList, test.warpers) || resampleAlg != test.resampleAlg {
+			t.Errorf("%s %s: expected %v of %d, got %v of %d", test.backend, test.resampling, test.warpers, test.resampleAlg, warperList, resampleAlg)
 		}
 	}
+}
 
+func TestRunWarpers(t *testing.T) {
+	tests := []struct {
+		name   string
+		failed map[int]int
+		run    []int
+		cErr   int
+	}{
+		{name: "opencl", run: []int{warperOpenCL}},
+		{name: "fallback", failed: map[int]int{warperOpenCL: 4}, run: []int{warperOpenCL, warperKernel}},
+		{name: "failed", failed: map[int]int{warperOpenCL: 4, warperKernel: 1}, run: []int{warperOpenCL, warperKernel}, cErr: 1},
+	}
+	for _, test := range tests {
+		var run []int
+		cErr := runWarpers([]int{warperOpenCL, warperKernel}, "/g/data/chirps.tif", func(warper int) int {
+			run = append(run, warper)
+			return test.failed[warper]
+		})
+		if !reflect.DeepEqual(run, test.run) || cErr != test.cErr {
+			t.Errorf("%s: expected %v run, failing with %d, got %v, failing with %d", test.name, test.run, test.cErr, run, cErr)
+		}
+	}
 }
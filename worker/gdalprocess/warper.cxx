@@ -78,10 +78,8 @@ int roundCoord(double coord, int maxExtent) {
 	return c;
 }
 
-int warp_operation_fast(const char *srcFilePath, char *srcProjRef, double *srcGeot, const char **geoLocOpts, const char *dstProjRef, double *dstGeot, int dstXImageSize, int dstYImageSize, int band, int srsCf, void **dstBuf, int *dstBufSize, int *dstBbox, double *noData, GDALDataType *dType, size_t *bytesRead)
+static GDALDatasetH openSrcDataset(const char *srcFilePath, int *band, int srsCf)
 {
-	*bytesRead = 0;
-
 	GDALDatasetH hSrcDS = nullptr;
 	const char *netCDFSig = "NETCDF:";
 
@@ -89,16 +87,24 @@ int warp_operation_fast(const char *srcFilePath, char *srcProjRef, double *srcGe
 		hSrcDS = GDALOpenEx(srcFilePath, GA_ReadOnly|GDAL_OF_RASTER, nullptr, nullptr, nullptr);
 	} else {
 		char bandQuery[20];
-		sprintf(bandQuery, "band_query=%d", band);
+		sprintf(bandQuery, "band_query=%d", *band);
 
 		const char *srsCfOpt = srsCf > 0 ? "srs_cf=yes" : "srs_cf=no";
 		const char *openOpts[] = {"md_query=no", bandQuery, srsCfOpt, NULL};
 		const char *drivers[] = {"GSKY_netCDF", NULL};
 
 		hSrcDS = GDALOpenEx(srcFilePath, GA_ReadOnly|GDAL_OF_RASTER, drivers, openOpts, nullptr);
-		band = 1;
+		*band = 1;
 	}
 
+	return hSrcDS;
+}
+
+int warp_operation_fast(const char *srcFilePath, char *srcProjRef, double *srcGeot, const char **geoLocOpts, const char *dstProjRef, double *dstGeot, int dstXImageSize, int dstYImageSize, int band, int srsCf, void **dstBuf, int *dstBufSize, int *dstBbox, double *noData, GDALDataType *dType, size_t *bytesRead)
+{
+	*bytesRead = 0;
+
+	GDALDatasetH hSrcDS = openSrcDataset(srcFilePath, &band, srsCf);
 	if(!hSrcDS) {
 		return 1;
 	}
@@ -391,3 +397,140 @@ int warp_operation_fast(const char *srcFilePath, char *srcProjRef, double *srcGe
 	GDALClose(hSrcDS);
 	return 0;
 }
+
+/*
+This is the implementation of the warp operation using the GDAL warp
+kernel, on OpenCL if useOpenCL is set. Unlike warp_operation_fast, the
+resampling algorithms other than the nearest neighbour are supported.
+GDAL silently falls back to its CPU kernel if no OpenCL device is
+available. The whole destination grid is returned, i.e. dstBbox is always
+the full image.
+*/
+int warp_operation_kernel(const char *srcFilePath, char *srcProjRef, double *srcGeot, const char **geoLocOpts, const char *dstProjRef, double *dstGeot, int dstXImageSize, int dstYImageSize, int band, int srsCf, int resampleAlg, int useOpenCL, void **dstBuf, int *dstBufSize, int *dstBbox, double *noData, GDALDataType *dType, size_t *bytesRead)
+{
+	*bytesRead = 0;
+
+	GDALDatasetH hSrcDS = openSrcDataset(srcFilePath, &band, srsCf);
+	if(!hSrcDS) {
+		return 1;
+	}
+
+	if(srcProjRef == nullptr) {
+		srcProjRef = (char *)GDALGetProjectionRef(hSrcDS);
+		if(strlen(srcProjRef) == 0) {
+			srcProjRef = (char *)"GEOGCS[\"WGS 84\",DATUM[\"WGS_1984\",SPHEROID[\"WGS 84\",6378137,298.257223563,AUTHORITY[\"EPSG\",\"7030\"]],TOWGS84[0,0,0,0,0,0,0],AUTHORITY[\"EPSG\",\"6326\"]],PRIMEM[\"Greenwich\",0,AUTHORITY[\"EPSG\",\"8901\"]],UNIT[\"degree\",0.0174532925199433,AUTHORITY[\"EPSG\",\"9108\"]],AUTHORITY[\"EPSG\",\"4326\"]]";
+		}
+	}
+
+	GDALRasterBandH hBand = GDALGetRasterBand(hSrcDS, band);
+	if(!hBand) {
+		GDALClose(hSrcDS);
+		return 2;
+	}
+
+	double _srcGeot[6];
+	if(srcGeot == nullptr) {
+		srcGeot = _srcGeot;
+		GDALGetGeoTransform(hSrcDS, srcGeot);
+	}
+
+	void *hTransformArg = nullptr;
+	if(geoLocOpts == nullptr) {
+		hTransformArg = GDALCreateGenImgProjTransformer3(srcProjRef, srcGeot, dstProjRef, dstGeot);
+	} else {
+		hTransformArg = createGeoLocTransformer(srcProjRef, geoLocOpts, dstProjRef, dstGeot);
+	}
+	if(!hTransformArg) {
+		GDALClose(hSrcDS);
+		return 3;
+	}
+
+	*dType = GDALGetRasterDataType(hBand);
+	const int srcDataSize = GDALGetDataTypeSizeBytes(*dType);
+	const int supportedDataType = *dType == GDT_Byte || *dType == GDT_Int16 || *dType == GDT_UInt16 || *dType == GDT_Float32;
+	if(!supportedDataType) {
+		*dType = GDT_Float32;
+	}
+	const int dataSize = GDALGetDataTypeSizeBytes(*dType);
+
+	int hasNoData = 0;
+	*noData = GDALGetRasterNoDataValue(hBand, &hasNoData);
+
+	// There is no destination dataset, the warp being to the buffer of
+	// WarpRegionToBuffer: GDAL only reads or writes hDstDS in WarpRegion
+	// and ChunkAndWarpImage, never called, and for the destination alpha
+	// band and INIT_DEST unset, neither of which is.
+	GDALWarpOptions *psWOptions = GDALCreateWarpOptions();
+	psWOptions->hSrcDS = hSrcDS;
+	psWOptions->hDstDS = nullptr;
+	psWOptions->nBandCount = 1;
+	psWOptions->panSrcBands = (int *)CPLMalloc(sizeof(int));
+	psWOptions->panSrcBands[0] = band;
+	psWOptions->panDstBands = (int *)CPLMalloc(sizeof(int));
+	psWOptions->panDstBands[0] = 1;
+	psWOptions->eResampleAlg = (GDALResampleAlg)resampleAlg;
+	psWOptions->eWorkingDataType = *dType;
+	psWOptions->pfnTransformer = GDALGenImgProjTransform;
+	psWOptions->pTransformerArg = hTransformArg;
+	if(hasNoData) {
+		psWOptions->padfSrcNoDataReal = (double *)CPLMalloc(sizeof(double));
+		psWOptions->padfSrcNoDataReal[0] = *noData;
+		psWOptions->padfDstNoDataReal = (double *)CPLMalloc(sizeof(double));
+		psWOptions->padfDstNoDataReal[0] = *noData;
+	}
+	psWOptions->papszWarpOptions = CSLSetNameValue(psWOptions->papszWarpOptions, "USE_OPENCL", useOpenCL ? "TRUE" : "FALSE");
+	psWOptions->papszWarpOptions = CSLSetNameValue(psWOptions->papszWarpOptions, "INIT_DEST", "NO_DATA");
+
+	GDALWarpOperation oWarper;
+	if(oWarper.Initialize(psWOptions) != CE_None) {
+		GDALDestroyWarpOptions(psWOptions);
+		GDALDestroyGenImgProjTransformer(hTransformArg);
+		GDALClose(hSrcDS);
+		return 4;
+	}
+
+	int srcXOff, srcYOff, srcXSize, srcYSize;
+	double srcXExtraSize, srcYExtraSize, srcFillRatio;
+	CPLErr err = oWarper.ComputeSourceWindow(0, 0, dstXImageSize, dstYImageSize, &srcXOff, &srcYOff, &srcXSize, &srcYSize, &srcXExtraSize, &srcYExtraSize, &srcFillRatio);
+	if(err != CE_None) {
+		GDALDestroyWarpOptions(psWOptions);
+		GDALDestroyGenImgProjTransformer(hTransformArg);
+		GDALClose(hSrcDS);
+		return 5;
+	}
+
+	*dstBufSize = dstXImageSize * dstYImageSize * dataSize;
+	uint8_t *pDstBuf = (uint8_t *)malloc(*dstBufSize);
+	*dstBuf = pDstBuf;
+	GDALCopyWords(noData, GDT_Float64, 0, pDstBuf, *dType, dataSize, dstXImageSize * dstYImageSize);
+
+	if(srcXSize > 0 && srcYSize > 0) {
+		err = oWarper.WarpRegionToBuffer(0, 0, dstXImageSize, dstYImageSize, pDstBuf, *dType, srcXOff, srcYOff, srcXSize, srcYSize, srcXExtraSize, srcYExtraSize);
+		if(err != CE_None) {
+			free(pDstBuf);
+			*dstBuf = nullptr;
+			GDALDestroyWarpOptions(psWOptions);
+			GDALDestroyGenImgProjTransformer(hTransformArg);
+			GDALClose(hSrcDS);
+			return 6;
+		}
+		*bytesRead = (size_t)srcXSize * srcYSize * srcDataSize;
+	}
+
+	dstBbox[0] = 0;
+	dstBbox[1] = 0;
+	dstBbox[2] = dstXImageSize;
+	dstBbox[3] = dstYImageSize;
+
+	if(*dType == GDT_Byte) {
+		const char *pixelType = GDALGetMetadataItem((GDALMajorObjectH)hBand, "PIXELTYPE", "IMAGE_STRUCTURE");
+		if(pixelType != nullptr && !strcmp(pixelType, "SIGNEDBYTE")) {
+			*dType = (GDALDataType)100;
+		}
+	}
+
+	GDALDestroyWarpOptions(psWOptions);
+	GDALDestroyGenImgProjTransformer(hTransformArg);
+	GDALClose(hSrcDS);
+	return 0;
+}
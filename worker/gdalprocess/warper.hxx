@@ -7,6 +7,7 @@
 #include "ogr_srs_api.h"
 #include "cpl_string.h"
 #include "gdal_utils.h"
+#include "gdalwarper.h"
 
 
 #ifdef __cplusplus
@@ -15,6 +16,8 @@ extern "C" {
 
 int warp_operation_fast(const char *srcFilePath, char *srcProjRef, double *srcGeot, const char **geoLocOpts, const char *dstProjRef, double *dstGeot, int dstXImageSize, int dstYImageSize, int band, int srsCf, void **dstBuf, int *dstBufSize, int *dstBbox, double *noData, GDALDataType *dType, size_t *bytesRead);
 
+int warp_operation_kernel(const char *srcFilePath, char *srcProjRef, double *srcGeot, const char **geoLocOpts, const char *dstProjRef, double *dstGeot, int dstXImageSize, int dstYImageSize, int band, int srsCf, int resampleAlg, int useOpenCL, void **dstBuf, int *dstBufSize, int *dstBbox, double *noData, GDALDataType *dType, size_t *bytesRead);
+
 #ifdef __cplusplus
 }
 #endif
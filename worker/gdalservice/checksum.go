@@ -0,0 +1,16 @@
+package gdalservice
+
+import "hash/crc32"
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// RasterChecksum computes the checksum of raw pixel data sent between
+// workers and the front-end.
+func RasterChecksum(data []byte) uint32 {
+	return crc32.Checksum(data, checksumTable)
+}
+
+// VerifyChecksum reports whether the raster data matches its checksum.
+func (r *Raster) VerifyChecksum() bool {
+	return RasterChecksum(r.GetData()) == r.GetChecksum()
+}
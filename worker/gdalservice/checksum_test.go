@@ -0,0 +1,16 @@
+package gdalservice
+
+import "testing"
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5}
+	r := &Raster{Data: data, Checksum: RasterChecksum(data)}
+	if !r.VerifyChecksum() {
+		t.Errorf("expected checksum to match")
+	}
+
+	r.Data = []byte{1, 2, 3, 4, 6}
+	if r.VerifyChecksum() {
+		t.Errorf("expected checksum mismatch for corrupted data")
+	}
+}
@@ -0,0 +1,192 @@
+package zarr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// The flags of the headers of the blosc buffers.
+const (
+	bloscShuffle    = 0x01
+	bloscMemcpyed   = 0x02
+	bloscBitShuffle = 0x04
+	bloscNoSplit    = 0x10
+)
+
+// The compressors of the blosc buffers, of the 3 high bits of the flags.
+const (
+	bloscLZ4  = 1
+	bloscZlib = 3
+)
+
+const bloscHeaderSize = 16
+
+// decodeBlosc returns the bytes of a blosc buffer, the default compressor
+// of the v2 arrays written by numcodecs, of its lz4 or zlib compressors
+// with or without byte shuffle.
+func decodeBlosc(data []byte) ([]byte, error) {
+	if len(data) < bloscHeaderSize {
+		return nil, fmt.Errorf("truncated blosc header")
+	}
+	flags := data[2]
+	typeSize := int(data[3])
+	nbytes := int(binary.LittleEndian.Uint32(data[4:]))
+	blockSize := int(binary.LittleEndian.Uint32(data[8:]))
+	if flags&bloscMemcpyed != 0 {
+		if len(data) < bloscHeaderSize+nbytes {
+			return nil, fmt.Errorf("truncated blosc buffer")
+		}
+		return data[bloscHeaderSize : bloscHeaderSize+nbytes], nil
+	}
+	if flags&bloscBitShuffle != 0 {
+		return nil, fmt.Errorf("blosc bit shuffle not supported")
+	}
+	if blockSize <= 0 || typeSize <= 0 {
+		return nil, fmt.Errorf("invalid blosc header")
+	}
+	compressor := int(flags >> 5)
+	if compressor != bloscLZ4 && compressor != bloscZlib {
+		return nil, fmt.Errorf("blosc compressor %d not supported", compressor)
+	}
+
+	out := make([]byte, 0, nbytes)
+	nblocks := (nbytes + blockSize - 1) / blockSize
+	if len(data) < bloscHeaderSize+4*nblocks {
+		return nil, fmt.Errorf("truncated blosc buffer")
+	}
+	for i := 0; i < nblocks; i++ {
+		size := blockSize
+		leftover := i == nblocks-1 && nbytes%blockSize != 0
+		if leftover {
+			size = nbytes % blockSize
+		}
+		nsplits := 1
+		if flags&bloscNoSplit == 0 && !leftover {
+			nsplits = typeSize
+		}
+		splitSize := size / nsplits
+		block := make([]byte, 0, size)
+		pos := int(binary.LittleEndian.Uint32(data[bloscHeaderSize+4*i:]))
+		for j := 0; j < nsplits; j++ {
+			if pos+4 > len(data) {
+				return nil, fmt.Errorf("truncated blosc block")
+			}
+			csize := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			if csize < 0 || pos+csize > len(data) {
+				return nil, fmt.Errorf("truncated blosc block")
+			}
+			src := data[pos : pos+csize]
+			pos += csize
+			if csize == splitSize {
+				block = append(block, src...)
+				continue
+			}
+			var split []byte
+			var err error
+			if compressor == bloscLZ4 {
+				split, err = decodeLZ4(src, splitSize)
+			} else {
+				var r io.Reader
+				if r, err = zlib.NewReader(bytes.NewReader(src)); err == nil {
+					split, err = ioutil.ReadAll(r)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(split) != splitSize {
+				return nil, fmt.Errorf("blosc block of %d bytes, %d expected", len(split), splitSize)
+			}
+			block = append(block, split...)
+		}
+		if flags&bloscShuffle != 0 && typeSize > 1 {
+			block = unshuffle(block, typeSize)
+		}
+		out = append(out, block...)
+	}
+	return out, nil
+}
+
+// unshuffle returns the values of a block whose bytes are shuffled, the
+// first bytes of all the values followed by their second bytes and so on.
+// The bytes after the last whole value are not shuffled.
+func unshuffle(block []byte, typeSize int) []byte {
+	n := len(block) / typeSize
+	out := make([]byte, len(block))
+	for i := 0; i < n; i++ {
+		for j := 0; j < typeSize; j++ {
+			out[i*typeSize+j] = block[j*n+i]
+		}
+	}
+	copy(out[n*typeSize:], block[n*typeSize:])
+	return out
+}
+
+// decodeLZ4 returns the bytes of an LZ4 block of size bytes.
+func decodeLZ4(src []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		literals := int(token >> 4)
+		if literals == 15 {
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("truncated lz4 block")
+				}
+				b := src[i]
+				i++
+				literals += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if i+literals > len(src) {
+			return nil, fmt.Errorf("truncated lz4 block")
+		}
+		out = append(out, src[i:i+literals]...)
+		i += literals
+		// The last sequence has no match.
+		if i == len(src) {
+			break
+		}
+		if i+2 > len(src) {
+			return nil, fmt.Errorf("truncated lz4 block")
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(out) {
+			return nil, fmt.Errorf("invalid lz4 offset")
+		}
+		length := int(token & 0x0f)
+		if length == 15 {
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("truncated lz4 block")
+				}
+				b := src[i]
+				i++
+				length += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		length += 4
+		if len(out)+length > size {
+			return nil, fmt.Errorf("lz4 block larger than %d bytes", size)
+		}
+		// The matches may overlap their output.
+		start := len(out) - offset
+		for k := 0; k < length; k++ {
+			out = append(out, out[start+k])
+		}
+	}
+	return out, nil
+}
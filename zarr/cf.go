@@ -0,0 +1,260 @@
+package zarr
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"time"
+)
+
+// Coordinate is the coordinate of a dimension of a variable, of the
+// values of its 1-D array, scaled and offset as CF packed values, or of
+// the indices of the dimensions without array.
+type Coordinate struct {
+	Name   string
+	Values []float64
+	Attrs  map[string]interface{}
+}
+
+// Variable is an array of a hierarchy whose last two dimensions are of
+// the y and x coordinates of a grid.
+type Variable struct {
+	*Array
+	X, Y *Coordinate
+	// Time is the coordinate of the CF time dimension, of units such as
+	// "days since 1970-01-01", if any.
+	Time *Coordinate
+	// Axes are the coordinates of the dimensions before y and x, in the
+	// order of the array, including the time dimension.
+	Axes []*Coordinate
+	// CRS is the crs_wkt or spatial_ref of the CF grid mapping of the
+	// variable, if any.
+	CRS string
+	// Geographic reports whether x and y are CF longitudes and
+	// latitudes.
+	Geographic bool
+}
+
+// Variables returns the variables of a hierarchy, the arrays of at least
+// two dimensions whose last two dimensions are of 1-D coordinate arrays,
+// of the group of the array or of one of its parents. The coordinate
+// arrays and the CF grid mappings and bounds are not variables.
+func (h *Hierarchy) Variables() ([]*Variable, error) {
+	coords := make(map[string]bool)
+	for _, a := range h.Arrays {
+		for _, d := range a.Dimensions {
+			if c := h.coordinateArray(a, d); c != nil {
+				coords[c.Path] = true
+			}
+		}
+		if bounds, ok := a.Attrs["bounds"].(string); ok {
+			coords[path.Join(path.Dir(a.Path), bounds)] = true
+		}
+	}
+
+	var vars []*Variable
+	for _, a := range h.Arrays {
+		n := len(a.Dimensions)
+		if coords[a.Path] || n < 2 || n != len(a.Shape) {
+			continue
+		}
+		yArray, xArray := h.coordinateArray(a, a.Dimensions[n-2]), h.coordinateArray(a, a.Dimensions[n-1])
+		if yArray == nil || xArray == nil {
+			continue
+		}
+		v := &Variable{Array: a}
+		var err error
+		if v.Y, err = coordinate(yArray); err != nil {
+			return nil, err
+		}
+		if v.X, err = coordinate(xArray); err != nil {
+			return nil, err
+		}
+		for i, d := range a.Dimensions[:n-2] {
+			c := &Coordinate{Name: d}
+			if ca := h.coordinateArray(a, d); ca != nil {
+				if c, err = coordinate(ca); err != nil {
+					return nil, err
+				}
+			} else {
+				for j := 0; j < a.Shape[i]; j++ {
+					c.Values = append(c.Values, float64(j))
+				}
+			}
+			if units, _ := c.Attrs["units"].(string); strings.Contains(units, " since ") && v.Time == nil {
+				v.Time = c
+			}
+			v.Axes = append(v.Axes, c)
+		}
+		v.Geographic = isCoordinate(v.X, "longitude", "degrees_east", "lon") && isCoordinate(v.Y, "latitude", "degrees_north", "lat")
+		if name, ok := a.Attrs["grid_mapping"].(string); ok {
+			if gm := h.Array(path.Join(path.Dir(a.Path), name)); gm != nil {
+				for _, attr := range []string{"crs_wkt", "spatial_ref"} {
+					if wkt, ok := gm.Attrs[attr].(string); ok && len(wkt) > 0 {
+						v.CRS = wkt
+						break
+					}
+				}
+			}
+		}
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
+
+// coordinateArray returns the 1-D array of a dimension of an array, of
+// the name of the dimension in the group of the array or in one of its
+// parents, nil if none.
+func (h *Hierarchy) coordinateArray(a *Array, dim string) *Array {
+	if len(dim) == 0 {
+		return nil
+	}
+	for dir := path.Dir(a.Path); ; dir = path.Dir(dir) {
+		if c := h.Array(path.Join(dir, dim)); c != nil && c != a && len(c.Shape) == 1 {
+			return c
+		}
+		if dir == "/" {
+			return nil
+		}
+	}
+}
+
+func coordinate(a *Array) (*Coordinate, error) {
+	values, err := a.Float64s()
+	if err != nil {
+		return nil, err
+	}
+	scale, hasScale := a.Attrs["scale_factor"].(float64)
+	offset, hasOffset := a.Attrs["add_offset"].(float64)
+	if hasScale || hasOffset {
+		if !hasScale {
+			scale = 1
+		}
+		for i := range values {
+			values[i] = values[i]*scale + offset
+		}
+	}
+	return &Coordinate{Name: a.Name(), Values: values, Attrs: a.Attrs}, nil
+}
+
+// isCoordinate reports whether a coordinate is of a CF standard name or
+// units, or of a name starting with prefix.
+func isCoordinate(c *Coordinate, standardName, units, prefix string) bool {
+	if name, _ := c.Attrs["standard_name"].(string); name == standardName {
+		return true
+	}
+	if u, _ := c.Attrs["units"].(string); u == units {
+		return true
+	}
+	return strings.HasPrefix(strings.ToLower(c.Name), prefix)
+}
+
+// GeoTransform returns the GDAL geotransform of the regular grid of the
+// pixel centres of x and y.
+func GeoTransform(x, y []float64) ([]float64, error) {
+	dx, err := resolution(x)
+	if err != nil {
+		return nil, fmt.Errorf("x coordinate: %v", err)
+	}
+	dy, err := resolution(y)
+	if err != nil {
+		return nil, fmt.Errorf("y coordinate: %v", err)
+	}
+	return []float64{x[0] - dx/2, dx, 0, y[0] - dy/2, 0, dy}, nil
+}
+
+// resolution returns the step of regular values, within a hundredth of
+// the step.
+func resolution(values []float64) (float64, error) {
+	n := len(values)
+	if n < 2 {
+		return 0, fmt.Errorf("%d values, at least 2 required", n)
+	}
+	step := (values[n-1] - values[0]) / float64(n-1)
+	if step == 0 || math.IsNaN(step) {
+		return 0, fmt.Errorf("invalid step %v", step)
+	}
+	for i, v := range values {
+		if math.Abs(v-(values[0]+float64(i)*step)) > math.Abs(step)/100 {
+			return 0, fmt.Errorf("irregular values")
+		}
+	}
+	return step, nil
+}
+
+// timeUnits are the durations of the CF time units, in seconds.
+var timeUnits = map[string]float64{
+	"seconds": 1, "second": 1, "secs": 1, "sec": 1, "s": 1,
+	"minutes": 60, "minute": 60, "mins": 60, "min": 60,
+	"hours": 3600, "hour": 3600, "hrs": 3600, "hr": 3600, "h": 3600,
+	"days": 86400, "day": 86400, "d": 86400,
+}
+
+var referenceLayouts = []string{
+	"2006-1-2 15:4:5Z07:00",
+	"2006-1-2 15:4:5 Z07:00",
+	"2006-1-2 15:4:5 -0700",
+	"2006-1-2 15:4:5",
+	"2006-1-2 15:4",
+	"2006-1-2",
+}
+
+// Times returns the times of the values of a CF time coordinate, of units
+// such as "days since 1970-01-01 00:00:00", in the standard calendar. The
+// months and years are calendar months and years, as for the netCDF
+// files.
+func Times(values []float64, units, calendar string) ([]time.Time, error) {
+	switch strings.ToLower(calendar) {
+	case "", "standard", "gregorian", "proleptic_gregorian":
+	default:
+		return nil, fmt.Errorf("calendar %s not supported", calendar)
+	}
+	parts := strings.SplitN(strings.TrimSpace(units), " since ", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid time units %q", units)
+	}
+	unit := strings.ToLower(strings.TrimSpace(parts[0]))
+	ref, err := referenceTime(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	times := make([]time.Time, len(values))
+	for i, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid time value %v", v)
+		}
+		switch unit {
+		case "months", "month":
+			times[i] = ref.AddDate(0, int(v), 0)
+		case "years", "year":
+			times[i] = ref.AddDate(int(v), 0, 0)
+		default:
+			seconds, found := timeUnits[unit]
+			if !found {
+				return nil, fmt.Errorf("invalid time units %q", units)
+			}
+			// The days are added apart for the durations to stay
+			// within the range of time.Duration.
+			seconds *= v
+			days := math.Floor(seconds / 86400)
+			rest := seconds - days*86400
+			times[i] = ref.AddDate(0, 0, int(days)).Add(time.Duration(math.Round(rest*1e6)) * time.Microsecond)
+		}
+	}
+	return times, nil
+}
+
+// referenceTime returns the UTC time of the reference of CF time units,
+// e.g. 1970-01-01, 1970-01-01T00:00:00Z or 1900-1-1 0:0:0 +10:00.
+func referenceTime(ref string) (time.Time, error) {
+	ref = strings.TrimSpace(strings.Replace(ref, "T", " ", 1))
+	ref = strings.TrimSpace(strings.TrimSuffix(ref, "UTC"))
+	for _, layout := range referenceLayouts {
+		if t, err := time.Parse(layout, ref); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time reference %q", ref)
+}
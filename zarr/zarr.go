@@ -0,0 +1,605 @@
+// Package zarr reads the hierarchies of the Zarr v2 and v3 stores, their
+// groups and arrays with their attributes and chunk layout, and the values
+// of their 1-D coordinate arrays, so that the crawler can index the
+// variables of the stores without the Zarr driver of GDAL.
+package zarr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is the error of the keys missing from a store, e.g. of the
+// chunks of fill values never written.
+var ErrNotFound = errors.New("zarr: key not found")
+
+// Store is the key-value store of a hierarchy, the keys being relative to
+// its root, e.g. temp/.zarray.
+type Store interface {
+	// Get returns the value of a key, or an error wrapping ErrNotFound
+	// if missing.
+	Get(key string) ([]byte, error)
+	// Keys returns the keys of the store, only listed if the hierarchy
+	// has no consolidated metadata.
+	Keys() ([]string, error)
+}
+
+// Dir is the store of a directory.
+type Dir string
+
+// Get returns the content of the file of a key.
+func (d Dir) Get(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(string(d), filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return data, err
+}
+
+// Keys returns the paths of the files of the directory.
+func (d Dir) Keys() ([]string, error) {
+	var keys []string
+	err := filepath.Walk(string(d), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			rel, err := filepath.Rel(string(d), p)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Node is a group or an array of a hierarchy.
+type Node struct {
+	// Path is the path of the node from the root, e.g. /temp, the root
+	// being /.
+	Path  string
+	Attrs map[string]interface{}
+}
+
+// Name returns the last element of the path of the node.
+func (n *Node) Name() string {
+	return path.Base(n.Path)
+}
+
+// Array is an array of a hierarchy.
+type Array struct {
+	Node
+	Shape []int
+	// Chunks is the shape of the chunks, of the same dimensions as the
+	// array.
+	Chunks []int
+	// DataType is the type of the values, e.g. float32, or the dtype of
+	// the types not read by this package.
+	DataType string
+	// Dimensions are the names of the dimensions, of the
+	// _ARRAY_DIMENSIONS attribute of v2 or dimension_names of v3, empty
+	// if unnamed.
+	Dimensions []string
+	FillValue  *float64
+	// Compressor names the compressors of the chunks, e.g. zlib or
+	// blosc, empty if not compressed.
+	Compressor string
+
+	store       Store
+	key         string
+	encoding    string // "default" of v3 or "v2"
+	separator   string
+	bigEndian   bool
+	codecs      []string // compressors, in the order of their encoding
+	crc32c      bool
+	unsupported string
+}
+
+// Hierarchy is the groups and arrays of a store.
+type Hierarchy struct {
+	Version int
+	Groups  []*Node
+	Arrays  []*Array
+}
+
+// dataTypeSizes are the sizes of the types of the values read.
+var dataTypeSizes = map[string]int{
+	"bool": 1, "int8": 1, "uint8": 1,
+	"int16": 2, "uint16": 2,
+	"int32": 4, "uint32": 4, "float32": 4,
+	"int64": 8, "uint64": 8, "float64": 8,
+}
+
+type v2Array struct {
+	Shape              []int             `json:"shape"`
+	Chunks             []int             `json:"chunks"`
+	DType              json.RawMessage   `json:"dtype"`
+	Compressor         *v2Codec          `json:"compressor"`
+	FillValue          json.RawMessage   `json:"fill_value"`
+	Order              string            `json:"order"`
+	Filters            []json.RawMessage `json:"filters"`
+	DimensionSeparator string            `json:"dimension_separator"`
+}
+
+type v2Codec struct {
+	ID string `json:"id"`
+}
+
+type v3Node struct {
+	ZarrFormat int             `json:"zarr_format"`
+	NodeType   string          `json:"node_type"`
+	Shape      []int           `json:"shape"`
+	DataType   json.RawMessage `json:"data_type"`
+	ChunkGrid  struct {
+		Name          string `json:"name"`
+		Configuration struct {
+			ChunkShape []int `json:"chunk_shape"`
+		} `json:"configuration"`
+	} `json:"chunk_grid"`
+	ChunkKeyEncoding struct {
+		Name          string `json:"name"`
+		Configuration struct {
+			Separator string `json:"separator"`
+		} `json:"configuration"`
+	} `json:"chunk_key_encoding"`
+	Codecs []struct {
+		Name          string `json:"name"`
+		Configuration struct {
+			Endian string `json:"endian"`
+		} `json:"configuration"`
+	} `json:"codecs"`
+	FillValue            json.RawMessage        `json:"fill_value"`
+	Attributes           map[string]interface{} `json:"attributes"`
+	DimensionNames       []*string              `json:"dimension_names"`
+	ConsolidatedMetadata *struct {
+		Metadata map[string]json.RawMessage `json:"metadata"`
+	} `json:"consolidated_metadata"`
+}
+
+// Open reads the hierarchy of a store, from its consolidated metadata if
+// any, of .zmetadata for v2 or of the root zarr.json for v3, otherwise
+// from the metadata of all its keys.
+func Open(s Store) (*Hierarchy, error) {
+	root, err := s.Get("zarr.json")
+	if err == nil {
+		return openV3(s, root)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	return openV2(s)
+}
+
+func openV2(s Store) (*Hierarchy, error) {
+	metadata := make(map[string]json.RawMessage)
+	consolidated, err := s.Get(".zmetadata")
+	if err == nil {
+		var zmetadata struct {
+			Metadata map[string]json.RawMessage `json:"metadata"`
+		}
+		if err := json.Unmarshal(consolidated, &zmetadata); err != nil {
+			return nil, fmt.Errorf("zarr: invalid .zmetadata: %v", err)
+		}
+		metadata = zmetadata.Metadata
+	} else if errors.Is(err, ErrNotFound) {
+		keys, err := s.Keys()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			switch path.Base(key) {
+			case ".zgroup", ".zarray", ".zattrs":
+				if metadata[key], err = s.Get(key); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		return nil, err
+	}
+
+	h := &Hierarchy{Version: 2}
+	var keys []string
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		dir := path.Dir(key)
+		if dir == "." {
+			dir = ""
+		}
+		node := Node{Path: "/" + dir}
+		if raw, found := metadata[path.Join(dir, ".zattrs")]; found {
+			if err := json.Unmarshal(raw, &node.Attrs); err != nil {
+				return nil, fmt.Errorf("zarr: invalid .zattrs of %s: %v", node.Path, err)
+			}
+		}
+		switch path.Base(key) {
+		case ".zgroup":
+			h.Groups = append(h.Groups, &node)
+		case ".zarray":
+			var meta v2Array
+			if err := json.Unmarshal(metadata[key], &meta); err != nil {
+				return nil, fmt.Errorf("zarr: invalid .zarray of %s: %v", node.Path, err)
+			}
+			a, err := newV2Array(s, node, dir, &meta)
+			if err != nil {
+				return nil, err
+			}
+			h.Arrays = append(h.Arrays, a)
+		}
+	}
+	if len(h.Groups) == 0 && len(h.Arrays) == 0 {
+		return nil, fmt.Errorf("zarr: no groups or arrays found")
+	}
+	return h, nil
+}
+
+func newV2Array(s Store, node Node, key string, meta *v2Array) (*Array, error) {
+	a := &Array{Node: node, Shape: meta.Shape, Chunks: meta.Chunks, store: s, key: key, encoding: "v2", separator: meta.DimensionSeparator}
+	if len(a.separator) == 0 {
+		a.separator = "."
+	}
+	if len(a.Chunks) != len(a.Shape) {
+		return nil, fmt.Errorf("zarr: chunks of %s not of the dimensions of its shape", a.Path)
+	}
+	if dims, ok := node.Attrs["_ARRAY_DIMENSIONS"].([]interface{}); ok {
+		for _, d := range dims {
+			name, _ := d.(string)
+			a.Dimensions = append(a.Dimensions, name)
+		}
+	}
+
+	var dtype string
+	if err := json.Unmarshal(meta.DType, &dtype); err != nil {
+		// The structured types are lists of fields.
+		a.DataType = string(meta.DType)
+		a.unsupported = "structured dtype"
+	} else {
+		a.DataType, a.bigEndian = v2DataType(dtype)
+		if _, found := dataTypeSizes[a.DataType]; !found {
+			a.unsupported = "dtype " + dtype
+		}
+	}
+	if meta.Compressor != nil {
+		a.Compressor = meta.Compressor.ID
+		a.codecs = []string{meta.Compressor.ID}
+	}
+	if len(meta.Filters) > 0 {
+		a.unsupported = "filters"
+	}
+	a.FillValue = fillValue(meta.FillValue)
+	return a, nil
+}
+
+// v2DataType returns the type of a dtype, e.g. float32 of <f4, and
+// whether its values are big endian.
+func v2DataType(dtype string) (string, bool) {
+	if len(dtype) < 3 {
+		return dtype, false
+	}
+	size, err := strconv.Atoi(dtype[2:])
+	if err != nil {
+		return dtype, false
+	}
+	bigEndian := dtype[0] == '>'
+	switch dtype[1] {
+	case 'b':
+		if size == 1 {
+			return "bool", false
+		}
+	case 'i':
+		return fmt.Sprintf("int%d", size*8), bigEndian
+	case 'u':
+		return fmt.Sprintf("uint%d", size*8), bigEndian
+	case 'f':
+		return fmt.Sprintf("float%d", size*8), bigEndian
+	}
+	return dtype, bigEndian
+}
+
+func openV3(s Store, root []byte) (*Hierarchy, error) {
+	metadata := map[string]json.RawMessage{"": root}
+	var rootNode v3Node
+	if err := json.Unmarshal(root, &rootNode); err != nil {
+		return nil, fmt.Errorf("zarr: invalid zarr.json: %v", err)
+	}
+	if rootNode.ConsolidatedMetadata != nil {
+		for key, raw := range rootNode.ConsolidatedMetadata.Metadata {
+			metadata[strings.Trim(key, "/")] = raw
+		}
+	} else if rootNode.NodeType == "group" {
+		keys, err := s.Keys()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if path.Base(key) != "zarr.json" || key == "zarr.json" {
+				continue
+			}
+			dir := path.Dir(key)
+			if metadata[dir], err = s.Get(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	h := &Hierarchy{Version: 3}
+	var keys []string
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var meta v3Node
+		if err := json.Unmarshal(metadata[key], &meta); err != nil {
+			return nil, fmt.Errorf("zarr: invalid zarr.json of /%s: %v", key, err)
+		}
+		node := Node{Path: "/" + key, Attrs: meta.Attributes}
+		switch meta.NodeType {
+		case "group":
+			h.Groups = append(h.Groups, &node)
+		case "array":
+			a, err := newV3Array(s, node, key, &meta)
+			if err != nil {
+				return nil, err
+			}
+			h.Arrays = append(h.Arrays, a)
+		default:
+			return nil, fmt.Errorf("zarr: invalid node_type %q of %s", meta.NodeType, node.Path)
+		}
+	}
+	return h, nil
+}
+
+func newV3Array(s Store, node Node, key string, meta *v3Node) (*Array, error) {
+	a := &Array{Node: node, Shape: meta.Shape, Chunks: meta.ChunkGrid.Configuration.ChunkShape, store: s, key: key}
+	if meta.ChunkGrid.Name != "regular" {
+		a.unsupported = "chunk grid " + meta.ChunkGrid.Name
+	}
+	if len(a.Chunks) != len(a.Shape) {
+		return nil, fmt.Errorf("zarr: chunk_shape of %s not of the dimensions of its shape", a.Path)
+	}
+	for _, d := range meta.DimensionNames {
+		name := ""
+		if d != nil {
+			name = *d
+		}
+		a.Dimensions = append(a.Dimensions, name)
+	}
+
+	a.encoding = meta.ChunkKeyEncoding.Name
+	a.separator = meta.ChunkKeyEncoding.Configuration.Separator
+	switch a.encoding {
+	case "", "default":
+		a.encoding = "default"
+		if len(a.separator) == 0 {
+			a.separator = "/"
+		}
+	case "v2":
+		if len(a.separator) == 0 {
+			a.separator = "."
+		}
+	default:
+		a.unsupported = "chunk key encoding " + a.encoding
+	}
+
+	if err := json.Unmarshal(meta.DataType, &a.DataType); err != nil {
+		a.DataType = string(meta.DataType)
+	}
+	if _, found := dataTypeSizes[a.DataType]; !found {
+		a.unsupported = "data type " + a.DataType
+	}
+
+	var compressors []string
+	for _, c := range meta.Codecs {
+		switch c.Name {
+		case "bytes":
+			a.bigEndian = c.Configuration.Endian == "big"
+		case "transpose":
+			// The 1-D arrays read are not transposed.
+		case "crc32c":
+			a.crc32c = true
+		case "gzip", "zlib", "blosc":
+			a.codecs = append(a.codecs, c.Name)
+			compressors = append(compressors, c.Name)
+		default:
+			// e.g. zstd or sharding_indexed.
+			a.unsupported = c.Name + " codec"
+			compressors = append(compressors, c.Name)
+		}
+	}
+	a.Compressor = strings.Join(compressors, ",")
+	a.FillValue = fillValue(meta.FillValue)
+	return a, nil
+}
+
+// fillValue returns the value of a fill_value, nil if null or not a
+// number.
+func fillValue(raw json.RawMessage) *float64 {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil
+	}
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case bool:
+		if v {
+			f = 1
+		}
+	case string:
+		switch v {
+		case "NaN":
+			f = math.NaN()
+		case "Infinity":
+			f = math.Inf(1)
+		case "-Infinity":
+			f = math.Inf(-1)
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+	return &f
+}
+
+// Array returns the array of a path, nil if not found.
+func (h *Hierarchy) Array(p string) *Array {
+	for _, a := range h.Arrays {
+		if a.Path == p {
+			return a
+		}
+	}
+	return nil
+}
+
+// chunkKey returns the key of the chunk of the indices of a grid.
+func (a *Array) chunkKey(idx []int) string {
+	parts := make([]string, len(idx))
+	for i, ix := range idx {
+		parts[i] = strconv.Itoa(ix)
+	}
+	if a.encoding == "default" {
+		parts = append([]string{"c"}, parts...)
+	}
+	name := strings.Join(parts, a.separator)
+	if len(name) == 0 {
+		// The chunk of a 0-D v2 array.
+		name = "0"
+	}
+	return path.Join(a.key, name)
+}
+
+// Float64s returns the values of a 1-D array, e.g. of a coordinate, the
+// missing chunks being of the fill value.
+func (a *Array) Float64s() ([]float64, error) {
+	if len(a.Shape) != 1 {
+		return nil, fmt.Errorf("zarr: %s is not a 1-D array", a.Path)
+	}
+	if len(a.unsupported) > 0 {
+		return nil, fmt.Errorf("zarr: %s of %s not supported", a.unsupported, a.Path)
+	}
+	n, chunk := a.Shape[0], a.Chunks[0]
+	if chunk <= 0 {
+		return nil, fmt.Errorf("zarr: invalid chunks of %s", a.Path)
+	}
+	size := dataTypeSizes[a.DataType]
+	fill := 0.0
+	if a.FillValue != nil {
+		fill = *a.FillValue
+	}
+
+	values := make([]float64, 0, n)
+	for i := 0; i*chunk < n; i++ {
+		m := chunk
+		if n-i*chunk < m {
+			m = n - i*chunk
+		}
+		key := a.chunkKey([]int{i})
+		data, err := a.store.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			for j := 0; j < m; j++ {
+				values = append(values, fill)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data, err = a.decode(data); err != nil {
+			return nil, fmt.Errorf("zarr: chunk %s: %v", key, err)
+		}
+		if len(data) < m*size {
+			return nil, fmt.Errorf("zarr: chunk %s of %d bytes, %d expected", key, len(data), chunk*size)
+		}
+		for j := 0; j < m; j++ {
+			values = append(values, a.value(data[j*size:]))
+		}
+	}
+	return values, nil
+}
+
+// decode returns the bytes of the values of an encoded chunk.
+func (a *Array) decode(data []byte) ([]byte, error) {
+	if a.crc32c {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated crc32c")
+		}
+		data = data[:len(data)-4]
+	}
+	for i := len(a.codecs) - 1; i >= 0; i-- {
+		var r io.Reader
+		var err error
+		switch a.codecs[i] {
+		case "zlib":
+			r, err = zlib.NewReader(bytes.NewReader(data))
+		case "gzip":
+			r, err = gzip.NewReader(bytes.NewReader(data))
+		case "blosc":
+			if data, err = decodeBlosc(data); err != nil {
+				return nil, err
+			}
+			continue
+		default:
+			err = fmt.Errorf("%s compressor not supported", a.codecs[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// value returns the value of the bytes of the data type.
+func (a *Array) value(b []byte) float64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if a.bigEndian {
+		order = binary.BigEndian
+	}
+	switch a.DataType {
+	case "bool", "uint8":
+		return float64(b[0])
+	case "int8":
+		return float64(int8(b[0]))
+	case "int16":
+		return float64(int16(order.Uint16(b)))
+	case "uint16":
+		return float64(order.Uint16(b))
+	case "int32":
+		return float64(int32(order.Uint32(b)))
+	case "uint32":
+		return float64(order.Uint32(b))
+	case "int64":
+		return float64(int64(order.Uint64(b)))
+	case "uint64":
+		return float64(order.Uint64(b))
+	case "float32":
+		return float64(math.Float32frombits(order.Uint32(b)))
+	case "float64":
+		return math.Float64frombits(order.Uint64(b))
+	}
+	return math.NaN()
+}
@@ -0,0 +1,283 @@
+package zarr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type mapStore map[string][]byte
+
+func (s mapStore) Get(key string) ([]byte, error) {
+	if v, found := s[key]; found {
+		return v, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+}
+
+func (s mapStore) Keys() ([]string, error) {
+	var keys []string
+	for key := range s {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func float64Bytes(values ...float64) []byte {
+	b := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(v))
+	}
+	return b
+}
+
+func int64Bytes(values ...int64) []byte {
+	b := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(b[8*i:], uint64(v))
+	}
+	return b
+}
+
+func compress(t *testing.T, codec string, data []byte) []byte {
+	var buf bytes.Buffer
+	var err error
+	if codec == "zlib" {
+		w := zlib.NewWriter(&buf)
+		_, err = w.Write(data)
+		w.Close()
+	} else {
+		w := gzip.NewWriter(&buf)
+		_, err = w.Write(data)
+		w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenV2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zarr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		".zgroup":      []byte(`{"zarr_format": 2}`),
+		".zattrs":      []byte(`{"title": "test"}`),
+		"lat/.zarray":  []byte(`{"zarr_format": 2, "shape": [3], "chunks": [2], "dtype": "<f8", "compressor": null, "fill_value": "NaN", "order": "C", "filters": null}`),
+		"lat/.zattrs":  []byte(`{"_ARRAY_DIMENSIONS": ["lat"], "units": "degrees_north"}`),
+		"lat/0":        float64Bytes(-10, -11),
+		"lat/1":        float64Bytes(-12, 0),
+		"lon/.zarray":  []byte(`{"zarr_format": 2, "shape": [4], "chunks": [4], "dtype": "<f8", "compressor": {"id": "zlib", "level": 1}, "fill_value": 0, "filters": null}`),
+		"lon/.zattrs":  []byte(`{"_ARRAY_DIMENSIONS": ["lon"], "units": "degrees_east"}`),
+		"lon/0":        compress(t, "zlib", float64Bytes(30, 30.5, 31, 31.5)),
+		"time/.zarray": []byte(`{"zarr_format": 2, "shape": [2], "chunks": [2], "dtype": "<i8", "compressor": {"id": "gzip"}, "fill_value": null, "filters": null}`),
+		"time/.zattrs": []byte(`{"_ARRAY_DIMENSIONS": ["time"], "units": "days since 2020-01-01", "calendar": "proleptic_gregorian"}`),
+		"time/0":       compress(t, "gzip", int64Bytes(0, 31)),
+		"crs/.zarray":  []byte(`{"zarr_format": 2, "shape": [], "chunks": [], "dtype": "<i4", "compressor": null, "fill_value": null, "filters": null}`),
+		"crs/.zattrs":  []byte(`{"crs_wkt": "GEOGCS[\"WGS 84\"]"}`),
+		"temp/.zarray": []byte(`{"zarr_format": 2, "shape": [2, 3, 4], "chunks": [1, 3, 2], "dtype": "<f4", "compressor": {"id": "blosc", "cname": "lz4"}, "fill_value": -999, "filters": null}`),
+		"temp/.zattrs": []byte(`{"_ARRAY_DIMENSIONS": ["time", "lat", "lon"], "grid_mapping": "crs"}`),
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := Open(Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 2 || len(h.Groups) != 1 || h.Groups[0].Path != "/" || len(h.Arrays) != 5 {
+		t.Fatalf("unexpected hierarchy: %+v", h)
+	}
+	temp := h.Array("/temp")
+	if temp == nil || !reflect.DeepEqual(temp.Chunks, []int{1, 3, 2}) || temp.DataType != "float32" || temp.Compressor != "blosc" || *temp.FillValue != -999 {
+		t.Fatalf("unexpected array: %+v", temp)
+	}
+
+	lat, err := h.Array("/lat").Float64s()
+	if err != nil || !reflect.DeepEqual(lat, []float64{-10, -11, -12}) {
+		t.Fatalf("unexpected lat: %v, %v", lat, err)
+	}
+
+	vars, err := h.Variables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 1 {
+		t.Fatalf("expected 1 variable, got %d", len(vars))
+	}
+	v := vars[0]
+	if v.Path != "/temp" || !v.Geographic || v.CRS != `GEOGCS["WGS 84"]` || v.Time == nil || len(v.Axes) != 1 {
+		t.Fatalf("unexpected variable: %+v", v)
+	}
+	if !reflect.DeepEqual(v.Time.Values, []float64{0, 31}) {
+		t.Errorf("unexpected time values: %v", v.Time.Values)
+	}
+	geot, err := GeoTransform(v.X.Values, v.Y.Values)
+	if err != nil || !reflect.DeepEqual(geot, []float64{29.75, 0.5, 0, -9.5, 0, -1}) {
+		t.Errorf("unexpected geotransform: %v, %v", geot, err)
+	}
+}
+
+func TestOpenV3(t *testing.T) {
+	s := mapStore{
+		"zarr.json": []byte(`{
+			"zarr_format": 3, "node_type": "group", "attributes": {},
+			"consolidated_metadata": {"kind": "inline", "metadata": {
+				"x": {"zarr_format": 3, "node_type": "array", "shape": [3], "data_type": "float64",
+					"chunk_grid": {"name": "regular", "configuration": {"chunk_shape": [3]}},
+					"chunk_key_encoding": {"name": "default", "configuration": {"separator": "/"}},
+					"codecs": [{"name": "bytes", "configuration": {"endian": "little"}}, {"name": "gzip", "configuration": {"level": 5}}],
+					"fill_value": "NaN", "dimension_names": ["x"], "attributes": {"standard_name": "projection_x_coordinate"}},
+				"y": {"zarr_format": 3, "node_type": "array", "shape": [2], "data_type": "float64",
+					"chunk_grid": {"name": "regular", "configuration": {"chunk_shape": [1]}},
+					"chunk_key_encoding": {"name": "v2", "configuration": {"separator": "."}},
+					"codecs": [{"name": "bytes", "configuration": {"endian": "little"}}],
+					"fill_value": 0, "dimension_names": ["y"], "attributes": {"scale_factor": 10.0}},
+				"ndvi": {"zarr_format": 3, "node_type": "array", "shape": [4, 2, 3], "data_type": "int16",
+					"chunk_grid": {"name": "regular", "configuration": {"chunk_shape": [4, 2, 3]}},
+					"chunk_key_encoding": {"name": "default"},
+					"codecs": [{"name": "bytes", "configuration": {"endian": "little"}}, {"name": "zstd"}],
+					"fill_value": -1, "dimension_names": ["band", "y", "x"], "attributes": {}}
+			}}
+		}`),
+		"x/c/0": compress(t, "gzip", float64Bytes(100, 200, 300)),
+		"y/0":   float64Bytes(50),
+	}
+
+	h, err := Open(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ndvi := h.Array("/ndvi")
+	if h.Version != 3 || ndvi == nil || ndvi.Compressor != "zstd" || !reflect.DeepEqual(ndvi.Dimensions, []string{"band", "y", "x"}) {
+		t.Fatalf("unexpected hierarchy: %+v", h)
+	}
+	if _, err := ndvi.Float64s(); err == nil {
+		t.Errorf("expected an error reading a 3-D array")
+	}
+
+	vars, err := h.Variables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 1 {
+		t.Fatalf("expected 1 variable, got %d", len(vars))
+	}
+	v := vars[0]
+	if v.Geographic || v.Time != nil || len(v.Axes) != 1 || !reflect.DeepEqual(v.Axes[0].Values, []float64{0, 1, 2, 3}) {
+		t.Errorf("unexpected variable: %+v", v)
+	}
+	// The missing chunk of y is of the fill value.
+	if !reflect.DeepEqual(v.X.Values, []float64{100, 200, 300}) || !reflect.DeepEqual(v.Y.Values, []float64{500, 0}) {
+		t.Errorf("unexpected coordinates: %v, %v", v.X.Values, v.Y.Values)
+	}
+}
+
+func TestDecodeBlosc(t *testing.T) {
+	// An LZ4 block of the literals abcd, a match of 8 bytes at 4 back and
+	// the literals efghi.
+	lz4 := []byte{0x44, 'a', 'b', 'c', 'd', 4, 0, 0x50, 'e', 'f', 'g', 'h', 'i'}
+	block, err := decodeLZ4(lz4, 17)
+	if err != nil || string(block) != "abcdabcdabcdefghi" {
+		t.Fatalf("unexpected lz4 block: %q, %v", block, err)
+	}
+
+	values := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	shuffled := []byte{1, 3, 5, 7, 2, 4, 6, 8}
+	header := func(flags byte, typeSize, nbytes, blockSize int) []byte {
+		h := make([]byte, bloscHeaderSize)
+		h[0], h[1], h[2], h[3] = 2, 1, flags, byte(typeSize)
+		binary.LittleEndian.PutUint32(h[4:], uint32(nbytes))
+		binary.LittleEndian.PutUint32(h[8:], uint32(blockSize))
+		return h
+	}
+
+	memcpyed := append(header(bloscMemcpyed, 2, 8, 8), values...)
+	if out, err := decodeBlosc(memcpyed); err != nil || !bytes.Equal(out, values) {
+		t.Errorf("unexpected memcpyed buffer: %v, %v", out, err)
+	}
+
+	// A shuffled block of 2 splits of 4 bytes, stored uncompressed.
+	buf := header(bloscLZ4<<5|bloscShuffle, 2, 8, 8)
+	buf = append(buf, 20, 0, 0, 0)
+	buf = append(buf, 4, 0, 0, 0)
+	buf = append(buf, shuffled[:4]...)
+	buf = append(buf, 4, 0, 0, 0)
+	buf = append(buf, shuffled[4:]...)
+	if out, err := decodeBlosc(buf); err != nil || !bytes.Equal(out, values) {
+		t.Errorf("unexpected shuffled buffer: %v, %v", out, err)
+	}
+
+	// A block not split, compressed with LZ4.
+	buf = header(bloscLZ4<<5|bloscNoSplit, 1, 17, 17)
+	buf = append(buf, 20, 0, 0, 0)
+	buf = append(buf, byte(len(lz4)), 0, 0, 0)
+	buf = append(buf, lz4...)
+	if out, err := decodeBlosc(buf); err != nil || string(out) != "abcdabcdabcdefghi" {
+		t.Errorf("unexpected lz4 buffer: %q, %v", out, err)
+	}
+}
+
+func TestTimes(t *testing.T) {
+	tests := []struct {
+		values   []float64
+		units    string
+		calendar string
+		expected []string
+	}{
+		{[]float64{0, 1.5}, "days since 2020-01-01", "", []string{"2020-01-01T00:00:00Z", "2020-01-02T12:00:00Z"}},
+		{[]float64{876576}, "hours since 1900-01-01T00:00:00Z", "standard", []string{"2000-01-01T00:00:00Z"}},
+		{[]float64{3600}, "seconds since 1970-01-01 00:00:00 +10:00", "gregorian", []string{"1969-12-31T15:00:00Z"}},
+		{[]float64{1, 13}, "months since 2000-01-15", "", []string{"2000-02-15T00:00:00Z", "2001-02-15T00:00:00Z"}},
+	}
+	for _, test := range tests {
+		times, err := Times(test.values, test.units, test.calendar)
+		if err != nil {
+			t.Errorf("%s: %v", test.units, err)
+			continue
+		}
+		var got []string
+		for _, ts := range times {
+			got = append(got, ts.Format(time.RFC3339))
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.units, test.expected, got)
+		}
+	}
+
+	if _, err := Times([]float64{0}, "days since 2000-01-01", "noleap"); err == nil {
+		t.Errorf("expected an error for the noleap calendar")
+	}
+	if _, err := Times([]float64{0}, "days", ""); err == nil {
+		t.Errorf("expected an error for units without reference")
+	}
+}
+
+func TestGeoTransform(t *testing.T) {
+	if _, err := GeoTransform([]float64{0, 1, 3}, []float64{0, 1}); err == nil {
+		t.Errorf("expected an error for irregular coordinates")
+	}
+	if _, err := GeoTransform([]float64{0}, []float64{0, 1}); err == nil {
+		t.Errorf("expected an error for a single coordinate")
+	}
+}